@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+func catCmd() *cobra.Command {
+	var skipVerify bool
+
+	cmd := &cobra.Command{
+		Use:   "cat <archive> <path>",
+		Short: "Print a file from a backup",
+		Long: `Print the content of one file of a backup to stdout, without extracting
+anything, e.g. to read or pipe an old version of a config.
+
+The archive is decrypted and, for an incremental backup, merged with its
+parents, so the file is the one a restore would write. The path is relative
+to the home directory, as files are archived; an absolute path under the
+home directory, as the shell expands ~/.zshrc, is accepted too. The path
+.dotpak-shell-snapshot.txt prints the shell snapshot of a backup made with
+--shell-snapshot.
+
+The archive may be an http(s) URL, as for restore.
+
+Examples:
+  dotpak cat backup.tar.gz.age .zshrc
+  dotpak cat backup.tar.gz ~/.config/nvim/init.lua | diff - ~/.config/nvim/init.lua
+  dotpak cat backup.tar.gz.age .dotpak-shell-snapshot.txt`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := getOutput()
+			if jsonOutput {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid, "--json is not supported; cat prints the file"))
+			}
+			home, err := osutils.HomeDir()
+			if err != nil {
+				return outputError(out, err)
+			}
+			name, err := restore.HomePath(args[1], home)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			archivePath, err := resolveArchive(cfg, args[0], out)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			// progress would end up in the file printed to stdout
+			opts := restore.ExportOptions{SkipIntegrityCheck: skipVerify}
+			result, err := restore.Cat(cfg, archivePath, name, cmd.OutOrStdout(), opts, events.Discard)
+			if err != nil {
+				return outputError(out, err)
+			}
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&skipVerify, "skip-integrity-check", false,
+		"Print even if the archive's integrity HMAC is missing or cannot be checked")
+
+	return cmd
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+// parseCategories splits the comma-separated --only value and checks each
+// name against the built-in and configured categories.
+func parseCategories(cfg *config.Config, only string) ([]string, error) {
+	if only == "" {
+		return nil, nil
+	}
+	var categories []string
+	for c := range strings.SplitSeq(only, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			categories = append(categories, c)
+		}
+	}
+	if err := restore.ValidateCategories(cfg, categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
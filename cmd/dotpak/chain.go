@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func chainCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "chain [archive]",
+		Short: "Show the archives an incremental backup needs",
+		Long: `Show the chain of archives a restore of a backup reads: the backup itself
+and, for an incremental backup, the parents it builds on, back to the full
+backup the chain starts from. Each archive is listed with its size, the
+files it holds, and how many of them a restore takes from it; the others
+are replaced by newer archives. An archive whose files a restore takes
+none of could be dropped by rebuilding the chain with a full backup.
+
+The files are counted from the catalogs in the metadata files, so nothing
+is decrypted. With --verbose, the files each archive contributes are
+listed. If no archive is specified, shows the chain of the latest backup.
+
+Examples:
+  dotpak chain                                  # Latest backup
+  dotpak chain dotfiles-20260115_120000.tar.gz.age
+  dotpak chain -v                               # List the files of each archive
+  dotpak chain --json | jq -r '.links[].archive'`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			var archivePath string
+			if len(args) > 0 {
+				archivePath = args[0]
+			} else {
+				archivePath = metadata.LatestBackup(cfg.Backup.BackupDir)
+				if archivePath == "" {
+					return outputError(out, fmt.Errorf("no backups found in %s", cfg.Backup.BackupDir))
+				}
+			}
+
+			result, err := metadata.DescribeChain(archivePath)
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			printChain(result, out)
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			return nil
+		},
+	}
+}
+
+// printChain prints the links of a chain, each under the one that builds
+// on it.
+func printChain(result *metadata.ChainResult, out *output.Output) {
+	if len(result.Links) == 0 {
+		return
+	}
+	if len(result.Links) == 1 {
+		out.Print("%s is a full backup (%s)\n\n", filepath.Base(result.Archive), osutils.FormatSize(result.ArchiveSize))
+	} else {
+		out.Print("Restoring %s needs %d archives (%s):\n\n", filepath.Base(result.Archive), len(result.Links),
+			osutils.FormatSize(result.ArchiveSize))
+	}
+
+	for i, link := range result.Links {
+		indent := ""
+		if i > 0 {
+			indent = strings.Repeat("   ", i-1) + "└─ "
+		}
+		name := filepath.Base(link.Archive)
+		if link.Missing {
+			out.Print("%s%s  missing\n", indent, name)
+			continue
+		}
+		kind := "incremental"
+		if link.Full {
+			kind = "full"
+		}
+		out.Info("%s%s", indent, name)
+		out.Print("  %s, %s\n", kind, osutils.FormatSize(link.ArchiveSize))
+
+		pad := strings.Repeat("   ", i)
+		switch {
+		case !result.Success:
+			// the files of a broken chain cannot be attributed
+		case link.NoCatalog:
+			out.Print("%s   files unknown (no catalog in the metadata)\n", pad)
+		case len(link.Contributed) == link.Files:
+			out.Print("%s   %d files, all restored (%s)\n", pad, link.Files, osutils.FormatSize(link.ContributedSize))
+		default:
+			out.Print("%s   %d files, %d restored (%s), %d replaced by newer archives\n", pad, link.Files,
+				len(link.Contributed), osutils.FormatSize(link.ContributedSize), link.Files-len(link.Contributed))
+		}
+		for _, path := range link.Contributed {
+			out.Verbose("%s     %s\n", pad, path)
+		}
+	}
+	if result.Files > 0 {
+		out.Print("\nRestores %d files (%s)\n", result.Files, osutils.FormatSize(result.Size))
+	}
+}
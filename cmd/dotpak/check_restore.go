@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+func checkRestoreCmd() *cobra.Command {
+	var (
+		only      string
+		files     []string
+		noRewrite bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check-restore [archive]",
+		Short: "Check whether a restore would change anything",
+		Long: `Compare an archive with the live home directory without writing anything.
+
+Every file, directory, and symlink in the archive is checked for existence,
+type, content (SHA-256), permissions, and symlink target. The command exits
+non-zero if a restore would change anything, so it can be used in CI to check
+that a machine conforms to its backup.
+
+If no archive is specified, checks the latest backup. The archive may be an
+http(s) URL, as for restore.
+
+Examples:
+  dotpak check-restore                      # Latest backup
+  dotpak check-restore backup.tar.gz.age    # Specific archive
+  dotpak check-restore --only shell,git     # Specific categories
+  dotpak check-restore --files '.config/nvim/**'  # Specific files
+  dotpak check-restore --json               # Machine-readable report`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := getOutput()
+
+			if err := restore.ValidateFilePatterns(files); err != nil {
+				return outputError(out, err)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			categories, err := parseCategories(cfg, only)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			var archivePath string
+			if len(args) > 0 {
+				archivePath, err = resolveArchive(cfg, args[0], out)
+				if err != nil {
+					return outputError(out, err)
+				}
+			} else {
+				archivePath = metadata.LatestBackup(cfg.Backup.BackupDir)
+				if archivePath == "" {
+					return outputError(out, fmt.Errorf("no backups found in %s", cfg.Backup.BackupDir))
+				}
+				out.Print("Using latest backup: %s\n", filepath.Base(archivePath))
+			}
+
+			r := restore.New(cfg, &restore.Options{Categories: categories, Files: files, NoRewrite: noRewrite}, output.NewTextSink(out))
+			result, err := r.Check(archivePath)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+
+			printCheckRestoreResult(result, out)
+
+			if !result.NoOp {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("restore would change %d of %d entries", len(result.Differences), result.Checked)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&only, "only", "", "Categories to check (comma-separated)")
+	cmd.Flags().StringSliceVar(&files, "files", nil, "Check only files matching these globs (repeatable)")
+	cmd.Flags().BoolVar(&noRewrite, "no-rewrite", false,
+		"Compare config files as archived, without the replacements a restore makes")
+
+	return cmd
+}
+
+func printCheckRestoreResult(result *metadata.CheckRestoreResult, out *output.Output) {
+	if result.NoOp {
+		out.Success("Restore would be a no-op (%d entries checked)\n", result.Checked)
+		return
+	}
+
+	out.Print("\nRestore would change %d of %d entries:\n", len(result.Differences), result.Checked)
+	for _, d := range result.Differences {
+		switch d.Kind {
+		case restore.DiffMode, restore.DiffSymlink, restore.DiffType:
+			out.Print("  %-8s %s (%s -> %s)\n", d.Kind, d.Path, d.Actual, d.Expected)
+		default:
+			out.Print("  %-8s %s\n", d.Kind, d.Path)
+		}
+	}
+}
+
+func printRestoreReport(report *metadata.RestoreReport, out *output.Output) {
+	if len(report.Changes) == 0 {
+		out.Success("Restore would be a no-op (%d entries checked)\n", report.Checked)
+	} else {
+		out.Print("\nRestore would change %d of %d entries:\n", len(report.Changes), report.Checked)
+		for _, d := range report.Changes {
+			out.Print("  %-8s %s\n", d.Kind, d.Path)
+		}
+	}
+
+	if len(report.MissingDirs) > 0 {
+		out.Print("\nDirectories restore would create:\n")
+		for _, dir := range report.MissingDirs {
+			out.Print("  %s\n", dir)
+		}
+	}
+	if len(report.MissingApps) > 0 {
+		out.Print("\nConfigs restored for programs that are not installed:\n")
+		for _, app := range report.MissingApps {
+			out.Print("  %-10s %s\n", app.App, strings.Join(app.Paths, ", "))
+		}
+	}
+	if len(report.PermissionIssues) > 0 {
+		out.Print("\nPaths restore cannot write:\n")
+		for _, issue := range report.PermissionIssues {
+			out.Print("  %s: %s\n", issue.Path, issue.Problem)
+		}
+	}
+
+	if len(report.MissingApps) == 0 && len(report.PermissionIssues) == 0 {
+		out.Success("No prerequisites missing\n")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/config"
+)
+
+func configGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print a config value",
+		Long: `Print the effective value of a config key, after includes, conf.d
+fragments, and host overrides are applied. Keys use dotted TOML paths;
+list values are printed one per line.
+
+Examples:
+  dotpak config get backup.backup_dir
+  dotpak config get items
+  dotpak config get profile.work.extra_items`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			key := args[0]
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			value, err := config.GetValue(cfg, key)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(map[string]any{"success": true, "key": key, "value": value})
+				return nil
+			}
+			if list, ok := value.([]string); ok {
+				for _, v := range list {
+					out.Print("%s\n", v)
+				}
+				return nil
+			}
+			out.Print("%v\n", value)
+			return nil
+		},
+	}
+}
+
+func configSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a config value, preserving comments",
+		Long: `Set a value in the config file. Only the changed line is rewritten, so
+comments and formatting are preserved. Keys use dotted TOML paths; list
+values are comma-separated.
+
+Examples:
+  dotpak config set backup.max_backups 30
+  dotpak config set backup.encryption age
+  dotpak config set profile.work.extra_items .config/work,.ssh/config`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			key, raw := args[0], args[1]
+
+			literal, err := config.FormatValue(key, raw)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			cfgPath, err := editConfigFile(func(data []byte) ([]byte, error) {
+				return config.SetValue(data, key, literal)
+			})
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(map[string]any{"success": true, "config": cfgPath, "key": key, "value": literal})
+				return nil
+			}
+			out.Success("Set %s = %s in %s\n", key, literal, cfgPath)
+			return nil
+		},
+	}
+}
+
+func configAddItemCmd() *cobra.Command {
+	var (
+		sensitive bool
+		profile   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "add-item <path>",
+		Short: "Add a path to the backup items, preserving comments",
+		Long: `Append a path to items (or sensitive with --sensitive) in the config file.
+The list keeps its layout and comments. Paths are relative to the home directory.
+
+Examples:
+  dotpak config add-item .config/foo
+  dotpak config add-item .kube/config --sensitive
+  dotpak config add-item .config/work --profile work`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			item := args[0]
+
+			key := "items"
+			if sensitive {
+				key = "sensitive"
+			}
+			if profile != "" {
+				key = "profile." + profile + ".extra_" + key
+			}
+
+			added := false
+			cfgPath, err := editConfigFile(func(data []byte) ([]byte, error) {
+				var editErr error
+				data, added, editErr = config.AppendToList(data, key, item)
+				return data, editErr
+			})
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(map[string]any{"success": true, "config": cfgPath, "key": key, "item": item, "added": added})
+				return nil
+			}
+			if !added {
+				out.Print("%s is already in %s\n", item, key)
+				return nil
+			}
+			out.Success("Added %s to %s in %s\n", item, key, cfgPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&sensitive, "sensitive", false, "Add to sensitive items (backed up only with encryption)")
+	cmd.Flags().StringVarP(&profile, "profile", "p", "", "Add to a profile's extra items")
+
+	return cmd
+}
+
+// editConfigFile applies edit to the config file and keeps the result only
+// if the edited config still loads and, when the original config was valid,
+// still validates. It returns the file path.
+func editConfigFile(edit func([]byte) ([]byte, error)) (string, error) {
+	cfgPath := configFile
+	if cfgPath == "" {
+		cfgPath = config.DefaultConfigPath()
+	}
+	if cfgPath == "" {
+		return "", errors.New("cannot determine config path")
+	}
+
+	info, err := os.Stat(cfgPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("config file not found: %s (run 'dotpak config init' first)", cfgPath)
+		}
+		return "", fmt.Errorf("reading config: %w", err)
+	}
+
+	original, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return "", fmt.Errorf("reading config: %w", err)
+	}
+	edited, err := edit(original)
+	if err != nil {
+		return "", err
+	}
+
+	wasValid := false
+	if cfg, loadErr := config.Load(cfgPath); loadErr == nil {
+		wasValid = validateConfig(cfg) == nil
+	}
+
+	if err = os.WriteFile(cfgPath, edited, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("writing config: %w", err)
+	}
+
+	cfg, err := config.Load(cfgPath)
+	if err == nil && wasValid {
+		err = validateConfig(cfg)
+	}
+	if err != nil {
+		if restoreErr := os.WriteFile(cfgPath, original, info.Mode().Perm()); restoreErr != nil {
+			return "", fmt.Errorf("%w (restoring original config also failed: %w)", err, restoreErr)
+		}
+		return "", fmt.Errorf("change not saved: %w", err)
+	}
+	return cfgPath, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+func configTestPatternCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "test-pattern <path>",
+		Short: "Show whether a path is excluded from backups, and by which pattern",
+		Long: `Apply the [excludes] patterns and the .dotpakignore files of the parent
+directories to a path, as a backup does, and print whether it is excluded and
+which rule decided. The path is relative to the home directory, or absolute
+or ~/ inside it; a trailing "/" tests a directory that does not exist.
+
+Patterns use gitignore syntax: "*.log" matches names at any depth, a pattern
+with a "/" before its end ("/test", ".config/nvim/undo") matches a path
+relative to home, "**" matches any number of directories, a trailing "/"
+matches directories only, and "!pattern" keeps what an earlier pattern
+excluded. The last pattern that matches decides; everything inside an
+excluded directory is excluded.
+
+Examples:
+  dotpak config test-pattern .config/nvim/lazy-lock.json
+  dotpak config test-pattern ~/.cache/
+  dotpak config test-pattern projects/test/a.txt --json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			home, err := osutils.HomeDir()
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			relPath, isDir, err := homeRelPath(home, args[0])
+			if err != nil {
+				return outputError(out, err)
+			}
+			match := backup.ExplainExclude(cfg, home, relPath, isDir)
+
+			if jsonOutput {
+				return out.JSON(match)
+			}
+			state := "included"
+			if match.Excluded {
+				state = "excluded"
+			}
+			switch {
+			case match.Pattern == "":
+				out.Print("%s is %s: no pattern matches it\n", match.Path, state)
+			case match.Dir != "":
+				out.Print("%s is %s: it is inside %s, which %q in %s matches\n",
+					match.Path, state, match.Dir, match.Pattern, match.Source)
+			default:
+				out.Print("%s is %s by %q in %s\n", match.Path, state, match.Pattern, match.Source)
+			}
+			return nil
+		},
+	}
+}
+
+// homeRelPath returns arg relative to home, and whether it is a directory:
+// an existing one, or arg ends in a slash.
+func homeRelPath(home, arg string) (string, bool, error) {
+	isDir := strings.HasSuffix(arg, "/") || strings.HasSuffix(arg, string(filepath.Separator))
+	path := arg
+	if rest, ok := strings.CutPrefix(arg, "~/"); ok {
+		path = filepath.Join(home, rest)
+	}
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(home, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false, errs.Errorf(errs.ErrConfigInvalid, "%s is not inside the home directory %s", arg, home)
+		}
+		path = rel
+	}
+	path = filepath.Clean(path)
+	if path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", false, errs.Errorf(errs.ErrConfigInvalid, "%s is not a path inside the home directory", arg)
+	}
+	if info, err := os.Lstat(filepath.Join(home, path)); err == nil {
+		isDir = info.IsDir()
+	}
+	return path, isDir, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+func envCmd() *cobra.Command {
+	var profile string
+
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Print the paths dotpak uses as shell variables",
+		Long: `Print the config file, home, backup, log, and temporary directories, and the
+newest archive, as dotpak resolves them (with --config, --home, --profile,
+and the config file applied), so wrapper scripts need not resolve them again.
+
+The output is a list of export statements for sh, bash, and zsh, or JSON with
+--json. DOTPAK_LATEST_ARCHIVE is empty if there are no backups.
+
+Examples:
+  eval "$(dotpak env)" && ls "$DOTPAK_BACKUP_DIR"
+  dotpak env --profile work --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig(profile)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			cfgPath := configFile
+			if cfgPath == "" {
+				cfgPath = config.DefaultConfigPath()
+			}
+			result, err := dotpakEnv(cfg, cfgPath)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			return writeEnv(cmd.OutOrStdout(), result)
+		},
+	}
+
+	cmd.Flags().StringVarP(&profile, "profile", "p", "", "Print the paths of a profile with its own backup_dir")
+
+	return cmd
+}
+
+// dotpakEnv resolves the paths of cfg, loaded from cfgPath.
+func dotpakEnv(cfg *config.Config, cfgPath string) (*metadata.EnvResult, error) {
+	home, err := osutils.HomeDir()
+	if err != nil {
+		return nil, err
+	}
+	logPath, err := cronLogPath()
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := osutils.TempDir()
+	if err != nil {
+		return nil, err
+	}
+	if abs, absErr := filepath.Abs(cfgPath); absErr == nil {
+		cfgPath = abs
+	}
+	return &metadata.EnvResult{
+		Success:       true,
+		Config:        cfgPath,
+		Home:          home,
+		BackupDir:     cfg.Backup.BackupDir,
+		LogDir:        filepath.Dir(logPath),
+		TempDir:       tempDir,
+		LatestArchive: metadata.LatestBackup(cfg.Backup.BackupDir),
+	}, nil
+}
+
+// writeEnv writes result to w as export statements. The output is meant
+// for eval, so the values are always quoted.
+func writeEnv(w io.Writer, result *metadata.EnvResult) error {
+	for _, v := range []struct{ name, value string }{
+		{"DOTPAK_CONFIG", result.Config},
+		{"DOTPAK_HOME", result.Home},
+		{"DOTPAK_BACKUP_DIR", result.BackupDir},
+		{"DOTPAK_LOG_DIR", result.LogDir},
+		{"DOTPAK_TEMP_DIR", result.TempDir},
+		{"DOTPAK_LATEST_ARCHIVE", result.LatestArchive},
+	} {
+		if _, err := fmt.Fprintf(w, "export %s='%s'\n", v.name, strings.ReplaceAll(v.value, "'", `'"'"'`)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
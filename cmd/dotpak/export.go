@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+func exportArchiveCmd() *cobra.Command {
+	var (
+		to         string
+		format     string
+		files      []string
+		skipVerify bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export-archive <archive>",
+		Short: "Export a backup as a plain tar or zip file",
+		Long: `Write the files of a backup to a plain, uncompressed tar file or a zip file
+that can be opened without dotpak, e.g. to hand them to someone else.
+
+The archive is decrypted and, for an incremental backup, merged with its
+parents, without extracting anything onto the filesystem. File names, modes,
+modification times, and symlinks are kept; owner names and ids, extended
+attributes, and dotpak metadata are not. The format follows the extension of
+--to (.zip for zip, tar otherwise) unless --format is given.
+
+The archive may be an http(s) URL, as for restore.
+
+Examples:
+  dotpak export-archive backup.tar.gz.age --to dotfiles.tar
+  dotpak export-archive backup.tar.gz --to nvim.zip --files '.config/nvim/**'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			if to == "" {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid, "--to is required"))
+			}
+			if err := restore.ValidateFilePatterns(files); err != nil {
+				return outputError(out, err)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			archivePath, err := resolveArchive(cfg, args[0], out)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			opts := restore.ExportOptions{Format: format, Files: files, SkipIntegrityCheck: skipVerify}
+			result, err := restore.Export(cfg, archivePath, to, opts, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&to, "to", "", "File to write (required)")
+	cmd.Flags().StringVar(&format, "format", "", "Output format: tar or zip (default: from the --to extension)")
+	cmd.Flags().StringSliceVar(&files, "files", nil, "Export only files matching these globs (repeatable)")
+	cmd.Flags().BoolVar(&skipVerify, "skip-integrity-check", false,
+		"Export even if the archive's integrity HMAC is missing or cannot be checked")
+
+	return cmd
+}
+
+func exportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export backups to other tools",
+	}
+	cmd.AddCommand(exportGitCmd())
+	return cmd
+}
+
+func exportGitCmd() *cobra.Command {
+	var (
+		files            []string
+		includeSensitive bool
+		skipVerify       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "git <dir> [archive]",
+		Short: "Commit the files of a backup to a git repository",
+		Long: `Write the files of a backup (the latest one by default) into the git working
+tree <dir> and commit them, so dotpak collects and encrypts dotfiles while
+git keeps their history for log, diff, and blame.
+
+<dir> is created and initialized if needed. Each export replaces the files
+of the previous one: files no longer backed up are deleted, and nothing is
+committed if no file changed. Commits are dated at the backup, so exporting
+older backups first rebuilds their history. Files added to <dir> by hand
+are kept and committed along with the export.
+
+The repository is not encrypted, so files under sensitive paths (.ssh,
+.gnupg, .aws, ...) are left out unless --include-sensitive is given.
+
+Examples:
+  dotpak export git ~/dotfiles-history
+  dotpak export git ~/dotfiles-history backup.tar.gz.age --files '.config/nvim/**'`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			if err := restore.ValidateFilePatterns(files); err != nil {
+				return outputError(out, err)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			var archivePath string
+			if len(args) > 1 {
+				if archivePath, err = resolveArchive(cfg, args[1], out); err != nil {
+					return outputError(out, err)
+				}
+			} else {
+				archivePath = metadata.LatestBackup(cfg.Backup.BackupDir)
+				if archivePath == "" {
+					return outputError(out, fmt.Errorf("no backups found in %s", cfg.Backup.BackupDir))
+				}
+				out.Print("Using latest backup: %s\n", filepath.Base(archivePath))
+			}
+
+			opts := restore.ExportOptions{Files: files, SkipIntegrityCheck: skipVerify, IncludeSensitive: includeSensitive}
+			result, err := restore.ExportToGit(cfg, archivePath, args[0], opts, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&files, "files", nil, "Export only files matching these globs (repeatable)")
+	cmd.Flags().BoolVar(&includeSensitive, "include-sensitive", false,
+		"Also export files under sensitive paths such as .ssh and .gnupg")
+	cmd.Flags().BoolVar(&skipVerify, "skip-integrity-check", false,
+		"Export even if the archive's integrity HMAC is missing or cannot be checked")
+
+	return cmd
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+// defaultStaleDays is how long a host may go without a backup before
+// fleet status reports it as stale.
+const defaultStaleDays = 7
+
+// fleetSizeHistory is the number of full backups per host whose sizes fleet
+// status reports.
+const fleetSizeHistory = 5
+
+func fleetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fleet",
+		Short: "Inspect backups of several machines sharing a backup location",
+	}
+	cmd.AddCommand(fleetStatusCmd())
+	return cmd
+}
+
+func fleetStatusCmd() *cobra.Command {
+	var (
+		fromRemote bool
+		staleDays  int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Summarize the last backup of each host",
+		Long: `Summarize the backups in a backup location shared by several machines: per
+host, the last backup and its age, the number of backups, and the size of the
+recent full backups. Hosts with no backup in --stale-days days are marked stale.
+
+Hosts are told apart by the hostname recorded in each backup's metadata, with
+[host] aliases from the config mapped to their host name. Backups without
+metadata are listed under "unknown". With --remote, the metadata files are
+downloaded into the download cache.
+
+Examples:
+  dotpak fleet status                     # Hosts in the local backup directory
+  dotpak fleet status --remote --json     # Hosts on the configured remote`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			if staleDays < 1 {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid, "--stale-days must be at least 1"))
+			}
+
+			location := cfg.Backup.BackupDir
+			var backups []metadata.BackupInfo
+			if fromRemote {
+				location = cfg.Remote.URL
+				backups, err = listRemoteBackups(cfg)
+				if err == nil {
+					err = describeRemoteBackups(cfg, backups)
+				}
+			} else {
+				backups, err = metadata.ListBackups(location)
+				if errors.Is(err, os.ErrNotExist) {
+					err = nil
+				} else if err != nil {
+					err = fmt.Errorf("reading backup directory: %w", err)
+				}
+			}
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result := fleetStatus(cfg, backups, staleDays, time.Now())
+			result.Location = location
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			printFleetStatus(result, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&fromRemote, "remote", false, "Summarize backups on the configured remote")
+	cmd.Flags().IntVar(&staleDays, "stale-days", defaultStaleDays, "Mark hosts with no backup in this many days as stale")
+
+	return cmd
+}
+
+// fleetStatus groups backups, newest first, by host as of now. Hosts are
+// ordered by name.
+func fleetStatus(cfg *config.Config, backups []metadata.BackupInfo, staleDays int, now time.Time) *metadata.FleetResult {
+	result := &metadata.FleetResult{Success: true, StaleDays: staleDays, Hosts: []metadata.FleetHost{}}
+
+	index := make(map[string]int)
+	for _, b := range backups {
+		name := "unknown"
+		if b.Hostname != "" {
+			name = cfg.HostName(b.Hostname)
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(result.Hosts)
+			index[name] = i
+			host := metadata.FleetHost{
+				Hostname:        name,
+				LastBackup:      b.Archive,
+				Timestamp:       b.Timestamp,
+				DaysSinceBackup: -1,
+				Sizes:           []int64{},
+			}
+			if created, err := b.CreatedAt(); err == nil {
+				host.DaysSinceBackup = max(int(now.Sub(created).Hours()/24), 0)
+			}
+			host.Stale = host.DaysSinceBackup < 0 || host.DaysSinceBackup >= staleDays
+			result.Hosts = append(result.Hosts, host)
+		}
+		host := &result.Hosts[i]
+		host.BackupCount++
+		if b.Parent == "" && len(host.Sizes) < fleetSizeHistory {
+			host.Sizes = append(host.Sizes, b.Size)
+		}
+	}
+
+	for i := range result.Hosts {
+		host := &result.Hosts[i]
+		slices.Reverse(host.Sizes)
+		if n := len(host.Sizes); n > 1 {
+			host.SizeChange = host.Sizes[n-1] - host.Sizes[n-2]
+		}
+		if host.Stale {
+			result.StaleHosts++
+		}
+	}
+	slices.SortFunc(result.Hosts, func(a, b metadata.FleetHost) int {
+		return strings.Compare(a.Hostname, b.Hostname)
+	})
+	return result
+}
+
+func printFleetStatus(result *metadata.FleetResult, out *output.Output) {
+	if len(result.Hosts) == 0 {
+		out.Warning("No backups found in %s\n", result.Location)
+		return
+	}
+
+	out.Print("  %-20s %-20s %8s %8s %10s %10s\n", "host", "last backup", "age", "backups", "size", "change")
+	for _, host := range result.Hosts {
+		age := "?"
+		if host.DaysSinceBackup >= 0 {
+			age = fmt.Sprintf("%dd", host.DaysSinceBackup)
+		}
+		size, change := "-", "-"
+		if n := len(host.Sizes); n > 0 {
+			size = formatSize(host.Sizes[n-1])
+		}
+		switch {
+		case len(host.Sizes) < 2:
+		case host.SizeChange >= 0:
+			change = "+" + formatSize(host.SizeChange)
+		default:
+			change = "-" + formatSize(-host.SizeChange)
+		}
+		stale := ""
+		if host.Stale {
+			stale = "  stale"
+		}
+		out.Print("  %-20s %-20s %8s %8d %10s %10s%s\n", host.Hostname, host.Timestamp, age,
+			host.BackupCount, size, change, stale)
+	}
+
+	out.Print("\n%d hosts in %s", len(result.Hosts), result.Location)
+	if result.StaleHosts > 0 {
+		out.Print(", %d with no backup in %d days", result.StaleHosts, result.StaleDays)
+	}
+	out.Print("\n")
+}
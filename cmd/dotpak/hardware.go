@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+func hardwareKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hardware-key",
+		Short: "Seal the backup encryption key to this device",
+		Long: `Keep the age key that decrypts backups in the TPM (Linux, Windows) or the
+Secure Enclave (macOS), so archives can only be decrypted on this device.
+Keys are handled by the age plugins age-plugin-tpm and age-plugin-se, which
+must be installed along with age.
+
+Archives are also encrypted to a recovery recipient, whose identity is
+kept off the device and restores backups anywhere else.`,
+	}
+	cmd.AddCommand(hardwareKeyInitCmd())
+	return cmd
+}
+
+func hardwareKeyInitCmd() *cobra.Command {
+	var (
+		kind              string
+		recoveryRecipient string
+		recoveryKey       string
+		force             bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create a hardware key and a recovery key and use them for backups",
+		Long: `Create an age identity sealed to this device's TPM or Secure Enclave and a
+recovery identity, write both recipients to
+~/.config/dotpak/age/hardware-recipients.txt, and set the config to encrypt
+backups with age to them and to decrypt with the hardware identity.
+Recipients of the backup.age_recipients file configured before are kept in
+the new file, so their keys still decrypt new backups. With --force, the keys
+replaced are kept as <file>.old and added to backup.age_identity_files, so
+archives encrypted to them still decrypt.
+
+The recovery identity is written to --recovery-key. Move it off this machine
+(a password manager or offline storage): while it stays here, backups are not
+limited to the device. Pass --recovery-recipient to reuse an existing
+recovery key instead.
+
+Examples:
+  dotpak hardware-key init
+  dotpak hardware-key init --kind secure-enclave
+  dotpak hardware-key init --recovery-recipient "$(cat recovery.pub)"`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+
+			if kind == "" {
+				kind = crypto.DefaultHardwareKind()
+			}
+			if kind == "" {
+				return outputError(out, errs.Errorf(errs.ErrEncryptionUnavailable,
+					"no supported hardware key on this platform"))
+			}
+			if err := crypto.CheckHardware(kind); err != nil {
+				return outputError(out, err)
+			}
+			if recoveryRecipient != "" {
+				if _, err := crypto.ParseRecipient(recoveryRecipient); err != nil {
+					return outputError(out, errs.Errorf(errs.ErrConfigInvalid, "--recovery-recipient: %v", err))
+				}
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			home, err := osutils.HomeDir()
+			if err != nil {
+				return outputError(out, err)
+			}
+			dir := filepath.Join(home, ".config", "dotpak", "age")
+			identity := filepath.Join(dir, "hardware-"+kind+".txt")
+			recipients := filepath.Join(dir, "hardware-recipients.txt")
+			if recoveryKey == "" {
+				recoveryKey = filepath.Join(dir, "recovery-key.txt")
+			}
+
+			// existing keys may be all that decrypts earlier archives
+			for _, path := range []string{identity, recoveryKey} {
+				if path == recoveryKey && recoveryRecipient != "" {
+					continue
+				}
+				if fileExists(path) && !force {
+					return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+						"%s already exists; --force replaces it and keeps it as %s.old for earlier archives", path, path))
+				}
+			}
+			kept, err := keptRecipients(cfg.Backup.AgeRecipients, recipients)
+			if err != nil {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid, "reading backup.age_recipients: %v", err))
+			}
+			if err = os.MkdirAll(dir, 0700); err != nil {
+				return outputError(out, err)
+			}
+
+			hwRecipient, recoveryRecipient, retired, err := generateHardwareKeys(kind, identity, recoveryKey,
+				recoveryRecipient)
+			if err != nil {
+				return outputError(out, err)
+			}
+			kept = slices.DeleteFunc(kept, func(r string) bool { return r == hwRecipient || r == recoveryRecipient })
+
+			content := fmt.Sprintf("# %s key of this device: decrypts only here\n%s\n# recovery key\n%s\n",
+				kind, hwRecipient, recoveryRecipient)
+			if len(kept) > 0 {
+				content += keptRecipientsHeader + "\n" + strings.Join(kept, "\n") + "\n"
+			}
+			if err = os.WriteFile(recipients, []byte(content), 0600); err != nil {
+				return outputError(out, err)
+			}
+
+			cfgPath, err := editConfigFile(func(data []byte) ([]byte, error) {
+				return useHardwareKey(data, kind, recipients, append([]string{identity}, retired...))
+			})
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				return out.JSON(map[string]any{
+					"success":            true,
+					"config":             cfgPath,
+					"kind":               kind,
+					"identity":           identity,
+					"recipient":          hwRecipient,
+					"recovery_recipient": recoveryRecipient,
+					"recipients_file":    recipients,
+					"kept_recipients":    kept,
+					"retired_keys":       retired,
+				})
+			}
+			out.Success("Created %s key: %s\n", kind, identity)
+			out.Print("  Recipient: %s\n", hwRecipient)
+			out.Print("Recovery recipient: %s\n", recoveryRecipient)
+			if len(kept) > 0 {
+				out.Print("Kept %d recipient(s) of %s, whose keys still decrypt new backups\n",
+					len(kept), cfg.Backup.AgeRecipients)
+			}
+			for _, path := range retired {
+				out.Print("Kept the replaced key as %s, in backup.age_identity_files to decrypt earlier archives\n",
+					path)
+			}
+			if fileExists(recoveryKey) {
+				out.Warning("Move the recovery key %s off this machine; while it stays here, "+
+					"backups are not limited to this device.\n", recoveryKey)
+			}
+			out.Print("Updated %s to encrypt backups with age to %s\n", cfgPath, recipients)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&kind, "kind", "", "Hardware key: tpm|secure-enclave (default: this platform's)")
+	cmd.Flags().StringVar(&recoveryRecipient, "recovery-recipient", "",
+		"Encrypt to this existing recovery recipient instead of creating a recovery key")
+	cmd.Flags().StringVar(&recoveryKey, "recovery-key", "",
+		"Where to write the new recovery identity (default ~/.config/dotpak/age/recovery-key.txt)")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace existing hardware and recovery keys, keeping them as <file>.old")
+
+	return cmd
+}
+
+// keptRecipientsHeader starts the section of the hardware recipients file
+// that holds the recipients configured before the hardware key.
+const keptRecipientsHeader = "# recipients configured before the hardware key"
+
+// keptRecipients returns the recipients of the configured recipients file
+// that backups should stay encrypted to once recipients replaces it: all of
+// them, or, when recipients is already the configured file, those it kept
+// from before.
+func keptRecipients(configured, recipients string) ([]string, error) {
+	if configured == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(configured)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	own := filepath.Clean(configured) == filepath.Clean(recipients)
+	keep := !own
+	var kept []string
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			if own {
+				keep = line == keptRecipientsHeader
+			}
+		case keep && !slices.Contains(kept, line):
+			kept = append(kept, line)
+		}
+	}
+	return kept, nil
+}
+
+// generateHardwareKeys creates the hardware identity and, unless a recovery
+// recipient is given, the recovery identity, and returns their recipients
+// and where the keys they replace were moved. Both are generated next to
+// the files they replace, which are moved aside only once both exist, so a
+// failed generation leaves the old keys in place.
+func generateHardwareKeys(kind, identity, recoveryKey, recoveryRecipient string) (string, string, []string, error) {
+	newIdentity := identity + ".new"
+	newRecoveryKey := recoveryKey + ".new"
+	cleanup := func() {
+		_ = os.Remove(newIdentity)
+		_ = os.Remove(newRecoveryKey)
+	}
+	cleanup()
+
+	hwRecipient, err := crypto.GenerateHardwareIdentity(kind, newIdentity)
+	if err != nil {
+		cleanup()
+		return "", "", nil, err
+	}
+	renames := [][2]string{{newIdentity, identity}}
+	if recoveryRecipient == "" {
+		if recoveryRecipient, err = crypto.GenerateRecoveryIdentity(newRecoveryKey); err != nil {
+			cleanup()
+			return "", "", nil, err
+		}
+		renames = append(renames, [2]string{newRecoveryKey, recoveryKey})
+	}
+
+	var retired []string
+	for _, rename := range renames {
+		old := ""
+		if fileExists(rename[1]) {
+			old = retiredKeyPath(rename[1])
+			if err = os.Rename(rename[1], old); err != nil {
+				cleanup()
+				return "", "", retired, err
+			}
+			retired = append(retired, old)
+		}
+		if err = os.Rename(rename[0], rename[1]); err != nil {
+			if old != "" && os.Rename(old, rename[1]) == nil {
+				retired = retired[:len(retired)-1]
+			}
+			cleanup()
+			return "", "", retired, err
+		}
+	}
+	return hwRecipient, recoveryRecipient, retired, nil
+}
+
+// retiredKeyPath returns where to keep the key at path when it is replaced:
+// path.old, or path.old.2 and so on if keys were replaced before.
+func retiredKeyPath(path string) string {
+	old := path + ".old"
+	for n := 2; fileExists(old); n++ {
+		old = fmt.Sprintf("%s.old.%d", path, n)
+	}
+	return old
+}
+
+// useHardwareKey edits config data to encrypt with age to the recipients
+// file and decrypt with identities: the hardware identity and the keys it
+// replaced.
+func useHardwareKey(data []byte, kind, recipients string, identities []string) ([]byte, error) {
+	var err error
+	for _, kv := range [][2]string{
+		{"backup.encryption", "age"},
+		{"backup.age_recipients", recipients},
+		{"backup.hardware_key", kind},
+	} {
+		if data, err = config.SetValue(data, kv[0], strconv.Quote(kv[1])); err != nil {
+			return nil, err
+		}
+	}
+	for _, identity := range identities {
+		if data, _, err = config.AppendToList(data, "backup.age_identity_files", identity); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func importSnapshotCmd() *cobra.Command {
+	var (
+		dryRun     bool
+		encrypt    string
+		noEncrypt  bool
+		noSecrets  bool
+		sourceHome string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import-snapshot <dir>",
+		Short: "Import a home directory from a Time Machine or rsnapshot snapshot as a backup",
+		Long: `Back up the configured dotfiles from a copy of a home directory in an existing
+snapshot, such as a Time Machine or rsnapshot backup, into the backup
+directory. The archive is dated at the newest file in the snapshot, so list,
+diff, restore, and prune treat it like any other backup.
+
+The home directory the snapshot was taken of is recorded so that restore
+rewrites it in config files. It is guessed from a directory ending in
+/Users/<name> or /home/<name>; pass --source-home otherwise.
+
+Package lists, shell snapshots, and hooks are not saved or run, and no
+backups are removed; imported archives count toward retention on the next
+backup like any other.
+
+Examples:
+  dotpak import-snapshot "/Volumes/TM/Backups.backupdb/mac/2024-01-15-143022/Macintosh HD - Data/Users/alice"
+  dotpak import-snapshot /snapshots/daily.0/localhost/home/alice
+  dotpak import-snapshot /mnt/old-disk/alice --source-home /home/alice`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			opts := &backup.Options{
+				DryRun:         dryRun,
+				IncludeSecrets: !noSecrets,
+				Version:        version,
+			}
+			if noEncrypt {
+				opts.EncryptionMethod = "none"
+			} else if encrypt != "" {
+				opts.EncryptionMethod = encrypt
+			}
+
+			result, err := backup.Import(cfg, args[0], sourceHome, opts, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the files that would be imported")
+	cmd.Flags().StringVar(&encrypt, "encrypt", "", "Encryption: age|gpg|aes")
+	cmd.Flags().BoolVar(&noEncrypt, "no-encrypt", false, "Disable encryption")
+	cmd.Flags().BoolVar(&noSecrets, "no-secrets", false, "Exclude sensitive files")
+	cmd.Flags().StringVar(&sourceHome, "source-home", "",
+		"Home directory the snapshot was taken of (default: guessed from the path)")
+
+	return cmd
+}
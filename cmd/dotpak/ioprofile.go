@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+// printIOProfile prints the per-phase measurements of a backup or restore.
+func printIOProfile(phases []metadata.IOPhase, out *output.Output) {
+	if len(phases) == 0 {
+		return
+	}
+	out.Print("\nIO profile:\n")
+	out.Print("  %-13s %10s %8s %10s %10s %12s\n", "phase", "time", "files", "read", "written", "read/s")
+	for _, p := range phases {
+		elapsed := time.Duration(p.DurationMS) * time.Millisecond
+		rate := "-"
+		if p.BytesRead > 0 && p.DurationMS > 0 {
+			rate = osutils.FormatSize(p.BytesRead*1000/p.DurationMS) + "/s"
+		}
+		out.Print("  %-13s %10s %8d %10s %10s %12s\n",
+			p.Phase, elapsed, p.Files, sizeOrDash(p.BytesRead), sizeOrDash(p.BytesWritten), rate)
+	}
+}
+
+func sizeOrDash(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return osutils.FormatSize(n)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+func lintPathsCmd() *cobra.Command {
+	var (
+		only  string
+		files []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "lint-paths [archive]",
+		Short: "Find absolute paths in backed-up configs that break on another machine",
+		Long: `Scan the text files of an archive for absolute paths that are likely to break
+when it is restored on a different machine or architecture:
+
+  home      the home directory of the backup, in files restore does not
+            rewrite (see [rewrite] in the config; paths restore replaces are
+            not reported)
+  user      another user's home directory (/Users/<name>, /home/<name>)
+  homebrew  a Homebrew prefix, which differs between Apple silicon
+            (/opt/homebrew), Intel Macs (/usr/local), and Linux
+  volume    a mounted volume (/Volumes, /mnt, /media)
+
+The command exits non-zero if it finds any. If no archive is specified,
+scans the latest backup.
+
+Examples:
+  dotpak lint-paths                      # Latest backup
+  dotpak lint-paths backup.tar.gz.age    # Specific archive
+  dotpak lint-paths --only shell,git     # Specific categories
+  dotpak lint-paths --json               # Machine-readable report`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := getOutput()
+
+			if err := restore.ValidateFilePatterns(files); err != nil {
+				return outputError(out, err)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			categories, err := parseCategories(cfg, only)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			var archivePath string
+			if len(args) > 0 {
+				archivePath, err = resolveArchive(cfg, args[0], out)
+				if err != nil {
+					return outputError(out, err)
+				}
+			} else {
+				archivePath = metadata.LatestBackup(cfg.Backup.BackupDir)
+				if archivePath == "" {
+					return outputError(out, fmt.Errorf("no backups found in %s", cfg.Backup.BackupDir))
+				}
+				out.Print("Using latest backup: %s\n", filepath.Base(archivePath))
+			}
+
+			r := restore.New(cfg, &restore.Options{Categories: categories, Files: files}, output.NewTextSink(out))
+			result, err := r.LintPaths(archivePath)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+
+			printLintPathsResult(result, out)
+
+			if len(result.Findings) > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("found %d machine-specific paths", len(result.Findings))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&only, "only", "", "Categories to scan (comma-separated)")
+	cmd.Flags().StringSliceVar(&files, "files", nil, "Scan only files matching these globs (repeatable)")
+
+	return cmd
+}
+
+func printLintPathsResult(result *metadata.LintPathsResult, out *output.Output) {
+	if result.Rewritten > 0 {
+		out.Print("Skipped %d paths that restore rewrites (see [rewrite] in the config)\n", result.Rewritten)
+	}
+	if len(result.Findings) == 0 {
+		out.Success("No machine-specific paths found (%d files scanned)\n", result.Checked)
+		return
+	}
+
+	out.Print("\nFound %d machine-specific paths in %d files scanned:\n", len(result.Findings), result.Checked)
+	for _, f := range result.Findings {
+		out.Print("  %s:%d: %s\n", f.Path, f.Line, f.Value)
+		out.Print("    %-8s %s\n", f.Kind, f.Hint)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// mergeBackups merges the backups in the backup directory with those on the
+// remote, newest first, marking the location of each. A backup in both is
+// listed once, with its local path and details.
+func mergeBackups(local, remote []metadata.BackupInfo) []metadata.BackupInfo {
+	merged := make([]metadata.BackupInfo, 0, len(local)+len(remote))
+	index := make(map[string]int)
+	for _, b := range local {
+		b.Location = metadata.LocationLocal
+		index[filepath.Base(b.Archive)] = len(merged)
+		merged = append(merged, b)
+	}
+	for _, b := range remote {
+		if i, ok := index[filepath.Base(b.Archive)]; ok {
+			merged[i].Location = metadata.LocationBoth
+			continue
+		}
+		b.Location = metadata.LocationRemote
+		merged = append(merged, b)
+	}
+	slices.SortStableFunc(merged, func(a, b metadata.BackupInfo) int {
+		return strings.Compare(b.Timestamp, a.Timestamp)
+	})
+	return merged
+}
+
+// backupFilter selects the backups list shows.
+type backupFilter struct {
+	host          string
+	since         time.Time
+	encryptedOnly bool
+}
+
+// apply returns the backups that match f. Hosts are compared after mapping
+// [host] aliases to their host name; backups without a recorded host or
+// time do not match --host or --since.
+func (f backupFilter) apply(cfg *config.Config, backups []metadata.BackupInfo) []metadata.BackupInfo {
+	return slices.DeleteFunc(backups, func(b metadata.BackupInfo) bool {
+		if f.encryptedOnly && !b.Encrypted {
+			return true
+		}
+		if f.host != "" && (b.Hostname == "" || cfg.HostName(b.Hostname) != cfg.HostName(f.host)) {
+			return true
+		}
+		if !f.since.IsZero() {
+			created, err := b.CreatedAt()
+			if err != nil || created.Before(f.since) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// parseSince parses the value of --since: a date (2006-01-02), or an age
+// such as 36h or 7d, taken back from now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, errs.Errorf(errs.ErrConfigInvalid,
+		"invalid --since %q (use a date like 2006-01-02 or an age like 7d)", value)
+}
@@ -3,26 +3,43 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/ospiem/dotpak/internal/backup"
 	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/download"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/hooks"
+	"github.com/ospiem/dotpak/internal/logging"
 	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/notify"
 	"github.com/ospiem/dotpak/internal/osutils"
 	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/pkgmgr"
 	"github.com/ospiem/dotpak/internal/restore"
+	"github.com/ospiem/dotpak/internal/webhook"
 )
 
 // Build information. Populated at build time via -ldflags.
@@ -37,6 +54,8 @@ var (
 	verbose    bool
 	quiet      bool
 	jsonOutput bool
+	homeDir    string
+	strict     bool
 )
 
 func main() {
@@ -49,6 +68,7 @@ Commands:
   backup   Create a backup of dotfiles
   restore  Restore dotfiles from backup
   list     List available backups
+  status   Show when the last backup was made
   config   Manage configuration
 
 Examples:
@@ -57,40 +77,136 @@ Examples:
   dotpak config init                # Create config
   dotpak restore                    # Restore from latest backup
   dotpak restore backup.tar.gz.age  # Restore specific archive
-  dotpak list                       # List available backups`,
+  dotpak list                       # List available backups
+
+Exit status:
+  0    success
+  1    other failure
+  2    invalid configuration or flags
+  3    completed with warnings, with --strict
+  4    encryption unavailable (no recipients, tool not installed)
+  5    encryption or decryption failed
+  6    archive not found
+  7    archive corrupt, or needs a newer dotpak
+  8    nothing to back up
+  9    no space left on device
+  10   permission denied
+  11   backup directory locked by another run
+  130  interrupted`,
+		PersistentPreRunE: func(_ *cobra.Command, _ []string) error {
+			if homeDir == "" {
+				return nil
+			}
+			return setHomeDir(homeDir)
+		},
 	}
 
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Config file path")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
 	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only show errors")
 	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	rootCmd.PersistentFlags().StringVar(&homeDir, "home", "", "Home directory to back up and restore (default $HOME)")
+	rootCmd.PersistentFlags().BoolVar(&strict, "strict", false,
+		"Fail with exit status 3 if the run printed warnings, such as skipped files")
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return errs.Wrap(errs.ErrConfigInvalid, err)
+	})
 
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
 	rootCmd.AddCommand(backupCmd())
 	rootCmd.AddCommand(restoreCmd())
+	rootCmd.AddCommand(checkRestoreCmd())
+	rootCmd.AddCommand(verifyCmd())
+	rootCmd.AddCommand(verifySignatureCmd())
+	rootCmd.AddCommand(lintPathsCmd())
 	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(statusCmd())
+	rootCmd.AddCommand(statsCmd())
+	rootCmd.AddCommand(fleetCmd())
+	rootCmd.AddCommand(pruneCmd())
+	rootCmd.AddCommand(upgradeBackupsCmd())
+	rootCmd.AddCommand(rekeyCmd())
 	rootCmd.AddCommand(configCmd())
 	rootCmd.AddCommand(diffCmd())
 	rootCmd.AddCommand(contentsCmd())
+	rootCmd.AddCommand(searchCmd())
+	rootCmd.AddCommand(catCmd())
+	rootCmd.AddCommand(tidyCmd())
+	rootCmd.AddCommand(chainCmd())
+	rootCmd.AddCommand(exportArchiveCmd())
+	rootCmd.AddCommand(exportCmd())
+	rootCmd.AddCommand(manifestCmd())
+	rootCmd.AddCommand(importSnapshotCmd())
+	rootCmd.AddCommand(migrateCmd())
+	rootCmd.AddCommand(uploadPendingCmd())
+	rootCmd.AddCommand(snapshotCmd())
+	rootCmd.AddCommand(snapshotIndexCmd())
+	rootCmd.AddCommand(hardwareKeyCmd())
 	rootCmd.AddCommand(cronCmd())
+	rootCmd.AddCommand(shellInitCmd())
+	rootCmd.AddCommand(envCmd())
 	rootCmd.AddCommand(versionCmd())
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	err := rootCmd.Execute()
+	if err == nil {
+		err = strictError()
 	}
+	stopLogging(err)
+	os.Exit(errs.ExitCode(err))
+}
+
+// strictError returns, with --strict, an error for the warnings the run
+// printed. The run itself is complete, and its --json result says so.
+func strictError() error {
+	n := output.Warnings()
+	if !strict || n == 0 {
+		return nil
+	}
+	warned := "a warning"
+	if n > 1 {
+		warned = fmt.Sprintf("%d warnings", n)
+	}
+	err := errs.Errorf(errs.ErrPartial, "the run printed %s and --strict is set", warned)
+	getOutput().Error("%v\n", err)
+	return err
+}
+
+// interruptContext returns a context canceled by the first SIGINT or
+// SIGTERM, so that a backup or restore stops cleanly instead of leaving a
+// partial archive or half-restored files. A second signal kills dotpak.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
 }
 
 func backupCmd() *cobra.Command {
 	var (
-		dryRun         bool
-		encrypt        string
-		noEncrypt      bool
-		noSecrets      bool
-		recipientsFile string
-		gpgRecipient   string
-		estimate       bool
-		profile        string
+		dryRun           bool
+		encrypt          string
+		noEncrypt        bool
+		encryptSensitive bool
+		noSecrets        bool
+		recipientsFile   string
+		gpgRecipient     string
+		estimate         bool
+		profile          string
+		materialize      bool
+		skipPlaceholders bool
+		nixSymlinks      string
+		shellSnapshot    bool
+		incremental      bool
+		noUpload         bool
+		profileIO        bool
+		progress         bool
+		jobs             int
+		items            []string
+		excludes         []string
+		wait             bool
 	)
 
 	cmd := &cobra.Command{
@@ -103,22 +219,50 @@ Examples:
   dotpak backup --dry-run          # Preview what would be backed up
   dotpak backup --encrypt age      # Use age encryption
   dotpak backup --encrypt gpg      # Use GPG encryption
+  dotpak backup --encrypt aes      # Built-in AES-256-GCM with DOTPAK_PASSPHRASE
+  dotpak backup --encrypt-sensitive  # Plain archive, sensitive files encrypted with age
   dotpak backup --estimate         # Show estimated backup size
+  dotpak backup --incremental      # Archive only files changed since the last backup
+  dotpak backup --profile-io       # Print time and IO per phase to diagnose slow backups
+  dotpak backup --progress         # Show progress even when output is piped
+  dotpak backup -j 8               # Walk 8 items at a time (large home directories)
+  dotpak backup --items .local/share/fonts --items ~/notes  # One-off extra items
+  dotpak backup --items '!.config/Code' --exclude '*.iso'    # Drop an item, skip files
+  dotpak backup --wait             # Wait for a running backup, restore, or prune to finish
   dotpak backup -p work            # Use 'work' profile`,
-		RunE: func(_ *cobra.Command, _ []string) error {
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			out := getOutput()
 
 			cfg, err := loadConfig(profile)
 			if err != nil {
 				return outputError(out, err)
 			}
+			for _, pattern := range excludes {
+				if err = checkExcludePattern(pattern); err != nil {
+					return outputError(out, errs.Wrap(errs.ErrConfigInvalid, fmt.Errorf("--exclude: %w", err)))
+				}
+			}
+			if err = cfg.ApplyOverrides(items, excludes); err != nil {
+				return outputError(out, err)
+			}
 
 			opts := &backup.Options{
-				DryRun:         dryRun,
-				IncludeSecrets: !noSecrets,
-				RecipientsFile: recipientsFile,
-				GPGRecipient:   gpgRecipient,
-				Estimate:       estimate,
+				DryRun:                  dryRun,
+				IncludeSecrets:          !noSecrets,
+				EncryptSensitive:        encryptSensitive,
+				RecipientsFile:          recipientsFile,
+				GPGRecipient:            gpgRecipient,
+				Estimate:                estimate,
+				MaterializePlaceholders: materialize,
+				SkipPlaceholders:        skipPlaceholders,
+				NixSymlinks:             nixSymlinks,
+				ShellSnapshot:           shellSnapshot,
+				Incremental:             incremental,
+				ProfileIO:               profileIO,
+				Jobs:                    jobs,
+				Profile:                 profile,
+				Version:                 version,
+				Wait:                    wait,
 			}
 
 			if noEncrypt {
@@ -127,18 +271,60 @@ Examples:
 				opts.EncryptionMethod = encrypt
 			}
 
-			b := backup.New(cfg, opts, out)
-			result, err := b.Run()
+			sink := output.NewTextSink(out)
+			if cmd.Flags().Changed("progress") {
+				sink.SetProgress(progress)
+			} else {
+				sink.SetProgress(osutils.IsTerminal(os.Stdout))
+			}
+
+			ctx, stop := interruptContext()
+			defer stop()
+			b := backup.New(cfg, opts, sink)
+			result, err := b.RunContext(ctx)
 			if err != nil {
 				return outputError(out, err)
 			}
+			// unreadable files are otherwise only listed with --verbose
+			if strict && result.Stats.FilesSkipped > 0 {
+				out.Warning("Skipped %d files that could not be read\n", result.Stats.FilesSkipped)
+			}
+
+			// snapshots live in the store in the backup directory, with no
+			// archive to upload or test
+			archived := result.Archive != ""
+			if result.Success && archived && !dryRun && !estimate && cfg.Remote.URL != "" && !noUpload {
+				var uploadErr error
+				if cfg.Remote.Spool {
+					uploadErr = uploadSpooled(cfg, result, out)
+				} else {
+					uploadErr = uploadBackup(cfg, result, out)
+				}
+				if uploadErr != nil {
+					result.Success = false
+					result.SetError(uploadErr)
+				}
+			}
+
+			if result.Success && archived && !result.Spooled && !dryRun && !estimate {
+				runSelfTest(cfg, result, out)
+			}
+
+			if !dryRun && !estimate {
+				postResultWebhook(cfg, "backup", result, out)
+				notifyBackup(cfg, result, out)
+			}
+
+			if profileIO && !jsonOutput {
+				printIOProfile(result.IOProfile, out)
+			}
 
 			if jsonOutput {
 				_ = out.JSON(result)
 			}
 
 			if !result.Success {
-				return errors.New(result.Error)
+				return errs.FromCode(result.ErrorCode, result.Error)
 			}
 
 			return nil
@@ -146,12 +332,38 @@ Examples:
 	}
 
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview without changes")
-	cmd.Flags().StringVar(&encrypt, "encrypt", "", "Encryption: age|gpg")
+	cmd.Flags().StringVar(&encrypt, "encrypt", "", "Encryption: age|gpg|aes")
 	cmd.Flags().BoolVar(&noEncrypt, "no-encrypt", false, "Disable encryption")
+	cmd.Flags().StringArrayVar(&items, "items", nil,
+		"Back up this path too, or leave out a configured item with !path, for this backup only (repeatable)")
+	cmd.Flags().StringArrayVar(&excludes, "exclude", nil,
+		"Exclude files matching this pattern (gitignore syntax) for this backup only (repeatable)")
+	cmd.Flags().BoolVar(&encryptSensitive, "encrypt-sensitive", false,
+		"Encrypt sensitive files on their own with age in an unencrypted archive")
 	cmd.Flags().BoolVar(&noSecrets, "no-secrets", false, "Exclude sensitive files")
 	cmd.Flags().StringVar(&recipientsFile, "recipients", "", "Path to age recipients file")
 	cmd.Flags().StringVar(&gpgRecipient, "gpg-recipient", "", "GPG recipient ID or email")
 	cmd.Flags().BoolVar(&estimate, "estimate", false, "Estimate backup size")
+	cmd.Flags().BoolVar(&materialize, "materialize", false,
+		"Download cloud-synced files (iCloud, OneDrive) not stored locally before archiving")
+	cmd.Flags().BoolVar(&skipPlaceholders, "skip-placeholders", false,
+		"Skip cloud-synced files not stored locally, even if materialize_placeholders is set")
+	cmd.MarkFlagsMutuallyExclusive("materialize", "skip-placeholders")
+	cmd.Flags().StringVar(&nixSymlinks, "nix-symlinks", "",
+		"Symlinks into the Nix store: link|skip|dereference (default: backup.nix_symlinks, else link)")
+	cmd.Flags().BoolVar(&shellSnapshot, "shell-snapshot", false,
+		"Archive shell aliases, functions, and environment as "+metadata.ShellSnapshotName+
+			" (values of *TOKEN*, *PASSWORD*, etc. and URL passwords redacted; other secrets are kept)")
+	cmd.Flags().BoolVar(&incremental, "incremental", false,
+		"Archive only files whose content changed since the previous backup")
+	cmd.Flags().BoolVar(&noUpload, "no-upload", false, "Do not upload the backup to the configured remote")
+	cmd.Flags().BoolVar(&profileIO, "profile-io", false, "Print time, files, and bytes read/written per phase")
+	cmd.Flags().BoolVar(&progress, "progress", false,
+		"Show byte progress with throughput and ETA "+
+			"(default: only when stdout is a terminal; otherwise a summary every 30s)")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", backup.DefaultJobs, "Items to collect in parallel")
+	cmd.Flags().BoolVar(&wait, "wait", false,
+		"Wait for another run using the backup directory to finish instead of failing")
 	cmd.Flags().StringVarP(&profile, "profile", "p", "", "Use named profile")
 
 	return cmd
@@ -159,69 +371,168 @@ Examples:
 
 func restoreCmd() *cobra.Command {
 	var (
-		dryRun    bool
-		force     bool
-		noBackup  bool
-		only      string
-		homebrew  bool
-		apt       bool
-		goRestore bool
+		dryRun     bool
+		force      bool
+		noBackup   bool
+		only       string
+		homebrew   bool
+		apt        bool
+		dnf        bool
+		pacman     bool
+		zypper     bool
+		goRestore  bool
+		vscode     bool
+		packages   string
+		jobs       int
+		fromRemote bool
+		skipVerify bool
+		noPost     bool
+		fsync      string
+		files      []string
+		atomic     bool
+		reportOnly bool
+		noRewrite  bool
+		target     string
+		rewriteTo  bool
+		profileIO  bool
+		profile    string
+		onConflict string
+		validate   bool
+		umask      bool
+		wait       bool
+		partial    bool
 	)
 
 	cmd := &cobra.Command{
-		Use:   "restore [archive]",
+		Use:   "restore [archive] [path...]",
 		Short: "Restore dotfiles from backup",
 		Long: `Restore dotfiles from a backup archive.
 
-If no archive is specified, restores from the latest backup.
+If no archive is specified, restores from the latest backup. Paths after the
+archive restore only those files, or the directories with everything under
+them, like --files without glob syntax.
+
+The archive may be an http(s) URL. It is downloaded to ~/.cache/dotpak/downloads
+and verified against a SHA-256 checksum taken from a "sha256" query parameter
+or from a sidecar file at the same URL with ".sha256" appended.
 
 Examples:
   dotpak restore                        # Latest backup
   dotpak restore backup.tar.gz          # Specific archive
   dotpak restore backup.tar.gz.age      # Encrypted archive
+  dotpak restore backup.tar.gz .zshrc .config/nvim  # Only these files and directories
+  dotpak restore https://example.com/dotfiles-20260101_120000.tar.gz.age
+  dotpak restore --remote               # Latest backup on the configured remote
   dotpak restore --only shell,git       # Specific categories
-  dotpak restore --homebrew             # Homebrew packages only
-  dotpak restore --go                   # Go packages only
-
-Categories: shell, git, editor, ssh, gpg, python, node, rust, go, cloud, docker, terminal, desktop`,
-		Args: cobra.MaximumNArgs(1),
+  dotpak restore --files '.config/nvim/**' --files .zshrc  # Specific files (globs)
+  dotpak restore --packages brew        # Homebrew packages only
+  dotpak restore --packages npm         # Global npm packages only
+  dotpak restore --vscode               # VS Code extensions (--packages jetbrains for JetBrains plugins)
+  dotpak restore --packages go -j 8 --json  # Go packages, 8 at a time, JSON summary
+  dotpak restore --fsync end            # Flush all restored files to disk before exiting
+  dotpak restore --transactional        # All files or none: stage, then swap into place
+  dotpak restore --no-post-restore      # Do not run post_restore commands of [[item]] entries
+  dotpak restore --no-rewrite           # Keep another user's home directory in restored configs
+  dotpak restore --target /tmp/inspect  # Extract into another directory instead of home
+  dotpak restore --on-conflict rename   # Write changed files as <file>.dotpak-restored next to local ones
+  dotpak restore --umask                # Apply the current umask to the archived file modes
+  dotpak restore --wait                 # Wait for a running backup or prune to finish
+  dotpak restore --report-only backup.tar.gz.age  # What a restore needs on a fresh OS, without restoring
+
+Categories: shell, git, editor, ssh, gpg, python, node, rust, go, cloud, docker, terminal, desktop, ai, wsl,
+plus any defined under [categories] in config.toml`,
+		Args: cobra.ArbitraryArgs,
 		RunE: func(_ *cobra.Command, args []string) error {
 			out := getOutput()
 
-			cfg, err := loadConfig("")
-			if err != nil {
+			if len(args) > 1 {
+				home, err := osutils.HomeDir()
+				if err != nil {
+					return outputError(out, err)
+				}
+				paths, err := restore.PathPatterns(args[1:], home)
+				if err != nil {
+					return outputError(out, err)
+				}
+				files = append(files, paths...)
+			}
+			if !slices.Contains(restore.FsyncPolicies, fsync) {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+					"--fsync must be %s (got %q)", strings.Join(restore.FsyncPolicies, "|"), fsync))
+			}
+			if err := restore.ValidateFilePatterns(files); err != nil {
 				return outputError(out, err)
 			}
-
-			if homebrew {
-				return handleHomebrew(cfg.Backup.BackupDir, dryRun, out)
+			if !slices.Contains(restore.ConflictPolicies, onConflict) {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+					"--on-conflict must be %s (got %q)", strings.Join(restore.ConflictPolicies, "|"), onConflict))
+			}
+			if onConflict == restore.ConflictPrompt && jsonOutput {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid, "--on-conflict prompt cannot be used with --json"))
 			}
 
-			if apt {
-				return handleApt(cfg.Backup.BackupDir, dryRun, out)
+			cfg, err := loadConfig(profile)
+			if err != nil {
+				return outputError(out, err)
+			}
+			categories, err := parseCategories(cfg, only)
+			if err != nil {
+				return outputError(out, err)
 			}
 
-			if goRestore {
-				return handleGo(cfg.Backup.BackupDir, dryRun, out)
+			legacy := []struct {
+				name    string
+				enabled bool
+			}{{"brew", homebrew}, {"apt", apt}, {"dnf", dnf}, {"pacman", pacman}, {"zypper", zypper}, {"go", goRestore},
+				{"vscode", vscode}}
+			for _, pm := range legacy {
+				if pm.enabled {
+					packages = pm.name
+					break
+				}
+			}
+			if packages != "" {
+				return handlePackages(cfg.Backup.BackupDir, packages, dryRun, jobs, out)
 			}
 
 			var archivePath string
-			if len(args) > 0 {
-				archivePath = args[0]
+			if fromRemote {
+				name := ""
+				if len(args) > 0 {
+					name = args[0]
+				}
+				archivePath, err = fetchRemoteArchive(cfg, name, out)
+				if err != nil {
+					return outputError(out, err)
+				}
+			} else if len(args) > 0 {
+				archivePath, err = resolveArchive(cfg, args[0], out)
+				if err != nil {
+					return outputError(out, err)
+				}
 			} else {
-				archivePath = findLatestBackup(cfg.Backup.BackupDir)
+				archivePath = metadata.LatestBackup(cfg.Backup.BackupDir)
 				if archivePath == "" {
 					return outputError(out, fmt.Errorf("no backups found in %s", cfg.Backup.BackupDir))
 				}
 				out.Print("Using latest backup: %s\n", filepath.Base(archivePath))
 			}
 
-			var categories []string
-			if only != "" {
-				categories = strings.Split(only, ",")
-				for i := range categories {
-					categories[i] = strings.TrimSpace(categories[i])
+			if reportOnly {
+				r := restore.New(cfg, &restore.Options{Categories: categories, Files: files, Target: target},
+					output.NewTextSink(out))
+				report, reportErr := r.Report(archivePath)
+				if reportErr != nil {
+					return outputError(out, reportErr)
 				}
+				if jsonOutput {
+					_ = out.JSON(report)
+				}
+				if !report.Success {
+					return errs.FromCode(report.ErrorCode, report.Error)
+				}
+				printRestoreReport(report, out)
+				return nil
 			}
 
 			if !force && !dryRun && !jsonOutput {
@@ -229,6 +540,12 @@ Categories: shell, git, editor, ssh, gpg, python, node, rust, go, cloud, docker,
 				if len(categories) > 0 {
 					out.Print("Categories: %s\n", strings.Join(categories, ", "))
 				}
+				if len(files) > 0 {
+					out.Print("Files: %s\n", strings.Join(files, ", "))
+				}
+				if target != "" {
+					out.Print("Target: %s\n", target)
+				}
 				out.Print("\nContinue? [y/N] ")
 
 				var response string
@@ -240,94 +557,195 @@ Categories: shell, git, editor, ssh, gpg, python, node, rust, go, cloud, docker,
 			}
 
 			opts := &restore.Options{
-				DryRun:     dryRun,
-				Force:      force,
-				Categories: categories,
-				NoBackup:   noBackup,
+				DryRun:             dryRun,
+				Force:              force,
+				Categories:         categories,
+				Files:              files,
+				NoBackup:           noBackup,
+				SkipIntegrityCheck: skipVerify,
+				NoPostRestore:      noPost,
+				Fsync:              fsync,
+				Transactional:      atomic,
+				NoRewrite:          noRewrite,
+				Target:             target,
+				RewriteTarget:      rewriteTo,
+				OnConflict:         onConflict,
+				Validate:           validate,
+				Umask:              umask,
+				Wait:               wait,
+				ForcePartial:       partial,
+			}
+			if onConflict == restore.ConflictPrompt {
+				opts.Resolve = promptConflict(out)
 			}
 
-			r := restore.New(cfg, opts, out)
-			result, err := r.Run(archivePath)
+			ctx, stop := interruptContext()
+			defer stop()
+			r := restore.New(cfg, opts, output.NewTextSink(out))
+			result, err := r.RunContext(ctx, archivePath)
 			if err != nil {
 				return outputError(out, err)
 			}
 
+			if !dryRun {
+				postResultWebhook(cfg, "restore", result, out)
+			}
+
+			if profileIO && !jsonOutput {
+				printIOProfile(result.IOProfile, out)
+			}
+
 			if jsonOutput {
 				_ = out.JSON(result)
 			}
 
 			if !result.Success {
-				return errors.New(result.Error)
+				return errs.FromCode(result.ErrorCode, result.Error)
 			}
 
 			return nil
 		},
 	}
 
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview without changes")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"Preview without changes: list files as new, modified, or identical, with totals per category")
 	cmd.Flags().BoolVarP(&force, "force", "f", false, "Skip confirmations")
 	cmd.Flags().BoolVar(&noBackup, "no-backup", false, "Skip creating safety backup")
 	cmd.Flags().StringVar(&only, "only", "", "Categories to restore (comma-separated)")
-	cmd.Flags().BoolVar(&homebrew, "homebrew", false, "Restore Homebrew packages only")
-	cmd.Flags().BoolVar(&apt, "apt", false, "Restore apt packages only (Linux)")
-	cmd.Flags().BoolVar(&goRestore, "go", false, "Restore Go packages only")
+	cmd.Flags().StringSliceVar(&files, "files", nil,
+		"Restore only files matching these globs relative to home (repeatable, ** matches any directories)")
+	cmd.Flags().BoolVar(&reportOnly, "report-only", false,
+		"Report what a restore would change and need (missing directories, programs, permissions) without restoring")
+	cmd.Flags().StringVar(&packages, "packages", "",
+		"Restore the packages of one package manager only ("+strings.Join(pkgmgr.Names(), ", ")+")")
+	cmd.Flags().BoolVar(&homebrew, "homebrew", false, "Same as --packages brew")
+	cmd.Flags().BoolVar(&apt, "apt", false, "Same as --packages apt")
+	cmd.Flags().BoolVar(&dnf, "dnf", false, "Same as --packages dnf")
+	cmd.Flags().BoolVar(&pacman, "pacman", false, "Same as --packages pacman")
+	cmd.Flags().BoolVar(&zypper, "zypper", false, "Same as --packages zypper")
+	cmd.Flags().BoolVar(&goRestore, "go", false, "Same as --packages go")
+	cmd.Flags().BoolVar(&vscode, "vscode", false, "Same as --packages vscode: reinstall VS Code extensions")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Parallel package installs")
+	cmd.Flags().BoolVar(&fromRemote, "remote", false,
+		"Download the archive (by name, default latest) from the configured remote")
+	cmd.Flags().BoolVar(&skipVerify, "skip-integrity-check", false,
+		"Restore even if the archive's integrity HMAC is missing or cannot be checked")
+	cmd.Flags().BoolVar(&noPost, "no-post-restore", false,
+		"Do not run the post_restore commands of restored [[item]] entries")
+	cmd.Flags().StringVar(&fsync, "fsync", restore.FsyncNone,
+		"When to flush restored files to disk: "+strings.Join(restore.FsyncPolicies, "|"))
+	cmd.Flags().BoolVar(&atomic, "transactional", false,
+		"Extract to a staging directory and move files into place only if all succeed, rolling back otherwise")
+	cmd.Flags().BoolVar(&noRewrite, "no-rewrite", false,
+		"Restore config files unchanged instead of replacing the backup's home directory and the [rewrite] map")
+	cmd.Flags().StringVar(&target, "target", "",
+		"Restore into this directory instead of the home directory (created if needed; skips post_restore commands)")
+	cmd.Flags().BoolVar(&rewriteTo, "rewrite-target", false,
+		"With --target, replace the backup's home directory in restored configs with the target, as for a new user's home")
+	cmd.Flags().BoolVar(&profileIO, "profile-io", false, "Print time, files, and bytes read/written per phase")
+	cmd.Flags().StringVar(&onConflict, "on-conflict", restore.ConflictOverwrite,
+		"For files that differ from the local ones: "+strings.Join(restore.ConflictPolicies, "|"))
+	cmd.Flags().BoolVar(&validate, "validate", false,
+		"Check the syntax of restored shell, git, tmux, and ssh configs, failing if one does not parse")
+	cmd.Flags().BoolVar(&umask, "umask", false,
+		"Clear the bits of the current umask from the file modes recorded in the archive")
+	cmd.Flags().BoolVar(&wait, "wait", false,
+		"Wait for another run using the backup directory to finish instead of failing")
+	cmd.Flags().BoolVar(&partial, "force-partial", false,
+		"Restore an archive made by a newer dotpak, leaving out the files that need features this one lacks")
+	cmd.Flags().StringVarP(&profile, "profile", "p", "",
+		"Use the backup_dir and encryption settings of a profile")
 
 	return cmd
 }
 
+// promptConflict asks, for each restored file that differs from the local
+// one, whether to overwrite it, keep it, or restore the file next to it.
+func promptConflict(out *output.Output) func(name string) string {
+	return func(name string) string {
+		out.Print("%s differs from the backup: [o]verwrite, [k]eep, or [r]estore as %s? [k] ",
+			name, filepath.Base(name)+restore.RestoredSuffix)
+		var response string
+		_, _ = fmt.Scanln(&response)
+		switch strings.ToLower(response) {
+		case "o":
+			return restore.ConflictOverwrite
+		case "r":
+			return restore.ConflictRename
+		default:
+			return restore.ConflictKeep
+		}
+	}
+}
+
 func listCmd() *cobra.Command {
-	return &cobra.Command{
+	var (
+		fromRemote bool
+		localOnly  bool
+		profile    string
+		since      string
+		filter     backupFilter
+	)
+
+	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List available backups",
+		Long: `List the backups in the backup directory, newest first. With a [remote]
+configured, the backups on the remote are listed too, each marked local,
+remote, or local+remote; --local skips the remote and --remote lists it alone.
+The details of remote backups come from their metadata files, downloaded once
+into the download cache, so the archives themselves are never downloaded.
+
+Examples:
+  dotpak list --host mbp --since 7d
+  dotpak list --since 2026-01-01 --encrypted-only --json`,
+		Args: cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
 			out := getOutput()
 
-			cfg, err := loadConfig("")
+			cfg, err := loadConfig(profile)
 			if err != nil {
 				return outputError(out, fmt.Errorf("loading config: %w", err))
 			}
-			backupDir := cfg.Backup.BackupDir
-
-			entries, err := os.ReadDir(backupDir)
-			if err != nil {
-				return outputError(out, fmt.Errorf("reading backup directory: %w", err))
+			if filter.since, err = parseSince(since, time.Now()); err != nil {
+				return outputError(out, err)
 			}
+			backupDir := cfg.Backup.BackupDir
 
 			var backups []metadata.BackupInfo
-
-			for _, entry := range entries {
-				name := entry.Name()
-				if !isArchiveFile(name) {
-					continue
+			switch {
+			case fromRemote:
+				backupDir = cfg.Remote.URL
+				backups, err = listRemoteBackups(cfg)
+				if err == nil {
+					err = describeRemoteBackups(cfg, backups)
 				}
-
-				fullPath := filepath.Join(backupDir, name)
-				info, infoErr := entry.Info()
-				if infoErr != nil {
-					// file became unreadable between ReadDir and Info - skip it
-					continue
+			case cfg.Remote.URL != "" && !localOnly:
+				backups, err = metadata.ListBackups(backupDir)
+				if errors.Is(err, os.ErrNotExist) {
+					err = nil
+				} else if err != nil {
+					err = fmt.Errorf("reading backup directory: %w", err)
+					break
 				}
-
-				backupInfo := metadata.BackupInfo{
-					Archive:   fullPath,
-					Timestamp: extractTimestamp(name),
-					Size:      info.Size(),
-					Encrypted: hasEncryptionExt(name),
+				remoteBackups, remoteErr := listRemoteBackups(cfg)
+				if remoteErr != nil {
+					out.Warning("Listing local backups only: %v\n", remoteErr)
+					break
 				}
-
-				metaPath := metadata.GetMetadataPath(fullPath)
-				if meta, loadErr := metadata.Load(metaPath); loadErr == nil {
-					backupInfo.Hostname = meta.Hostname
-					backupInfo.FileCount = meta.Stats.FilesBackedUp
-					backupInfo.Encryption = meta.EncryptionMethod
+				backups = mergeBackups(backups, remoteBackups)
+				err = describeRemoteBackups(cfg, backups)
+				backupDir += " or " + cfg.Remote.URL
+			default:
+				backups, err = metadata.ListBackups(backupDir)
+				if err != nil {
+					err = fmt.Errorf("reading backup directory: %w", err)
 				}
-
-				backups = append(backups, backupInfo)
 			}
-
-			sort.Slice(backups, func(i, j int) bool {
-				return backups[i].Timestamp > backups[j].Timestamp
-			})
+			if err != nil {
+				return outputError(out, err)
+			}
+			backups = filter.apply(cfg, backups)
 
 			result := &metadata.ListResult{
 				Success: true,
@@ -347,8 +765,18 @@ func listCmd() *cobra.Command {
 					if b.Encrypted {
 						enc = fmt.Sprintf(" [%s]", b.Encryption)
 					}
+					if b.Location != "" {
+						enc += " (" + b.Location + ")"
+					}
 					out.Print("  %s%s\n", filepath.Base(b.Archive), enc)
-					out.Print("    Size: %s, Files: %d\n", formatSize(b.Size), b.FileCount)
+					if b.FileCount > 0 {
+						out.Print("    Size: %s, Files: %d\n", formatSize(b.Size), b.FileCount)
+					} else {
+						out.Print("    Size: %s\n", formatSize(b.Size))
+					}
+					if b.Parent != "" {
+						out.Print("    Incremental on: %s\n", b.Parent)
+					}
 					if b.Hostname != "" {
 						out.Print("    Host: %s\n", b.Hostname)
 					}
@@ -359,6 +787,16 @@ func listCmd() *cobra.Command {
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&fromRemote, "remote", false, "List only the backups on the configured remote")
+	cmd.Flags().BoolVar(&localOnly, "local", false, "List only the backups in the backup directory")
+	cmd.Flags().StringVarP(&profile, "profile", "p", "", "List the backups of a profile with its own backup_dir")
+	cmd.Flags().StringVar(&filter.host, "host", "", "List only the backups of this host")
+	cmd.Flags().StringVar(&since, "since", "", "List only the backups since a date (2006-01-02) or age (7d, 36h)")
+	cmd.Flags().BoolVar(&filter.encryptedOnly, "encrypted-only", false, "List only encrypted backups")
+	cmd.MarkFlagsMutuallyExclusive("remote", "local")
+
+	return cmd
 }
 
 func configCmd() *cobra.Command {
@@ -369,6 +807,10 @@ func configCmd() *cobra.Command {
 
 	cmd.AddCommand(configInitCmd())
 	cmd.AddCommand(configValidateCmd())
+	cmd.AddCommand(configGetCmd())
+	cmd.AddCommand(configSetCmd())
+	cmd.AddCommand(configAddItemCmd())
+	cmd.AddCommand(configTestPatternCmd())
 
 	return cmd
 }
@@ -435,6 +877,10 @@ func configValidateCmd() *cobra.Command {
 				return outputError(out, err)
 			}
 
+			for _, overlap := range cfg.OverlappingItems() {
+				out.Warning("%s is already backed up with %s; remove it from the config\n",
+					overlap.Path, overlap.Within)
+			}
 			out.Success("Config OK: %s\n", cfgPath)
 			return nil
 		},
@@ -442,23 +888,49 @@ func configValidateCmd() *cobra.Command {
 }
 
 func diffCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "diff <archive>",
-		Short: "Show differences between archive and current files",
-		Args:  cobra.ExactArgs(1),
+	var only string
+
+	cmd := &cobra.Command{
+		Use:   "diff <archive> [newer-archive]",
+		Short: "Show differences between archive and current files, or between two archives",
+		Long: `Show the files an archive would change in the home directory. With a second
+archive, show the files added, removed, and modified from the first backup to
+the second instead. Incremental backups are compared as they restore, merged
+with their parents. Pass --verbose to show the content changes.
+
+Examples:
+  dotpak diff dotfiles-20260101_120000.tar.gz
+  dotpak diff -v dotfiles-20260101_120000.tar.gz dotfiles-20260201_120000.tar.gz`,
+		Args: cobra.RangeArgs(1, 2),
 		RunE: func(_ *cobra.Command, args []string) error {
 			out := getOutput()
 			cfg, err := loadConfig("")
 			if err != nil {
 				return outputError(out, err)
 			}
-			return restore.ShowDiff(cfg, args[0], verbose, out)
+			categories, err := parseCategories(cfg, only)
+			if err != nil {
+				return outputError(out, err)
+			}
+			if len(args) == 2 {
+				return restore.ShowArchiveDiff(cfg, args[0], args[1], categories, verbose, out)
+			}
+			return restore.ShowDiff(cfg, args[0], categories, verbose, out)
 		},
 	}
+
+	cmd.Flags().StringVar(&only, "only", "", "Categories to compare (comma-separated)")
+
+	return cmd
 }
 
 func contentsCmd() *cobra.Command {
-	return &cobra.Command{
+	var (
+		only       string
+		verifyHome bool
+	)
+
+	cmd := &cobra.Command{
 		Use:   "contents <archive>",
 		Short: "List archive contents",
 		Args:  cobra.ExactArgs(1),
@@ -468,9 +940,25 @@ func contentsCmd() *cobra.Command {
 			if err != nil {
 				return outputError(out, err)
 			}
-			return restore.ListArchiveContents(cfg, args[0], out)
+			categories, err := parseCategories(cfg, only)
+			if err != nil {
+				return outputError(out, err)
+			}
+			var home string
+			if verifyHome {
+				if home, err = osutils.HomeDir(); err != nil {
+					return outputError(out, err)
+				}
+			}
+			return restore.ListArchiveContents(cfg, args[0], categories, home, out)
 		},
 	}
+
+	cmd.Flags().StringVar(&only, "only", "", "Categories to list (comma-separated)")
+	cmd.Flags().BoolVar(&verifyHome, "verify-against-home", false,
+		"Mark each entry same, differs, or missing compared to the home directory")
+
+	return cmd
 }
 
 func cronCmd() *cobra.Command {
@@ -513,7 +1001,7 @@ func cronCmd() *cobra.Command {
 
 	runCmd := &cobra.Command{
 		Use:    "run",
-		Short:  "Run backup with logging (used by launchd/cron)",
+		Short:  "Run backup with logging (used by launchd, cron, and Task Scheduler)",
 		Hidden: true,
 		RunE: func(_ *cobra.Command, _ []string) error {
 			return cronRun()
@@ -548,19 +1036,91 @@ func getOutput() *output.Output {
 	return output.New(mode, verbose)
 }
 
+// setHomeDir validates dir and uses it as the home directory for the rest of
+// the run, including the default config location and "~/" expansion.
+func setHomeDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return outputError(getOutput(), errs.Errorf(errs.ErrConfigInvalid, "--home: %w", err))
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return outputError(getOutput(), errs.Errorf(errs.ErrConfigInvalid, "--home: %w", err))
+	}
+	if !info.IsDir() {
+		return outputError(getOutput(), errs.Errorf(errs.ErrConfigInvalid, "--home: %s is not a directory", abs))
+	}
+	osutils.SetHomeDir(abs)
+	return nil
+}
+
 func loadConfig(profile string) (*config.Config, error) {
 	cfgPath := configFile
 	if cfgPath == "" {
 		cfgPath = config.DefaultConfigPath()
 	}
-	return config.LoadWithProfile(cfgPath, profile)
+	cfg, err := config.LoadWithProfile(cfgPath, profile)
+	if err == nil && runLogger == nil {
+		if logErr := startLogging(cfg.Logging); logErr != nil {
+			getOutput().Warning("Not logging to %s: %v\n", cfg.Logging.File, logErr)
+		}
+	}
+	return cfg, err
+}
+
+// The log of the run, started by the first config loaded that sets
+// [logging] file.
+var (
+	runLogger *slog.Logger
+	logFile   io.Closer
+	logStart  time.Time
+)
+
+// startLogging opens the log file of cfg, if it sets one, and logs the
+// command line. Every message printed from then on is logged too.
+func startLogging(cfg config.LoggingConfig) error {
+	if cfg.File == "" {
+		return nil
+	}
+	level, err := logging.ParseLevel(cfg.Level)
+	if err != nil {
+		return fmt.Errorf("logging.level: %w", err)
+	}
+	maxSize, err := config.ParseSize(cfg.MaxSize)
+	if err != nil {
+		return fmt.Errorf("logging.max_size: %w", err)
+	}
+	logger, closer, err := logging.Open(cfg.File, logging.Options{Level: level, MaxSize: maxSize, MaxFiles: cfg.MaxFiles})
+	if err != nil {
+		return err
+	}
+	runLogger, logFile, logStart = logger.With("pid", os.Getpid()), closer, time.Now()
+	output.SetLogger(runLogger)
+	runLogger.Info("dotpak "+strings.Join(os.Args[1:], " "), "version", version)
+	return nil
+}
+
+// stopLogging logs how the run ended and closes the log file.
+func stopLogging(err error) {
+	if runLogger == nil {
+		return
+	}
+	took := time.Since(logStart).Round(time.Millisecond)
+	if err != nil {
+		runLogger.Error("Failed", "error", err, "duration", took)
+	} else {
+		runLogger.Info("Finished", "duration", took)
+	}
+	output.SetLogger(nil)
+	_ = logFile.Close()
 }
 
 func outputError(out *output.Output, err error) error {
 	if jsonOutput {
 		_ = out.JSON(map[string]any{
-			"success": false,
-			"error":   err.Error(),
+			"success":    false,
+			"error":      err.Error(),
+			"error_code": errs.Code(err),
 		})
 	} else {
 		out.Error("%v\n", err)
@@ -568,6 +1128,133 @@ func outputError(out *output.Output, err error) error {
 	return err
 }
 
+// resolveArchive returns a local path for archive, downloading it into the
+// cache first if it is a URL.
+func resolveArchive(cfg *config.Config, archive string, out *output.Output) (string, error) {
+	if !download.IsURL(archive) {
+		return archive, nil
+	}
+	keys, err := minisignKeys(cfg)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := osutils.DownloadDir()
+	if err != nil {
+		return "", fmt.Errorf("creating download cache: %w", err)
+	}
+	out.Print("Downloading %s...\n", archive)
+	path, err := download.Archive(archive, cacheDir)
+	if err != nil {
+		return "", fmt.Errorf("downloading archive: %w", err)
+	}
+	out.Verbose("Checksum verified, cached at %s\n", path)
+
+	if len(keys) == 0 {
+		out.Warning("Signature of %s not checked: set backup.minisign_public_keys to require signed archives\n",
+			filepath.Base(path))
+		return path, nil
+	}
+	comment, err := download.VerifySignature(archive, path, keys)
+	if err != nil {
+		return "", fmt.Errorf("verifying signature: %w", err)
+	}
+	out.Print("Signature verified: %s\n", comment)
+	return path, nil
+}
+
+// minisignKeys parses the trusted minisign public keys from the config.
+// Downloaded archives must be signed by one of them when any are set.
+func minisignKeys(cfg *config.Config) ([]crypto.MinisignKey, error) {
+	keys := make([]crypto.MinisignKey, 0, len(cfg.Backup.MinisignPublicKeys))
+	for _, s := range cfg.Backup.MinisignPublicKeys {
+		key, err := crypto.ParseMinisignKey(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// postResultWebhook sends an operation result to the configured webhook.
+// Delivery failures are reported as warnings and never fail the operation.
+func postResultWebhook(cfg *config.Config, event string, result any, out *output.Output) {
+	if cfg.Backup.ResultWebhookURL == "" {
+		return
+	}
+	if err := webhook.Post(cfg.Backup.ResultWebhookURL, cfg.Backup.ResultWebhookSecret, event, result); err != nil {
+		out.Warning("Result webhook failed: %v\n", err)
+		return
+	}
+	out.Verbose("Result posted to webhook\n")
+}
+
+// notifyBackup reports a backup result through the configured
+// notifications, warning about the channels that fail.
+func notifyBackup(cfg *config.Config, result *metadata.BackupResult, out *output.Output) {
+	if err := notify.Notify(cfg.Notifications, notify.Backup(result)); err != nil {
+		out.Warning("Notifications failed: %v\n", err)
+	}
+}
+
+// validateHosts reports host groups without members and hostnames claimed
+// by more than one [host] table.
+func validateHosts(cfg *config.Config) []string {
+	var issues []string
+	for _, name := range slices.Sorted(maps.Keys(cfg.HostGroups)) {
+		if len(cfg.HostGroups[name].Members) == 0 {
+			issues = append(issues, fmt.Sprintf("host-group.%s.members is required", name))
+		}
+	}
+	owner := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Hosts)) {
+		for _, alias := range cfg.Hosts[name].Aliases {
+			if _, ok := cfg.Hosts[alias]; ok && alias != name {
+				issues = append(issues, fmt.Sprintf("host.%s.aliases: %q has a [host] table of its own", name, alias))
+			} else if other, ok := owner[alias]; ok && other != name {
+				issues = append(issues, fmt.Sprintf("host.%s.aliases: %q is already an alias of %s", name, alias, other))
+			}
+			owner[alias] = name
+		}
+	}
+	return issues
+}
+
+// validateProfiles reports invalid [profile.X.backup] overrides, checking
+// each against the [backup] settings it does not override.
+func validateProfiles(cfg *config.Config) []string {
+	var issues []string
+	for _, name := range slices.Sorted(maps.Keys(cfg.Profiles)) {
+		override := cfg.Profiles[name].Backup
+		prefix := "profile." + name + ".backup."
+		switch override.Encryption {
+		case "age", "gpg", "aes", "none", "":
+		default:
+			issues = append(issues, fmt.Sprintf("%sencryption must be age|gpg|aes|none (got %q)",
+				prefix, override.Encryption))
+		}
+		encryption := cmp.Or(override.Encryption, cfg.Backup.Encryption)
+		if override.MaxBackups != nil && *override.MaxBackups < 0 {
+			issues = append(issues, prefix+"max_backups must be >= 0")
+		}
+		if encryption == "age" && cmp.Or(override.AgeRecipients, cfg.Backup.AgeRecipients) == "" {
+			issues = append(issues, prefix+"age_recipients is required when encryption=age")
+		}
+		if encryption == "gpg" && cmp.Or(override.GPGRecipient, cfg.Backup.GPGRecipient) == "" {
+			issues = append(issues, prefix+"gpg_recipient is required when encryption=gpg")
+		}
+	}
+	return issues
+}
+
+// checkExcludePattern checks the syntax of an [excludes] pattern.
+func checkExcludePattern(pattern string) error {
+	if _, err := path.Match(strings.TrimPrefix(filepath.ToSlash(pattern), "!"), ""); err != nil {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+	return nil
+}
+
 func validateConfig(cfg *config.Config) error {
 	var issues []string
 
@@ -595,15 +1282,136 @@ func validateConfig(cfg *config.Config) error {
 		issues = append(issues, "backup.max_backups must be >= 0")
 	}
 
+	for _, pattern := range cfg.Excludes.Patterns {
+		if err := checkExcludePattern(pattern); err != nil {
+			issues = append(issues, "excludes.patterns: "+err.Error())
+		}
+	}
+
+	if err := validateRetention(cfg.Retention); err != nil {
+		issues = append(issues, err.Error())
+	}
+
+	if cfg.Backup.SizeChangeAlertPercent < 0 {
+		issues = append(issues, "backup.size_change_alert_percent must be >= 0")
+	}
+
+	if _, err := cfg.Backup.ParseVerifySchedule(); err != nil {
+		issues = append(issues, err.Error())
+	}
+
+	if _, err := cfg.Backup.ParsePreserve(); err != nil {
+		issues = append(issues, err.Error())
+	}
+
+	if _, err := cfg.FileSizeLimits(); err != nil {
+		issues = append(issues, err.Error())
+	}
+
+	if _, err := minisignKeys(cfg); err != nil {
+		issues = append(issues, "backup.minisign_public_keys: "+err.Error())
+	}
+
+	for i, item := range cfg.ItemConfigs {
+		if strings.TrimSpace(item.Path) == "" {
+			issues = append(issues, fmt.Sprintf("item[%d].path is required", i))
+		}
+	}
+	for _, item := range cfg.WSL.Items {
+		if !filepath.IsLocal(filepath.FromSlash(item)) || strings.HasPrefix(item, "~") {
+			issues = append(issues,
+				fmt.Sprintf("wsl.items must be relative to the Windows user profile (got %q)", item))
+		}
+	}
+
+	if err := restore.ValidateOrder(cfg); err != nil {
+		issues = append(issues, err.Error())
+	}
+
+	issues = append(issues, validateHosts(cfg)...)
+	issues = append(issues, validateProfiles(cfg)...)
+
+	for _, key := range []struct {
+		name  string
+		names []string
+	}{{"managers", cfg.Packages.Managers}, {"skip", cfg.Packages.Skip}} {
+		for _, name := range key.names {
+			if _, ok := pkgmgr.Find(name); !ok {
+				issues = append(issues, fmt.Sprintf("packages.%s: unknown package manager %q (supported: %s)",
+					key.name, name, strings.Join(pkgmgr.Names(), ", ")))
+			}
+		}
+	}
+
 	switch cfg.Backup.Encryption {
-	case "age", "gpg", "none", "":
+	case "age", "gpg", "aes", "none", "":
 	default:
 		issues = append(
 			issues,
-			fmt.Sprintf("backup.encryption must be age|gpg|none (got %q)", cfg.Backup.Encryption),
+			fmt.Sprintf("backup.encryption must be age|gpg|aes|none (got %q)", cfg.Backup.Encryption),
 		)
 	}
 
+	switch cfg.Backup.Format {
+	case "tar.gz", "zip", "":
+	default:
+		issues = append(issues, fmt.Sprintf("backup.format must be tar.gz|zip (got %q)", cfg.Backup.Format))
+	}
+
+	switch cfg.Backup.NixSymlinks {
+	case backup.NixLink, backup.NixSkip, backup.NixDereference, "":
+	default:
+		issues = append(issues,
+			fmt.Sprintf("backup.nix_symlinks must be link|skip|dereference (got %q)", cfg.Backup.NixSymlinks))
+	}
+
+	switch cfg.Backup.Storage {
+	case "archive", "":
+	case backup.StorageObjects:
+		if cfg.Backup.Encryption != "none" && cfg.Backup.Encryption != "" {
+			issues = append(issues, "backup.storage=objects does not support encryption (set encryption = \"none\")")
+		}
+	default:
+		issues = append(issues, fmt.Sprintf("backup.storage must be archive|objects (got %q)", cfg.Backup.Storage))
+	}
+
+	for from := range cfg.Rewrite.Map {
+		if from == "" {
+			issues = append(issues, "rewrite.map keys must not be empty")
+		}
+	}
+	if err := restore.ValidateFilePatterns(cfg.Rewrite.Files); err != nil {
+		issues = append(issues, fmt.Sprintf("rewrite.files: %v", err))
+	}
+
+	switch cfg.Hooks.OnFailure {
+	case hooks.OnFailureAbort, hooks.OnFailureWarn, "":
+	default:
+		issues = append(issues, fmt.Sprintf("hooks.on_failure must be abort|warn (got %q)", cfg.Hooks.OnFailure))
+	}
+
+	for _, category := range cfg.Validate.Categories {
+		if !slices.Contains(restore.ValidatorCategories(), category) {
+			issues = append(issues, fmt.Sprintf("validate.categories: unknown category %q (available: %s)",
+				category, strings.Join(restore.ValidatorCategories(), ", ")))
+		}
+	}
+
+	if _, _, err := cfg.Restore.DefaultModes(); err != nil {
+		issues = append(issues, err.Error())
+	}
+	if err := restore.ValidateCategories(cfg, cfg.Restore.PrivateCategories); err != nil {
+		issues = append(issues, "restore.private_categories: "+err.Error())
+	}
+
+	if cfg.Notifications.On != "" && !slices.Contains(notify.Policies, cfg.Notifications.On) {
+		issues = append(issues, fmt.Sprintf("notifications.on must be %s (got %q)",
+			strings.Join(notify.Policies, "|"), cfg.Notifications.On))
+	}
+	if len(cfg.Notifications.EmailTo) > 0 && (cfg.Notifications.SMTPHost == "" || cfg.Notifications.EmailFrom == "") {
+		issues = append(issues, "notifications.smtp_host and email_from are required with email_to")
+	}
+
 	if cfg.Backup.Encryption == "age" {
 		if strings.TrimSpace(cfg.Backup.AgeRecipients) == "" {
 			issues = append(issues, "backup.age_recipients is required when encryption=age")
@@ -616,14 +1424,67 @@ func validateConfig(cfg *config.Config) error {
 			}
 			if _, err := os.Stat(recipientsPath); err != nil {
 				issues = append(issues, fmt.Sprintf("backup.age_recipients not found: %s", cfg.Backup.AgeRecipients))
+			} else if err = crypto.CheckRecipients(recipientsPath); err != nil {
+				issues = append(issues, "backup.age_recipients: "+err.Error())
 			}
 		}
 	}
+	for _, path := range cfg.Backup.AgeIdentityFiles {
+		if plugin, err := crypto.IdentityPlugin(path); err == nil && plugin != "" {
+			if _, err = exec.LookPath("age-plugin-" + plugin); err != nil {
+				issues = append(issues, fmt.Sprintf("backup.age_identity_files: %s needs age-plugin-%s in PATH", path, plugin))
+			}
+		}
+	}
+
+	switch cfg.Backup.SensitiveEncryption {
+	case "":
+	case "age":
+		if cfg.Backup.Format == "zip" {
+			issues = append(issues, "backup.sensitive_encryption requires format = \"tar.gz\"")
+		}
+		if cfg.Backup.Storage == backup.StorageObjects {
+			issues = append(issues, "backup.sensitive_encryption is not supported with storage = \"objects\"")
+		}
+		if strings.TrimSpace(cfg.Backup.AgeRecipients) == "" {
+			issues = append(issues, "backup.age_recipients is required when sensitive_encryption=age")
+		}
+	default:
+		issues = append(issues,
+			fmt.Sprintf("backup.sensitive_encryption must be age or empty (got %q)", cfg.Backup.SensitiveEncryption))
+	}
 
 	if cfg.Backup.Encryption == "gpg" && strings.TrimSpace(cfg.Backup.GPGRecipient) == "" {
 		issues = append(issues, "backup.gpg_recipient is required when encryption=gpg")
 	}
 
+	if kind := cfg.Backup.HardwareKey; kind != "" {
+		if cfg.Backup.Encryption != "age" {
+			issues = append(issues, "backup.hardware_key requires encryption=age")
+		}
+		if err := crypto.CheckHardware(kind); err != nil {
+			issues = append(issues, "backup.hardware_key: "+err.Error())
+		}
+	}
+
+	if cfg.Backup.Encryption == "aes" {
+		if _, err := crypto.ResolvePassphrase(cfg.Backup.PassphraseFile); err != nil {
+			issues = append(issues, err.Error())
+		}
+	}
+
+	if hookURL := cfg.Backup.ResultWebhookURL; hookURL != "" {
+		if u, err := url.Parse(hookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			issues = append(issues, fmt.Sprintf("backup.result_webhook_url must be an http(s) URL (got %q)", hookURL))
+		}
+	}
+
+	if cfg.Remote.URL != "" {
+		if _, err := newRemote(cfg); err != nil {
+			issues = append(issues, "remote.url: "+err.Error())
+		}
+	}
+
 	for _, path := range cfg.Items {
 		if strings.TrimSpace(path) == "" {
 			issues = append(issues, "items contains empty path")
@@ -641,125 +1502,51 @@ func validateConfig(cfg *config.Config) error {
 	if len(issues) == 0 {
 		return nil
 	}
-	return fmt.Errorf("config validation failed:\n- %s", strings.Join(issues, "\n- "))
-}
-
-func handleHomebrew(backupDir string, dryRun bool, out *output.Output) error {
-	// sanitize and validate the brewfile path
-	cleanBackupDir := filepath.Clean(backupDir)
-	brewfile := filepath.Join(cleanBackupDir, "Brewfile")
-
-	// resolve to absolute path and verify it's within backup directory
-	absBrewfile, err := filepath.Abs(brewfile)
-	if err != nil {
-		return outputError(out, fmt.Errorf("invalid brewfile path: %w", err))
-	}
-	absBackupDir, err := filepath.Abs(cleanBackupDir)
-	if err != nil {
-		return outputError(out, fmt.Errorf("invalid backup directory: %w", err))
-	}
-	if !strings.HasPrefix(absBrewfile, absBackupDir+string(filepath.Separator)) {
-		return outputError(out, errors.New("brewfile path escapes backup directory"))
-	}
-
-	// verify it's a regular file (not a symlink to outside)
-	info, err := os.Lstat(absBrewfile)
-	if err != nil {
-		return outputError(out, fmt.Errorf("brewfile not found: %s", brewfile))
-	}
-	if info.Mode()&os.ModeSymlink != 0 {
-		return outputError(out, errors.New("brewfile cannot be a symlink"))
-	}
-
-	out.Print("Restoring Homebrew packages from %s...\n", brewfile)
-
-	if dryRun {
-		out.Print("\nDry run - would run: brew bundle install --file=%s\n", brewfile)
-		return nil
-	}
-
-	//nolint:gosec // g204: absBrewfile is validated to be within backup directory above
-	cmd := exec.Command("brew", "bundle", "install", "--file="+absBrewfile, "--no-lock")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err = cmd.Run(); err != nil {
-		return outputError(out, fmt.Errorf("brew bundle failed: %w", err))
-	}
-
-	out.Success("Homebrew packages restored\n")
-	return nil
+	return errs.Errorf(errs.ErrConfigInvalid, "config validation failed:\n- %s", strings.Join(issues, "\n- "))
 }
 
 const linux = "linux"
 const darwin = "darwin"
+const windows = "windows"
 
-func handleApt(backupDir string, dryRun bool, out *output.Output) error {
-	if runtime.GOOS != linux {
-		return outputError(out, errors.New("apt restore only available on Linux"))
-	}
-	aptFile := filepath.Join(filepath.Clean(backupDir), "apt-packages.txt")
-	if _, err := os.Stat(aptFile); err != nil {
-		return outputError(out, errors.New("apt-packages.txt not found in backup"))
+// handlePackages reinstalls the packages of one package manager from its
+// snapshot in the backup directory.
+func handlePackages(backupDir, name string, dryRun bool, jobs int, out *output.Output) error {
+	pm, ok := pkgmgr.Find(name)
+	if !ok {
+		return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+			"unknown package manager %q (supported: %s)", name, strings.Join(pkgmgr.Names(), ", ")))
 	}
-	if dryRun {
-		out.Print("Dry run - would install packages from: %s\n", aptFile)
-		return nil
-	}
-	out.Print("To restore apt packages, run:\n")
-	out.Print("  xargs sudo apt install -y < %s\n", aptFile)
-	return nil
-}
 
-func handleGo(backupDir string, dryRun bool, out *output.Output) error {
-	goFile := filepath.Join(filepath.Clean(backupDir), "go-packages.txt")
-	content, err := os.ReadFile(goFile)
+	opts := pkgmgr.RestoreOptions{DryRun: dryRun, Jobs: jobs}
+	if !jsonOutput {
+		opts.Output = os.Stdout
+	}
+	result, err := pm.Restore(filepath.Join(filepath.Clean(backupDir), pm.File()), opts, output.NewTextSink(out))
 	if err != nil {
-		return outputError(out, errors.New("go-packages.txt not found in backup"))
+		return outputError(out, err)
 	}
 
-	var packages []string
-	for line := range strings.SplitSeq(string(content), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			packages = append(packages, line)
-		}
+	for _, f := range result.Failed {
+		out.Warning("Failed to install %s after %d attempt(s): %s\n", f.Package, f.Attempts, f.Error)
 	}
-
-	if len(packages) == 0 {
-		out.Print("No Go packages to restore\n")
-		return nil
+	if result.Command != "" && !jsonOutput {
+		out.Print("To restore %s packages, run:\n  %s\n", name, result.Command)
 	}
-
-	out.Print("Restoring %d Go packages...\n", len(packages))
-
-	if dryRun {
-		out.Print("\nDry run - would run:\n")
-		for _, pkg := range packages {
-			out.Print("  go install %s@latest\n", pkg)
-		}
-		return nil
-	}
-
-	var installed, failed int
-	for _, pkg := range packages {
-		out.Verbose("Installing %s...\n", pkg)
-		//nolint:gosec // g204: pkg comes from go-packages.txt backup file created by this tool
-		cmd := exec.Command("go", "install", pkg+"@latest")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			out.Warning("Failed to install %s: %v\n", pkg, err)
-			failed++
-		} else {
-			installed++
-		}
+	if jsonOutput {
+		_ = out.JSON(result)
 	}
 
-	if failed > 0 {
-		out.Print("Go packages: %d installed, %d failed\n", installed, failed)
-	} else {
-		out.Success("Installed %d Go packages\n", installed)
+	switch {
+	case result.Error != "":
+		return errors.New(result.Error)
+	case dryRun, result.Command != "":
+	case len(result.Failed) > 0:
+		out.Print("%s packages: %d installed, %d failed\n", name, len(result.Installed), len(result.Failed))
+	case len(result.Installed) > 0:
+		out.Success("Installed %d %s packages\n", len(result.Installed), name)
+	case name == "brew":
+		out.Success("Homebrew packages restored\n")
 	}
 	return nil
 }
@@ -770,8 +1557,10 @@ func installCron(hour int, out *output.Output) error {
 		return installLaunchdCron(hour, out)
 	case linux:
 		return installLinuxCron(hour, out)
+	case windows:
+		return installScheduledTask(hour, out)
 	default:
-		return outputError(out, errors.New("cron install is supported on macOS and Linux only"))
+		return outputError(out, errors.New("cron install is supported on macOS, Linux, and Windows only"))
 	}
 }
 
@@ -781,8 +1570,10 @@ func uninstallCron(out *output.Output) error {
 		return uninstallLaunchdCron(out)
 	case linux:
 		return uninstallLinuxCron(out)
+	case windows:
+		return uninstallScheduledTask(out)
 	default:
-		return outputError(out, errors.New("cron uninstall is supported on macOS and Linux only"))
+		return outputError(out, errors.New("cron uninstall is supported on macOS, Linux, and Windows only"))
 	}
 }
 
@@ -792,8 +1583,10 @@ func cronStatus(out *output.Output) error {
 		return launchdStatus(out)
 	case linux:
 		return linuxCronStatus(out)
+	case windows:
+		return scheduledTaskStatus(out)
 	default:
-		return outputError(out, errors.New("cron status is supported on macOS and Linux only"))
+		return outputError(out, errors.New("cron status is supported on macOS, Linux, and Windows only"))
 	}
 }
 
@@ -802,8 +1595,11 @@ func cronLogPath() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if runtime.GOOS == darwin {
+	switch runtime.GOOS {
+	case darwin:
 		return filepath.Join(home, "Library", "Logs", "dotpak", "backup.log"), nil
+	case windows:
+		return filepath.Join(home, "AppData", "Local", "dotpak", "backup.log"), nil
 	}
 	return filepath.Join(home, ".local", "share", "dotpak", "backup.log"), nil
 }
@@ -834,10 +1630,15 @@ func cronRun() error {
 
 	out := output.New(output.ModeQuiet, false)
 
-	b := backup.New(cfg, &backup.Options{IncludeSecrets: true}, out)
-	result, err := b.Run()
+	ctx, stop := interruptContext()
+	defer stop()
+	b := backup.New(cfg, &backup.Options{IncludeSecrets: true, Version: version}, output.NewTextSink(out))
+	result, err := b.RunContext(ctx)
 	if err != nil {
 		fmt.Fprintf(logFile, "error: %v\n", err)
+		if notifyErr := notify.Notify(cfg.Notifications, notify.Backup(&metadata.BackupResult{Error: err.Error()})); notifyErr != nil {
+			fmt.Fprintf(logFile, "notifications: %v\n", notifyErr)
+		}
 		return err
 	}
 
@@ -847,8 +1648,19 @@ func cronRun() error {
 		fmt.Fprintf(logFile, "error encoding result: %v\n", encErr)
 	}
 
+	if cfg.Backup.ResultWebhookURL != "" {
+		if hookErr := webhook.Post(
+			cfg.Backup.ResultWebhookURL, cfg.Backup.ResultWebhookSecret, "backup", result,
+		); hookErr != nil {
+			fmt.Fprintf(logFile, "webhook: %v\n", hookErr)
+		}
+	}
+	if notifyErr := notify.Notify(cfg.Notifications, notify.Backup(result)); notifyErr != nil {
+		fmt.Fprintf(logFile, "notifications: %v\n", notifyErr)
+	}
+
 	if !result.Success {
-		return errors.New(result.Error)
+		return errs.FromCode(result.ErrorCode, result.Error)
 	}
 
 	return nil
@@ -1196,13 +2008,7 @@ func xmlEscapeText(value string) (string, error) {
 }
 
 func shellQuote(value string) string {
-	if value == "" {
-		return "''"
-	}
-	if !strings.ContainsAny(value, " \t\n'\"\\$") {
-		return value
-	}
-	return "'" + strings.ReplaceAll(value, "'", "'\"'\"'") + "'"
+	return osutils.ShellQuote(value)
 }
 
 func readCrontab() (string, error) {
@@ -1259,59 +2065,6 @@ func filterDotpakCron(existing string) ([]string, bool) {
 	return filtered, removed
 }
 
-func findLatestBackup(backupDir string) string {
-	entries, err := os.ReadDir(backupDir)
-	if err != nil {
-		return ""
-	}
-
-	var archives []string
-	for _, entry := range entries {
-		name := entry.Name()
-		if isArchiveFile(name) {
-			archives = append(archives, filepath.Join(backupDir, name))
-		}
-	}
-
-	if len(archives) == 0 {
-		return ""
-	}
-
-	sort.Strings(archives)
-	return archives[len(archives)-1]
-}
-
-func isArchiveFile(name string) bool {
-	return strings.HasPrefix(name, "dotfiles") &&
-		(strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tar.gz.age") || strings.HasSuffix(name, ".tar.gz.gpg"))
-}
-
-func hasEncryptionExt(name string) bool {
-	return strings.HasSuffix(name, ".age") || strings.HasSuffix(name, ".gpg")
-}
-
-// extractTimestamp extracts and formats the timestamp from an archive filename.
-// Archive names have the format: dotfiles-YYYYMMDD_HHMMSS.tar.gz[.age|.gpg]
-// Example: dotfiles-20240115_143022.tar.gz -> "2024-01-15 14:30:22".
-func extractTimestamp(name string) string {
-	// minimum length: "dotfiles-" (9) + "YYYYMMDD_HHMMSS" (15) = 24
-	const prefixLen = 9 // len("dotfiles-")
-	const tsLen = 15    // len("YYYYMMDD_HHMMSS")
-	const minNameLen = prefixLen + tsLen
-
-	if len(name) < minNameLen {
-		return ""
-	}
-	ts := name[prefixLen : prefixLen+tsLen]
-	if len(ts) != tsLen {
-		return ts
-	}
-	// format: YYYYMMDD_HHMMSS -> YYYY-MM-DD HH:MM:SS
-	return fmt.Sprintf("%s-%s-%s %s:%s:%s",
-		ts[0:4], ts[4:6], ts[6:8], // year, Month, Day
-		ts[9:11], ts[11:13], ts[13:15]) // hour, Minute, Second
-}
-
 // formatSize wraps osutils.FormatSize for local use.
 func formatSize(size int64) string {
 	return osutils.FormatSize(size)
@@ -1321,6 +2074,10 @@ func getSampleConfig() string {
 	return `# Dotpak configuration file
 # See https://github.com/ospiem/dotpak for documentation
 
+# Merge shared configs first; this file's values override theirs and its
+# lists are appended. Relative paths are resolved from this file's directory.
+# include = ["~/dotfiles/dotpak-shared.toml", "./work.toml"]
+
 # Items to backup
 items = [
     # Shell
@@ -1387,6 +2144,18 @@ items = [
     ".claude/projects",
     ".codex/config.toml",
     ".codex/skills",
+    # Windows (relative to %USERPROFILE%; items missing on other systems are skipped)
+    "Documents/PowerShell/Microsoft.PowerShell_profile.ps1",
+    "Documents/PowerShell/profile.ps1",
+    "Documents/WindowsPowerShell/Microsoft.PowerShell_profile.ps1",
+    "Documents/WindowsPowerShell/profile.ps1",
+    ".wslconfig",
+    "AppData/Local/Packages/Microsoft.WindowsTerminal_8wekyb3d8bbwe/LocalState/settings.json",
+    "AppData/Local/Packages/Microsoft.WindowsTerminalPreview_8wekyb3d8bbwe/LocalState/settings.json",
+    "AppData/Local/Microsoft/Windows Terminal/settings.json",
+    "AppData/Roaming/Code/User/settings.json",
+    "AppData/Roaming/Code/User/keybindings.json",
+    "AppData/Local/nvim",
 ]
 
 # Sensitive items (only backed up with encryption)
@@ -1413,6 +2182,7 @@ sensitive = [
     ".zsh_history",
     ".bash_history",
     ".lesshst",
+    "AppData/Roaming/Microsoft/Windows/PowerShell/PSReadLine",
     # AI tools (auth/tokens)
     ".claude.json",
     ".codex/auth.json",
@@ -1423,22 +2193,134 @@ sensitive = [
 # Where to store backups
 backup_dir = "~/backups/dotfiles"
 
-# Number of backups to keep
+# Number of backups to keep (unless [retention] sets keep_* counts)
 max_backups = 7
 
-# Encryption: "age" | "gpg" | "none"
+# Encryption: "age" | "gpg" | "aes" | "none"
+# aes is built in (AES-256-GCM, no external tool) and takes its passphrase
+# from DOTPAK_PASSPHRASE or passphrase_file
 encryption = "none"
 
-# Path to age recipients file (for age encryption)
+# Archive format: "tar.gz" | "zip" (zip opens on machines without tar tooling)
+# format = "tar.gz"
+
+# Storage: "archive" writes one archive per backup; "objects" stores each file
+# content once under objects/ and each backup as a snapshot referencing them
+# (unencrypted only; see dotpak snapshot)
+# storage = "archive"
+
+# Path to age recipients file (for age encryption): age1 keys, ssh-ed25519
+# or ssh-rsa public keys, or age plugin recipients (age1yubikey1...), one per line
 # age_recipients = "~/.config/age/recipients.txt"
 
-# Path to age identity files (for age decryption)
+# With encryption = "none", still back up sensitive files, each encrypted on
+# its own to age_recipients as <path>.age inside the plain archive; restore
+# decrypts them when an identity is available
+# sensitive_encryption = "age"
+
+# Path to age identity files (for age decryption): age keys, SSH private
+# keys, or age plugin identities
 # age_identity_files = ["~/.config/age/keys.txt"]  # required for decrypting age backups
 
+# Also look for identities in well-known locations when decrypting:
+# ~/.config/dotpak/age/keys.txt, ~/.config/age/keys.txt, ~/.ssh/id_ed25519, ~/.ssh/id_rsa
+# age_identity_discovery = true
+
 # GPG recipient (for GPG encryption)
 # gpg_recipient = "your@email.com"
 
-# Exclude patterns
+# File whose first line is the passphrase (for aes encryption, unless
+# DOTPAK_PASSPHRASE is set)
+# passphrase_file = "~/.config/dotpak/passphrase"
+
+# Key sealed to this device for age encryption: "tpm" | "secure-enclave"
+# (set up with dotpak hardware-key init, which also adds a recovery recipient)
+# hardware_key = "tpm"
+
+# POST the result JSON of every backup/restore to this URL
+# result_webhook_url = "https://example.com/hooks/dotpak"
+# Sign payloads with HMAC-SHA256 (X-Dotpak-Signature: sha256=<hex>)
+# result_webhook_secret = "change-me"
+
+# Download cloud-synced files (iCloud Drive, OneDrive) that are only
+# placeholders on disk before archiving them, instead of skipping them
+# materialize_placeholders = true
+
+# Symlinks into the Nix store, like the configs home-manager links into
+# home, dangle on machines without Nix: "link" archives them as they are,
+# "skip" leaves them out, as Nix rebuilds them, and "dereference" archives
+# the files they point to (also backup --nix-symlinks)
+# nix_symlinks = "link"
+
+# Keep more than content and permissions, for faithful .ssh and .gnupg
+# restores: "xattrs" (extended attributes), "hardlinks" (files linked under
+# several names), "times" (modification times), and "owner" (user and group,
+# restored only as root)
+# preserve = ["xattrs", "times"]
+
+# Archive the aliases, functions, and environment variables of your
+# interactive shell; print them with
+# dotpak cat <archive> .dotpak-shell-snapshot.txt
+# Values assigned to names such as *TOKEN* or *PASSWORD* and passwords in
+# URLs are redacted, in definitions too; other secrets are archived as is.
+# shell_snapshot = true
+
+# Warn when an archive is more than N% larger or smaller than the average of
+# the last 5 backups (0 disables), optionally with a desktop notification
+# size_change_alert_percent = 50
+# size_change_alert_notify = true
+
+# Skip files larger than this (e.g. caches with videos), with a warning;
+# [[item]] tables can set their own max_file_size ("0" for no limit)
+# max_file_size = "100MB"
+
+# If backup_dir is on a network mount that is not available (NAS asleep),
+# retry for this long before failing, then write the backup to spool_dir;
+# the next backup that reaches backup_dir moves spooled backups there
+# destination_wait = "5m"
+# spool_dir = "~/.local/share/dotpak/spool"
+
+# After every N backups ("5"), or "daily" / "weekly", verify a random older
+# backup end to end (integrity HMAC, decryption, checksums against the
+# catalog) and report the result as a desktop notification
+# verify_schedule = "weekly"
+
+# Sign each archive with an HMAC keyed by a secret that stays on this machine
+# (created on first use), and refuse to restore archives that fail the check.
+# Protects against a tampered archive in a shared or network backup directory.
+# sign_archives = true
+# hmac_key_file = "~/.config/dotpak/hmac.key"
+
+# Require archives restored from a URL to carry a minisign signature
+# (<url>.minisig) made by one of these public keys
+# minisign_public_keys = ["RWQ...second line of your minisign.pub..."]
+
+# Upload every backup to a remote destination (skip with --no-upload).
+# Credentials come from the environment: AWS_ACCESS_KEY_ID and
+# AWS_SECRET_ACCESS_KEY for S3, DOTPAK_REMOTE_PASSWORD for WebDAV, and your
+# ssh keys or agent for SFTP.
+# [remote]
+# url = "s3://my-bucket/dotfiles"          # or sftp://user@host/path, https://dav.example.com/dotfiles
+# region = "eu-central-1"                  # S3 only
+# endpoint = "https://minio.example.com"   # S3-compatible services
+# username = "me"                          # WebDAV only
+# spool = true                             # keep backups that fail to upload for later
+
+# GFS-style retention, applied after each backup and by "dotpak prune";
+# replaces max_backups when any keep_* count is set
+# [retention]
+# keep_last = 3
+# keep_daily = 7
+# keep_weekly = 4
+# keep_monthly = 6
+# keep_pre_restore = 3   # pre-restore safety archives kept by prune (0 = all)
+
+# Exclude patterns, in gitignore syntax: "*.log" matches at any depth, "/test"
+# or ".config/nvim/undo" relative to home, "**" any directories, "cache/"
+# directories only, and "!pattern" keeps what an earlier pattern excluded.
+# Check a path with: dotpak config test-pattern <path>
+# Directories can also hold .dotpakignore files with exclusions for their
+# own content, applied after these patterns.
 [excludes]
 patterns = [
     # General
@@ -1450,6 +2332,7 @@ patterns = [
     ".DS_Store",
     "*.sock",
     "*.cache",
+    "*:Zone.Identifier",  # left by Windows Explorer when copying files into WSL
     # CI/CD and dev artifacts
     ".circleci",
     ".github",
@@ -1534,9 +2417,119 @@ patterns = [
 # Use with: dotpak backup --profile work
 # [profile.work]
 # extra_items = [".config/slack"]
+# [profile.work.backup]             # overrides [backup] for this profile
+# backup_dir = "~/work-backups"
+# encryption = "age"
+# age_recipients = "~/.config/dotpak/work-recipients.txt"
 
 # Hostname-specific settings (applied automatically)
 # [host.my-macbook]
+# aliases = ["my-macbook-wifi"]   # other hostnames of this machine
 # extra_items = [".config/work-specific"]
+
+# Host groups apply to every member, before its own [host] settings
+# [host-group.laptops]
+# members = ["my-macbook", "air"]
+# extra_items = [".config/powertop"]
+
+# Package managers whose installed packages are saved with each backup
+# (default: all installed ones), including the editor extensions of vscode
+# and jetbrains. Restore with: dotpak restore --packages npm
+# [packages]
+# managers = ["brew", "go", "npm", "cargo"]
+# skip = ["pip"]
+
+# Shell commands run in the home directory before and after backups and
+# restores, with DOTPAK_ARCHIVE, DOTPAK_RESULT (success|failure, post hooks
+# only), and DOTPAK_HOOK set. A failing hook aborts the operation, or with
+# on_failure = "warn" is only reported.
+# [hooks]
+# pre_backup = ["code --list-extensions > ~/.config/Code/extensions.txt"]
+# post_backup = ["notify-send dotpak \"backup: $DOTPAK_RESULT\""]
+# pre_restore = []
+# post_restore = []
+# on_failure = "abort"
+
+# Report backups nobody watches, such as scheduled ones: on = "failure"
+# (default) only reports failures, "always" successes too. The webhook gets a
+# Slack and Discord compatible JSON post; email is sent with STARTTLS, or
+# implicit TLS on port 465, and the password read from DOTPAK_SMTP_PASSWORD
+# or smtp_password_file.
+# [notifications]
+# on = "failure"
+# desktop = true
+# webhook_url = "https://hooks.slack.com/services/..."
+# email_to = ["me@example.com"]
+# email_from = "dotpak@example.com"
+# smtp_host = "smtp.example.com"
+# smtp_port = 587
+# smtp_username = "dotpak@example.com"
+# smtp_password_file = "~/.config/dotpak/smtp-password"
+
+# Log every run, with timestamps, to a file that is rotated to dotpak.log.1,
+# .2, ... past max_size. Level debug also logs what --verbose prints.
+# [logging]
+# file = "~/.local/share/dotpak/dotpak.log"
+# level = "info"      # debug|info|warn|error
+# max_size = "10MB"
+# max_files = 3
+
+# Check the syntax of restored config files after every restore, as
+# restore --validate does: zsh -n / bash -n on shell rc files, git config
+# --list on git configs, tmux source-file -n, and ssh -G. A file that does not
+# parse fails the restore. verify --in-container runs the same checks.
+# [validate]
+# after_restore = true
+# categories = ["shell", "git", "terminal", "ssh"]  # default: all
+
+# Modes of restored files: the archive's, without the bits of the current
+# umask with umask = true (or restore --umask). Files and directories whose
+# recorded mode locks their owner out, such as 000 files, get the default
+# modes; private categories lose group and other permissions.
+# [restore]
+# umask = true
+# default_file_mode = "0644"
+# default_dir_mode = "0755"
+# private_categories = ["ssh", "gpg"]
+
+# Replace strings in config files on restore, for a backup made by another
+# user or on another machine. The home directory the backup was made in is
+# always replaced by the one restored to (skip with --no-rewrite); map adds
+# other replacements. Every replacement is reported.
+# [rewrite]
+# files = [".gitconfig", ".zshrc", ".ssh/config"]  # default: common shell, git, ssh, and editor configs
+# [rewrite.map]
+# "/opt/homebrew" = "/home/linuxbrew/.linuxbrew"
+
+# In WSL, configs of the Windows side backed up with the Linux dotfiles,
+# relative to the Windows user profile (ignored elsewhere). They are archived
+# under wsl-windows/ and restored to the Windows side (restore --only wsl);
+# desktop.ini, Thumbs.db, and Zone.Identifier files are left out.
+[wsl]
+items = [
+    ".wslconfig",
+    "AppData/Local/Packages/Microsoft.WindowsTerminal_8wekyb3d8bbwe/LocalState/settings.json",
+    "AppData/Roaming/Code/User/settings.json",
+    "AppData/Roaming/Code/User/keybindings.json",
+    "Documents/PowerShell/Microsoft.PowerShell_profile.ps1",
+]
+# windows_home = "/mnt/c/Users/me"  # default: %USERPROFILE% from cmd.exe
+
+# Items with their own post-restore command, run after a restore that wrote
+# any file under path (skip with --no-post-restore), or their own
+# max_file_size
+# [[item]]
+# path = ".config/nvim"
+# post_restore = "nvim --headless '+Lazy! sync' +qa"
+# after = [".zshrc"]   # items or categories whose commands run first
+# [[item]]
+# path = ".config/obs-studio"
+# max_file_size = "10MB"
+
+# Restore categories for --only (restore, check-restore, diff, contents)
+# Prefixes extend the built-in category of the same name unless replace = true
+# [categories.work]
+# prefixes = [".config/work-tool"]
+# after = ["ssh"]   # restored after these categories
 `
 }
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/store"
 )
 
 func TestCheckFDAStatus(t *testing.T) {
@@ -97,35 +106,890 @@ func TestCronLogPath(t *testing.T) {
 	}
 }
 
-func TestExtractTimestamp(t *testing.T) {
+func TestLinuxCronStatus(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("linux-only test")
+	}
+	// just verify it doesn't panic when crontab may not exist
+}
+
+func TestWindowsCommandLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"plain", []string{`C:\dotpak\dotpak.exe`, "cron", "run"}, `C:\dotpak\dotpak.exe cron run`},
+		{"space", []string{`C:\Program Files\dotpak.exe`, "run"}, `"C:\Program Files\dotpak.exe" run`},
+		{"empty", []string{"a", ""}, `a ""`},
+		{"quote", []string{`say "hi"`}, `"say \"hi\""`},
+		{"trailing backslash", []string{`C:\My Dir\`}, `"C:\My Dir\\"`},
+		{"backslash before quote", []string{`a\"b c`}, `"a\\\"b c"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := windowsCommandLine(tt.args); got != tt.want {
+				t.Errorf("windowsCommandLine(%q) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduledTaskArgs(t *testing.T) {
+	t.Parallel()
+
+	args := scheduledTaskArgs(`C:\Program Files\dotpak\dotpak.exe`, 3)
+	want := []string{
+		"/Create", "/F", "/TN", scheduledTaskName, "/SC", "DAILY", "/ST", "03:00",
+		"/TR", `"C:\Program Files\dotpak\dotpak.exe" cron run`,
+	}
+	if !slices.Equal(args, want) {
+		t.Errorf("scheduledTaskArgs = %q, want %q", args, want)
+	}
+}
+
+func TestBackupStatus(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+
+	result, err := backupStatus(filepath.Join(dir, "missing"), now)
+	if err != nil {
+		t.Fatalf("backupStatus() error for a missing directory: %v", err)
+	}
+	if result.BackupCount != 0 || result.DaysSinceBackup != -1 {
+		t.Errorf("no backups: got count %d, days %d", result.BackupCount, result.DaysSinceBackup)
+	}
+
+	for _, name := range []string{"dotfiles-20260301_090000.tar.gz", "dotfiles-20260307_180000.tar.gz.age"} {
+		if err = os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err = backupStatus(dir, now)
+	if err != nil {
+		t.Fatalf("backupStatus() error: %v", err)
+	}
+	if result.BackupCount != 2 {
+		t.Errorf("BackupCount = %d, want 2", result.BackupCount)
+	}
+	if filepath.Base(result.LastBackup) != "dotfiles-20260307_180000.tar.gz.age" {
+		t.Errorf("LastBackup = %s, want the newest archive", result.LastBackup)
+	}
+	if result.DaysSinceBackup != 2 {
+		t.Errorf("DaysSinceBackup = %d, want 2", result.DaysSinceBackup)
+	}
+}
+
+func TestDotpakEnv(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := &config.Config{Backup: config.BackupConfig{BackupDir: filepath.Join(dir, "it's backups")}}
+	result, err := dotpakEnv(cfg, filepath.Join(dir, "config.toml"))
+	if err != nil {
+		t.Fatalf("dotpakEnv() error: %v", err)
+	}
+	if result.LatestArchive != "" || result.LogDir == "" || result.TempDir == "" {
+		t.Errorf("dotpakEnv() = %+v, want the directories and no latest archive", result)
+	}
+
+	if err = os.MkdirAll(cfg.Backup.BackupDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(cfg.Backup.BackupDir, "dotfiles-20260307_180000.tar.gz.age")
+	if err = os.WriteFile(archive, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = dotpakEnv(cfg, filepath.Join(dir, "config.toml")); err != nil || result.LatestArchive != archive {
+		t.Fatalf("dotpakEnv() = %+v, %v; want the latest archive %s", result, err, archive)
+	}
+
+	var buf bytes.Buffer
+	if err = writeEnv(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	want := "export DOTPAK_BACKUP_DIR='" + strings.ReplaceAll(cfg.Backup.BackupDir, "'", `'"'"'`) + "'\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("writeEnv() = %q, want it to contain %q", buf.String(), want)
+	}
+}
+
+func TestBackupStats(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	result, err := backupStats(filepath.Join(dir, "missing"))
+	if err != nil || result.Backups != 0 {
+		t.Fatalf("backupStats() of a missing directory = %+v, %v", result, err)
+	}
+
+	for name, size := range map[string]int{"dotfiles-20260301_090000.tar.gz": 10, "dotfiles-20260302_090000.tar.gz": 30} {
+		if err = os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta := &metadata.Metadata{
+		Stats:      metadata.Stats{FilesBackedUp: 1},
+		Files:      []metadata.CatalogEntry{{Path: ".zshrc", Size: 5}, {Path: ".vimrc", Size: 7}},
+		DurationMS: 1500,
+	}
+	if err = meta.Save(filepath.Join(dir, "dotfiles-20260302_090000.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = backupStats(dir)
+	if err != nil {
+		t.Fatalf("backupStats() error: %v", err)
+	}
+	if result.Backups != 2 || result.TotalSize != 40 {
+		t.Errorf("Backups = %d, TotalSize = %d, want 2, 40", result.Backups, result.TotalSize)
+	}
+	want := []metadata.StatsPoint{
+		{Archive: filepath.Join(dir, "dotfiles-20260301_090000.tar.gz"), Timestamp: "2026-03-01 09:00:00", Size: 10},
+		{Archive: filepath.Join(dir, "dotfiles-20260302_090000.tar.gz"), Timestamp: "2026-03-02 09:00:00", Size: 30,
+			Files: 2, ContentSize: 12, DurationMS: 1500},
+	}
+	if !slices.Equal(result.Series, want) {
+		t.Errorf("Series = %+v, want %+v", result.Series, want)
+	}
+}
+
+func TestAddHistory(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "dotfiles-20260302_090000.tar.gz")
+	meta := &metadata.Metadata{Files: []metadata.CatalogEntry{
+		{Path: ".config/nvim/init.lua", Size: 40}, {Path: ".zshrc", Size: 5},
+	}}
+	if err := meta.Save(metadata.GetMetadataPath(archive)); err != nil {
+		t.Fatal(err)
+	}
+	result := &metadata.StatsResult{BackupDir: dir, Series: []metadata.StatsPoint{{Archive: archive}}}
+	if err := addHistory(result, []string{".config/nvim", ".zshrc"}, 0); err != nil {
+		t.Fatalf("addHistory() error: %v", err)
+	}
+	want := []metadata.StatsItem{{Path: ".config/nvim", Files: 1, Size: 40}, {Path: ".zshrc", Files: 1, Size: 5}}
+	if result.History != nil || !slices.Equal(result.Items, want) {
+		t.Errorf("without history: %+v, %+v; want items from the catalog", result.History, result.Items)
+	}
+
+	err := metadata.AppendHistory(dir,
+		metadata.HistoryEntry{Timestamp: "2026-03-01 09:00:00", ArchiveSize: 100, DurationMS: 1000,
+			ContentSize: 50, Items: []metadata.ItemSize{{Path: ".zshrc", Files: 1, Size: 50}}},
+		metadata.HistoryEntry{Timestamp: "2026-03-02 09:00:00", ArchiveSize: 300, DurationMS: 3000,
+			ContentSize: 80, Items: []metadata.ItemSize{
+				{Path: ".config", Files: 2, Size: 60}, {Path: ".zshrc", Files: 1, Size: 20},
+			}},
+		metadata.HistoryEntry{Timestamp: "2026-03-03 09:00:00", ArchiveSize: 10, DurationMS: 2000,
+			ContentSize: 90, Incremental: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addHistory(result, nil, 0); err != nil {
+		t.Fatalf("addHistory() error: %v", err)
+	}
+	wantHistory := metadata.StatsHistory{Runs: 3, Since: "2026-03-01 09:00:00", AverageSize: 200,
+		AverageIncrementalSize: 10, AverageDurationMS: 2000, FirstContentSize: 50, ContentSize: 90}
+	if result.History == nil || *result.History != wantHistory {
+		t.Errorf("History = %+v, want %+v", result.History, wantHistory)
+	}
+	want = []metadata.StatsItem{
+		{Path: ".config", Files: 2, Size: 60, Growth: 60}, {Path: ".zshrc", Files: 1, Size: 20, Growth: -30},
+	}
+	if !slices.Equal(result.Items, want) {
+		t.Errorf("Items = %+v, want %+v", result.Items, want)
+	}
+
+	if err = addHistory(result, nil, 1); err != nil || result.History.Runs != 1 {
+		t.Errorf("addHistory(last 1) = %+v, %v; want one run", result.History, err)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	st := store.Open(dir)
+	result, err := listSnapshots(st)
+	if err != nil || len(result.Snapshots) != 0 {
+		t.Fatalf("listSnapshots() of an empty store = %+v, %v", result, err)
+	}
+
+	src := filepath.Join(dir, "zshrc")
+	if err = os.WriteFile(src, []byte("export A=1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	object, _, err := st.Put(src, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dotfiles-20260301_090000", "dotfiles-20260302_090000"} {
+		manifest := &store.Manifest{
+			Timestamp: "2026-03-02T09:00:00",
+			Entries:   []store.Entry{{Path: ".zshrc", Size: 10, SHA256: object}},
+		}
+		if err = st.Save(name, manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err = listSnapshots(st)
+	if err != nil {
+		t.Fatalf("listSnapshots() error: %v", err)
+	}
+	if len(result.Snapshots) != 2 || result.Snapshots[0].Name != "dotfiles-20260302_090000" {
+		t.Fatalf("Snapshots = %+v, want newest first", result.Snapshots)
+	}
+	want := metadata.SnapshotInfo{Name: "dotfiles-20260302_090000", Timestamp: "2026-03-02 09:00:00", Files: 1, Size: 10}
+	if result.Snapshots[0] != want {
+		t.Errorf("Snapshots[0] = %+v, want %+v", result.Snapshots[0], want)
+	}
+	if result.Objects != 1 || result.ObjectsSize == 0 {
+		t.Errorf("Objects = %d (%d bytes), want 1 shared object", result.Objects, result.ObjectsSize)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		values []int64
+		want   string
+	}{
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8}, "▁▂▃▄▅▆▇█"},
+		{[]int64{5, 5, 5}, "▁▁▁"},
+		{[]int64{0, 10, 80}, " ▁█"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := sparkline(tt.values); got != tt.want {
+			t.Errorf("sparkline(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestLargestIncrease(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		values      []int64
+		wantIndex   int
+		wantPercent float64
+	}{
+		{[]int64{100, 110, 400, 410}, 2, 263.6363636363636},
+		{[]int64{100, 0, 300}, 2, 200},
+		{[]int64{300, 200, 100}, 0, 0},
+	}
+	for _, tt := range tests {
+		index, percent := largestIncrease(tt.values)
+		if index != tt.wantIndex || percent != tt.wantPercent {
+			t.Errorf("largestIncrease(%v) = %d, %v, want %d, %v", tt.values, index, percent, tt.wantIndex, tt.wantPercent)
+		}
+	}
+}
+
+func TestFleetStatus(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.DefaultConfig()
+	cfg.Hosts["mbp"] = config.HostConfig{Aliases: []string{"mbp-wifi"}}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+
+	// newest first, as ListBackups returns them
+	backups := []metadata.BackupInfo{
+		metadata.NewBackupInfo("dotfiles-20260309_090000.tar.gz", 150),
+		metadata.NewBackupInfo("dotfiles-20260308_090000.tar.gz", 10),
+		metadata.NewBackupInfo("dotfiles-20260307_090000.tar.gz", 100),
+		metadata.NewBackupInfo("dotfiles-20260301_090000.tar.gz", 80),
+		metadata.NewBackupInfo("dotfiles-20260201_090000.tar.gz", 5),
+	}
+	backups[0].Hostname = "mbp-wifi"
+	backups[1].Hostname = "mbp"
+	backups[1].Parent = "dotfiles-20260307_090000.tar.gz"
+	backups[2].Hostname = "mbp"
+	backups[3].Hostname = "desktop"
+
+	result := fleetStatus(cfg, backups, 7, now)
+	if len(result.Hosts) != 3 {
+		t.Fatalf("got %d hosts, want 3: %+v", len(result.Hosts), result.Hosts)
+	}
+	desktop, mbp, unknown := result.Hosts[0], result.Hosts[1], result.Hosts[2]
+
+	if mbp.Hostname != "mbp" || mbp.BackupCount != 3 || mbp.DaysSinceBackup != 1 || mbp.Stale {
+		t.Errorf("mbp = %+v, want 3 backups, 1 day old, not stale", mbp)
+	}
+	if !slices.Equal(mbp.Sizes, []int64{100, 150}) || mbp.SizeChange != 50 {
+		t.Errorf("mbp sizes = %v, change %d, want [100 150] and +50", mbp.Sizes, mbp.SizeChange)
+	}
+	if desktop.Hostname != "desktop" || desktop.DaysSinceBackup != 9 || !desktop.Stale {
+		t.Errorf("desktop = %+v, want 9 days old and stale", desktop)
+	}
+	if unknown.Hostname != "unknown" || !unknown.Stale {
+		t.Errorf("unknown = %+v, want stale backups without metadata", unknown)
+	}
+	if result.StaleHosts != 2 {
+		t.Errorf("StaleHosts = %d, want 2", result.StaleHosts)
+	}
+}
+
+func TestMergeBackups(t *testing.T) {
+	t.Parallel()
+
+	local := []metadata.BackupInfo{
+		metadata.NewBackupInfo("/backups/dotfiles-20260309_090000.tar.gz", 150),
+		metadata.NewBackupInfo("/backups/dotfiles-20260301_090000.tar.gz", 80),
+	}
+	remote := []metadata.BackupInfo{
+		metadata.NewBackupInfo("dotfiles-20260305_090000.tar.gz.age", 120),
+		metadata.NewBackupInfo("dotfiles-20260301_090000.tar.gz", 80),
+	}
+
+	merged := mergeBackups(local, remote)
+	want := []struct{ archive, location string }{
+		{"/backups/dotfiles-20260309_090000.tar.gz", metadata.LocationLocal},
+		{"dotfiles-20260305_090000.tar.gz.age", metadata.LocationRemote},
+		{"/backups/dotfiles-20260301_090000.tar.gz", metadata.LocationBoth},
+	}
+	if len(merged) != len(want) {
+		t.Fatalf("got %d backups, want %d: %+v", len(merged), len(want), merged)
+	}
+	for i, w := range want {
+		if merged[i].Archive != w.archive || merged[i].Location != w.location {
+			t.Errorf("merged[%d] = %s (%s), want %s (%s)",
+				i, merged[i].Archive, merged[i].Location, w.archive, w.location)
+		}
+	}
+}
+
+func TestBackupFilter(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.DefaultConfig()
+	cfg.Hosts["mbp"] = config.HostConfig{Aliases: []string{"mbp-wifi"}}
+	backups := func() []metadata.BackupInfo {
+		b := []metadata.BackupInfo{
+			metadata.NewBackupInfo("dotfiles-20260309_090000.tar.gz.age", 150),
+			metadata.NewBackupInfo("dotfiles-20260305_090000.tar.gz", 10),
+			metadata.NewBackupInfo("dotfiles-20260301_090000.tar.gz.gpg", 100),
+		}
+		b[0].Hostname = "mbp-wifi"
+		b[1].Hostname = "mbp"
+		b[2].Hostname = "desktop"
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		filter backupFilter
+		want   int
+	}{
+		{"none", backupFilter{}, 3},
+		{"host with alias", backupFilter{host: "mbp"}, 2},
+		{"since", backupFilter{since: time.Date(2026, 3, 5, 0, 0, 0, 0, time.Local)}, 2},
+		{"encrypted only", backupFilter{encryptedOnly: true}, 2},
+		{"combined", backupFilter{host: "mbp", encryptedOnly: true}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.filter.apply(cfg, backups()); len(got) != tt.want {
+				t.Errorf("apply() = %d backups, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local), false},
+		{"7d", time.Date(2026, 3, 3, 12, 0, 0, 0, time.Local), false},
+		{"36h", time.Date(2026, 3, 9, 0, 0, 0, 0, time.Local), false},
+		{"last week", time.Time{}, true},
+		{"-2d", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateHosts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		hosts  map[string]config.HostConfig
+		groups map[string]config.HostGroup
+		want   int
+	}{
+		{"none", nil, nil, 0},
+		{
+			"valid",
+			map[string]config.HostConfig{"mbp": {Aliases: []string{"mbp-wifi"}}},
+			map[string]config.HostGroup{"laptops": {Members: []string{"mbp", "air"}}},
+			0,
+		},
+		{"group without members", nil, map[string]config.HostGroup{"laptops": {}}, 1},
+		{
+			"alias shadows host",
+			map[string]config.HostConfig{"mbp": {Aliases: []string{"air"}}, "air": {}},
+			nil,
+			1,
+		},
+		{
+			"alias claimed twice",
+			map[string]config.HostConfig{"mbp": {Aliases: []string{"x"}}, "air": {Aliases: []string{"x"}}},
+			nil,
+			1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.DefaultConfig()
+			cfg.Hosts = tt.hosts
+			cfg.HostGroups = tt.groups
+			if got := validateHosts(cfg); len(got) != tt.want {
+				t.Errorf("validateHosts() = %v, want %d issues", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	t.Parallel()
+
+	negative := -1
+	tests := []struct {
+		name   string
+		backup config.ProfileBackup
+		want   int
+	}{
+		{"no overrides", config.ProfileBackup{}, 0},
+		{"age with recipients", config.ProfileBackup{Encryption: "age", AgeRecipients: "~/keys/work.txt"}, 0},
+		{"age without recipients", config.ProfileBackup{Encryption: "age"}, 1},
+		{"gpg without recipient", config.ProfileBackup{Encryption: "gpg"}, 1},
+		{"unknown encryption", config.ProfileBackup{Encryption: "rot13"}, 1},
+		{"negative max_backups", config.ProfileBackup{MaxBackups: &negative}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.DefaultConfig()
+			cfg.Profiles = map[string]config.Profile{"work": {Backup: tt.backup}}
+			if got := validateProfiles(cfg); len(got) != tt.want {
+				t.Errorf("validateProfiles() = %v, want %d issues", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigResultWebhook(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"unset", "", false},
+		{"https", "https://example.com/hooks/dotpak", false},
+		{"http", "http://homeassistant.local:8123/api/webhook/dotpak", false},
+		{"missing scheme", "example.com/hook", true},
+		{"unsupported scheme", "ftp://example.com/hook", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Backup.BackupDir = t.TempDir()
+			cfg.Backup.ResultWebhookURL = tt.url
+
+			err := validateConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfigPackages(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
 		name     string
-		input    string
-		expected string
+		packages config.PackagesConfig
+		wantErr  bool
 	}{
-		{"normal", "dotfiles-20250115_143022.tar.gz", "2025-01-15 14:30:22"},
-		{"encrypted", "dotfiles-20250115_143022.tar.gz.age", "2025-01-15 14:30:22"},
-		{"too short", "dotfiles-.tar.gz", ""},
-		{"empty", "", ""},
+		{"unset", config.PackagesConfig{}, false},
+		{"known", config.PackagesConfig{Managers: []string{"brew", "npm"}, Skip: []string{"pip"}}, false},
+		{"unknown manager", config.PackagesConfig{Managers: []string{"homebrew"}}, true},
+		{"unknown skip", config.PackagesConfig{Skip: []string{"gem"}}, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := extractTimestamp(tt.input)
-			if result != tt.expected {
-				t.Errorf("extractTimestamp(%q) = %q, want %q", tt.input, result, tt.expected)
+			cfg := config.DefaultConfig()
+			cfg.Backup.BackupDir = t.TempDir()
+			cfg.Packages = tt.packages
+
+			err := validateConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestLinuxCronStatus(t *testing.T) {
+func TestValidateConfigFormat(t *testing.T) {
 	t.Parallel()
 
-	if runtime.GOOS != "linux" {
-		t.Skip("linux-only test")
+	for format, wantErr := range map[string]bool{"": false, "tar.gz": false, "zip": false, "7z": true} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Backup.Format = format
+
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with format %q error = %v, wantErr %v", format, err, wantErr)
+		}
+	}
+}
+
+func TestValidateConfigNixSymlinks(t *testing.T) {
+	t.Parallel()
+
+	for mode, wantErr := range map[string]bool{"": false, "link": false, "skip": false, "dereference": false, "follow": true} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Backup.NixSymlinks = mode
+
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with nix_symlinks %q error = %v, wantErr %v", mode, err, wantErr)
+		}
+	}
+}
+
+func TestValidateConfigWSLItems(t *testing.T) {
+	t.Parallel()
+
+	for item, wantErr := range map[string]bool{
+		"AppData/Roaming/Code/User/settings.json": false, ".wslconfig": false,
+		"/mnt/c/Users/me/.wslconfig": true, "~/.wslconfig": true, "../.wslconfig": true,
+	} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.WSL.Items = []string{item}
+
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with wsl.items %q error = %v, wantErr %v", item, err, wantErr)
+		}
+	}
+}
+
+func TestValidateConfigExcludes(t *testing.T) {
+	t.Parallel()
+
+	for pattern, wantErr := range map[string]bool{"*.log": false, "!/test/**": false, "[abc": true, "!cache[/": true} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Excludes.Patterns = []string{pattern}
+
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with pattern %q error = %v, wantErr %v", pattern, err, wantErr)
+		}
+	}
+}
+
+func TestValidateConfigOrder(t *testing.T) {
+	t.Parallel()
+
+	for after, wantErr := range map[string]bool{"gpg": false, ".zshrc": false, "nope": true, "editor": true} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.ItemConfigs = []config.ItemConfig{{Path: ".zshrc"}}
+		cfg.Categories = map[string]config.CategoryConfig{"shell": {After: []string{after}}}
+		if after == ".zshrc" {
+			cfg.Categories = nil
+			cfg.ItemConfigs = append(cfg.ItemConfigs, config.ItemConfig{Path: ".npmrc", After: []string{after}})
+		}
+
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with after %q error = %v, wantErr %v", after, err, wantErr)
+		}
+	}
+}
+
+func TestHomeRelPath(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, ".config", "nvim"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		arg     string
+		want    string
+		isDir   bool
+		wantErr bool
+	}{
+		{arg: ".zshrc", want: ".zshrc"},
+		{arg: ".config/nvim", want: filepath.Join(".config", "nvim"), isDir: true},
+		{arg: "~/.cache/", want: ".cache", isDir: true},
+		{arg: filepath.Join(home, ".gitconfig"), want: ".gitconfig"},
+		{arg: "../etc/passwd", wantErr: true},
+		{arg: filepath.Dir(home), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, isDir, err := homeRelPath(home, tt.arg)
+		if (err != nil) != tt.wantErr || got != tt.want || isDir != tt.isDir {
+			t.Errorf("homeRelPath(%q) = %q, %v, %v; want %q, %v", tt.arg, got, isDir, err, tt.want, tt.isDir)
+		}
+	}
+}
+
+func TestValidateConfigHooks(t *testing.T) {
+	t.Parallel()
+
+	for policy, wantErr := range map[string]bool{"": false, "abort": false, "warn": false, "ignore": true} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Hooks.OnFailure = policy
+
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with on_failure %q error = %v, wantErr %v", policy, err, wantErr)
+		}
+	}
+}
+
+func TestValidateConfigRewrite(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		rewrite config.RewriteConfig
+		wantErr bool
+	}{
+		{"none", config.RewriteConfig{}, false},
+		{"map and files", config.RewriteConfig{
+			Map:   map[string]string{"/opt/homebrew": "/usr/local"},
+			Files: []string{".zshrc", ".config/fish/**"},
+		}, false},
+		{"empty key", config.RewriteConfig{Map: map[string]string{"": "/usr/local"}}, true},
+		{"bad glob", config.RewriteConfig{Files: []string{".config/[fish"}}, true},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Rewrite = tt.rewrite
+
+		if err := validateConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("validateConfig() with %s rewrite error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateConfigStorage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		storage    string
+		encryption string
+		wantErr    bool
+	}{
+		{"", "none", false},
+		{"archive", "none", false},
+		{"objects", "none", false},
+		{"objects", "gpg", true},
+		{"blobs", "none", true},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Backup.Storage = tt.storage
+		cfg.Backup.Encryption = tt.encryption
+		cfg.Backup.GPGRecipient = "me@example.com"
+
+		if err := validateConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("validateConfig() with storage %q, encryption %q error = %v, wantErr %v",
+				tt.storage, tt.encryption, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateConfigHardwareKey(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct{ kind, encryption string }{{"yubikey", "age"}, {"tpm", "none"}} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Backup.Encryption = tt.encryption
+		cfg.Backup.HardwareKey = tt.kind
+
+		if err := validateConfig(cfg); err == nil {
+			t.Errorf("validateConfig() with hardware_key %q, encryption %q succeeded", tt.kind, tt.encryption)
+		}
+	}
+}
+
+func TestUseHardwareKey(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	original := "[backup]\nbackup_dir = \"" + dir + "\"\nencryption = \"none\" # for now\nage_identity_files = [\"~/keys.txt\"]\n"
+	edited, err := useHardwareKey([]byte(original), "tpm", "/keys/hardware-recipients.txt",
+		[]string{"/keys/hardware-tpm.txt", "/keys/hardware-tpm.txt.old"})
+	if err != nil {
+		t.Fatalf("useHardwareKey() error: %v", err)
+	}
+
+	path := filepath.Join(dir, "config.toml")
+	if err = os.WriteFile(path, edited, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("edited config does not load: %v\n%s", err, edited)
+	}
+	b := cfg.Backup
+	if b.Encryption != "age" || b.AgeRecipients != "/keys/hardware-recipients.txt" || b.HardwareKey != "tpm" {
+		t.Errorf("backup config = %+v", b)
+	}
+	want := []string{b.AgeIdentityFiles[0], "/keys/hardware-tpm.txt", "/keys/hardware-tpm.txt.old"}
+	if !slices.Equal(b.AgeIdentityFiles, want) {
+		t.Errorf("age_identity_files = %v, want the hardware identity and the replaced key appended", b.AgeIdentityFiles)
+	}
+}
+
+func TestRetiredKeyPath(t *testing.T) {
+	t.Parallel()
+
+	key := filepath.Join(t.TempDir(), "recovery-key.txt")
+	if got := retiredKeyPath(key); got != key+".old" {
+		t.Errorf("retiredKeyPath() = %q, want %q", got, key+".old")
+	}
+	for _, path := range []string{key + ".old", key + ".old.2"} {
+		if err := os.WriteFile(path, []byte("AGE-SECRET-KEY-1"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := retiredKeyPath(key); got != key+".old.3" {
+		t.Errorf("retiredKeyPath() with two replaced keys = %q, want %q", got, key+".old.3")
+	}
+}
+
+func TestKeptRecipients(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recipients := filepath.Join(dir, "hardware-recipients.txt")
+	laptop := filepath.Join(dir, "recipients.txt")
+	if err := os.WriteFile(laptop, []byte("# laptop\nage1laptop\n\nage1yubikey\nage1laptop\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	kept, err := keptRecipients(laptop, recipients)
+	if err != nil || !slices.Equal(kept, []string{"age1laptop", "age1yubikey"}) {
+		t.Errorf("keptRecipients() of another file = %q, %v; want all its recipients", kept, err)
+	}
+
+	// a second init replaces the hardware and recovery recipients of the
+	// first but keeps what the first kept
+	content := "# tpm key of this device: decrypts only here\nage1tpm\n# recovery key\nage1recovery\n" +
+		keptRecipientsHeader + "\nage1laptop\n"
+	if err = os.WriteFile(recipients, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kept, err = keptRecipients(recipients, recipients)
+	if err != nil || !slices.Equal(kept, []string{"age1laptop"}) {
+		t.Errorf("keptRecipients() of its own file = %q, %v; want only the kept section", kept, err)
+	}
+
+	for _, configured := range []string{"", filepath.Join(dir, "missing.txt")} {
+		if kept, err = keptRecipients(configured, recipients); err != nil || kept != nil {
+			t.Errorf("keptRecipients(%q) = %q, %v; want none", configured, kept, err)
+		}
+	}
+}
+
+func TestValidateConfigAES(t *testing.T) {
+	t.Parallel()
+	if os.Getenv(crypto.PassphraseEnv) != "" {
+		t.Skip(crypto.PassphraseEnv + " is set")
+	}
+
+	passphraseFile := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for file, wantErr := range map[string]bool{passphraseFile: false, "": true, passphraseFile + ".missing": true} {
+		cfg := config.DefaultConfig()
+		cfg.Backup.BackupDir = t.TempDir()
+		cfg.Backup.Encryption = "aes"
+		cfg.Backup.PassphraseFile = file
+
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with passphrase_file %q error = %v, wantErr %v", file, err, wantErr)
+		}
+	}
+}
+
+func TestTrustedKeys(t *testing.T) {
+	configured := "RWQBAgMEBQYHCAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	release := "RWQICQoLDA0ODwAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	cfg := config.DefaultConfig()
+	cfg.Backup.MinisignPublicKeys = []string{configured}
+
+	defer func(key string) { releaseKey = key }(releaseKey)
+	for _, tt := range []struct {
+		release string
+		want    []string
+	}{
+		{"", []string{"0807060504030201"}},
+		{release, []string{"0807060504030201", "0F0E0D0C0B0A0908"}},
+	} {
+		releaseKey = tt.release
+		keys, err := trustedKeys(cfg)
+		if err != nil {
+			t.Fatalf("trustedKeys() with release key %q error: %v", tt.release, err)
+		}
+		var ids []string
+		for _, key := range keys {
+			ids = append(ids, key.String())
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("trustedKeys() with release key %q = %v, want %v", tt.release, ids, tt.want)
+		}
+	}
+
+	releaseKey = "RWQnotakey"
+	if _, err := trustedKeys(cfg); err == nil {
+		t.Error("trustedKeys() with a malformed release key succeeded")
 	}
-	// just verify it doesn't panic when crontab may not exist
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+func manifestCmd() *cobra.Command {
+	var (
+		format     string
+		to         string
+		files      []string
+		skipVerify bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "manifest <archive>",
+		Short: "Print a manifest of a backup for external verification and dedup tools",
+		Long: `Print the path, type, mode, size, modification time, SHA-256, and symlink
+target of every entry of a backup, in a standard format that other tools read
+without knowing dotpak archives:
+
+  mtree   a BSD mtree(8) spec: "mtree -f spec -p dir" checks a restored or
+          exported tree against it (default)
+  bsdtar  libarchive's mtree dialect with a full path per line, as written
+          by "bsdtar --format=mtree"
+  json    a JSON document with an entry per file
+
+The archive is decrypted and, for an incremental backup, merged with its
+parents. The archive may be an http(s) URL, as for restore.
+
+Examples:
+  dotpak manifest backup.tar.gz.age > dotfiles.mtree
+  dotpak manifest backup.tar.gz --format json --to manifest.json
+  dotpak manifest backup.zip --format bsdtar --files '.config/nvim/**'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := getOutput()
+			if jsonOutput && to == "" {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+					"--json needs --to; use --format json for a JSON manifest"))
+			}
+			if err := restore.ValidateFilePatterns(files); err != nil {
+				return outputError(out, err)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			archivePath, err := resolveArchive(cfg, args[0], out)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			// progress would end up in a manifest printed to stdout
+			var w io.Writer = cmd.OutOrStdout()
+			var sink events.Sink = events.Discard
+			if to != "" {
+				file, createErr := os.Create(to)
+				if createErr != nil {
+					return outputError(out, createErr)
+				}
+				defer file.Close()
+				w, sink = file, output.NewTextSink(out)
+			}
+
+			opts := restore.ExportOptions{Format: format, Files: files, SkipIntegrityCheck: skipVerify}
+			result, err := restore.Manifest(cfg, archivePath, w, opts, sink)
+			if err != nil {
+				return outputError(out, err)
+			}
+			result.Output = to
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			if !result.Success {
+				if to != "" {
+					_ = os.Remove(to)
+				}
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			if to != "" {
+				out.Success("Wrote %s manifest of %d files to %s\n", result.Format, result.Files, to)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", restore.ManifestMtree,
+		"Manifest format: "+strings.Join(restore.ManifestFormats, "|"))
+	cmd.Flags().StringVar(&to, "to", "", "File to write instead of stdout")
+	cmd.Flags().StringSliceVar(&files, "files", nil, "List only files matching these globs (repeatable)")
+	cmd.Flags().BoolVar(&skipVerify, "skip-integrity-check", false,
+		"List even if the archive's integrity HMAC is missing or cannot be checked")
+
+	return cmd
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/migrate"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func migrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "migrate",
+		Aliases: []string{"migrate-home"},
+		Short:   "Move dotfiles, keys, and packages to a new machine",
+		Long: `Move a home directory to a new machine in two steps.
+
+On the old machine, export writes a bundle into a directory (such as a USB
+drive): a full encrypted backup including sensitive files, the package lists
+of the installed package managers, and a plan.
+
+On the new machine, import follows the plan in order:
+  1. packages    reinstall the packages of each package manager
+  2. configs     restore the configuration files
+  3. keys        restore the sensitive files (SSH, GPG, cloud credentials)
+  4. post-steps  run the post_restore commands of the restored items
+
+Progress is saved in the bundle after each step, so an interrupted import
+resumes at the step that failed. What is left to do by hand, such as
+packages that need root, ends up in a checklist shown by status.
+
+Examples:
+  dotpak migrate export /Volumes/USB/dotpak            # On the old machine
+  dotpak migrate import /Volumes/USB/dotpak --dry-run  # Preview on the new one
+  dotpak migrate import /Volumes/USB/dotpak
+  dotpak migrate status /Volumes/USB/dotpak`,
+	}
+	cmd.AddCommand(migrateExportCmd(), migrateImportCmd(), migrateStatusCmd())
+	return cmd
+}
+
+func migrateExportCmd() *cobra.Command {
+	var (
+		dryRun  bool
+		encrypt string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export <dir>",
+		Short: "Write a migration bundle on the old machine",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			opts := &backup.Options{DryRun: dryRun, EncryptionMethod: encrypt, Version: version}
+			result, err := migrate.Export(cfg, args[0], opts, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			if !jsonOutput && !dryRun {
+				out.Print("\nMigration bundle written to %s\n", args[0])
+				out.Print("On the new machine, install dotpak with its config and keys, then run:\n")
+				out.Print("  dotpak migrate import %s\n", args[0])
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the files that would be exported")
+	cmd.Flags().StringVar(&encrypt, "encrypt", "", "Encryption: age|gpg|aes (default: backup.encryption)")
+
+	return cmd
+}
+
+func migrateImportCmd() *cobra.Command {
+	var (
+		dryRun  bool
+		restart bool
+		jobs    int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import <dir>",
+		Short: "Restore a migration bundle on the new machine",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			opts := migrate.ImportOptions{DryRun: dryRun, Jobs: jobs, Restart: restart}
+			if !jsonOutput {
+				opts.Output = os.Stdout
+			}
+			result, err := migrate.Import(cfg, filepath.Clean(args[0]), opts, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			} else {
+				printMigrateStatus(result, out)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what each step would do")
+	cmd.Flags().BoolVar(&restart, "restart", false, "Run every step again, ignoring saved progress")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Parallel package installs")
+
+	return cmd
+}
+
+func migrateStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status <dir>",
+		Short: "Show the progress and checklist of a migration",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+
+			result, err := migrate.Status(filepath.Clean(args[0]))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			printMigrateStatus(result, out)
+			return nil
+		},
+	}
+}
+
+func printMigrateStatus(result *metadata.MigrateResult, out *output.Output) {
+	if len(result.Steps) == 0 {
+		return
+	}
+	out.Print("\nMigration of %s:\n", result.Archive)
+	for _, step := range result.Steps {
+		switch step.Status {
+		case migrate.StatusDone:
+			out.Success("  [x] %s\n", step.Name)
+		case migrate.StatusFailed:
+			out.Error("  [!] %s: %s\n", step.Name, step.Error)
+		default:
+			out.Print("  [ ] %s\n", step.Name)
+		}
+	}
+	if len(result.Checklist) > 0 {
+		out.Print("\nChecklist:\n")
+		for _, entry := range result.Checklist {
+			out.Print("  - %s\n", entry)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func pruneCmd() *cobra.Command {
+	var (
+		dryRun bool
+		policy struct{ last, daily, weekly, monthly, preRestore int }
+		wait   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old backups according to the retention policy",
+		Long: `Remove the backups the retention policy does not keep, metadata files whose
+archive is gone, and old pre-restore safety archives.
+
+The policy comes from the [retention] config section:
+
+  [retention]
+  keep_last = 3         # the newest 3 backups
+  keep_daily = 7        # the newest backup of each of the last 7 days with one
+  keep_weekly = 4       # ... of the last 4 weeks
+  keep_monthly = 6      # ... of the last 6 months
+  keep_pre_restore = 3  # pre-restore safety archives (0 keeps all)
+
+Without keep_* counts, the newest max_backups backups are kept. Flags override
+the config. Parents of kept incremental backups are always kept.
+
+Examples:
+  dotpak prune --dry-run                  # Show what would be removed
+  dotpak prune --keep-daily 7 --keep-weekly 4`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			flags := cmd.Flags()
+			overrides := []struct {
+				flag string
+				src  int
+				dst  *int
+			}{
+				{"keep-last", policy.last, &cfg.Retention.KeepLast},
+				{"keep-daily", policy.daily, &cfg.Retention.KeepDaily},
+				{"keep-weekly", policy.weekly, &cfg.Retention.KeepWeekly},
+				{"keep-monthly", policy.monthly, &cfg.Retention.KeepMonthly},
+				{"keep-pre-restore", policy.preRestore, &cfg.Retention.KeepPreRestore},
+			}
+			for _, o := range overrides {
+				if flags.Changed(o.flag) {
+					*o.dst = o.src
+				}
+			}
+			if err = validateRetention(cfg.Retention); err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := backup.Prune(cfg, backup.PruneOptions{DryRun: dryRun, PreRestore: true, Wait: wait},
+				output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+
+			printPruneResult(result, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be removed without removing it")
+	cmd.Flags().IntVar(&policy.last, "keep-last", 0, "Keep the newest N backups")
+	cmd.Flags().IntVar(&policy.daily, "keep-daily", 0, "Keep the newest backup of each of the last N days")
+	cmd.Flags().IntVar(&policy.weekly, "keep-weekly", 0, "Keep the newest backup of each of the last N weeks")
+	cmd.Flags().IntVar(&policy.monthly, "keep-monthly", 0, "Keep the newest backup of each of the last N months")
+	cmd.Flags().IntVar(&policy.preRestore, "keep-pre-restore", 0,
+		"Keep the newest N pre-restore safety archives (0 keeps all)")
+	cmd.Flags().BoolVar(&wait, "wait", false,
+		"Wait for another run using the backup directory to finish instead of failing")
+
+	return cmd
+}
+
+func printPruneResult(result *metadata.PruneResult, out *output.Output) {
+	verb := "Removed"
+	if result.DryRun {
+		verb = "Would remove"
+	}
+
+	for _, k := range result.Kept {
+		out.Verbose("  keep    %s (%s)\n", k.Archive, strings.Join(k.Reasons, ", "))
+	}
+	for _, name := range result.Removed {
+		out.Print("  remove  %s\n", name)
+	}
+	for _, name := range result.RemovedMetadata {
+		out.Print("  remove  %s (orphaned metadata)\n", name)
+	}
+	for _, name := range result.RemovedPreRestore {
+		out.Print("  remove  %s (pre-restore)\n", name)
+	}
+	for _, name := range result.RemovedSnapshots {
+		out.Print("  remove  %s (snapshot)\n", name)
+	}
+
+	var parts []string
+	for _, c := range []struct {
+		n    int
+		noun string
+	}{
+		{len(result.Removed), "backup"},
+		{len(result.RemovedMetadata), "orphaned metadata file"},
+		{len(result.RemovedPreRestore), "pre-restore archive"},
+		{len(result.RemovedSnapshots), "snapshot"},
+	} {
+		if c.n == 1 {
+			parts = append(parts, "1 "+c.noun)
+		} else if c.n > 1 {
+			parts = append(parts, fmt.Sprintf("%d %ss", c.n, c.noun))
+		}
+	}
+	if len(parts) == 0 {
+		out.Success("Nothing to prune (%d kept)\n", len(result.Kept))
+		return
+	}
+	out.Success("%s %s (%s freed); %d kept\n",
+		verb, strings.Join(parts, ", "), formatSize(result.FreedBytes), len(result.Kept))
+}
+
+// validateRetention rejects negative keep_* counts.
+func validateRetention(r config.RetentionConfig) error {
+	for _, c := range []struct {
+		name string
+		n    int
+	}{
+		{"keep_last", r.KeepLast},
+		{"keep_daily", r.KeepDaily},
+		{"keep_weekly", r.KeepWeekly},
+		{"keep_monthly", r.KeepMonthly},
+		{"keep_pre_restore", r.KeepPreRestore},
+	} {
+		if c.n < 0 {
+			return errs.Errorf(errs.ErrConfigInvalid, "retention.%s must be >= 0 (got %d)", c.name, c.n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+func rekeyCmd() *cobra.Command {
+	var (
+		encrypt      string
+		recipients   string
+		gpgRecipient string
+		skipVerify   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rekey <archive>",
+		Short: "Re-encrypt a backup to new recipients",
+		Long: `Re-encrypt an encrypted backup to the current recipients, e.g. after an age
+key is lost or rotated, or to move an aes archive to age.
+
+The archive is decrypted with the configured identities or passphrase and
+streamed into the new encryption, so the plaintext never reaches the disk.
+The new archive replaces the old one; it is renamed if --encrypt changes the
+method (.age, .gpg), and its metadata records the method and a new integrity
+HMAC. Remote copies of the old archive are not touched: upload the new one
+again.
+
+The recipients are those of backup.age_recipients or backup.gpg_recipient
+unless --recipients or --gpg-recipient is given. An archive that is the
+parent of incremental backups keeps its method, as they name it.
+
+Examples:
+  dotpak rekey ~/backups/dotfiles-20260101_120000.tar.gz.age --recipients new-recipients.txt
+  dotpak rekey ~/backups/dotfiles-20260101_120000.tar.gz.aes --encrypt age`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			switch encrypt {
+			case "", "age", "gpg":
+			default:
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+					"--encrypt must be age or gpg (got %q)", encrypt))
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			opts := restore.RekeyOptions{
+				Method:             encrypt,
+				RecipientsFile:     recipients,
+				GPGRecipient:       gpgRecipient,
+				SkipIntegrityCheck: skipVerify,
+			}
+			result, err := restore.Rekey(cfg, args[0], opts, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			if result.Previous != "" {
+				out.Print("Replaced %s; upload %s to remotes again\n", result.Previous, result.Archive)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&encrypt, "encrypt", "", "Encrypt with age or gpg (default: the method of the archive)")
+	cmd.Flags().StringVar(&recipients, "recipients", "", "age recipients file (default: backup.age_recipients)")
+	cmd.Flags().StringVar(&gpgRecipient, "gpg-recipient", "", "gpg key ID or email (default: backup.gpg_recipient)")
+	cmd.Flags().BoolVar(&skipVerify, "skip-integrity-check", false,
+		"Re-encrypt even if the archive's integrity HMAC is missing or cannot be checked")
+
+	return cmd
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/remote"
+)
+
+func newRemote(cfg *config.Config) (remote.Remote, error) {
+	if cfg.Remote.URL == "" {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "no remote configured (set url in the [remote] config section)")
+	}
+	return remote.New(cfg.Remote.URL, remote.Options{
+		Region:   cfg.Remote.Region,
+		Endpoint: cfg.Remote.Endpoint,
+		Username: cfg.Remote.Username,
+	})
+}
+
+// uploadBackup uploads a new archive and its metadata to the configured
+// remote and records the destination in result.
+func uploadBackup(cfg *config.Config, result *metadata.BackupResult, out *output.Output) error {
+	r, err := newRemote(cfg)
+	if err != nil {
+		return err
+	}
+
+	out.Print("Uploading to %s...\n", r)
+	if err = r.Upload(result.Archive); err != nil {
+		return fmt.Errorf("uploading %s: %w", filepath.Base(result.Archive), err)
+	}
+	if metaPath := metadata.GetMetadataPath(result.Archive); fileExists(metaPath) {
+		if err = r.Upload(metaPath); err != nil {
+			return fmt.Errorf("uploading %s: %w", filepath.Base(metaPath), err)
+		}
+	}
+	result.Remote = r.String()
+	out.Success("Uploaded %s\n", filepath.Base(result.Archive))
+	return nil
+}
+
+// uploadSpooled flags a new archive as pending upload and uploads it with
+// any archives still pending from earlier backups. If the remote cannot be
+// reached, the archive stays pending and result.PendingUpload is set rather
+// than failing the backup.
+func uploadSpooled(cfg *config.Config, result *metadata.BackupResult, out *output.Output) error {
+	r, err := newRemote(cfg)
+	if err != nil {
+		return err
+	}
+
+	// a backup spooled by backup_dir lives outside the backup directory
+	spoolCfg := *cfg
+	spoolCfg.Backup.BackupDir = filepath.Dir(result.Archive)
+	if err = backup.MarkPendingUpload(spoolCfg.Backup.BackupDir, result.Archive); err != nil {
+		return fmt.Errorf("flagging %s pending upload: %w", filepath.Base(result.Archive), err)
+	}
+	upload, err := backup.UploadPending(&spoolCfg, r, false, output.NewTextSink(out))
+	if err != nil {
+		return err
+	}
+	if slices.Contains(upload.Pending, filepath.Base(result.Archive)) {
+		result.PendingUpload = true
+		out.Warning("Upload to %s failed (%s); %d backups pending, retried by the next backup or dotpak upload-pending\n",
+			r, upload.Error, len(upload.Pending))
+		return nil
+	}
+	result.Remote = r.String()
+	out.Success("Uploaded %s\n", filepath.Base(result.Archive))
+	if !upload.Success {
+		out.Warning("%s\n", upload.Error)
+	}
+	return nil
+}
+
+// listRemoteBackups returns the archives on the configured remote, newest first.
+func listRemoteBackups(cfg *config.Config) ([]metadata.BackupInfo, error) {
+	r, err := newRemote(cfg)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := r.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", r, err)
+	}
+
+	backups := []metadata.BackupInfo{}
+	for i := len(objects) - 1; i >= 0; i-- {
+		if metadata.IsArchiveName(objects[i].Name) {
+			backups = append(backups, metadata.NewBackupInfo(objects[i].Name, objects[i].Size))
+		}
+	}
+	return backups, nil
+}
+
+// describeRemoteBackups fills in the details recorded in the metadata of
+// remote backups, downloading metadata files into the download cache.
+// Archives without metadata keep the details derived from their name, and
+// backups merged from the backup directory keep their local details.
+func describeRemoteBackups(cfg *config.Config, backups []metadata.BackupInfo) error {
+	r, err := newRemote(cfg)
+	if err != nil {
+		return err
+	}
+	cacheDir, err := osutils.DownloadDir()
+	if err != nil {
+		return fmt.Errorf("creating download cache: %w", err)
+	}
+	for i := range backups {
+		if backups[i].Location == metadata.LocationLocal || backups[i].Location == metadata.LocationBoth {
+			continue
+		}
+		meta, fetchErr := remote.FetchMetadata(r, backups[i].Archive, filepath.Join(cacheDir, "remote"))
+		if fetchErr != nil {
+			continue
+		}
+		backups[i].Hostname = meta.Hostname
+		backups[i].FileCount = meta.Stats.FilesBackedUp
+		backups[i].Parent = meta.Parent
+	}
+	return nil
+}
+
+// fetchRemoteArchive downloads the named archive (the newest one if name is
+// empty), along with any parents of an incremental backup, into the download
+// cache and returns its local path.
+func fetchRemoteArchive(cfg *config.Config, name string, out *output.Output) (string, error) {
+	r, err := newRemote(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		backups, listErr := listRemoteBackups(cfg)
+		if listErr != nil {
+			return "", listErr
+		}
+		if len(backups) == 0 {
+			return "", errs.Errorf(errs.ErrArchiveNotFound, "no backups found on %s", r)
+		}
+		name = backups[0].Archive
+	}
+
+	cacheDir, err := osutils.DownloadDir()
+	if err != nil {
+		return "", fmt.Errorf("creating download cache: %w", err)
+	}
+	out.Print("Downloading %s from %s...\n", name, r)
+	path, err := remote.Fetch(r, filepath.Base(name), filepath.Join(cacheDir, "remote"))
+	if err != nil {
+		return "", err
+	}
+	out.Verbose("Cached at %s\n", path)
+	return path, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+// scheduledTaskName is the Windows Task Scheduler task that runs daily
+// backups.
+const scheduledTaskName = `\dotpak\daily-backup`
+
+// scheduledTaskArgs returns the schtasks arguments that create or replace
+// the task running "dotpak cron run" daily at hour.
+func scheduledTaskArgs(execPath string, hour int) []string {
+	run := []string{execPath, "cron", "run"}
+	if configFile != "" {
+		run = []string{execPath, "--config", configFile, "cron", "run"}
+	}
+	return []string{
+		"/Create", "/F",
+		"/TN", scheduledTaskName,
+		"/SC", "DAILY",
+		"/ST", fmt.Sprintf("%02d:00", hour),
+		"/TR", windowsCommandLine(run),
+	}
+}
+
+// windowsCommandLine joins args into a command line that Windows programs
+// split back into the same arguments.
+func windowsCommandLine(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+			quoted = append(quoted, arg)
+			continue
+		}
+		var b strings.Builder
+		b.WriteByte('"')
+		backslashes := 0
+		for _, c := range arg {
+			switch c {
+			case '\\':
+				backslashes++
+				continue
+			case '"':
+				// backslashes before a quote are escaped, and the quote too
+				b.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+			default:
+				b.WriteString(strings.Repeat(`\`, backslashes))
+			}
+			backslashes = 0
+			b.WriteRune(c)
+		}
+		// backslashes before the closing quote are escaped
+		b.WriteString(strings.Repeat(`\`, 2*backslashes))
+		b.WriteByte('"')
+		quoted = append(quoted, b.String())
+	}
+	return strings.Join(quoted, " ")
+}
+
+func installScheduledTask(hour int, out *output.Output) error {
+	execPath, err := os.Executable()
+	if err != nil {
+		return outputError(out, fmt.Errorf("getting executable path: %w", err))
+	}
+
+	//nolint:gosec // g204: arguments are built from the executable path and config flag
+	if cmdOut, runErr := exec.Command("schtasks", scheduledTaskArgs(execPath, hour)...).CombinedOutput(); runErr != nil {
+		return outputError(out, fmt.Errorf("creating scheduled task: %s", strings.TrimSpace(string(cmdOut))))
+	}
+
+	logPath, _ := cronLogPath()
+	out.Success("Installed daily backup at %d:00\n", hour)
+	out.Print("Scheduled task: %s\n", scheduledTaskName)
+	out.Print("Log: %s\n", logPath)
+	return nil
+}
+
+func uninstallScheduledTask(out *output.Output) error {
+	if exec.Command("schtasks", "/Query", "/TN", scheduledTaskName).Run() != nil {
+		out.Warning("Scheduled task not installed\n")
+		return nil
+	}
+	if cmdOut, err := exec.Command("schtasks", "/Delete", "/F", "/TN", scheduledTaskName).CombinedOutput(); err != nil {
+		return outputError(out, fmt.Errorf("removing scheduled task: %s", strings.TrimSpace(string(cmdOut))))
+	}
+
+	out.Success("Uninstalled daily backup\n")
+	return nil
+}
+
+func scheduledTaskStatus(out *output.Output) error {
+	cmdOut, err := exec.Command("schtasks", "/Query", "/TN", scheduledTaskName, "/FO", "LIST").CombinedOutput()
+	if err != nil {
+		out.Print("Status: not installed\n")
+		out.Print("\nRun 'dotpak cron install' to set up scheduled backups\n")
+		return nil //nolint:nilerr // a missing task is a status, not a failure
+	}
+
+	out.Print("Status: installed\n")
+	out.Print("Scheduled task: %s\n", scheduledTaskName)
+	for line := range strings.SplitSeq(string(cmdOut), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && (key == "Next Run Time" || key == "Status") {
+			out.Print("%s: %s\n", key, strings.TrimSpace(value))
+		}
+	}
+	if logPath, logErr := cronLogPath(); logErr == nil {
+		out.Print("Log: %s\n", logPath)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+func searchCmd() *cobra.Command {
+	var (
+		content bool
+		profile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "search <pattern>",
+		Short: "Find which backups contain matching files",
+		Long: `Search the files of every backup in the backup directory, newest first, and
+report the backups with matches, e.g. to find the last backup that still had
+a config deleted since.
+
+A file matches if its path contains the pattern, ignoring case, or, for a
+pattern with glob characters (* ? [), if its path or name matches it. Names
+are read from the catalog in each backup's metadata where there is one, so
+archives are not decrypted.
+
+With --content, files with a line containing the pattern (as text, matching
+case) match too; every archive is then decrypted and read. Binary files are
+not searched.
+
+Examples:
+  dotpak search starship.toml
+  dotpak search '*.lua'
+  dotpak search --content 'alias ll='
+  dotpak search --json nvim | jq -r '.backups[].archive'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			cfg, err := loadConfig(profile)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := restore.Search(cfg, args[0], restore.SearchOptions{Content: content}, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			printSearch(result, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&content, "content", false, "Also search the lines of the files (decrypts every archive)")
+	cmd.Flags().StringVarP(&profile, "profile", "p", "", "Search the backup_dir of a profile")
+
+	return cmd
+}
+
+// printSearch prints the matching files of each backup.
+func printSearch(result *metadata.SearchResult, out *output.Output) {
+	found := 0
+	for _, b := range result.Backups {
+		if len(b.Matches) == 0 {
+			continue
+		}
+		found++
+		out.Info("%s (%s)\n", filepath.Base(b.Archive), b.Timestamp)
+		for _, m := range b.Matches {
+			switch {
+			case m.Lines > 1:
+				out.Print("  %s:%d: %s (+%d more lines)\n", m.Path, m.Line, m.Text, m.Lines-1)
+			case m.Lines == 1:
+				out.Print("  %s:%d: %s\n", m.Path, m.Line, m.Text)
+			default:
+				out.Print("  %s (%s)\n", m.Path, osutils.FormatSize(m.Size))
+			}
+		}
+	}
+	if found == 0 {
+		out.Print("No matches in %d backups\n", result.Searched)
+		return
+	}
+	out.Print("\nMatches in %d of %d backups\n", found, result.Searched)
+}
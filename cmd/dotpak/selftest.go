@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/notify"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+// runSelfTest verifies a random older backup if verify_schedule calls for
+// it, records the outcome in result, and reports it as a desktop
+// notification. A failed self-test does not fail the backup that just
+// succeeded.
+func runSelfTest(cfg *config.Config, result *metadata.BackupResult, out *output.Output) {
+	due, err := restore.SelfTestDue(cfg, time.Now())
+	if err != nil {
+		out.Warning("Backup self-test skipped: %v\n", err)
+		return
+	}
+	if !due {
+		return
+	}
+
+	verified, err := restore.SelfTest(cfg, result.Archive, output.NewTextSink(out))
+	if err != nil {
+		out.Warning("Backup self-test failed: %v\n", err)
+		return
+	}
+	if verified == nil {
+		out.Verbose("Backup self-test skipped: no older backup to verify\n")
+		return
+	}
+	result.SelfTest = verified
+
+	name := filepath.Base(verified.Archive)
+	title, message := "dotpak: backup self-test passed",
+		fmt.Sprintf("%s: %d files verified", name, verified.Files)
+	if !verified.Success {
+		title, message = "dotpak: backup self-test FAILED", fmt.Sprintf("%s: %s", name, verified.Error)
+		out.Warning("Backup self-test failed for %s: %s\n", name, verified.Error)
+	}
+	if notifyErr := notify.Send(title, message); notifyErr != nil {
+		out.Verbose("Failed to send notification: %v\n", notifyErr)
+	}
+}
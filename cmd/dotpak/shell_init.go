@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Prompt integration emitted by shell-init. Each refreshes the segment at
+// most every DOTPAK_PROMPT_INTERVAL seconds (default 60) from
+// `dotpak status --json`, so the prompt does not spawn dotpak on every
+// command.
+const (
+	zshPromptInit = `
+# dotpak prompt segment: add ${DOTPAK_PROMPT_SEGMENT} to PROMPT or RPROMPT
+typeset -g DOTPAK_PROMPT_SEGMENT=''
+typeset -gi _dotpak_prompt_checked=-1
+_dotpak_precmd() {
+  if (( _dotpak_prompt_checked >= 0 && SECONDS - _dotpak_prompt_checked < ${DOTPAK_PROMPT_INTERVAL:-60} )); then
+    return
+  fi
+  _dotpak_prompt_checked=$SECONDS
+  local json days
+  json=$(command dotpak status --json 2>/dev/null) || { DOTPAK_PROMPT_SEGMENT=''; return; }
+  days=${json#*\"days_since_backup\": }
+  days=${days%%[^0-9-]*}
+  case $days in
+    '') DOTPAK_PROMPT_SEGMENT='' ;;
+    -1) DOTPAK_PROMPT_SEGMENT='dotpak:never' ;;
+    *) DOTPAK_PROMPT_SEGMENT="dotpak:${days}d" ;;
+  esac
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook precmd _dotpak_precmd
+setopt prompt_subst
+`
+
+	bashPromptInit = `
+# dotpak prompt segment: add ${DOTPAK_PROMPT_SEGMENT} to PS1
+DOTPAK_PROMPT_SEGMENT=''
+_dotpak_prompt_checked=-1
+_dotpak_precmd() {
+  if (( _dotpak_prompt_checked >= 0 && SECONDS - _dotpak_prompt_checked < ${DOTPAK_PROMPT_INTERVAL:-60} )); then
+    return
+  fi
+  _dotpak_prompt_checked=$SECONDS
+  local json days
+  json=$(command dotpak status --json 2>/dev/null) || { DOTPAK_PROMPT_SEGMENT=''; return; }
+  days=${json#*\"days_since_backup\": }
+  days=${days%%[!0-9-]*}
+  case $days in
+    '') DOTPAK_PROMPT_SEGMENT='' ;;
+    -1) DOTPAK_PROMPT_SEGMENT='dotpak:never' ;;
+    *) DOTPAK_PROMPT_SEGMENT="dotpak:${days}d" ;;
+  esac
+}
+case ";${PROMPT_COMMAND:-};" in
+  *";_dotpak_precmd;"*) ;;
+  *) PROMPT_COMMAND="_dotpak_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;;
+esac
+`
+
+	fishPromptInit = `
+# dotpak prompt segment: call dotpak_prompt_segment from fish_prompt
+set -g _dotpak_prompt_checked 0
+set -g _dotpak_prompt_segment ''
+function dotpak_prompt_segment --description 'Days since the last dotpak backup'
+    set -l interval 60
+    set -q DOTPAK_PROMPT_INTERVAL; and set interval $DOTPAK_PROMPT_INTERVAL
+    set -l now (date +%s)
+    if test (math $now - $_dotpak_prompt_checked) -ge $interval
+        set -g _dotpak_prompt_checked $now
+        set -g _dotpak_prompt_segment ''
+        set -l days (command dotpak status --json 2>/dev/null | string match -r -g '"days_since_backup": (-?[0-9]+)')
+        if test "$days" = -1
+            set -g _dotpak_prompt_segment 'dotpak:never'
+        else if test -n "$days"
+            set -g _dotpak_prompt_segment "dotpak:"$days"d"
+        end
+    end
+    echo -n $_dotpak_prompt_segment
+end
+`
+)
+
+func shellInitCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "shell-init <zsh|bash|fish>",
+		Short: "Print shell integration (completion and prompt segment)",
+		Long: `Print shell code that sets up completion for dotpak and a prompt segment
+showing the days since the last backup ("dotpak:3d", or "dotpak:never").
+
+The segment is refreshed from "dotpak status --json" at most every
+DOTPAK_PROMPT_INTERVAL seconds (default 60).
+
+Setup:
+  zsh   ~/.zshrc:   eval "$(dotpak shell-init zsh)"     then RPROMPT='${DOTPAK_PROMPT_SEGMENT}'
+  bash  ~/.bashrc:  eval "$(dotpak shell-init bash)"    then PS1='${DOTPAK_PROMPT_SEGMENT} \w \$ '
+  fish  config.fish: dotpak shell-init fish | source    then call dotpak_prompt_segment in fish_prompt
+
+zsh completion needs compinit to have run before the eval line.`,
+		ValidArgs: []string{"zsh", "bash", "fish"},
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var buf bytes.Buffer
+			var prompt string
+			root := cmd.Root()
+
+			var err error
+			switch args[0] {
+			case "zsh":
+				err = root.GenZshCompletion(&buf)
+				prompt = zshPromptInit
+			case "bash":
+				err = root.GenBashCompletionV2(&buf, true)
+				prompt = bashPromptInit
+			case "fish":
+				err = root.GenFishCompletion(&buf, true)
+				prompt = fishPromptInit
+			}
+			if err != nil {
+				return fmt.Errorf("generating %s completion: %w", args[0], err)
+			}
+
+			buf.WriteString(prompt)
+			_, err = cmd.OutOrStdout().Write(buf.Bytes())
+			return err
+		},
+	}
+}
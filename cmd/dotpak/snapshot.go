@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/restore"
+	"github.com/ospiem/dotpak/internal/store"
+)
+
+func snapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "List and restore snapshots of the content-addressed store",
+		Long: `With backup.storage = "objects", backups are snapshots in a content-addressed
+store in the backup directory: each file content is stored once, compressed,
+under objects/<sha256>, and each snapshot is a manifest in snapshots/ that
+references them. Unchanged files cost nothing in later snapshots.
+
+Snapshots are pruned with the retention policy, like archives, and objects
+no remaining snapshot references are removed with them.`,
+	}
+	cmd.AddCommand(snapshotListCmd(), snapshotRestoreCmd())
+	return cmd
+}
+
+func snapshotListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List snapshots",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := listSnapshots(store.Open(cfg.Backup.BackupDir))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			printSnapshots(result, out)
+			return nil
+		},
+	}
+}
+
+// listSnapshots describes the snapshots of st, newest first.
+func listSnapshots(st *store.Store) (*metadata.SnapshotListResult, error) {
+	result := &metadata.SnapshotListResult{Success: true, Snapshots: []metadata.SnapshotInfo{}}
+
+	names, err := st.Names()
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshots: %w", err)
+	}
+	slices.Reverse(names)
+	for _, name := range names {
+		info := metadata.SnapshotInfo{Name: name}
+		if m, loadErr := st.Load(name); loadErr == nil {
+			info.Timestamp = strings.Replace(m.Timestamp, "T", " ", 1)
+			info.Hostname = m.Hostname
+			info.Files = len(m.Entries)
+			for _, e := range m.Entries {
+				info.Size += e.Size
+			}
+		}
+		result.Snapshots = append(result.Snapshots, info)
+	}
+
+	if result.Objects, result.ObjectsSize, err = st.ObjectsSize(); err != nil {
+		return nil, fmt.Errorf("reading objects: %w", err)
+	}
+	return result, nil
+}
+
+func printSnapshots(result *metadata.SnapshotListResult, out *output.Output) {
+	if len(result.Snapshots) == 0 {
+		out.Warning("No snapshots found\n")
+		return
+	}
+
+	out.Print("Available snapshots:\n\n")
+	var total int64
+	for _, s := range result.Snapshots {
+		out.Print("  %s\n", s.Name)
+		out.Print("    Files: %d, %s\n", s.Files, formatSize(s.Size))
+		if s.Hostname != "" {
+			out.Verbose("    Host: %s\n", s.Hostname)
+		}
+		total += s.Size
+	}
+	out.Print("\n%d snapshots of %s stored in %d objects, %s on disk\n",
+		len(result.Snapshots), formatSize(total), result.Objects, formatSize(result.ObjectsSize))
+}
+
+func snapshotRestoreCmd() *cobra.Command {
+	var (
+		dryRun    bool
+		force     bool
+		noBackup  bool
+		only      string
+		files     []string
+		noPost    bool
+		atomic    bool
+		noRewrite bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restore [snapshot]",
+		Short: "Restore dotfiles from a snapshot",
+		Long: `Restore dotfiles from a snapshot of the content-addressed store, by default
+the newest one. Every file is checked against its hash before anything is
+written, and the restore otherwise works like restore: a safety backup of the
+files it replaces, category and file filters, and post_restore commands.
+
+Examples:
+  dotpak snapshot restore                              # Newest snapshot
+  dotpak snapshot restore dotfiles-20260101_120000
+  dotpak snapshot restore --only shell,git --dry-run
+  dotpak snapshot restore --files '.config/nvim/**'`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			if err := restore.ValidateFilePatterns(files); err != nil {
+				return outputError(out, err)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			categories, err := parseCategories(cfg, only)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			var name string
+			if len(args) > 0 {
+				name = strings.TrimSuffix(args[0], ".json")
+			} else {
+				name, err = store.Open(cfg.Backup.BackupDir).Latest()
+				if err != nil {
+					return outputError(out, fmt.Errorf("reading snapshots: %w", err))
+				}
+				if name == "" {
+					return outputError(out, errs.Errorf(errs.ErrArchiveNotFound,
+						"no snapshots found in %s", cfg.Backup.BackupDir))
+				}
+				out.Print("Using latest snapshot: %s\n", name)
+			}
+
+			if !force && !dryRun && !jsonOutput {
+				out.Print("\nRestore from snapshot: %s\n", name)
+				if len(categories) > 0 {
+					out.Print("Categories: %s\n", strings.Join(categories, ", "))
+				}
+				if len(files) > 0 {
+					out.Print("Files: %s\n", strings.Join(files, ", "))
+				}
+				out.Print("\nContinue? [y/N] ")
+
+				var response string
+				_, _ = fmt.Scanln(&response)
+				if strings.ToLower(response) != "y" {
+					out.Print("Canceled.\n")
+					return nil
+				}
+			}
+
+			opts := &restore.Options{
+				DryRun:        dryRun,
+				Force:         force,
+				Categories:    categories,
+				Files:         files,
+				NoBackup:      noBackup,
+				NoPostRestore: noPost,
+				Transactional: atomic,
+				NoRewrite:     noRewrite,
+			}
+			result, err := restore.New(cfg, opts, output.NewTextSink(out)).RunSnapshot(name)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if !dryRun {
+				postResultWebhook(cfg, "restore", result, out)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview without changes")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Skip confirmations")
+	cmd.Flags().BoolVar(&noBackup, "no-backup", false, "Skip creating safety backup")
+	cmd.Flags().StringVar(&only, "only", "", "Categories to restore (comma-separated)")
+	cmd.Flags().StringSliceVar(&files, "files", nil,
+		"Restore only files matching these globs relative to home (repeatable, ** matches any directories)")
+	cmd.Flags().BoolVar(&noPost, "no-post-restore", false,
+		"Do not run the post_restore commands of restored [[item]] entries")
+	cmd.Flags().BoolVar(&atomic, "transactional", false,
+		"Extract to a staging directory and move files into place only if all succeed, rolling back otherwise")
+	cmd.Flags().BoolVar(&noRewrite, "no-rewrite", false,
+		"Restore config files unchanged instead of replacing the backup's home directory and the [rewrite] map")
+
+	return cmd
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func snapshotIndexCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot-index",
+		Short: "Record a hash index of all dotfiles in the home directory",
+		Long: `Record the path, mode, size, modification time, and SHA-256 of every dotfile
+in the home directory, whether the config backs it up or not, without
+archiving any content. The entries of the home directory whose name starts
+with "." are indexed with everything below them, except paths that match
+[excludes] patterns or .dotpakignore files and the backup directory. Files
+larger than 64 MiB are recorded without a hash.
+
+Indexes are saved in the indexes/ directory of the backup directory.
+"snapshot-index diff" compares one with the home directory as it is now, or
+with a later index, to show which dotfiles appeared or changed, and marks
+those no item backs up.
+
+Examples:
+  dotpak snapshot-index                          # Record an index
+  dotpak snapshot-index list
+  dotpak snapshot-index diff --since 30d         # Changes since last month
+  dotpak snapshot-index diff --since 30d --untracked
+  dotpak snapshot-index diff index-20260101_120000 index-20260201_120000`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := backup.SnapshotIndex(cfg, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(snapshotIndexListCmd(), snapshotIndexDiffCmd())
+	return cmd
+}
+
+func snapshotIndexListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List saved indexes",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := listIndexes(cfg.Backup.BackupDir)
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			if len(result.Indexes) == 0 {
+				out.Warning("No indexes found\n")
+				return nil
+			}
+			out.Print("Saved indexes:\n\n")
+			for _, idx := range result.Indexes {
+				out.Print("  %s  %d files, %s\n", idx.Name, idx.Files, formatSize(idx.Size))
+			}
+			return nil
+		},
+	}
+}
+
+// listIndexes describes the indexes saved in backupDir, newest first.
+func listIndexes(backupDir string) (*metadata.IndexListResult, error) {
+	result := &metadata.IndexListResult{Success: true, Indexes: []metadata.IndexInfo{}}
+	names, err := backup.IndexNames(backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading indexes: %w", err)
+	}
+	slices.Reverse(names)
+	for _, name := range names {
+		info := metadata.IndexInfo{Name: name}
+		if idx, loadErr := backup.LoadIndex(backupDir, name); loadErr == nil {
+			info.Timestamp = idx.Timestamp
+			info.Hostname = idx.Hostname
+			info.Files = len(idx.Entries)
+			for _, e := range idx.Entries {
+				info.Size += e.Size
+			}
+		}
+		result.Indexes = append(result.Indexes, info)
+	}
+	return result, nil
+}
+
+func snapshotIndexDiffCmd() *cobra.Command {
+	var (
+		since     string
+		untracked bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "diff [old-index [new-index]]",
+		Short: "Show the dotfiles that appeared, disappeared, or changed",
+		Long: `Compare a saved index with the home directory as it is now, or with a later
+index, and list the dotfiles added, removed, and modified in between. Files
+that no item or sensitive item backs up are marked, so that new dotfiles can
+be added to the backup set.
+
+Without an index, the newest one is compared; --since picks the newest index
+taken at or before a date (2006-01-02) or an age (36h, 30d) instead.`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+			if since != "" && len(args) > 0 {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid, "--since cannot be combined with an index name"))
+			}
+			sinceTime, err := parseSince(since, time.Now())
+			if err != nil {
+				return outputError(out, err)
+			}
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			dir := cfg.Backup.BackupDir
+
+			var oldName string
+			switch {
+			case len(args) > 0:
+				oldName = strings.TrimSuffix(args[0], ".json")
+			case since != "":
+				oldName, err = backup.IndexBefore(dir, sinceTime)
+			default:
+				oldName, err = backup.IndexBefore(dir, time.Now())
+			}
+			if err != nil {
+				return outputError(out, fmt.Errorf("reading indexes: %w", err))
+			}
+			if oldName == "" && since != "" {
+				return outputError(out, errs.Errorf(errs.ErrArchiveNotFound,
+					"no index taken before %s in %s", sinceTime.Format(time.DateTime), dir))
+			}
+			if oldName == "" {
+				return outputError(out, errs.Errorf(errs.ErrArchiveNotFound,
+					"no index found in %s; record one with dotpak snapshot-index", dir))
+			}
+			old, err := backup.LoadIndex(dir, oldName)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			var cur *backup.Index
+			var newName string
+			if len(args) == 2 {
+				newName = strings.TrimSuffix(args[1], ".json")
+				cur, err = backup.LoadIndex(dir, newName)
+			} else {
+				cur, err = backup.TakeIndex(cfg, output.NewTextSink(out))
+			}
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result := backup.DiffIndexes(cfg, old, cur)
+			result.Old, result.New = oldName, newName
+			if untracked {
+				result.Added = untrackedChanges(result.Added)
+				result.Modified = untrackedChanges(result.Modified)
+				result.Removed = untrackedChanges(result.Removed)
+			}
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			printIndexDiff(result, old.Timestamp, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&since, "since", "",
+		"Compare with the newest index taken at or before a date (2006-01-02) or an age (30d)")
+	cmd.Flags().BoolVar(&untracked, "untracked", false, "Show only files that no item backs up")
+
+	return cmd
+}
+
+func untrackedChanges(changes []metadata.IndexChange) []metadata.IndexChange {
+	return slices.DeleteFunc(changes, func(c metadata.IndexChange) bool { return c.Tracked })
+}
+
+func printIndexDiff(result *metadata.IndexDiffResult, oldTime string, out *output.Output) {
+	target := "now"
+	if result.New != "" {
+		target = result.New
+	}
+	out.Print("Changes from %s (%s) to %s:\n", result.Old, strings.Replace(oldTime, "T", " ", 1), target)
+
+	if len(result.Added)+len(result.Removed)+len(result.Modified) == 0 {
+		out.Success("\nNo dotfiles changed\n")
+		return
+	}
+
+	diffOut := output.NewDiffOutput(out)
+	section := func(title, marker string, changes []metadata.IndexChange, show func(string)) {
+		if len(changes) == 0 {
+			return
+		}
+		out.Print("\n%s (%d):\n", title, len(changes))
+		for _, c := range changes {
+			line := "  " + marker + " " + c.Path
+			if !c.Tracked && marker != "-" {
+				line += "  (not backed up)"
+			}
+			show(line)
+		}
+	}
+	section("Added", "+", result.Added, diffOut.Added)
+	section("Removed", "-", result.Removed, diffOut.Removed)
+	section("Modified", "~", result.Modified, diffOut.Changed)
+
+	untracked := 0
+	for _, c := range slices.Concat(result.Added, result.Modified) {
+		if !c.Tracked {
+			untracked++
+		}
+	}
+	if untracked > 0 {
+		out.Print("\n%d new or changed dotfiles are not backed up; add them with dotpak config add-item\n", untracked)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+// sparkBars are the bars of a sparkline, lowest first.
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// statsTopItems is the number of largest items stats prints without
+// --by-item.
+const statsTopItems = 5
+
+func statsCmd() *cobra.Command {
+	var (
+		trend  bool
+		byItem bool
+		last   int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show size, file count, and duration of the retained backups",
+		Long: `Summarize the backups in the backup directory: their number, the space they
+take, and the size, file count, and duration of the newest one.
+
+With --trend, each of archive size, backed up content size, file count, and
+duration is plotted as a sparkline across the backups, oldest first, with the
+largest increase between two backups, so a regression such as a newly
+included cache directory stands out. File counts and content sizes come from
+the catalog in each backup's metadata; durations are only known for backups
+made by releases that record them.
+
+Each backup run is also recorded in history.jsonl in the backup directory,
+which prune leaves alone, so stats shows the average archive size and
+duration and the growth of the backed up content since the first recorded
+run, with the largest items. With --by-item, every item is listed with its
+files, size, and growth, to find what grows the archives; without a history
+yet, the breakdown comes from the catalogs of the retained backups.
+
+With --json, series lists every backup, oldest first, and history the
+summary of the recorded runs.
+
+Examples:
+  dotpak stats
+  dotpak stats --trend --last 30
+  dotpak stats --by-item
+  dotpak stats --json | jq '.series[] | [.timestamp, .size]'`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := backupStats(cfg.Backup.BackupDir)
+			if err != nil {
+				return outputError(out, err)
+			}
+			if last > 0 && len(result.Series) > last {
+				result.Series = result.Series[len(result.Series)-last:]
+			}
+			if err = addHistory(result, slices.Concat(cfg.Items, cfg.Sensitive), last); err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			printStats(result, out, !byItem)
+			if trend {
+				printTrend(result.Series, out)
+			}
+			if byItem {
+				printItems(result, out)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&trend, "trend", false, "Plot size, file count, and duration across backups")
+	cmd.Flags().BoolVar(&byItem, "by-item", false, "Break the newest backup down by item, with growth")
+	cmd.Flags().IntVar(&last, "last", 0, "Only include the newest N backups and runs (0 includes all)")
+
+	return cmd
+}
+
+// backupStats describes the backups in backupDir, oldest first. File counts
+// and content sizes come from each backup's catalog, or its stats if it has
+// none; backups without metadata only have an archive size. A missing
+// directory means there are no backups yet.
+func backupStats(backupDir string) (*metadata.StatsResult, error) {
+	result := &metadata.StatsResult{Success: true, BackupDir: backupDir, Series: []metadata.StatsPoint{}}
+
+	backups, err := metadata.ListBackups(backupDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+	slices.Reverse(backups)
+
+	for _, b := range backups {
+		point := metadata.StatsPoint{Archive: b.Archive, Timestamp: b.Timestamp, Size: b.Size}
+		if meta, loadErr := metadata.Load(metadata.GetMetadataPath(b.Archive)); loadErr == nil {
+			point.Files = meta.Stats.FilesBackedUp + meta.Stats.FilesUnchanged
+			point.ContentSize = meta.Stats.TotalSize
+			if len(meta.Files) > 0 {
+				point.Files = len(meta.Files)
+				point.ContentSize = 0
+				for _, entry := range meta.Files {
+					point.ContentSize += entry.Size
+				}
+			}
+			point.DurationMS = meta.DurationMS
+		}
+		result.Series = append(result.Series, point)
+		result.TotalSize += b.Size
+	}
+	result.Backups = len(result.Series)
+	return result, nil
+}
+
+// addHistory adds to result the summary of the runs in the history file of
+// its backup directory, the newest last ones if last is positive, and the
+// breakdown by item of the newest run, or of the newest backup's catalog if
+// no run recorded one. items are the items of the config, to which files are
+// attributed in catalogs.
+func addHistory(result *metadata.StatsResult, items []string, last int) error {
+	runs, err := metadata.LoadHistory(result.BackupDir)
+	if err != nil {
+		return fmt.Errorf("reading backup history: %w", err)
+	}
+	if last > 0 && len(runs) > last {
+		runs = runs[len(runs)-last:]
+	}
+	if len(runs) > 0 {
+		result.History = summarizeHistory(runs)
+	}
+
+	var first, newest []metadata.ItemSize
+	for _, run := range runs {
+		if len(run.Items) == 0 {
+			continue
+		}
+		if first == nil {
+			first = run.Items
+		}
+		newest = run.Items
+	}
+	if newest == nil && len(result.Series) > 0 {
+		first = catalogItems(result.Series[0].Archive, items)
+		newest = catalogItems(result.Series[len(result.Series)-1].Archive, items)
+	}
+	result.Items = itemGrowth(first, newest)
+	return nil
+}
+
+func summarizeHistory(runs []metadata.HistoryEntry) *metadata.StatsHistory {
+	h := &metadata.StatsHistory{
+		Runs:             len(runs),
+		Since:            runs[0].Timestamp,
+		FirstContentSize: runs[0].ContentSize,
+		ContentSize:      runs[len(runs)-1].ContentSize,
+	}
+	var full, incremental int64
+	for _, run := range runs {
+		if run.Incremental {
+			h.AverageIncrementalSize += run.ArchiveSize
+			incremental++
+		} else {
+			h.AverageSize += run.ArchiveSize
+			full++
+		}
+		h.AverageDurationMS += run.DurationMS
+	}
+	if full > 0 {
+		h.AverageSize /= full
+	}
+	if incremental > 0 {
+		h.AverageIncrementalSize /= incremental
+	}
+	h.AverageDurationMS /= int64(len(runs))
+	return h
+}
+
+// catalogItems breaks the catalog of archive down by item, or returns nil
+// if it has none.
+func catalogItems(archive string, items []string) []metadata.ItemSize {
+	meta, err := metadata.Load(metadata.GetMetadataPath(archive))
+	if err != nil || len(meta.Files) == 0 {
+		return nil
+	}
+	return metadata.ItemSizes(meta.Files, items)
+}
+
+// itemGrowth returns the items of newest, largest first, with their growth
+// since first.
+func itemGrowth(first, newest []metadata.ItemSize) []metadata.StatsItem {
+	before := make(map[string]int64, len(first))
+	for _, item := range first {
+		before[item.Path] = item.Size
+	}
+	result := make([]metadata.StatsItem, 0, len(newest))
+	for _, item := range newest {
+		result = append(result, metadata.StatsItem{
+			Path:   item.Path,
+			Files:  item.Files,
+			Size:   item.Size,
+			Growth: item.Size - before[item.Path],
+		})
+	}
+	return result
+}
+
+// signedSize formats a change in size with its sign.
+func signedSize(change int64) string {
+	if change < 0 {
+		return "-" + formatSize(-change)
+	}
+	return "+" + formatSize(change)
+}
+
+func printStats(result *metadata.StatsResult, out *output.Output, topItems bool) {
+	if result.Backups == 0 {
+		out.Warning("No backups found in %s\n", result.BackupDir)
+		return
+	}
+
+	out.Print("%d backups in %s, %s\n", result.Backups, result.BackupDir, formatSize(result.TotalSize))
+	if len(result.Series) == 0 {
+		return
+	}
+	newest := result.Series[len(result.Series)-1]
+	out.Print("Newest: %s, %s, %d files (%s)", newest.Timestamp, formatSize(newest.Size),
+		newest.Files, formatSize(newest.ContentSize))
+	if newest.DurationMS > 0 {
+		out.Print(", took %s", time.Duration(newest.DurationMS)*time.Millisecond)
+	}
+	out.Print("\n")
+
+	if h := result.History; h != nil {
+		out.Print("History: %d runs since %s, average full backup %s", h.Runs, h.Since, formatSize(h.AverageSize))
+		if h.AverageIncrementalSize > 0 {
+			out.Print(", incremental %s", formatSize(h.AverageIncrementalSize))
+		}
+		out.Print(", took %s on average\n", (time.Duration(h.AverageDurationMS) * time.Millisecond).Round(time.Millisecond))
+		if h.Runs > 1 {
+			out.Print("Content: %s -> %s (%s", formatSize(h.FirstContentSize), formatSize(h.ContentSize),
+				signedSize(h.ContentSize-h.FirstContentSize))
+			if h.FirstContentSize > 0 {
+				out.Print(", %+.0f%%", float64(h.ContentSize-h.FirstContentSize)*100/float64(h.FirstContentSize))
+			}
+			out.Print(")\n")
+		}
+	}
+	if topItems && len(result.Items) > 0 {
+		largest := make([]string, 0, statsTopItems)
+		for _, item := range result.Items[:min(len(result.Items), statsTopItems)] {
+			largest = append(largest, fmt.Sprintf("%s %s", item.Path, formatSize(item.Size)))
+		}
+		out.Print("Largest items: %s\n", strings.Join(largest, ", "))
+	}
+}
+
+func printItems(result *metadata.StatsResult, out *output.Output) {
+	if len(result.Items) == 0 {
+		out.Warning("\nNo catalog to break the newest backup down by item\n")
+		return
+	}
+	width := 0
+	for _, item := range result.Items {
+		width = max(width, len(item.Path))
+	}
+	out.Print("\nItems of the newest backup, largest first, with growth:\n")
+	for _, item := range result.Items {
+		out.Print("  %-*s  %10s  %6d files  %s\n", width, item.Path, formatSize(item.Size), item.Files,
+			signedSize(item.Growth))
+	}
+}
+
+// trendMetric is one plotted value of the backups in a stats series.
+type trendMetric struct {
+	name   string
+	value  func(metadata.StatsPoint) int64
+	format func(int64) string
+}
+
+var trendMetrics = []trendMetric{
+	{"size", func(p metadata.StatsPoint) int64 { return p.Size }, formatSize},
+	{"content", func(p metadata.StatsPoint) int64 { return p.ContentSize }, formatSize},
+	{"files", func(p metadata.StatsPoint) int64 { return int64(p.Files) }, func(n int64) string {
+		return fmt.Sprintf("%d", n)
+	}},
+	{"duration", func(p metadata.StatsPoint) int64 { return p.DurationMS }, func(ms int64) string {
+		return (time.Duration(ms) * time.Millisecond).Round(time.Millisecond).String()
+	}},
+}
+
+func printTrend(series []metadata.StatsPoint, out *output.Output) {
+	if len(series) < 2 {
+		return
+	}
+
+	out.Print("\nTrend over %d backups (%s to %s):\n", len(series), series[0].Timestamp, series[len(series)-1].Timestamp)
+	for _, m := range trendMetrics {
+		values := make([]int64, len(series))
+		for i, p := range series {
+			values[i] = m.value(p)
+		}
+		low, high := knownRange(values)
+		if high == 0 {
+			out.Print("  %-9s (not recorded)\n", m.name)
+			continue
+		}
+		newest := "unknown"
+		if v := values[len(values)-1]; v > 0 {
+			newest = m.format(v)
+		}
+		line := fmt.Sprintf("  %-9s %s  %s .. %s, newest %s", m.name, sparkline(values),
+			m.format(low), m.format(high), newest)
+		if i, pct := largestIncrease(values); i > 0 {
+			line += fmt.Sprintf("; largest increase +%.0f%% at %s", pct, series[i].Timestamp)
+		}
+		out.Print("%s\n", line)
+	}
+}
+
+// sparkline plots values, scaled between their smallest and largest known
+// value. Values of 0 are unknown and left blank.
+func sparkline(values []int64) string {
+	low, high := knownRange(values)
+	var b strings.Builder
+	for _, v := range values {
+		switch {
+		case v <= 0:
+			b.WriteRune(' ')
+		case high == low:
+			b.WriteRune(sparkBars[0])
+		default:
+			b.WriteRune(sparkBars[int((v-low)*int64(len(sparkBars)-1)/(high-low))])
+		}
+	}
+	return b.String()
+}
+
+// knownRange returns the smallest and largest positive value, or 0, 0 if
+// there is none.
+func knownRange(values []int64) (low, high int64) {
+	for _, v := range values {
+		if v <= 0 {
+			continue
+		}
+		if low == 0 || v < low {
+			low = v
+		}
+		high = max(high, v)
+	}
+	return low, high
+}
+
+// largestIncrease returns the index of the value that grew the most, in
+// percent, from the known value before it, and that percentage. The index
+// is 0 if no value grew.
+func largestIncrease(values []int64) (index int, percent float64) {
+	prev := int64(0)
+	for i, v := range values {
+		if v <= 0 {
+			continue
+		}
+		if prev > 0 && v > prev {
+			if p := float64(v-prev) * 100 / float64(prev); p > percent {
+				index, percent = i, p
+			}
+		}
+		prev = v
+	}
+	return index, percent
+}
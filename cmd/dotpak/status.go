@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func statusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show when the last backup was made",
+		Long: `Show the newest backup in the backup directory and how long ago it was made.
+
+With --json, days_since_backup is -1 if there are no backups. The shell-init
+prompt segment is built on this output.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := backupStatus(cfg.Backup.BackupDir, time.Now())
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				return out.JSON(result)
+			}
+			printStatus(result, out)
+			return nil
+		},
+	}
+}
+
+// backupStatus describes the backups in backupDir as of now. A missing
+// directory means there are no backups yet.
+func backupStatus(backupDir string, now time.Time) (*metadata.StatusResult, error) {
+	result := &metadata.StatusResult{Success: true, BackupDir: backupDir, DaysSinceBackup: -1}
+
+	backups, err := metadata.ListBackups(backupDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+	result.BackupCount = len(backups)
+	if len(backups) == 0 {
+		return result, nil
+	}
+
+	// ListBackups sorts newest first
+	latest := backups[0]
+	result.LastBackup = latest.Archive
+	result.Timestamp = latest.Timestamp
+	if created, parseErr := latest.CreatedAt(); parseErr == nil {
+		result.DaysSinceBackup = max(int(now.Sub(created).Hours()/24), 0)
+	}
+	return result, nil
+}
+
+func printStatus(result *metadata.StatusResult, out *output.Output) {
+	if result.LastBackup == "" {
+		out.Warning("No backups found in %s\n", result.BackupDir)
+		return
+	}
+
+	age := "today"
+	switch {
+	case result.DaysSinceBackup < 0:
+		age = "unknown age"
+	case result.DaysSinceBackup == 1:
+		age = "1 day ago"
+	case result.DaysSinceBackup > 1:
+		age = fmt.Sprintf("%d days ago", result.DaysSinceBackup)
+	}
+	out.Print("Last backup: %s (%s, %s)\n", filepath.Base(result.LastBackup), result.Timestamp, age)
+	out.Print("Backups:     %d in %s\n", result.BackupCount, result.BackupDir)
+}
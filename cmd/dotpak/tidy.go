@@ -0,0 +1,101 @@
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func tidyCmd() *cobra.Command {
+	var (
+		deleteJunk bool
+		olderThan  string
+		profile    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "tidy",
+		Short: "Find junk in the backed-up directories",
+		Long: `List the junk in the directories of the backup items, which the default
+[excludes] patterns leave out of backups and programs recreate as needed:
+
+  swap      editor swap and backup files (*.swp, *~, #*#)
+  compiled  compiled zsh and python files (*.zwc, *.pyc)
+  cache     caches (__pycache__, *.cache, .emacs.d/eln-cache)
+  os        files left by macOS and Windows (.DS_Store, *:Zone.Identifier)
+
+With --delete, the junk is deleted, which shrinks the home directory and the
+backups of configs that do not exclude it. Junk modified in the last day,
+such as the swap file of an open editor, is left; --older-than changes that.
+Sensitive items are not searched.
+
+Examples:
+  dotpak tidy                    # List the junk
+  dotpak tidy --delete           # Delete it
+  dotpak tidy --delete --older-than 30d`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			before, err := parseSince(olderThan, time.Now())
+			if err != nil {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+					"invalid --older-than %q (use an age like 7d or a date like 2006-01-02)", olderThan))
+			}
+
+			cfg, err := loadConfig(profile)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			opts := backup.TidyOptions{Delete: deleteJunk, Before: before}
+			result, err := backup.Tidy(cfg, opts, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+			printTidy(result, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&deleteJunk, "delete", false, "Delete the junk found")
+	cmd.Flags().StringVar(&olderThan, "older-than", "1d",
+		"Only junk not modified since this age (7d, 36h) or date (2006-01-02)")
+	cmd.Flags().StringVarP(&profile, "profile", "p", "", "Tidy the items of a profile")
+
+	return cmd
+}
+
+// printTidy lists the junk found or deleted.
+func printTidy(result *metadata.TidyResult, out *output.Output) {
+	for _, e := range result.Junk {
+		name := e.Path
+		if e.Dir {
+			name += "/"
+		}
+		out.Print("  %-9s %s (%s)\n", e.Kind, name, osutils.FormatSize(e.Size))
+	}
+	if result.Kept > 0 {
+		out.Print("Left %d junk entries modified since --older-than\n", result.Kept)
+	}
+	switch {
+	case len(result.Junk) == 0:
+		out.Success("No junk found\n")
+	case result.Deleted:
+		out.Success("Deleted %d junk files (%s)\n", result.Files, osutils.FormatSize(result.Size))
+	default:
+		out.Print("Found %d junk files (%s); delete them with dotpak tidy --delete\n",
+			result.Files, osutils.FormatSize(result.Size))
+	}
+}
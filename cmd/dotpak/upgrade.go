@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func upgradeBackupsCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "upgrade-backups",
+		Short: "Rewrite the metadata of old backups in the current format",
+		Long: `Rewrite the metadata files of backups made by earlier dotpak releases in the
+current schema, so later releases and other tools reading the .json files
+see the current field names.
+
+This release reads old metadata as it is, so upgrading is optional. Archives
+are not touched, and their integrity HMACs stay valid.
+
+Examples:
+  dotpak upgrade-backups --dry-run   # List the metadata files to rewrite
+  dotpak upgrade-backups`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := metadata.UpgradeDir(cfg.Backup.BackupDir, dryRun)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+
+			printUpgradeResult(result, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be rewritten without writing it")
+
+	return cmd
+}
+
+func printUpgradeResult(result *metadata.UpgradeResult, out *output.Output) {
+	for _, name := range result.Upgraded {
+		out.Print("  upgrade  %s\n", name)
+	}
+	if len(result.Upgraded) == 0 {
+		out.Success("All %d metadata files are current\n", result.Current)
+		return
+	}
+
+	verb := "Upgraded"
+	if result.DryRun {
+		verb = "Would upgrade"
+	}
+	out.Success("%s %d metadata files; %d already current\n", verb, len(result.Upgraded), result.Current)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+func uploadPendingCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "upload-pending",
+		Short: "Upload backups that failed to reach the remote",
+		Long: `Upload the backups flagged as pending upload, oldest first, then remove the
+backups on the remote that the retention policy does not keep.
+
+With spool = true in the [remote] config section, a backup that fails to
+upload, e.g. while offline, stays in the backup directory flagged as pending
+instead of failing. The next backup retries pending uploads; run this
+command to retry them sooner, e.g. from cron or a network hook. Local prune
+keeps pending backups until they are uploaded.
+
+Examples:
+  dotpak upload-pending --dry-run   # Show what would be uploaded
+  dotpak upload-pending`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out := getOutput()
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			r, err := newRemote(cfg)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			result, err := backup.UploadPending(cfg, r, dryRun, output.NewTextSink(out))
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				_ = out.JSON(result)
+			}
+
+			if !result.Success {
+				return errs.FromCode(result.ErrorCode, result.Error)
+			}
+
+			verb := "Uploaded"
+			if dryRun {
+				verb = "Would upload"
+			}
+			if len(result.Uploaded) == 0 {
+				out.Success("No backups pending upload\n")
+			} else {
+				out.Success("%s %d backups to %s\n", verb, len(result.Uploaded), result.Remote)
+			}
+			for _, name := range result.Pruned {
+				out.Print("  remove  %s (remote)\n", name)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be uploaded and removed")
+
+	return cmd
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// releaseKey is the minisign public key that release files are signed with,
+// embedded by release builds via -ldflags. Development builds have none.
+var releaseKey string
+
+func verifySignatureCmd() *cobra.Command {
+	var signature string
+
+	cmd := &cobra.Command{
+		Use:   "verify-signature <file>",
+		Short: "Check a file's minisign signature",
+		Long: `Check the minisign signature of a file, such as a downloaded dotpak release
+or archive, before running or restoring it. The signature is read from
+<file>.minisig unless --signature is given, and must be made by one of the
+keys in backup.minisign_public_keys or by the release key built into
+official dotpak releases.
+
+Use it when provisioning a machine from a script, so that a dotpak already
+installed vouches for the next one instead of trusting whatever the download
+returned:
+
+  curl -LO .../dotpak-v1.2.0-linux-amd64.tar.gz
+  curl -LO .../dotpak-v1.2.0-linux-amd64.tar.gz.minisig
+  dotpak verify-signature dotpak-v1.2.0-linux-amd64.tar.gz && tar xzf ...
+
+The command exits non-zero if the signature is missing or invalid.
+
+Examples:
+  dotpak verify-signature dotpak-v1.2.0-linux-amd64.tar.gz
+  dotpak verify-signature dotfiles.tar.gz.age --signature sig.minisig`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			out := getOutput()
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				return outputError(out, err)
+			}
+			keys, err := trustedKeys(cfg)
+			if err != nil {
+				return outputError(out, err)
+			}
+			if len(keys) == 0 {
+				return outputError(out, errs.Errorf(errs.ErrConfigInvalid,
+					"no trusted keys: set backup.minisign_public_keys (this build has no release key)"))
+			}
+
+			path := args[0]
+			if signature == "" {
+				signature = path + crypto.MinisignSuffix
+			}
+			sig, err := os.ReadFile(signature)
+			if err != nil {
+				return outputError(out, errs.Errorf(errs.ErrArchiveCorrupt, "reading signature: %w", err))
+			}
+			comment, err := crypto.VerifyMinisign(keys, path, sig)
+			if err != nil {
+				return outputError(out, err)
+			}
+
+			if jsonOutput {
+				return out.JSON(map[string]any{
+					"success":         true,
+					"file":            path,
+					"trusted_comment": comment,
+				})
+			}
+			out.Success("Signature verified: %s\n", comment)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&signature, "signature", "", "Signature file (default <file>.minisig)")
+
+	return cmd
+}
+
+// trustedKeys parses the configured minisign public keys and adds the
+// release key, if this build has one.
+func trustedKeys(cfg *config.Config) ([]crypto.MinisignKey, error) {
+	keys, err := minisignKeys(cfg)
+	if err != nil || releaseKey == "" {
+		return keys, err
+	}
+	key, err := crypto.ParseMinisignKey(releaseKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, key), nil
+}
@@ -2,16 +2,39 @@ package backup
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/metadata"
 )
 
-// createArchive creates a tar.gz archive from the collected files.
+// PartialSuffix is appended to the name of an archive while it is written,
+// so that a backup that is interrupted or killed never leaves an incomplete
+// archive that list and restore would take for a backup.
+const PartialSuffix = ".partial"
+
+// removePartialArchives removes the archives that backups killed before
+// they could clean up left in dir.
+func removePartialArchives(dir string, sink events.Sink) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "dotfiles-*"+PartialSuffix))
+	for _, path := range matches {
+		if err := os.Remove(path); err == nil {
+			events.Detail(sink, "Removed incomplete archive %s\n", filepath.Base(path))
+		}
+	}
+}
+
+// createArchive creates an archive in the configured format from the
+// collected files.
 func (b *Backup) createArchive(archivePath string, files []FileInfo) (err error) {
 	// create output file with restricted permissions
 	outFile, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
@@ -27,7 +50,7 @@ func (b *Backup) createArchive(archivePath string, files []FileInfo) (err error)
 	return b.writeArchive(outFile, files)
 }
 
-// createEncryptedArchive streams a tar.gz archive directly into the encryptor,
+// createEncryptedArchive streams an archive directly into the encryptor,
 // so that unencrypted data never touches disk.
 func (b *Backup) createEncryptedArchive(outputPath string, files []FileInfo, enc crypto.Encryptor) error {
 	pr, pw := io.Pipe()
@@ -49,53 +72,159 @@ func (b *Backup) createEncryptedArchive(outputPath string, files []FileInfo, enc
 	return <-errCh
 }
 
-// writeArchive writes a tar.gz stream to w from the collected files.
+// writeArchive writes a tar.gz stream, or a zip file for backup.format =
+// "zip", to w from the collected files. Small files are read ahead
+// concurrently while earlier ones are compressed. Progress is reported in
+// bytes against the sizes seen during collection.
 func (b *Backup) writeArchive(w io.Writer, files []FileInfo) (err error) {
-	// create gzip writer
-	gzWriter := gzip.NewWriter(w)
+	buffered := bufio.NewWriterSize(&countingWriter{w: w, n: &b.archiveWritten}, archiveBufferSize)
 	defer func() {
-		if cerr := gzWriter.Close(); cerr != nil && err == nil {
-			err = cerr
+		if ferr := buffered.Flush(); ferr != nil && err == nil {
+			err = ferr
 		}
 	}()
 
-	// create tar writer
-	tarWriter := tar.NewWriter(gzWriter)
-	defer func() {
-		if cerr := tarWriter.Close(); cerr != nil && err == nil {
-			err = cerr
+	var aw ArchiveWriter
+	if b.cfg.Backup.Format == "zip" {
+		zipWriter := NewZipWriter(buffered)
+		defer func() {
+			if cerr := zipWriter.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		aw = zipWriter
+	} else {
+		// create gzip writer
+		gzWriter := gzip.NewWriter(buffered)
+		defer func() {
+			if cerr := gzWriter.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+
+		// create tar writer
+		tarWriter := tar.NewWriter(gzWriter)
+		defer func() {
+			if cerr := tarWriter.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		aw = tarWriter
+	}
+
+	if b.manifest != nil {
+		if err = writeDataEntry(aw, metadata.ManifestName, b.manifest); err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
 		}
-	}()
+	}
+	if b.shellSnapshot != nil {
+		if err = writeDataEntry(aw, metadata.ShellSnapshotName, b.shellSnapshot); err != nil {
+			return fmt.Errorf("writing shell snapshot: %w", err)
+		}
+	}
+
+	var totalBytes, doneBytes int64
+	for _, f := range files {
+		totalBytes += f.Size
+	}
+	start := time.Now()
+	events.BytesDone(b.sink, "", 0, len(files), 0, totalBytes, 0)
+
+	if b.ctx != nil {
+		aw = &cancelableWriter{ArchiveWriter: aw, b: b}
+	}
 
-	// add each file
+	// add each file; every queued file must be consumed to stop readAhead
+	queue := readAhead(b.fsys(), files, &b.archiveRead)
 	for i, f := range files {
-		b.out.Progress(i+1, len(files), f.RelPath)
+		loaded := <-<-queue
+		if err = b.canceled(); err != nil {
+			continue // drain the queue so that readAhead finishes
+		}
+		events.FileStarted(b.sink, f.RelPath, i+1, len(files))
 
-		if addErr := AddFileToTar(tarWriter, f.FullPath, f.RelPath); addErr != nil {
-			b.out.Verbose("Failed to add %s: %v\n", f.RelPath, addErr)
-			continue
+		tw := aw
+		if b.preserve.Xattrs && b.cfg.Backup.Format != "zip" {
+			tw = &xattrWriter{ArchiveWriter: aw, fs: b.fsys(), path: f.FullPath}
+		}
+		var addErr error
+		switch target, linked := b.links[f.RelPath]; {
+		case linked:
+			addErr = writeLink(tw, f, target)
+		case f.Sensitive && b.sealer != nil:
+			addErr = writeSealed(b.fsys(), tw, f, loaded, b.sealer, &b.archiveRead)
+		default:
+			addErr = writePacked(b.fsys(), tw, f, loaded, &b.archiveRead)
+		}
+		events.FileDone(b.sink, f.RelPath, i+1, len(files), f.Size, addErr)
+		if addErr != nil {
+			events.Detail(b.sink, "Failed to add %s: %v\n", f.RelPath, addErr)
 		}
+		doneBytes += f.Size
+		events.BytesDone(b.sink, f.RelPath, i+1, len(files), doneBytes, totalBytes, time.Since(start))
 	}
 
-	b.out.ClearProgress()
-	return nil
+	return err
+}
+
+// cancelableWriter fails writes once the context of RunContext is done, so
+// that a large file does not hold up a canceled backup until it is archived.
+type cancelableWriter struct {
+	ArchiveWriter
+	b *Backup
+}
+
+func (w *cancelableWriter) Write(p []byte) (int, error) {
+	if err := w.b.canceled(); err != nil {
+		return 0, err
+	}
+	return w.ArchiveWriter.Write(p)
+}
+
+// countingWriter adds the bytes written to w to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// writeDataEntry writes an entry that describes the backup rather than
+// being one of its files, such as the metadata.ManifestName entry.
+func writeDataEntry(aw ArchiveWriter, name string, data []byte) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}
+	if err := aw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := aw.Write(data)
+	return err
 }
 
-// AddFileToTar adds a single file (or symlink) to a tar writer.
-func AddFileToTar(tw *tar.Writer, fullPath, relPath string) error {
+// AddFileToTar adds a single file (or symlink) of fs to a tar or zip writer.
+func AddFileToTar(fs fsys.FS, tw ArchiveWriter, fullPath, relPath string) error {
 	// use Lstat to detect symlinks without following them
-	info, err := os.Lstat(fullPath)
+	info, err := lstatRetry(fs, fullPath)
 	if err != nil {
 		return err
 	}
 
 	// handle symlinks
 	if info.Mode()&os.ModeSymlink != 0 {
-		linkTarget, readErr := os.Readlink(fullPath)
+		linkTarget, readErr := fs.Readlink(fullPath)
 		if readErr != nil {
 			return readErr
 		}
-		header, headerErr := tar.FileInfoHeader(info, linkTarget)
+		header, headerErr := tar.FileInfoHeader(info, filepath.ToSlash(linkTarget))
 		if headerErr != nil {
 			return headerErr
 		}
@@ -104,7 +233,7 @@ func AddFileToTar(tw *tar.Writer, fullPath, relPath string) error {
 	}
 
 	// regular file handling
-	file, err := os.Open(fullPath)
+	file, err := openRetry(fs, fullPath)
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,7 @@
 package backup
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -9,104 +10,284 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ospiem/dotpak/internal/config"
 	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/hooks"
+	"github.com/ospiem/dotpak/internal/lock"
 	"github.com/ospiem/dotpak/internal/metadata"
 	"github.com/ospiem/dotpak/internal/osutils"
-	"github.com/ospiem/dotpak/internal/output"
+	"github.com/ospiem/dotpak/internal/pkgmgr"
 )
 
 // Options holds backup options.
 type Options struct {
 	DryRun           bool
-	EncryptionMethod string // "age", "gpg", "none"
+	EncryptionMethod string // "age", "gpg", "aes", "none"
 	IncludeSecrets   bool
 	RecipientsFile   string
 	GPGRecipient     string
 	Estimate         bool
+	// MaterializePlaceholders downloads cloud-synced files whose contents
+	// are not stored locally (iCloud Drive, OneDrive) before archiving them.
+	MaterializePlaceholders bool
+	// SkipPlaceholders skips such files even if the config materializes them.
+	SkipPlaceholders bool
+	// NixSymlinks overrides backup.nix_symlinks: NixLink, NixSkip, or
+	// NixDereference.
+	NixSymlinks string
+	// ShellSnapshot saves the interactive shell's aliases, functions, and
+	// environment next to the archive.
+	ShellSnapshot bool
+	// Incremental archives only files whose content changed since the
+	// previous backup, which becomes the new backup's parent.
+	Incremental bool
+	// ProfileIO reports the time and IO of each phase in the result.
+	ProfileIO bool
+	// Jobs is the number of items collected in parallel; 0 means DefaultJobs.
+	Jobs int
+	// EncryptSensitive backs up sensitive files into an archive that is not
+	// encrypted, each encrypted on its own with age, as
+	// backup.sensitive_encryption = "age" does.
+	EncryptSensitive bool
+	// Profile is the name of the config profile in use and Version the
+	// dotpak release, both recorded in the metadata.
+	Profile string
+	Version string
+	// Wait waits for another run that holds the lock on the backup
+	// directory instead of failing.
+	Wait bool
 }
 
+// DefaultJobs is the number of items collected in parallel by default.
+const DefaultJobs = 4
+
 // Backup performs the backup operation.
 type Backup struct {
 	cfg     *config.Config
 	opts    *Options
-	out     *output.Output
+	sink    events.Sink
 	homeDir string
 	stats   metadata.Stats
+	// sizeLimits skip the files above max_file_size
+	sizeLimits config.FileSizeLimits
+	// preserve is backup.preserve, and links maps the archived files that
+	// are hard links to an earlier one to its RelPath.
+	preserve config.Preserve
+	links    map[string]string
+	// manifest is the metadata written as the first archive entry, and
+	// shellSnapshot the entry after it with backup.shell_snapshot.
+	manifest      []byte
+	shellSnapshot []byte
+	// snapshotHome is set by Import to the home directory that homeDir, a
+	// copy in an existing snapshot, was taken of.
+	snapshotHome string
+	// ctx stops a backup started with RunContext; nil for Run.
+	ctx context.Context
+
+	// sealer encrypts sensitive files on their own in an archive that is not
+	// encrypted; nil otherwise.
+	sealer *crypto.AgeEncryptor
+
+	placeholders []string
+	// nixStore is the Nix store directory that symlinks are checked
+	// against; empty archives them all as links.
+	nixStore string
+	// excludes are the [excludes] patterns, parsed on first use
+	excludes     []ignoreRule
+	excludesOnce sync.Once
+	// mu guards stats and placeholders while items are collected in parallel
+	mu sync.Mutex
+
+	// bytes read while hashing and archiving, bytes of the archive before
+	// encryption, and the per-phase IO measurements reported with
+	// Options.ProfileIO
+	hashRead       atomic.Int64
+	archiveRead    atomic.Int64
+	archiveWritten atomic.Int64
+	ioProfile      []metadata.IOPhase
+
+	// fs is the filesystem the collected files are hashed and archived
+	// from, nil for the OS one. Items are always collected from the OS;
+	// tests replace it to fail or cut short the reads.
+	fs fsys.FS
+}
+
+// fsys returns the filesystem the collected files are read from.
+func (b *Backup) fsys() fsys.FS {
+	if b.fs == nil {
+		return fsys.OS
+	}
+	return b.fs
 }
 
-// New creates a new Backup instance.
+// New creates a new Backup instance that reports progress to sink.
 // Returns nil if the home directory cannot be determined.
-func New(cfg *config.Config, opts *Options, out *output.Output) *Backup {
+func New(cfg *config.Config, opts *Options, sink events.Sink) *Backup {
 	home, err := osutils.HomeDir()
 	if err != nil {
-		out.Error("Cannot determine home directory: %v\n", err)
+		events.Error(sink, "Cannot determine home directory: %v\n", err)
 		return nil
 	}
 	return &Backup{
 		cfg:     cfg,
 		opts:    opts,
-		out:     out,
+		sink:    events.Synchronized(sink),
 		homeDir: home,
 	}
 }
 
-// Run executes the backup.
+// Run executes the backup between its pre_backup and post_backup hooks.
+// Hooks do not run for dry runs and estimates.
 func (b *Backup) Run() (*metadata.BackupResult, error) {
+	return b.RunContext(context.Background())
+}
+
+// RunContext is Run, stopped when ctx is done: between items while files
+// are collected and between files while the archive is written, which is
+// then removed. The result reports an ErrCanceled error.
+func (b *Backup) RunContext(ctx context.Context) (*metadata.BackupResult, error) {
+	if b != nil {
+		b.ctx = ctx
+	}
+	if b == nil || b.opts.DryRun || b.opts.Estimate {
+		return b.run()
+	}
+
+	dirEnv := "DOTPAK_BACKUP_DIR=" + b.cfg.Backup.BackupDir
+	ran, err := hooks.Run(b.cfg.Hooks, hooks.PreBackup, b.homeDir, []string{dirEnv}, b.sink)
+	if err != nil {
+		result := &metadata.BackupResult{Hooks: ran}
+		result.SetError(err)
+		return result, nil
+	}
+
+	result, err := b.run()
+	if err != nil {
+		return result, err
+	}
+	result.Hooks = append(ran, result.Hooks...)
+
+	env := append(hooks.ResultEnv(result.Archive, result.Success, result.Error), dirEnv)
+	ran, err = hooks.Run(b.cfg.Hooks, hooks.PostBackup, b.homeDir, env, b.sink)
+	result.Hooks = append(result.Hooks, ran...)
+	if err != nil && result.Success {
+		result.Success = false
+		result.SetError(err)
+	}
+	return result, nil
+}
+
+func (b *Backup) run() (*metadata.BackupResult, error) {
 	result := &metadata.BackupResult{
 		Success: false,
 	}
 
 	if b == nil {
-		result.Error = "backup not initialized (home directory error)"
+		result.SetError(errors.New("backup not initialized (home directory error)"))
 		return result, errors.New(result.Error)
 	}
+	began := time.Now()
 
-	if err := os.MkdirAll(b.cfg.Backup.BackupDir, 0700); err != nil {
-		errMsg := fmt.Sprintf("creating backup directory: %v", err)
-		if os.IsPermission(err) && runtime.GOOS == "darwin" {
-			execPath, _ := os.Executable()
-			resolvedPath, _ := filepath.EvalSymlinks(execPath)
-			if resolvedPath == "" {
-				resolvedPath = execPath
-			}
-			errMsg += fmt.Sprintf(
-				"\n\nFull Disk Access may be required. "+
-					"Add to System Settings → Privacy & Security → Full Disk Access:\n  %s",
-				resolvedPath,
-			)
+	if b.opts.DryRun || b.opts.Estimate {
+		if err := os.MkdirAll(b.cfg.Backup.BackupDir, 0700); err != nil {
+			result.SetError(fmt.Errorf("creating backup directory: %w%s", err, fullDiskAccessHint(err)))
+			return result, nil
 		}
-		result.Error = errMsg
-		return result, nil
+	} else {
+		cfg, err := b.openDestination()
+		if err != nil {
+			result.SetError(err)
+			return result, nil
+		}
+		result.Spooled = cfg != b.cfg
+		b.cfg = cfg
+
+		l, err := b.lock()
+		if err != nil {
+			result.SetError(err)
+			return result, nil
+		}
+		defer l.Release()
 	}
 
 	encMethod, recipientsFile, gpgRecipient, err := b.resolveEncryption()
 	if err != nil {
-		result.Error = err.Error()
+		result.SetError(err)
 		//nolint:nilerr // error captured in result.Error for structured JSON response
 		return result, nil
 	}
+	if encMethod != "" && b.cfg.Backup.Storage == StorageObjects {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid,
+			"backup.storage = %q stores files unencrypted; use --no-encrypt or encryption = \"none\"", StorageObjects))
+		return result, nil
+	}
+	if encMethod == "" {
+		if b.sealer, err = b.sensitiveEncryptor(); err != nil {
+			result.SetError(err)
+			return result, nil
+		}
+	}
 
-	b.out.Print("Collecting files...\n")
-	files := b.collectFiles(encMethod != "")
+	if b.sizeLimits, err = b.cfg.FileSizeLimits(); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	if b.preserve, err = b.cfg.Backup.ParsePreserve(); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	switch mode := b.nixSymlinks(); mode {
+	case NixLink, NixSkip, NixDereference:
+	default:
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid,
+			"backup.nix_symlinks must be link, skip, or dereference (got %q)", mode))
+		return result, nil
+	}
+
+	events.StartPhase(b.sink, events.PhaseCollect, "Collecting files...\n")
+	start := time.Now()
+	files := b.collectFiles(encMethod != "" || b.sealer != nil)
+	b.recordIO(string(events.PhaseCollect), start, len(files), 0, 0)
+	if err = b.canceled(); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	result.Placeholders = b.placeholders
+	b.reportNixLinks()
+	if b.stats.Materialized > 0 {
+		events.Info(b.sink, "Downloaded %d cloud placeholder files\n", b.stats.Materialized)
+	}
+	if b.stats.Placeholders > 0 {
+		hint := " (use --materialize to download them)"
+		if b.materializePlaceholders() {
+			hint = ""
+		}
+		events.Warning(b.sink, "Skipped %d cloud placeholder files not stored locally%s:\n  %s\n",
+			b.stats.Placeholders, hint, strings.Join(b.placeholders, "\n  "))
+	}
 
 	if len(files) == 0 {
-		result.Error = "no files to backup"
+		result.SetError(errs.Wrap(errs.ErrNothingToBackup, errors.New("no files to backup")))
 		return result, nil
 	}
 
-	b.out.Print("Found %d files to backup\n", len(files))
+	events.Info(b.sink, "Found %d files to backup\n", len(files))
 
 	if b.opts.Estimate {
 		var totalSize int64
 		for _, f := range files {
 			totalSize += f.Size
 		}
-		b.out.Print("\nEstimate:\n")
-		b.out.Print("  Files: %d\n", len(files))
-		b.out.Print("  Size: %s\n", formatSize(totalSize))
+		events.Info(b.sink, "\nEstimate:\n")
+		events.Info(b.sink, "  Files: %d\n", len(files))
+		events.Info(b.sink, "  Size: %s\n", formatSize(totalSize))
 
 		result.Success = true
 		result.Stats = b.stats
@@ -114,13 +295,16 @@ func (b *Backup) Run() (*metadata.BackupResult, error) {
 	}
 
 	if b.opts.DryRun {
-		b.out.Print("\nDry run - would backup:\n")
+		events.Info(b.sink, "\nDry run - would backup:\n")
 		for _, f := range files {
-			b.out.Print("  %s\n", f.RelPath)
+			events.Info(b.sink, "  %s\n", f.RelPath)
 		}
 
 		if encMethod != "" {
-			b.out.Print("\nWould encrypt with: %s\n", encMethod)
+			events.Info(b.sink, "\nWould encrypt with: %s\n", encMethod)
+		}
+		if b.sealer != nil {
+			events.Info(b.sink, "\nWould encrypt sensitive files with: age\n")
 		}
 
 		result.Success = true
@@ -130,82 +314,212 @@ func (b *Backup) Run() (*metadata.BackupResult, error) {
 		return result, nil
 	}
 
-	timestamp := time.Now().Format("20060102_150405")
-	archivePath := filepath.Join(b.cfg.Backup.BackupDir, fmt.Sprintf("dotfiles-%s.tar.gz", timestamp))
+	if b.cfg.Backup.Storage == StorageObjects {
+		if b.opts.ShellSnapshot || b.cfg.Backup.ShellSnapshot {
+			events.Warning(b.sink, "Shell snapshot not saved: backup.storage = %q has no archive to hold it\n",
+				StorageObjects)
+		}
+		return b.runSnapshot(result, files, began), nil
+	}
+
+	previousArchive := metadata.LatestBackup(b.cfg.Backup.BackupDir)
+	previousSizes := b.previousSizes()
 
+	start = time.Now()
+	b.hashFiles(files)
+	b.recordIO("hash", start, len(files), b.hashRead.Load(), 0)
+	archived := files
+	parent := ""
+	if b.opts.Incremental {
+		if base := b.incrementalBase(previousArchive); base != nil {
+			archived = changedFiles(files, base)
+			parent = filepath.Base(previousArchive)
+			b.stats.FilesUnchanged = len(files) - len(archived)
+			events.Info(b.sink, "Incremental backup on %s: %d changed, %d unchanged\n",
+				parent, len(archived), b.stats.FilesUnchanged)
+		}
+	}
+	if b.preserve.Hardlinks && b.cfg.Backup.Format != "zip" {
+		b.links = b.hardLinks(archived)
+	}
+
+	imported := b.snapshotHome != ""
+	created := time.Now()
+	meta := metadata.New()
+	meta.HomeDir = b.homeDir
+	if imported {
+		created = snapshotTime(files)
+		meta.Timestamp = created.Format("2006-01-02T15:04:05")
+		meta.HomeDir = b.snapshotHome
+		meta.ImportedFrom = b.homeDir
+	}
+	timestamp := created.Format("20060102_150405")
+	archivePath := filepath.Join(b.cfg.Backup.BackupDir, "dotfiles-"+timestamp+metadata.ArchiveExt(b.cfg.Backup.Format))
+	if imported {
+		if err = checkImportName(b.cfg.Backup.BackupDir, timestamp); err != nil {
+			result.SetError(err)
+			return result, nil
+		}
+	}
+
+	meta.Encrypted = encMethod != ""
+	meta.EncryptionMethod = encMethod
+	if b.sealer != nil {
+		meta.SensitiveEncryption = string(crypto.MethodAge)
+		meta.Features = append(meta.Features, metadata.FeatureSealed)
+		result.SensitiveEncryption = meta.SensitiveEncryption
+	}
+	if len(b.links) > 0 {
+		meta.Features = append(meta.Features, metadata.FeatureHardlinks)
+	}
+	meta.OSVersion = metadata.GetOSVersion()
+	meta.DotpakVersion = b.opts.Version
+	meta.Profile = b.opts.Profile
+	meta.Stats = b.stats
+	meta.Files = catalog(files)
+	meta.Parent = parent
+	if b.manifest, err = meta.Encode(); err != nil {
+		result.SetError(fmt.Errorf("encoding manifest: %w", err))
+		return result, nil
+	}
+	b.shellSnapshot = b.captureShellSnapshot()
+
+	removePartialArchives(b.cfg.Backup.BackupDir, b.sink)
+	start = time.Now()
 	var finalArchive string
 	if encMethod != "" {
-		b.out.Print("Creating encrypted archive with %s...\n", encMethod)
+		events.StartPhase(b.sink, events.PhaseArchive, "Creating encrypted archive with %s...\n", encMethod)
 
 		enc, encErr := crypto.NewEncryptor(crypto.Method(encMethod), crypto.Options{
 			AgeRecipientsFile: recipientsFile,
 			GPGRecipient:      gpgRecipient,
+			PassphraseFile:    b.cfg.Backup.PassphraseFile,
 		})
 		if encErr != nil {
-			result.Error = fmt.Sprintf("encryption failed: %v", encErr)
+			result.SetError(fmt.Errorf("encryption failed: %w", encErr))
 			return result, nil
 		}
 
 		encryptedPath := archivePath + "." + encMethod
-		if encErr = b.createEncryptedArchive(encryptedPath, files, enc); encErr != nil {
-			_ = os.Remove(encryptedPath)
-			result.Error = fmt.Sprintf("creating encrypted archive: %v", encErr)
+		partial := encryptedPath + PartialSuffix
+		if encErr = b.createEncryptedArchive(partial, archived, enc); encErr == nil {
+			encErr = os.Rename(partial, encryptedPath)
+		}
+		if encErr != nil {
+			_ = os.Remove(partial)
+			result.SetError(fmt.Errorf("creating encrypted archive: %w", encErr))
 			return result, nil
 		}
 		finalArchive = encryptedPath
 	} else {
-		b.out.Print("Creating archive: %s\n", filepath.Base(archivePath))
-		if err = b.createArchive(archivePath, files); err != nil {
-			errMsg := fmt.Sprintf("creating archive: %v", err)
-			if os.IsPermission(err) && runtime.GOOS == "darwin" {
-				execPath, _ := os.Executable()
-				resolvedPath, _ := filepath.EvalSymlinks(execPath)
-				if resolvedPath == "" {
-					resolvedPath = execPath
-				}
-				errMsg += fmt.Sprintf(
-					"\n\nFull Disk Access may be required. "+
-						"Add to System Settings → Privacy & Security → Full Disk Access:\n  %s",
-					resolvedPath,
-				)
-			}
-			result.Error = errMsg
+		events.StartPhase(b.sink, events.PhaseArchive, "Creating archive: %s\n", filepath.Base(archivePath))
+		partial := archivePath + PartialSuffix
+		if err = b.createArchive(partial, archived); err == nil {
+			err = os.Rename(partial, archivePath)
+		}
+		if err != nil {
+			_ = os.Remove(partial)
+			result.SetError(fmt.Errorf("creating archive: %w%s", err, fullDiskAccessHint(err)))
 			return result, nil
 		}
 		finalArchive = archivePath
 	}
-
-	meta := metadata.New()
-	meta.Encrypted = encMethod != ""
-	meta.EncryptionMethod = encMethod
-	meta.OSVersion = metadata.GetOSVersion()
+	var archiveSize int64
+	if info, statErr := os.Stat(finalArchive); statErr == nil {
+		archiveSize = info.Size()
+	}
+	b.recordIO(string(events.PhaseArchive), start, len(archived), b.archiveRead.Load(), archiveSize)
+	b.stats.BytesRead = b.archiveRead.Load()
+	b.stats.CompressedSize = b.archiveWritten.Load()
+	b.stats.ArchiveSize = archiveSize
+	if encMethod != "" {
+		b.stats.EncryptionOverhead = archiveSize - b.stats.CompressedSize
+	}
 	meta.Stats = b.stats
 
+	mac, err := b.signArchive(finalArchive, meta)
+	if err != nil {
+		_ = os.Remove(finalArchive)
+		result.SetError(fmt.Errorf("signing archive: %w", err))
+		return result, nil
+	}
+
+	meta.HMAC = mac
+	meta.DurationMS = time.Since(began).Milliseconds()
+	if parent == "" && !imported {
+		meta.SizeAlert = b.checkSizeChange(finalArchive, previousSizes)
+	}
+
 	metadataPath := metadata.GetMetadataPath(finalArchive)
 	if err = meta.Save(metadataPath); err != nil {
-		b.out.Warning("Failed to save metadata: %v\n", err)
+		if mac != "" {
+			// without its HMAC the archive would fail verification on restore
+			_ = os.Remove(finalArchive)
+			result.SetError(fmt.Errorf("saving metadata: %w", err))
+			return result, nil
+		}
+		events.Warning(b.sink, "Failed to save metadata: %v\n", err)
 	}
 
-	b.backupHomebrew()
-	b.backupMASApps()
-	b.backupAptPackages()
-	b.backupGoPackages()
-	b.cleanupOldBackups()
+	if !imported {
+		b.recordHistory(metadata.HistoryEntry{
+			Hostname:         meta.Hostname,
+			Archive:          filepath.Base(finalArchive),
+			Incremental:      parent != "",
+			EncryptionMethod: encMethod,
+			DurationMS:       meta.DurationMS,
+		}, meta.Files)
+
+		events.StartPhase(b.sink, events.PhasePackages, "")
+		start = time.Now()
+		pkgmgr.Snapshot(b.cfg.Packages, b.cfg.Backup.BackupDir, b.sink)
+		b.recordIO(string(events.PhasePackages), start, 0, 0, 0)
+
+		events.StartPhase(b.sink, events.PhaseCleanup, "")
+		b.cleanupOldBackups()
+	}
 
 	result.Success = true
 	result.Archive = finalArchive
 	result.Encrypted = meta.Encrypted
 	result.EncryptionMethod = meta.EncryptionMethod
+	result.Parent = parent
 	result.Stats = b.stats
+	result.SizeAlert = meta.SizeAlert
+	if b.opts.ProfileIO {
+		result.IOProfile = b.ioProfile
+	}
+	if previousArchive != "" && !imported {
+		if prev, loadErr := metadata.Load(metadata.GetMetadataPath(previousArchive)); loadErr == nil {
+			result.Delta = meta.Compare(prev)
+			result.Delta.Previous = previousArchive
+		}
+	}
 
-	b.out.Success("\nBackup complete: %s\n", filepath.Base(finalArchive))
-	b.out.Print("  Files: %d\n", b.stats.FilesBackedUp)
-	b.out.Print("  Skipped: %d\n", b.stats.FilesSkipped)
+	events.Success(b.sink, "\nBackup complete: %s\n", filepath.Base(finalArchive))
+	events.Info(b.sink, "  Files: %d\n", b.stats.FilesBackedUp)
+	events.Info(b.sink, "  Skipped: %d\n", b.stats.FilesSkipped)
+	if parent != "" {
+		events.Info(b.sink, "  Unchanged (in %s): %d\n", parent, b.stats.FilesUnchanged)
+	}
 	if b.stats.FilesExcluded > 0 {
-		b.out.Print("  Excluded: %d\n", b.stats.FilesExcluded)
+		events.Info(b.sink, "  Excluded: %d\n", b.stats.FilesExcluded)
+	}
+	if b.stats.FilesTooLarge > 0 {
+		events.Info(b.sink, "  Too large: %d\n", b.stats.FilesTooLarge)
 	}
 	if b.stats.SensitiveFiles > 0 {
-		b.out.Print("  Sensitive: %d\n", b.stats.SensitiveFiles)
+		events.Info(b.sink, "  Sensitive: %d\n", b.stats.SensitiveFiles)
+	}
+	if ratio := b.stats.CompressionRatio(); ratio > 0 {
+		events.Info(b.sink, "  Compressed: %s -> %s (%.2fx)\n",
+			formatSize(b.stats.BytesRead), formatSize(b.stats.CompressedSize), ratio)
+	}
+	if b.stats.EncryptionOverhead != 0 {
+		events.Info(b.sink, "  Encryption overhead: %s\n", formatSize(b.stats.EncryptionOverhead))
+	}
+	if result.Delta != nil {
+		events.Info(b.sink, "  Since previous backup: %s\n", result.Delta)
 	}
 
 	return result, nil
@@ -228,10 +542,18 @@ func (b *Backup) resolveEncryption() (method, recipientsFile, gpgRecipient strin
 			recipientsFile = b.cfg.Backup.AgeRecipients
 		}
 		if recipientsFile == "" {
-			return "", "", "", errors.New("age encryption requested but no recipients file specified")
+			return "", "", "", errs.Errorf(
+				errs.ErrEncryptionUnavailable, "age encryption requested but no recipients file specified",
+			)
 		}
 		if _, statErr := os.Stat(recipientsFile); statErr != nil {
-			return "", "", "", fmt.Errorf("age recipients file not found: %s", recipientsFile)
+			return "", "", "", errs.Errorf(errs.ErrEncryptionUnavailable, "age recipients file not found: %s", recipientsFile)
+		}
+		// age needs the plugin to encrypt to the hardware recipient
+		if kind := b.cfg.Backup.HardwareKey; kind != "" {
+			if hwErr := crypto.CheckHardware(kind); hwErr != nil {
+				return "", "", "", hwErr
+			}
 		}
 		return "age", recipientsFile, "", nil
 	}
@@ -242,44 +564,116 @@ func (b *Backup) resolveEncryption() (method, recipientsFile, gpgRecipient strin
 			gpgRecipient = b.cfg.Backup.GPGRecipient
 		}
 		if gpgRecipient == "" {
-			return "", "", "", errors.New("gpg encryption requested but no recipient specified")
+			return "", "", "", errs.Errorf(
+				errs.ErrEncryptionUnavailable, "gpg encryption requested but no recipient specified",
+			)
 		}
 		return "gpg", "", gpgRecipient, nil
 	}
 
-	return "", "", "", fmt.Errorf("unknown encryption method: %s", method)
+	if method == "aes" {
+		// fail before archiving rather than after
+		if _, passErr := crypto.ResolvePassphrase(b.cfg.Backup.PassphraseFile); passErr != nil {
+			return "", "", "", passErr
+		}
+		return "aes", "", "", nil
+	}
+
+	return "", "", "", errs.Errorf(errs.ErrConfigInvalid, "unknown encryption method: %s", method)
 }
 
-func (b *Backup) collectFiles(includeSecrets bool) []FileInfo {
-	var files []FileInfo
-	var totalSize int64
+// fullDiskAccessHint returns advice to append to permission errors on macOS,
+// where writing to protected locations requires Full Disk Access.
+func fullDiskAccessHint(err error) string {
+	if !os.IsPermission(err) || runtime.GOOS != "darwin" {
+		return ""
+	}
+	execPath, _ := os.Executable()
+	resolvedPath, _ := filepath.EvalSymlinks(execPath)
+	if resolvedPath == "" {
+		resolvedPath = execPath
+	}
+	return fmt.Sprintf(
+		"\n\nFull Disk Access may be required. "+
+			"Add to System Settings → Privacy & Security → Full Disk Access:\n  %s",
+		resolvedPath,
+	)
+}
 
+func (b *Backup) collectFiles(includeSecrets bool) []FileInfo {
+	type task struct {
+		path      string
+		sensitive bool
+		// windowsRoot is the Windows user profile of wsl.items.
+		windowsRoot string
+	}
+	b.nixStore = nixStoreDir()
+	var tasks []task
 	for _, item := range b.cfg.GetBackupItems() {
-		collected, err := b.collectItem(item.Path)
-		if err != nil {
-			b.out.Verbose("Skipping %s: %v\n", item.Path, err)
-			b.stats.FilesSkipped++
-			continue
+		tasks = append(tasks, task{path: item.Path})
+	}
+	if includeSecrets && b.opts.IncludeSecrets {
+		for _, item := range b.cfg.GetSensitiveItems() {
+			tasks = append(tasks, task{path: item.Path, sensitive: true})
 		}
-		for _, f := range collected {
-			totalSize += f.Size
+	}
+	if root := b.windowsRoot(); root != "" {
+		for _, item := range b.cfg.WSL.Items {
+			tasks = append(tasks, task{path: item, windowsRoot: root})
 		}
-		files = append(files, collected...)
 	}
 
-	if includeSecrets && b.opts.IncludeSecrets {
-		for _, item := range b.cfg.GetSensitiveItems() {
-			collected, err := b.collectItem(item.Path)
-			if err != nil {
-				b.out.Verbose("Skipping sensitive %s: %v\n", item.Path, err)
+	// walk items in parallel, then concatenate in config order so that the
+	// archive layout does not depend on scheduling
+	collected := make([][]FileInfo, len(tasks))
+	parallel(len(tasks), b.jobs(), func(i int) {
+		if b.canceled() != nil {
+			return
+		}
+		var files []FileInfo
+		var err error
+		if tasks[i].windowsRoot != "" {
+			files, err = b.collectWindowsItem(tasks[i].windowsRoot, tasks[i].path)
+		} else {
+			files, err = b.collectItem(tasks[i].path)
+		}
+		switch {
+		case err != nil && tasks[i].sensitive:
+			events.Detail(b.sink, "Skipping sensitive %s: %v\n", tasks[i].path, err)
+		case err != nil:
+			events.Detail(b.sink, "Skipping %s: %v\n", tasks[i].path, err)
+			b.tally(&b.stats.FilesSkipped)
+		default:
+			collected[i] = files
+		}
+	})
+	sort.Strings(b.placeholders)
+
+	for _, overlap := range b.cfg.OverlappingItems() {
+		events.Warning(b.sink, "Item %s overlaps %s; its files are archived once\n", overlap.Path, overlap.Within)
+	}
+
+	// overlapping items collect the same files; keep the first of each,
+	// marked sensitive if any item that collected it is
+	var files []FileInfo
+	var totalSize int64
+	seen := make(map[string]int)
+	for i, group := range collected {
+		for _, f := range group {
+			if k, dup := seen[f.RelPath]; dup {
+				if tasks[i].sensitive && !files[k].Sensitive {
+					files[k].Sensitive = true
+					b.stats.SensitiveFiles++
+				}
 				continue
 			}
-			for i := range collected {
-				collected[i].Sensitive = true
-				totalSize += collected[i].Size
+			seen[f.RelPath] = len(files)
+			f.Sensitive = tasks[i].sensitive
+			if f.Sensitive {
+				b.stats.SensitiveFiles++
 			}
-			files = append(files, collected...)
-			b.stats.SensitiveFiles += len(collected)
+			totalSize += f.Size
+			files = append(files, f)
 		}
 	}
 
@@ -288,31 +682,72 @@ func (b *Backup) collectFiles(includeSecrets bool) []FileInfo {
 	return files
 }
 
+// jobs returns the number of items to collect in parallel.
+func (b *Backup) jobs() int {
+	if b.opts.Jobs > 0 {
+		return b.opts.Jobs
+	}
+	return DefaultJobs
+}
+
+// tally increments a collection counter in b.stats.
+func (b *Backup) tally(counter *int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*counter++
+}
+
+// collectItem collects the item at relPath of the home directory.
 func (b *Backup) collectItem(relPath string) ([]FileInfo, error) {
-	fullPath := filepath.Join(b.homeDir, relPath)
+	return b.collectItemIn(b.homeDir, relPath, b.excludeRules())
+}
 
-	info, err := os.Lstat(fullPath)
+// collectItemIn collects the item at relPath of the directory root, which
+// is the home directory but for wsl.items, excluding paths by rules and
+// IgnoreFiles.
+func (b *Backup) collectItemIn(root, relPath string, rules []ignoreRule) ([]FileInfo, error) {
+	fullPath := filepath.Join(root, relPath)
+	limit := b.sizeLimits.For(relPath)
+
+	info, err := lstatRetry(fsys.OS, fullPath)
+	if os.IsNotExist(err) {
+		if _, stubErr := os.Lstat(osutils.ICloudStubPath(fullPath)); stubErr == nil {
+			return b.collectICloudStub(fullPath, relPath, limit), nil
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	ig := newIgnorer(root, relPath, rules)
 
 	if info.Mode()&os.ModeSymlink != 0 {
-		if b.isExcluded(relPath) {
-			b.stats.FilesExcluded++
+		if ig.ignored(relPath, false) {
+			b.tally(&b.stats.FilesExcluded)
 			return nil, nil
 		}
+		if files, ok := b.collectNixLink(fullPath, relPath, ig, limit); ok {
+			return files, nil
+		}
 		return []FileInfo{{
 			FullPath: fullPath,
 			RelPath:  relPath,
 			Size:     info.Size(),
 			ModTime:  info.ModTime(),
+			Mode:     info.Mode(),
 		}}, nil
 	}
 
 	// single file
 	if !info.IsDir() {
-		if b.isExcluded(relPath) {
-			b.stats.FilesExcluded++
+		if ig.ignored(relPath, false) {
+			b.tally(&b.stats.FilesExcluded)
+			return nil, nil
+		}
+		if b.tooLarge(relPath, info.Size(), limit) {
+			return nil, nil
+		}
+		info, ok := b.resolvePlaceholder(fullPath, relPath, info)
+		if !ok {
 			return nil, nil
 		}
 		return []FileInfo{{
@@ -320,6 +755,7 @@ func (b *Backup) collectItem(relPath string) ([]FileInfo, error) {
 			RelPath:  relPath,
 			Size:     info.Size(),
 			ModTime:  info.ModTime(),
+			Mode:     info.Mode(),
 		}}, nil
 	}
 
@@ -327,14 +763,14 @@ func (b *Backup) collectItem(relPath string) ([]FileInfo, error) {
 	var files []FileInfo
 	err = filepath.WalkDir(fullPath, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
-			b.out.Verbose("Cannot access %s: %v\n", path, err)
-			b.stats.FilesSkipped++
+			events.Detail(b.sink, "Cannot access %s: %v\n", path, err)
+			b.tally(&b.stats.FilesSkipped)
 			return nil
 		}
-		rel, relErr := filepath.Rel(b.homeDir, path)
+		rel, relErr := filepath.Rel(root, path)
 		if relErr != nil {
-			b.out.Verbose("Cannot compute relative path for %s: %v\n", path, relErr)
-			b.stats.FilesSkipped++
+			events.Detail(b.sink, "Cannot compute relative path for %s: %v\n", path, relErr)
+			b.tally(&b.stats.FilesSkipped)
 			return nil
 		}
 
@@ -343,14 +779,18 @@ func (b *Backup) collectItem(relPath string) ([]FileInfo, error) {
 		// returning SkipDir for a non-directory entry would skip remaining
 		// siblings in the parent directory, which we must avoid.
 		if d.Type()&os.ModeSymlink != 0 {
-			if b.isExcluded(rel) {
-				b.stats.FilesExcluded++
+			if ig.ignored(rel, false) {
+				b.tally(&b.stats.FilesExcluded)
+				return nil
+			}
+			if linked, ok := b.collectNixLink(path, rel, ig, limit); ok {
+				files = append(files, linked...)
 				return nil
 			}
-			fi, infoErr := d.Info()
+			fi, infoErr := lstatRetry(fsys.OS, path)
 			if infoErr != nil {
-				b.out.Verbose("Cannot stat %s: %v\n", path, infoErr)
-				b.stats.FilesSkipped++
+				events.Detail(b.sink, "Cannot stat %s: %v\n", path, infoErr)
+				b.tally(&b.stats.FilesSkipped)
 				return nil
 			}
 			files = append(files, FileInfo{
@@ -358,26 +798,44 @@ func (b *Backup) collectItem(relPath string) ([]FileInfo, error) {
 				RelPath:  rel,
 				Size:     fi.Size(),
 				ModTime:  fi.ModTime(),
+				Mode:     fi.Mode(),
 			})
 			return nil
 		}
 
 		if d.IsDir() {
-			if b.isExcluded(rel) {
-				b.stats.FilesExcluded++
+			if ig.ignored(rel, true) {
+				b.tally(&b.stats.FilesExcluded)
 				return filepath.SkipDir
 			}
+			ig.load(rel)
 			return nil
 		}
-		if b.isExcluded(rel) {
-			b.stats.FilesExcluded++
+		if target, isStub := osutils.ICloudStubTarget(path); isStub {
+			relTarget := filepath.Join(filepath.Dir(rel), filepath.Base(target))
+			if ig.ignored(relTarget, false) {
+				b.tally(&b.stats.FilesExcluded)
+				return nil
+			}
+			files = append(files, b.collectICloudStub(target, relTarget, limit)...)
+			return nil
+		}
+		if ig.ignored(rel, false) {
+			b.tally(&b.stats.FilesExcluded)
 			return nil
 		}
 
-		fi, infoErr := d.Info()
+		fi, infoErr := lstatRetry(fsys.OS, path)
 		if infoErr != nil {
-			b.out.Verbose("Cannot stat %s: %v\n", path, infoErr)
-			b.stats.FilesSkipped++
+			events.Detail(b.sink, "Cannot stat %s: %v\n", path, infoErr)
+			b.tally(&b.stats.FilesSkipped)
+			return nil
+		}
+		if b.tooLarge(rel, fi.Size(), limit) {
+			return nil
+		}
+		fi, ok := b.resolvePlaceholder(path, rel, fi)
+		if !ok {
 			return nil
 		}
 
@@ -386,6 +844,7 @@ func (b *Backup) collectItem(relPath string) ([]FileInfo, error) {
 			RelPath:  rel,
 			Size:     fi.Size(),
 			ModTime:  fi.ModTime(),
+			Mode:     fi.Mode(),
 		})
 		return nil
 	})
@@ -393,209 +852,75 @@ func (b *Backup) collectItem(relPath string) ([]FileInfo, error) {
 	return files, err
 }
 
-func (b *Backup) isExcluded(path string) bool {
-	name := filepath.Base(path)
-
-	for _, pattern := range b.cfg.Excludes.Patterns {
-		// check against basename (for patterns like "*.log", ".DS_Store")
-		if matched, err := filepath.Match(pattern, name); err == nil && matched {
-			return true
-		}
-		// check against full relative path
-		if matched, err := filepath.Match(pattern, path); err == nil && matched {
-			return true
-		}
-		// check if path is inside excluded directory (e.g., ".git/objects/...")
-		// pattern ".git" should match ".git" or ".git/..." but NOT ".gitconfig"
-		if name == pattern {
-			return true
-		}
-		// check for directory prefix match
-		if strings.HasPrefix(path, pattern+"/") || strings.HasSuffix(path, "/"+pattern) {
-			return true
-		}
-		// check for pattern as path component (e.g., "foo/.git/bar")
-		if strings.Contains(path, "/"+pattern+"/") {
-			return true
-		}
+// collectICloudStub returns the evicted file behind an iCloud stub if it
+// can be materialized and is not larger than limit.
+func (b *Backup) collectICloudStub(target, relTarget string, limit int64) []FileInfo {
+	info, ok := b.resolveICloudStub(target, relTarget)
+	if !ok || b.tooLarge(relTarget, info.Size(), limit) {
+		return nil
 	}
-	return false
+	return []FileInfo{{
+		FullPath: target,
+		RelPath:  relTarget,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Mode:     info.Mode(),
+	}}
 }
 
-func (b *Backup) cleanupOldBackups() {
-	if b.cfg.Backup.MaxBackups <= 0 {
-		return
-	}
-
-	groups := make(map[string][]string)
-
-	entries, err := os.ReadDir(b.cfg.Backup.BackupDir)
-	if err != nil {
-		b.out.Verbose("Cannot read backup directory for cleanup: %v\n", err)
-		return
-	}
-
-	for _, entry := range entries {
-		name := entry.Name()
-		if !strings.HasPrefix(name, "dotfiles-") {
-			continue
-		}
-
-		parts := strings.SplitN(strings.TrimPrefix(name, "dotfiles-"), ".", 2)
-		if len(parts) > 0 {
-			timestamp := parts[0]
-			groups[timestamp] = append(groups[timestamp], filepath.Join(b.cfg.Backup.BackupDir, name))
-		}
-	}
-
-	var timestamps []string
-	for ts := range groups {
-		timestamps = append(timestamps, ts)
-	}
-	sort.Strings(timestamps)
-
-	toRemove := len(timestamps) - b.cfg.Backup.MaxBackups
-	if toRemove <= 0 {
-		return
-	}
-
-	for i := range toRemove {
-		ts := timestamps[i]
-		for _, path := range groups[ts] {
-			b.out.Verbose("Removing old backup: %s\n", filepath.Base(path))
-			if rmErr := os.Remove(path); rmErr != nil {
-				b.out.Verbose("Failed to remove old backup %s: %v\n", filepath.Base(path), rmErr)
-			}
-		}
+// tooLarge reports whether a file of size is above the max_file_size limit
+// of its item, which skips it with a warning.
+func (b *Backup) tooLarge(relPath string, size, limit int64) bool {
+	if limit <= 0 || size <= limit {
+		return false
 	}
+	events.Warning(b.sink, "Skipping %s: %s is larger than max_file_size (%s)\n",
+		relPath, formatSize(size), formatSize(limit))
+	b.tally(&b.stats.FilesTooLarge)
+	return true
 }
 
-func (b *Backup) backupHomebrew() {
-	brewfile := filepath.Join(b.cfg.Backup.BackupDir, "Brewfile")
-	if err := runCommand("brew", "bundle", "dump", "--file="+brewfile, "--force", "--describe"); err != nil {
-		b.out.Verbose("Homebrew backup failed: %v\n", err)
-		return
-	}
-
-	// filter out go "..." lines (they're saved separately in go-packages.txt)
-	content, err := os.ReadFile(brewfile)
-	if err != nil {
-		b.out.Verbose("Failed to read Brewfile: %v\n", err)
-		return
-	}
-
-	var filtered []string
-	for line := range strings.SplitSeq(string(content), "\n") {
-		if !strings.HasPrefix(line, "go \"") {
-			filtered = append(filtered, line)
-		}
-	}
-
-	if err = os.WriteFile(brewfile, []byte(strings.Join(filtered, "\n")), 0600); err != nil {
-		b.out.Verbose("Failed to write filtered Brewfile: %v\n", err)
-		return
-	}
-
-	b.out.Verbose("Homebrew packages saved to Brewfile\n")
+// excludeRules returns the [excludes] patterns, parsed once.
+func (b *Backup) excludeRules() []ignoreRule {
+	b.excludesOnce.Do(func() {
+		b.excludes = compileExcludes(b.cfg.Excludes.Patterns)
+	})
+	return b.excludes
 }
 
-func (b *Backup) backupMASApps() {
-	masFile := filepath.Join(b.cfg.Backup.BackupDir, "mas-apps.txt")
-	commandOutput, err := runCommandOutput("mas", "list")
-	if err != nil {
-		b.out.Verbose("MAS backup failed: %v\n", err)
-		return
-	}
-	if err = os.WriteFile(masFile, []byte(commandOutput), 0600); err != nil {
-		b.out.Verbose("Failed to save MAS apps: %v\n", err)
-	} else {
-		b.out.Verbose("Mac App Store apps saved\n")
+// lock locks the backup directory the archive is written to against
+// overlapping runs.
+func (b *Backup) lock() (*lock.Lock, error) {
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
+	return lock.Acquire(ctx, b.cfg.Backup.BackupDir, "backup", b.opts.Wait, b.sink)
 }
 
-func (b *Backup) backupAptPackages() {
-	if runtime.GOOS != "linux" {
-		return
-	}
-	aptFile := filepath.Join(b.cfg.Backup.BackupDir, "apt-packages.txt")
-	commandOutput, err := runCommandOutput("apt-mark", "showmanual")
-	if err != nil {
-		b.out.Verbose("apt backup failed: %v\n", err)
-		return
-	}
-	if err = os.WriteFile(aptFile, []byte(commandOutput), 0600); err != nil {
-		b.out.Verbose("Failed to save apt packages: %v\n", err)
-	} else {
-		b.out.Verbose("apt packages saved\n")
+// cleanupOldBackups prunes the backups that the retention policy no longer
+// keeps. The backup holds the lock on the backup directory already.
+func (b *Backup) cleanupOldBackups() {
+	result, err := prune(b.cfg, PruneOptions{}, b.sink)
+	if err == nil && !result.Success {
+		events.Detail(b.sink, "Cleanup failed: %s\n", result.Error)
 	}
 }
 
-func (b *Backup) backupGoPackages() {
-	// find Go bin directory
-	goBinDir := os.Getenv("GOBIN")
-	if goBinDir == "" {
-		goPath := os.Getenv("GOPATH")
-		if goPath == "" {
-			goPath = filepath.Join(b.homeDir, "go")
-		}
-		goBinDir = filepath.Join(goPath, "bin")
-	}
-
-	entries, err := os.ReadDir(goBinDir)
-	if err != nil {
-		b.out.Verbose("Go packages backup skipped: %v\n", err)
-		return
-	}
-
-	var packages []string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		binPath := filepath.Join(goBinDir, entry.Name())
-		info, infoErr := entry.Info()
-		if infoErr != nil {
-			continue
-		}
-		// skip non-executable files
-		if info.Mode()&0111 == 0 {
-			continue
-		}
-
-		// get module path using go version -m
-		output, cmdErr := runCommandOutput("go", "version", "-m", binPath)
-		if cmdErr != nil {
-			continue
-		}
-
-		// parse output to find module path
-		// format: <binary>\n\tpath\t<module_path>\n...
-		for line := range strings.SplitSeq(output, "\n") {
-			line = strings.TrimSpace(line)
-			if modulePath, found := strings.CutPrefix(line, "path\t"); found {
-				if modulePath != "" {
-					packages = append(packages, modulePath)
-				}
-				break
-			}
-		}
-	}
-
-	if len(packages) == 0 {
-		b.out.Verbose("No Go packages found to backup\n")
-		return
+// canceled returns an ErrCanceled error once the context of RunContext is
+// done.
+func (b *Backup) canceled() error {
+	if b.ctx == nil {
+		return nil
 	}
+	return errs.Wrap(errs.ErrCanceled, b.ctx.Err())
+}
 
-	sort.Strings(packages)
-
-	goFile := filepath.Join(b.cfg.Backup.BackupDir, "go-packages.txt")
-	content := strings.Join(packages, "\n") + "\n"
-	if err = os.WriteFile(goFile, []byte(content), 0600); err != nil {
-		b.out.Verbose("Failed to save Go packages: %v\n", err)
-	} else {
-		b.out.Verbose("Go packages saved (%d packages)\n", len(packages))
-	}
+// backupTimestamp returns the timestamp that groups a backup's files, e.g.
+// "20240115_143022" for dotfiles-20240115_143022.tar.gz and its .json.
+func backupTimestamp(name string) string {
+	timestamp, _, _ := strings.Cut(strings.TrimPrefix(name, "dotfiles-"), ".")
+	return timestamp
 }
 
 // FileInfo holds information about a file to backup.
@@ -604,7 +929,30 @@ type FileInfo struct {
 	RelPath   string
 	Size      int64
 	ModTime   time.Time
+	Mode      os.FileMode
 	Sensitive bool
+	SHA256    string
+}
+
+// catalog returns the metadata catalog entries for files, sorted by path.
+func catalog(files []FileInfo) []metadata.CatalogEntry {
+	entries := make([]metadata.CatalogEntry, 0, len(files))
+	for _, f := range files {
+		entry := metadata.CatalogEntry{
+			Path:    f.RelPath,
+			Size:    f.Size,
+			ModTime: f.ModTime.Unix(),
+			SHA256:  f.SHA256,
+		}
+		if f.Mode != 0 {
+			entry.Mode = fmt.Sprintf("%04o", f.Mode.Perm())
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
 }
 
 func formatSize(size int64) string {
@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/lock"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+const (
+	// destinationRetryDelay is the first delay between checks of an
+	// unavailable backup directory; it doubles up to maxDestinationRetryDelay.
+	destinationRetryDelay    = 5 * time.Second
+	maxDestinationRetryDelay = time.Minute
+)
+
+// checkDestination returns an error if dir cannot be created or written to.
+func checkDestination(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(dir, ".dotpak-probe-*")
+	if err != nil {
+		return err
+	}
+	_ = probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// openDestination waits up to destination_wait for the backup directory to
+// become available, checking again with a growing delay. If it stays
+// unavailable and spool_dir is set, it returns a copy of the config that
+// backs up into the spool directory instead. Once the backup directory is
+// available, backups spooled earlier are moved into it.
+func (b *Backup) openDestination() (*config.Config, error) {
+	wait, err := b.cfg.Backup.ParseDestinationWait()
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.Backup.SpoolDir != "" && b.cfg.Backup.Storage == StorageObjects {
+		return nil, errs.Errorf(errs.ErrConfigInvalid,
+			"backup.spool_dir is not supported with backup.storage = %q", StorageObjects)
+	}
+	dir := b.cfg.Backup.BackupDir
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	deadline := time.Now().Add(wait)
+	delay := destinationRetryDelay
+	for {
+		err = checkDestination(dir)
+		if err == nil {
+			b.moveSpooled()
+			return b.cfg, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		events.Warning(b.sink, "Backup directory %s unavailable (%v), retrying in %s\n",
+			dir, err, min(delay, remaining).Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return nil, errs.Wrap(errs.ErrCanceled, ctx.Err())
+		case <-time.After(min(delay, remaining)):
+		}
+		delay = min(delay*2, maxDestinationRetryDelay)
+	}
+
+	spool := b.cfg.Backup.SpoolDir
+	if spool == "" {
+		return nil, fmt.Errorf("creating backup directory: %w%s", err, fullDiskAccessHint(err))
+	}
+	if spoolErr := os.MkdirAll(spool, 0700); spoolErr != nil {
+		return nil, fmt.Errorf("backup directory unavailable (%w), and creating spool directory: %w", err, spoolErr)
+	}
+	events.Warning(b.sink, "Backup directory %s unavailable (%v), spooling to %s\n", dir, err, spool)
+	spooled := *b.cfg
+	spooled.Backup.BackupDir = spool
+	return &spooled, nil
+}
+
+// moveSpooled moves the files of the spool directory into the backup
+// directory. Files that cannot be moved stay spooled for the next backup.
+func (b *Backup) moveSpooled() {
+	spool := b.cfg.Backup.SpoolDir
+	if spool == "" || spool == b.cfg.Backup.BackupDir {
+		return
+	}
+	entries, err := os.ReadDir(spool)
+	if err != nil {
+		return
+	}
+
+	moved := 0
+	for _, entry := range entries {
+		// the lock file of the spool directory stays; moving it would
+		// replace the one of the backup directory while it may be held
+		if !entry.Type().IsRegular() || entry.Name() == lock.FileName {
+			continue
+		}
+		src := filepath.Join(spool, entry.Name())
+		if entry.Name() == PendingUploadFile {
+			// merge, or the list would replace the backup directory's
+			if err = MarkPendingUpload(b.cfg.Backup.BackupDir, PendingUploads(spool)...); err == nil {
+				_ = os.Remove(src)
+			}
+			continue
+		}
+		if entry.Name() == metadata.HistoryFile {
+			var history []metadata.HistoryEntry
+			if history, err = metadata.LoadHistory(spool); err == nil {
+				err = metadata.AppendHistory(b.cfg.Backup.BackupDir, history...)
+			}
+			if err == nil {
+				_ = os.Remove(src)
+			}
+			continue
+		}
+		if err = moveFile(src, filepath.Join(b.cfg.Backup.BackupDir, entry.Name())); err != nil {
+			events.Warning(b.sink, "Failed to move spooled %s: %v\n", entry.Name(), err)
+			continue
+		}
+		moved++
+	}
+	if moved > 0 {
+		events.Info(b.sink, "Moved %d spooled files from %s to %s\n", moved, spool, b.cfg.Backup.BackupDir)
+	}
+}
+
+// moveFile renames src to dst, copying it when they are on different
+// filesystems, as a local spool and a network mount are.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".part"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	err = errors.Join(err, out.Sync(), out.Close())
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = os.Chtimes(dst, info.ModTime(), info.ModTime())
+	return os.Remove(src)
+}
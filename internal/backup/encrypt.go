@@ -1,24 +1,13 @@
 package backup
 
 import (
-	"os/exec"
+	"cmp"
+	"os"
 
 	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
 )
 
-// runCommand runs an external command.
-func runCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	return cmd.Run()
-}
-
-// runCommandOutput runs a command and returns its output.
-func runCommandOutput(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.Output()
-	return string(output), err
-}
-
 // HasAge checks if age is available.
 func HasAge() bool {
 	return crypto.HasAge()
@@ -28,3 +17,37 @@ func HasAge() bool {
 func HasGPG() bool {
 	return crypto.HasGPG()
 }
+
+// sensitiveEncryptor returns the encryptor of the sensitive files in an
+// archive that is not encrypted, or nil unless backup.sensitive_encryption
+// or Options.EncryptSensitive asks for one.
+func (b *Backup) sensitiveEncryptor() (*crypto.AgeEncryptor, error) {
+	method := b.cfg.Backup.SensitiveEncryption
+	if b.opts.EncryptSensitive {
+		method = string(crypto.MethodAge)
+	}
+	switch method {
+	case "":
+		return nil, nil
+	case string(crypto.MethodAge):
+	default:
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "unknown sensitive_encryption method: %s", method)
+	}
+
+	if b.cfg.Backup.Format == "zip" || b.cfg.Backup.Storage == StorageObjects {
+		return nil, errs.Errorf(errs.ErrConfigInvalid,
+			"sensitive files are encrypted on their own only in tar.gz archives")
+	}
+	recipientsFile := cmp.Or(b.opts.RecipientsFile, b.cfg.Backup.AgeRecipients)
+	if recipientsFile == "" {
+		return nil, errs.Errorf(errs.ErrEncryptionUnavailable,
+			"sensitive_encryption = age but no recipients file specified")
+	}
+	if _, err := os.Stat(recipientsFile); err != nil {
+		return nil, errs.Errorf(errs.ErrEncryptionUnavailable, "age recipients file not found: %s", recipientsFile)
+	}
+	if !crypto.HasAge() {
+		return nil, errs.Errorf(errs.ErrEncryptionUnavailable, "age is not installed")
+	}
+	return crypto.NewAgeEncryptor(crypto.Options{AgeRecipientsFile: recipientsFile})
+}
@@ -0,0 +1,28 @@
+package backup
+
+import (
+	"slices"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// recordHistory completes e with the statistics of the run and the backed up
+// files, then appends it to the history file of the backup directory for
+// stats. A failure is only reported, since the backup itself is complete.
+func (b *Backup) recordHistory(e metadata.HistoryEntry, files []metadata.CatalogEntry) {
+	e.Timestamp = time.Now().Format(time.DateTime)
+	e.Files = len(files)
+	for _, f := range files {
+		e.ContentSize += f.Size
+	}
+	e.FilesArchived = b.stats.FilesBackedUp
+	e.BytesRead = b.stats.BytesRead
+	e.ArchiveSize = b.stats.ArchiveSize
+	e.Items = metadata.ItemSizes(files, slices.Concat(b.cfg.Items, b.cfg.Sensitive))
+
+	if err := metadata.AppendHistory(b.cfg.Backup.BackupDir, e); err != nil {
+		events.Detail(b.sink, "Failed to record backup history: %v\n", err)
+	}
+}
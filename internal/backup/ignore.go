@@ -0,0 +1,262 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+)
+
+// IgnoreFile is the name of the per-directory exclusion files read while
+// collecting files, in gitignore syntax. Its rules apply to the directory it
+// is in and everything below, after [excludes] patterns, so that a directory
+// such as .config/nvim carries its own exclusions and "!" rules can keep
+// files that [excludes] leaves out. The file itself is backed up.
+const IgnoreFile = ".dotpakignore"
+
+// ExcludesSource is the source of the rules of [excludes] in an
+// ExcludeMatch.
+const ExcludesSource = "[excludes]"
+
+// ignoreRule is a line of an IgnoreFile or an [excludes] pattern.
+type ignoreRule struct {
+	// text is the line as written.
+	text string
+	// pattern is slash-separated, without the leading "!", a leading or
+	// trailing "/", or escapes.
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored patterns had a slash before their end and match paths
+	// relative to the directory of the file; the others match names at any
+	// depth.
+	anchored bool
+}
+
+// parseRule parses a line of an IgnoreFile. It returns false for blank
+// lines and comments.
+func parseRule(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	// trailing spaces are ignored unless escaped
+	if trimmed := strings.TrimRight(line, " "); !strings.HasSuffix(trimmed, "\\") {
+		line = trimmed
+	}
+
+	rule := ignoreRule{text: line}
+	if rest, ok := strings.CutPrefix(line, "!"); ok {
+		rule.negate = true
+		line = rest
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if rest, ok := strings.CutSuffix(line, "/"); ok {
+		rule.dirOnly = true
+		line = rest
+	}
+	rule.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.pattern = strings.ReplaceAll(line, `\ `, " ")
+	return rule, true
+}
+
+// parseIgnore parses the content of an IgnoreFile.
+func parseIgnore(data []byte) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if rule, ok := parseRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// compileExcludes parses [excludes] patterns, which have the syntax of the
+// lines of an IgnoreFile in the home directory.
+func compileExcludes(patterns []string) []ignoreRule {
+	rules := make([]ignoreRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		if rule, ok := parseRule(filepath.ToSlash(pattern)); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// match reports whether the rule matches rel, a slash-separated path
+// relative to the directory of its IgnoreFile.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		matched, err := path.Match(r.pattern, path.Base(rel))
+		return err == nil && matched
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches any number of segments.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(segments); i >= 0; i-- {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// ignorer decides which paths of an item are excluded, by [excludes]
+// patterns and the IgnoreFiles found while collecting it.
+type ignorer struct {
+	homeDir  string
+	excludes []ignoreRule
+	// rules maps the slash-separated directories, relative to home, that
+	// hold an IgnoreFile to its rules; "." is home itself.
+	rules map[string][]ignoreRule
+	// parent is the decision on the parent directory of the item that
+	// excludes it, if one does; nothing inside it is then collected.
+	parent *ExcludeMatch
+}
+
+// newIgnorer returns an ignorer for the item at relPath with the
+// IgnoreFiles of its parent directories up to home loaded. Those of the
+// item and its subdirectories are loaded with load as they are walked.
+func newIgnorer(homeDir, relPath string, excludes []ignoreRule) *ignorer {
+	ig := &ignorer{homeDir: homeDir, excludes: excludes}
+	var parents []string
+	for dir := path.Dir(filepath.ToSlash(relPath)); ; dir = path.Dir(dir) {
+		ig.load(dir)
+		if dir == "." || dir == "/" {
+			break
+		}
+		parents = append(parents, dir)
+	}
+	// as when walking from home, the outermost excluded directory decides
+	for i := len(parents) - 1; i >= 0 && ig.parent == nil; i-- {
+		if m := ig.decide(parents[i], true); m.Excluded {
+			m.Dir = parents[i]
+			ig.parent = &m
+		}
+	}
+	return ig
+}
+
+// load reads the IgnoreFile of dir, relative to home, if there is one.
+func (ig *ignorer) load(dir string) {
+	dir = filepath.ToSlash(dir)
+	data, err := os.ReadFile(filepath.Join(ig.homeDir, filepath.FromSlash(dir), IgnoreFile))
+	if err != nil {
+		return
+	}
+	if rules := parseIgnore(data); len(rules) > 0 {
+		if ig.rules == nil {
+			ig.rules = make(map[string][]ignoreRule)
+		}
+		ig.rules[dir] = rules
+	}
+}
+
+// ignored reports whether relPath, inside the item of the ignorer, is
+// excluded. The directories between the item and relPath are expected to
+// have been walked and found not excluded.
+func (ig *ignorer) ignored(relPath string, isDir bool) bool {
+	return ig.parent != nil || ig.decide(relPath, isDir).Excluded
+}
+
+// explain is ignored, with the rule that decided.
+func (ig *ignorer) explain(relPath string, isDir bool) ExcludeMatch {
+	if ig.parent != nil {
+		m := *ig.parent
+		m.Path = filepath.ToSlash(relPath)
+		return m
+	}
+	return ig.decide(relPath, isDir)
+}
+
+// decide applies the rules to relPath on its own: the last rule that
+// matches it decides, [excludes] patterns come first, and the rules of the
+// IgnoreFiles of deeper directories after those above them.
+func (ig *ignorer) decide(relPath string, isDir bool) ExcludeMatch {
+	rel := filepath.ToSlash(relPath)
+	m := ExcludeMatch{Path: rel}
+	apply := func(rules []ignoreRule, sub, source string) {
+		for _, rule := range rules {
+			if rule.match(sub, isDir) {
+				m.Excluded = !rule.negate
+				m.Pattern = rule.text
+				m.Source = source
+			}
+		}
+	}
+	apply(ig.excludes, rel, ExcludesSource)
+	if len(ig.rules) == 0 {
+		return m
+	}
+
+	var dirs []string
+	for dir := path.Dir(rel); ; dir = path.Dir(dir) {
+		if _, ok := ig.rules[dir]; ok {
+			dirs = append(dirs, dir)
+		}
+		if dir == "." || dir == "/" {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		sub := rel
+		if dirs[i] != "." {
+			sub = strings.TrimPrefix(rel, dirs[i]+"/")
+		}
+		apply(ig.rules[dirs[i]], sub, path.Join(dirs[i], IgnoreFile))
+	}
+	return m
+}
+
+// ExcludeMatch tells whether a path, relative to home, is excluded from
+// backups, and which rule decided.
+type ExcludeMatch struct {
+	Path     string `json:"path"`
+	Excluded bool   `json:"excluded"`
+	// Pattern is the rule that decided, as written, and Source where it
+	// is: ExcludesSource or an IgnoreFile relative to home. They are empty
+	// if no rule matches the path.
+	Pattern string `json:"pattern,omitempty"`
+	Source  string `json:"source,omitempty"`
+	// Dir is set when the path is excluded because it is inside Dir, which
+	// the rule matches.
+	Dir string `json:"dir,omitempty"`
+}
+
+// ExplainExclude tells whether a backup would exclude the path relPath of
+// homeDir, by the [excludes] patterns of cfg and the IgnoreFiles of its
+// parent directories, and which rule decided. isDir tells whether the path
+// is a directory, as rules ending in "/" only match directories.
+func ExplainExclude(cfg *config.Config, homeDir, relPath string, isDir bool) ExcludeMatch {
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	ig := newIgnorer(homeDir, relPath, compileExcludes(cfg.Excludes.Patterns))
+	return ig.explain(relPath, isDir)
+}
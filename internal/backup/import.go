@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Import backs up the configured items from dir, the copy of a home
+// directory in an existing snapshot such as a Time Machine or rsnapshot
+// backup, into an archive dated at the newest file, so that list, diff, and
+// restore work on it like on any other backup. sourceHome is the home
+// directory the snapshot was taken of, which restore replaces in config
+// files; if empty it is guessed from the snapshot layout (…/Users/<name> or
+// …/home/<name>), else dir itself is recorded.
+//
+// Unlike Run, Import does not run hooks, save package lists or a shell
+// snapshot, or remove old backups. Incremental and estimate options are
+// ignored.
+func Import(cfg *config.Config, dir, sourceHome string, opts *Options, sink events.Sink) (*metadata.BackupResult, error) {
+	result := &metadata.BackupResult{}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	if info, statErr := os.Stat(abs); statErr != nil || !info.IsDir() {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid, "%s is not a directory", dir))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
+		return result, nil
+	}
+	if cfg.Backup.Storage == StorageObjects {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid,
+			"snapshots are imported as archives, not with backup.storage = %q", StorageObjects))
+		return result, nil
+	}
+	if sourceHome == "" {
+		sourceHome = guessSnapshotHome(abs)
+	}
+
+	importOpts := *opts
+	importOpts.Incremental = false
+	importOpts.Estimate = false
+	importOpts.ShellSnapshot = false
+	b := &Backup{
+		cfg:          cfg,
+		opts:         &importOpts,
+		sink:         events.Synchronized(sink),
+		homeDir:      abs,
+		snapshotHome: sourceHome,
+	}
+	events.Info(b.sink, "Importing %s as a backup of %s\n", abs, sourceHome)
+	return b.run()
+}
+
+// guessSnapshotHome returns the home directory that dir, a home directory
+// inside a snapshot, was copied from: /Users/<name> or /home/<name> if dir
+// ends in one, else dir.
+func guessSnapshotHome(dir string) string {
+	slashed := filepath.ToSlash(dir)
+	for _, parent := range []string{"/Users/", "/home/"} {
+		i := strings.LastIndex(slashed, parent)
+		if i < 0 {
+			continue
+		}
+		if name := slashed[i+len(parent):]; name != "" && !strings.Contains(name, "/") {
+			return parent + name
+		}
+	}
+	return dir
+}
+
+// snapshotTime returns the time an imported snapshot is dated at: the
+// modification time of its newest file, or now if none has one.
+func snapshotTime(files []FileInfo) time.Time {
+	var newest time.Time
+	for _, f := range files {
+		if f.ModTime.After(newest) {
+			newest = f.ModTime
+		}
+	}
+	if newest.IsZero() {
+		return time.Now()
+	}
+	return newest
+}
+
+// checkImportName returns an error if a backup dated timestamp already
+// exists in backupDir, since an import must not replace it.
+func checkImportName(backupDir, timestamp string) error {
+	if matches, _ := filepath.Glob(filepath.Join(backupDir, "dotfiles-"+timestamp+".*")); len(matches) > 0 {
+		return fmt.Errorf("a backup dated %s already exists: %s", timestamp, filepath.Base(matches[0]))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// hashFiles records the SHA-256 of each file's content (of the link target
+// for symlinks), so later incremental backups can tell what changed.
+func (b *Backup) hashFiles(files []FileInfo) {
+	errList := make([]error, len(files))
+	parallel(len(files), readahead, func(i int) {
+		files[i].SHA256, errList[i] = fileHash(b.fsys(), files[i].FullPath, &b.hashRead)
+	})
+	for i, err := range errList {
+		if err != nil {
+			events.Detail(b.sink, "Cannot hash %s: %v\n", files[i].RelPath, err)
+		}
+	}
+}
+
+// fileHash returns the hex SHA-256 of the file at path of fs, adding the
+// bytes read to bytesRead.
+func fileHash(fs fsys.FS, path string, bytesRead *atomic.Int64) (string, error) {
+	info, err := lstatRetry(fs, path)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, readErr := fs.Readlink(path)
+		if readErr != nil {
+			return "", readErr
+		}
+		h.Write([]byte(target))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	file, err := openRetry(fs, path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(h, file)
+	bytesRead.Add(n)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// incrementalBase returns the metadata of previousArchive if an incremental
+// backup can build on it, or nil (with the reason reported) if a full backup
+// is needed instead.
+func (b *Backup) incrementalBase(previousArchive string) *metadata.Metadata {
+	if previousArchive == "" {
+		events.Info(b.sink, "No previous backup, creating a full backup\n")
+		return nil
+	}
+	// make sure the whole chain is still restorable
+	if _, _, err := metadata.Chain(previousArchive); err != nil {
+		events.Warning(b.sink, "Cannot build on %s, creating a full backup: %v\n", filepath.Base(previousArchive), err)
+		return nil
+	}
+	prev, err := metadata.Load(metadata.GetMetadataPath(previousArchive))
+	if err != nil || len(prev.Files) == 0 || prev.Files[0].SHA256 == "" {
+		events.Info(b.sink, "Previous backup has no file hashes, creating a full backup\n")
+		return nil
+	}
+	return prev
+}
+
+// changedFiles returns the files whose content differs from, or is missing
+// in, the previous backup's catalog.
+func changedFiles(files []FileInfo, prev *metadata.Metadata) []FileInfo {
+	previous := make(map[string]string, len(prev.Files))
+	for _, f := range prev.Files {
+		previous[f.Path] = f.SHA256
+	}
+
+	var changed []FileInfo
+	for _, f := range files {
+		if f.SHA256 == "" || previous[f.RelPath] != f.SHA256 {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,321 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// IndexDir is the directory of the backup directory that holds the indexes
+// written by SnapshotIndex.
+const IndexDir = "indexes"
+
+// IndexVersion is the version of the index layout this release writes.
+const IndexVersion = 1
+
+// maxIndexHashSize bounds the files whose content is hashed for an index;
+// larger ones are compared by size and modification time.
+const maxIndexHashSize = 64 << 20
+
+// Index records the dotfiles of a home directory, whether the config backs
+// them up or not, without their content.
+type Index struct {
+	Version   int          `json:"version"`
+	Timestamp string       `json:"timestamp"`
+	Hostname  string       `json:"hostname"`
+	HomeDir   string       `json:"home_dir,omitempty"`
+	Entries   []IndexEntry `json:"entries"`
+}
+
+// IndexEntry is a file or symlink of an Index, relative to home.
+type IndexEntry struct {
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime int64       `json:"mtime"`
+	Size    int64       `json:"size"`
+	// SHA256 is the hash of the content of a regular file of at most
+	// maxIndexHashSize bytes.
+	SHA256 string `json:"sha256,omitempty"`
+	// Link is the target of a symlink.
+	Link string `json:"link,omitempty"`
+}
+
+// differs reports whether e and other record different files.
+func (e IndexEntry) differs(other IndexEntry) bool {
+	if e.Mode != other.Mode || e.Size != other.Size || e.Link != other.Link {
+		return true
+	}
+	if e.SHA256 != "" && other.SHA256 != "" {
+		return e.SHA256 != other.SHA256
+	}
+	return e.ModTime != other.ModTime
+}
+
+// SnapshotIndex records an index of the dotfiles of the home directory, the
+// entries whose name starts with "." and everything below them, and saves
+// it in the backup directory. Paths matching [excludes] patterns or
+// .dotpakignore files are left out, as is the backup directory itself.
+func SnapshotIndex(cfg *config.Config, sink events.Sink) (*metadata.IndexResult, error) {
+	result := &metadata.IndexResult{}
+	idx, err := TakeIndex(cfg, sink)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	name := "index-" + time.Now().Format(timestampLayout)
+	path, err := SaveIndex(cfg.Backup.BackupDir, name, idx)
+	if err != nil {
+		result.SetError(fmt.Errorf("saving index: %w", err))
+		return result, nil
+	}
+
+	result.Success = true
+	result.Name = name
+	result.Path = path
+	result.Files = len(idx.Entries)
+	for _, e := range idx.Entries {
+		result.Size += e.Size
+	}
+	events.Success(sink, "Indexed %d dotfiles in %s\n", result.Files, name)
+	return result, nil
+}
+
+// TakeIndex indexes the dotfiles of the home directory, as SnapshotIndex
+// does, without saving the index.
+func TakeIndex(cfg *config.Config, sink events.Sink) (*Index, error) {
+	home, err := osutils.HomeDir()
+	if err != nil {
+		return nil, err
+	}
+	b := &Backup{cfg: cfg, homeDir: home, sink: sink}
+	return b.takeIndex()
+}
+
+func (b *Backup) takeIndex() (*Index, error) {
+	idx := &Index{
+		Version:   IndexVersion,
+		Timestamp: time.Now().Format(time.RFC3339),
+		HomeDir:   b.homeDir,
+		Entries:   []IndexEntry{},
+	}
+	idx.Hostname, _ = osutils.Hostname()
+
+	entries, err := os.ReadDir(b.homeDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading home directory: %w", err)
+	}
+	backupDir := filepath.Clean(b.cfg.Backup.BackupDir)
+	ig := newIgnorer(b.homeDir, IgnoreFile, b.excludeRules())
+
+	var paths []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		root := filepath.Join(b.homeDir, entry.Name())
+		walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			rel, _ := filepath.Rel(b.homeDir, path)
+			if err != nil {
+				events.Detail(b.sink, "Cannot index %s: %v\n", rel, err)
+				return nil
+			}
+			if d.IsDir() {
+				if path == backupDir || ig.ignored(rel, true) {
+					return filepath.SkipDir
+				}
+				ig.load(rel)
+				return nil
+			}
+			if d.Type()&^fs.ModeSymlink == 0 && !ig.ignored(rel, false) {
+				paths = append(paths, rel)
+			}
+			return nil
+		})
+		if walkErr != nil {
+			return nil, walkErr
+		}
+	}
+
+	indexed := make([]*IndexEntry, len(paths))
+	parallel(len(paths), readahead, func(i int) {
+		indexed[i] = b.indexEntry(paths[i])
+	})
+	for _, e := range indexed {
+		if e != nil {
+			idx.Entries = append(idx.Entries, *e)
+		}
+	}
+	return idx, nil
+}
+
+// indexEntry describes the file at relPath, or returns nil if it cannot be
+// read.
+func (b *Backup) indexEntry(relPath string) *IndexEntry {
+	fullPath := filepath.Join(b.homeDir, relPath)
+	info, err := lstatRetry(b.fsys(), fullPath)
+	if err != nil {
+		events.Detail(b.sink, "Cannot index %s: %v\n", relPath, err)
+		return nil
+	}
+	e := &IndexEntry{
+		Path:    filepath.ToSlash(relPath),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime().Unix(),
+		Size:    info.Size(),
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		e.Size = 0
+		target, readErr := b.fsys().Readlink(fullPath)
+		if readErr != nil {
+			events.Detail(b.sink, "Cannot index %s: %v\n", relPath, readErr)
+			return nil
+		}
+		e.Link = filepath.ToSlash(target)
+	case info.Mode().IsRegular() && info.Size() <= maxIndexHashSize:
+		if e.SHA256, err = fileHash(b.fsys(), fullPath, &b.hashRead); err != nil {
+			events.Detail(b.sink, "Cannot hash %s: %v\n", relPath, err)
+		}
+	}
+	return e
+}
+
+// SaveIndex writes idx as the index name of backupDir and returns its path.
+func SaveIndex(backupDir, name string, idx *Index) (string, error) {
+	dir := filepath.Join(backupDir, IndexDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+".json")
+	return path, os.WriteFile(path, data, 0600)
+}
+
+// LoadIndex reads the index name of backupDir.
+func LoadIndex(backupDir, name string) (*Index, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, errs.Errorf(errs.ErrArchiveNotFound, "index not found: %s", name)
+	}
+	data, err := os.ReadFile(filepath.Join(backupDir, IndexDir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errs.Errorf(errs.ErrArchiveNotFound, "index not found: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	if err = json.Unmarshal(data, &idx); err != nil {
+		return nil, errs.Wrap(errs.ErrArchiveCorrupt, fmt.Errorf("reading index %s: %w", name, err))
+	}
+	if idx.Version > IndexVersion {
+		return nil, fmt.Errorf("index %s was written by a newer dotpak (index version %d)", name, idx.Version)
+	}
+	return &idx, nil
+}
+
+// IndexNames returns the names of the indexes of backupDir, oldest first.
+func IndexNames(backupDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(backupDir, IndexDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// IndexBefore returns the name of the newest index of backupDir taken at or
+// before t, or "" if there is none.
+func IndexBefore(backupDir string, t time.Time) (string, error) {
+	names, err := IndexNames(backupDir)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range slices.Backward(names) {
+		taken, parseErr := time.ParseInLocation(timestampLayout, strings.TrimPrefix(name, "index-"), time.Local)
+		if parseErr == nil && !taken.After(t) {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// DiffIndexes lists the dotfiles added, removed, and modified from old to
+// cur. Each change records whether an item or sensitive item of cfg covers
+// the file, so that untracked dotfiles can be added to the backup set.
+func DiffIndexes(cfg *config.Config, old, cur *Index) *metadata.IndexDiffResult {
+	result := &metadata.IndexDiffResult{
+		Success:  true,
+		Added:    []metadata.IndexChange{},
+		Removed:  []metadata.IndexChange{},
+		Modified: []metadata.IndexChange{},
+	}
+	oldEntries := make(map[string]IndexEntry, len(old.Entries))
+	for _, e := range old.Entries {
+		oldEntries[e.Path] = e
+	}
+	curEntries := make(map[string]IndexEntry, len(cur.Entries))
+	for _, e := range cur.Entries {
+		curEntries[e.Path] = e
+	}
+
+	items := slices.Concat(cfg.Items, cfg.Sensitive)
+	change := func(e IndexEntry) metadata.IndexChange {
+		return metadata.IndexChange{Path: e.Path, Size: e.Size, Tracked: coveredBy(e.Path, items)}
+	}
+	for _, path := range slices.Sorted(maps.Keys(curEntries)) {
+		e := curEntries[path]
+		prev, ok := oldEntries[path]
+		switch {
+		case !ok:
+			result.Added = append(result.Added, change(e))
+		case prev.differs(e):
+			result.Modified = append(result.Modified, change(e))
+		default:
+			result.Unchanged++
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(oldEntries)) {
+		if _, ok := curEntries[path]; !ok {
+			result.Removed = append(result.Removed, change(oldEntries[path]))
+		}
+	}
+	return result
+}
+
+// coveredBy reports whether the slash-separated path is one of items or
+// inside one of them.
+func coveredBy(path string, items []string) bool {
+	for _, item := range items {
+		item = strings.Trim(filepath.ToSlash(filepath.Clean(item)), "/")
+		if path == item || strings.HasPrefix(path, item+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// signArchive returns the archive's integrity HMAC when sign_archives is
+// enabled, creating the local key on first use. It returns "" otherwise.
+// The HMAC covers meta, so it is signed once every field it covers is set.
+func (b *Backup) signArchive(archivePath string, meta *metadata.Metadata) (string, error) {
+	if !b.cfg.Backup.SignArchives {
+		return "", nil
+	}
+	keyPath := b.cfg.Backup.IntegrityKeyPath()
+	key, err := crypto.LoadOrCreateHMACKey(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("loading integrity key %s: %w", keyPath, err)
+	}
+	signed, err := meta.Signed()
+	if err != nil {
+		return "", err
+	}
+	return crypto.ArchiveHMAC(key, archivePath, signed)
+}
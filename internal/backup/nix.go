@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/fsys"
+)
+
+// Values of backup.nix_symlinks, which decides what becomes of symlinks
+// into the Nix store, such as the configs home-manager links into home.
+const (
+	// NixLink archives them as symlinks, which dangle on machines without
+	// the store path. It is the default.
+	NixLink = "link"
+	// NixSkip leaves them out, as Nix rebuilds them.
+	NixSkip = "skip"
+	// NixDereference archives the files they point to in their place.
+	NixDereference = "dereference"
+)
+
+// defaultNixStore is the Nix store unless NIX_STORE_DIR names another.
+const defaultNixStore = "/nix/store"
+
+// nixStoreDir returns the Nix store directory, resolved like symlinks into
+// it are.
+func nixStoreDir() string {
+	dir := os.Getenv("NIX_STORE_DIR")
+	if dir == "" {
+		dir = defaultNixStore
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+	return filepath.Clean(dir)
+}
+
+// nixStoreTarget returns the path the symlink at path resolves to, and
+// whether it is inside the Nix store, directly or through other links such
+// as ~/.nix-profile. A target that no longer exists, having been garbage
+// collected, is judged by the link itself.
+func nixStoreTarget(path, store string) (string, bool) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", false
+	}
+	if resolved, evalErr := filepath.EvalSymlinks(path); evalErr == nil {
+		target = resolved
+	}
+	return target, strings.HasPrefix(target, store+string(filepath.Separator))
+}
+
+// nixSymlinks returns the nix_symlinks mode, --nix-symlinks winning over
+// the config.
+func (b *Backup) nixSymlinks() string {
+	if b.opts.NixSymlinks != "" {
+		return b.opts.NixSymlinks
+	}
+	if b.cfg.Backup.NixSymlinks != "" {
+		return b.cfg.Backup.NixSymlinks
+	}
+	return NixLink
+}
+
+// collectNixLink handles the symlink at fullPath, relPath in home, if it
+// points into the Nix store and nix_symlinks is not "link". It reports
+// whether it did, with the files to archive in place of the symlink.
+func (b *Backup) collectNixLink(fullPath, relPath string, ig *ignorer, limit int64) ([]FileInfo, bool) {
+	if b.nixStore == "" {
+		return nil, false
+	}
+	target, ok := nixStoreTarget(fullPath, b.nixStore)
+	if !ok {
+		return nil, false
+	}
+	b.tally(&b.stats.NixSymlinks)
+	switch b.nixSymlinks() {
+	case NixSkip:
+		events.Detail(b.sink, "Skipping %s, a link into the Nix store\n", relPath)
+		return nil, true
+	case NixDereference:
+		return b.dereferenceNix(target, relPath, ig, limit), true
+	default:
+		return nil, false
+	}
+}
+
+// dereferenceNix returns the files of target, in the Nix store, as the
+// files of relPath: target itself if it is a file, or the files below it.
+// Symlinks below it into the store are followed in turn.
+func (b *Backup) dereferenceNix(target, relPath string, ig *ignorer, limit int64) []FileInfo {
+	info, err := os.Stat(target)
+	if err != nil {
+		events.Detail(b.sink, "Cannot dereference %s: %v\n", relPath, err)
+		b.tally(&b.stats.FilesSkipped)
+		return nil
+	}
+	if !info.IsDir() {
+		if b.tooLarge(relPath, info.Size(), limit) {
+			return nil
+		}
+		return []FileInfo{{FullPath: target, RelPath: relPath, Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode()}}
+	}
+
+	var files []FileInfo
+	_ = filepath.WalkDir(target, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			events.Detail(b.sink, "Cannot access %s: %v\n", path, err)
+			b.tally(&b.stats.FilesSkipped)
+			return nil
+		}
+		sub, _ := filepath.Rel(target, path)
+		rel := filepath.Join(relPath, sub)
+		if d.IsDir() {
+			if path != target && ig.ignored(rel, true) {
+				b.tally(&b.stats.FilesExcluded)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ig.ignored(rel, false) {
+			b.tally(&b.stats.FilesExcluded)
+			return nil
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			if linked, ok := b.collectNixLink(path, rel, ig, limit); ok {
+				files = append(files, linked...)
+				return nil
+			}
+		}
+		fi, infoErr := lstatRetry(fsys.OS, path)
+		if infoErr != nil {
+			events.Detail(b.sink, "Cannot stat %s: %v\n", path, infoErr)
+			b.tally(&b.stats.FilesSkipped)
+			return nil
+		}
+		if fi.Mode().IsRegular() && b.tooLarge(rel, fi.Size(), limit) {
+			return nil
+		}
+		files = append(files, FileInfo{FullPath: path, RelPath: rel, Size: fi.Size(), ModTime: fi.ModTime(), Mode: fi.Mode()})
+		return nil
+	})
+	return files
+}
+
+// reportNixLinks tells what became of the symlinks into the Nix store.
+func (b *Backup) reportNixLinks() {
+	n := b.stats.NixSymlinks
+	if n == 0 {
+		return
+	}
+	switch b.nixSymlinks() {
+	case NixSkip:
+		events.Info(b.sink, "Skipped %d symlinks into the Nix store\n", n)
+	case NixDereference:
+		events.Info(b.sink, "Archived the files of %d symlinks into the Nix store\n", n)
+	default:
+		if b.cfg.Backup.NixSymlinks == "" && b.opts.NixSymlinks == "" {
+			events.Info(b.sink, "Archived %d symlinks into the Nix store as links, which dangle on machines "+
+				"without Nix (set nix_symlinks to skip or dereference them)\n", n)
+		}
+	}
+}
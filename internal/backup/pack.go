@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+const (
+	// smallFileLimit is the largest file read into memory ahead of the tar
+	// writer; larger files are streamed from disk when their turn comes.
+	smallFileLimit = 256 << 10
+	// readahead is the number of files stat'ed and read concurrently. Trees
+	// of tiny files (oh-my-zsh, elpa) are bound by per-file latency, not
+	// bandwidth, so overlapping the syscalls is what makes them fast.
+	readahead = 16
+	// archiveBufferSize batches compressed output into large writes.
+	archiveBufferSize = 1 << 20
+)
+
+// packedFile is a file loaded ahead of the tar writer.
+type packedFile struct {
+	info os.FileInfo
+	link string // symlink target
+	data []byte // content of a small regular file
+	// streamed is set for files too large (or of a type) not to read ahead;
+	// the tar writer reads them itself.
+	streamed bool
+	err      error
+}
+
+// readAhead loads files from fs concurrently, at most readahead ahead of the
+// consumer, and delivers them in order: each element of the returned channel
+// yields the next file. The consumer must drain the channel.
+func readAhead(fs fsys.FS, files []FileInfo, bytesRead *atomic.Int64) <-chan chan packedFile {
+	queue := make(chan chan packedFile, readahead)
+	go func() {
+		defer close(queue)
+		for _, f := range files {
+			ch := make(chan packedFile, 1)
+			queue <- ch
+			go func() { ch <- loadFile(fs, f.FullPath, bytesRead) }()
+		}
+	}()
+	return queue
+}
+
+func loadFile(fs fsys.FS, path string, bytesRead *atomic.Int64) packedFile {
+	info, err := lstatRetry(fs, path)
+	if err != nil {
+		return packedFile{err: err}
+	}
+
+	switch mode := info.Mode(); {
+	case mode&os.ModeSymlink != 0:
+		link, readErr := fs.Readlink(path)
+		return packedFile{info: info, link: filepath.ToSlash(link), err: readErr}
+	case !mode.IsRegular() || info.Size() > smallFileLimit:
+		return packedFile{info: info, streamed: true}
+	}
+
+	file, err := openRetry(fs, path)
+	if err != nil {
+		return packedFile{err: err}
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, smallFileLimit+1))
+	if err != nil {
+		return packedFile{err: err}
+	}
+	if len(data) > smallFileLimit {
+		// grew since the stat
+		return packedFile{info: info, streamed: true}
+	}
+	bytesRead.Add(int64(len(data)))
+	return packedFile{info: info, data: data}
+}
+
+// writePacked writes a file loaded by readAhead from fs to tw.
+func writePacked(fs fsys.FS, tw ArchiveWriter, f FileInfo, p packedFile, bytesRead *atomic.Int64) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.streamed {
+		if err := AddFileToTar(fs, tw, f.FullPath, f.RelPath); err != nil {
+			return err
+		}
+		bytesRead.Add(p.info.Size())
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(p.info, p.link)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(f.RelPath)
+	if p.info.Mode()&os.ModeSymlink != 0 {
+		return tw.WriteHeader(header)
+	}
+	// the content read is authoritative if the file changed after the stat
+	header.Size = int64(len(p.data))
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(p.data)
+	return err
+}
+
+// writeSealed writes a sensitive file loaded by readAhead from fs to tw encrypted on
+// its own with enc, under its name with crypto.MemberSuffix appended, in an
+// entry marked with crypto.MemberPAXKey so that restore decrypts it, and
+// with the metadata.FeatureSealed feature.
+// Symlinks and special files are written as writePacked writes them.
+func writeSealed(
+	fs fsys.FS,
+	tw ArchiveWriter,
+	f FileInfo,
+	p packedFile,
+	enc *crypto.AgeEncryptor,
+	bytesRead *atomic.Int64,
+) error {
+	if p.err != nil {
+		return p.err
+	}
+	if !p.info.Mode().IsRegular() {
+		return writePacked(fs, tw, f, p, bytesRead)
+	}
+
+	content := io.Reader(bytes.NewReader(p.data))
+	if p.streamed {
+		file, err := openRetry(fs, f.FullPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		content = file
+		bytesRead.Add(p.info.Size())
+	}
+	var sealed bytes.Buffer
+	if err := enc.EncryptStream(content, &sealed); err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(p.info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(f.RelPath) + crypto.MemberSuffix
+	header.Size = int64(sealed.Len())
+	header.PAXRecords = map[string]string{
+		crypto.MemberPAXKey:    string(crypto.MethodAge),
+		metadata.FeaturePAXKey: metadata.FeatureSealed,
+	}
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(sealed.Bytes())
+	return err
+}
+
+// parallel calls fn for each index in [0, n) using up to workers goroutines.
+func parallel(n, workers int, fn func(i int)) {
+	next := atomic.Int64{}
+	var wg sync.WaitGroup
+	for range min(workers, n) {
+		wg.Go(func() {
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// recordIO adds the measurements of a phase that began at start to the IO
+// profile.
+func (b *Backup) recordIO(phase string, start time.Time, files int, bytesRead, bytesWritten int64) {
+	b.ioProfile = append(b.ioProfile, metadata.IOPhase{
+		Phase:        phase,
+		DurationMS:   time.Since(start).Milliseconds(),
+		Files:        files,
+		BytesRead:    bytesRead,
+		BytesWritten: bytesWritten,
+	})
+}
@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/remote"
+)
+
+// PendingUploadFile lists, in the backup directory, the archives that failed
+// to upload with [remote] spool and wait for the next attempt.
+const PendingUploadFile = "pending-upload.json"
+
+type pendingUploads struct {
+	Archives []string `json:"archives"`
+}
+
+// PendingUploads returns the names of the archives in backupDir waiting to
+// be uploaded, oldest first.
+func PendingUploads(backupDir string) []string {
+	data, err := os.ReadFile(filepath.Join(backupDir, PendingUploadFile))
+	if err != nil {
+		return nil
+	}
+	var pending pendingUploads
+	if json.Unmarshal(data, &pending) != nil {
+		return nil
+	}
+	slices.Sort(pending.Archives)
+	return slices.Compact(pending.Archives)
+}
+
+// MarkPendingUpload records the archives, in backupDir, as waiting to be
+// uploaded.
+func MarkPendingUpload(backupDir string, archives ...string) error {
+	pending := PendingUploads(backupDir)
+	for _, archive := range archives {
+		pending = append(pending, filepath.Base(archive))
+	}
+	return savePendingUploads(backupDir, pending)
+}
+
+func savePendingUploads(backupDir string, archives []string) error {
+	path := filepath.Join(backupDir, PendingUploadFile)
+	if len(archives) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	slices.Sort(archives)
+	data, err := json.MarshalIndent(pendingUploads{Archives: slices.Compact(archives)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// keepPendingUploads adds the archives waiting to be uploaded, and their
+// parents, to the backups prune keeps, so that they reach the remote first.
+func keepPendingUploads(backupDir string, kept map[string][]string) {
+	for _, name := range PendingUploads(backupDir) {
+		archive := filepath.Join(backupDir, name)
+		ts := backupTimestamp(name)
+		kept[ts] = append(kept[ts], "pending-upload")
+		for _, parent := range chainParents(archive) {
+			ts = backupTimestamp(filepath.Base(parent))
+			if !slices.Contains(kept[ts], "parent") {
+				kept[ts] = append(kept[ts], "parent")
+			}
+		}
+	}
+}
+
+// UploadPending uploads the archives waiting in the backup directory, each
+// with its metadata, to r, oldest first. It stops at the first failure,
+// since the remote is then most likely still unreachable, and leaves the
+// rest pending. Once all are uploaded, it removes the backups on r that the
+// retention policy does not keep, the same policy prune applies locally.
+func UploadPending(cfg *config.Config, r remote.Remote, dryRun bool, sink events.Sink) (*metadata.UploadResult, error) {
+	result := &metadata.UploadResult{DryRun: dryRun, Remote: r.String(), Uploaded: []string{}}
+	backupDir := cfg.Backup.BackupDir
+
+	var pending []string
+	for _, name := range PendingUploads(backupDir) {
+		if _, err := os.Stat(filepath.Join(backupDir, name)); err != nil {
+			events.Detail(sink, "Pending archive %s is gone, skipping\n", name)
+			continue
+		}
+		pending = append(pending, name)
+	}
+
+	for len(pending) > 0 {
+		name := pending[0]
+		if dryRun {
+			events.Info(sink, "Would upload %s\n", name)
+			result.Uploaded = append(result.Uploaded, name)
+			pending = pending[1:]
+			continue
+		}
+		events.Info(sink, "Uploading %s to %s...\n", name, r)
+		if err := uploadWithMetadata(r, filepath.Join(backupDir, name)); err != nil {
+			result.Pending = pending
+			result.SetError(err)
+			_ = savePendingUploads(backupDir, pending)
+			return result, nil
+		}
+		result.Uploaded = append(result.Uploaded, name)
+		pending = pending[1:]
+	}
+	if !dryRun {
+		if err := savePendingUploads(backupDir, nil); err != nil {
+			result.SetError(fmt.Errorf("updating %s: %w", PendingUploadFile, err))
+			return result, nil
+		}
+	}
+
+	pruned, err := pruneRemote(cfg, r, dryRun, sink)
+	result.Pruned = pruned
+	if err != nil {
+		result.SetError(fmt.Errorf("pruning %s: %w", r, err))
+		return result, nil
+	}
+
+	result.Success = true
+	return result, nil
+}
+
+// uploadWithMetadata uploads archive and, if it has one, its metadata file.
+func uploadWithMetadata(r remote.Remote, archive string) error {
+	if err := r.Upload(archive); err != nil {
+		return fmt.Errorf("uploading %s: %w", filepath.Base(archive), err)
+	}
+	metaPath := metadata.GetMetadataPath(archive)
+	if _, err := os.Stat(metaPath); err == nil {
+		if err = r.Upload(metaPath); err != nil {
+			return fmt.Errorf("uploading %s: %w", filepath.Base(metaPath), err)
+		}
+	}
+	return nil
+}
+
+// pruneRemote removes the backups on r that the retention policy does not
+// keep, with their metadata, and returns the archives removed. Parents of
+// kept incremental backups are found from the remote metadata, downloaded
+// into the download cache.
+func pruneRemote(cfg *config.Config, r remote.Remote, dryRun bool, sink events.Sink) ([]string, error) {
+	policy := cfg.RetentionPolicy()
+	if !policy.Enabled() {
+		return nil, nil
+	}
+	objects, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	cacheDir, err := osutils.DownloadDir()
+	if err != nil {
+		return nil, err
+	}
+	cacheDir = filepath.Join(cacheDir, "remote")
+
+	byTimestamp := make(map[string]*backupGroup)
+	var groups []*backupGroup
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Name, "dotfiles-") {
+			continue
+		}
+		ts := backupTimestamp(obj.Name)
+		g, ok := byTimestamp[ts]
+		if !ok {
+			g = &backupGroup{timestamp: ts}
+			g.created, _ = time.ParseInLocation(timestampLayout, ts, time.Local)
+			byTimestamp[ts] = g
+			groups = append(groups, g)
+		}
+		g.files = append(g.files, obj.Name)
+		if metadata.IsArchiveName(obj.Name) {
+			g.archive = obj.Name
+		}
+	}
+	slices.SortFunc(groups, func(a, b *backupGroup) int { return strings.Compare(b.timestamp, a.timestamp) })
+
+	parents := func(archive string) []string {
+		var chain []string
+		for name := archive; ; {
+			meta, fetchErr := remote.FetchMetadata(r, name, cacheDir)
+			if fetchErr != nil || meta.Parent == "" || slices.Contains(chain, meta.Parent) {
+				return chain
+			}
+			name = meta.Parent
+			chain = append(chain, name)
+		}
+	}
+	kept := retain(groups, policy, parents)
+
+	var pruned []string
+	for _, g := range groups {
+		if g.archive == "" || kept[g.timestamp] != nil {
+			continue
+		}
+		if dryRun {
+			events.Info(sink, "Would remove %s from %s\n", g.archive, r)
+			pruned = append(pruned, g.archive)
+			continue
+		}
+		events.Detail(sink, "Removing old backup from %s: %s\n", r, g.archive)
+		for _, name := range g.files {
+			if err = r.Delete(name); err != nil {
+				return pruned, fmt.Errorf("removing %s: %w", name, err)
+			}
+		}
+		pruned = append(pruned, g.archive)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// iCloudDownloadTimeout bounds how long materializing a single evicted
+// iCloud file may take.
+const iCloudDownloadTimeout = 2 * time.Minute
+
+// materializePlaceholders reports whether cloud placeholders should be
+// downloaded before archiving. --skip-placeholders wins over the config.
+func (b *Backup) materializePlaceholders() bool {
+	if b.opts.SkipPlaceholders {
+		return false
+	}
+	return b.opts.MaterializePlaceholders || b.cfg.Backup.MaterializePlaceholders
+}
+
+// skipPlaceholder records a cloud placeholder that is left out of the archive.
+func (b *Backup) skipPlaceholder(relPath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Placeholders++
+	b.placeholders = append(b.placeholders, relPath)
+}
+
+// resolvePlaceholder decides what to do with a file whose contents may live
+// only in the cloud. Archiving such a file as-is would store an empty stub,
+// so it is either downloaded first or skipped. It reports whether the file
+// should be archived and returns its (possibly refreshed) info.
+func (b *Backup) resolvePlaceholder(path, relPath string, info os.FileInfo) (os.FileInfo, bool) {
+	if !osutils.IsCloudPlaceholder(info) {
+		return info, true
+	}
+
+	if !b.materializePlaceholders() {
+		events.Detail(b.sink, "Skipping cloud placeholder %s\n", path)
+		b.skipPlaceholder(relPath)
+		return info, false
+	}
+
+	events.Detail(b.sink, "Downloading cloud placeholder %s\n", path)
+	downloaded, err := downloadPlaceholder(path)
+	if err != nil {
+		events.Detail(b.sink, "Cannot download %s: %v\n", path, err)
+		b.skipPlaceholder(relPath)
+		return info, false
+	}
+	b.tally(&b.stats.Materialized)
+	return downloaded, true
+}
+
+// resolveICloudStub handles an evicted file that iCloud Drive replaced with a
+// ".name.icloud" stub. The stub itself is never archived; the real file is
+// downloaded first when placeholders are materialized, and skipped otherwise.
+func (b *Backup) resolveICloudStub(target, relTarget string) (os.FileInfo, bool) {
+	if _, err := os.Lstat(target); err == nil {
+		// stale stub next to the real file, which is collected on its own
+		return nil, false
+	}
+
+	if !b.materializePlaceholders() {
+		events.Detail(b.sink, "Skipping evicted iCloud file %s\n", target)
+		b.skipPlaceholder(relTarget)
+		return nil, false
+	}
+
+	events.Detail(b.sink, "Downloading evicted iCloud file %s\n", target)
+	if err := osutils.MaterializeICloudStub(target, iCloudDownloadTimeout); err != nil {
+		events.Detail(b.sink, "Cannot download %s: %v\n", target, err)
+		b.skipPlaceholder(relTarget)
+		return nil, false
+	}
+	info, err := lstatRetry(fsys.OS, target)
+	if err != nil {
+		events.Detail(b.sink, "Cannot stat %s: %v\n", target, err)
+		b.skipPlaceholder(relTarget)
+		return nil, false
+	}
+	b.tally(&b.stats.Materialized)
+	return info, true
+}
+
+// downloadPlaceholder reads a cloud placeholder to the end, which makes the
+// sync provider fetch its contents, and returns the refreshed file info.
+func downloadPlaceholder(path string) (os.FileInfo, error) {
+	err := retryTransient(transientRetries, transientRetryDelay, func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(io.Discard, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lstatRetry(fsys.OS, path)
+}
@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"archive/tar"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+const (
+	// xattrPAXPrefix starts the PAX records that hold the extended
+	// attributes of an entry, as GNU tar and bsdtar write them.
+	xattrPAXPrefix = "SCHILY.xattr."
+	// dotpakXattrPrefix starts the attributes dotpak marks entries with,
+	// such as metadata.FeaturePAXKey, which are not a file's own.
+	dotpakXattrPrefix = "user.dotpak."
+)
+
+// XattrName returns the extended attribute the PAX record key holds, and
+// reports false if it holds none or one of dotpak's own.
+func XattrName(key string) (string, bool) {
+	attr, ok := strings.CutPrefix(key, xattrPAXPrefix)
+	if !ok || attr == "" || strings.HasPrefix(attr, dotpakXattrPrefix) {
+		return "", false
+	}
+	return attr, true
+}
+
+// hardLinks returns, for each of files that is a hard link to an earlier
+// one, the RelPath of the earlier one, so that the archive holds its
+// content once. Sensitive files sealed on their own are left out, as their
+// entries are renamed.
+func (b *Backup) hardLinks(files []FileInfo) map[string]string {
+	links := make(map[string]string)
+	first := make(map[osutils.FileID]string)
+	for _, f := range files {
+		if !f.Mode.IsRegular() || (f.Sensitive && b.sealer != nil) {
+			continue
+		}
+		info, err := b.fsys().Lstat(f.FullPath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		id, nlink, ok := osutils.FileIdentity(info)
+		if !ok || nlink < 2 {
+			continue
+		}
+		if target, seen := first[id]; seen {
+			links[f.RelPath] = target
+		} else {
+			first[id] = f.RelPath
+		}
+	}
+	return links
+}
+
+// writeLink writes f to tw as a hard link to the entry target, with the
+// metadata.FeatureHardlinks feature.
+func writeLink(tw ArchiveWriter, f FileInfo, target string) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeLink,
+		Name:       filepath.ToSlash(f.RelPath),
+		Linkname:   filepath.ToSlash(target),
+		Mode:       int64(f.Mode.Perm()),
+		ModTime:    f.ModTime,
+		PAXRecords: map[string]string{metadata.FeaturePAXKey: metadata.FeatureHardlinks},
+	})
+}
+
+// xattrWriter adds the extended attributes of the file at path to the
+// header of its entry, for backup.preserve = ["xattrs"]. Symlinks have none
+// recorded, and attributes that cannot be read are left out.
+type xattrWriter struct {
+	ArchiveWriter
+	fs   fsys.FS
+	path string
+}
+
+func (w *xattrWriter) WriteHeader(header *tar.Header) error {
+	if header.Typeflag != tar.TypeSymlink {
+		attrs, _ := w.fs.Xattrs(w.path)
+		for attr, value := range attrs {
+			if strings.HasPrefix(attr, dotpakXattrPrefix) || strings.ContainsAny(attr, "=\x00") {
+				continue
+			}
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords[xattrPAXPrefix+attr] = value
+		}
+	}
+	return w.ArchiveWriter.WriteHeader(header)
+}
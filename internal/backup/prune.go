@@ -0,0 +1,285 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/lock"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// PreRestoreDir is the directory inside the backup directory that holds the
+// safety archives restore creates.
+const PreRestoreDir = "pre-restore"
+
+// timestampLayout is the layout of the timestamp in archive names.
+const timestampLayout = "20060102_150405"
+
+// PruneOptions holds prune options.
+type PruneOptions struct {
+	DryRun bool
+	// PreRestore also prunes pre-restore safety archives, keeping the newest
+	// Retention.KeepPreRestore.
+	PreRestore bool
+	// Wait waits for another run that holds the lock on the backup
+	// directory instead of failing.
+	Wait bool
+}
+
+// backupGroup is the set of files in the backup directory that share a
+// timestamp: an archive, its metadata, and sidecars such as checksums.
+type backupGroup struct {
+	timestamp string
+	created   time.Time // zero if the timestamp does not parse
+	archive   string    // empty if only metadata is left
+	files     []string
+}
+
+// Prune removes the backups in the configured backup directory that the
+// retention policy (see config.RetentionPolicy) does not keep, metadata
+// files whose archive is gone, and, with opts.PreRestore, old pre-restore
+// safety archives. Parents of kept incremental backups are always kept.
+// Unless opts.DryRun is set, the backup directory is locked against
+// overlapping runs.
+func Prune(cfg *config.Config, opts PruneOptions, sink events.Sink) (*metadata.PruneResult, error) {
+	if !opts.DryRun {
+		l, err := lock.Acquire(context.Background(), cfg.Backup.BackupDir, "prune", opts.Wait, sink)
+		if err != nil {
+			result := &metadata.PruneResult{Removed: []string{}}
+			result.SetError(err)
+			return result, nil
+		}
+		defer l.Release()
+	}
+	return prune(cfg, opts, sink)
+}
+
+// prune is Prune in a backup directory the caller has locked.
+func prune(cfg *config.Config, opts PruneOptions, sink events.Sink) (*metadata.PruneResult, error) {
+	result := &metadata.PruneResult{DryRun: opts.DryRun, Removed: []string{}}
+	backupDir := cfg.Backup.BackupDir
+
+	groups, err := listBackupGroups(backupDir)
+	if err != nil {
+		result.SetError(fmt.Errorf("reading backup directory: %w", err))
+		return result, nil
+	}
+
+	policy := cfg.RetentionPolicy()
+	var kept map[string][]string
+	if policy.Enabled() {
+		kept = retain(groups, policy, chainParents)
+		keepPendingUploads(backupDir, kept)
+	} else {
+		// no policy at all: keep everything
+		kept = make(map[string][]string)
+		for _, g := range groups {
+			kept[g.timestamp] = []string{"unlimited"}
+		}
+	}
+
+	for _, g := range groups {
+		switch {
+		case g.archive == "":
+			for _, file := range g.files {
+				if !opts.DryRun {
+					events.Detail(sink, "Removing orphaned metadata: %s\n", filepath.Base(file))
+				}
+				result.RemovedMetadata = append(result.RemovedMetadata, filepath.Base(file))
+			}
+			result.FreedBytes += removeFiles(g.files, opts.DryRun, sink)
+		case kept[g.timestamp] != nil:
+			result.Kept = append(result.Kept, metadata.KeptBackup{
+				Archive: filepath.Base(g.archive),
+				Reasons: kept[g.timestamp],
+			})
+		default:
+			if !opts.DryRun {
+				events.Detail(sink, "Removing old backup: %s\n", filepath.Base(g.archive))
+			}
+			result.Removed = append(result.Removed, filepath.Base(g.archive))
+			result.FreedBytes += removeFiles(g.files, opts.DryRun, sink)
+		}
+	}
+
+	if opts.PreRestore && policy.KeepPreRestore > 0 {
+		dir := filepath.Join(backupDir, PreRestoreDir)
+		removed, freed := prunePreRestore(dir, policy.KeepPreRestore, opts.DryRun, sink)
+		result.RemovedPreRestore = removed
+		result.FreedBytes += freed
+	}
+
+	if err = pruneSnapshots(cfg, policy, opts, result, sink); err != nil {
+		result.SetError(fmt.Errorf("pruning snapshots: %w", err))
+		return result, nil
+	}
+
+	result.Success = true
+	return result, nil
+}
+
+// listBackupGroups returns the backups in dir, newest first.
+func listBackupGroups(dir string) ([]*backupGroup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byTimestamp := make(map[string]*backupGroup)
+	var groups []*backupGroup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "dotfiles-") {
+			continue
+		}
+		ts := backupTimestamp(name)
+		g, ok := byTimestamp[ts]
+		if !ok {
+			g = &backupGroup{timestamp: ts}
+			g.created, _ = time.ParseInLocation(timestampLayout, ts, time.Local)
+			byTimestamp[ts] = g
+			groups = append(groups, g)
+		}
+		path := filepath.Join(dir, name)
+		g.files = append(g.files, path)
+		if metadata.IsArchiveName(name) {
+			g.archive = path
+		}
+	}
+
+	slices.SortFunc(groups, func(a, b *backupGroup) int { return strings.Compare(b.timestamp, a.timestamp) })
+	return groups, nil
+}
+
+// retain applies policy to groups, sorted newest first, and returns the
+// timestamps of the backups to keep with the rules that keep them. parents
+// returns the parent archives of an incremental backup.
+func retain(groups []*backupGroup, policy config.RetentionConfig,
+	parents func(archive string) []string) map[string][]string {
+	kept := make(map[string][]string)
+
+	var archives []*backupGroup
+	for _, g := range groups {
+		if g.archive == "" {
+			continue
+		}
+		if g.created.IsZero() {
+			// cannot place it in a period; deleting it would be a guess
+			kept[g.timestamp] = append(kept[g.timestamp], "undated")
+			continue
+		}
+		archives = append(archives, g)
+	}
+
+	rules := []struct {
+		reason string
+		count  int
+		period func(time.Time) string
+	}{
+		{"last", policy.KeepLast, func(t time.Time) string { return t.Format(timestampLayout) }},
+		{"daily", policy.KeepDaily, func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{"weekly", policy.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, rule := range rules {
+		// the newest backup of each period counts, for the newest count periods
+		last, periods := "", 0
+		for _, g := range archives {
+			if periods >= rule.count {
+				break
+			}
+			if period := rule.period(g.created); period != last {
+				last = period
+				periods++
+				kept[g.timestamp] = append(kept[g.timestamp], rule.reason)
+			}
+		}
+	}
+
+	// keep the parents of retained incremental backups, or they could no
+	// longer be restored
+	for _, g := range archives {
+		if _, ok := kept[g.timestamp]; !ok || slices.Contains(kept[g.timestamp], "parent") {
+			continue
+		}
+		for _, archive := range parents(g.archive) {
+			ts := backupTimestamp(filepath.Base(archive))
+			if !slices.Contains(kept[ts], "parent") {
+				kept[ts] = append(kept[ts], "parent")
+			}
+		}
+	}
+	return kept
+}
+
+// chainParents returns the parent archives of an incremental backup in the
+// backup directory, nearest first.
+func chainParents(archive string) []string {
+	chain, _, err := metadata.Chain(archive)
+	if err != nil {
+		return nil
+	}
+	return chain[1:]
+}
+
+// prunePreRestore removes all but the newest keep safety archives in dir.
+func prunePreRestore(dir string, keep int, dryRun bool, sink events.Sink) ([]string, int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			events.Detail(sink, "Cannot read %s: %v\n", dir, err)
+		}
+		return nil, 0
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "pre-restore-") {
+			names = append(names, entry.Name())
+		}
+	}
+	// timestamped names sort oldest first
+	slices.Sort(names)
+	if len(names) <= keep {
+		return nil, 0
+	}
+
+	removed := names[:len(names)-keep]
+	var freed int64
+	for _, name := range removed {
+		if !dryRun {
+			events.Detail(sink, "Removing pre-restore archive: %s\n", name)
+		}
+		freed += removeFiles([]string{filepath.Join(dir, name)}, dryRun, sink)
+	}
+	return removed, freed
+}
+
+// removeFiles deletes paths, unless dryRun, and returns their total size.
+func removeFiles(paths []string, dryRun bool, sink events.Sink) int64 {
+	var size int64
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if !dryRun {
+			if err = os.Remove(path); err != nil {
+				events.Warning(sink, "Failed to remove %s: %v\n", filepath.Base(path), err)
+				continue
+			}
+		}
+		size += info.Size()
+	}
+	return size
+}
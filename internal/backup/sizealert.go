@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/notify"
+)
+
+// sizeAlertWindow is the number of previous backups averaged when checking
+// for an unexpected size change.
+const sizeAlertWindow = 5
+
+// previousSizes returns the archive sizes of the most recent full backups,
+// newest first, for the rolling average. Incremental archives are left out
+// since they hold only changed files. It returns nil when size alerts are off.
+func (b *Backup) previousSizes() []int64 {
+	if b.cfg.Backup.SizeChangeAlertPercent <= 0 {
+		return nil
+	}
+	backups, err := metadata.ListBackups(b.cfg.Backup.BackupDir)
+	if err != nil {
+		return nil
+	}
+	sizes := make([]int64, 0, sizeAlertWindow)
+	for _, info := range backups {
+		if len(sizes) == sizeAlertWindow {
+			break
+		}
+		if info.Parent != "" {
+			continue
+		}
+		sizes = append(sizes, info.Size)
+	}
+	return sizes
+}
+
+// checkSizeChange compares the new archive with the rolling average of
+// previous archive sizes and returns an alert if it differs by more than the
+// configured percentage. The alert is reported as a warning and, if
+// configured, as a desktop notification.
+func (b *Backup) checkSizeChange(archivePath string, previous []int64) *metadata.SizeAlert {
+	if len(previous) == 0 {
+		return nil
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil
+	}
+
+	alert := sizeChangeAlert(info.Size(), previous, b.cfg.Backup.SizeChangeAlertPercent)
+	if alert == nil {
+		return nil
+	}
+
+	direction := "larger"
+	if alert.ChangePercent < 0 {
+		direction = "smaller"
+	}
+	message := fmt.Sprintf("%s is %.0f%% %s than the average of the last %d backups (%s vs %s)",
+		filepath.Base(archivePath), math.Abs(alert.ChangePercent), direction, len(previous),
+		formatSize(alert.ArchiveSize), formatSize(alert.AverageSize))
+	events.Warning(b.sink, "Unexpected backup size change: %s\n", message)
+
+	if b.cfg.Backup.SizeChangeAlertNotify {
+		if notifyErr := notify.Send("dotpak: unexpected backup size", message); notifyErr != nil {
+			events.Detail(b.sink, "Failed to send notification: %v\n", notifyErr)
+		}
+	}
+	return alert
+}
+
+// sizeChangeAlert returns an alert if size differs from the average of
+// previous by more than thresholdPercent, or nil otherwise.
+func sizeChangeAlert(size int64, previous []int64, thresholdPercent int) *metadata.SizeAlert {
+	var total int64
+	for _, s := range previous {
+		total += s
+	}
+	average := total / int64(len(previous))
+	if average == 0 {
+		return nil
+	}
+
+	change := float64(size-average) / float64(average) * 100
+	if math.Abs(change) <= float64(thresholdPercent) {
+		return nil
+	}
+	return &metadata.SizeAlert{
+		ArchiveSize:      size,
+		AverageSize:      average,
+		ChangePercent:    change,
+		ThresholdPercent: thresholdPercent,
+	}
+}
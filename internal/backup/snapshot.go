@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/store"
+)
+
+// StorageObjects is the backup.storage value that keeps backups as
+// snapshots in a content-addressed store.
+const StorageObjects = "objects"
+
+// runSnapshot stores files as a snapshot in the content-addressed store of
+// the backup directory, adding only the contents not stored yet, then prunes
+// snapshots like archives.
+func (b *Backup) runSnapshot(result *metadata.BackupResult, files []FileInfo, began time.Time) *metadata.BackupResult {
+	st := store.Open(b.cfg.Backup.BackupDir)
+
+	start := time.Now()
+	b.hashFiles(files)
+	b.recordIO("hash", start, len(files), b.hashRead.Load(), 0)
+
+	name := "dotfiles-" + time.Now().Format(timestampLayout)
+	events.StartPhase(b.sink, events.PhaseArchive, "Storing snapshot: %s\n", name)
+	start = time.Now()
+	manifest := &store.Manifest{Entries: make([]store.Entry, 0, len(files))}
+	var added int
+	var addedSize int64
+	for i, f := range files {
+		events.FileStarted(b.sink, f.RelPath, i+1, len(files))
+		info, err := lstatRetry(b.fsys(), f.FullPath)
+		if err != nil {
+			events.FileDone(b.sink, f.RelPath, i+1, len(files), 0, err)
+			b.stats.FilesSkipped++
+			continue
+		}
+		entry := store.Entry{
+			Path:    filepath.ToSlash(f.RelPath),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime().Unix(),
+			Size:    info.Size(),
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.Size = 0
+			entry.Link, err = os.Readlink(f.FullPath)
+			entry.Link = filepath.ToSlash(entry.Link)
+		case info.Mode().IsRegular():
+			var size int64
+			entry.SHA256, size, err = st.Put(f.FullPath, f.SHA256)
+			if size > 0 {
+				added++
+				addedSize += size
+			}
+		default:
+			continue
+		}
+		events.FileDone(b.sink, f.RelPath, i+1, len(files), entry.Size, err)
+		if err != nil {
+			events.Warning(b.sink, "Cannot store %s: %v\n", f.RelPath, err)
+			b.stats.FilesSkipped++
+			continue
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	b.recordIO(string(events.PhaseArchive), start, len(files), 0, addedSize)
+	b.stats.FilesBackedUp = len(manifest.Entries)
+
+	meta := metadata.New()
+	manifest.Timestamp = meta.Timestamp
+	manifest.Hostname = meta.Hostname
+	manifest.OSVersion = metadata.GetOSVersion()
+	manifest.HomeDir = b.homeDir
+	manifest.Stats = b.stats
+	manifest.DurationMS = time.Since(began).Milliseconds()
+	if err := st.Save(name, manifest); err != nil {
+		result.SetError(fmt.Errorf("saving snapshot: %w", err))
+		return result
+	}
+	b.recordHistory(metadata.HistoryEntry{
+		Hostname:   manifest.Hostname,
+		Archive:    name,
+		DurationMS: manifest.DurationMS,
+	}, catalog(files))
+
+	events.StartPhase(b.sink, events.PhaseCleanup, "")
+	b.cleanupOldBackups()
+
+	result.Success = true
+	result.Snapshot = name
+	result.Stats = b.stats
+	if b.opts.ProfileIO {
+		result.IOProfile = b.ioProfile
+	}
+
+	events.Success(b.sink, "\nSnapshot complete: %s\n", name)
+	events.Info(b.sink, "  Files: %d\n", b.stats.FilesBackedUp)
+	events.Info(b.sink, "  Skipped: %d\n", b.stats.FilesSkipped)
+	if b.stats.FilesTooLarge > 0 {
+		events.Info(b.sink, "  Too large: %d\n", b.stats.FilesTooLarge)
+	}
+	events.Info(b.sink, "  New objects: %d (%s)\n", added, formatSize(addedSize))
+	return result
+}
+
+// pruneSnapshots removes the snapshots of the store in the backup directory
+// that the retention policy does not keep, then the objects only they
+// referenced, recording them in result.
+func pruneSnapshots(cfg *config.Config, policy config.RetentionConfig, opts PruneOptions,
+	result *metadata.PruneResult, sink events.Sink) error {
+	st := store.Open(cfg.Backup.BackupDir)
+	if !st.Exists() {
+		return nil
+	}
+	names, err := st.Names()
+	if err != nil {
+		return err
+	}
+
+	var removed []string
+	if policy.Enabled() {
+		// newest first, as retain expects
+		slices.Reverse(names)
+		groups := make([]*backupGroup, 0, len(names))
+		for _, name := range names {
+			g := &backupGroup{timestamp: backupTimestamp(name), archive: name}
+			if created, parseErr := time.ParseInLocation(timestampLayout, g.timestamp, time.Local); parseErr == nil {
+				g.created = created
+			}
+			groups = append(groups, g)
+		}
+		kept := retain(groups, policy, chainParents)
+		for _, g := range groups {
+			if kept[g.timestamp] == nil {
+				removed = append(removed, g.archive)
+			}
+		}
+	}
+
+	for _, name := range removed {
+		if !opts.DryRun {
+			events.Detail(sink, "Removing old snapshot: %s\n", name)
+		}
+	}
+	freed, err := st.Remove(removed, opts.DryRun)
+	result.RemovedSnapshots = append(result.RemovedSnapshots, removed...)
+	result.FreedBytes += freed
+	return err
+}
@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// junkKind is a kind of junk that tidy finds, by [excludes] patterns.
+type junkKind struct {
+	name     string
+	patterns []string
+}
+
+// junkKinds are the default [excludes] patterns of files that programs
+// regenerate or leave behind, which tidy deletes; the other defaults, such
+// as *.sh or docs, exclude files that are not worth backing up but are
+// still used.
+var junkKinds = []junkKind{
+	{name: "swap", patterns: []string{"*.swp", "*~", "#*#"}},
+	{name: "compiled", patterns: []string{"*.zwc", "*.pyc"}},
+	{name: "cache", patterns: []string{"__pycache__", "*.cache", ".emacs.d/eln-cache"}},
+	{name: "os", patterns: []string{".DS_Store", "*:Zone.Identifier"}},
+}
+
+// TidyOptions holds tidy options.
+type TidyOptions struct {
+	// Delete deletes the junk found; otherwise it is only reported.
+	Delete bool
+	// Before leaves junk modified at or after it, such as the swap file of
+	// an open editor.
+	Before time.Time
+}
+
+// Tidy finds junk in the directories of the backup items: editor swap
+// files, compiled zsh and python files, caches, and files left by macOS and
+// Windows, which backups exclude by default and programs recreate as
+// needed. With opts.Delete, it deletes them. Sensitive items are not
+// searched.
+func Tidy(cfg *config.Config, opts TidyOptions, sink events.Sink) (*metadata.TidyResult, error) {
+	home, err := osutils.HomeDir()
+	if err != nil {
+		result := &metadata.TidyResult{}
+		result.SetError(err)
+		return result, nil
+	}
+	b := &Backup{cfg: cfg, homeDir: home, sink: sink}
+	return b.tidy(opts), nil
+}
+
+func (b *Backup) tidy(opts TidyOptions) *metadata.TidyResult {
+	result := &metadata.TidyResult{Deleted: opts.Delete, Junk: []metadata.TidyEntry{}}
+	kinds := make([][]ignoreRule, len(junkKinds))
+	for i, k := range junkKinds {
+		kinds[i] = compileExcludes(k.patterns)
+	}
+	junk := func(rel string, isDir bool) string {
+		rel = filepath.ToSlash(rel)
+		for i, rules := range kinds {
+			if slices.ContainsFunc(rules, func(r ignoreRule) bool { return r.match(rel, isDir) }) {
+				return junkKinds[i].name
+			}
+		}
+		return ""
+	}
+
+	backupDir := filepath.Clean(b.cfg.Backup.BackupDir)
+	seen := make(map[string]bool)
+	for _, item := range b.cfg.GetBackupItems() {
+		root := filepath.Join(b.homeDir, item.Path)
+		if info, err := os.Lstat(root); err != nil || !info.IsDir() {
+			continue
+		}
+		walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			rel, _ := filepath.Rel(b.homeDir, path)
+			if err != nil {
+				events.Detail(b.sink, "Cannot read %s: %v\n", rel, err)
+				return nil
+			}
+			if path == backupDir || seen[rel] {
+				return skip(d)
+			}
+			// an item is backed up on purpose, whatever its name
+			if path == root {
+				return nil
+			}
+			kind := junk(rel, d.IsDir())
+			if kind == "" {
+				return nil
+			}
+			seen[rel] = true
+			b.tidyEntry(result, metadata.TidyEntry{Path: filepath.ToSlash(rel), Kind: kind, Dir: d.IsDir()}, opts)
+			return skip(d)
+		})
+		if walkErr != nil {
+			events.Warning(b.sink, "Cannot tidy %s: %v\n", item.Path, walkErr)
+		}
+	}
+
+	result.Success = true
+	return result
+}
+
+// skip returns the error that makes WalkDir skip d: SkipDir for a
+// directory, and nil for a file.
+func skip(d fs.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// tidyEntry sizes the junk entry e and, with opts.Delete, deletes it,
+// adding it to result unless it was modified since opts.Before.
+func (b *Backup) tidyEntry(result *metadata.TidyResult, e metadata.TidyEntry, opts TidyOptions) {
+	full := filepath.Join(b.homeDir, filepath.FromSlash(e.Path))
+	var newest time.Time
+	_ = filepath.WalkDir(full, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, infoErr := d.Info()
+		if infoErr != nil {
+			return nil
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		if !d.IsDir() {
+			e.Files++
+			e.Size += info.Size()
+		}
+		return nil
+	})
+	if !opts.Before.IsZero() && !newest.Before(opts.Before) {
+		result.Kept++
+		events.Detail(b.sink, "Keeping %s, modified %s\n", e.Path, newest.Format(time.DateTime))
+		return
+	}
+
+	if opts.Delete {
+		if err := os.RemoveAll(full); err != nil {
+			e.Error = err.Error()
+			events.Warning(b.sink, "Cannot delete %s: %v\n", e.Path, err)
+		} else {
+			events.Detail(b.sink, "Deleted %s\n", e.Path)
+		}
+	}
+	result.Junk = append(result.Junk, e)
+	if e.Error == "" {
+		result.Files += e.Files
+		result.Size += e.Size
+	}
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/fsys"
+)
+
+const (
+	transientRetries    = 3
+	transientRetryDelay = 100 * time.Millisecond
+)
+
+// transientErrors are filesystem errors that commonly succeed on retry:
+// interrupted syscalls, stale NFS handles, and network filesystem timeouts.
+var transientErrors = []error{
+	syscall.EINTR,
+	syscall.EAGAIN,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+	os.ErrDeadlineExceeded,
+}
+
+// isTransientFSError reports whether err is worth retrying.
+func isTransientFSError(err error) bool {
+	for _, target := range transientErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTransient calls fn until it succeeds, fails with a non-transient
+// error, or has been attempted attempts times. The delay doubles after
+// each failed attempt.
+func retryTransient(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= attempts || !isTransientFSError(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// lstatRetry is fs.Lstat with retries on transient errors.
+func lstatRetry(fs fsys.FS, path string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := retryTransient(transientRetries, transientRetryDelay, func() error {
+		var statErr error
+		info, statErr = fs.Lstat(path)
+		return statErr
+	})
+	return info, err
+}
+
+// openRetry is fs.Open with retries on transient errors.
+func openRetry(fs fsys.FS, path string) (fsys.File, error) {
+	var file fsys.File
+	err := retryTransient(transientRetries, transientRetryDelay, func() error {
+		var openErr error
+		file, openErr = fs.Open(path)
+		return openErr
+	})
+	return file, err
+}
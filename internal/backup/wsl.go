@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"path/filepath"
+	"slices"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// WindowsPrefix is the directory of archives that holds the files of
+// wsl.items, relative to the Windows user profile, apart from the Linux
+// dotfiles so that restore can write them back to the Windows side.
+const WindowsPrefix = "wsl-windows"
+
+// windowsJunk are the files Windows leaves in the user profile, such as
+// Explorer's folder settings and thumbnail caches and Office lock files,
+// which are left out of wsl.items.
+var windowsJunk = []string{
+	"desktop.ini", "Thumbs.db", "ehthumbs.db", "*:Zone.Identifier", "$RECYCLE.BIN/", "~$*",
+}
+
+// WindowsHome returns the Windows user profile that wsl.items are relative
+// to, as a Linux path: wsl.windows_home if set and, in WSL, the one
+// osutils.WindowsHome finds. Elsewhere it returns "".
+func WindowsHome(cfg *config.Config) (string, error) {
+	if cfg.WSL.WindowsHome != "" {
+		return cfg.WSL.WindowsHome, nil
+	}
+	if !osutils.IsWSL() {
+		return "", nil
+	}
+	return osutils.WindowsHome()
+}
+
+// windowsRoot returns the Windows user profile to collect wsl.items from,
+// or "" if there are none to collect.
+func (b *Backup) windowsRoot() string {
+	if len(b.cfg.WSL.Items) == 0 {
+		return ""
+	}
+	root, err := WindowsHome(b.cfg)
+	if err != nil {
+		events.Warning(b.sink, "Skipping wsl.items: %v\n", err)
+		return ""
+	}
+	if root != "" {
+		events.Detail(b.sink, "Collecting wsl.items from %s\n", root)
+	}
+	return root
+}
+
+// collectWindowsItem collects the item at relPath of the Windows user
+// profile root, without windowsJunk, under WindowsPrefix.
+func (b *Backup) collectWindowsItem(root, relPath string) ([]FileInfo, error) {
+	relPath = filepath.Clean(filepath.FromSlash(relPath))
+	if !filepath.IsLocal(relPath) {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "wsl.items must be relative to the Windows user profile")
+	}
+	rules := slices.Concat(b.excludeRules(), compileExcludes(windowsJunk))
+	files, err := b.collectItemIn(root, relPath, rules)
+	for i := range files {
+		files[i].RelPath = filepath.Join(WindowsPrefix, files[i].RelPath)
+	}
+	return files, err
+}
@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"strings"
+)
+
+// ArchiveWriter writes archive entries as tar headers followed by their
+// content. *tar.Writer and *ZipWriter implement it.
+type ArchiveWriter interface {
+	WriteHeader(header *tar.Header) error
+	io.Writer
+}
+
+// ZipWriter writes tar entries to a zip file, for backup.format = "zip".
+// Symlinks are stored the way Info-ZIP does, as entries with the symlink
+// mode holding the link target. Entries zip cannot represent, such as hard
+// links and devices, are skipped.
+type ZipWriter struct {
+	zw *zip.Writer
+	w  io.Writer
+}
+
+// NewZipWriter creates a ZipWriter writing to w.
+func NewZipWriter(w io.Writer) *ZipWriter {
+	return &ZipWriter{zw: zip.NewWriter(w)}
+}
+
+// WriteHeader starts a new entry; Write then writes the content of a
+// regular file.
+func (z *ZipWriter) WriteHeader(header *tar.Header) error {
+	z.w = nil
+	fh := &zip.FileHeader{Name: header.Name, Method: zip.Deflate, Modified: header.ModTime}
+	fh.SetMode(header.FileInfo().Mode())
+	switch header.Typeflag {
+	case tar.TypeDir:
+		fh.Name = strings.TrimSuffix(fh.Name, "/") + "/"
+		fh.Method = zip.Store
+	case tar.TypeSymlink:
+		fh.Method = zip.Store
+	case tar.TypeReg:
+	default:
+		return nil
+	}
+
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(w, header.Linkname)
+		return err
+	}
+	if header.Typeflag == tar.TypeReg {
+		z.w = w
+	}
+	return nil
+}
+
+// Write writes content of the current regular file entry. Content of
+// skipped entries is discarded.
+func (z *ZipWriter) Write(p []byte) (int, error) {
+	if z.w == nil {
+		return len(p), nil
+	}
+	return z.w.Write(p)
+}
+
+// Close finishes the zip file by writing the central directory.
+func (z *ZipWriter) Close() error {
+	return z.zw.Close()
+}
@@ -3,12 +3,18 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 
+	"github.com/ospiem/dotpak/internal/errs"
 	"github.com/ospiem/dotpak/internal/osutils"
 )
 
@@ -18,21 +24,444 @@ type Config struct {
 	Items     []string              `toml:"items"`
 	Sensitive []string              `toml:"sensitive"`
 	Excludes  ExcludesConfig        `toml:"excludes"`
+	Remote    RemoteConfig          `toml:"remote"`
+	Retention RetentionConfig       `toml:"retention"`
+	Packages  PackagesConfig        `toml:"packages"`
+	Hooks     HooksConfig           `toml:"hooks"`
+	Rewrite   RewriteConfig         `toml:"rewrite"`
 	Profiles  map[string]Profile    `toml:"profile"`
 	Hosts     map[string]HostConfig `toml:"host"`
+	// HostGroups share host settings between several machines.
+	HostGroups map[string]HostGroup `toml:"host-group"`
+	// Notifications report the outcome of backups, such as scheduled ones
+	// that nobody watches.
+	Notifications NotificationsConfig `toml:"notifications"`
+	// Validate selects the syntax checks of restored config files.
+	Validate ValidateConfig `toml:"validate"`
+	// Restore sets the modes of restored files.
+	Restore RestoreConfig `toml:"restore"`
+	// WSL selects the configs of the Windows side of a WSL installation.
+	WSL WSLConfig `toml:"wsl"`
+	// Logging writes a log of each run to a file.
+	Logging LoggingConfig `toml:"logging"`
+
+	Categories map[string]CategoryConfig `toml:"categories"`
+	// ItemConfigs are [[item]] tables: items backed up like entries of Items,
+	// with settings of their own.
+	ItemConfigs []ItemConfig `toml:"item"`
+}
+
+// ItemConfig is a backup item declared as an [[item]] table.
+type ItemConfig struct {
+	Path string `toml:"path"`
+	// PostRestore is a shell command run in the home directory after a
+	// restore that wrote any file under Path.
+	PostRestore string `toml:"post_restore"`
+	// MaxFileSize overrides backup.max_file_size for files under Path;
+	// "0" backs up files of any size.
+	MaxFileSize string `toml:"max_file_size"`
+	// After names the items, by path, or categories whose post_restore
+	// commands run before this one's.
+	After []string `toml:"after"`
+}
+
+// CategoryConfig defines a restore category, or extends the built-in one
+// of the same name.
+type CategoryConfig struct {
+	Prefixes []string `toml:"prefixes"` // paths relative to home
+	Replace  bool     `toml:"replace"`  // drop the built-in prefixes
+	// After names the categories restored before this one.
+	After []string `toml:"after"`
 }
 
 // BackupConfig holds backup-related settings.
 type BackupConfig struct {
-	BackupDir        string   `toml:"backup_dir"`
-	MaxBackups       int      `toml:"max_backups"`
-	Encryption       string   `toml:"encryption"`
-	AgeRecipients    string   `toml:"age_recipients"`
-	AgeIdentityFiles []string `toml:"age_identity_files"`
-	GPGRecipient     string   `toml:"gpg_recipient"`
+	BackupDir               string   `toml:"backup_dir"`
+	MaxBackups              int      `toml:"max_backups"`
+	Encryption              string   `toml:"encryption"`
+	AgeRecipients           string   `toml:"age_recipients"`
+	AgeIdentityFiles        []string `toml:"age_identity_files"`
+	AgeIdentityDiscovery    bool     `toml:"age_identity_discovery"`
+	GPGRecipient            string   `toml:"gpg_recipient"`
+	PassphraseFile          string   `toml:"passphrase_file"`
+	ResultWebhookURL        string   `toml:"result_webhook_url"`
+	ResultWebhookSecret     string   `toml:"result_webhook_secret"`
+	MaterializePlaceholders bool     `toml:"materialize_placeholders"`
+	ShellSnapshot           bool     `toml:"shell_snapshot"`
+	SizeChangeAlertPercent  int      `toml:"size_change_alert_percent"`
+	SizeChangeAlertNotify   bool     `toml:"size_change_alert_notify"`
+	SignArchives            bool     `toml:"sign_archives"`
+	HMACKeyFile             string   `toml:"hmac_key_file"`
+	MinisignPublicKeys      []string `toml:"minisign_public_keys"`
+	VerifySchedule          string   `toml:"verify_schedule"`
+	Format                  string   `toml:"format"`
+	// Storage is "archive" (the default) for an archive per backup, or
+	// "objects" for snapshots in a content-addressed store that keeps each
+	// file content once.
+	Storage string `toml:"storage"`
+	// HardwareKey is "tpm" or "secure-enclave" when age_recipients includes
+	// a key sealed to this device, set up by dotpak hardware-key init.
+	HardwareKey string `toml:"hardware_key"`
+	// MaxFileSize is the largest file backed up, such as "100MB"; larger
+	// files are skipped with a warning. Empty backs up files of any size.
+	MaxFileSize string `toml:"max_file_size"`
+	// DestinationWait is how long to retry, such as "10m", when backup_dir
+	// is unavailable, like a network mount whose server is asleep. Empty
+	// fails at once.
+	DestinationWait string `toml:"destination_wait"`
+	// SpoolDir is a local directory backups are written to when backup_dir
+	// is still unavailable after destination_wait. The next backup that
+	// reaches backup_dir moves them there.
+	SpoolDir string `toml:"spool_dir"`
+	// SensitiveEncryption is "age" to back up sensitive files into archives
+	// that are not encrypted, each file encrypted on its own to
+	// age_recipients.
+	SensitiveEncryption string `toml:"sensitive_encryption"`
+	// NixSymlinks is "link" (the default), "skip", or "dereference" for
+	// symlinks into the Nix store, such as those of home-manager.
+	NixSymlinks string `toml:"nix_symlinks"`
+	// Preserve lists what backup records and restore applies beyond the
+	// content and permissions of files: "xattrs", "hardlinks", "times", and
+	// "owner".
+	Preserve []string `toml:"preserve"`
+}
+
+// ParseDestinationWait parses destination_wait. An empty value is zero.
+func (b BackupConfig) ParseDestinationWait() (time.Duration, error) {
+	value := strings.TrimSpace(b.DestinationWait)
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, errs.Errorf(errs.ErrConfigInvalid,
+			"backup.destination_wait must be a duration such as 30s or 10m (got %q)", value)
+	}
+	return wait, nil
+}
+
+// VerifySchedule says how often a random older backup is verified after a
+// backup: after every Every backups, or once per Interval. The zero value
+// disables the self-test.
+type VerifySchedule struct {
+	Every    int
+	Interval time.Duration
+}
+
+// ParseVerifySchedule parses verify_schedule: a number N for every Nth
+// backup, "daily", or "weekly". An empty value disables the self-test.
+func (b BackupConfig) ParseVerifySchedule() (VerifySchedule, error) {
+	switch value := strings.TrimSpace(b.VerifySchedule); value {
+	case "":
+		return VerifySchedule{}, nil
+	case "daily":
+		return VerifySchedule{Interval: 24 * time.Hour}, nil
+	case "weekly":
+		return VerifySchedule{Interval: 7 * 24 * time.Hour}, nil
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return VerifySchedule{}, errs.Errorf(errs.ErrConfigInvalid,
+				"backup.verify_schedule must be a positive number of backups, daily, or weekly (got %q)", value)
+		}
+		return VerifySchedule{Every: n}, nil
+	}
+}
+
+// Preserve is what backup.preserve asks to keep of files.
+type Preserve struct {
+	// Xattrs keeps extended attributes, such as com.apple.metadata; restore
+	// sets only those of the user namespace, and com.apple on macOS.
+	Xattrs bool
+	// Hardlinks archives the names of a file linked more than once as links
+	// to the first, and restores them linked.
+	Hardlinks bool
+	// Times restores modification times.
+	Times bool
+	// Owner restores the owning user and group, when run as root.
+	Owner bool
+}
+
+// ParsePreserve parses preserve, a list of xattrs, hardlinks, times, and
+// owner.
+func (b BackupConfig) ParsePreserve() (Preserve, error) {
+	var p Preserve
+	for _, value := range b.Preserve {
+		switch strings.TrimSpace(value) {
+		case "xattrs":
+			p.Xattrs = true
+		case "hardlinks":
+			p.Hardlinks = true
+		case "times":
+			p.Times = true
+		case "owner":
+			p.Owner = true
+		default:
+			return Preserve{}, errs.Errorf(errs.ErrConfigInvalid,
+				"backup.preserve entries must be xattrs, hardlinks, times, or owner (got %q)", value)
+		}
+	}
+	return p, nil
+}
+
+// FileSizeLimits are the max_file_size settings in bytes, where 0 is no
+// limit.
+type FileSizeLimits struct {
+	Default int64
+	// Items holds the limits of [[item]] tables that set one, by path.
+	Items map[string]int64
+}
+
+// For returns the limit of the files under item.
+func (l FileSizeLimits) For(item string) int64 {
+	if limit, ok := l.Items[item]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// FileSizeLimits parses backup.max_file_size and the max_file_size of the
+// [[item]] tables.
+func (c *Config) FileSizeLimits() (FileSizeLimits, error) {
+	var limits FileSizeLimits
+	var err error
+	if limits.Default, err = ParseSize(c.Backup.MaxFileSize); err != nil {
+		return limits, errs.Errorf(errs.ErrConfigInvalid, "backup.max_file_size: %v", err)
+	}
+	for i, item := range c.ItemConfigs {
+		if strings.TrimSpace(item.MaxFileSize) == "" {
+			continue
+		}
+		limit, parseErr := ParseSize(item.MaxFileSize)
+		if parseErr != nil {
+			return limits, errs.Errorf(errs.ErrConfigInvalid, "item[%d].max_file_size: %v", i, parseErr)
+		}
+		if limits.Items == nil {
+			limits.Items = make(map[string]int64)
+		}
+		limits.Items[item.Path] = limit
+	}
+	return limits, nil
+}
+
+// sizeUnits are the units ParseSize accepts, in powers of 1024 like the
+// sizes dotpak prints.
+var sizeUnits = map[string]float64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+	"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+}
+
+// ParseSize parses a size such as "500KB", "1.5 GB", or a number of bytes.
+// An empty size is 0.
+func ParseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	number := strings.TrimRightFunc(value, func(r rune) bool {
+		return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z'
+	})
+	unit, ok := sizeUnits[strings.ToUpper(value[len(number):])]
+	n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 500KB, 100MB, 2GB)", value)
+	}
+	return int64(n * unit), nil
+}
+
+// IntegrityKeyPath returns the HMAC key file used to sign and verify
+// archives, defaulting to hmac.key next to the default config file.
+func (b BackupConfig) IntegrityKeyPath() string {
+	if b.HMACKeyFile != "" {
+		return b.HMACKeyFile
+	}
+	if path := DefaultConfigPath(); path != "" {
+		return filepath.Join(filepath.Dir(path), "hmac.key")
+	}
+	return ""
 }
 
-// ExcludesConfig holds file exclusion patterns.
+// RetentionConfig is a GFS-style retention policy: the newest KeepLast
+// backups are kept, plus the newest backup of each of the last KeepDaily
+// days, KeepWeekly weeks, and KeepMonthly months that have one.
+type RetentionConfig struct {
+	KeepLast    int `toml:"keep_last"`
+	KeepDaily   int `toml:"keep_daily"`
+	KeepWeekly  int `toml:"keep_weekly"`
+	KeepMonthly int `toml:"keep_monthly"`
+	// KeepPreRestore is the number of pre-restore safety archives that
+	// dotpak prune keeps; 0 keeps all of them.
+	KeepPreRestore int `toml:"keep_pre_restore"`
+}
+
+// Enabled reports whether the policy keeps backups by age, as opposed to
+// leaving retention to max_backups.
+func (r RetentionConfig) Enabled() bool {
+	return r.KeepLast > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0
+}
+
+// RetentionPolicy returns the policy old backups are pruned by: the
+// [retention] section if it sets any keep_* count, otherwise the newest
+// max_backups backups.
+func (c *Config) RetentionPolicy() RetentionConfig {
+	if c.Retention.Enabled() {
+		return c.Retention
+	}
+	policy := c.Retention
+	policy.KeepLast = c.Backup.MaxBackups
+	return policy
+}
+
+// PackagesConfig selects the package managers whose installed packages are
+// snapshotted into the backup directory.
+type PackagesConfig struct {
+	// Managers lists the package managers to snapshot; empty means every
+	// supported one that is installed.
+	Managers []string `toml:"managers"`
+	// Skip lists package managers never snapshotted.
+	Skip []string `toml:"skip"`
+}
+
+// HooksConfig holds shell commands run before and after backups and
+// restores.
+type HooksConfig struct {
+	PreBackup   []string `toml:"pre_backup"`
+	PostBackup  []string `toml:"post_backup"`
+	PreRestore  []string `toml:"pre_restore"`
+	PostRestore []string `toml:"post_restore"`
+	// OnFailure is "abort" (the default) to fail the operation when a hook
+	// fails, or "warn" to only report it.
+	OnFailure string `toml:"on_failure"`
+}
+
+// NotificationsConfig holds where the outcome of backups is reported.
+type NotificationsConfig struct {
+	// On is "failure" (the default) to notify of failed backups only,
+	// "always" to notify of every backup, or "never".
+	On string `toml:"on"`
+	// Desktop shows a notification with osascript on macOS and notify-send
+	// on Linux.
+	Desktop bool `toml:"desktop"`
+	// WebhookURL receives a JSON POST with "text" and "content" fields, the
+	// message fields of Slack and Discord incoming webhooks.
+	WebhookURL string `toml:"webhook_url"`
+	// EmailTo receives an email sent through SMTPHost from EmailFrom. The
+	// SMTP password is read from DOTPAK_SMTP_PASSWORD or the first line of
+	// SMTPPasswordFile.
+	EmailTo          []string `toml:"email_to"`
+	EmailFrom        string   `toml:"email_from"`
+	SMTPHost         string   `toml:"smtp_host"`
+	SMTPPort         int      `toml:"smtp_port"`
+	SMTPUsername     string   `toml:"smtp_username"`
+	SMTPPasswordFile string   `toml:"smtp_password_file"`
+}
+
+// ValidateConfig selects the syntax checks run on restored config files,
+// such as zsh -n on .zshrc, by restore --validate and verify --in-container.
+type ValidateConfig struct {
+	// AfterRestore runs the checks after every restore, as --validate does.
+	AfterRestore bool `toml:"after_restore"`
+	// Categories limits the checks to these categories; empty means all of
+	// them (shell, git, terminal, ssh).
+	Categories []string `toml:"categories"`
+}
+
+// RestoreConfig sets the modes restore gives files and directories instead
+// of those recorded in the archive.
+type RestoreConfig struct {
+	// Umask clears the bits of the current umask from the recorded modes,
+	// as --umask does.
+	Umask bool `toml:"umask"`
+	// DefaultFileMode and DefaultDirMode, in octal such as "0644", replace
+	// recorded modes that would lock the owner out: files the owner cannot
+	// read, such as mode 000, and directories the owner cannot read, write,
+	// or search. Empty means 0644 and 0755.
+	DefaultFileMode string `toml:"default_file_mode"`
+	DefaultDirMode  string `toml:"default_dir_mode"`
+	// PrivateCategories are restored without group and other permissions,
+	// whatever modes the archive records, such as ["ssh", "gpg"].
+	PrivateCategories []string `toml:"private_categories"`
+}
+
+// DefaultModes parses default_file_mode and default_dir_mode.
+func (r RestoreConfig) DefaultModes() (file, dir os.FileMode, err error) {
+	if file, err = parseMode(r.DefaultFileMode, 0o644); err != nil {
+		return 0, 0, errs.Errorf(errs.ErrConfigInvalid, "restore.default_file_mode: %v", err)
+	}
+	if dir, err = parseMode(r.DefaultDirMode, 0o755); err != nil {
+		return 0, 0, errs.Errorf(errs.ErrConfigInvalid, "restore.default_dir_mode: %v", err)
+	}
+	return file, dir, nil
+}
+
+// parseMode parses an octal permission mode such as "0600"; an empty value
+// is def.
+func parseMode(value string, def os.FileMode) (os.FileMode, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q (want octal, e.g. 0644)", value)
+	}
+	return os.FileMode(n), nil
+}
+
+// WSLConfig backs up configs of the Windows side of a WSL installation, such
+// as Windows Terminal and VS Code settings, with the Linux dotfiles.
+type WSLConfig struct {
+	// Items are paths relative to the Windows user profile, backed up when
+	// dotpak runs in WSL and restored to the same place.
+	Items []string `toml:"items"`
+	// WindowsHome is the Windows user profile as a Linux path, such as
+	// /mnt/c/Users/me; empty asks cmd.exe for %USERPROFILE%.
+	WindowsHome string `toml:"windows_home"`
+}
+
+// LoggingConfig writes the messages of each run, with timestamps, to a log
+// file, so that scheduled runs leave a record to look back at.
+type LoggingConfig struct {
+	// File is the log file; empty disables logging.
+	File string `toml:"file"`
+	// Level is the least level logged: debug, info (default), warn, or
+	// error. Debug logs the messages of --verbose.
+	Level string `toml:"level"`
+	// MaxSize is the size past which the file is rotated to File.1, and
+	// MaxFiles the number of rotated files kept; 10MB and 3 by default.
+	MaxSize  string `toml:"max_size"`
+	MaxFiles int    `toml:"max_files"`
+}
+
+// RewriteConfig rewrites config files on restore, for backups made by
+// another user or on another machine. The home directory recorded with a
+// backup is always replaced by the one restored to.
+type RewriteConfig struct {
+	// Map maps other strings to replace, such as paths outside the home
+	// directory, to their replacements.
+	Map map[string]string `toml:"map"`
+	// Files are globs, relative to home, of the files rewritten; empty
+	// means a built-in list of shell, git, ssh, and editor configs.
+	Files []string `toml:"files"`
+}
+
+// RemoteConfig holds the remote destination that backups are uploaded to.
+// Credentials are read from the environment, not the config file.
+type RemoteConfig struct {
+	URL      string `toml:"url"`      // s3://bucket/prefix, sftp://user@host/path, or https://host/path (WebDAV)
+	Region   string `toml:"region"`   // S3 region
+	Endpoint string `toml:"endpoint"` // S3-compatible endpoint URL
+	Username string `toml:"username"` // WebDAV username
+
+	// Spool keeps a backup that fails to upload locally, flagged as pending
+	// upload, for the next backup or upload-pending to retry.
+	Spool bool `toml:"spool"`
+}
+
+// ExcludesConfig holds file exclusion patterns, in gitignore syntax.
 type ExcludesConfig struct {
 	Patterns []string `toml:"patterns"`
 }
@@ -44,10 +473,37 @@ type Profile struct {
 	ExtraItems     []string       `toml:"extra_items"`
 	ExtraSensitive []string       `toml:"extra_sensitive"`
 	Excludes       ExcludesConfig `toml:"excludes"`
+	Backup         ProfileBackup  `toml:"backup"`
+}
+
+// ProfileBackup holds the [backup] settings a profile overrides, so that a
+// profile can back up into its own directory with its own key. Empty values
+// keep the [backup] ones.
+type ProfileBackup struct {
+	BackupDir      string `toml:"backup_dir"`
+	Encryption     string `toml:"encryption"`
+	AgeRecipients  string `toml:"age_recipients"`
+	GPGRecipient   string `toml:"gpg_recipient"`
+	PassphraseFile string `toml:"passphrase_file"`
+	// MaxBackups is a pointer since 0, which keeps every backup, is a
+	// valid override.
+	MaxBackups *int `toml:"max_backups"`
 }
 
 // HostConfig represents hostname-specific settings.
 type HostConfig struct {
+	// Aliases are other hostnames of the same machine, e.g. the names a
+	// laptop gets on different networks.
+	Aliases        []string       `toml:"aliases"`
+	ExtraItems     []string       `toml:"extra_items"`
+	ExtraSensitive []string       `toml:"extra_sensitive"`
+	Excludes       ExcludesConfig `toml:"excludes"`
+}
+
+// HostGroup applies host settings to each of its members, given as hostnames
+// or [host] names.
+type HostGroup struct {
+	Members        []string       `toml:"members"`
 	ExtraItems     []string       `toml:"extra_items"`
 	ExtraSensitive []string       `toml:"extra_sensitive"`
 	Excludes       ExcludesConfig `toml:"excludes"`
@@ -107,6 +563,23 @@ func DefaultConfig() *Config {
 			// AI tools (settings)
 			".claude/settings.json", ".claude/projects",
 			".codex/config.toml", ".codex/skills",
+			// Windows: PowerShell profiles, WSL, Windows Terminal, VS Code, Neovim
+			"Documents/PowerShell/Microsoft.PowerShell_profile.ps1", "Documents/PowerShell/profile.ps1",
+			"Documents/WindowsPowerShell/Microsoft.PowerShell_profile.ps1", "Documents/WindowsPowerShell/profile.ps1",
+			".wslconfig",
+			"AppData/Local/Packages/Microsoft.WindowsTerminal_8wekyb3d8bbwe/LocalState/settings.json",
+			"AppData/Local/Packages/Microsoft.WindowsTerminalPreview_8wekyb3d8bbwe/LocalState/settings.json",
+			"AppData/Local/Microsoft/Windows Terminal/settings.json",
+			"AppData/Roaming/Code/User/settings.json", "AppData/Roaming/Code/User/keybindings.json",
+			"AppData/Local/nvim",
+		},
+		WSL: WSLConfig{
+			Items: []string{
+				".wslconfig",
+				"AppData/Local/Packages/Microsoft.WindowsTerminal_8wekyb3d8bbwe/LocalState/settings.json",
+				"AppData/Roaming/Code/User/settings.json", "AppData/Roaming/Code/User/keybindings.json",
+				"Documents/PowerShell/Microsoft.PowerShell_profile.ps1",
+			},
 		},
 		Sensitive: []string{
 			// SSH
@@ -131,6 +604,7 @@ func DefaultConfig() *Config {
 			".zsh_history",
 			".bash_history",
 			".lesshst",
+			"AppData/Roaming/Microsoft/Windows/PowerShell/PSReadLine",
 			// AI tools (auth/tokens)
 			".claude.json",
 			".codex/auth.json",
@@ -141,6 +615,8 @@ func DefaultConfig() *Config {
 				// general
 				".git", ".idea", "*.log", "*.swp", "*.bak",
 				".DS_Store", "*.sock", "*.cache",
+				// left by Windows Explorer when copying files into WSL
+				"*:Zone.Identifier",
 				// CI/CD and dev artifacts
 				".circleci", ".github", ".travis.yml", ".gitlab-ci.yml",
 				"Makefile", "Dockerfile", "*.md", "LICENSE*", "COPYING*",
@@ -178,8 +654,9 @@ func DefaultConfig() *Config {
 				".revision-hash", ".version",
 			},
 		},
-		Profiles: make(map[string]Profile),
-		Hosts:    make(map[string]HostConfig),
+		Profiles:   make(map[string]Profile),
+		Hosts:      make(map[string]HostConfig),
+		HostGroups: make(map[string]HostGroup),
 	}
 }
 
@@ -193,24 +670,64 @@ func DefaultConfigPath() string {
 	return filepath.Join(home, ".config", "dotpak", "config.toml")
 }
 
-// Load reads configuration from a TOML file.
+// FragmentDir returns the drop-in directory whose *.toml files are merged
+// into the config file at path.
+func FragmentDir(path string) string {
+	return filepath.Join(filepath.Dir(path), "conf.d")
+}
+
+// Load reads configuration from a TOML file and the files it includes. The
+// fragments in the conf.d directory are merged only for the default config
+// path, so that a config given elsewhere, such as --config /tmp/x.toml, does
+// not merge *.toml files that happen to sit next to it, with the hooks and
+// post_restore commands they may hold.
 func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return DefaultConfig(), nil // use defaults if config doesn't exist
-		}
-		return nil, fmt.Errorf("reading config: %w", err)
+	return load(path, isDefaultConfigPath(path))
+}
+
+// isDefaultConfigPath reports whether path is DefaultConfigPath.
+func isDefaultConfigPath(path string) bool {
+	defaultPath := DefaultConfigPath()
+	if defaultPath == "" {
+		return false
 	}
+	abs, err := filepath.Abs(path)
+	return err == nil && abs == filepath.Clean(defaultPath)
+}
 
-	// start with empty config so config file completely replaces defaults
-	cfg := &Config{
-		Profiles: make(map[string]Profile),
-		Hosts:    make(map[string]HostConfig),
+// load reads configuration like Load, merging the conf.d fragments if
+// fragments is set.
+func load(path string, fragments bool) (*Config, error) {
+	_, err := os.Stat(path)
+	var cfg *Config
+	merged := make(map[string]bool)
+	switch {
+	case os.IsNotExist(err):
+		cfg = DefaultConfig() // use defaults if config doesn't exist
+	case err != nil:
+		return nil, fmt.Errorf("reading config: %w", err)
+	default:
+		// start with empty config so config file completely replaces defaults
+		cfg = &Config{
+			Profiles:   make(map[string]Profile),
+			Hosts:      make(map[string]HostConfig),
+			HostGroups: make(map[string]HostGroup),
+		}
+		if err = cfg.mergeFile(path, nil, merged); err != nil {
+			return nil, err
+		}
 	}
 
-	if _, decodeErr := toml.Decode(string(data), cfg); decodeErr != nil {
-		return nil, fmt.Errorf("parsing config: %w", decodeErr)
+	if fragments {
+		paths, globErr := filepath.Glob(filepath.Join(FragmentDir(path), "*.toml"))
+		if globErr != nil {
+			return nil, fmt.Errorf("listing config fragments: %w", globErr)
+		}
+		for _, fragment := range paths {
+			if err = cfg.mergeFile(fragment, nil, merged); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if cfg.Backup.MaxBackups == 0 {
@@ -223,6 +740,12 @@ func Load(path string) (*Config, error) {
 	cfg.Backup.BackupDir = expandPath(cfg.Backup.BackupDir)
 	cfg.Backup.AgeRecipients = expandPath(cfg.Backup.AgeRecipients)
 	cfg.Backup.AgeIdentityFiles = expandPaths(cfg.Backup.AgeIdentityFiles)
+	cfg.Backup.HMACKeyFile = expandPath(cfg.Backup.HMACKeyFile)
+	cfg.Backup.PassphraseFile = expandPath(cfg.Backup.PassphraseFile)
+	cfg.Notifications.SMTPPasswordFile = expandPath(cfg.Notifications.SMTPPasswordFile)
+	cfg.Backup.SpoolDir = expandPath(cfg.Backup.SpoolDir)
+	cfg.WSL.WindowsHome = expandPath(cfg.WSL.WindowsHome)
+	cfg.Logging.File = expandPath(cfg.Logging.File)
 
 	// expand ~ in Items and Sensitive paths
 	for i, item := range cfg.Items {
@@ -231,10 +754,84 @@ func Load(path string) (*Config, error) {
 	for i, item := range cfg.Sensitive {
 		cfg.Sensitive[i] = expandPath(item)
 	}
+	// [[item]] paths stay relative to home, like the items backup collects
+	for i := range cfg.ItemConfigs {
+		path := strings.TrimPrefix(strings.TrimSpace(cfg.ItemConfigs[i].Path), "~/")
+		cfg.ItemConfigs[i].Path = path
+		if path != "" && !slices.Contains(cfg.Items, path) {
+			cfg.Items = append(cfg.Items, path)
+		}
+	}
 
 	return cfg, nil
 }
 
+// includes lists the files a config file includes.
+type includes struct {
+	Include []string `toml:"include"`
+}
+
+// mergeFile merges the config file at path into c, after the files it
+// includes. Items, [[item]] tables, sensitive items, and exclude patterns are
+// appended; every other value the file sets, including whole profiles and
+// hosts, overrides the current one. chain holds the files currently being
+// included, to detect cycles, and merged the files already merged, which are
+// skipped so that a base included by two files is merged once.
+func (c *Config) mergeFile(path string, chain []string, merged map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", path, err)
+	}
+	if slices.Contains(chain, abs) {
+		return errs.Errorf(errs.ErrConfigInvalid, "include cycle: %s", strings.Join(append(chain, abs), " -> "))
+	}
+	if merged[abs] {
+		return nil
+	}
+	chain = append(chain, abs)
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		if len(chain) > 1 && os.IsNotExist(err) {
+			return errs.Errorf(errs.ErrConfigInvalid, "included config not found: %s (from %s)", abs, chain[len(chain)-2])
+		}
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	var inc includes
+	if _, err = toml.Decode(string(data), &inc); err != nil {
+		return errs.Errorf(errs.ErrConfigInvalid, "parsing %s: %w", filepath.Base(abs), err)
+	}
+	for _, included := range inc.Include {
+		included = expandPath(included)
+		if !filepath.IsAbs(included) {
+			included = filepath.Join(filepath.Dir(abs), included)
+		}
+		if err = c.mergeFile(included, chain, merged); err != nil {
+			return err
+		}
+	}
+
+	var fragment Config
+	if _, err = toml.Decode(string(data), &fragment); err != nil {
+		return errs.Errorf(errs.ErrConfigInvalid, "parsing %s: %w", filepath.Base(abs), err)
+	}
+
+	// the decoder reuses slice backing arrays, so keep copies of the lists
+	items, sensitive := slices.Clone(c.Items), slices.Clone(c.Sensitive)
+	itemConfigs := slices.Clone(c.ItemConfigs)
+	excludes := slices.Clone(c.Excludes.Patterns)
+	if _, err = toml.Decode(string(data), c); err != nil {
+		return errs.Errorf(errs.ErrConfigInvalid, "parsing %s: %w", filepath.Base(abs), err)
+	}
+	c.Items = slices.Concat(items, fragment.Items)
+	c.ItemConfigs = slices.Concat(itemConfigs, fragment.ItemConfigs)
+	c.Sensitive = slices.Concat(sensitive, fragment.Sensitive)
+	c.Excludes.Patterns = slices.Concat(excludes, fragment.Excludes.Patterns)
+	merged[abs] = true
+	return nil
+}
+
 // LoadWithProfile loads config and applies a profile.
 func LoadWithProfile(path, profileName string) (*Config, error) {
 	cfg, err := Load(path)
@@ -243,17 +840,14 @@ func LoadWithProfile(path, profileName string) (*Config, error) {
 	}
 
 	// apply hostname-specific config if available
-	hostname, err := osutils.Hostname()
-	if err == nil {
-		if hostCfg, ok := cfg.Hosts[hostname]; ok {
-			cfg.applyHostConfig(hostCfg)
-		}
+	if hostname, hostErr := osutils.Hostname(); hostErr == nil {
+		cfg.applyHost(hostname)
 	}
 
 	if profileName != "" {
 		profile, ok := cfg.Profiles[profileName]
 		if !ok {
-			return nil, fmt.Errorf("profile not found: %s", profileName)
+			return nil, errs.Errorf(errs.ErrConfigInvalid, "profile not found: %s", profileName)
 		}
 		cfg.applyProfile(profile)
 	}
@@ -261,6 +855,44 @@ func LoadWithProfile(path, profileName string) (*Config, error) {
 	return cfg, nil
 }
 
+// HostName returns the [host] name for hostname: hostname itself, or the
+// host that lists it among its aliases.
+func (c *Config) HostName(hostname string) string {
+	if _, ok := c.Hosts[hostname]; ok {
+		return hostname
+	}
+	names := slices.Sorted(maps.Keys(c.Hosts))
+	for _, name := range names {
+		if slices.Contains(c.Hosts[name].Aliases, hostname) {
+			return name
+		}
+	}
+	return hostname
+}
+
+// applyHost applies the host groups hostname belongs to, in name order, and
+// then its own host settings.
+func (c *Config) applyHost(hostname string) {
+	name := c.HostName(hostname)
+	for _, group := range slices.Sorted(maps.Keys(c.HostGroups)) {
+		members := c.HostGroups[group].Members
+		if slices.Contains(members, name) || slices.Contains(members, hostname) {
+			c.applyHostGroup(c.HostGroups[group])
+		}
+	}
+	if hostCfg, ok := c.Hosts[name]; ok {
+		c.applyHostConfig(hostCfg)
+	}
+}
+
+func (c *Config) applyHostGroup(group HostGroup) {
+	c.applyHostConfig(HostConfig{
+		ExtraItems:     group.ExtraItems,
+		ExtraSensitive: group.ExtraSensitive,
+		Excludes:       group.Excludes,
+	})
+}
+
 func (c *Config) applyHostConfig(host HostConfig) {
 	if len(host.ExtraItems) > 0 {
 		c.Items = append(c.Items, host.ExtraItems...)
@@ -273,6 +905,58 @@ func (c *Config) applyHostConfig(host HostConfig) {
 	}
 }
 
+// ApplyOverrides changes the items and exclude patterns of c for one
+// backup, as backup --items and --exclude do. An item is added, or removed
+// from items and sensitive items if it starts with "!"; patterns are added
+// to [excludes]. Items are relative to home, absolute inside it, or start
+// with ~/.
+func (c *Config) ApplyOverrides(items, excludes []string) error {
+	for _, item := range items {
+		removed, remove := strings.CutPrefix(strings.TrimSpace(item), "!")
+		path, err := homeRelItem(removed)
+		if err != nil {
+			return err
+		}
+		same := func(other string) bool {
+			otherPath, otherErr := homeRelItem(other)
+			return otherErr == nil && otherPath == path
+		}
+		switch {
+		case remove:
+			before := len(c.Items) + len(c.Sensitive)
+			c.Items = slices.DeleteFunc(c.Items, same)
+			c.Sensitive = slices.DeleteFunc(c.Sensitive, same)
+			if len(c.Items)+len(c.Sensitive) == before {
+				return errs.Errorf(errs.ErrConfigInvalid, "cannot leave out %s: it is not an item", removed)
+			}
+		case !slices.ContainsFunc(c.Items, same):
+			c.Items = append(c.Items, path)
+		}
+	}
+	c.Excludes.Patterns = append(c.Excludes.Patterns, excludes...)
+	return nil
+}
+
+// homeRelItem returns the item path relative to home.
+func homeRelItem(path string) (string, error) {
+	path = filepath.Clean(expandPath(strings.TrimSpace(path)))
+	if filepath.IsAbs(path) {
+		home, err := osutils.HomeDir()
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(home, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", errs.Errorf(errs.ErrConfigInvalid, "item %s is not inside the home directory %s", path, home)
+		}
+		path = rel
+	}
+	if path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", errs.Errorf(errs.ErrConfigInvalid, "item %s is not a path inside the home directory", path)
+	}
+	return path, nil
+}
+
 func (c *Config) applyProfile(profile Profile) {
 	if len(profile.Items) > 0 {
 		c.Items = profile.Items
@@ -289,6 +973,26 @@ func (c *Config) applyProfile(profile Profile) {
 	if len(profile.Excludes.Patterns) > 0 {
 		c.Excludes.Patterns = append(c.Excludes.Patterns, profile.Excludes.Patterns...)
 	}
+
+	override := profile.Backup
+	if override.BackupDir != "" {
+		c.Backup.BackupDir = expandPath(override.BackupDir)
+	}
+	if override.Encryption != "" {
+		c.Backup.Encryption = override.Encryption
+	}
+	if override.AgeRecipients != "" {
+		c.Backup.AgeRecipients = expandPath(override.AgeRecipients)
+	}
+	if override.GPGRecipient != "" {
+		c.Backup.GPGRecipient = override.GPGRecipient
+	}
+	if override.PassphraseFile != "" {
+		c.Backup.PassphraseFile = expandPath(override.PassphraseFile)
+	}
+	if override.MaxBackups != nil {
+		c.Backup.MaxBackups = *override.MaxBackups
+	}
 }
 
 // GetBackupItems returns the list of items to backup.
@@ -314,9 +1018,43 @@ type BackupItem struct {
 	Path string
 }
 
+// ItemOverlap is an item, or sensitive item, that another one already
+// covers: the same path, or a path inside it.
+type ItemOverlap struct {
+	Path   string
+	Within string
+}
+
+// OverlappingItems returns the items and sensitive items that an earlier or
+// enclosing one covers, such as .config/nvim next to .config. Their files
+// would otherwise be archived, and restored, twice.
+func (c *Config) OverlappingItems() []ItemOverlap {
+	paths := slices.Concat(c.Items, c.Sensitive)
+	var overlaps []ItemOverlap
+	for i, path := range paths {
+		clean := filepath.Clean(path)
+		for j, other := range paths {
+			otherClean := filepath.Clean(other)
+			inside := strings.HasPrefix(clean, otherClean+string(filepath.Separator))
+			// of two identical items, the later one is reported
+			if inside || clean == otherClean && j < i {
+				overlaps = append(overlaps, ItemOverlap{Path: path, Within: other})
+				break
+			}
+		}
+	}
+	return overlaps
+}
+
 func expandPath(path string) string {
+	if runtime.GOOS == "windows" {
+		path = expandWindowsVars(path, os.LookupEnv)
+		if strings.HasPrefix(path, `~\`) {
+			path = "~/" + path[2:]
+		}
+	}
 	if strings.HasPrefix(path, "~/") {
-		home, err := os.UserHomeDir()
+		home, err := osutils.HomeDir()
 		if err != nil {
 			return path // return unexpanded on error
 		}
@@ -325,6 +1063,36 @@ func expandPath(path string) string {
 	return path
 }
 
+// expandWindowsVars replaces the %NAME% references in path to environment
+// variables that lookup finds, such as %USERPROFILE% or %APPDATA%, the way
+// cmd.exe does; other text is kept.
+func expandWindowsVars(path string, lookup func(string) (string, bool)) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(path, '%')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(path[start+1:], '%')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		value, ok := lookup(path[start+1 : end])
+		if !ok || end == start+1 {
+			// keep the first % and look for a reference from the second
+			b.WriteString(path[:end])
+			path = path[end:]
+			continue
+		}
+		b.WriteString(path[:start])
+		b.WriteString(value)
+		path = path[end+1:]
+	}
+	b.WriteString(path)
+	return b.String()
+}
+
 func expandPaths(paths []string) []string {
 	if len(paths) == 0 {
 		return nil
@@ -6,6 +6,9 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/osutils"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -297,6 +300,53 @@ patterns = ["*.tmp", "*.cache"]
 			t.Errorf("expected 3 exclude patterns, got %d: %v", len(cfg.Excludes.Patterns), cfg.Excludes.Patterns)
 		}
 	})
+
+	t.Run("applies profile backup overrides", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "config.toml")
+
+		content := `
+items = [".zshrc"]
+
+[backup]
+backup_dir = "/backups"
+encryption = "none"
+max_backups = 10
+
+[profile.work.backup]
+backup_dir = "/work-backups"
+encryption = "age"
+age_recipients = "/keys/work.txt"
+max_backups = 0
+`
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := LoadWithProfile(configPath, "work")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Backup.BackupDir != "/work-backups" {
+			t.Errorf("expected backup_dir /work-backups, got %q", cfg.Backup.BackupDir)
+		}
+		if cfg.Backup.Encryption != "age" || cfg.Backup.AgeRecipients != "/keys/work.txt" {
+			t.Errorf("expected age with /keys/work.txt, got %q with %q",
+				cfg.Backup.Encryption, cfg.Backup.AgeRecipients)
+		}
+		if cfg.Backup.MaxBackups != 0 {
+			t.Errorf("expected max_backups 0, got %d", cfg.Backup.MaxBackups)
+		}
+
+		base, err := Load(configPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if base.Backup.BackupDir != "/backups" || base.Backup.MaxBackups != 10 {
+			t.Errorf("expected [backup] settings without a profile, got %q and %d",
+				base.Backup.BackupDir, base.Backup.MaxBackups)
+		}
+	})
 }
 
 func TestExpandPath(t *testing.T) {
@@ -341,6 +391,126 @@ func TestExpandPath(t *testing.T) {
 	}
 }
 
+func TestExpandWindowsVars(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"USERPROFILE": `C:\Users\alice`,
+		"APPDATA":     `C:\Users\alice\AppData\Roaming`,
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`%USERPROFILE%\backups`, `C:\Users\alice\backups`},
+		{`%APPDATA%\dotpak\%USERPROFILE%`, `C:\Users\alice\AppData\Roaming\dotpak\C:\Users\alice`},
+		{`%UNSET%\x`, `%UNSET%\x`},
+		{`100%\%APPDATA%`, `100%\C:\Users\alice\AppData\Roaming`},
+		{`%%USERPROFILE%`, `%C:\Users\alice`},
+		{`50%`, `50%`},
+		{`~/backups`, `~/backups`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+			if got := expandWindowsVars(tt.input, lookup); got != tt.want {
+				t.Errorf("expandWindowsVars(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"2048", 2048, false},
+		{"500KB", 500 << 10, false},
+		{"100 MB", 100 << 20, false},
+		{"1.5gb", 3 << 29, false},
+		{"2GiB", 2 << 30, false},
+		{"10M", 10 << 20, false},
+		{"-1MB", 0, true},
+		{"MB", 0, true},
+		{"10 parsecs", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, %v; want %d, error %v", tt.input, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultModes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		file, dir         string
+		wantFile, wantDir os.FileMode
+		wantErr           bool
+	}{
+		{"", "", 0o644, 0o755, false},
+		{"0600", "0o700", 0o600, 0o700, false},
+		{"640", "", 0o640, 0o755, false},
+		{"0999", "", 0, 0, true},
+		{"", "01777", 0, 0, true},
+	}
+	for _, tt := range tests {
+		file, dir, err := RestoreConfig{DefaultFileMode: tt.file, DefaultDirMode: tt.dir}.DefaultModes()
+		if (err != nil) != tt.wantErr || file != tt.wantFile || dir != tt.wantDir {
+			t.Errorf("DefaultModes(%q, %q) = %o, %o, %v; want %o, %o, error %v",
+				tt.file, tt.dir, file, dir, err, tt.wantFile, tt.wantDir, tt.wantErr)
+		}
+	}
+}
+
+func TestFileSizeLimits(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Backup: BackupConfig{MaxFileSize: "100MB"},
+		ItemConfigs: []ItemConfig{
+			{Path: ".config/obs-studio", MaxFileSize: "10MB"},
+			{Path: ".local/share/fonts", MaxFileSize: "0"},
+			{Path: ".config/nvim", PostRestore: "nvim --headless +qa"},
+		},
+	}
+	limits, err := cfg.FileSizeLimits()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for item, want := range map[string]int64{
+		".config/obs-studio": 10 << 20,
+		".local/share/fonts": 0,
+		".config/nvim":       100 << 20,
+		".zshrc":             100 << 20,
+	} {
+		if got := limits.For(item); got != want {
+			t.Errorf("For(%q) = %d, want %d", item, got, want)
+		}
+	}
+
+	cfg.ItemConfigs[0].MaxFileSize = "ten"
+	if _, err = cfg.FileSizeLimits(); err == nil || !strings.Contains(err.Error(), "item[0].max_file_size") {
+		t.Errorf("expected an item[0].max_file_size error, got %v", err)
+	}
+}
+
 func TestGetBackupItems(t *testing.T) {
 	t.Parallel()
 
@@ -381,6 +551,24 @@ func TestGetSensitiveItems(t *testing.T) {
 	}
 }
 
+func TestOverlappingItems(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Items:     []string{".config", ".config/nvim", ".zshrc", ".configs", "./.zshrc"},
+		Sensitive: []string{".ssh", ".config/gh/hosts.yml"},
+	}
+
+	want := []ItemOverlap{
+		{Path: ".config/nvim", Within: ".config"},
+		{Path: "./.zshrc", Within: ".zshrc"},
+		{Path: ".config/gh/hosts.yml", Within: ".config"},
+	}
+	if got := cfg.OverlappingItems(); !slices.Equal(got, want) {
+		t.Errorf("OverlappingItems() = %v, want %v", got, want)
+	}
+}
+
 func TestHostConfig(t *testing.T) {
 	t.Parallel()
 
@@ -420,6 +608,58 @@ extra_items = [".config/host-specific"]
 	}
 }
 
+func TestApplyHost(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+items = [".zshrc"]
+
+[backup]
+backup_dir = "~/backups"
+
+[host.mbp]
+aliases = ["mbp-wifi"]
+extra_items = [".config/mbp"]
+
+[host-group.laptops]
+members = ["mbp", "air"]
+extra_items = [".config/powertop"]
+
+[host-group.all]
+members = ["mbp", "air", "desktop"]
+extra_items = [".config/fleet"]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hostname string
+		want     []string
+	}{
+		{"mbp", []string{".zshrc", ".config/fleet", ".config/powertop", ".config/mbp"}},
+		{"mbp-wifi", []string{".zshrc", ".config/fleet", ".config/powertop", ".config/mbp"}},
+		{"air", []string{".zshrc", ".config/fleet", ".config/powertop"}},
+		{"desktop", []string{".zshrc", ".config/fleet"}},
+		{"other", []string{".zshrc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := Load(configPath)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cfg.applyHost(tt.hostname)
+			if !slices.Equal(cfg.Items, tt.want) {
+				t.Errorf("items = %v, want %v", cfg.Items, tt.want)
+			}
+		})
+	}
+}
+
 func TestBackupItem(t *testing.T) {
 	t.Parallel()
 
@@ -431,3 +671,323 @@ func TestBackupItem(t *testing.T) {
 		t.Errorf("expected path .config/nvim, got %s", item.Path)
 	}
 }
+
+func TestLoadFragments(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.toml")
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(configPath, `
+items = [".zshrc"]
+sensitive = [".ssh"]
+
+[backup]
+backup_dir = "/tmp/backups"
+max_backups = 7
+
+[excludes]
+patterns = ["*.log"]
+
+[profile.work]
+items = [".gitconfig"]
+
+[[item]]
+path = ".tmux.conf"
+post_restore = "tmux source-file ~/.tmux.conf"
+`)
+	writeFile(filepath.Join(FragmentDir(configPath), "20-nvim.toml"), `
+items = [".config/nvim"]
+
+[[item]]
+path = ".config/nvim"
+post_restore = "nvim --headless +qa"
+
+[backup]
+max_backups = 3
+
+[excludes]
+patterns = ["*.swp"]
+`)
+	writeFile(filepath.Join(FragmentDir(configPath), "10-cloud.toml"), `
+sensitive = [".aws"]
+
+[backup]
+max_backups = 5
+
+[profile.work]
+items = [".config/work"]
+`)
+	writeFile(filepath.Join(FragmentDir(configPath), "README.md"), "not a fragment")
+
+	// only the default config path merges its fragments
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if want := []string{".zshrc", ".tmux.conf"}; !slices.Equal(cfg.Items, want) || len(cfg.Sensitive) != 1 {
+		t.Errorf("Load(other path) Items = %v, Sensitive = %v; want the fragments left out", cfg.Items, cfg.Sensitive)
+	}
+
+	cfg, err = load(configPath, true)
+	if err != nil {
+		t.Fatalf("load() error: %v", err)
+	}
+
+	if want := []string{".zshrc", ".config/nvim", ".tmux.conf"}; !slices.Equal(cfg.Items, want) {
+		t.Errorf("Items = %v, want %v", cfg.Items, want)
+	}
+	if len(cfg.ItemConfigs) != 2 || cfg.ItemConfigs[0].Path != ".tmux.conf" ||
+		cfg.ItemConfigs[1].PostRestore != "nvim --headless +qa" {
+		t.Errorf("ItemConfigs = %+v, want the main config's item then the fragment's", cfg.ItemConfigs)
+	}
+	if want := []string{".ssh", ".aws"}; !slices.Equal(cfg.Sensitive, want) {
+		t.Errorf("Sensitive = %v, want %v", cfg.Sensitive, want)
+	}
+	if want := []string{"*.log", "*.swp"}; !slices.Equal(cfg.Excludes.Patterns, want) {
+		t.Errorf("Excludes = %v, want %v", cfg.Excludes.Patterns, want)
+	}
+	if cfg.Backup.MaxBackups != 3 {
+		t.Errorf("MaxBackups = %d, want 3 from the last fragment", cfg.Backup.MaxBackups)
+	}
+	if cfg.Backup.BackupDir != "/tmp/backups" {
+		t.Errorf("BackupDir = %q, want value from main config", cfg.Backup.BackupDir)
+	}
+	if want := []string{".config/work"}; !slices.Equal(cfg.Profiles["work"].Items, want) {
+		t.Errorf("work profile items = %v, want %v", cfg.Profiles["work"].Items, want)
+	}
+
+	writeFile(filepath.Join(FragmentDir(configPath), "30-bad.toml"), "items = [")
+	if _, err = load(configPath, true); err == nil || !strings.Contains(err.Error(), "30-bad.toml") {
+		t.Errorf("expected parse error naming the fragment, got %v", err)
+	}
+}
+
+func TestLoadInclude(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sharedPath := filepath.Join(dir, "shared", "base.toml")
+	writeFile(sharedPath, `
+items = [".zshrc", ".gitconfig"]
+
+[backup]
+backup_dir = "/srv/backups"
+max_backups = 30
+`)
+	configPath := filepath.Join(dir, "machine", "config.toml")
+	writeFile(configPath, `
+include = ["../shared/base.toml", "work.toml"]
+items = [".config/local"]
+
+[backup]
+max_backups = 7
+`)
+	writeFile(filepath.Join(dir, "machine", "work.toml"), `
+sensitive = [".aws"]
+`)
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if want := []string{".zshrc", ".gitconfig", ".config/local"}; !slices.Equal(cfg.Items, want) {
+		t.Errorf("Items = %v, want %v", cfg.Items, want)
+	}
+	if want := []string{".aws"}; !slices.Equal(cfg.Sensitive, want) {
+		t.Errorf("Sensitive = %v, want %v", cfg.Sensitive, want)
+	}
+	if cfg.Backup.BackupDir != "/srv/backups" {
+		t.Errorf("BackupDir = %q, want value from included file", cfg.Backup.BackupDir)
+	}
+	if cfg.Backup.MaxBackups != 7 {
+		t.Errorf("MaxBackups = %d, want 7 from the including file", cfg.Backup.MaxBackups)
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		t.Parallel()
+		cycleDir := t.TempDir()
+		writeFile(filepath.Join(cycleDir, "a.toml"), `include = ["b.toml"]`)
+		writeFile(filepath.Join(cycleDir, "b.toml"), `include = ["a.toml"]`)
+
+		_, err := Load(filepath.Join(cycleDir, "a.toml"))
+		if err == nil || !strings.Contains(err.Error(), "include cycle") {
+			t.Errorf("expected include cycle error, got %v", err)
+		}
+	})
+
+	t.Run("diamond", func(t *testing.T) {
+		t.Parallel()
+		diamondDir := t.TempDir()
+		writeFile(filepath.Join(diamondDir, "base.toml"), `
+items = [".zshrc"]
+sensitive = [".ssh"]
+
+[excludes]
+patterns = ["*.log"]
+`)
+		writeFile(filepath.Join(diamondDir, "work.toml"), `include = ["base.toml"]`)
+		writeFile(filepath.Join(diamondDir, "home.toml"), `include = ["base.toml"]`)
+		writeFile(filepath.Join(diamondDir, "config.toml"), `include = ["work.toml", "home.toml"]`)
+
+		cfg, err := Load(filepath.Join(diamondDir, "config.toml"))
+		if err != nil {
+			t.Fatalf("Load() error: %v", err)
+		}
+		if !slices.Equal(cfg.Items, []string{".zshrc"}) || !slices.Equal(cfg.Sensitive, []string{".ssh"}) ||
+			!slices.Equal(cfg.Excludes.Patterns, []string{"*.log"}) {
+			t.Errorf("Items = %v, Sensitive = %v, Excludes = %v; want the base merged once",
+				cfg.Items, cfg.Sensitive, cfg.Excludes.Patterns)
+		}
+	})
+
+	t.Run("missing include", func(t *testing.T) {
+		t.Parallel()
+		missingDir := t.TempDir()
+		writeFile(filepath.Join(missingDir, "config.toml"), `include = ["nope.toml"]`)
+
+		_, err := Load(filepath.Join(missingDir, "config.toml"))
+		if err == nil || !strings.Contains(err.Error(), "nope.toml") {
+			t.Errorf("expected missing include error, got %v", err)
+		}
+	})
+}
+
+func TestParseVerifySchedule(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    VerifySchedule
+		wantErr bool
+	}{
+		{"", VerifySchedule{}, false},
+		{"5", VerifySchedule{Every: 5}, false},
+		{"daily", VerifySchedule{Interval: 24 * time.Hour}, false},
+		{"weekly", VerifySchedule{Interval: 7 * 24 * time.Hour}, false},
+		{"0", VerifySchedule{}, true},
+		{"monthly", VerifySchedule{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := BackupConfig{VerifySchedule: tt.value}.ParseVerifySchedule()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVerifySchedule(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseVerifySchedule(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDestinationWait(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"30s", 30 * time.Second, false},
+		{" 10m ", 10 * time.Minute, false},
+		{"-1m", 0, true},
+		{"ten minutes", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := BackupConfig{DestinationWait: tt.value}.ParseDestinationWait()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDestinationWait(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDestinationWait(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePreserve(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   []string
+		want    Preserve
+		wantErr bool
+	}{
+		{"empty", nil, Preserve{}, false},
+		{"xattrs and times", []string{"xattrs", " times"}, Preserve{Xattrs: true, Times: true}, false},
+		{"all", []string{"xattrs", "hardlinks", "times", "owner"}, Preserve{true, true, true, true}, false},
+		{"unknown", []string{"xattrs", "acls"}, Preserve{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BackupConfig{Preserve: tt.value}.ParsePreserve()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePreserve(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePreserve(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	t.Parallel()
+
+	home, err := osutils.HomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	cfg := &Config{
+		Items:     []string{".zshrc", ".config/nvim"},
+		Sensitive: []string{".ssh"},
+	}
+	err = cfg.ApplyOverrides(
+		[]string{"notes", "~/.zshrc", filepath.Join(home, ".vimrc"), "!.ssh", "!~/.config/nvim/"},
+		[]string{"*.iso"},
+	)
+	if err != nil {
+		t.Fatalf("ApplyOverrides() error: %v", err)
+	}
+	if want := []string{".zshrc", "notes", ".vimrc"}; !slices.Equal(cfg.Items, want) {
+		t.Errorf("Items = %v, want %v", cfg.Items, want)
+	}
+	if len(cfg.Sensitive) != 0 {
+		t.Errorf("Sensitive = %v, want none", cfg.Sensitive)
+	}
+	if want := []string{"*.iso"}; !slices.Equal(cfg.Excludes.Patterns, want) {
+		t.Errorf("Excludes = %v, want %v", cfg.Excludes.Patterns, want)
+	}
+
+	for _, item := range []string{"!.bashrc", "!", filepath.Join(filepath.Dir(home), "other"), "../x"} {
+		if err = (&Config{Items: []string{".zshrc"}}).ApplyOverrides([]string{item}, nil); err == nil {
+			t.Errorf("ApplyOverrides(%q) should fail", item)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// The functions in this file edit config files in place, line by line, so
+// that comments, ordering, and formatting survive programmatic changes.
+// Only the assignment being changed is rewritten.
+
+// assignment is a "key = value" statement, possibly spanning several lines
+// (multi-line arrays).
+type assignment struct {
+	table string // enclosing [table], empty for top-level keys
+	key   string
+	start int // first line index
+	end   int // last line index, inclusive
+}
+
+// document is a TOML file split into lines with its assignments and table
+// headers located.
+type document struct {
+	lines       []string // each line keeps its trailing newline
+	assignments []assignment
+	headers     map[string]int // table name -> header line index
+	firstHeader int            // index of the first table header, or len(lines)
+}
+
+func parseDocument(data []byte) *document {
+	doc := &document{
+		lines:   strings.SplitAfter(string(data), "\n"),
+		headers: make(map[string]int),
+	}
+	if n := len(doc.lines); n > 0 && doc.lines[n-1] == "" {
+		doc.lines = doc.lines[:n-1]
+	}
+	doc.firstHeader = len(doc.lines)
+
+	table := ""
+	for i := 0; i < len(doc.lines); i++ {
+		code := strings.TrimSpace(stripComment(doc.lines[i]))
+		switch {
+		case code == "":
+			continue
+		case strings.HasPrefix(code, "["):
+			table = strings.TrimSpace(strings.Trim(code, "[]"))
+			doc.headers[table] = i
+			doc.firstHeader = min(doc.firstHeader, i)
+			continue
+		}
+
+		key, value, ok := strings.Cut(code, "=")
+		if !ok {
+			continue
+		}
+		a := assignment{table: table, key: strings.TrimSpace(key), start: i, end: i}
+		for depth := bracketDepth(value); depth > 0 && a.end+1 < len(doc.lines); {
+			a.end++
+			depth += bracketDepth(doc.lines[a.end])
+		}
+		doc.assignments = append(doc.assignments, a)
+		i = a.end
+	}
+	return doc
+}
+
+func (d *document) find(table, key string) (assignment, bool) {
+	for _, a := range d.assignments {
+		if a.table == table && a.key == key {
+			return a, true
+		}
+	}
+	return assignment{}, false
+}
+
+func (d *document) bytes() []byte {
+	return []byte(strings.Join(d.lines, ""))
+}
+
+// replace replaces lines [start, end] with repl.
+func (d *document) replace(start, end int, repl ...string) {
+	d.lines = append(d.lines[:start], append(repl, d.lines[end+1:]...)...)
+}
+
+// SetValue sets key (e.g. "backup.max_backups" or "items") to the TOML
+// literal value, rewriting only that assignment. A missing key is added to
+// its table, and a missing table is appended to the file.
+func SetValue(data []byte, key, literal string) ([]byte, error) {
+	table, name := splitKey(key)
+	doc := parseDocument(data)
+
+	if a, ok := doc.find(table, name); ok {
+		first := doc.lines[a.start]
+		indent := first[:len(first)-len(strings.TrimLeft(first, " \t"))]
+		line := indent + name + " = " + literal
+		if a.start == a.end {
+			if comment := trailingComment(first); comment != "" {
+				line += " " + comment
+			}
+		}
+		doc.replace(a.start, a.end, line+"\n")
+		return doc.checked()
+	}
+
+	line := name + " = " + literal + "\n"
+	header, hasTable := doc.headers[table]
+	switch {
+	case table == "" || hasTable:
+		at := doc.firstHeader
+		if table != "" {
+			at = header + 1
+		}
+		for _, a := range doc.assignments {
+			if a.table == table {
+				at = a.end + 1
+			}
+		}
+		doc.replace(at, at-1, line)
+	default:
+		if n := len(doc.lines); n > 0 && !strings.HasSuffix(doc.lines[n-1], "\n") {
+			doc.lines[n-1] += "\n"
+		}
+		doc.lines = append(doc.lines, "\n", "["+table+"]\n", line)
+	}
+	return doc.checked()
+}
+
+// AppendToList adds item to the string array at key, keeping the array's
+// layout (one element per line or inline). It reports false if the array
+// already contains item.
+func AppendToList(data []byte, key, item string) ([]byte, bool, error) {
+	table, name := splitKey(key)
+	doc := parseDocument(data)
+	literal := strconv.Quote(item)
+
+	a, ok := doc.find(table, name)
+	if !ok {
+		out, err := SetValue(data, key, "["+literal+"]")
+		return out, err == nil, err
+	}
+
+	var current struct {
+		List []string `toml:"list"`
+	}
+	if _, err := toml.Decode("list = "+valueText(doc.lines[a.start:a.end+1]), &current); err != nil {
+		return nil, false, errs.Errorf(errs.ErrConfigInvalid, "%s is not a list of strings: %w", key, err)
+	}
+	if slices.Contains(current.List, item) {
+		return data, false, nil
+	}
+
+	closing := doc.lines[a.end]
+	if a.start != a.end && strings.HasPrefix(strings.TrimSpace(closing), "]") {
+		// one element per line: add a line before the closing bracket
+		indent := "    "
+		prev := a.end - 1
+		for ; prev > a.start; prev-- {
+			code := strings.TrimSpace(stripComment(doc.lines[prev]))
+			if code == "" {
+				continue
+			}
+			l := doc.lines[prev]
+			indent = l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+			if !strings.HasSuffix(code, ",") {
+				doc.lines[prev] = insertAt(l, len(strings.TrimRight(stripComment(l), " \t\r\n")), ",")
+			}
+			break
+		}
+		doc.replace(a.end, a.end-1, indent+literal+",\n")
+		out, err := doc.checked()
+		return out, err == nil, err
+	}
+
+	// inline: insert before the closing bracket on the last line
+	pos := strings.LastIndex(stripComment(closing), "]")
+	if pos < 0 {
+		return nil, false, errs.Errorf(errs.ErrConfigInvalid, "%s is not an array", key)
+	}
+	before := strings.TrimRight(closing[:pos], " \t")
+	sep := ", "
+	if strings.HasSuffix(before, "[") {
+		sep = ""
+	} else if strings.HasSuffix(before, ",") {
+		sep = " "
+	}
+	doc.lines[a.end] = before + sep + literal + closing[pos:]
+	out, err := doc.checked()
+	return out, err == nil, err
+}
+
+// checked returns the edited document, failing if it no longer parses.
+func (d *document) checked() ([]byte, error) {
+	out := d.bytes()
+	var cfg Config
+	if _, err := toml.Decode(string(out), &cfg); err != nil {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "edited config does not parse: %w", err)
+	}
+	return out, nil
+}
+
+// FormatValue converts a command-line value for key into a TOML literal,
+// using the type of the corresponding Config field. Lists are given as
+// comma-separated values.
+func FormatValue(key, raw string) (string, error) {
+	typ, err := fieldType(key)
+	if err != nil {
+		return "", err
+	}
+
+	switch kind := typ.Kind(); {
+	case kind == reflect.String:
+		return strconv.Quote(raw), nil
+	case kind == reflect.Bool:
+		b, parseErr := strconv.ParseBool(raw)
+		if parseErr != nil {
+			return "", errs.Errorf(errs.ErrConfigInvalid, "%s must be true or false", key)
+		}
+		return strconv.FormatBool(b), nil
+	case kind == reflect.Int:
+		n, parseErr := strconv.Atoi(raw)
+		if parseErr != nil {
+			return "", errs.Errorf(errs.ErrConfigInvalid, "%s must be an integer", key)
+		}
+		return strconv.Itoa(n), nil
+	case kind == reflect.Slice:
+		var quoted []string
+		for v := range strings.SplitSeq(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				quoted = append(quoted, strconv.Quote(v))
+			}
+		}
+		return "[" + strings.Join(quoted, ", ") + "]", nil
+	default:
+		return "", errs.Errorf(errs.ErrConfigInvalid, "%s cannot be set from the command line", key)
+	}
+}
+
+// fieldType returns the type of the Config field addressed by a dotted TOML
+// key such as "backup.max_backups" or "profile.work.items".
+func fieldType(key string) (reflect.Type, error) {
+	typ := reflect.TypeFor[Config]()
+	for part := range strings.SplitSeq(key, ".") {
+		switch kind := typ.Kind(); {
+		case kind == reflect.Struct:
+			field, ok := fieldByTag(typ, part)
+			if !ok {
+				return nil, errs.Errorf(errs.ErrConfigInvalid, "unknown config key: %s", key)
+			}
+			typ = field.Type
+		case kind == reflect.Map:
+			// the map key (profile or host name) selects an entry
+			typ = typ.Elem()
+		default:
+			return nil, errs.Errorf(errs.ErrConfigInvalid, "unknown config key: %s", key)
+		}
+	}
+	if typ.Kind() == reflect.Struct || typ.Kind() == reflect.Map {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "%s is a table, not a value", key)
+	}
+	return typ, nil
+}
+
+// GetValue returns the value of the dotted TOML key in c, e.g.
+// "backup.max_backups" or "profile.work.extra_items". Tables cannot be read
+// as a whole.
+func GetValue(c *Config, key string) (any, error) {
+	if _, err := fieldType(key); err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	for part := range strings.SplitSeq(key, ".") {
+		switch kind := v.Kind(); {
+		case kind == reflect.Struct:
+			field, _ := fieldByTag(v.Type(), part)
+			v = v.FieldByIndex(field.Index)
+		case kind == reflect.Map:
+			entry := v.MapIndex(reflect.ValueOf(part))
+			if !entry.IsValid() {
+				return nil, errs.Errorf(errs.ErrConfigInvalid, "%s: no %q entry", key, part)
+			}
+			v = entry
+		}
+	}
+	return v.Interface(), nil
+}
+
+func fieldByTag(typ reflect.Type, tag string) (reflect.StructField, bool) {
+	for i := range typ.NumField() {
+		if f := typ.Field(i); f.Tag.Get("toml") == tag {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// splitKey splits "backup.max_backups" into its table and key.
+func splitKey(key string) (string, string) {
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// valueText returns the value part of an assignment spanning lines, without comments.
+func valueText(lines []string) string {
+	var sb strings.Builder
+	for i, l := range lines {
+		code := stripComment(l)
+		if i == 0 {
+			_, code, _ = strings.Cut(code, "=")
+		}
+		sb.WriteString(code)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// stripComment removes a trailing "# comment" that is not inside a string.
+func stripComment(line string) string {
+	if i := commentIndex(line); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// trailingComment returns the "# comment" at the end of line, if any.
+func trailingComment(line string) string {
+	if i := commentIndex(line); i >= 0 {
+		return strings.TrimRight(line[i:], "\r\n")
+	}
+	return ""
+}
+
+func commentIndex(line string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return i
+		}
+	}
+	return -1
+}
+
+// bracketDepth returns the change in array nesting across line.
+func bracketDepth(line string) int {
+	code := stripComment(line)
+	depth := 0
+	var quote byte
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+func insertAt(s string, i int, insert string) string {
+	return s[:i] + insert + s[i:]
+}
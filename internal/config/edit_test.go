@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const editSample = `# Dotpak configuration
+
+items = [
+    # Shell
+    ".zshrc",
+    ".bashrc"
+]
+
+sensitive = [".ssh"]
+
+[backup]
+backup_dir = "~/backups" # where archives go
+max_backups = 14
+
+# Exclude patterns
+[excludes]
+patterns = ["*.log"]
+`
+
+func TestSetValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		key     string
+		literal string
+		want    []string // substrings expected in the result
+	}{
+		{"replace keeps comment", "backup.backup_dir", `"/srv/b"`, []string{`backup_dir = "/srv/b" # where archives go` + "\n"}},
+		{"replace scalar", "backup.max_backups", "30", []string{"max_backups = 30\n"}},
+		{"add to table", "backup.encryption", `"age"`, []string{"max_backups = 14\nencryption = \"age\"\n\n# Exclude patterns"}},
+		{"replace multi-line array", "items", `[".vimrc"]`, []string{"# Dotpak configuration\n\nitems = [\".vimrc\"]\n\nsensitive"}},
+		{"add new table", "host.laptop.extra_items", `[".work"]`, []string{"\n[host.laptop]\nextra_items = [\".work\"]\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			out, err := SetValue([]byte(editSample), tt.key, tt.literal)
+			if err != nil {
+				t.Fatalf("SetValue() error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("result missing %q:\n%s", want, out)
+				}
+			}
+			if !strings.Contains(string(out), "# Exclude patterns\n") {
+				t.Errorf("comments should be preserved:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestAppendToList(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		key   string
+		item  string
+		added bool
+		want  string
+	}{
+		{"multi-line", "items", ".config/foo", true, "    \".bashrc\",\n    \".config/foo\",\n]"},
+		{"inline", "sensitive", ".aws", true, `sensitive = [".ssh", ".aws"]`},
+		{"table", "excludes.patterns", "*.tmp", true, `patterns = ["*.log", "*.tmp"]`},
+		{"duplicate", "items", ".zshrc", false, "    \".zshrc\",\n    \".bashrc\"\n]"},
+		{"missing list", "profile.work.extra_items", ".work", true, "[profile.work]\nextra_items = [\".work\"]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			out, added, err := AppendToList([]byte(editSample), tt.key, tt.item)
+			if err != nil {
+				t.Fatalf("AppendToList() error: %v", err)
+			}
+			if added != tt.added {
+				t.Errorf("added = %v, want %v", added, tt.added)
+			}
+			if !strings.Contains(string(out), tt.want) {
+				t.Errorf("result missing %q:\n%s", tt.want, out)
+			}
+			if !strings.Contains(string(out), "    # Shell\n") {
+				t.Errorf("comments should be preserved:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		key     string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"backup.max_backups", "30", "30", false},
+		{"backup.max_backups", "many", "", true},
+		{"backup.encryption", "age", `"age"`, false},
+		{"backup.shell_snapshot", "true", "true", false},
+		{"items", ".zshrc, .vimrc", `[".zshrc", ".vimrc"]`, false},
+		{"profile.work.items", ".gitconfig", `[".gitconfig"]`, false},
+		{"backup.nope", "1", "", true},
+		{"backup", "1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.raw, func(t *testing.T) {
+			t.Parallel()
+			got, err := FormatValue(tt.key, tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FormatValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FormatValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetValue(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Items:    []string{".zshrc"},
+		Backup:   BackupConfig{MaxBackups: 14, Encryption: "age"},
+		Profiles: map[string]Profile{"work": {ExtraItems: []string{".config/work"}}},
+	}
+
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"backup.max_backups", "14", false},
+		{"backup.encryption", "age", false},
+		{"items", "[.zshrc]", false},
+		{"profile.work.extra_items", "[.config/work]", false},
+		{"profile.home.extra_items", "", true},
+		{"backup", "", true},
+		{"nope", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			t.Parallel()
+			got, err := GetValue(cfg, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && fmt.Sprint(got) != tt.want {
+				t.Errorf("GetValue() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
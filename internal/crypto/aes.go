@@ -0,0 +1,252 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// PassphraseEnv is the environment variable holding the passphrase of the
+// aes method. It takes precedence over backup.passphrase_file.
+const PassphraseEnv = "DOTPAK_PASSPHRASE"
+
+// Layout of files written by AESEncryptor: a header of aesMagic, the
+// PBKDF2 iteration count (uint32, big endian), the salt, and the nonce
+// prefix, followed by the plaintext in aesChunkSize chunks, each sealed
+// with AES-256-GCM. A chunk's nonce is the prefix, its index (uint32), and a
+// byte set to 1 for the last chunk only, so chunks cannot be reordered,
+// dropped, or truncated without failing authentication. Every chunk also
+// authenticates the whole header as additional data, so the key derivation
+// parameters cannot be changed either.
+const (
+	aesMagic         = "dotpak-aes256gcm-v1\n"
+	aesIterations    = 600_000
+	aesMaxIterations = 10_000_000
+	aesSaltSize      = 16
+	aesPrefixSize    = 7
+	aesChunkSize     = 64 * 1024
+)
+
+// AESEncryptor implements Encryptor with AES-256-GCM and a key derived
+// from a passphrase with PBKDF2-SHA256, using only the Go standard library.
+// It is meant for minimal environments where neither age nor gpg can be
+// installed. Its file format is dotpak's own, read by no other tool.
+type AESEncryptor struct {
+	passphrase string
+}
+
+// NewAESEncryptor creates a new AESEncryptor with the passphrase
+// from DOTPAK_PASSPHRASE or, if that is unset, opts.PassphraseFile.
+func NewAESEncryptor(opts Options) (*AESEncryptor, error) {
+	passphrase, err := ResolvePassphrase(opts.PassphraseFile)
+	if err != nil {
+		return nil, err
+	}
+	return &AESEncryptor{passphrase: passphrase}, nil
+}
+
+// ResolvePassphrase returns the passphrase of the aes method: the value
+// of DOTPAK_PASSPHRASE, or else the first line of passphraseFile.
+func ResolvePassphrase(passphraseFile string) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	if passphraseFile == "" {
+		return "", errs.Errorf(errs.ErrEncryptionUnavailable,
+			"aes encryption needs a passphrase: set %s or backup.passphrase_file", PassphraseEnv)
+	}
+	data, err := os.ReadFile(passphraseFile)
+	if err != nil {
+		return "", errs.Errorf(errs.ErrEncryptionUnavailable, "passphrase file not readable: %s", passphraseFile)
+	}
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+	if passphrase == "" {
+		return "", errs.Errorf(errs.ErrEncryptionUnavailable, "passphrase file is empty: %s", passphraseFile)
+	}
+	return passphrase, nil
+}
+
+// Available returns true: the aes method needs no external tool.
+func (e *AESEncryptor) Available() bool {
+	return true
+}
+
+// EncryptReader encrypts data from r and writes the result to outputPath.
+func (e *AESEncryptor) EncryptReader(r io.Reader, outputPath string) (err error) {
+	header := make([]byte, 0, len(aesMagic)+4+aesSaltSize+aesPrefixSize)
+	header = append(header, aesMagic...)
+	header = binary.BigEndian.AppendUint32(header, aesIterations)
+	random := make([]byte, aesSaltSize+aesPrefixSize)
+	if _, err = rand.Read(random); err != nil {
+		return errs.Wrap(errs.ErrEncryptionFailed, err)
+	}
+	header = append(header, random...)
+	salt, prefix := random[:aesSaltSize], random[aesSaltSize:]
+
+	aead, err := e.cipher(salt, aesIterations)
+	if err != nil {
+		return errs.Wrap(errs.ErrEncryptionFailed, err)
+	}
+
+	out, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errs.Wrap(errs.ErrEncryptionFailed, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = errs.Wrap(errs.ErrEncryptionFailed, closeErr)
+		}
+		if err != nil {
+			_ = os.Remove(outputPath)
+		}
+	}()
+
+	w := bufio.NewWriterSize(out, aesChunkSize+aead.Overhead())
+	if _, err = w.Write(header); err != nil {
+		return errs.Wrap(errs.ErrEncryptionFailed, err)
+	}
+
+	src := bufio.NewReaderSize(r, aesChunkSize)
+	chunk := make([]byte, aesChunkSize)
+	sealed := make([]byte, 0, aesChunkSize+aead.Overhead())
+	for index := uint32(0); ; index++ {
+		n, readErr := io.ReadFull(src, chunk)
+		last := errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF)
+		if readErr != nil && !last {
+			return errs.Wrap(errs.ErrEncryptionFailed, readErr)
+		}
+		if !last {
+			_, peekErr := src.Peek(1)
+			last = errors.Is(peekErr, io.EOF)
+		}
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(prefix, index, last), chunk[:n], header)
+		if _, err = w.Write(sealed); err != nil {
+			return errs.Wrap(errs.ErrEncryptionFailed, err)
+		}
+		if last {
+			break
+		}
+		if index == ^uint32(0) {
+			return errs.Errorf(errs.ErrEncryptionFailed, "input too large")
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return errs.Wrap(errs.ErrEncryptionFailed, err)
+	}
+	return nil
+}
+
+// Decrypt decrypts inputPath to outputPath. A wrong passphrase and a
+// modified or truncated file fail alike and leave no output.
+func (e *AESEncryptor) Decrypt(inputPath, outputPath string) (err error) {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return errs.Wrap(errs.ErrDecryptionFailed, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errs.Wrap(errs.ErrDecryptionFailed, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = errs.Wrap(errs.ErrDecryptionFailed, closeErr)
+		}
+		if err != nil {
+			_ = os.Remove(outputPath)
+		}
+	}()
+	return e.DecryptStream(in, out)
+}
+
+// DecryptStream decrypts data from r and writes the result to w. Chunks are
+// written as they are authenticated, so on error w may hold the start of
+// the plaintext.
+func (e *AESEncryptor) DecryptStream(r io.Reader, w io.Writer) error {
+	src := bufio.NewReaderSize(r, aesChunkSize+64)
+
+	header := make([]byte, len(aesMagic)+4+aesSaltSize+aesPrefixSize)
+	if _, err := io.ReadFull(src, header); err != nil || !bytes.HasPrefix(header, []byte(aesMagic)) {
+		return errs.Errorf(errs.ErrDecryptionFailed, "not an aes-method archive")
+	}
+	rest := header[len(aesMagic):]
+	iterations := binary.BigEndian.Uint32(rest)
+	if iterations == 0 || iterations > aesMaxIterations {
+		return errs.Errorf(errs.ErrDecryptionFailed, "invalid key derivation parameters")
+	}
+	salt := rest[4 : 4+aesSaltSize]
+	prefix := rest[4+aesSaltSize:]
+
+	aead, err := e.cipher(salt, int(iterations))
+	if err != nil {
+		return errs.Wrap(errs.ErrDecryptionFailed, err)
+	}
+
+	bw := bufio.NewWriterSize(w, aesChunkSize)
+	sealed := make([]byte, aesChunkSize+aead.Overhead())
+	plain := make([]byte, 0, aesChunkSize)
+	for index := uint32(0); ; index++ {
+		n, readErr := io.ReadFull(src, sealed)
+		last := errors.Is(readErr, io.ErrUnexpectedEOF)
+		if readErr != nil && !last {
+			return errs.Errorf(errs.ErrDecryptionFailed, "archive is truncated")
+		}
+		if !last {
+			_, peekErr := src.Peek(1)
+			last = errors.Is(peekErr, io.EOF)
+		}
+
+		plain, err = aead.Open(plain[:0], chunkNonce(prefix, index, last), sealed[:n], header)
+		if err != nil {
+			return errs.Errorf(errs.ErrDecryptionFailed,
+				"aes decryption failed: wrong passphrase, or the archive is corrupt or truncated")
+		}
+		if _, err = bw.Write(plain); err != nil {
+			return errs.Wrap(errs.ErrDecryptionFailed, err)
+		}
+		if last {
+			break
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return errs.Wrap(errs.ErrDecryptionFailed, err)
+	}
+	return nil
+}
+
+func (e *AESEncryptor) cipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, e.passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the 12-byte GCM nonce of a chunk.
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, aesPrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
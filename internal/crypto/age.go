@@ -3,10 +3,21 @@ package crypto
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
 	"os"
 	"os/exec"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// Sensitive files backed up with backup.sensitive_encryption = "age" are
+// encrypted on their own inside a plain archive: each is stored under its
+// name with MemberSuffix appended, in an entry with the PAX record
+// MemberPAXKey set to the method. The record is an extended attribute, which
+// tar tools list without complaint.
+const (
+	MemberSuffix = ".age"
+	MemberPAXKey = "SCHILY.xattr.user.dotpak.encrypted"
 )
 
 // AgeEncryptor implements Encryptor using age.
@@ -31,55 +42,122 @@ func (e *AgeEncryptor) Available() bool {
 
 // EncryptReader encrypts data from r and writes the result to outputPath.
 func (e *AgeEncryptor) EncryptReader(r io.Reader, outputPath string) error {
+	return e.encrypt(r, nil, "-o", outputPath)
+}
+
+// EncryptStream encrypts data from r and writes the result to w.
+func (e *AgeEncryptor) EncryptStream(r io.Reader, w io.Writer) error {
+	return e.encrypt(r, w)
+}
+
+func (e *AgeEncryptor) encrypt(r io.Reader, w io.Writer, outputArgs ...string) error {
 	if e.recipientsFile == "" {
-		return errors.New("age recipients file not specified")
+		return errs.Errorf(errs.ErrEncryptionUnavailable, "age recipients file not specified")
 	}
 
-	if _, err := os.Stat(e.recipientsFile); err != nil {
-		return fmt.Errorf("age recipients file not found: %s", e.recipientsFile)
+	if err := CheckRecipients(e.recipientsFile); err != nil {
+		return err
 	}
 
 	//nolint:gosec // g204: age command with validated recipients file path
-	cmd := exec.Command("age", "-e", "-R", e.recipientsFile, "-o", outputPath)
+	cmd := exec.Command("age", append([]string{"-e", "-R", e.recipientsFile}, outputArgs...)...)
 	cmd.Stdin = r
+	cmd.Stdout = w
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("age encryption failed: %s", stderr.String())
+		if errors.Is(err, exec.ErrNotFound) {
+			return errs.Errorf(errs.ErrEncryptionUnavailable, "age is not installed")
+		}
+		return errs.Errorf(errs.ErrEncryptionFailed, "age encryption failed: %s", stderr.String())
 	}
 
 	return nil
 }
 
-// Decrypt decrypts a file using age.
+// Decrypt decrypts a file using age, offering every existing identity file.
 func (e *AgeEncryptor) Decrypt(inputPath, outputPath string) error {
-	identityFile, err := e.findIdentityFile()
+	return e.decrypt(nil, nil, "-o", outputPath, inputPath)
+}
+
+// DecryptStream decrypts data from r and writes the result to w, offering
+// every existing identity file.
+func (e *AgeEncryptor) DecryptStream(r io.Reader, w io.Writer) error {
+	return e.decrypt(r, w)
+}
+
+// CanDecrypt returns an ErrEncryptionUnavailable error if age is not
+// installed or no identity file exists, so that callers can tell before
+// streaming ciphertext into DecryptStream.
+func (e *AgeEncryptor) CanDecrypt() error {
+	if !HasAge() {
+		return errs.Errorf(errs.ErrEncryptionUnavailable, "age is not installed")
+	}
+	_, err := e.findIdentityFiles()
+	return err
+}
+
+func (e *AgeEncryptor) decrypt(r io.Reader, w io.Writer, fileArgs ...string) error {
+	identityFiles, err := e.findIdentityFiles()
 	if err != nil {
 		return err
 	}
 
-	cmd := exec.Command("age", "-d", "-i", identityFile, "-o", outputPath, inputPath)
+	args := []string{"-d"}
+	for _, identityFile := range identityFiles {
+		args = append(args, "-i", identityFile)
+	}
+	args = append(args, fileArgs...)
+
+	cmd := exec.Command("age", args...)
+	cmd.Stdin = r
+	cmd.Stdout = w
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	if runErr := cmd.Run(); runErr != nil {
-		return fmt.Errorf("age decryption failed: %s", stderr.String())
+		if errors.Is(runErr, exec.ErrNotFound) {
+			return errs.Errorf(errs.ErrEncryptionUnavailable, "age is not installed")
+		}
+		return errs.Errorf(errs.ErrDecryptionFailed, "age decryption failed: %s", stderr.String())
 	}
 
 	return nil
 }
 
-func (e *AgeEncryptor) findIdentityFile() (string, error) {
+// findIdentityFiles returns the configured identity files that exist, in
+// order. age tries each one, so an archive decrypts as long as any of them
+// holds the matching key. Identity files of an age plugin that is not
+// installed are left out, since age fails on them before trying the others.
+func (e *AgeEncryptor) findIdentityFiles() ([]string, error) {
 	if len(e.identityFiles) == 0 {
-		return "", errors.New("no age identity files configured")
+		return nil, errs.Errorf(errs.ErrEncryptionUnavailable, "no age identity files configured (set age_identity_files or age_identity_discovery)")
 	}
 
+	var found []string
+	var pluginErr error
 	for _, loc := range e.identityFiles {
-		if _, err := os.Stat(loc); err == nil {
-			return loc, nil
+		if _, err := os.Stat(loc); err != nil {
+			continue
+		}
+		if plugin, _ := IdentityPlugin(loc); plugin != "" {
+			if err := checkPlugin(plugin, "identities in "+loc); err != nil {
+				pluginErr = err
+				continue
+			}
 		}
+		found = append(found, loc)
+	}
+	if len(found) > 0 {
+		return found, nil
+	}
+	if pluginErr != nil {
+		return nil, pluginErr
 	}
 
-	return "", fmt.Errorf("age identity file not found in configured locations: %v", e.identityFiles)
+	return nil, errs.Errorf(
+		errs.ErrEncryptionUnavailable,
+		"age identity file not found in configured locations: %v", e.identityFiles,
+	)
 }
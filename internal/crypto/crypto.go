@@ -1,11 +1,13 @@
-// Package crypto provides encryption and decryption functionality using age and GPG.
+// Package crypto provides encryption and decryption functionality using age,
+// GPG, and a built-in AES-256-GCM method.
 package crypto
 
 import (
-	"errors"
 	"io"
 	"os/exec"
 	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
 )
 
 // Method represents an encryption method.
@@ -18,6 +20,9 @@ const (
 	MethodAge Method = "age"
 	// MethodGPG represents GPG encryption.
 	MethodGPG Method = "gpg"
+	// MethodAES represents passphrase-based AES-256-GCM encryption
+	// without external tools.
+	MethodAES Method = "aes"
 )
 
 // Encryptor defines the interface for encryption/decryption operations.
@@ -30,6 +35,13 @@ type Encryptor interface {
 	Available() bool
 }
 
+// StreamDecryptor is implemented by the Encryptors that decrypt without
+// reading or writing files.
+type StreamDecryptor interface {
+	// DecryptStream decrypts data from r and writes the result to w.
+	DecryptStream(r io.Reader, w io.Writer) error
+}
+
 // Options holds configuration for encryption/decryption.
 type Options struct {
 	// AgeRecipientsFile is the path to the age recipients file (for encryption).
@@ -38,6 +50,9 @@ type Options struct {
 	AgeIdentityFiles []string
 	// GPGRecipient is the GPG recipient ID or email.
 	GPGRecipient string
+	// PassphraseFile holds the passphrase of the aes method, unless
+	// DOTPAK_PASSPHRASE is set.
+	PassphraseFile string
 }
 
 // DetectMethod detects the encryption method from a file path based on its extension.
@@ -48,6 +63,9 @@ func DetectMethod(filePath string) Method {
 	if strings.HasSuffix(filePath, ".gpg") {
 		return MethodGPG
 	}
+	if strings.HasSuffix(filePath, ".aes") {
+		return MethodAES
+	}
 	return MethodNone
 }
 
@@ -58,10 +76,12 @@ func NewEncryptor(method Method, opts Options) (Encryptor, error) {
 		return NewAgeEncryptor(opts)
 	case MethodGPG:
 		return NewGPGEncryptor(opts)
+	case MethodAES:
+		return NewAESEncryptor(opts)
 	case MethodNone:
-		return nil, errors.New("no encryption method specified")
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "no encryption method specified")
 	default:
-		return nil, errors.New("unknown encryption method: " + string(method))
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "unknown encryption method: %s", method)
 	}
 }
 
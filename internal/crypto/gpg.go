@@ -2,10 +2,12 @@ package crypto
 
 import (
 	"bytes"
-	"fmt"
+	"errors"
 	"io"
 	"os"
 	"os/exec"
+
+	"github.com/ospiem/dotpak/internal/errs"
 )
 
 // GPGEncryptor implements Encryptor using GPG.
@@ -38,7 +40,10 @@ func (e *GPGEncryptor) EncryptReader(r io.Reader, outputPath string) error {
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("gpg encryption failed: %s", stderr.String())
+		if errors.Is(err, exec.ErrNotFound) {
+			return errs.Errorf(errs.ErrEncryptionUnavailable, "gpg is not installed")
+		}
+		return errs.Errorf(errs.ErrEncryptionFailed, "gpg encryption failed: %s", stderr.String())
 	}
 
 	return nil
@@ -52,7 +57,29 @@ func (e *GPGEncryptor) Decrypt(inputPath, outputPath string) error {
 	cmd.Stdin = os.Stdin // allow passphrase input
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("gpg decryption failed: %s", stderr.String())
+		if errors.Is(err, exec.ErrNotFound) {
+			return errs.Errorf(errs.ErrEncryptionUnavailable, "gpg is not installed")
+		}
+		return errs.Errorf(errs.ErrDecryptionFailed, "gpg decryption failed: %s", stderr.String())
+	}
+
+	return nil
+}
+
+// DecryptStream decrypts data from r and writes the result to w. As stdin
+// holds the data, a passphrase is asked through the gpg agent's pinentry.
+func (e *GPGEncryptor) DecryptStream(r io.Reader, w io.Writer) error {
+	cmd := exec.Command("gpg", "--decrypt")
+	cmd.Stdin = r
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errs.Errorf(errs.ErrEncryptionUnavailable, "gpg is not installed")
+		}
+		return errs.Errorf(errs.ErrDecryptionFailed, "gpg decryption failed: %s", stderr.String())
 	}
 
 	return nil
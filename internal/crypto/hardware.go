@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// Hardware key kinds for backup.hardware_key.
+const (
+	HardwareTPM           = "tpm"
+	HardwareSecureEnclave = "secure-enclave"
+)
+
+// hardwarePlugin describes the age plugin that keeps identities of a
+// hardware key kind. The identity file it writes only references a key
+// sealed to the device, so it cannot decrypt anything elsewhere.
+type hardwarePlugin struct {
+	binary    string
+	platforms []string
+	// generate and recipient return the plugin arguments that create an
+	// identity file and print its recipient.
+	generate  func(identityPath string) []string
+	recipient func(identityPath string) []string
+}
+
+var hardwarePlugins = map[string]hardwarePlugin{
+	HardwareTPM: {
+		binary:    "age-plugin-tpm",
+		platforms: []string{"linux", "windows"},
+		generate:  func(path string) []string { return []string{"--generate", "-o", path} },
+		recipient: func(path string) []string { return []string{"-y", path} },
+	},
+	HardwareSecureEnclave: {
+		binary:    "age-plugin-se",
+		platforms: []string{"darwin"},
+		generate: func(path string) []string {
+			return []string{"keygen", "--access-control=any-biometry-or-passcode", "-o", path}
+		},
+		recipient: func(path string) []string { return []string{"recipients", "-i", path} },
+	},
+}
+
+// HardwareKinds returns the supported backup.hardware_key values.
+func HardwareKinds() []string {
+	return []string{HardwareTPM, HardwareSecureEnclave}
+}
+
+// DefaultHardwareKind returns the hardware key kind of this platform, or ""
+// if it has none.
+func DefaultHardwareKind() string {
+	for _, kind := range HardwareKinds() {
+		if slices.Contains(hardwarePlugins[kind].platforms, runtime.GOOS) {
+			return kind
+		}
+	}
+	return ""
+}
+
+// CheckHardware reports whether keys of the given kind can be used here:
+// the platform must have the hardware and the age plugin must be installed.
+func CheckHardware(kind string) error {
+	plugin, ok := hardwarePlugins[kind]
+	if !ok {
+		return errs.Errorf(errs.ErrConfigInvalid, "unknown hardware key %q (supported: %s)",
+			kind, strings.Join(HardwareKinds(), ", "))
+	}
+	if !slices.Contains(plugin.platforms, runtime.GOOS) {
+		return errs.Errorf(errs.ErrEncryptionUnavailable, "%s keys are not supported on %s", kind, runtime.GOOS)
+	}
+	if _, err := exec.LookPath(plugin.binary); err != nil {
+		return errs.Errorf(errs.ErrEncryptionUnavailable, "%s keys need %s in PATH", kind, plugin.binary)
+	}
+	return nil
+}
+
+// GenerateHardwareIdentity creates a key sealed to the device's TPM or
+// Secure Enclave, writes its age identity to identityPath, and returns the
+// recipient to encrypt to.
+func GenerateHardwareIdentity(kind, identityPath string) (string, error) {
+	if err := CheckHardware(kind); err != nil {
+		return "", err
+	}
+	plugin := hardwarePlugins[kind]
+	if _, err := runKeyTool(plugin.binary, plugin.generate(identityPath)...); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(identityPath, 0600); err != nil {
+		return "", err
+	}
+	return runKeyTool(plugin.binary, plugin.recipient(identityPath)...)
+}
+
+// GenerateRecoveryIdentity creates a regular age identity at identityPath
+// and returns its recipient. Archives also encrypted to it can be restored
+// on another machine with the identity file, which belongs offline.
+func GenerateRecoveryIdentity(identityPath string) (string, error) {
+	if _, err := runKeyTool("age-keygen", "-o", identityPath); err != nil {
+		return "", err
+	}
+	return runKeyTool("age-keygen", "-y", identityPath)
+}
+
+// runKeyTool runs a key generation tool and returns its trimmed output.
+func runKeyTool(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", errs.Errorf(errs.ErrEncryptionUnavailable, "%s is not installed", name)
+		}
+		return "", errs.Errorf(errs.ErrEncryptionFailed, "%s failed: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
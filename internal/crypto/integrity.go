@@ -0,0 +1,121 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// hmacKeySize is the size of a generated integrity key in bytes.
+const hmacKeySize = 32
+
+// LoadHMACKey reads the hex-encoded integrity key at path.
+func LoadHMACKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) < hmacKeySize {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "invalid integrity key in %s", path)
+	}
+	return key, nil
+}
+
+// LoadOrCreateHMACKey reads the integrity key at path, generating a random
+// one with 0600 permissions if the file does not exist.
+func LoadOrCreateHMACKey(path string) ([]byte, error) {
+	key, err := LoadHMACKey(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+
+	key = make([]byte, hmacKeySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// O_EXCL: never overwrite a key another process just created
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return LoadHMACKey(path)
+		}
+		return nil, err
+	}
+	_, err = file.WriteString(hex.EncodeToString(key) + "\n")
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ArchiveHMAC returns the hex HMAC-SHA256 of the archive at path and its
+// signed metadata (see metadata.Metadata.Signed). The MAC covers the file
+// name as well as the content, so a validly signed archive cannot be passed
+// off under another name (e.g. an old backup as the latest), and the
+// metadata, so its parent and file catalog cannot be swapped either.
+func ArchiveHMAC(key []byte, path string, meta []byte) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sum, err := archiveMAC(key, filepath.Base(path), meta, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// VerifyArchiveHMAC checks the archive read from file and its signed
+// metadata against the expected HMAC, returning ErrArchiveCorrupt if they do
+// not match. It reads file from its current offset to the end; the caller
+// seeks back to read the verified content, so that the archive cannot be
+// swapped between the check and the read.
+func VerifyArchiveHMAC(key []byte, file *os.File, meta []byte, expected string) error {
+	name := filepath.Base(file.Name())
+	want, err := hex.DecodeString(expected)
+	if err != nil {
+		return errs.Errorf(errs.ErrArchiveCorrupt, "%s: malformed integrity HMAC", name)
+	}
+	got, err := archiveMAC(key, name, meta, file)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(got, want) {
+		return errs.Errorf(errs.ErrArchiveCorrupt,
+			"%s: integrity check failed, the archive or its metadata was modified or not created with this key", name)
+	}
+	return nil
+}
+
+// archiveMAC computes the MAC of an archive: its name and metadata, each
+// prefixed with its length so neither can run into the other, then the
+// content.
+func archiveMAC(key []byte, name string, meta []byte, content io.Reader) ([]byte, error) {
+	mac := hmac.New(sha256.New, key)
+	for _, field := range [][]byte{[]byte(name), meta} {
+		_ = binary.Write(mac, binary.BigEndian, uint64(len(field)))
+		mac.Write(field)
+	}
+	if _, err := io.Copy(mac, content); err != nil {
+		return nil, err
+	}
+	return mac.Sum(nil), nil
+}
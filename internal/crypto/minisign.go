@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// MinisignSuffix is appended to a file name to find its minisign signature.
+const MinisignSuffix = ".minisig"
+
+// minisign signature algorithms: Ed signs the file itself, ED (the default
+// since minisign 0.10) signs its BLAKE2b-512 hash.
+const (
+	minisignLegacy    = "Ed"
+	minisignPrehashed = "ED"
+)
+
+// MinisignKey is a minisign public key.
+type MinisignKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// ParseMinisignKey parses a minisign public key, given either as the base64
+// line ("RWQ...") or as the contents of a minisign.pub file.
+func ParseMinisignKey(s string) (MinisignKey, error) {
+	line := lastLine(s)
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != minisignLegacy {
+		return MinisignKey{}, errs.Errorf(errs.ErrConfigInvalid, "invalid minisign public key %q", line)
+	}
+	var key MinisignKey
+	copy(key.ID[:], raw[2:10])
+	key.Key = ed25519.PublicKey(raw[10:])
+	return key, nil
+}
+
+// String returns the key ID in the hex form minisign prints.
+func (k MinisignKey) String() string {
+	id := k.ID
+	for i, j := 0, len(id)-1; i < j; i, j = i+1, j-1 {
+		id[i], id[j] = id[j], id[i]
+	}
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
+
+// minisignSignature is a parsed .minisig file.
+type minisignSignature struct {
+	algorithm      string
+	keyID          [8]byte
+	signature      []byte
+	trustedComment string
+	globalSig      []byte
+}
+
+func parseMinisignSignature(data []byte) (*minisignSignature, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return nil, errors.New("not a minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return nil, errors.New("malformed minisign signature")
+	}
+	comment, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return nil, errors.New("minisign signature has no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return nil, errors.New("malformed minisign global signature")
+	}
+
+	sig := &minisignSignature{
+		algorithm:      string(raw[:2]),
+		signature:      raw[10:],
+		trustedComment: comment,
+		globalSig:      global,
+	}
+	copy(sig.keyID[:], raw[2:10])
+	if sig.algorithm != minisignLegacy && sig.algorithm != minisignPrehashed {
+		return nil, fmt.Errorf("unsupported minisign algorithm %q", sig.algorithm)
+	}
+	return sig, nil
+}
+
+// VerifyMinisign checks the minisign signature sigData of the file at path
+// against the trusted keys and returns the signature's trusted comment. It
+// returns ErrArchiveCorrupt if the signature is invalid or was made by an
+// untrusted key.
+func VerifyMinisign(keys []MinisignKey, path string, sigData []byte) (string, error) {
+	sig, err := parseMinisignSignature(sigData)
+	if err != nil {
+		return "", errs.Wrap(errs.ErrArchiveCorrupt, err)
+	}
+
+	var key *MinisignKey
+	for i := range keys {
+		if keys[i].ID == sig.keyID {
+			key = &keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return "", errs.Errorf(errs.ErrArchiveCorrupt, "signature was made by untrusted key %s",
+			MinisignKey{ID: sig.keyID}.String())
+	}
+
+	message, err := minisignMessage(sig.algorithm, path)
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(key.Key, message, sig.signature) {
+		return "", errs.Errorf(errs.ErrArchiveCorrupt, "minisign signature verification failed for %s", path)
+	}
+	// the global signature binds the trusted comment to the file signature
+	if !ed25519.Verify(key.Key, append(bytes.Clone(sig.signature), sig.trustedComment...), sig.globalSig) {
+		return "", errs.Errorf(errs.ErrArchiveCorrupt, "minisign trusted comment verification failed for %s", path)
+	}
+	return sig.trustedComment, nil
+}
+
+// minisignMessage returns the signed message for path: the file itself for
+// legacy signatures, its BLAKE2b-512 hash for prehashed ones.
+func minisignMessage(algorithm, path string) ([]byte, error) {
+	if algorithm == minisignLegacy {
+		return os.ReadFile(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// lastLine returns the last non-empty, non-comment line of s.
+func lastLine(s string) string {
+	var last string
+	for line := range strings.SplitSeq(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			last = line
+		}
+	}
+	return last
+}
@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// Kinds of age recipient and identity.
+const (
+	KeyNative = "age"
+	KeySSH    = "ssh"
+	KeyPlugin = "plugin"
+)
+
+// sshKeyTypes are the SSH public key types age encrypts to.
+var sshKeyTypes = []string{"ssh-ed25519", "ssh-rsa"}
+
+// Recipient is an entry of an age recipients file.
+type Recipient struct {
+	Kind string
+	// Plugin names the age plugin that handles a KeyPlugin recipient, such
+	// as "yubikey" for age1yubikey1 recipients, run as age-plugin-yubikey.
+	Plugin string
+}
+
+// ParseRecipient classifies an age recipient: a native age1 key, an
+// ssh-ed25519 or ssh-rsa public key as found in authorized_keys or an
+// id_*.pub file, or the key of an age plugin, such as age1yubikey1...
+func ParseRecipient(s string) (Recipient, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "ssh-") || strings.HasPrefix(s, "sk-ssh-") {
+		fields := strings.Fields(s)
+		if !slices.Contains(sshKeyTypes, fields[0]) {
+			return Recipient{}, fmt.Errorf("unsupported SSH key type %s (age supports %s)",
+				fields[0], strings.Join(sshKeyTypes, " and "))
+		}
+		if len(fields) < 2 {
+			return Recipient{}, fmt.Errorf("%s key has no key data", fields[0])
+		}
+		if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+			return Recipient{}, fmt.Errorf("malformed %s key: %w", fields[0], err)
+		}
+		return Recipient{Kind: KeySSH}, nil
+	}
+
+	// a bech32 string ends with the last "1": age1<data> for native keys,
+	// age1<plugin>1<data> for plugin keys
+	lower := strings.ToLower(s)
+	sep := strings.LastIndex(lower, "1")
+	if !strings.HasPrefix(lower, "age1") || strings.ContainsAny(s, " \t") || sep == len(s)-1 {
+		return Recipient{}, fmt.Errorf("not an age recipient: %q", truncateKey(s))
+	}
+	if hrp := lower[:sep]; hrp != "age" {
+		return Recipient{Kind: KeyPlugin, Plugin: hrp[len("age1"):]}, nil
+	}
+	return Recipient{Kind: KeyNative}, nil
+}
+
+// ReadRecipients parses an age recipients file: one recipient per line,
+// with blank lines and # comments ignored.
+func ReadRecipients(path string) ([]Recipient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errs.Errorf(errs.ErrEncryptionUnavailable, "age recipients file not found: %s", path)
+	}
+	defer f.Close()
+
+	var recipients []Recipient
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		recipient, parseErr := ParseRecipient(line)
+		if parseErr != nil {
+			return nil, errs.Errorf(errs.ErrConfigInvalid, "%s:%d: %v", path, n, parseErr)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "%s holds no age recipients", path)
+	}
+	return recipients, nil
+}
+
+// CheckRecipients reports whether age can encrypt to the recipients file:
+// every line must be a recipient, and the plugin of each plugin recipient
+// must be installed.
+func CheckRecipients(path string) error {
+	recipients, err := ReadRecipients(path)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if recipient.Kind == KeyPlugin {
+			if err = checkPlugin(recipient.Plugin, "age1"+recipient.Plugin+"1 recipients"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IdentityPlugin returns the age plugin that an identity file needs, such
+// as "yubikey" for AGE-PLUGIN-YUBIKEY-1 identities, or "" for native age
+// and SSH private keys.
+func IdentityPlugin(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, "AGE-PLUGIN-"); ok {
+			if sep := strings.LastIndex(rest, "-1"); sep > 0 {
+				return strings.ToLower(rest[:sep]), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// checkPlugin reports whether age-plugin-<name>, which what needs, is in
+// PATH.
+func checkPlugin(name, what string) error {
+	binary := "age-plugin-" + name
+	if _, err := exec.LookPath(binary); err != nil {
+		return errs.Errorf(errs.ErrEncryptionUnavailable, "%s need %s in PATH", what, binary)
+	}
+	return nil
+}
+
+// truncateKey shortens a key for error messages.
+func truncateKey(s string) string {
+	if len(s) > 24 {
+		return s[:24] + "..."
+	}
+	return s
+}
@@ -0,0 +1,227 @@
+// Package download fetches backup archives over HTTP(S) into a local cache,
+// verifying their SHA-256 checksum.
+package download
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+const (
+	// ChecksumParam is the query parameter that may carry the expected SHA-256.
+	// It is removed from the URL before the archive is requested.
+	ChecksumParam = "sha256"
+	// SidecarSuffix is appended to the archive URL to find its checksum file,
+	// in sha256sum format ("<hex>  <name>").
+	SidecarSuffix = ".sha256"
+)
+
+// timeout bounds a whole archive download.
+const timeout = 10 * time.Minute
+
+// maxSidecarSize bounds how much of a checksum file is read.
+const maxSidecarSize = 4096
+
+// IsURL reports whether s is an http or https URL.
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// Archive downloads the archive at rawURL into cacheDir and returns its local
+// path. The expected checksum is taken from the sha256 query parameter or,
+// if absent, from the sidecar file at rawURL + ".sha256"; downloads without
+// a checksum are refused. A previously downloaded archive with the same
+// checksum is reused without downloading it again.
+func Archive(rawURL, cacheDir string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid archive URL: %s", rawURL)
+	}
+
+	query := u.Query()
+	expected := strings.ToLower(query.Get(ChecksumParam))
+	query.Del(ChecksumParam)
+	u.RawQuery = query.Encode()
+
+	name, ok := fileName(u.Path)
+	if !ok {
+		return "", fmt.Errorf("archive URL has no file name: %s", rawURL)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if expected == "" {
+		sidecar := *u
+		sidecar.Path += SidecarSuffix
+		expected, err = fetchChecksum(ctx, sidecar.String())
+		if err != nil {
+			return "", fmt.Errorf("no checksum for %s: pass ?%s=<hex> or publish %s: %w",
+				name, ChecksumParam, name+SidecarSuffix, err)
+		}
+	}
+	if !isSHA256(expected) {
+		return "", fmt.Errorf("invalid sha256 checksum %q", expected)
+	}
+
+	dir := filepath.Join(cacheDir, expected)
+	dest := filepath.Join(dir, name)
+	if sum, hashErr := hashFile(dest); hashErr == nil && sum == expected {
+		return dest, nil
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating download cache: %w", err)
+	}
+	if err = fetchArchive(ctx, u.String(), dest, expected); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// fileName returns the last element of the URL path p, under which the
+// download is saved, and whether it is a plain file name: not empty, "." or
+// "..", and free of separators, which a decoded path may hold as %2F or %5C,
+// so that it cannot resolve outside the download directory.
+func fileName(p string) (string, bool) {
+	name := path.Base(p)
+	switch name {
+	case "", "/", ".", "..":
+		return "", false
+	}
+	return name, !strings.ContainsAny(name, `/\`) && filepath.IsLocal(name)
+}
+
+// VerifySignature checks the downloaded archive at localPath against the minisign
+// signature published at rawURL + ".minisig", which must be made by one of
+// the trusted keys. It returns the signature's trusted comment.
+func VerifySignature(rawURL, localPath string, keys []crypto.MinisignKey) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive URL: %s", rawURL)
+	}
+	query := u.Query()
+	query.Del(ChecksumParam)
+	u.RawQuery = query.Encode()
+	u.Path += crypto.MinisignSuffix
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := get(ctx, u.String())
+	if err != nil {
+		return "", errs.Errorf(errs.ErrArchiveCorrupt, "no signature for %s: %w", path.Base(u.Path), err)
+	}
+	defer resp.Body.Close()
+	sig, err := io.ReadAll(io.LimitReader(resp.Body, maxSidecarSize))
+	if err != nil {
+		return "", fmt.Errorf("downloading signature: %w", err)
+	}
+	return crypto.VerifyMinisign(keys, localPath, sig)
+}
+
+// fetchArchive downloads src to dest, failing if its SHA-256 is not expected.
+// The file is written under a temporary name and renamed once verified.
+func fetchArchive(ctx context.Context, src, dest, expected string) error {
+	resp, err := get(ctx, src)
+	if err != nil {
+		return errs.Wrap(errs.ErrArchiveNotFound, err)
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".download-*")
+	if err != nil {
+		return fmt.Errorf("creating download file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("downloading archive: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return errs.Errorf(errs.ErrArchiveCorrupt, "checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// fetchChecksum reads the first field of the checksum file at src.
+func fetchChecksum(ctx context.Context, src string) (string, error) {
+	resp, err := get(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxSidecarSize))
+	if !scanner.Scan() {
+		return "", errors.New("empty checksum file")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) == 0 {
+		return "", errors.New("empty checksum file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// get performs a GET request and fails on non-2xx responses. The caller must
+// close the response body.
+func get(ctx context.Context, src string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", "dotpak")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", src, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", src, resp.Status)
+	}
+	return resp, nil
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
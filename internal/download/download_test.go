@@ -0,0 +1,213 @@
+package download
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+const archiveName = "dotfiles-20260101_120000.tar.gz"
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newServer serves body at /archiveName and, if sidecar is non-empty, the
+// sidecar checksum file next to it. It counts archive requests.
+func newServer(t *testing.T, body []byte, sidecar string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + archiveName:
+			requests.Add(1)
+			if r.URL.Query().Has(ChecksumParam) {
+				t.Errorf("checksum parameter should not be sent to the server: %s", r.URL)
+			}
+			_, _ = w.Write(body)
+		case "/" + archiveName + SidecarSuffix:
+			if sidecar == "" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(sidecar))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestArchive(t *testing.T) {
+	t.Parallel()
+
+	body := []byte("archive contents")
+	sum := checksum(body)
+
+	t.Run("checksum from query parameter", func(t *testing.T) {
+		t.Parallel()
+		srv, requests := newServer(t, body, "")
+		cacheDir := t.TempDir()
+		url := srv.URL + "/" + archiveName + "?sha256=" + strings.ToUpper(sum)
+
+		path, err := Archive(url, cacheDir)
+		if err != nil {
+			t.Fatalf("Archive() error: %v", err)
+		}
+		if !strings.HasSuffix(path, archiveName) {
+			t.Errorf("expected cached file named %s, got %s", archiveName, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != string(body) {
+			t.Errorf("unexpected cached contents %q (err %v)", data, err)
+		}
+
+		// second call is served from the cache
+		if _, err = Archive(url, cacheDir); err != nil {
+			t.Fatalf("Archive() from cache error: %v", err)
+		}
+		if n := requests.Load(); n != 1 {
+			t.Errorf("expected 1 download, got %d", n)
+		}
+	})
+
+	t.Run("checksum from sidecar", func(t *testing.T) {
+		t.Parallel()
+		srv, _ := newServer(t, body, sum+"  "+archiveName+"\n")
+
+		if _, err := Archive(srv.URL+"/"+archiveName, t.TempDir()); err != nil {
+			t.Fatalf("Archive() error: %v", err)
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		t.Parallel()
+		srv, _ := newServer(t, body, checksum([]byte("other"))+"\n")
+		cacheDir := t.TempDir()
+
+		_, err := Archive(srv.URL+"/"+archiveName, cacheDir)
+		if errs.Code(err) != errs.CodeArchiveCorrupt {
+			t.Fatalf("expected %s, got %v", errs.CodeArchiveCorrupt, err)
+		}
+	})
+
+	t.Run("no checksum", func(t *testing.T) {
+		t.Parallel()
+		srv, requests := newServer(t, body, "")
+
+		if _, err := Archive(srv.URL+"/"+archiveName, t.TempDir()); err == nil {
+			t.Fatal("expected error without a checksum")
+		}
+		if n := requests.Load(); n != 0 {
+			t.Errorf("archive should not be downloaded without a checksum, got %d requests", n)
+		}
+	})
+
+	t.Run("no file name", func(t *testing.T) {
+		t.Parallel()
+		srv, requests := newServer(t, body, "")
+
+		for _, p := range []string{"/", "/archives/..", "/archives/.", "/..%5C..%5Cevil.tar.gz"} {
+			if _, err := Archive(srv.URL+p+"?sha256="+sum, t.TempDir()); err == nil {
+				t.Errorf("Archive(%q) succeeded, want an error", p)
+			}
+		}
+		if n := requests.Load(); n != 0 {
+			t.Errorf("nothing should be downloaded, got %d requests", n)
+		}
+	})
+
+	t.Run("missing archive", func(t *testing.T) {
+		t.Parallel()
+		srv, _ := newServer(t, body, "")
+
+		_, err := Archive(srv.URL+"/missing.tar.gz?sha256="+sum, t.TempDir())
+		if errs.Code(err) != errs.CodeArchiveNotFound {
+			t.Fatalf("expected %s, got %v", errs.CodeArchiveNotFound, err)
+		}
+	})
+}
+
+func TestIsURL(t *testing.T) {
+	t.Parallel()
+
+	for input, expected := range map[string]bool{
+		"https://example.com/a.tar.gz": true,
+		"http://example.com/a.tar.gz":  true,
+		"backup.tar.gz":                false,
+		"/tmp/https://x":               false,
+	} {
+		if got := IsURL(input); got != expected {
+			t.Errorf("IsURL(%q) = %v, want %v", input, got, expected)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte("dotpakid")
+	pubLine := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), pub...))
+	keys := make([]crypto.MinisignKey, 1)
+	keys[0], err = crypto.ParseMinisignKey(pubLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte("archive contents")
+	sig := ed25519.Sign(priv, body)
+	minisig := fmt.Sprintf("untrusted comment: signature\n%s\ntrusted comment: release\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), sig...)),
+		base64.StdEncoding.EncodeToString(ed25519.Sign(priv, append(sig, "release"...))))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + archiveName:
+			_, _ = w.Write(body)
+		case "/" + archiveName + crypto.MinisignSuffix:
+			_, _ = w.Write([]byte(minisig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	url := srv.URL + "/" + archiveName + "?sha256=" + checksum(body)
+	path, err := Archive(url, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment, err := VerifySignature(url, path, keys)
+	if err != nil || comment != "release" {
+		t.Errorf("VerifySignature() = %q, %v", comment, err)
+	}
+
+	if err = os.WriteFile(path, []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = VerifySignature(url, path, keys); !errors.Is(err, errs.ErrArchiveCorrupt) {
+		t.Errorf("VerifySignature() of a modified archive error = %v, want ErrArchiveCorrupt", err)
+	}
+	if _, err = VerifySignature(srv.URL+"/unsigned.tar.gz", path, keys); !errors.Is(err, errs.ErrArchiveCorrupt) {
+		t.Errorf("VerifySignature() without signature error = %v, want ErrArchiveCorrupt", err)
+	}
+}
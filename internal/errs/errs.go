@@ -0,0 +1,157 @@
+// Package errs defines the error taxonomy surfaced as error_code in JSON output,
+// so wrappers can branch on the kind of failure instead of matching messages.
+package errs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Sentinel errors classifying failures. Test with errors.Is.
+var (
+	ErrConfigInvalid         = errors.New("invalid configuration")
+	ErrEncryptionUnavailable = errors.New("encryption unavailable")
+	ErrEncryptionFailed      = errors.New("encryption failed")
+	ErrDecryptionFailed      = errors.New("decryption failed")
+	ErrArchiveNotFound       = errors.New("archive not found")
+	ErrArchiveCorrupt        = errors.New("archive corrupt")
+	ErrPermissionDenied      = errors.New("permission denied")
+	ErrNoSpace               = errors.New("no space left on device")
+	ErrNothingToBackup       = errors.New("nothing to backup")
+	ErrCanceled              = errors.New("canceled")
+	ErrLocked                = errors.New("backup directory locked")
+	ErrNewerFormat           = errors.New("archive needs a newer dotpak")
+	ErrPartial               = errors.New("completed with warnings")
+)
+
+// Error codes reported in the error_code field of JSON results.
+const (
+	CodeConfigInvalid         = "config_invalid"
+	CodeEncryptionUnavailable = "encryption_unavailable"
+	CodeEncryptionFailed      = "encryption_failed"
+	CodeDecryptionFailed      = "decryption_failed"
+	CodeArchiveNotFound       = "archive_not_found"
+	CodeArchiveCorrupt        = "archive_corrupt"
+	CodePermissionDenied      = "permission_denied"
+	CodeNoSpace               = "no_space"
+	CodeNothingToBackup       = "nothing_to_backup"
+	CodeCanceled              = "canceled"
+	CodeLocked                = "locked"
+	CodeNewerFormat           = "newer_format"
+	CodePartial               = "partial"
+	CodeUnknown               = "unknown"
+)
+
+// codes maps sentinel errors to their codes, in match priority order.
+var codes = []struct {
+	err  error
+	code string
+}{
+	{ErrConfigInvalid, CodeConfigInvalid},
+	{ErrEncryptionUnavailable, CodeEncryptionUnavailable},
+	{ErrEncryptionFailed, CodeEncryptionFailed},
+	{ErrDecryptionFailed, CodeDecryptionFailed},
+	{ErrArchiveNotFound, CodeArchiveNotFound},
+	{ErrNewerFormat, CodeNewerFormat},
+	{ErrArchiveCorrupt, CodeArchiveCorrupt},
+	{ErrNothingToBackup, CodeNothingToBackup},
+	{ErrCanceled, CodeCanceled},
+	{ErrLocked, CodeLocked},
+	{ErrNoSpace, CodeNoSpace},
+	{ErrPermissionDenied, CodePermissionDenied},
+	{ErrPartial, CodePartial},
+	// underlying causes that were not explicitly classified
+	{syscall.ENOSPC, CodeNoSpace},
+	{os.ErrPermission, CodePermissionDenied},
+	{gzip.ErrHeader, CodeArchiveCorrupt},
+	{gzip.ErrChecksum, CodeArchiveCorrupt},
+	{tar.ErrHeader, CodeArchiveCorrupt},
+	{io.ErrUnexpectedEOF, CodeArchiveCorrupt},
+}
+
+// exitCodes maps error codes to the exit status of the dotpak command, so
+// scripts can tell failures apart without --json. Other codes exit with 1.
+var exitCodes = map[string]int{
+	CodeConfigInvalid:         2,
+	CodePartial:               3,
+	CodeEncryptionUnavailable: 4,
+	CodeEncryptionFailed:      5,
+	CodeDecryptionFailed:      5,
+	CodeArchiveNotFound:       6,
+	CodeArchiveCorrupt:        7,
+	CodeNewerFormat:           7,
+	CodeNothingToBackup:       8,
+	CodeNoSpace:               9,
+	CodePermissionDenied:      10,
+	CodeLocked:                11,
+	// as for a shell command killed by SIGINT
+	CodeCanceled: 130,
+}
+
+// classified tags an error with a sentinel kind without changing its message.
+type classified struct {
+	kind error
+	err  error
+}
+
+func (c *classified) Error() string { return c.err.Error() }
+
+func (c *classified) Unwrap() []error { return []error{c.kind, c.err} }
+
+// Wrap classifies err as kind. The returned error keeps err's message and
+// matches both kind and err with errors.Is. Wrap returns nil if err is nil.
+func Wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classified{kind: kind, err: err}
+}
+
+// Errorf formats an error message and classifies it as kind.
+// Like fmt.Errorf, it supports %w for wrapping an underlying cause.
+func Errorf(kind error, format string, args ...any) error {
+	return Wrap(kind, fmt.Errorf(format, args...))
+}
+
+// Code returns the error code for err, or an empty string if err is nil.
+func Code(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return CodeUnknown
+}
+
+// FromCode returns an error with message, classified by the sentinel of
+// code, for a failure reported in a result, whose error is only kept as its
+// message and code.
+func FromCode(code, message string) error {
+	err := errors.New(message)
+	for _, c := range codes {
+		if c.code == code {
+			return Wrap(c.err, err)
+		}
+	}
+	return err
+}
+
+// ExitCode returns the exit status for err: 0 if it is nil, the status of
+// its code, or 1.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if status, ok := exitCodes[Code(err)]; ok {
+		return status
+	}
+	return 1
+}
@@ -0,0 +1,108 @@
+package errs
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("recipients file not found")
+	err := Wrap(ErrEncryptionUnavailable, cause)
+
+	if err.Error() != cause.Error() {
+		t.Errorf("expected message to be preserved, got %q", err.Error())
+	}
+	if !errors.Is(err, ErrEncryptionUnavailable) {
+		t.Error("expected error to match its kind")
+	}
+	if !errors.Is(err, cause) {
+		t.Error("expected error to match its cause")
+	}
+	if Wrap(ErrConfigInvalid, nil) != nil {
+		t.Error("expected Wrap(nil) to return nil")
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	t.Parallel()
+
+	err := Errorf(ErrArchiveNotFound, "archive not found: %s", "/backups/x.tar.gz")
+	if err.Error() != "archive not found: /backups/x.tar.gz" {
+		t.Errorf("unexpected message: %q", err.Error())
+	}
+	if Code(err) != CodeArchiveNotFound {
+		t.Errorf("expected %s, got %s", CodeArchiveNotFound, Code(err))
+	}
+}
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"unclassified", errors.New("boom"), CodeUnknown},
+		{"classified", Wrap(ErrConfigInvalid, errors.New("bad")), CodeConfigInvalid},
+		{"wrapped classified", fmt.Errorf("loading: %w", Wrap(ErrConfigInvalid, errors.New("bad"))), CodeConfigInvalid},
+		{"os permission", &fs.PathError{Op: "open", Path: "/x", Err: syscall.EACCES}, CodePermissionDenied},
+		{"disk full", &fs.PathError{Op: "write", Path: "/x", Err: syscall.ENOSPC}, CodeNoSpace},
+		{"gzip header", fmt.Errorf("extraction failed: %w", gzip.ErrHeader), CodeArchiveCorrupt},
+		{"kind wins over cause", Wrap(ErrDecryptionFailed, os.ErrPermission), CodeDecryptionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Code(tt.err); got != tt.want {
+				t.Errorf("Code(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, 0},
+		{"unclassified", errors.New("boom"), 1},
+		{"config", Wrap(ErrConfigInvalid, errors.New("bad")), 2},
+		{"partial", Errorf(ErrPartial, "the run printed a warning"), 3},
+		{"nothing to backup", Wrap(ErrNothingToBackup, errors.New("no files")), 8},
+		{"disk full", &fs.PathError{Op: "write", Path: "/x", Err: syscall.ENOSPC}, 9},
+		{"canceled", fmt.Errorf("backup: %w", ErrCanceled), 130},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromCode(t *testing.T) {
+	t.Parallel()
+
+	err := FromCode(CodeNoSpace, "creating archive: no space left on device")
+	if err.Error() != "creating archive: no space left on device" || !errors.Is(err, ErrNoSpace) {
+		t.Errorf("FromCode(%s) = %v, want the message classified as ErrNoSpace", CodeNoSpace, err)
+	}
+	if err = FromCode(CodeUnknown, "boom"); Code(err) != CodeUnknown || err.Error() != "boom" {
+		t.Errorf("FromCode(%s) = %v (%s), want an unclassified error", CodeUnknown, err, Code(err))
+	}
+}
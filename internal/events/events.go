@@ -0,0 +1,180 @@
+// Package events defines the progress events emitted by backup and restore,
+// so callers can render them as text, stream them as JSON, or drive a UI
+// without the library code knowing how they are displayed.
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kind identifies the type of an Event.
+type Kind int
+
+const (
+	// KindInfo is a status message shown in normal output.
+	KindInfo Kind = iota
+	// KindDetail is a diagnostic message shown only in verbose output.
+	KindDetail
+	// KindWarning is a problem that did not stop the operation.
+	KindWarning
+	// KindError is a problem that stopped the operation.
+	KindError
+	// KindSuccess announces that the operation finished.
+	KindSuccess
+	// KindPhase marks the start of a new Phase.
+	KindPhase
+	// KindFileStarted is emitted before a file is processed.
+	KindFileStarted
+	// KindFileDone is emitted after a file is processed, with Err set on failure.
+	KindFileDone
+	// KindBytes reports how many bytes of a known total have been processed.
+	KindBytes
+)
+
+// String returns the kind name used in JSON output.
+func (k Kind) String() string {
+	switch k {
+	case KindInfo:
+		return "info"
+	case KindDetail:
+		return "detail"
+	case KindWarning:
+		return "warning"
+	case KindError:
+		return "error"
+	case KindSuccess:
+		return "success"
+	case KindPhase:
+		return "phase"
+	case KindFileStarted:
+		return "file_started"
+	case KindFileDone:
+		return "file_done"
+	case KindBytes:
+		return "bytes"
+	}
+	return "unknown"
+}
+
+// Phase names a stage of a backup or restore.
+type Phase string
+
+const (
+	PhaseCollect      Phase = "collect"
+	PhaseArchive      Phase = "archive"
+	PhasePackages     Phase = "packages"
+	PhaseCleanup      Phase = "cleanup"
+	PhaseDecrypt      Phase = "decrypt"
+	PhaseSafetyBackup Phase = "safety_backup"
+	PhaseExtract      Phase = "extract"
+	PhaseVerify       Phase = "verify"
+	PhasePostRestore  Phase = "post_restore"
+)
+
+// Event is a single progress or status notification.
+type Event struct {
+	Kind Kind
+	// Phase is set for KindPhase.
+	Phase Phase
+	// Path, Current, and Total are set for file events. Total is zero when
+	// the number of files is not known in advance.
+	Path    string
+	Current int
+	Total   int
+	// Size is the number of bytes processed, set for KindFileDone.
+	Size int64
+	// Err is set for KindFileDone when the file could not be processed.
+	Err error
+	// Bytes, TotalBytes, Rate (bytes per second), and ETA are set for
+	// KindBytes. ETA is zero until the rate is known.
+	Bytes      int64
+	TotalBytes int64
+	Rate       int64
+	ETA        time.Duration
+	// Message is human-readable text, formatted the way the CLI prints it.
+	Message string
+}
+
+// Sink receives events. Emit is never called concurrently, but an operation
+// that works in parallel may call it from several goroutines in turn. It
+// should return quickly.
+type Sink interface {
+	Emit(e Event)
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(Event)
+
+// Emit calls f(e).
+func (f SinkFunc) Emit(e Event) {
+	f(e)
+}
+
+// Synchronized returns a Sink that passes events to s one at a time, for
+// operations that emit from several goroutines.
+func Synchronized(s Sink) Sink {
+	var mu sync.Mutex
+	return SinkFunc(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		s.Emit(e)
+	})
+}
+
+// Discard is a Sink that drops every event.
+var Discard Sink = SinkFunc(func(Event) {})
+
+// Info emits a KindInfo message.
+func Info(s Sink, format string, args ...any) {
+	s.Emit(Event{Kind: KindInfo, Message: fmt.Sprintf(format, args...)})
+}
+
+// Detail emits a KindDetail message.
+func Detail(s Sink, format string, args ...any) {
+	s.Emit(Event{Kind: KindDetail, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warning emits a KindWarning message.
+func Warning(s Sink, format string, args ...any) {
+	s.Emit(Event{Kind: KindWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Error emits a KindError message.
+func Error(s Sink, format string, args ...any) {
+	s.Emit(Event{Kind: KindError, Message: fmt.Sprintf(format, args...)})
+}
+
+// Success emits a KindSuccess message.
+func Success(s Sink, format string, args ...any) {
+	s.Emit(Event{Kind: KindSuccess, Message: fmt.Sprintf(format, args...)})
+}
+
+// StartPhase emits a KindPhase event. The message may be empty.
+func StartPhase(s Sink, p Phase, format string, args ...any) {
+	s.Emit(Event{Kind: KindPhase, Phase: p, Message: fmt.Sprintf(format, args...)})
+}
+
+// FileStarted emits a KindFileStarted event.
+func FileStarted(s Sink, path string, current, total int) {
+	s.Emit(Event{Kind: KindFileStarted, Path: path, Current: current, Total: total})
+}
+
+// FileDone emits a KindFileDone event.
+func FileDone(s Sink, path string, current, total int, size int64, err error) {
+	s.Emit(Event{Kind: KindFileDone, Path: path, Current: current, Total: total, Size: size, Err: err})
+}
+
+// BytesDone emits a KindBytes event for done of totalBytes processed in
+// elapsed time, deriving the throughput and the time remaining from them.
+func BytesDone(s Sink, path string, current, total int, done, totalBytes int64, elapsed time.Duration) {
+	e := Event{Kind: KindBytes, Path: path, Current: current, Total: total, Bytes: done, TotalBytes: totalBytes}
+	if elapsed > 0 && done > 0 {
+		e.Rate = int64(float64(done) / elapsed.Seconds())
+		if remaining := totalBytes - done; remaining > 0 && e.Rate > 0 {
+			e.ETA = time.Duration(float64(remaining) / float64(e.Rate) * float64(time.Second))
+		}
+	}
+	s.Emit(e)
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHelpers(t *testing.T) {
+	t.Parallel()
+
+	var got []Event
+	sink := SinkFunc(func(e Event) { got = append(got, e) })
+
+	failure := errors.New("permission denied")
+
+	StartPhase(sink, PhaseArchive, "Creating archive: %s\n", "a.tar.gz")
+	FileStarted(sink, ".zshrc", 1, 2)
+	FileDone(sink, ".zshrc", 1, 2, 42, failure)
+	Warning(sink, "careful: %d\n", 3)
+
+	if len(got) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(got))
+	}
+	if got[0].Kind != KindPhase || got[0].Phase != PhaseArchive || got[0].Message != "Creating archive: a.tar.gz\n" {
+		t.Errorf("unexpected phase event: %+v", got[0])
+	}
+	if got[1].Kind != KindFileStarted || got[1].Path != ".zshrc" || got[1].Current != 1 || got[1].Total != 2 {
+		t.Errorf("unexpected file started event: %+v", got[1])
+	}
+	if got[2].Kind != KindFileDone || got[2].Size != 42 || !errors.Is(got[2].Err, failure) {
+		t.Errorf("unexpected file done event: %+v", got[2])
+	}
+	if got[3].Kind != KindWarning || got[3].Message != "careful: 3\n" {
+		t.Errorf("unexpected warning event: %+v", got[3])
+	}
+}
+
+func TestBytesDone(t *testing.T) {
+	t.Parallel()
+
+	var got Event
+	sink := SinkFunc(func(e Event) { got = e })
+
+	BytesDone(sink, ".zshrc", 1, 4, 100, 400, 2*time.Second)
+	if got.Kind != KindBytes || got.Bytes != 100 || got.TotalBytes != 400 {
+		t.Fatalf("unexpected bytes event: %+v", got)
+	}
+	if got.Rate != 50 {
+		t.Errorf("Rate = %d, want 50", got.Rate)
+	}
+	if got.ETA != 6*time.Second {
+		t.Errorf("ETA = %s, want 6s", got.ETA)
+	}
+
+	BytesDone(sink, "", 0, 4, 0, 400, 0)
+	if got.Rate != 0 || got.ETA != 0 {
+		t.Errorf("expected no rate or ETA before any progress, got %+v", got)
+	}
+}
+
+func TestKindString(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		kind     Kind
+		expected string
+	}{
+		{KindInfo, "info"},
+		{KindDetail, "detail"},
+		{KindWarning, "warning"},
+		{KindError, "error"},
+		{KindSuccess, "success"},
+		{KindPhase, "phase"},
+		{KindFileStarted, "file_started"},
+		{KindFileDone, "file_done"},
+		{KindBytes, "bytes"},
+		{Kind(99), "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := tt.kind.String(); got != tt.expected {
+				t.Errorf("Kind(%d).String() = %q, want %q", tt.kind, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// Faulty wraps an FS to inject errors, for tests. Each operation first asks
+// Err, with the name of the method, such as "OpenFile", "Read", or "Write",
+// and the path it is called with; the error returned, if any, fails it
+// instead.
+type Faulty struct {
+	FS
+	// Err returns the error to fail op on name with, or nil to let it
+	// through. A nil Err lets everything through.
+	Err func(op, name string) error
+	// ReadLimit, if positive, is the most bytes a Read of an open file
+	// returns, to exercise readers that do not expect short reads.
+	ReadLimit int
+}
+
+// fail returns the error to fail op on name with, wrapped in an
+// *fs.PathError unless it is one.
+func (f *Faulty) fail(op, name string) error {
+	if f.Err == nil {
+		return nil
+	}
+	err := f.Err(op, name)
+	if err == nil {
+		return nil
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Open opens name for reading.
+func (f *Faulty) Open(name string) (File, error) {
+	if err := f.fail("Open", name); err != nil {
+		return nil, err
+	}
+	file, err := f.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, f: f, name: name}, nil
+}
+
+// OpenFile opens name with flag, creating it with perm if flag asks to.
+func (f *Faulty) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if err := f.fail("OpenFile", name); err != nil {
+		return nil, err
+	}
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, f: f, name: name}, nil
+}
+
+// Stat describes name, following symlinks.
+func (f *Faulty) Stat(name string) (fs.FileInfo, error) {
+	if err := f.fail("Stat", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Stat(name)
+}
+
+// Lstat describes name without following a symlink at its end.
+func (f *Faulty) Lstat(name string) (fs.FileInfo, error) {
+	if err := f.fail("Lstat", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Lstat(name)
+}
+
+// Readlink returns the target of the symlink name.
+func (f *Faulty) Readlink(name string) (string, error) {
+	if err := f.fail("Readlink", name); err != nil {
+		return "", err
+	}
+	return f.FS.Readlink(name)
+}
+
+// Symlink creates newname as a symlink to oldname.
+func (f *Faulty) Symlink(oldname, newname string) error {
+	if err := f.fail("Symlink", newname); err != nil {
+		return err
+	}
+	return f.FS.Symlink(oldname, newname)
+}
+
+// Link creates newname as a hard link to oldname. Err is asked with newname.
+func (f *Faulty) Link(oldname, newname string) error {
+	if err := f.fail("Link", newname); err != nil {
+		return err
+	}
+	return f.FS.Link(oldname, newname)
+}
+
+// Mkdir creates the directory name.
+func (f *Faulty) Mkdir(name string, perm fs.FileMode) error {
+	if err := f.fail("Mkdir", name); err != nil {
+		return err
+	}
+	return f.FS.Mkdir(name, perm)
+}
+
+// MkdirAll creates the directory name and its missing parents.
+func (f *Faulty) MkdirAll(name string, perm fs.FileMode) error {
+	if err := f.fail("MkdirAll", name); err != nil {
+		return err
+	}
+	return f.FS.MkdirAll(name, perm)
+}
+
+// Rename moves oldpath to newpath. Err is asked with oldpath.
+func (f *Faulty) Rename(oldpath, newpath string) error {
+	if err := f.fail("Rename", oldpath); err != nil {
+		return err
+	}
+	return f.FS.Rename(oldpath, newpath)
+}
+
+// Remove removes name.
+func (f *Faulty) Remove(name string) error {
+	if err := f.fail("Remove", name); err != nil {
+		return err
+	}
+	return f.FS.Remove(name)
+}
+
+// RemoveAll removes name and anything under it.
+func (f *Faulty) RemoveAll(name string) error {
+	if err := f.fail("RemoveAll", name); err != nil {
+		return err
+	}
+	return f.FS.RemoveAll(name)
+}
+
+// Chtimes sets the access and modification times of name.
+func (f *Faulty) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.fail("Chtimes", name); err != nil {
+		return err
+	}
+	return f.FS.Chtimes(name, atime, mtime)
+}
+
+// Lchown sets the owner of name without following a symlink at its end.
+func (f *Faulty) Lchown(name string, uid, gid int) error {
+	if err := f.fail("Lchown", name); err != nil {
+		return err
+	}
+	return f.FS.Lchown(name, uid, gid)
+}
+
+// Xattrs returns the extended attributes of name.
+func (f *Faulty) Xattrs(name string) (map[string]string, error) {
+	if err := f.fail("Xattrs", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Xattrs(name)
+}
+
+// Setxattr sets the extended attribute attr of name to value.
+func (f *Faulty) Setxattr(name, attr, value string) error {
+	if err := f.fail("Setxattr", name); err != nil {
+		return err
+	}
+	return f.FS.Setxattr(name, attr, value)
+}
+
+// faultyFile is a file opened through a Faulty.
+type faultyFile struct {
+	File
+	f    *Faulty
+	name string
+}
+
+func (ff *faultyFile) Read(b []byte) (int, error) {
+	if err := ff.f.fail("Read", ff.name); err != nil {
+		return 0, err
+	}
+	if ff.f.ReadLimit > 0 && len(b) > ff.f.ReadLimit {
+		b = b[:ff.f.ReadLimit]
+	}
+	return ff.File.Read(b)
+}
+
+func (ff *faultyFile) Write(b []byte) (int, error) {
+	if err := ff.f.fail("Write", ff.name); err != nil {
+		return 0, err
+	}
+	return ff.File.Write(b)
+}
+
+func (ff *faultyFile) Chmod(mode fs.FileMode) error {
+	if err := ff.f.fail("Chmod", ff.name); err != nil {
+		return err
+	}
+	return ff.File.Chmod(mode)
+}
+
+func (ff *faultyFile) Sync() error {
+	if err := ff.f.fail("Sync", ff.name); err != nil {
+		return err
+	}
+	return ff.File.Sync()
+}
@@ -0,0 +1,256 @@
+package fsys
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMem(t *testing.T) {
+	t.Parallel()
+
+	t.Run("writes and reads files", func(t *testing.T) {
+		t.Parallel()
+		m := NewMem()
+		if err := m.MkdirAll("/home/u/.config", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(m, "/home/u/.config/app.toml", []byte("a = 1"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ReadFile(m, "/home/u/.config/app.toml")
+		if err != nil || string(data) != "a = 1" {
+			t.Fatalf("ReadFile() = %q, %v", data, err)
+		}
+		info, err := m.Stat("/home/u/.config/app.toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 5 || info.Mode() != 0600 || info.Name() != "app.toml" {
+			t.Errorf("Stat() = size %d mode %v name %s", info.Size(), info.Mode(), info.Name())
+		}
+
+		f, err := m.OpenFile("/home/u/.config/app.toml", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte("\nb = 2")); err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ = ReadFile(m, "/home/u/.config/app.toml"); string(data) != "a = 1\nb = 2" {
+			t.Errorf("after append = %q", data)
+		}
+	})
+
+	t.Run("fails as the OS does", func(t *testing.T) {
+		t.Parallel()
+		m := NewMem()
+		if err := WriteFile(m, "/file", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			name string
+			err  error
+			want error
+		}{
+			{"open missing", func() error { _, err := m.Open("/missing"); return err }(), fs.ErrNotExist},
+			{"create without parent", WriteFile(m, "/no/file", nil, 0644), fs.ErrNotExist},
+			{"mkdir existing", m.Mkdir("/file", 0755), fs.ErrExist},
+			{"mkdir under a file", m.MkdirAll("/file/dir", 0755), syscall.ENOTDIR},
+			{"readlink of a file", func() error { _, err := m.Readlink("/file"); return err }(), syscall.EINVAL},
+		}
+		for _, tt := range tests {
+			var pathErr *fs.PathError
+			if !errors.Is(tt.err, tt.want) || !errors.As(tt.err, &pathErr) {
+				t.Errorf("%s: err = %v, want *fs.PathError for %v", tt.name, tt.err, tt.want)
+			}
+		}
+
+		if err := m.MkdirAll("/dir/sub", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Remove("/dir"); !errors.Is(err, syscall.ENOTEMPTY) {
+			t.Errorf("Remove(non-empty dir) = %v, want ENOTEMPTY", err)
+		}
+	})
+
+	t.Run("follows symlinks", func(t *testing.T) {
+		t.Parallel()
+		m := NewMem()
+		if err := m.MkdirAll("/dotfiles/nvim", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(m, "/dotfiles/nvim/init.lua", []byte("vim"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.MkdirAll("/home/.config", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Symlink("../../dotfiles/nvim", "/home/.config/nvim"); err != nil {
+			t.Fatal(err)
+		}
+
+		if data, err := ReadFile(m, "/home/.config/nvim/init.lua"); err != nil || string(data) != "vim" {
+			t.Errorf("read through symlink = %q, %v", data, err)
+		}
+		info, err := m.Lstat("/home/.config/nvim")
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			t.Errorf("Lstat(symlink) = %v, %v", info, err)
+		}
+		if target, _ := m.Readlink("/home/.config/nvim"); target != "../../dotfiles/nvim" {
+			t.Errorf("Readlink() = %q", target)
+		}
+
+		if err = m.Symlink("/loop", "/loop"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = m.Stat("/loop"); !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("Stat(symlink loop) = %v, want ELOOP", err)
+		}
+	})
+
+	t.Run("renames and removes trees", func(t *testing.T) {
+		t.Parallel()
+		m := NewMem()
+		if err := m.MkdirAll("/a/b", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(m, "/a/b/c", []byte("c"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Rename("/a", "/z"); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := ReadFile(m, "/z/b/c"); err != nil || string(data) != "c" {
+			t.Errorf("after Rename = %q, %v", data, err)
+		}
+		if _, err := m.Stat("/a/b/c"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("old path still there: %v", err)
+		}
+		if err := m.RemoveAll("/z"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Stat("/z/b"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("RemoveAll left %v", err)
+		}
+		if err := m.RemoveAll("/z"); err != nil {
+			t.Errorf("RemoveAll(missing) = %v", err)
+		}
+	})
+
+	t.Run("links files and keeps their attributes", func(t *testing.T) {
+		t.Parallel()
+		m := NewMem()
+		if err := WriteFile(m, "/id_ed25519", []byte("key"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Link("/id_ed25519", "/id_copy"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Setxattr("/id_copy", "user.origin", "laptop"); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		if err := m.Chtimes("/id_copy", mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		attrs, err := m.Xattrs("/id_ed25519")
+		if err != nil || attrs["user.origin"] != "laptop" {
+			t.Errorf("Xattrs(other name) = %v, %v", attrs, err)
+		}
+		if info, _ := m.Stat("/id_ed25519"); !info.ModTime().Equal(mtime) {
+			t.Errorf("ModTime(other name) = %v, want %v", info.ModTime(), mtime)
+		}
+		if err = m.Remove("/id_ed25519"); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ReadFile(m, "/id_copy"); string(data) != "key" {
+			t.Errorf("link after removing the original = %q", data)
+		}
+		if err = m.MkdirAll("/dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Link("/dir", "/dir2"); !errors.Is(err, syscall.EPERM) {
+			t.Errorf("Link(dir) = %v, want EPERM", err)
+		}
+	})
+
+	t.Run("creates temporary directories", func(t *testing.T) {
+		t.Parallel()
+		m := NewMem()
+		dir, err := MkdirTemp(m, "/", ".stage-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info, statErr := m.Stat(dir); statErr != nil || !info.IsDir() || filepath.Dir(dir) != "/" {
+			t.Errorf("MkdirTemp() = %s, stat %v", dir, statErr)
+		}
+	})
+}
+
+func TestFaulty(t *testing.T) {
+	t.Parallel()
+
+	m := NewMem()
+	if err := WriteFile(m, "/secret", []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("injects errors", func(t *testing.T) {
+		t.Parallel()
+		f := &Faulty{FS: m, Err: func(op, name string) error {
+			if op == "OpenFile" && name == "/secret" {
+				return fs.ErrPermission
+			}
+			return nil
+		}}
+		_, err := f.OpenFile("/secret", os.O_WRONLY, 0)
+		var pathErr *fs.PathError
+		if !errors.Is(err, fs.ErrPermission) || !errors.As(err, &pathErr) || pathErr.Path != "/secret" {
+			t.Errorf("OpenFile() = %v, want permission denied on /secret", err)
+		}
+		if _, err = f.Stat("/secret"); err != nil {
+			t.Errorf("Stat() = %v, want it let through", err)
+		}
+	})
+
+	t.Run("cuts reads short", func(t *testing.T) {
+		t.Parallel()
+		f := &Faulty{FS: m, ReadLimit: 3}
+		file, err := f.Open("/secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		buf := make([]byte, 8)
+		if n, _ := file.Read(buf); n != 3 {
+			t.Errorf("Read() = %d bytes, want 3", n)
+		}
+		rest, err := io.ReadAll(file)
+		if err != nil || string(rest) != "3456789" {
+			t.Errorf("ReadAll() = %q, %v", rest, err)
+		}
+	})
+
+	t.Run("fails reads of open files", func(t *testing.T) {
+		t.Parallel()
+		f := &Faulty{FS: m, Err: func(op, _ string) error {
+			if op == "Read" {
+				return syscall.EIO
+			}
+			return nil
+		}}
+		if _, err := ReadFile(f, "/secret"); !errors.Is(err, syscall.EIO) {
+			t.Errorf("ReadFile() = %v, want EIO", err)
+		}
+	})
+}
@@ -0,0 +1,520 @@
+package fsys
+
+import (
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxSymlinkHops is the number of symlinks followed to resolve a path before
+// failing with ELOOP, as Linux does.
+const maxSymlinkHops = 40
+
+// Mem is a filesystem held in memory, for tests. Relative paths are taken
+// from the root, permissions are recorded but not enforced, and ownership is
+// ignored; wrap it in Faulty to fail operations. It is safe for concurrent
+// use.
+type Mem struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	mode    fs.FileMode
+	data    []byte
+	link    string
+	modTime time.Time
+	xattrs  map[string]string
+}
+
+// NewMem returns an empty Mem, holding only the root directory.
+func NewMem() *Mem {
+	return &Mem{nodes: make(map[string]*memNode)}
+}
+
+func memClean(name string) string {
+	if !filepath.IsAbs(name) {
+		name = string(filepath.Separator) + name
+	}
+	return filepath.Clean(name)
+}
+
+// node returns the node at the resolved path p. The root always exists.
+func (m *Mem) node(p string) *memNode {
+	if filepath.Dir(p) == p {
+		return &memNode{mode: fs.ModeDir | 0755}
+	}
+	return m.nodes[p]
+}
+
+// resolve returns the path name refers to, following the symlinks among its
+// directories, and the last element too if followLast is set.
+func (m *Mem) resolve(name string, followLast bool) (string, error) {
+	p := memClean(name)
+	for hops := 0; hops <= maxSymlinkHops; hops++ {
+		target, ok := m.firstLink(p, followLast)
+		if !ok {
+			return p, nil
+		}
+		p = target
+	}
+	return "", syscall.ELOOP
+}
+
+// firstLink replaces the first symlink of p to follow by its target and
+// returns the path, or reports false if there is none.
+func (m *Mem) firstLink(p string, followLast bool) (string, bool) {
+	vol := filepath.VolumeName(p)
+	sep := string(filepath.Separator)
+	parts := strings.Split(strings.TrimPrefix(p[len(vol):], sep), sep)
+	cur := vol + sep
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		cur = filepath.Join(cur, part)
+		n := m.nodes[cur]
+		if n == nil {
+			return "", false
+		}
+		last := i == len(parts)-1
+		if n.mode&fs.ModeSymlink == 0 || (last && !followLast) {
+			continue
+		}
+		target := n.link
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(cur), target)
+		}
+		return memClean(filepath.Join(append([]string{target}, parts[i+1:]...)...)), true
+	}
+	return "", false
+}
+
+// lookup resolves name and returns its path and node, or an *fs.PathError
+// for op if it does not exist.
+func (m *Mem) lookup(op, name string, followLast bool) (string, *memNode, error) {
+	p, err := m.resolve(name, followLast)
+	if err != nil {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	n := m.node(p)
+	if n == nil {
+		return p, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return p, n, nil
+}
+
+// checkParent returns an *fs.PathError for op unless the parent of the
+// resolved path p is a directory.
+func (m *Mem) checkParent(op, name, p string) error {
+	parent := m.node(filepath.Dir(p))
+	switch {
+	case parent == nil:
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	case !parent.mode.IsDir():
+		return &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// hasChildren reports whether the directory at p holds anything.
+func (m *Mem) hasChildren(p string) bool {
+	prefix := p + string(filepath.Separator)
+	for path := range m.nodes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens name for reading.
+func (m *Mem) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens name with flag, creating it with perm if flag asks to.
+func (m *Mem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, n, err := m.lookup("open", name, true)
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case n != nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case n != nil && n.mode.IsDir() && writing:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case n != nil:
+		if flag&os.O_TRUNC != 0 && writing {
+			n.data, n.modTime = nil, time.Now()
+		}
+	case flag&os.O_CREATE == 0 || p == "":
+		return nil, err
+	default:
+		if err = m.checkParent("open", name, p); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: perm.Perm(), modTime: time.Now()}
+		m.nodes[p] = n
+	}
+	return &memFile{m: m, name: name, node: n, flag: flag}, nil
+}
+
+// Stat describes name, following symlinks.
+func (m *Mem) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(name), nil
+}
+
+// Lstat describes name without following a symlink at its end.
+func (m *Mem) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(name), nil
+}
+
+// Readlink returns the target of the symlink name.
+func (m *Mem) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return n.link, nil
+}
+
+// Symlink creates newname as a symlink to oldname.
+func (m *Mem) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.create("symlink", newname, &memNode{mode: fs.ModeSymlink | 0777, link: oldname, modTime: time.Now()})
+}
+
+// Link creates newname as a hard link to the file oldname: both names then
+// share the content, mode, and attributes.
+func (m *Mem) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("link", oldname, false)
+	if err != nil {
+		return err
+	}
+	if n.mode.IsDir() {
+		return &fs.PathError{Op: "link", Path: oldname, Err: syscall.EPERM}
+	}
+	return m.create("link", newname, n)
+}
+
+// Mkdir creates the directory name, whose parent must exist.
+func (m *Mem) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.create("mkdir", name, &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()})
+}
+
+// create adds n at name, which must not exist, in an existing directory.
+func (m *Mem) create(op, name string, n *memNode) error {
+	p, existing, err := m.lookup(op, name, false)
+	switch {
+	case existing != nil:
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	case p == "":
+		return err
+	}
+	if err = m.checkParent(op, name, p); err != nil {
+		return err
+	}
+	m.nodes[p] = n
+	return nil
+}
+
+// MkdirAll creates the directory name and its missing parents.
+func (m *Mem) MkdirAll(name string, perm fs.FileMode) error {
+	if info, err := m.Stat(name); err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	clean := memClean(name)
+	if parent := filepath.Dir(clean); parent != clean {
+		if err := m.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := m.Mkdir(name, perm); err != nil {
+		if info, statErr := m.Lstat(name); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Rename moves oldpath, and anything under it, to newpath.
+func (m *Mem) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from, n, err := m.lookup("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	to, existing, err := m.lookup("rename", newpath, false)
+	if to == "" {
+		return err
+	}
+	if err = m.checkParent("rename", newpath, to); err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	if strings.HasPrefix(to, from+string(filepath.Separator)) {
+		return &fs.PathError{Op: "rename", Path: newpath, Err: syscall.EINVAL}
+	}
+	if existing != nil && existing.mode.IsDir() {
+		if !n.mode.IsDir() {
+			return &fs.PathError{Op: "rename", Path: newpath, Err: syscall.EISDIR}
+		}
+		if m.hasChildren(to) {
+			return &fs.PathError{Op: "rename", Path: newpath, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	prefix := from + string(filepath.Separator)
+	for path, child := range m.nodes {
+		if strings.HasPrefix(path, prefix) {
+			delete(m.nodes, path)
+			m.nodes[to+path[len(from):]] = child
+		}
+	}
+	delete(m.nodes, from)
+	m.nodes[to] = n
+	return nil
+}
+
+// Remove removes the file, symlink, or empty directory name.
+func (m *Mem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, n, err := m.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if n.mode.IsDir() && m.hasChildren(p) {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(m.nodes, p)
+	return nil
+}
+
+// RemoveAll removes name and anything under it.
+func (m *Mem) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, n, err := m.lookup("removeall", name, false)
+	if n == nil {
+		if p == "" {
+			return err
+		}
+		return nil
+	}
+	prefix := p + string(filepath.Separator)
+	for path := range m.nodes {
+		if strings.HasPrefix(path, prefix) {
+			delete(m.nodes, path)
+		}
+	}
+	delete(m.nodes, p)
+	return nil
+}
+
+// Chtimes sets the modification time of name; the access time is not
+// recorded.
+func (m *Mem) Chtimes(name string, _, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// Lchown checks that name exists; Mem does not record ownership.
+func (m *Mem) Lchown(name string, _, _ int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _, err := m.lookup("lchown", name, false)
+	return err
+}
+
+// Xattrs returns the extended attributes of name, following symlinks.
+func (m *Mem) Xattrs(name string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("listxattr", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return maps.Clone(n.xattrs), nil
+}
+
+// Setxattr sets the extended attribute attr of name to value, following
+// symlinks.
+func (m *Mem) Setxattr(name, attr, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("setxattr", name, true)
+	if err != nil {
+		return err
+	}
+	if n.xattrs == nil {
+		n.xattrs = make(map[string]string)
+	}
+	n.xattrs[attr] = value
+	return nil
+}
+
+// Paths returns the paths of the files, directories, and symlinks of m,
+// sorted, for tests to check what was written where.
+func (m *Mem) Paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Sorted(maps.Keys(m.nodes))
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return memInfo{name: filepath.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memFile is an open file of a Mem.
+type memFile struct {
+	m      *Mem
+	name   string
+	node   *memNode
+	flag   int
+	off    int64
+	closed bool
+}
+
+// check returns an *fs.PathError for op if f is closed or a directory.
+func (f *memFile) check(op string) error {
+	switch {
+	case f.closed:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	case f.node.mode.IsDir() && (op == "read" || op == "write"):
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	}
+	return nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	if err := f.check("write"); err != nil {
+		return 0, err
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.off = int64(len(f.node.data))
+	}
+	if end := f.off + int64(len(b)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.off:], b)
+	f.off += int64(len(b))
+	f.node.modTime = time.Now()
+	return len(b), nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	if err := f.check("stat"); err != nil {
+		return nil, err
+	}
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Chmod(mode fs.FileMode) error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	if err := f.check("chmod"); err != nil {
+		return err
+	}
+	f.node.mode = f.node.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	return f.check("sync")
+}
+
+func (f *memFile) Close() error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	if err := f.check("close"); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
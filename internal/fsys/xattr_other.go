@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+)
+
+func xattrs(name string) (map[string]string, error) {
+	return nil, &fs.PathError{Op: "listxattr", Path: name, Err: errors.ErrUnsupported}
+}
+
+func setxattr(name, _, _ string) error {
+	return &fs.PathError{Op: "setxattr", Path: name, Err: errors.ErrUnsupported}
+}
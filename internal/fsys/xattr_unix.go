@@ -0,0 +1,50 @@
+//go:build linux || darwin
+
+package fsys
+
+import (
+	"io/fs"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrs reads the extended attributes of the file name.
+func xattrs(name string) (map[string]string, error) {
+	size, err := unix.Listxattr(name, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "listxattr", Path: name, Err: err}
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	list := make([]byte, size)
+	if size, err = unix.Listxattr(name, list); err != nil {
+		return nil, &fs.PathError{Op: "listxattr", Path: name, Err: err}
+	}
+
+	attrs := make(map[string]string)
+	for attr := range strings.SplitSeq(string(list[:size]), "\x00") {
+		if attr == "" {
+			continue
+		}
+		n, getErr := unix.Getxattr(name, attr, nil)
+		if getErr != nil {
+			return nil, &fs.PathError{Op: "getxattr", Path: name, Err: getErr}
+		}
+		value := make([]byte, n)
+		if n, getErr = unix.Getxattr(name, attr, value); getErr != nil {
+			return nil, &fs.PathError{Op: "getxattr", Path: name, Err: getErr}
+		}
+		attrs[attr] = string(value[:n])
+	}
+	return attrs, nil
+}
+
+// setxattr sets the extended attribute attr of the file name to value.
+func setxattr(name, attr, value string) error {
+	if err := unix.Setxattr(name, attr, []byte(value), 0); err != nil {
+		return &fs.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	return nil
+}
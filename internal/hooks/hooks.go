@@ -0,0 +1,119 @@
+// Package hooks runs the user's [hooks] commands before and after backups
+// and restores.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Hook events, named as their [hooks] keys.
+const (
+	PreBackup   = "pre_backup"
+	PostBackup  = "post_backup"
+	PreRestore  = "pre_restore"
+	PostRestore = "post_restore"
+)
+
+// Failure policies of [hooks] on_failure.
+const (
+	OnFailureAbort = "abort"
+	OnFailureWarn  = "warn"
+)
+
+// Timeout bounds each hook command.
+const Timeout = 10 * time.Minute
+
+// Commands returns the commands configured for event.
+func Commands(cfg config.HooksConfig, event string) []string {
+	switch event {
+	case PreBackup:
+		return cfg.PreBackup
+	case PostBackup:
+		return cfg.PostBackup
+	case PreRestore:
+		return cfg.PreRestore
+	case PostRestore:
+		return cfg.PostRestore
+	}
+	return nil
+}
+
+// ResultEnv returns the environment telling a post hook the outcome of the
+// operation on archive: DOTPAK_RESULT is "success" or "failure", and
+// DOTPAK_ERROR holds the error message of a failure.
+func ResultEnv(archive string, success bool, errMsg string) []string {
+	outcome := "success"
+	if !success {
+		outcome = "failure"
+	}
+	return []string{"DOTPAK_ARCHIVE=" + archive, "DOTPAK_RESULT=" + outcome, "DOTPAK_ERROR=" + errMsg}
+}
+
+// Run runs the commands configured for event in order with the shell in
+// dir, with env and DOTPAK_HOOK set to event. It stops at the first command
+// that fails; with on_failure = "abort" the failure is returned, with
+// "warn" it is only reported to sink.
+func Run(cfg config.HooksConfig, event, dir string, env []string, sink events.Sink) ([]metadata.HookResult, error) {
+	commands := Commands(cfg, event)
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	events.Info(sink, "Running %s hooks...\n", event)
+	env = append(append(os.Environ(), env...), "DOTPAK_HOOK="+event)
+	results := make([]metadata.HookResult, 0, len(commands))
+	for _, command := range commands {
+		result := metadata.HookResult{Event: event, Command: command}
+		events.Detail(sink, "  %s\n", command)
+		err := run(command, dir, env, sink)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+		if err == nil {
+			continue
+		}
+		if cfg.OnFailure == OnFailureWarn {
+			events.Warning(sink, "%s hook %q failed: %v\n", event, command, err)
+			return results, nil
+		}
+		return results, fmt.Errorf("%s hook %q failed: %w", event, command, err)
+	}
+	return results, nil
+}
+
+func run(command, dir string, env []string, sink events.Sink) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	cmd := Command(ctx, command)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	output, err := cmd.CombinedOutput()
+	if text := strings.TrimSpace(string(output)); text != "" {
+		events.Detail(sink, "%s\n", text)
+	}
+	return err
+}
+
+// Command returns a command running command with the shell: /bin/sh, or
+// cmd on Windows.
+func Command(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		//nolint:gosec // g204: the command comes from the user's own config file
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	//nolint:gosec // g204: the command comes from the user's own config file
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands are written for /bin/sh")
+	}
+
+	t.Run("runs commands with environment", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		cfg := config.HooksConfig{PostBackup: []string{
+			`echo "$DOTPAK_HOOK $DOTPAK_ARCHIVE $DOTPAK_RESULT" > out.txt`,
+			"echo second >> out.txt",
+		}}
+
+		env := ResultEnv("/backups/a.tar.gz", true, "")
+		results, err := Run(cfg, PostBackup, dir, env, events.Discard)
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if len(results) != 2 || results[0].Event != PostBackup || results[0].Error != "" {
+			t.Errorf("Run() results = %+v", results)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "post_backup /backups/a.tar.gz success\nsecond\n"; string(data) != want {
+			t.Errorf("hook output = %q, want %q", data, want)
+		}
+	})
+
+	t.Run("abort stops at failing command", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		cfg := config.HooksConfig{PreRestore: []string{"exit 3", "touch ran"}}
+
+		results, err := Run(cfg, PreRestore, dir, nil, events.Discard)
+		if err == nil || !strings.Contains(err.Error(), "pre_restore hook") {
+			t.Errorf("Run() error = %v, want pre_restore hook failure", err)
+		}
+		if len(results) != 1 || results[0].Error == "" {
+			t.Errorf("Run() results = %+v, want one failed command", results)
+		}
+		if _, statErr := os.Stat(filepath.Join(dir, "ran")); statErr == nil {
+			t.Error("command after the failing one ran")
+		}
+	})
+
+	t.Run("warn reports failure", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := config.HooksConfig{PreBackup: []string{"false"}, OnFailure: OnFailureWarn}
+		var kinds []events.Kind
+		sink := events.SinkFunc(func(e events.Event) { kinds = append(kinds, e.Kind) })
+
+		results, err := Run(cfg, PreBackup, t.TempDir(), nil, sink)
+		if err != nil {
+			t.Errorf("Run() error = %v, want nil with on_failure = warn", err)
+		}
+		if len(results) != 1 || results[0].Error == "" {
+			t.Errorf("Run() results = %+v, want one failed command", results)
+		}
+		if !slices.Contains(kinds, events.KindWarning) {
+			t.Error("Run() reported no warning")
+		}
+	})
+
+	t.Run("no commands", func(t *testing.T) {
+		t.Parallel()
+
+		results, err := Run(config.HooksConfig{PreBackup: []string{"false"}}, PostBackup, t.TempDir(), nil, events.Discard)
+		if err != nil || results != nil {
+			t.Errorf("Run() = %+v, %v, want nil, nil", results, err)
+		}
+	})
+}
+
+func TestResultEnv(t *testing.T) {
+	t.Parallel()
+
+	got := ResultEnv("a.zip", false, "disk full")
+	want := []string{"DOTPAK_ARCHIVE=a.zip", "DOTPAK_RESULT=failure", "DOTPAK_ERROR=disk full"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ResultEnv() = %q, want %q", got, want)
+	}
+}
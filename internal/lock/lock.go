@@ -0,0 +1,133 @@
+// Package lock guards a backup directory against overlapping dotpak runs, so
+// that a scheduled backup cannot prune or write archives while a manual
+// backup, restore, or prune uses the same directory.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+)
+
+// FileName is the name of the lock file in the backup directory.
+const FileName = ".dotpak.lock"
+
+// pollInterval is how often a waiting Acquire tries the lock again.
+const pollInterval = 250 * time.Millisecond
+
+// errUnsupported reports a file system without file locks, such as some
+// network mounts.
+var errUnsupported = errors.New("file locks are not supported")
+
+// Lock is a held lock on a backup directory.
+type Lock struct {
+	file *os.File
+}
+
+// holder describes the run holding a lock; it is written into the lock
+// file so that the runs it keeps out can name it.
+type holder struct {
+	PID       int       `json:"pid"`
+	Operation string    `json:"operation"`
+	Started   time.Time `json:"started"`
+}
+
+func (h holder) String() string {
+	return fmt.Sprintf("%s, pid %d, started %s", h.Operation, h.PID, h.Started.Format(time.DateTime))
+}
+
+// Acquire locks the directory dir for the operation op, "backup", "restore",
+// or "prune". If another run holds the lock, Acquire fails with an
+// errs.ErrLocked error naming it or, with wait, tries again until the lock
+// is free or ctx is done. The lock is released by Release or when the
+// process exits.
+//
+// A directory that does not exist needs no lock: Acquire then returns a nil
+// Lock, as it does, with a warning, on file systems without file locks.
+func Acquire(ctx context.Context, dir, op string, wait bool, sink events.Sink) (*Lock, error) {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	path := filepath.Join(dir, FileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+
+	waiting := false
+	for {
+		locked, lockErr := tryLock(f)
+		switch {
+		case errors.Is(lockErr, errUnsupported):
+			f.Close()
+			events.Warning(sink, "Cannot lock %s (%v); not guarding against overlapping runs\n", dir, lockErr)
+			return nil, nil
+		case lockErr != nil:
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", path, lockErr)
+		case locked:
+			l := &Lock{file: f}
+			l.record(op)
+			return l, nil
+		}
+
+		if !wait {
+			held := readHolder(f)
+			f.Close()
+			return nil, errs.Errorf(errs.ErrLocked,
+				"%s is in use by %s; try again when it finishes, or pass --wait", dir, held)
+		}
+		if !waiting {
+			events.Info(sink, "Waiting for %s to finish with %s...\n", readHolder(f), dir)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, errs.Wrap(errs.ErrCanceled, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Release releases the lock. It does nothing on a nil Lock.
+func (l *Lock) Release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	_ = l.file.Truncate(0)
+	_ = unlock(l.file)
+	l.file.Close()
+	l.file = nil
+}
+
+// record writes the holder of the lock into the lock file. It is only
+// informational, so failures are ignored.
+func (l *Lock) record(op string) {
+	data, err := json.Marshal(holder{PID: os.Getpid(), Operation: op, Started: time.Now()})
+	if err != nil {
+		return
+	}
+	if err = l.file.Truncate(0); err == nil {
+		_, _ = l.file.WriteAt(append(data, '\n'), 0)
+	}
+}
+
+// readHolder describes the run holding the lock of f, e.g. "another dotpak
+// run (backup, pid 4242, started 2024-01-15 14:30:22)".
+func readHolder(f *os.File) string {
+	buf := make([]byte, 512)
+	n, _ := f.ReadAt(buf, 0)
+	var h holder
+	if n == 0 || json.Unmarshal(buf[:n], &h) != nil || h.PID == 0 {
+		return "another dotpak run"
+	}
+	return "another dotpak run (" + h.String() + ")"
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package lock
+
+import "os"
+
+// tryLock reports that file locks are not supported.
+func tryLock(*os.File) (bool, error) {
+	return false, errUnsupported
+}
+
+func unlock(*os.File) error {
+	return nil
+}
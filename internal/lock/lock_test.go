@@ -0,0 +1,88 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+)
+
+func TestAcquire(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fails while another run holds the lock", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		held, err := Acquire(context.Background(), dir, "backup", false, events.Discard)
+		if err != nil || held == nil {
+			t.Fatalf("Acquire() = %v, %v", held, err)
+		}
+
+		_, err = Acquire(context.Background(), dir, "prune", false, events.Discard)
+		if !errors.Is(err, errs.ErrLocked) {
+			t.Fatalf("second Acquire() error = %v, want ErrLocked", err)
+		}
+		if !strings.Contains(err.Error(), "backup, pid") || !strings.Contains(err.Error(), "--wait") {
+			t.Errorf("second Acquire() error = %q, want the holder and a --wait hint", err)
+		}
+
+		held.Release()
+		again, err := Acquire(context.Background(), dir, "prune", false, events.Discard)
+		if err != nil {
+			t.Fatalf("Acquire() after Release() error = %v", err)
+		}
+		again.Release()
+	})
+
+	t.Run("waits for the lock", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		held, err := Acquire(context.Background(), dir, "restore", false, events.Discard)
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		time.AfterFunc(2*pollInterval, held.Release)
+
+		l, err := Acquire(context.Background(), dir, "backup", true, events.Discard)
+		if err != nil {
+			t.Fatalf("waiting Acquire() error = %v", err)
+		}
+		l.Release()
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		held, err := Acquire(context.Background(), dir, "backup", false, events.Discard)
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		defer held.Release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*pollInterval)
+		defer cancel()
+		_, err = Acquire(ctx, dir, "backup", true, events.Discard)
+		if !errors.Is(err, errs.ErrCanceled) {
+			t.Errorf("Acquire() error = %v, want ErrCanceled", err)
+		}
+	})
+
+	t.Run("missing directory needs no lock", func(t *testing.T) {
+		t.Parallel()
+
+		l, err := Acquire(context.Background(), filepath.Join(t.TempDir(), "missing"), "restore", false,
+			events.Discard)
+		if l != nil || err != nil {
+			t.Errorf("Acquire() = %v, %v; want nil, nil", l, err)
+		}
+		l.Release()
+	})
+}
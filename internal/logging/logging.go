@@ -0,0 +1,143 @@
+// Package logging writes a log of each dotpak run to a file, so that
+// scheduled runs leave a persistent, timestamped record without redirecting
+// their output.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Defaults for Options.
+const (
+	DefaultMaxSize  = 10 << 20
+	DefaultMaxFiles = 3
+)
+
+// Options configures a log file.
+type Options struct {
+	// Level is the least level written.
+	Level slog.Level
+	// MaxSize is the size past which the file is rotated, DefaultMaxSize
+	// if zero.
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept, DefaultMaxFiles if
+	// zero.
+	MaxFiles int
+}
+
+// ParseLevel parses a level name: debug, info, warn, or error. Empty is
+// info.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn, or error)", name)
+}
+
+// Open returns a logger appending to the file at path, in slog's text
+// format, and the file, to be closed at the end of the run. The directory
+// of the file is created if needed.
+func Open(path string, opts Options) (*slog.Logger, io.Closer, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = DefaultMaxFiles
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, nil, fmt.Errorf("creating log directory: %w", err)
+	}
+	f := &RotatingFile{path: path, maxSize: opts.MaxSize, maxFiles: opts.MaxFiles}
+	if err := f.open(); err != nil {
+		return nil, nil, err
+	}
+	return slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{Level: opts.Level})), f, nil
+}
+
+// RotatingFile is a log file that is renamed to path.1, and path.1 to
+// path.2 and so on, once it grows past its maximum size. Several dotpak
+// runs may append to it at once; each write is one log record.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Write appends p, rotating the file first if p would take it past its
+// maximum size.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts the rotated files, dropping the oldest, and starts a new
+// file. Another run may have rotated the file already, in which case the
+// current one is small and is kept.
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	if info, err := os.Stat(f.path); err == nil && info.Size() >= f.size {
+		_ = os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+		for i := f.maxFiles - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err = os.Rename(f.path, f.path+".1"); err != nil {
+			return fmt.Errorf("rotating log file: %w", err)
+		}
+	}
+	return f.open()
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+
+	for name, want := range map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+	} {
+		if got, err := ParseLevel(name); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Error("ParseLevel(trace) succeeded")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "logs", "dotpak.log")
+	logger, closer, err := Open(path, Options{Level: slog.LevelInfo, MaxSize: 200, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	logger.Debug("left out")
+	for i := range 6 {
+		logger.Info("backup complete", "run", i)
+	}
+	if err = closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var all string
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		data, readErr := os.ReadFile(name)
+		if readErr != nil {
+			t.Fatalf("reading %s: %v", name, readErr)
+		}
+		if len(data) > 200 {
+			t.Errorf("%s has %d bytes, want at most 200", name, len(data))
+		}
+		all += string(data)
+	}
+	if _, err = os.Stat(path + ".3"); err == nil {
+		t.Error("more rotated files kept than MaxFiles")
+	}
+	if strings.Contains(all, "left out") {
+		t.Error("debug message logged at level info")
+	}
+	if !strings.Contains(all, "run=5") || !strings.HasSuffix(strings.TrimSpace(all), "run=5") {
+		t.Errorf("last record not at the end of the log:\n%s", all)
+	}
+
+	// a new run appends to the current file
+	logger, closer, err = Open(path, Options{MaxSize: 200, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("restore complete")
+	closer.Close()
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "restore complete") {
+		t.Errorf("log after reopening = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// DescribeChain describes the chain of archivePath, as Chain finds it, and
+// which files a restore takes from each link, from the catalogs in the
+// metadata files: an incremental archive holds the files of its catalog
+// whose hash differs from its parent's, a full one its whole catalog, and
+// a restore takes each file from the newest link holding it. Nothing is
+// decrypted. A missing parent is described as a link of its own, and the
+// result records the error.
+func DescribeChain(archivePath string) (*ChainResult, error) {
+	result := &ChainResult{Archive: archivePath, Links: []ChainLink{}}
+	if _, err := os.Stat(archivePath); err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath))
+		return result, nil
+	}
+
+	var metas []*Metadata
+	for path := archivePath; ; {
+		link := NewBackupInfo(path, 0)
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			result.Links = append(result.Links, ChainLink{
+				Archive: path, Timestamp: link.Timestamp, Contributed: []string{}, Missing: true,
+			})
+			result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "incremental backup %s needs missing parent archive %s",
+				filepath.Base(result.Links[len(result.Links)-2].Archive), filepath.Base(path)))
+			return result, nil
+		}
+		meta, err := Load(GetMetadataPath(path))
+		if err != nil {
+			if len(metas) > 0 {
+				result.SetError(errs.Errorf(errs.ErrArchiveCorrupt, "reading metadata of %s: %w", filepath.Base(path), err))
+				return result, nil
+			}
+			// archives without metadata are full backups
+			meta = nil
+		}
+		result.Links = append(result.Links, ChainLink{
+			Archive:     path,
+			Timestamp:   link.Timestamp,
+			Full:        meta == nil || meta.Parent == "",
+			Encryption:  link.Encryption,
+			ArchiveSize: info.Size(),
+			Contributed: []string{},
+			NoCatalog:   meta == nil || len(meta.Files) == 0,
+		})
+		result.ArchiveSize += info.Size()
+		metas = append(metas, meta)
+		if meta == nil || meta.Parent == "" {
+			break
+		}
+
+		path = filepath.Join(filepath.Dir(archivePath), meta.Parent)
+		for _, l := range result.Links {
+			if l.Archive == path {
+				result.SetError(errs.Errorf(errs.ErrArchiveCorrupt, "incremental backup chain loops at %s", meta.Parent))
+				return result, nil
+			}
+		}
+	}
+
+	attributeFiles(result, metas)
+	result.Success = true
+	return result, nil
+}
+
+// attributeFiles records in the links of result the files each holds and
+// those a restore takes from it, given the metadata of each link.
+func attributeFiles(result *ChainResult, metas []*Metadata) {
+	if metas[0] == nil || len(metas[0].Files) == 0 {
+		return
+	}
+	// held[i] maps the files of link i to whether it holds them
+	held := make([]map[string]bool, len(metas))
+	for i, meta := range metas {
+		held[i] = make(map[string]bool)
+		if meta == nil {
+			continue
+		}
+		var parent map[string]string
+		if i+1 < len(metas) && metas[i+1] != nil {
+			parent = make(map[string]string, len(metas[i+1].Files))
+			for _, f := range metas[i+1].Files {
+				parent[f.Path] = f.SHA256
+			}
+		}
+		for _, f := range meta.Files {
+			if parent == nil || f.SHA256 == "" || parent[f.Path] != f.SHA256 {
+				held[i][f.Path] = true
+			}
+		}
+		result.Links[i].Files = len(held[i])
+	}
+
+	for _, f := range metas[0].Files {
+		result.Files++
+		result.Size += f.Size
+		for i := range metas {
+			if held[i][f.Path] {
+				result.Links[i].Contributed = append(result.Links[i].Contributed, f.Path)
+				result.Links[i].ContributedSize += f.Size
+				break
+			}
+		}
+	}
+}
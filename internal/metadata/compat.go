@@ -0,0 +1,183 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// SchemaVersion is the version of the metadata layout this release writes.
+// Metadata without a version field was written by earlier releases and is
+// read as version 1.
+const SchemaVersion = 2
+
+// Archive features. An archive lists the features its entries need in
+// Metadata.Features, and each entry that needs some names them, separated
+// by commas, in the PAX record FeaturePAXKey, so that a release that does
+// not know a feature refuses the archive, or with --force-partial restores
+// only the entries that do not need it.
+const (
+	// FeatureSealed marks sensitive files encrypted on their own, as
+	// backup.sensitive_encryption writes them.
+	FeatureSealed = "sealed"
+	// FeatureHardlinks marks hard links to an earlier entry, which
+	// backup.preserve writes in place of a second copy of the content.
+	FeatureHardlinks = "hardlinks"
+	FeaturePAXKey    = "SCHILY.xattr.user.dotpak.features"
+)
+
+// KnownFeatures lists the archive features this release restores.
+var KnownFeatures = []string{FeatureSealed, FeatureHardlinks}
+
+// UnknownFeatures returns the features that are not in KnownFeatures.
+func UnknownFeatures(features []string) []string {
+	var unknown []string
+	for _, feature := range features {
+		if feature = strings.TrimSpace(feature); feature != "" && !slices.Contains(KnownFeatures, feature) &&
+			!slices.Contains(unknown, feature) {
+			unknown = append(unknown, feature)
+		}
+	}
+	return unknown
+}
+
+// readers decode metadata of each schema version into the current layout.
+var readers = map[int]func(data []byte) (*Metadata, error){
+	1: readV1,
+	2: readV2,
+}
+
+// Parse decodes metadata of any schema version this release knows. The
+// returned Metadata keeps the version it was written with; Save writes it
+// in the current schema.
+func Parse(data []byte) (*Metadata, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	version := max(header.Version, 1)
+
+	read, ok := readers[version]
+	if !ok {
+		return nil, errs.Errorf(errs.ErrNewerFormat,
+			"metadata schema version %d is newer than this dotpak supports (%d); upgrade dotpak", version, SchemaVersion)
+	}
+	meta, err := read(data)
+	if err != nil {
+		return nil, err
+	}
+	meta.Version = version
+	return meta, nil
+}
+
+func readV2(data []byte) (*Metadata, error) {
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// readV1 reads metadata written before the schema was versioned. The first
+// releases named some fields differently; those names are accepted when the
+// current ones are missing.
+func readV1(data []byte) (*Metadata, error) {
+	var legacy struct {
+		Metadata
+		Host       string `json:"host"`
+		Encryption string `json:"encryption"`
+		Stats      struct {
+			Stats
+			Files     *int   `json:"files"`
+			Skipped   *int   `json:"skipped"`
+			Excluded  *int   `json:"excluded"`
+			Sensitive *int   `json:"sensitive"`
+			Size      *int64 `json:"size"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, err
+	}
+
+	meta := legacy.Metadata
+	meta.Stats = legacy.Stats.Stats
+	if meta.Hostname == "" {
+		meta.Hostname = legacy.Host
+	}
+	if meta.EncryptionMethod == "" {
+		meta.EncryptionMethod = legacy.Encryption
+	}
+	for _, alias := range []struct {
+		old *int
+		dst *int
+	}{
+		{legacy.Stats.Files, &meta.Stats.FilesBackedUp},
+		{legacy.Stats.Skipped, &meta.Stats.FilesSkipped},
+		{legacy.Stats.Excluded, &meta.Stats.FilesExcluded},
+		{legacy.Stats.Sensitive, &meta.Stats.SensitiveFiles},
+	} {
+		if alias.old != nil && *alias.dst == 0 {
+			*alias.dst = *alias.old
+		}
+	}
+	if legacy.Stats.Size != nil && meta.Stats.TotalSize == 0 {
+		meta.Stats.TotalSize = *legacy.Stats.Size
+	}
+	return &meta, nil
+}
+
+// UpgradeDir rewrites the metadata of the archives in backupDir that was
+// written in an older schema, so releases that only read the current one
+// can use it. With dryRun nothing is written.
+func UpgradeDir(backupDir string, dryRun bool) (*UpgradeResult, error) {
+	result := &UpgradeResult{DryRun: dryRun, Upgraded: []string{}}
+
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		result.SetError(fmt.Errorf("reading backup directory: %w", err))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
+		return result, nil
+	}
+
+	var metaPaths []string
+	for _, entry := range entries {
+		if !IsArchiveName(entry.Name()) {
+			continue
+		}
+		metaPath := GetMetadataPath(filepath.Join(backupDir, entry.Name()))
+		if _, statErr := os.Stat(metaPath); statErr == nil {
+			metaPaths = append(metaPaths, metaPath)
+		}
+	}
+	sort.Strings(metaPaths)
+
+	for _, metaPath := range metaPaths {
+		meta, loadErr := Load(metaPath)
+		if loadErr != nil {
+			result.SetError(fmt.Errorf("reading %s: %w", filepath.Base(metaPath), loadErr))
+			return result, nil
+		}
+		if meta.Version >= SchemaVersion {
+			result.Current++
+			continue
+		}
+		if !dryRun {
+			if saveErr := meta.Save(metaPath); saveErr != nil {
+				result.SetError(fmt.Errorf("writing %s: %w", filepath.Base(metaPath), saveErr))
+				return result, nil
+			}
+		}
+		result.Upgraded = append(result.Upgraded, filepath.Base(metaPath))
+	}
+
+	result.Success = true
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		data        string
+		wantVersion int
+		wantHost    string
+		wantFiles   int
+		wantSize    int64
+		wantMethod  string
+		wantErr     string
+	}{
+		{
+			name:        "current schema",
+			data:        `{"version":2,"hostname":"mbp","encryption_method":"age","stats":{"files_backed_up":3,"total_size":10}}`,
+			wantVersion: 2, wantHost: "mbp", wantFiles: 3, wantSize: 10, wantMethod: "age",
+		},
+		{
+			name:        "unversioned",
+			data:        `{"hostname":"mbp","stats":{"files_backed_up":3,"total_size":10}}`,
+			wantVersion: 1, wantHost: "mbp", wantFiles: 3, wantSize: 10,
+		},
+		{
+			name:        "early field names",
+			data:        `{"host":"mbp","encryption":"gpg","stats":{"files":3,"size":10}}`,
+			wantVersion: 1, wantHost: "mbp", wantFiles: 3, wantSize: 10, wantMethod: "gpg",
+		},
+		{
+			name:    "newer schema",
+			data:    `{"version":99}`,
+			wantErr: "newer than this dotpak supports",
+		},
+		{
+			name:    "invalid json",
+			data:    `{`,
+			wantErr: "unexpected end",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			meta, err := Parse([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if meta.Version != tt.wantVersion || meta.Hostname != tt.wantHost ||
+				meta.Stats.FilesBackedUp != tt.wantFiles || meta.Stats.TotalSize != tt.wantSize ||
+				meta.EncryptionMethod != tt.wantMethod {
+				t.Errorf("Parse() = version %d, host %q, files %d, size %d, method %q",
+					meta.Version, meta.Hostname, meta.Stats.FilesBackedUp, meta.Stats.TotalSize, meta.EncryptionMethod)
+			}
+		})
+	}
+}
+
+func TestUpgradeDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dotfiles-20250101_120000.tar.gz", "archive")
+	write("dotfiles-20250101_120000.json", `{"host":"mbp","stats":{"files":3}}`)
+	write("dotfiles-20250102_120000.tar.gz.age", "archive")
+	write("dotfiles-20250102_120000.json", `{"version":2,"hostname":"mbp"}`)
+	write("dotfiles-20250103_120000.json", `{"host":"orphan"}`)
+
+	result, err := UpgradeDir(dir, true)
+	if err != nil || !result.Success {
+		t.Fatalf("UpgradeDir(dry run) = %+v, %v", result, err)
+	}
+	if !slices.Equal(result.Upgraded, []string{"dotfiles-20250101_120000.json"}) || result.Current != 1 {
+		t.Errorf("UpgradeDir(dry run) upgraded %v, current %d", result.Upgraded, result.Current)
+	}
+	if meta, _ := Load(filepath.Join(dir, "dotfiles-20250101_120000.json")); meta.Version != 1 {
+		t.Errorf("dry run rewrote metadata to version %d", meta.Version)
+	}
+
+	result, err = UpgradeDir(dir, false)
+	if err != nil || !result.Success || len(result.Upgraded) != 1 {
+		t.Fatalf("UpgradeDir() = %+v, %v", result, err)
+	}
+	meta, err := Load(filepath.Join(dir, "dotfiles-20250101_120000.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Version != SchemaVersion || meta.Hostname != "mbp" || meta.Stats.FilesBackedUp != 3 {
+		t.Errorf("upgraded metadata = %+v", meta)
+	}
+	if meta, _ = Load(filepath.Join(dir, "dotfiles-20250103_120000.json")); meta.Version != 1 {
+		t.Error("metadata without an archive was rewritten")
+	}
+
+	if result, _ = UpgradeDir(filepath.Join(dir, "missing"), false); result.Success {
+		t.Error("UpgradeDir() of a missing directory succeeded")
+	}
+}
+
+func TestUnknownFeatures(t *testing.T) {
+	t.Parallel()
+
+	got := UnknownFeatures([]string{FeatureSealed, " zstd-members", "", "zstd-members", "chunked"})
+	if want := []string{"zstd-members", "chunked"}; !slices.Equal(got, want) {
+		t.Errorf("UnknownFeatures() = %v, want %v", got, want)
+	}
+	if got = UnknownFeatures([]string{FeatureSealed}); got != nil {
+		t.Errorf("UnknownFeatures() = %v, want none", got)
+	}
+}
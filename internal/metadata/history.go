@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// HistoryFile records, in the backup directory, one line of JSON per backup
+// run, so that stats can show trends beyond the backups prune keeps.
+const HistoryFile = "history.jsonl"
+
+// HistoryEntry describes one backup run.
+type HistoryEntry struct {
+	Timestamp string `json:"timestamp"`
+	Hostname  string `json:"hostname,omitempty"`
+	// Archive is the file name of the archive, or the name of the snapshot.
+	Archive          string `json:"archive"`
+	Incremental      bool   `json:"incremental,omitempty"`
+	EncryptionMethod string `json:"encryption_method,omitempty"`
+	DurationMS       int64  `json:"duration_ms"`
+	// Files and ContentSize describe the backed up state, including the
+	// files of an incremental backup's parents; FilesArchived and BytesRead
+	// what the run wrote, and ArchiveSize the archive on disk.
+	Files         int   `json:"files"`
+	ContentSize   int64 `json:"content_size"`
+	FilesArchived int   `json:"files_archived"`
+	BytesRead     int64 `json:"bytes_read,omitempty"`
+	ArchiveSize   int64 `json:"archive_size,omitempty"`
+	// Items breaks the backed up state down by item, by path.
+	Items []ItemSize `json:"items,omitempty"`
+}
+
+// ItemSize is the number and size of the backed up files of an item.
+type ItemSize struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// AppendHistory adds entries to the history file of backupDir, on a line
+// of their own after one cut short.
+func AppendHistory(backupDir string, entries ...HistoryEntry) error {
+	f, err := os.OpenFile(filepath.Join(backupDir, HistoryFile), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if info, statErr := f.Stat(); statErr == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, err = f.Write([]byte{'\n'})
+		}
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err = enc.Encode(e); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// LoadHistory returns the runs recorded in the history file of backupDir,
+// oldest first. Lines that cannot be read, such as one cut short by a
+// crash, are left out; a missing file means no run was recorded.
+func LoadHistory(backupDir string) ([]HistoryEntry, error) {
+	f, err := os.Open(filepath.Join(backupDir, HistoryFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var e HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Timestamp != "" {
+			entries = append(entries, e)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	// a spooled history is appended after the runs that reached the backup
+	// directory meanwhile
+	slices.SortStableFunc(entries, func(a, b HistoryEntry) int {
+		return strings.Compare(a.Timestamp, b.Timestamp)
+	})
+	return entries, nil
+}
+
+// ItemSizes breaks files down by the item of items, such as .config/nvim,
+// that holds them, largest first. A file is counted in the longest item
+// holding it, or under its top directory if none does, as for a backup made
+// with another config.
+func ItemSizes(files []CatalogEntry, items []string) []ItemSize {
+	roots := make([]string, 0, len(items))
+	for _, item := range items {
+		roots = append(roots, strings.Trim(filepath.ToSlash(filepath.Clean(item)), "/"))
+	}
+
+	sizes := make(map[string]*ItemSize)
+	for _, f := range files {
+		path := filepath.ToSlash(f.Path)
+		item := ""
+		for _, root := range roots {
+			if (path == root || strings.HasPrefix(path, root+"/")) && len(root) > len(item) {
+				item = root
+			}
+		}
+		if item == "" {
+			item, _, _ = strings.Cut(path, "/")
+		}
+		s := sizes[item]
+		if s == nil {
+			s = &ItemSize{Path: item}
+			sizes[item] = s
+		}
+		s.Files++
+		s.Size += f.Size
+	}
+
+	result := make([]ItemSize, 0, len(sizes))
+	for _, s := range sizes {
+		result = append(result, *s)
+	}
+	slices.SortFunc(result, func(a, b ItemSize) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Path, b.Path))
+	})
+	return result
+}
@@ -3,41 +3,181 @@ package metadata
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/ospiem/dotpak/internal/errs"
 	"github.com/ospiem/dotpak/internal/osutils"
 )
 
+// ManifestName is the first entry of every archive: its metadata, without
+// the fields only known once the archive is written (HMAC, size alert, and
+// duration), so that an archive separated from its metadata file still
+// describes itself.
+const ManifestName = ".dotpak-manifest.json"
+
+// ShellSnapshotName is the archive entry that holds the interactive shell's
+// aliases, functions, and environment when backup.shell_snapshot is set.
+// Like the manifest, it is never restored into the home directory.
+const ShellSnapshotName = ".dotpak-shell-snapshot.txt"
+
 // Metadata represents backup metadata.
 type Metadata struct {
-	Timestamp        string `json:"timestamp"`
-	Hostname         string `json:"hostname"`
-	OSVersion        string `json:"os_version,omitempty"`
+	// Version is the schema version the metadata was written with; see
+	// SchemaVersion.
+	Version   int    `json:"version"`
+	Timestamp string `json:"timestamp"`
+	Hostname  string `json:"hostname"`
+	OSVersion string `json:"os_version,omitempty"`
+	// HomeDir is the home directory the files were backed up from, replaced
+	// in config files restored into another one.
+	HomeDir string `json:"home_dir,omitempty"`
+	// DotpakVersion is the release that made the backup, and Profile the
+	// config profile it used.
+	DotpakVersion string `json:"dotpak_version,omitempty"`
+	Profile       string `json:"profile,omitempty"`
+	// ImportedFrom is the directory of the snapshot an imported backup was
+	// made from.
+	ImportedFrom     string `json:"imported_from,omitempty"`
 	Encrypted        bool   `json:"encrypted"`
 	EncryptionMethod string `json:"encryption_method,omitempty"`
-	Stats            Stats  `json:"stats"`
+	// SensitiveEncryption is the method sensitive files are encrypted with
+	// on their own in an archive that is not encrypted.
+	SensitiveEncryption string `json:"sensitive_encryption,omitempty"`
+	// Features lists the archive features the entries need; see
+	// KnownFeatures.
+	Features []string `json:"features,omitempty"`
+	Stats    Stats    `json:"stats"`
+	// Files is the catalog of archived files, used to compare backups
+	// without decrypting them. For an incremental backup it lists the full
+	// state, including unchanged files stored in earlier archives.
+	Files []CatalogEntry `json:"files,omitempty"`
+	// Parent is the file name of the archive an incremental backup builds
+	// on. The archive holds only files that changed since the parent.
+	Parent string `json:"parent,omitempty"`
+	// HMAC is the hex HMAC-SHA256 of the archive name, its signed metadata
+	// (see Signed), and its content, keyed by a secret that never leaves the
+	// machine, so restore can detect an archive or metadata file that was
+	// tampered with in the backup directory.
+	HMAC string `json:"hmac,omitempty"`
+	// SizeAlert is set when the archive size differed unexpectedly from
+	// the previous backups.
+	SizeAlert *SizeAlert `json:"size_alert,omitempty"`
+	// DurationMS is the time taken to collect the files and write the
+	// archive.
+	DurationMS int64 `json:"duration_ms,omitempty"`
+}
+
+// SizeAlert describes an unexpected change in archive size compared with the
+// rolling average of previous backups.
+type SizeAlert struct {
+	ArchiveSize      int64   `json:"archive_size"`
+	AverageSize      int64   `json:"average_size"`
+	ChangePercent    float64 `json:"change_percent"`
+	ThresholdPercent int     `json:"threshold_percent"`
+}
+
+// CatalogEntry records one archived file.
+type CatalogEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Mode    string `json:"mode,omitempty"` // octal permissions, e.g. "0600"
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// BackupDelta summarizes how a backup differs from the previous one.
+type BackupDelta struct {
+	Previous     string `json:"previous"`
+	FilesAdded   int    `json:"files_added"`
+	FilesRemoved int    `json:"files_removed"`
+	FilesChanged int    `json:"files_changed"`
+	SizeChange   int64  `json:"size_change"`
 }
 
 // Stats represents backup statistics.
 type Stats struct {
-	FilesBackedUp  int   `json:"files_backed_up"`
-	FilesSkipped   int   `json:"files_skipped"`
-	FilesExcluded  int   `json:"files_excluded"`
-	SensitiveFiles int   `json:"sensitive_files"`
-	TotalSize      int64 `json:"total_size"`
+	FilesBackedUp  int `json:"files_backed_up"`
+	FilesSkipped   int `json:"files_skipped"`
+	FilesExcluded  int `json:"files_excluded"`
+	FilesTooLarge  int `json:"files_too_large,omitempty"`
+	FilesUnchanged int `json:"files_unchanged,omitempty"`
+	SensitiveFiles int `json:"sensitive_files"`
+	Placeholders   int `json:"placeholders,omitempty"`
+	Materialized   int `json:"materialized,omitempty"`
+	// NixSymlinks counts the symlinks into the Nix store, archived, skipped,
+	// or dereferenced as backup.nix_symlinks decides.
+	NixSymlinks int   `json:"nix_symlinks,omitempty"`
+	TotalSize   int64 `json:"total_size"`
+
+	// BytesRead is the content archived before compression, CompressedSize
+	// the archive before encryption, and ArchiveSize the archive on disk;
+	// EncryptionOverhead is the difference of the last two.
+	BytesRead          int64 `json:"bytes_read,omitempty"`
+	CompressedSize     int64 `json:"compressed_size,omitempty"`
+	ArchiveSize        int64 `json:"archive_size,omitempty"`
+	EncryptionOverhead int64 `json:"encryption_overhead,omitempty"`
+}
+
+// CompressionRatio returns BytesRead divided by CompressedSize, or 0 if
+// nothing was archived.
+func (s Stats) CompressionRatio() float64 {
+	if s.CompressedSize == 0 {
+		return 0
+	}
+	return float64(s.BytesRead) / float64(s.CompressedSize)
 }
 
 // BackupResult represents the result of a backup operation.
 type BackupResult struct {
-	Success          bool   `json:"success"`
-	Archive          string `json:"archive,omitempty"`
-	Encrypted        bool   `json:"encrypted"`
-	EncryptionMethod string `json:"encryption_method,omitempty"`
-	Stats            Stats  `json:"stats"`
-	Error            string `json:"error,omitempty"`
+	Success             bool   `json:"success"`
+	Archive             string `json:"archive,omitempty"`
+	Encrypted           bool   `json:"encrypted"`
+	EncryptionMethod    string `json:"encryption_method,omitempty"`
+	SensitiveEncryption string `json:"sensitive_encryption,omitempty"`
+	Parent              string `json:"parent,omitempty"`
+	// Snapshot is the name of the snapshot written instead of an archive
+	// with backup.storage = "objects".
+	Snapshot     string       `json:"snapshot,omitempty"`
+	Spooled      bool         `json:"spooled,omitempty"`
+	Remote       string       `json:"remote,omitempty"`
+	Stats        Stats        `json:"stats"`
+	Delta        *BackupDelta `json:"delta,omitempty"`
+	SizeAlert    *SizeAlert   `json:"size_alert,omitempty"`
+	Placeholders []string     `json:"placeholders,omitempty"`
+	IOProfile    []IOPhase    `json:"io_profile,omitempty"`
+	// PendingUpload is set when the upload failed with [remote] spool and
+	// the archive waits for the next backup or upload-pending.
+	PendingUpload bool `json:"pending_upload,omitempty"`
+	// SelfTest is the scheduled verification of an older backup that ran
+	// after this one, if verify_schedule called for it.
+	SelfTest  *VerifyResult `json:"self_test,omitempty"`
+	Hooks     []HookResult  `json:"hooks,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	ErrorCode string        `json:"error_code,omitempty"`
+}
+
+// IOPhase records the IO done by one phase of a backup or restore.
+type IOPhase struct {
+	Phase        string `json:"phase"`
+	DurationMS   int64  `json:"duration_ms"`
+	Files        int    `json:"files"`
+	BytesRead    int64  `json:"bytes_read"`
+	BytesWritten int64  `json:"bytes_written"`
+}
+
+// RestoreStats holds restore statistics.
+type RestoreStats struct {
+	FilesRestored int   `json:"files_restored"`
+	FilesSkipped  int   `json:"files_skipped"`
+	FilesFailed   int   `json:"files_failed"`
+	BytesWritten  int64 `json:"bytes_written"`
+	DurationMS    int64 `json:"duration_ms"`
 }
 
 // RestoreResult represents the result of a restore operation.
@@ -45,9 +185,449 @@ type RestoreResult struct {
 	Success      bool     `json:"success"`
 	Archive      string   `json:"archive,omitempty"`
 	SafetyBackup string   `json:"safety_backup,omitempty"`
+	Chain        []string `json:"chain,omitempty"`
+	Verified     bool     `json:"verified"`
 	Categories   []string `json:"categories,omitempty"`
+	Files        []string `json:"files,omitempty"`
+	Target       string   `json:"target,omitempty"`
 	DryRun       bool     `json:"dry_run"`
-	Error        string   `json:"error,omitempty"`
+	// RolledBack is set when a transactional restore failed to move its
+	// staged files into place and put the original files back.
+	RolledBack bool `json:"rolled_back,omitempty"`
+	// Restored and NotRestored list the files a canceled restore wrote
+	// and those it did not get to.
+	Restored    []string `json:"restored,omitempty"`
+	NotRestored []string `json:"not_restored,omitempty"`
+	// Stats counts the files restored, and IOProfile records the time and
+	// IO of the decrypt, safety backup, and extract phases.
+	Stats     RestoreStats `json:"stats"`
+	IOProfile []IOPhase    `json:"io_profile,omitempty"`
+	// Conflicts lists the restored files that differed from the local ones
+	// and how each was resolved with --on-conflict.
+	Conflicts []RestoreConflict `json:"conflicts,omitempty"`
+	// ModeChanges lists the restored files and directories given another
+	// mode than the archive records, other than by --umask.
+	ModeChanges []ModeChange `json:"mode_changes,omitempty"`
+	// CaseCollisions lists the entries skipped because an entry whose name
+	// differs only in case was restored first, to the same file on a
+	// case-insensitive filesystem.
+	CaseCollisions []string `json:"case_collisions,omitempty"`
+	// Unsupported lists the entries --force-partial left out because they
+	// need archive features this release does not know.
+	Unsupported []string `json:"unsupported,omitempty"`
+	// PostRestore lists the post_restore commands of the [[item]] tables
+	// with restored files.
+	PostRestore []PostRestoreResult `json:"post_restore,omitempty"`
+	// Preview classifies the files and symlinks a dry run would restore
+	// against the local ones.
+	Preview *RestorePreview `json:"preview,omitempty"`
+	// Rewrites lists the replacements made in restored config files, or
+	// that a dry run would make.
+	Rewrites []Rewrite `json:"rewrites,omitempty"`
+	// Checks lists the syntax checks of the restored config files run with
+	// --validate or validate.after_restore.
+	Checks    []SmokeCheck `json:"checks,omitempty"`
+	Hooks     []HookResult `json:"hooks,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	ErrorCode string       `json:"error_code,omitempty"`
+}
+
+// RestoreConflict describes a restored file that differed from the local
+// one: Resolution is overwrite, keep, or rename.
+type RestoreConflict struct {
+	Path       string `json:"path"`
+	Resolution string `json:"resolution"`
+}
+
+// ModeChange describes a restored file given another mode than the one
+// recorded: Reason is "unreadable" for a mode that locked the owner out,
+// replaced by a default mode, or "private" for a file of a private category.
+type ModeChange struct {
+	Path   string `json:"path"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+// RestorePreview sums up what a restore would change: the files and
+// symlinks it would create (New), overwrite with the same content
+// (Identical), or overwrite with other content (Modified). Bytes is the
+// size of the files it would write and ChangedBytes that of the new and
+// modified ones.
+type RestorePreview struct {
+	PreviewCounts
+	Categories []CategoryPreview `json:"categories"`
+	Files      []PreviewFile     `json:"files"`
+}
+
+// PreviewCounts counts the files of a RestorePreview by status.
+type PreviewCounts struct {
+	New          int   `json:"new"`
+	Identical    int   `json:"identical"`
+	Modified     int   `json:"modified"`
+	Bytes        int64 `json:"bytes"`
+	ChangedBytes int64 `json:"changed_bytes"`
+}
+
+// Add counts f.
+func (c *PreviewCounts) Add(f PreviewFile) {
+	switch f.Status {
+	case "new":
+		c.New++
+		c.ChangedBytes += f.Size
+	case "modified":
+		c.Modified++
+		c.ChangedBytes += f.Size
+	default:
+		c.Identical++
+	}
+	c.Bytes += f.Size
+}
+
+// CategoryPreview counts the files of a category in a RestorePreview;
+// Category is empty for files of no category.
+type CategoryPreview struct {
+	Category string `json:"category"`
+	PreviewCounts
+}
+
+// PreviewFile is a file or symlink of a RestorePreview, with its status:
+// new, identical, or modified.
+type PreviewFile struct {
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	Category string `json:"category,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+// Rewrite describes the replacements of one string in a restored file.
+type Rewrite struct {
+	Path  string `json:"path"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int    `json:"count"`
+}
+
+// PostRestoreResult describes a post_restore command of a restored item.
+type PostRestoreResult struct {
+	Path    string `json:"path"`
+	Command string `json:"command"`
+	Ran     bool   `json:"ran"` // false in dry runs, with --no-post-restore, and with --target
+	Error   string `json:"error,omitempty"`
+}
+
+// HookResult describes a command run from [hooks].
+type HookResult struct {
+	Event   string `json:"event"` // pre_backup, post_backup, pre_restore or post_restore
+	Command string `json:"command"`
+	Error   string `json:"error,omitempty"`
+}
+
+// StatsResult represents statistics across the backups in a backup
+// directory.
+type StatsResult struct {
+	Success   bool   `json:"success"`
+	BackupDir string `json:"backup_dir"`
+	Backups   int    `json:"backups"`
+	// TotalSize is the size of all archives on disk.
+	TotalSize int64 `json:"total_size"`
+	// Series holds one point per backup, oldest first.
+	Series []StatsPoint `json:"series"`
+	// History summarizes the runs recorded in the history file, if any.
+	History *StatsHistory `json:"history,omitempty"`
+	// Items breaks the newest backup down by item, largest first.
+	Items []StatsItem `json:"items"`
+	Error string      `json:"error,omitempty"`
+}
+
+// StatsHistory summarizes the backup runs recorded in the history file,
+// which outlive the archives prune removes.
+type StatsHistory struct {
+	Runs  int    `json:"runs"`
+	Since string `json:"since"`
+	// AverageSize is the average archive size of full backups, and
+	// AverageIncrementalSize that of incremental ones.
+	AverageSize            int64 `json:"average_size"`
+	AverageIncrementalSize int64 `json:"average_incremental_size,omitempty"`
+	AverageDurationMS      int64 `json:"average_duration_ms"`
+	// FirstContentSize is the content backed up by the first run, and
+	// ContentSize by the newest one.
+	FirstContentSize int64 `json:"first_content_size"`
+	ContentSize      int64 `json:"content_size"`
+}
+
+// StatsItem is the size of an item in the newest backup of a StatsResult.
+type StatsItem struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+	// Growth is the change in size since the first run or backup the
+	// result describes.
+	Growth int64 `json:"growth"`
+}
+
+// StatsPoint describes one backup of a StatsResult series.
+type StatsPoint struct {
+	Archive   string `json:"archive"`
+	Timestamp string `json:"timestamp"`
+	Size      int64  `json:"size"` // of the archive
+	// Files and ContentSize describe the backed up state from the catalog,
+	// including the files of an incremental backup's parents.
+	Files       int   `json:"files"`
+	ContentSize int64 `json:"content_size"`
+	// DurationMS is 0 for backups of releases that did not record it.
+	DurationMS int64 `json:"duration_ms,omitempty"`
+}
+
+// UpgradeResult represents the result of rewriting backup metadata in the
+// current schema.
+type UpgradeResult struct {
+	Success bool `json:"success"`
+	DryRun  bool `json:"dry_run"`
+	// Upgraded lists the metadata files rewritten (or, in a dry run, to be
+	// rewritten).
+	Upgraded []string `json:"upgraded"`
+	// Current counts the metadata files already in the current schema.
+	Current   int    `json:"current"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// PackageRestoreResult represents the result of a package restore operation.
+type PackageRestoreResult struct {
+	Success   bool             `json:"success"`
+	Manager   string           `json:"manager"`
+	DryRun    bool             `json:"dry_run"`
+	Installed []string         `json:"installed"`
+	Failed    []PackageFailure `json:"failed"`
+	// Command is the shell command to run to reinstall the packages, for
+	// package managers that need root and are not run by dotpak.
+	Command string `json:"command,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PackageFailure describes a package that could not be installed.
+type PackageFailure struct {
+	Package  string `json:"package"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+// VerifyResult represents the result of an archive verification.
+type VerifyResult struct {
+	Success   bool   `json:"success"`
+	Archive   string `json:"archive"`
+	Encrypted bool   `json:"encrypted"`
+	Files     int    `json:"files"`
+	TotalSize int64  `json:"total_size"`
+	// Verified is set when the archive's integrity HMAC was checked.
+	Verified bool `json:"verified"`
+	// Mismatched lists the paths whose content does not match the catalog
+	// in the archive's metadata, or that are missing from either.
+	Mismatched []string `json:"mismatched,omitempty"`
+	// Root is the scratch home directory verify --in-container restored the
+	// archive into, and Checks the smoke checks run there.
+	Root      string       `json:"root,omitempty"`
+	Checks    []SmokeCheck `json:"checks,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	ErrorCode string       `json:"error_code,omitempty"`
+}
+
+// SmokeCheck is a command run against a restored config file to check that
+// it still works, such as zsh -n on .zshrc.
+type SmokeCheck struct {
+	Category string `json:"category"`
+	File     string `json:"file"`
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	// Status is passed, failed, or skipped when the program is not
+	// installed.
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+}
+
+// RekeyResult describes an archive re-encrypted to other recipients by
+// rekey.
+type RekeyResult struct {
+	Success bool `json:"success"`
+	// Archive is the re-encrypted archive, and Previous the archive it
+	// replaced when changing the method changed its name.
+	Archive  string `json:"archive"`
+	Previous string `json:"previous,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Size     int64  `json:"size"`
+	// Verified is set when the archive's integrity HMAC was checked before
+	// decrypting it, and Signed when a new HMAC was recorded.
+	Verified  bool   `json:"verified"`
+	Signed    bool   `json:"signed"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// ExportResult represents the result of exporting an archive to a plain tar
+// or zip file, or to a git working tree.
+type ExportResult struct {
+	Success bool   `json:"success"`
+	Archive string `json:"archive"`
+	Output  string `json:"output"`
+	Format  string `json:"format"`
+	// Chain lists the archives merged for an incremental backup, newest
+	// first.
+	Chain     []string `json:"chain,omitempty"`
+	Verified  bool     `json:"verified"`
+	Files     int      `json:"files"`
+	TotalSize int64    `json:"total_size"`
+	// Skipped lists the sensitive files left out of a git export.
+	Skipped []string `json:"skipped,omitempty"`
+	// Changes is the number of paths a git export changed, and Commit the
+	// commit recording them; both are empty if nothing changed.
+	Changes   int    `json:"changes,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// CheckRestoreResult represents the result of a restore idempotency check.
+type CheckRestoreResult struct {
+	Success     bool                `json:"success"`
+	Archive     string              `json:"archive"`
+	NoOp        bool                `json:"no_op"`
+	Checked     int                 `json:"checked"`
+	Differences []RestoreDifference `json:"differences"`
+	Error       string              `json:"error,omitempty"`
+	ErrorCode   string              `json:"error_code,omitempty"`
+}
+
+// LintPathsResult lists the absolute paths in the files of an archive that
+// are likely to break when it is restored on another machine.
+type LintPathsResult struct {
+	Success bool   `json:"success"`
+	Archive string `json:"archive"`
+	// SourceHome is the home directory the backup was made in, if recorded.
+	SourceHome string `json:"source_home,omitempty"`
+	Checked    int    `json:"checked"`
+	// Rewritten counts the paths that restore replaces, under SourceHome or
+	// a [rewrite] map key, which are not reported.
+	Rewritten int        `json:"rewritten"`
+	Findings  []PathLint `json:"findings"`
+	Error     string     `json:"error,omitempty"`
+	ErrorCode string     `json:"error_code,omitempty"`
+}
+
+// PathLint is a machine-specific absolute path found in an archived file.
+type PathLint struct {
+	Path  string `json:"path"`
+	Line  int    `json:"line"`
+	Value string `json:"value"`
+	Kind  string `json:"kind"`
+	Hint  string `json:"hint"`
+}
+
+// RestoreDifference describes an archive entry that a restore would change.
+type RestoreDifference struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// RestoreReport describes what a restore would change on this machine and
+// what it needs first, such as directories to create and programs that read
+// the restored configs.
+type RestoreReport struct {
+	Success bool                `json:"success"`
+	Archive string              `json:"archive"`
+	Checked int                 `json:"checked"`
+	Changes []RestoreDifference `json:"changes"`
+	// MissingDirs are the outermost directories, relative to home, that
+	// restore would create.
+	MissingDirs      []string          `json:"missing_dirs"`
+	MissingApps      []MissingApp      `json:"missing_apps"`
+	PermissionIssues []PermissionIssue `json:"permission_issues"`
+	Error            string            `json:"error,omitempty"`
+	ErrorCode        string            `json:"error_code,omitempty"`
+}
+
+// MissingApp is a program that is not installed although the archive
+// restores its configuration.
+type MissingApp struct {
+	App   string   `json:"app"`
+	Paths []string `json:"paths"`
+}
+
+// PermissionIssue is a path restore would fail to write.
+type PermissionIssue struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+}
+
+// ChainResult describes the archives needed to restore a backup: the
+// backup itself and, for an incremental backup, its parents back to the
+// full backup the chain starts from.
+type ChainResult struct {
+	Success bool   `json:"success"`
+	Archive string `json:"archive"`
+	// Links are the archives of the chain, newest first. A missing parent
+	// ends the chain with a link marked Missing.
+	Links []ChainLink `json:"links"`
+	// Files and Size are the files the backup restores, and ArchiveSize
+	// the size of the archives that hold them.
+	Files       int    `json:"files"`
+	Size        int64  `json:"size"`
+	ArchiveSize int64  `json:"archive_size"`
+	Error       string `json:"error,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+}
+
+// ChainLink is an archive of a chain. Files is the number of files it
+// holds and Contributed those a restore of the chain takes from it; the
+// others are replaced by newer links. Both come from the catalogs of the
+// metadata, so NoCatalog is set when they are unknown.
+type ChainLink struct {
+	Archive     string   `json:"archive"`
+	Timestamp   string   `json:"timestamp,omitempty"`
+	Full        bool     `json:"full"`
+	Encryption  string   `json:"encryption,omitempty"`
+	ArchiveSize int64    `json:"archive_size"`
+	Files       int      `json:"files"`
+	Contributed []string `json:"contributed"`
+	// ContributedSize is the size of the contributed files.
+	ContributedSize int64 `json:"contributed_size"`
+	NoCatalog       bool  `json:"no_catalog,omitempty"`
+	Missing         bool  `json:"missing,omitempty"`
+}
+
+// SearchResult lists the backups with files matching a search, newest
+// first. Searched counts the backups searched, with or without matches.
+type SearchResult struct {
+	Success   bool           `json:"success"`
+	Pattern   string         `json:"pattern"`
+	Content   bool           `json:"content"`
+	Searched  int            `json:"searched"`
+	Backups   []SearchBackup `json:"backups"`
+	Error     string         `json:"error,omitempty"`
+	ErrorCode string         `json:"error_code,omitempty"`
+}
+
+// SearchBackup lists the matching files of a backup. Error is set for a
+// backup that could not be searched.
+type SearchBackup struct {
+	Archive   string        `json:"archive"`
+	Timestamp string        `json:"timestamp"`
+	Matches   []SearchMatch `json:"matches"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// SearchMatch is a file whose name or content matched a search. For a
+// content match, Line and Text are the first matching line and Lines the
+// number of matching lines.
+type SearchMatch struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Line  int    `json:"line,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Lines int    `json:"lines,omitempty"`
 }
 
 // ListResult represents the result of a list operation.
@@ -57,6 +637,231 @@ type ListResult struct {
 	Error   string       `json:"error,omitempty"`
 }
 
+// SnapshotListResult represents the snapshots of a content-addressed store.
+type SnapshotListResult struct {
+	Success   bool           `json:"success"`
+	Snapshots []SnapshotInfo `json:"snapshots"`
+	// Objects and ObjectsSize describe the stored file contents, shared by
+	// all snapshots.
+	Objects     int    `json:"objects"`
+	ObjectsSize int64  `json:"objects_size"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SnapshotInfo describes a snapshot of a content-addressed store.
+type SnapshotInfo struct {
+	Name      string `json:"name"`
+	Timestamp string `json:"timestamp"`
+	Hostname  string `json:"hostname,omitempty"`
+	Files     int    `json:"files"`
+	Size      int64  `json:"size"` // of the files, before deduplication
+}
+
+// TidyResult lists the junk found in the tracked directories of the home
+// directory, and whether it was deleted.
+type TidyResult struct {
+	Success bool        `json:"success"`
+	Deleted bool        `json:"deleted"`
+	Junk    []TidyEntry `json:"junk"`
+	Files   int         `json:"files"`
+	Size    int64       `json:"size"`
+	// Kept is the number of junk entries left because they were modified
+	// too recently, such as the swap file of an open editor.
+	Kept      int    `json:"kept"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// TidyEntry is a junk file or directory, relative to home. Files and Size
+// count what it holds; Error is set if it could not be deleted.
+type TidyEntry struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"`
+	Dir   bool   `json:"dir,omitempty"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// IndexResult represents an index of the dotfiles of the home directory
+// saved by snapshot-index.
+type IndexResult struct {
+	Success   bool   `json:"success"`
+	Name      string `json:"name,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Files     int    `json:"files"`
+	Size      int64  `json:"size"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// IndexListResult lists the saved indexes of the dotfiles of the home
+// directory.
+type IndexListResult struct {
+	Success bool        `json:"success"`
+	Indexes []IndexInfo `json:"indexes"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// IndexInfo describes a saved index.
+type IndexInfo struct {
+	Name      string `json:"name"`
+	Timestamp string `json:"timestamp"`
+	Hostname  string `json:"hostname,omitempty"`
+	Files     int    `json:"files"`
+	Size      int64  `json:"size"`
+}
+
+// IndexDiffResult lists the dotfiles that appeared, disappeared, or changed
+// between two indexes.
+type IndexDiffResult struct {
+	Success bool `json:"success"`
+	// Old and New name the indexes compared; New is empty when the old
+	// index was compared with the home directory as it is now.
+	Old       string        `json:"old"`
+	New       string        `json:"new,omitempty"`
+	Added     []IndexChange `json:"added"`
+	Removed   []IndexChange `json:"removed"`
+	Modified  []IndexChange `json:"modified"`
+	Unchanged int           `json:"unchanged"`
+	Error     string        `json:"error,omitempty"`
+	ErrorCode string        `json:"error_code,omitempty"`
+}
+
+// IndexChange is a dotfile added, removed, or modified between two indexes.
+type IndexChange struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Tracked reports whether an item or sensitive item of the config
+	// covers the file.
+	Tracked bool `json:"tracked"`
+}
+
+// PruneResult represents the result of a prune operation.
+type PruneResult struct {
+	Success bool         `json:"success"`
+	DryRun  bool         `json:"dry_run"`
+	Kept    []KeptBackup `json:"kept"`
+	// Removed lists the backups deleted (or, in a dry run, to be deleted);
+	// each entry is the archive with its metadata and sidecar files.
+	Removed []string `json:"removed"`
+	// RemovedMetadata lists metadata files whose archive no longer exists.
+	RemovedMetadata []string `json:"removed_metadata,omitempty"`
+	// RemovedPreRestore lists pre-restore safety archives beyond
+	// keep_pre_restore.
+	RemovedPreRestore []string `json:"removed_pre_restore,omitempty"`
+	// RemovedSnapshots lists the snapshots of the content-addressed store
+	// removed; FreedBytes includes the objects only they referenced.
+	RemovedSnapshots []string `json:"removed_snapshots,omitempty"`
+	FreedBytes       int64    `json:"freed_bytes"`
+	Error            string   `json:"error,omitempty"`
+	ErrorCode        string   `json:"error_code,omitempty"`
+}
+
+// UploadResult represents the result of uploading the backups that are
+// waiting for the remote.
+type UploadResult struct {
+	Success bool   `json:"success"`
+	DryRun  bool   `json:"dry_run"`
+	Remote  string `json:"remote,omitempty"`
+	// Uploaded lists the archives uploaded with their metadata, and Pending
+	// those still waiting, e.g. because the remote became unreachable.
+	Uploaded []string `json:"uploaded"`
+	Pending  []string `json:"pending,omitempty"`
+	// Pruned lists the backups removed from the remote by the retention
+	// policy.
+	Pruned    []string `json:"pruned,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	ErrorCode string   `json:"error_code,omitempty"`
+}
+
+// MigrateResult represents the result of restoring a migration bundle on a
+// new machine.
+type MigrateResult struct {
+	Success bool   `json:"success"`
+	Bundle  string `json:"bundle"`
+	Archive string `json:"archive,omitempty"`
+	DryRun  bool   `json:"dry_run"`
+	// Steps lists the steps of the plan in order, with their status.
+	Steps []MigrateStep `json:"steps"`
+	// Checklist lists what is left to do by hand, such as commands to
+	// install the packages of package managers that need root.
+	Checklist []string `json:"checklist,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	ErrorCode string   `json:"error_code,omitempty"`
+}
+
+// MigrateStep describes one step of a migration.
+type MigrateStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // pending, done, or failed
+	Error  string `json:"error,omitempty"`
+}
+
+// KeptBackup is a backup retained by prune and the policy rules that kept it
+// ("last", "daily", "weekly", "monthly", "parent", "pending-upload").
+type KeptBackup struct {
+	Archive string   `json:"archive"`
+	Reasons []string `json:"reasons"`
+}
+
+// StatusResult represents the result of a status query.
+type StatusResult struct {
+	Success     bool   `json:"success"`
+	BackupDir   string `json:"backup_dir"`
+	BackupCount int    `json:"backup_count"`
+	LastBackup  string `json:"last_backup,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+	// DaysSinceBackup is the number of whole days since the last backup, or
+	// -1 if there is none.
+	DaysSinceBackup int    `json:"days_since_backup"`
+	Error           string `json:"error,omitempty"`
+}
+
+// EnvResult holds the paths dotpak resolves, for scripts.
+type EnvResult struct {
+	Success   bool   `json:"success"`
+	Config    string `json:"config"`
+	Home      string `json:"home"`
+	BackupDir string `json:"backup_dir"`
+	LogDir    string `json:"log_dir"`
+	TempDir   string `json:"temp_dir"`
+	// LatestArchive is the newest archive in BackupDir, or empty if there
+	// is none.
+	LatestArchive string `json:"latest_archive"`
+}
+
+// FleetResult represents the result of a fleet status query: the backups
+// of every machine writing to a shared backup location.
+type FleetResult struct {
+	Success   bool        `json:"success"`
+	Location  string      `json:"location"`
+	StaleDays int         `json:"stale_days"`
+	Hosts     []FleetHost `json:"hosts"`
+	// StaleHosts counts the hosts with no backup in StaleDays days.
+	StaleHosts int    `json:"stale_hosts"`
+	Error      string `json:"error,omitempty"`
+	ErrorCode  string `json:"error_code,omitempty"`
+}
+
+// FleetHost summarizes the backups of one host.
+type FleetHost struct {
+	Hostname    string `json:"hostname"`
+	BackupCount int    `json:"backup_count"`
+	LastBackup  string `json:"last_backup"`
+	Timestamp   string `json:"timestamp"`
+	// DaysSinceBackup is the number of whole days since the last backup, or
+	// -1 if its time is unknown.
+	DaysSinceBackup int `json:"days_since_backup"`
+	// Sizes are the archive sizes of the host's recent full backups, oldest
+	// first; incremental archives are left out since their size says little
+	// about the data backed up.
+	Sizes []int64 `json:"sizes"`
+	// SizeChange is the size of the newest full backup minus the one before.
+	SizeChange int64 `json:"size_change"`
+	Stale      bool  `json:"stale"`
+}
+
 // BackupInfo represents info about a single backup.
 type BackupInfo struct {
 	Archive      string `json:"archive"`
@@ -66,7 +871,128 @@ type BackupInfo struct {
 	Encryption   string `json:"encryption,omitempty"`
 	Hostname     string `json:"hostname,omitempty"`
 	FileCount    int    `json:"file_count,omitempty"`
+	Parent       string `json:"parent,omitempty"`
 	MetadataPath string `json:"metadata_path,omitempty"`
+
+	// Location is where a backup listed from both the backup directory and
+	// the remote is: local, remote, or local+remote.
+	Location string `json:"location,omitempty"`
+}
+
+// Locations of the backups listed from both the backup directory and the
+// remote.
+const (
+	LocationLocal  = "local"
+	LocationRemote = "remote"
+	LocationBoth   = "local+remote"
+)
+
+// SetError records err as the result's error message and error code.
+func (r *BackupResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *PruneResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *UploadResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *MigrateResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *RestoreResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *VerifyResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *ExportResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *FleetResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *CheckRestoreResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *LintPathsResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *ChainResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *SearchResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *RestoreReport) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *IndexResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *TidyResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *IndexDiffResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *UpgradeResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
+}
+
+// SetError records err as the result's error message and error code.
+func (r *RekeyResult) SetError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errs.Code(err)
 }
 
 // New creates a new Metadata with current timestamp and hostname.
@@ -77,42 +1003,153 @@ func New() *Metadata {
 	}
 
 	return &Metadata{
+		Version:   SchemaVersion,
 		Timestamp: time.Now().Format("2006-01-02T15:04:05"),
 		Hostname:  hostname,
 	}
 }
 
-// Load reads metadata from a JSON file.
+// Load reads metadata from a JSON file written by this or an earlier release.
 func Load(path string) (*Metadata, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var meta Metadata
-	if err = json.Unmarshal(data, &meta); err != nil {
-		return nil, err
-	}
-
-	return &meta, nil
+	return Parse(data)
 }
 
-// Save writes metadata to a JSON file.
+// Save writes metadata to a JSON file in the current schema.
 func (m *Metadata) Save(path string) error {
-	data, err := json.MarshalIndent(m, "", "  ")
+	data, err := m.Encode()
 	if err != nil {
 		return err
 	}
 	return os.WriteFile(path, data, 0600)
 }
 
+// Encode returns m as JSON in the current schema, as Save writes it and
+// backup embeds it in the archive as ManifestName.
+func (m *Metadata) Encode() ([]byte, error) {
+	m.Version = SchemaVersion
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Signed returns the fields of m that the integrity HMAC covers: all but
+// the HMAC itself, the size alert and duration, which are only known once
+// the archive is signed, and the schema version, which upgrade-backups
+// rewrites.
+func (m *Metadata) Signed() ([]byte, error) {
+	signed := *m
+	signed.Version, signed.HMAC, signed.SizeAlert, signed.DurationMS = 0, "", nil, 0
+	return json.Marshal(&signed)
+}
+
+// Compare reports how the backup described by m differs from prev. If prev
+// was written before catalogs were recorded, only the file count and total
+// size are compared and no files are reported as changed.
+func (m *Metadata) Compare(prev *Metadata) *BackupDelta {
+	delta := &BackupDelta{SizeChange: m.Stats.TotalSize - prev.Stats.TotalSize}
+
+	if len(prev.Files) == 0 {
+		if n := m.Stats.FilesBackedUp - prev.Stats.FilesBackedUp; n > 0 {
+			delta.FilesAdded = n
+		} else {
+			delta.FilesRemoved = -n
+		}
+		return delta
+	}
+
+	previous := make(map[string]CatalogEntry, len(prev.Files))
+	for _, f := range prev.Files {
+		previous[f.Path] = f
+	}
+	for _, f := range m.Files {
+		old, ok := previous[f.Path]
+		switch {
+		case !ok:
+			delta.FilesAdded++
+		case old.SHA256 != "" && f.SHA256 != "":
+			if old.SHA256 != f.SHA256 {
+				delta.FilesChanged++
+			}
+		case old.Size != f.Size || old.ModTime != f.ModTime:
+			delta.FilesChanged++
+		}
+		delete(previous, f.Path)
+	}
+	delta.FilesRemoved = len(previous)
+	return delta
+}
+
+// String formats the delta for display, e.g.
+// "+3 files, -1 file, +2.40 MB, 4 files changed".
+func (d *BackupDelta) String() string {
+	var parts []string
+	if d.FilesAdded > 0 {
+		parts = append(parts, "+"+pluralFiles(d.FilesAdded))
+	}
+	if d.FilesRemoved > 0 {
+		parts = append(parts, "-"+pluralFiles(d.FilesRemoved))
+	}
+	switch {
+	case d.SizeChange > 0:
+		parts = append(parts, "+"+osutils.FormatSize(d.SizeChange))
+	case d.SizeChange < 0:
+		parts = append(parts, "-"+osutils.FormatSize(-d.SizeChange))
+	}
+	if d.FilesChanged > 0 {
+		parts = append(parts, pluralFiles(d.FilesChanged)+" changed")
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func pluralFiles(n int) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", n)
+}
+
+// Chain returns the archives needed to restore archivePath, newest first:
+// archivePath itself followed by the parents of an incremental backup, up to
+// the full backup the chain starts from. It also returns the metadata of
+// archivePath, or nil if it has none (the archive is then treated as full).
+func Chain(archivePath string) ([]string, *Metadata, error) {
+	chain := []string{archivePath}
+	head, err := Load(GetMetadataPath(archivePath))
+	if err != nil {
+		return chain, nil, nil //nolint:nilerr // archives without metadata are full backups
+	}
+
+	for meta := head; meta.Parent != ""; {
+		name := meta.Parent
+		parent := filepath.Join(filepath.Dir(archivePath), name)
+		if slices.Contains(chain, parent) {
+			return nil, nil, errs.Errorf(errs.ErrArchiveCorrupt, "incremental backup chain loops at %s", name)
+		}
+		if _, statErr := os.Stat(parent); statErr != nil {
+			return nil, nil, errs.Errorf(errs.ErrArchiveNotFound,
+				"incremental backup %s needs missing parent archive %s", filepath.Base(chain[len(chain)-1]), name)
+		}
+		chain = append(chain, parent)
+		if meta, err = Load(GetMetadataPath(parent)); err != nil {
+			return nil, nil, errs.Errorf(errs.ErrArchiveCorrupt, "reading metadata of %s: %w", name, err)
+		}
+	}
+	return chain, head, nil
+}
+
 // GetMetadataPath returns the metadata path for an archive.
 // archive.tar.gz -> archive.json
-// archive.tar.gz.age -> archive.json.
+// archive.zip.age -> archive.json.
 func GetMetadataPath(archivePath string) string {
 	base := archivePath
 
-	for _, ext := range []string{".age", ".gpg"} {
+	for _, ext := range encryptionExts {
 		if before, ok := strings.CutSuffix(base, ext); ok {
 			base = before
 			break
@@ -122,6 +1159,8 @@ func GetMetadataPath(archivePath string) string {
 	switch {
 	case strings.HasSuffix(base, ".tar.gz"):
 		base = strings.TrimSuffix(base, ".tar.gz")
+	case strings.HasSuffix(base, ".zip"):
+		base = strings.TrimSuffix(base, ".zip")
 	case strings.HasSuffix(base, ".tar"):
 		base = strings.TrimSuffix(base, ".tar")
 	}
@@ -129,6 +1168,155 @@ func GetMetadataPath(archivePath string) string {
 	return base + ".json"
 }
 
+// ListBackups returns info about the archives in backupDir, newest first.
+// Details recorded in each archive's metadata file are included when present.
+func ListBackups(backupDir string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupInfo
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !IsArchiveName(name) {
+			continue
+		}
+
+		fullPath := filepath.Join(backupDir, name)
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			// file became unreadable between ReadDir and Info - skip it
+			continue
+		}
+
+		backupInfo := NewBackupInfo(fullPath, info.Size())
+
+		if meta, loadErr := Load(GetMetadataPath(fullPath)); loadErr == nil {
+			backupInfo.Hostname = meta.Hostname
+			backupInfo.FileCount = meta.Stats.FilesBackedUp
+			if meta.EncryptionMethod != "" {
+				backupInfo.Encryption = meta.EncryptionMethod
+			}
+			backupInfo.Parent = meta.Parent
+		}
+
+		backups = append(backups, backupInfo)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Timestamp > backups[j].Timestamp
+	})
+
+	return backups, nil
+}
+
+// NewBackupInfo returns the info for an archive that can be derived from its
+// name, such as the timestamp and whether it is encrypted.
+func NewBackupInfo(archive string, size int64) BackupInfo {
+	name := filepath.Base(archive)
+	info := BackupInfo{
+		Archive:   archive,
+		Timestamp: extractTimestamp(name),
+		Size:      size,
+		Encrypted: hasEncryptionExt(name),
+	}
+	if info.Encrypted {
+		info.Encryption = strings.TrimPrefix(filepath.Ext(name), ".")
+	}
+	return info
+}
+
+// CreatedAt returns the local time the backup was created, taken from the
+// archive name.
+func (b BackupInfo) CreatedAt() (time.Time, error) {
+	return time.ParseInLocation(time.DateTime, b.Timestamp, time.Local)
+}
+
+// LatestBackup returns the path of the newest archive in backupDir,
+// or an empty string if there is none.
+func LatestBackup(backupDir string) string {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return ""
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if IsArchiveName(name) {
+			archives = append(archives, filepath.Join(backupDir, name))
+		}
+	}
+
+	if len(archives) == 0 {
+		return ""
+	}
+
+	sort.Strings(archives)
+	return archives[len(archives)-1]
+}
+
+// IsArchiveName reports whether name is a dotpak backup archive file name.
+// Uncompressed .tar archives of earlier releases are included.
+func IsArchiveName(name string) bool {
+	if !strings.HasPrefix(name, "dotfiles") {
+		return false
+	}
+	for _, ext := range append([]string{""}, encryptionExts...) {
+		if strings.HasSuffix(name, ".tar.gz"+ext) || strings.HasSuffix(name, ".zip"+ext) ||
+			strings.HasSuffix(name, ".tar"+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveExt returns the file extension of unencrypted archives in the
+// given backup.format: ".zip" for "zip" and ".tar.gz" otherwise.
+func ArchiveExt(format string) string {
+	if format == "zip" {
+		return ".zip"
+	}
+	return ".tar.gz"
+}
+
+// encryptionExts are the suffixes encryption adds to archive names, one
+// per method.
+var encryptionExts = []string{".age", ".gpg", ".aes"}
+
+func hasEncryptionExt(name string) bool {
+	for _, ext := range encryptionExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractTimestamp extracts and formats the timestamp from an archive filename.
+// Archive names have the format: dotfiles-YYYYMMDD_HHMMSS.{tar.gz|zip}[.age|.gpg|.aes]
+// Example: dotfiles-20240115_143022.tar.gz -> "2024-01-15 14:30:22".
+func extractTimestamp(name string) string {
+	// minimum length: "dotfiles-" (9) + "YYYYMMDD_HHMMSS" (15) = 24
+	const prefixLen = 9 // len("dotfiles-")
+	const tsLen = 15    // len("YYYYMMDD_HHMMSS")
+	const minNameLen = prefixLen + tsLen
+
+	if len(name) < minNameLen {
+		return ""
+	}
+	ts := name[prefixLen : prefixLen+tsLen]
+	if len(ts) != tsLen {
+		return ts
+	}
+	// format: YYYYMMDD_HHMMSS -> YYYY-MM-DD HH:MM:SS
+	return fmt.Sprintf("%s-%s-%s %s:%s:%s",
+		ts[0:4], ts[4:6], ts[6:8], // year, Month, Day
+		ts[9:11], ts[11:13], ts[13:15]) // hour, Minute, Second
+}
+
 // GenerateArchiveName creates an archive name with timestamp.
 func GenerateArchiveName(backupDir string, encrypted bool, method string) string {
 	timestamp := time.Now().Format("20060102_150405")
@@ -140,6 +1328,8 @@ func GenerateArchiveName(backupDir string, encrypted bool, method string) string
 			name += ".age"
 		case "gpg":
 			name += ".gpg"
+		case "aes":
+			name += ".aes"
 		}
 	}
 
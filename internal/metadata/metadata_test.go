@@ -2,11 +2,15 @@ package metadata
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/ospiem/dotpak/internal/errs"
 )
 
 func TestNew(t *testing.T) {
@@ -164,6 +168,16 @@ func TestGetMetadataPath(t *testing.T) {
 			archivePath: "/backups/dotfiles-20250110_120000.tar.gz.gpg",
 			expected:    "/backups/dotfiles-20250110_120000.json",
 		},
+		{
+			name:        "age encrypted zip archive",
+			archivePath: "/backups/dotfiles-20250110_120000.zip.age",
+			expected:    "/backups/dotfiles-20250110_120000.json",
+		},
+		{
+			name:        "aes encrypted zip archive",
+			archivePath: "/backups/dotfiles-20250110_120000.zip.aes",
+			expected:    "/backups/dotfiles-20250110_120000.json",
+		},
 		{
 			name:        "tar archive",
 			archivePath: "/backups/dotfiles-20250110_120000.tar",
@@ -186,6 +200,52 @@ func TestGetMetadataPath(t *testing.T) {
 	}
 }
 
+func TestExtractTimestamp(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"normal", "dotfiles-20250115_143022.tar.gz", "2025-01-15 14:30:22"},
+		{"encrypted", "dotfiles-20250115_143022.tar.gz.age", "2025-01-15 14:30:22"},
+		{"too short", "dotfiles-.tar.gz", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := extractTimestamp(tt.input)
+			if result != tt.expected {
+				t.Errorf("extractTimestamp(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsArchiveName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"dotfiles-20250110_120000.tar.gz":         true,
+		"dotfiles-20250110_120000.tar.gz.gpg":     true,
+		"dotfiles-20250110_120000.zip":            true,
+		"dotfiles-20250110_120000.zip.age":        true,
+		"dotfiles-20250110_120000.tar":            true,
+		"dotfiles-20250110_120000.tar.gz.aes":     true,
+		"dotfiles-20250110_120000.json":           false,
+		"pre-restore-20250110_120000.zip":         false,
+		"dotfiles-20250110_120000.zip.part":       false,
+		"dotfiles-20250110_120000.tar.gz.partial": false,
+	}
+	for name, want := range tests {
+		if got := IsArchiveName(name); got != want {
+			t.Errorf("IsArchiveName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
 func TestGenerateArchiveName(t *testing.T) {
 	t.Parallel()
 
@@ -215,6 +275,13 @@ func TestGenerateArchiveName(t *testing.T) {
 		}
 	})
 
+	t.Run("aes encrypted archive", func(t *testing.T) {
+		name := GenerateArchiveName(backupDir, true, "aes")
+		if !strings.HasSuffix(name, ".tar.gz.aes") {
+			t.Errorf("expected .tar.gz.aes suffix, got %s", name)
+		}
+	})
+
 	t.Run("includes timestamp", func(t *testing.T) {
 		name := GenerateArchiveName(backupDir, false, "")
 		base := filepath.Base(name)
@@ -307,6 +374,28 @@ func TestBackupResult(t *testing.T) {
 	})
 }
 
+func TestSetError(t *testing.T) {
+	t.Parallel()
+
+	result := BackupResult{}
+	result.SetError(errs.Errorf(errs.ErrNothingToBackup, "no files to backup"))
+
+	if result.Error != "no files to backup" {
+		t.Errorf("unexpected error message: %q", result.Error)
+	}
+	if result.ErrorCode != errs.CodeNothingToBackup {
+		t.Errorf("expected %s, got %s", errs.CodeNothingToBackup, result.ErrorCode)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"error_code":"nothing_to_backup"`) {
+		t.Errorf("expected error_code in JSON, got %s", data)
+	}
+}
+
 func TestRestoreResult(t *testing.T) {
 	t.Parallel()
 
@@ -401,3 +490,268 @@ func TestBackupInfo(t *testing.T) {
 		t.Error("expected 'metadata_path' field in JSON")
 	}
 }
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	prev := &Metadata{
+		Stats: Stats{FilesBackedUp: 3, TotalSize: 300},
+		Files: []CatalogEntry{
+			{Path: ".zshrc", Size: 100, ModTime: 1},
+			{Path: ".gitconfig", Size: 100, ModTime: 1},
+			{Path: ".vimrc", Size: 100, ModTime: 1},
+		},
+	}
+	cur := &Metadata{
+		Stats: Stats{FilesBackedUp: 3, TotalSize: 2500},
+		Files: []CatalogEntry{
+			{Path: ".zshrc", Size: 100, ModTime: 1},
+			{Path: ".gitconfig", Size: 200, ModTime: 2},
+			{Path: ".config/big", Size: 2200, ModTime: 2},
+		},
+	}
+
+	delta := cur.Compare(prev)
+	want := BackupDelta{FilesAdded: 1, FilesRemoved: 1, FilesChanged: 1, SizeChange: 2200}
+	if *delta != want {
+		t.Errorf("Compare() = %+v, want %+v", *delta, want)
+	}
+
+	t.Run("previous without catalog", func(t *testing.T) {
+		t.Parallel()
+		old := &Metadata{Stats: Stats{FilesBackedUp: 5, TotalSize: 3000}}
+		got := cur.Compare(old)
+		want := BackupDelta{FilesRemoved: 2, SizeChange: -500}
+		if *got != want {
+			t.Errorf("Compare() = %+v, want %+v", *got, want)
+		}
+	})
+
+	t.Run("hashes override mtime", func(t *testing.T) {
+		t.Parallel()
+		old := &Metadata{Files: []CatalogEntry{{Path: ".zshrc", Size: 1, ModTime: 1, SHA256: "a"}}}
+		touched := &Metadata{Files: []CatalogEntry{{Path: ".zshrc", Size: 1, ModTime: 2, SHA256: "a"}}}
+		if got := touched.Compare(old); got.FilesChanged != 0 {
+			t.Errorf("FilesChanged = %d, want 0 for identical content", got.FilesChanged)
+		}
+	})
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := func(ts, parent string) string {
+		path := filepath.Join(dir, "dotfiles-"+ts+".tar.gz")
+		if err := os.WriteFile(path, []byte("archive"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Metadata{Parent: parent}).Save(GetMetadataPath(path)); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	full := archive("20250101_120000", "")
+	mid := archive("20250102_120000", filepath.Base(full))
+	head := archive("20250103_120000", filepath.Base(mid))
+
+	chain, meta, err := Chain(head)
+	if err != nil {
+		t.Fatalf("Chain() error: %v", err)
+	}
+	if want := []string{head, mid, full}; !slices.Equal(chain, want) {
+		t.Errorf("Chain() = %v, want %v", chain, want)
+	}
+	if meta == nil || meta.Parent != filepath.Base(mid) {
+		t.Errorf("Chain() metadata = %+v, want the head's metadata", meta)
+	}
+
+	t.Run("archive without metadata", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "dotfiles-20250101_120000.tar.gz")
+		chain, meta, err := Chain(path)
+		if err != nil || meta != nil || len(chain) != 1 {
+			t.Errorf("Chain() = %v, %v, %v; want just the archive", chain, meta, err)
+		}
+	})
+
+	t.Run("missing parent", func(t *testing.T) {
+		t.Parallel()
+		orphanDir := t.TempDir()
+		path := filepath.Join(orphanDir, "dotfiles-20250102_120000.tar.gz")
+		if err := os.WriteFile(path, []byte("archive"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Metadata{Parent: "dotfiles-20250101_120000.tar.gz"}).Save(GetMetadataPath(path)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := Chain(path); !errors.Is(err, errs.ErrArchiveNotFound) {
+			t.Errorf("Chain() error = %v, want ErrArchiveNotFound", err)
+		}
+	})
+}
+
+func TestDescribeChain(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := func(ts, parent string, files ...CatalogEntry) string {
+		path := filepath.Join(dir, "dotfiles-"+ts+".tar.gz")
+		if err := os.WriteFile(path, []byte("archive"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Metadata{Parent: parent, Files: files}).Save(GetMetadataPath(path)); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	zshrc := func(hash string) CatalogEntry { return CatalogEntry{Path: ".zshrc", Size: 10, SHA256: hash} }
+	vimrc := CatalogEntry{Path: ".vimrc", Size: 20, SHA256: "v1"}
+	bashrc := CatalogEntry{Path: ".bashrc", Size: 30, SHA256: "b1"}
+	full := archive("20250101_120000", "", zshrc("z1"), vimrc, bashrc)
+	mid := archive("20250102_120000", filepath.Base(full), zshrc("z2"), vimrc, bashrc)
+	head := archive("20250103_120000", filepath.Base(mid), zshrc("z3"), vimrc)
+
+	result, err := DescribeChain(head)
+	if err != nil || !result.Success {
+		t.Fatalf("DescribeChain() = %+v, %v", result, err)
+	}
+	type link struct {
+		archive     string
+		full        bool
+		files       int
+		contributed []string
+	}
+	want := []link{
+		{head, false, 1, []string{".zshrc"}},
+		{mid, false, 1, []string{}},
+		{full, true, 3, []string{".vimrc"}},
+	}
+	var got []link
+	for _, l := range result.Links {
+		got = append(got, link{l.Archive, l.Full, l.Files, l.Contributed})
+	}
+	if !slices.EqualFunc(got, want, func(a, b link) bool {
+		return a.archive == b.archive && a.full == b.full && a.files == b.files && slices.Equal(a.contributed, b.contributed)
+	}) {
+		t.Errorf("DescribeChain() links = %+v, want %+v", got, want)
+	}
+	if result.Files != 2 || result.Size != 30 || result.ArchiveSize != 3*int64(len("archive")) {
+		t.Errorf("DescribeChain() = %d files, %d bytes in %d bytes of archives; want 2, 30, 21",
+			result.Files, result.Size, result.ArchiveSize)
+	}
+
+	t.Run("missing parent", func(t *testing.T) {
+		t.Parallel()
+		orphan := filepath.Join(t.TempDir(), "dotfiles-20250103_120000.tar.gz")
+		if err := os.WriteFile(orphan, []byte("archive"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Metadata{Parent: "dotfiles-20250102_120000.tar.gz"}).Save(GetMetadataPath(orphan)); err != nil {
+			t.Fatal(err)
+		}
+		result, err := DescribeChain(orphan)
+		if err != nil || result.Success || result.ErrorCode != errs.CodeArchiveNotFound ||
+			len(result.Links) != 2 || !result.Links[1].Missing {
+			t.Errorf("DescribeChain() = %+v, %v; want the missing parent as a link", result, err)
+		}
+	})
+
+	t.Run("archive without metadata", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "dotfiles-20250101_120000.tar.gz")
+		if err := os.WriteFile(path, []byte("archive"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		result, err := DescribeChain(path)
+		if err != nil || !result.Success || len(result.Links) != 1 || !result.Links[0].Full || !result.Links[0].NoCatalog {
+			t.Errorf("DescribeChain() = %+v, %v; want a full backup without catalog", result, err)
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	runs, err := LoadHistory(dir)
+	if err != nil || runs != nil {
+		t.Fatalf("LoadHistory() without a history = %v, %v; want nil, nil", runs, err)
+	}
+
+	first := HistoryEntry{Timestamp: "2026-03-01 09:00:00", Archive: "dotfiles-20260301_090000.tar.gz", Files: 2}
+	second := HistoryEntry{Timestamp: "2026-03-02 09:00:00", Archive: "dotfiles-20260302_090000.tar.gz", Files: 3,
+		Items: []ItemSize{{Path: ".config", Files: 3, Size: 30}}}
+	if err = AppendHistory(dir, second); err != nil {
+		t.Fatal(err)
+	}
+	// a line cut short by a crash, then a run appended from a spool
+	f, err := os.OpenFile(filepath.Join(dir, HistoryFile), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString(`{"timestamp": "2026-03-03`); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if err = AppendHistory(dir, first); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err = LoadHistory(dir)
+	if err != nil {
+		t.Fatalf("LoadHistory() error: %v", err)
+	}
+	if len(runs) != 2 || runs[0].Archive != first.Archive || runs[1].Archive != second.Archive {
+		t.Fatalf("LoadHistory() = %+v, want both runs oldest first", runs)
+	}
+	if !slices.Equal(runs[1].Items, second.Items) {
+		t.Errorf("items = %+v, want %+v", runs[1].Items, second.Items)
+	}
+}
+
+func TestItemSizes(t *testing.T) {
+	t.Parallel()
+
+	files := []CatalogEntry{
+		{Path: ".config/nvim/init.lua", Size: 10},
+		{Path: ".config/nvim/lua/plugins.lua", Size: 20},
+		{Path: ".config/git/config", Size: 5},
+		{Path: ".zshrc", Size: 7},
+		{Path: ".ssh/config", Size: 3},
+	}
+	got := ItemSizes(files, []string{".config", ".config/nvim/", ".zshrc"})
+	want := []ItemSize{
+		{Path: ".config/nvim", Files: 2, Size: 30},
+		{Path: ".zshrc", Files: 1, Size: 7},
+		{Path: ".config", Files: 1, Size: 5},
+		{Path: ".ssh", Files: 1, Size: 3},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ItemSizes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBackupDeltaString(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		delta    BackupDelta
+		expected string
+	}{
+		{BackupDelta{}, "no changes"},
+		{BackupDelta{FilesAdded: 3, FilesRemoved: 1, SizeChange: 2 << 20, FilesChanged: 4},
+			"+3 files, -1 file, +2.00 MB, 4 files changed"},
+		{BackupDelta{SizeChange: -512}, "-512 bytes"},
+		{BackupDelta{FilesChanged: 1}, "1 file changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.delta.String(); got != tt.expected {
+				t.Errorf("String() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
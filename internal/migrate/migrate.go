@@ -0,0 +1,377 @@
+// Package migrate moves dotfiles to a new machine: Export writes a bundle
+// with a full encrypted backup, its package lists, and a plan, and Import
+// follows the plan on the new machine in order (packages, configs, keys,
+// post-restore commands), recording its progress in the bundle so that an
+// interrupted migration resumes where it stopped.
+package migrate
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/pkgmgr"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+// Files of a bundle, next to the archive and the package lists.
+const (
+	PlanFile  = "migrate-plan.json"
+	StateFile = "migrate-state.json"
+)
+
+// Steps of a migration, in the order Import runs them.
+const (
+	StepPackages  = "packages"
+	StepConfigs   = "configs"
+	StepKeys      = "keys"
+	StepPostSteps = "post-steps"
+)
+
+// Steps lists the steps of a migration in order.
+var Steps = []string{StepPackages, StepConfigs, StepKeys, StepPostSteps}
+
+// Step statuses.
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Plan describes a bundle written by Export.
+type Plan struct {
+	Archive  string    `json:"archive"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+	// Sensitive lists the sensitive paths of the old machine, relative to
+	// its home directory, restored by the keys step.
+	Sensitive []string `json:"sensitive,omitempty"`
+	// Packages lists the package managers with a package list in the
+	// bundle.
+	Packages []string `json:"packages,omitempty"`
+}
+
+// State records the progress of Import in a bundle.
+type State struct {
+	Archive string                 `json:"archive"`
+	Steps   []metadata.MigrateStep `json:"steps"`
+	// PostRestore lists the post_restore commands of the restored items,
+	// run by the post-steps step.
+	PostRestore []metadata.PostRestoreResult `json:"post_restore,omitempty"`
+	Checklist   []string                     `json:"checklist,omitempty"`
+}
+
+// ImportOptions holds Import options.
+type ImportOptions struct {
+	DryRun bool
+	// Jobs is the number of packages installed at a time.
+	Jobs int
+	// Output receives the output of package installers; nil discards it.
+	Output io.Writer
+	// Restart runs every step again, ignoring the recorded progress.
+	Restart bool
+}
+
+// Export writes a full backup with its package lists into dir, with the
+// plan Import follows. The backup must be encrypted, since it includes
+// sensitive files. It is neither incremental nor uploaded, and no backups
+// are removed from dir.
+func Export(cfg *config.Config, dir string, opts *backup.Options, sink events.Sink) (*metadata.BackupResult, error) {
+	if method := cmp.Or(opts.EncryptionMethod, cfg.Backup.Encryption, "none"); method == "none" {
+		result := &metadata.BackupResult{}
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid,
+			"a migration bundle includes sensitive files and must be encrypted; pass --encrypt or set backup.encryption"))
+		return result, nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	bundleCfg := *cfg
+	bundleCfg.Backup.BackupDir = abs
+	bundleCfg.Backup.MaxBackups = 0
+	bundleCfg.Backup.SpoolDir = ""
+	bundleCfg.Backup.Storage = ""
+	bundleCfg.Backup.VerifySchedule = ""
+	bundleCfg.Retention = config.RetentionConfig{}
+	bundleCfg.Remote = config.RemoteConfig{}
+
+	exportOpts := *opts
+	exportOpts.Incremental = false
+	exportOpts.IncludeSecrets = true
+	result, err := backup.New(&bundleCfg, &exportOpts, sink).Run()
+	if err != nil || !result.Success || opts.DryRun {
+		return result, err
+	}
+
+	plan := Plan{Archive: filepath.Base(result.Archive), Created: time.Now()}
+	plan.Hostname, _ = os.Hostname()
+	home, _ := osutils.HomeDir()
+	for _, path := range cfg.Sensitive {
+		if rel, relErr := filepath.Rel(home, path); relErr == nil && !strings.HasPrefix(rel, "..") {
+			plan.Sensitive = append(plan.Sensitive, filepath.ToSlash(rel))
+		}
+	}
+	for _, pm := range pkgmgr.Selected(cfg.Packages) {
+		if _, statErr := os.Stat(filepath.Join(abs, pm.File())); statErr == nil {
+			plan.Packages = append(plan.Packages, pm.Name())
+		}
+	}
+	if err = writeJSON(filepath.Join(abs, PlanFile), plan); err != nil {
+		result.Success = false
+		result.SetError(fmt.Errorf("writing %s: %w", PlanFile, err))
+	}
+	return result, nil
+}
+
+// Import restores the bundle in dir on this machine, running the steps not
+// done yet in order and stopping at the first that fails. Progress is saved
+// in the bundle after each step, except in dry runs.
+func Import(cfg *config.Config, dir string, opts ImportOptions, sink events.Sink) (*metadata.MigrateResult, error) {
+	result := &metadata.MigrateResult{Bundle: dir, DryRun: opts.DryRun}
+	plan, err := LoadPlan(dir)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	result.Archive = plan.Archive
+	archive := filepath.Join(dir, plan.Archive)
+	if _, err = os.Stat(archive); err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "bundle archive %s not found", plan.Archive))
+		return result, nil
+	}
+
+	state := LoadState(dir)
+	if opts.Restart || state.Archive != plan.Archive {
+		state = newState(plan.Archive)
+	}
+
+	for i := range state.Steps {
+		step := &state.Steps[i]
+		if step.Status == StatusDone {
+			events.Info(sink, "Step %s already done, skipping\n", step.Name)
+			continue
+		}
+		events.Info(sink, "\nStep %d/%d: %s\n", i+1, len(state.Steps), step.Name)
+		stepErr := runStep(cfg, dir, archive, plan, state, step.Name, opts, sink)
+		if opts.DryRun {
+			if stepErr != nil {
+				step.Status, step.Error = StatusFailed, stepErr.Error()
+			}
+			continue
+		}
+		if stepErr != nil {
+			step.Status, step.Error = StatusFailed, stepErr.Error()
+		} else {
+			step.Status, step.Error = StatusDone, ""
+		}
+		if err = writeJSON(filepath.Join(dir, StateFile), state); err != nil {
+			result.SetError(fmt.Errorf("saving %s: %w", StateFile, err))
+			break
+		}
+		if stepErr != nil {
+			result.SetError(fmt.Errorf("step %s: %w", step.Name, stepErr))
+			break
+		}
+	}
+
+	result.Steps = state.Steps
+	result.Checklist = state.Checklist
+	result.Success = result.Error == ""
+	return result, nil
+}
+
+// Status returns the progress recorded in the bundle in dir without running
+// any step.
+func Status(dir string) (*metadata.MigrateResult, error) {
+	plan, err := LoadPlan(dir)
+	if err != nil {
+		return nil, err
+	}
+	state := LoadState(dir)
+	if state.Archive != plan.Archive {
+		state = newState(plan.Archive)
+	}
+	return &metadata.MigrateResult{
+		Success:   true,
+		Bundle:    dir,
+		Archive:   plan.Archive,
+		Steps:     state.Steps,
+		Checklist: state.Checklist,
+	}, nil
+}
+
+// runStep runs one step of the plan, adding what is left to do by hand to
+// the checklist of state.
+func runStep(cfg *config.Config, dir, archive string, plan *Plan, state *State, name string,
+	opts ImportOptions, sink events.Sink) error {
+	switch name {
+	case StepPackages:
+		for _, manager := range plan.Packages {
+			pm, ok := pkgmgr.Find(manager)
+			if !ok {
+				state.note("Install the %s packages by hand: unknown package manager", manager)
+				continue
+			}
+			if !pm.Available() {
+				state.note("Install %s, then the packages listed in %s", manager, filepath.Join(dir, pm.File()))
+				continue
+			}
+			result, err := pm.Restore(filepath.Join(dir, pm.File()),
+				pkgmgr.RestoreOptions{DryRun: opts.DryRun, Jobs: opts.Jobs, Output: opts.Output}, sink)
+			if err != nil {
+				state.note("Install the %s packages: %v", manager, err)
+				continue
+			}
+			if result.Command != "" {
+				state.note("Install the %s packages with: %s", manager, result.Command)
+			}
+			for _, f := range result.Failed {
+				state.note("Install the %s package %s by hand: %s", manager, f.Package, f.Error)
+			}
+		}
+		return nil
+
+	case StepConfigs:
+		result, err := restoreArchive(cfg, archive, &restore.Options{ExcludeFiles: plan.Sensitive}, opts, sink)
+		if err != nil {
+			return err
+		}
+		state.addPostRestore(result.PostRestore)
+		return nil
+
+	case StepKeys:
+		if len(plan.Sensitive) == 0 {
+			events.Info(sink, "No sensitive files to restore\n")
+			return nil
+		}
+		result, err := restoreArchive(cfg, archive, &restore.Options{Files: plan.Sensitive}, opts, sink)
+		if err != nil {
+			return err
+		}
+		state.addPostRestore(result.PostRestore)
+		return nil
+
+	case StepPostSteps:
+		if opts.DryRun {
+			for _, command := range state.PostRestore {
+				events.Info(sink, "Would run %s\n", command.Command)
+			}
+			return nil
+		}
+		ran, err := restore.RunPostRestore(state.PostRestore, sink)
+		if err != nil {
+			return err
+		}
+		state.PostRestore = ran
+		for _, command := range ran {
+			if command.Error != "" {
+				state.note("Rerun the post_restore command of %s: %s (%s)", command.Path, command.Command, command.Error)
+			}
+		}
+		state.note("Check the restored files with: dotpak check-restore %s", archive)
+		return nil
+	}
+	return fmt.Errorf("unknown step %q", name)
+}
+
+// restoreArchive restores the files of archive selected by restoreOpts over
+// the local files, leaving post_restore commands to the post-steps step.
+func restoreArchive(cfg *config.Config, archive string, restoreOpts *restore.Options, opts ImportOptions,
+	sink events.Sink) (*metadata.RestoreResult, error) {
+	restoreOpts.DryRun = opts.DryRun
+	restoreOpts.Force = true
+	restoreOpts.NoPostRestore = true
+	r := restore.New(cfg, restoreOpts, sink)
+	if r == nil {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "cannot determine home directory")
+	}
+	result, err := r.Run(archive)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, errors.New(result.Error)
+	}
+	return result, nil
+}
+
+// note adds an entry to the checklist, once.
+func (s *State) note(format string, args ...any) {
+	entry := fmt.Sprintf(format, args...)
+	if slices.Contains(s.Checklist, entry) {
+		return
+	}
+	s.Checklist = append(s.Checklist, entry)
+}
+
+// addPostRestore adds the post_restore commands of a restore to those the
+// post-steps step runs, once per item.
+func (s *State) addPostRestore(commands []metadata.PostRestoreResult) {
+	for _, command := range commands {
+		if !slices.ContainsFunc(s.PostRestore, func(c metadata.PostRestoreResult) bool { return c.Path == command.Path }) {
+			s.PostRestore = append(s.PostRestore, command)
+		}
+	}
+}
+
+func newState(archive string) *State {
+	state := &State{Archive: archive}
+	for _, name := range Steps {
+		state.Steps = append(state.Steps, metadata.MigrateStep{Name: name, Status: StatusPending})
+	}
+	return state
+}
+
+// LoadPlan reads the plan of the bundle in dir.
+func LoadPlan(dir string) (*Plan, error) {
+	data, err := os.ReadFile(filepath.Join(dir, PlanFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errs.Errorf(errs.ErrArchiveNotFound,
+				"%s is not a migration bundle: no %s (create one with dotpak migrate export)", dir, PlanFile)
+		}
+		return nil, err
+	}
+	var plan Plan
+	if err = json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", PlanFile, err)
+	}
+	return &plan, nil
+}
+
+// LoadState returns the progress recorded in the bundle in dir, or a state
+// with every step pending if there is none.
+func LoadState(dir string) *State {
+	data, err := os.ReadFile(filepath.Join(dir, StateFile))
+	if err != nil {
+		return newState("")
+	}
+	var state State
+	if json.Unmarshal(data, &state) != nil || len(state.Steps) != len(Steps) {
+		return newState("")
+	}
+	return &state
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
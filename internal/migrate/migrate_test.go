@@ -0,0 +1,155 @@
+package migrate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+)
+
+func createArchive(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzw := gzip.NewWriter(f)
+	defer gzw.Close()
+	tw := tar.NewWriter(gzw)
+	defer tw.Close()
+
+	for name, content := range files {
+		if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExportRequiresEncryption(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.DefaultConfig()
+	cfg.Backup.Encryption = "none"
+	result, err := Export(cfg, t.TempDir(), &backup.Options{}, events.Discard)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if result.Success || result.ErrorCode != errs.Code(errs.ErrConfigInvalid) {
+		t.Errorf("expected a config error for an unencrypted bundle, got %+v", result)
+	}
+}
+
+func TestImport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("post_restore commands use /bin/sh in this test")
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	bundle := t.TempDir()
+
+	archive := "dotfiles-20240115-143022.tar.gz"
+	createArchive(t, filepath.Join(bundle, archive), map[string]string{
+		".zshrc":                "shell config",
+		".ssh/id_ed25519":       "private key",
+		".config/nvim/init.vim": "editor config",
+	})
+	if err := writeJSON(filepath.Join(bundle, PlanFile), Plan{Archive: archive, Sensitive: []string{".ssh"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Backup.BackupDir = t.TempDir()
+	cfg.ItemConfigs = []config.ItemConfig{{Path: ".config/nvim", PostRestore: "touch nvim-ran"}}
+
+	t.Run("dry run changes nothing", func(t *testing.T) {
+		result, err := Import(cfg, bundle, ImportOptions{DryRun: true}, events.Discard)
+		if err != nil || !result.Success {
+			t.Fatalf("Import failed: %v %+v", err, result)
+		}
+		if _, err = os.Stat(filepath.Join(home, ".zshrc")); err == nil {
+			t.Error("dry run restored .zshrc")
+		}
+		if _, err = os.Stat(filepath.Join(bundle, StateFile)); err == nil {
+			t.Error("dry run saved the state")
+		}
+	})
+
+	t.Run("runs the steps in order", func(t *testing.T) {
+		result, err := Import(cfg, bundle, ImportOptions{}, events.Discard)
+		if err != nil || !result.Success {
+			t.Fatalf("Import failed: %v %+v", err, result)
+		}
+		for _, name := range []string{".zshrc", ".ssh/id_ed25519", ".config/nvim/init.vim", "nvim-ran"} {
+			if _, err = os.Stat(filepath.Join(home, name)); err != nil {
+				t.Errorf("%s not restored: %v", name, err)
+			}
+		}
+		var names []string
+		for _, step := range result.Steps {
+			names = append(names, step.Name)
+			if step.Status != StatusDone {
+				t.Errorf("step %s is %s", step.Name, step.Status)
+			}
+		}
+		if !slices.Equal(names, Steps) {
+			t.Errorf("steps = %v, want %v", names, Steps)
+		}
+		if len(result.Checklist) == 0 {
+			t.Error("expected the checklist to suggest check-restore")
+		}
+	})
+
+	t.Run("resumes after the steps done", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(home, ".zshrc")); err != nil {
+			t.Fatal(err)
+		}
+		result, err := Import(cfg, bundle, ImportOptions{}, events.Discard)
+		if err != nil || !result.Success {
+			t.Fatalf("Import failed: %v %+v", err, result)
+		}
+		if _, err = os.Stat(filepath.Join(home, ".zshrc")); err == nil {
+			t.Error("a finished migration ran its steps again")
+		}
+
+		status, err := Status(bundle)
+		if err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if status.Steps[len(status.Steps)-1].Status != StatusDone {
+			t.Errorf("Status = %+v", status)
+		}
+
+		if result, err = Import(cfg, bundle, ImportOptions{Restart: true}, events.Discard); err != nil || !result.Success {
+			t.Fatalf("Import --restart failed: %v %+v", err, result)
+		}
+		if _, err = os.Stat(filepath.Join(home, ".zshrc")); err != nil {
+			t.Errorf("restart did not restore .zshrc: %v", err)
+		}
+	})
+}
+
+func TestImportNotABundle(t *testing.T) {
+	t.Parallel()
+
+	result, err := Import(config.DefaultConfig(), t.TempDir(), ImportOptions{}, events.Discard)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Success || result.ErrorCode != errs.Code(errs.ErrArchiveNotFound) {
+		t.Errorf("expected archive not found, got %+v", result)
+	}
+}
@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"cmp"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/webhook"
+)
+
+// Values of notifications.on.
+const (
+	OnFailure = "failure"
+	OnAlways  = "always"
+	OnNever   = "never"
+)
+
+// Policies lists the values of notifications.on.
+var Policies = []string{OnFailure, OnAlways, OnNever}
+
+// PasswordEnv holds the SMTP password, taking precedence over
+// notifications.smtp_password_file.
+const PasswordEnv = "DOTPAK_SMTP_PASSWORD"
+
+// smtpTimeout bounds the whole SMTP exchange so that an unreachable mail
+// server cannot hang a scheduled backup.
+const smtpTimeout = 30 * time.Second
+
+// Notification is the outcome of an operation, reported by Notify.
+type Notification struct {
+	Event    string // "backup"
+	Success  bool
+	Hostname string
+	Title    string
+	Message  string
+}
+
+// Backup returns the notification of a backup result.
+func Backup(result *metadata.BackupResult) Notification {
+	n := Notification{Event: "backup", Success: result.Success}
+	n.Hostname, _ = os.Hostname()
+	if !result.Success {
+		n.Title = "dotpak: backup FAILED on " + n.Hostname
+		n.Message = result.Error
+		return n
+	}
+	n.Title = "dotpak: backup succeeded on " + n.Hostname
+	name := cmp.Or(result.Snapshot, filepath.Base(result.Archive))
+	n.Message = fmt.Sprintf("%s: %d files, %d bytes", name, result.Stats.FilesBackedUp, result.Stats.ArchiveSize)
+	return n
+}
+
+// Notify sends n through each channel cfg configures, unless cfg.On leaves
+// it out: successes are only sent with OnAlways. A failing channel does not
+// stop the others; their errors are joined.
+func Notify(cfg config.NotificationsConfig, n Notification) error {
+	switch cfg.On {
+	case OnNever:
+		return nil
+	case OnAlways:
+	default:
+		if n.Success {
+			return nil
+		}
+	}
+
+	var errList []error
+	if cfg.Desktop {
+		if err := Send(n.Title, n.Message); err != nil {
+			errList = append(errList, fmt.Errorf("desktop: %w", err))
+		}
+	}
+	if cfg.WebhookURL != "" {
+		if err := postWebhook(cfg.WebhookURL, n); err != nil {
+			errList = append(errList, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if len(cfg.EmailTo) > 0 {
+		if err := sendEmail(cfg, n); err != nil {
+			errList = append(errList, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// webhookPayload is understood by Slack incoming webhooks, which read
+// text, and Discord ones, which read content.
+type webhookPayload struct {
+	Text     string `json:"text"`
+	Content  string `json:"content"`
+	Event    string `json:"event"`
+	Success  bool   `json:"success"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+func postWebhook(url string, n Notification) error {
+	text := n.Title + "\n" + n.Message
+	return webhook.Post(url, "", n.Event, webhookPayload{
+		Text:     text,
+		Content:  text,
+		Event:    n.Event,
+		Success:  n.Success,
+		Hostname: n.Hostname,
+	})
+}
+
+// sendEmail sends n to cfg.EmailTo through cfg.SMTPHost: with implicit TLS
+// on port 465, else with STARTTLS when the server offers it, which
+// authentication then requires.
+func sendEmail(cfg config.NotificationsConfig, n Notification) error {
+	if cfg.SMTPHost == "" || cfg.EmailFrom == "" {
+		return errors.New("notifications.smtp_host and email_from are required to send email")
+	}
+	port := cmp.Or(cfg.SMTPPort, 587)
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		password, err := smtpPassword(cfg.SMTPPasswordFile)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, password, cfg.SMTPHost)
+	}
+
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: cfg.SMTPHost})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && port != 465 {
+		if err = client.StartTLS(&tls.Config{ServerName: cfg.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(cfg.EmailFrom); err != nil {
+		return err
+	}
+	for _, to := range cfg.EmailTo {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(emailMessage(cfg.EmailFrom, cfg.EmailTo, n.Title, n.Message, time.Now())); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailMessage formats a plain-text email.
+func emailMessage(from string, to []string, subject, body string, date time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// smtpPassword returns the SMTP password from PasswordEnv or the first line
+// of passwordFile.
+func smtpPassword(passwordFile string) (string, error) {
+	if password := os.Getenv(PasswordEnv); password != "" {
+		return password, nil
+	}
+	if passwordFile == "" {
+		return "", fmt.Errorf("smtp_username is set but no password: set %s or notifications.smtp_password_file",
+			PasswordEnv)
+	}
+	data, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return "", fmt.Errorf("reading SMTP password: %w", err)
+	}
+	password, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSuffix(password, "\r"), nil
+}
@@ -0,0 +1,45 @@
+// Package notify reports operation results as desktop notifications,
+// webhook posts, and emails.
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Send shows a desktop notification using osascript on macOS and
+// notify-send on Linux.
+func Send(title, message string) error {
+	name, args, err := command(runtime.GOOS, title, message)
+	if err != nil {
+		return err
+	}
+	//nolint:gosec // g204: fixed binary, title and message are passed as arguments
+	if out, runErr := exec.Command(name, args...).CombinedOutput(); runErr != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", name, runErr, msg)
+		}
+		return fmt.Errorf("%s: %w", name, runErr)
+	}
+	return nil
+}
+
+// command returns the notification command for goos. On macOS the title and
+// message are passed as script arguments so they never need quoting.
+func command(goos, title, message string) (string, []string, error) {
+	switch goos {
+	case "darwin":
+		return "osascript", []string{
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+			title, message,
+		}, nil
+	case "linux":
+		return "notify-send", []string{"--app-name=dotpak", title, message}, nil
+	default:
+		return "", nil, fmt.Errorf("desktop notifications are not supported on %s", goos)
+	}
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		goos     string
+		name     string
+		lastArgs []string
+		wantErr  bool
+	}{
+		{"darwin", "osascript", []string{"Title", `say "hi"`}, false},
+		{"linux", "notify-send", []string{"Title", `say "hi"`}, false},
+		{"windows", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			t.Parallel()
+			name, args, err := command(tt.goos, "Title", `say "hi"`)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("command(%q) error = %v, wantErr %v", tt.goos, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name != tt.name {
+				t.Errorf("command(%q) name = %q, want %q", tt.goos, name, tt.name)
+			}
+			if got := args[len(args)-2:]; !slices.Equal(got, tt.lastArgs) {
+				t.Errorf("command(%q) args end with %q, want %q", tt.goos, got, tt.lastArgs)
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var payloads []webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding payload: %v", err)
+		}
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+
+	failed := Backup(&metadata.BackupResult{Error: "disk full"})
+	succeeded := Backup(&metadata.BackupResult{Success: true, Archive: "/backups/dotfiles.tar.gz"})
+
+	tests := []struct {
+		on   string
+		n    Notification
+		sent bool
+	}{
+		{"", failed, true},
+		{"", succeeded, false},
+		{OnAlways, succeeded, true},
+		{OnNever, failed, false},
+	}
+	for _, tt := range tests {
+		payloads = nil
+		cfg := config.NotificationsConfig{On: tt.on, WebhookURL: server.URL}
+		if err := Notify(cfg, tt.n); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+		if sent := len(payloads) == 1; sent != tt.sent {
+			t.Errorf("on=%q success=%v: sent = %v, want %v", tt.on, tt.n.Success, sent, tt.sent)
+		}
+	}
+
+	payloads = nil
+	if err := Notify(config.NotificationsConfig{WebhookURL: server.URL}, failed); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if p := payloads[0]; p.Success || p.Text != p.Content || !strings.Contains(p.Text, "disk full") {
+		t.Errorf("unexpected payload %+v", p)
+	}
+}
+
+func TestNotifyEmailRequiresServer(t *testing.T) {
+	t.Parallel()
+
+	err := Notify(config.NotificationsConfig{EmailTo: []string{"me@example.com"}}, Notification{})
+	if err == nil || !strings.Contains(err.Error(), "smtp_host") {
+		t.Errorf("expected a missing smtp_host error, got %v", err)
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	t.Parallel()
+
+	date := time.Date(2024, 1, 15, 14, 30, 22, 0, time.UTC)
+	got := string(emailMessage("dotpak@example.com", []string{"a@example.com", "b@example.com"},
+		"dotpak: backup FAILED", "disk full\nretry later", date))
+	want := "From: dotpak@example.com\r\n" +
+		"To: a@example.com, b@example.com\r\n" +
+		"Subject: dotpak: backup FAILED\r\n" +
+		"Date: Mon, 15 Jan 2024 14:30:22 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		"disk full\r\nretry later\r\n"
+	if got != want {
+		t.Errorf("emailMessage =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSMTPPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp-password")
+	if err := os.WriteFile(path, []byte("secret\r\nignored\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(PasswordEnv, "")
+	if got, err := smtpPassword(path); err != nil || got != "secret" {
+		t.Errorf("smtpPassword(file) = %q, %v", got, err)
+	}
+	if _, err := smtpPassword(""); err == nil {
+		t.Error("expected an error without a password")
+	}
+
+	t.Setenv(PasswordEnv, "from-env")
+	if got, err := smtpPassword(path); err != nil || got != "from-env" {
+		t.Errorf("smtpPassword(env) = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package osutils
+
+import "os"
+
+// FileIdentity reports false: files have no inode to tell hard links by.
+func FileIdentity(os.FileInfo) (FileID, uint64, bool) {
+	return FileID{}, 0, false
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package osutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileIdentity returns the device and inode of the file info describes, and
+// the number of names linked to it. It reports false if info does not come
+// from the OS.
+func FileIdentity(info os.FileInfo) (FileID, uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, 0, false
+	}
+	//nolint:gosec // g115: device numbers are never negative
+	return FileID{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, uint64(st.Nlink), true
+}
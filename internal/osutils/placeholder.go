@@ -0,0 +1,30 @@
+package osutils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// iCloudStubSuffix is the extension older iCloud Drive releases give the
+// stub left behind when a file is evicted: "notes.txt" becomes ".notes.txt.icloud".
+const iCloudStubSuffix = ".icloud"
+
+// ICloudStubTarget returns the path of the evicted file that the iCloud stub
+// at path stands in for, and whether path is such a stub.
+func ICloudStubTarget(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(filepath.Base(path), ".")
+	if !ok {
+		return "", false
+	}
+	target, ok := strings.CutSuffix(rest, iCloudStubSuffix)
+	if !ok || target == "" {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(path), target), true
+}
+
+// ICloudStubPath returns the path of the iCloud stub that would replace the
+// file at path if it were evicted.
+func ICloudStubPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+iCloudStubSuffix)
+}
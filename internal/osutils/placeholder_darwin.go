@@ -0,0 +1,41 @@
+package osutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sfDataless is the st_flags bit macOS sets on files whose contents are
+// evicted to a cloud provider (iCloud Drive, OneDrive, Dropbox).
+const sfDataless = 0x40000000
+
+// IsCloudPlaceholder reports whether info describes a cloud-synced file whose
+// contents are not stored locally. Reading such a file triggers a download.
+func IsCloudPlaceholder(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Flags&sfDataless != 0
+}
+
+// MaterializeICloudStub asks iCloud Drive to download the evicted file at
+// target and waits up to timeout for it to replace its stub.
+func MaterializeICloudStub(target string, timeout time.Duration) error {
+	//nolint:gosec // g204: target is a path inside the user's home directory
+	if out, err := exec.Command("brctl", "download", target).CombinedOutput(); err != nil {
+		return fmt.Errorf("brctl download: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for iCloud download", timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !windows
+
+package osutils
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// IsCloudPlaceholder reports whether info describes a cloud-synced file whose
+// contents are not stored locally. No such files exist on this platform.
+func IsCloudPlaceholder(_ os.FileInfo) bool {
+	return false
+}
+
+// MaterializeICloudStub is only supported on macOS.
+func MaterializeICloudStub(_ string, _ time.Duration) error {
+	return errors.New("iCloud downloads are only supported on macOS")
+}
@@ -0,0 +1,32 @@
+package osutils
+
+import "testing"
+
+func TestICloudStubTarget(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path     string
+		expected string
+		ok       bool
+	}{
+		{"Documents/.notes.txt.icloud", "Documents/notes.txt", true},
+		{".zshrc.icloud", "zshrc", true},
+		{"Documents/notes.txt.icloud", "", false},
+		{"Documents/.notes.txt", "", false},
+		{".icloud", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+			got, ok := ICloudStubTarget(tt.path)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("ICloudStubTarget(%q) = %q, %v; want %q, %v", tt.path, got, ok, tt.expected, tt.ok)
+			}
+			if ok && ICloudStubPath(got) != tt.path {
+				t.Errorf("ICloudStubPath(%q) = %q, want %q", got, ICloudStubPath(got), tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package osutils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// file attributes set by the Cloud Files API (OneDrive, iCloud for Windows)
+// on files whose contents are not stored locally.
+const (
+	fileAttributeOffline            = 0x00001000
+	fileAttributeRecallOnOpen       = 0x00040000
+	fileAttributeRecallOnDataAccess = 0x00400000
+)
+
+// IsCloudPlaceholder reports whether info describes a cloud-synced file whose
+// contents are not stored locally. Reading such a file triggers a download.
+func IsCloudPlaceholder(info os.FileInfo) bool {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return false
+	}
+	const placeholder = fileAttributeOffline | fileAttributeRecallOnOpen | fileAttributeRecallOnDataAccess
+	return attrs.FileAttributes&placeholder != 0
+}
+
+// MaterializeICloudStub is only supported on macOS.
+func MaterializeICloudStub(_ string, _ time.Duration) error {
+	return errors.New("iCloud downloads are only supported on macOS")
+}
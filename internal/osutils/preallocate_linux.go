@@ -0,0 +1,18 @@
+package osutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: allocate blocks without changing the
+// file size, so a short write never leaves trailing zeros.
+const fallocKeepSize = 0x1
+
+// Preallocate reserves size bytes of disk space for file so that writing it
+// does not fragment or repeatedly extend it. It is advisory: filesystems
+// without fallocate support return an error that callers may ignore.
+func Preallocate(file *os.File, size int64) error {
+	//nolint:gosec // g115: file descriptors fit in int
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+}
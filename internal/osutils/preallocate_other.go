@@ -0,0 +1,10 @@
+//go:build !linux
+
+package osutils
+
+import "os"
+
+// Preallocate is a no-op on this platform.
+func Preallocate(_ *os.File, _ int64) error {
+	return nil
+}
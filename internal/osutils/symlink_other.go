@@ -0,0 +1,9 @@
+//go:build !windows
+
+package osutils
+
+// SymlinkNotPermitted reports false: creating symlinks needs no privilege
+// on this platform.
+func SymlinkNotPermitted(_ error) bool {
+	return false
+}
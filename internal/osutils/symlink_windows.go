@@ -0,0 +1,14 @@
+package osutils
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// SymlinkNotPermitted reports whether err is the failure to create a
+// symlink without the privilege Windows requires for it, which Developer
+// Mode or an elevated shell grants.
+func SymlinkNotPermitted(err error) bool {
+	return errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD)
+}
@@ -28,3 +28,17 @@ func CreateTempFile(pattern string) (*os.File, error) {
 	}
 	return os.CreateTemp(dir, pattern)
 }
+
+// DownloadDir returns the directory (~/.cache/dotpak/downloads/) where
+// archives restored from a URL are cached, creating it with 0700 permissions.
+func DownloadDir() (string, error) {
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".cache", "dotpak", "downloads")
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
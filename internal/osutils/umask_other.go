@@ -0,0 +1,10 @@
+//go:build !unix
+
+package osutils
+
+import "os"
+
+// Umask returns 0: there is no file mode creation mask.
+func Umask() os.FileMode {
+	return 0
+}
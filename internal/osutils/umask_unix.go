@@ -0,0 +1,18 @@
+//go:build unix
+
+package osutils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Umask returns the file mode creation mask of the process. It is read by
+// setting it and back, so it is best called before starting goroutines that
+// create files.
+func Umask() os.FileMode {
+	mask := unix.Umask(0)
+	unix.Umask(mask)
+	return os.FileMode(mask) & 0o777 //nolint:gosec // g115: the mask is at most 0o777
+}
@@ -4,6 +4,9 @@ package osutils
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 )
 
 // FormatSize formats a byte size as a human-readable string.
@@ -26,8 +29,32 @@ func FormatSize(size int64) string {
 	}
 }
 
+// ShellQuote quotes value for a POSIX shell if it contains characters the
+// shell would interpret.
+func ShellQuote(value string) string {
+	if value == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(value, " \t\n'\"\\$") {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "'\"'\"'") + "'"
+}
+
+// homeOverride replaces the user's home directory when set by SetHomeDir.
+var homeOverride string
+
+// SetHomeDir makes HomeDir return dir instead of $HOME, so backups and
+// restores can operate on another user's files. An empty dir clears the override.
+func SetHomeDir(dir string) {
+	homeOverride = dir
+}
+
 // HomeDir returns the user's home directory or an error.
 func HomeDir() (string, error) {
+	if homeOverride != "" {
+		return homeOverride, nil
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("getting home directory: %w", err)
@@ -35,6 +62,12 @@ func HomeDir() (string, error) {
 	return home, nil
 }
 
+// IsTerminal reports whether f is a terminal rather than a file or pipe.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 // Hostname returns the hostname without the domain part, or an error.
 func Hostname() (string, error) {
 	hostname, err := os.Hostname()
@@ -49,5 +82,40 @@ func Hostname() (string, error) {
 	return hostname, nil
 }
 
+// CaseInsensitive reports whether the filesystem of dir, or of its nearest
+// existing parent, treats names that differ only in case as the same file,
+// as macOS and Windows do by default. It creates and removes a probe file;
+// if it cannot, it guesses from the operating system.
+func CaseInsensitive(dir string) bool {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	probe, err := os.CreateTemp(dir, ".dotpak-case-probe-")
+	if err != nil {
+		return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+	}
+	_ = probe.Close()
+	defer os.Remove(probe.Name())
+
+	upper := filepath.Join(dir, strings.ToUpper(filepath.Base(probe.Name())))
+	_, err = os.Stat(upper)
+	return err == nil
+}
+
 const MaxExtractFileSize = 1 << 30   // 1GB
 const MaxExtractTotalSize = 10 << 30 // 10GB
+const MaxExtractEntries = 1 << 20    // about a million
+
+// FileID identifies a file on the OS: names with the same FileID are hard
+// links to one file.
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
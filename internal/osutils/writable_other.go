@@ -0,0 +1,12 @@
+//go:build !unix
+
+package osutils
+
+import "os"
+
+// Writable reports whether path is not read-only. Access control lists are
+// not checked.
+func Writable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().Perm()&0o200 != 0
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package osutils
+
+import "golang.org/x/sys/unix"
+
+// Writable reports whether the current user may write to path, or create
+// files in it if it is a directory.
+func Writable(path string) bool {
+	return unix.Access(path, unix.W_OK) == nil
+}
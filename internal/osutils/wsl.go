@@ -0,0 +1,73 @@
+package osutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// interopTimeout bounds the Windows programs WindowsHome starts, which hang
+// when WSL interop is broken.
+const interopTimeout = 10 * time.Second
+
+// IsWSL reports whether dotpak runs in a Windows Subsystem for Linux
+// distribution.
+func IsWSL() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	return err == nil && strings.Contains(strings.ToLower(string(data)), "microsoft")
+}
+
+// WindowsHome returns the Windows user profile of a WSL distribution as a
+// Linux path, such as /mnt/c/Users/me: the %USERPROFILE% that cmd.exe
+// reports, converted with wslpath. Without interop, it falls back to
+// /mnt/c/Users/$USER if that exists.
+func WindowsHome() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), interopTimeout)
+	defer cancel()
+
+	profile, interopErr := interopOutput(ctx, "cmd.exe", "/d", "/c", "echo %USERPROFILE%")
+	if interopErr == nil && profile != "" && !strings.Contains(profile, "%") {
+		converted, err := interopOutput(ctx, "wslpath", "-u", profile)
+		if err == nil && converted != "" {
+			return converted, nil
+		}
+		interopErr = err
+	}
+
+	if user := os.Getenv("USER"); user != "" {
+		guess := filepath.Join("/mnt/c/Users", user)
+		if info, err := os.Stat(guess); err == nil && info.IsDir() {
+			return guess, nil
+		}
+	}
+	if interopErr == nil {
+		interopErr = errors.New("cmd.exe did not report %USERPROFILE%")
+	}
+	return "", fmt.Errorf("cannot find the Windows user profile (%w); set wsl.windows_home", interopErr)
+}
+
+// interopOutput runs a program and returns its trimmed output. It runs in
+// /mnt/c if that exists, since cmd.exe cannot work in a Linux directory.
+func interopOutput(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if info, err := os.Stat("/mnt/c"); err == nil && info.IsDir() {
+		cmd.Dir = "/mnt/c"
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
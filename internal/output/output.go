@@ -2,12 +2,19 @@
 package output
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/fatih/color"
+
+	"github.com/ospiem/dotpak/internal/osutils"
 )
 
 // Mode represents the output mode.
@@ -27,6 +34,37 @@ type Output struct {
 	errWriter io.Writer
 }
 
+// logger receives the messages of every Output, whatever its mode, when a
+// log file is configured.
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger sends the messages printed from now on to l as well, at the
+// level of their kind: verbose messages at debug, warnings at warn, errors
+// at error, and the others at info. Progress lines are not logged. A nil l
+// stops logging.
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// log sends a message to the logger, if there is one.
+func log(level slog.Level, format string, args ...any) {
+	l := logger.Load()
+	if l == nil {
+		return
+	}
+	if msg := strings.TrimSpace(fmt.Sprintf(format, args...)); msg != "" {
+		l.Log(context.Background(), level, msg)
+	}
+}
+
+// warnings counts the warnings of every Output, whatever its mode.
+var warnings atomic.Int64
+
+// Warnings returns the number of warnings output so far, shown or not.
+func Warnings() int {
+	return int(warnings.Load())
+}
+
 // New creates a new Output with the specified mode.
 func New(mode Mode, verbose bool) *Output {
 	return &Output{
@@ -49,6 +87,7 @@ func (o *Output) SetErrWriter(w io.Writer) {
 
 // Print outputs a message in normal mode.
 func (o *Output) Print(format string, args ...any) {
+	log(slog.LevelInfo, format, args...)
 	if o.mode == ModeQuiet || o.mode == ModeJSON {
 		return
 	}
@@ -57,6 +96,7 @@ func (o *Output) Print(format string, args ...any) {
 
 // Println outputs a message with newline in normal mode.
 func (o *Output) Println(args ...any) {
+	log(slog.LevelInfo, "%s", fmt.Sprint(args...))
 	if o.mode == ModeQuiet || o.mode == ModeJSON {
 		return
 	}
@@ -65,6 +105,7 @@ func (o *Output) Println(args ...any) {
 
 // Verbose outputs only when verbose mode is enabled.
 func (o *Output) Verbose(format string, args ...any) {
+	log(slog.LevelDebug, format, args...)
 	if !o.verbose || o.mode == ModeQuiet || o.mode == ModeJSON {
 		return
 	}
@@ -73,6 +114,7 @@ func (o *Output) Verbose(format string, args ...any) {
 
 // Error outputs to stderr (always shown except in JSON mode).
 func (o *Output) Error(format string, args ...any) {
+	log(slog.LevelError, format, args...)
 	if o.mode == ModeJSON {
 		return
 	}
@@ -81,6 +123,8 @@ func (o *Output) Error(format string, args ...any) {
 
 // Warning outputs a warning message.
 func (o *Output) Warning(format string, args ...any) {
+	warnings.Add(1)
+	log(slog.LevelWarn, format, args...)
 	if o.mode == ModeQuiet || o.mode == ModeJSON {
 		return
 	}
@@ -89,6 +133,7 @@ func (o *Output) Warning(format string, args ...any) {
 
 // Success outputs a success message.
 func (o *Output) Success(format string, args ...any) {
+	log(slog.LevelInfo, format, args...)
 	if o.mode == ModeQuiet || o.mode == ModeJSON {
 		return
 	}
@@ -97,6 +142,7 @@ func (o *Output) Success(format string, args ...any) {
 
 // Info outputs an info message.
 func (o *Output) Info(format string, args ...any) {
+	log(slog.LevelInfo, format, args...)
 	if o.mode == ModeQuiet || o.mode == ModeJSON {
 		return
 	}
@@ -111,6 +157,22 @@ func (o *Output) Progress(current, total int, item string) {
 	fmt.Fprintf(o.writer, "\r[%d/%d] %s", current, total, truncate(item, 60))
 }
 
+// ByteProgress outputs progress in bytes with throughput and, once known,
+// the estimated time remaining.
+func (o *Output) ByteProgress(current, total int, done, totalBytes, rate int64, eta time.Duration, item string) {
+	if o.mode == ModeQuiet || o.mode == ModeJSON {
+		return
+	}
+	line := fmt.Sprintf("\r[%d/%d] %s/%s", current, total, osutils.FormatSize(done), osutils.FormatSize(totalBytes))
+	if rate > 0 {
+		line += fmt.Sprintf(" %s/s", osutils.FormatSize(rate))
+	}
+	if eta > 0 {
+		line += fmt.Sprintf(" ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprintf(o.writer, "%s %s", line, truncate(item, 40))
+}
+
 // ClearProgress clears the progress line.
 func (o *Output) ClearProgress() {
 	if o.mode == ModeQuiet || o.mode == ModeJSON {
@@ -3,8 +3,12 @@ package output
 import (
 	"bytes"
 	"encoding/json"
+	"log/slog"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/events"
 )
 
 func TestNew(t *testing.T) {
@@ -203,6 +207,16 @@ func TestWarning(t *testing.T) {
 			t.Errorf("expected no warning in quiet mode, got %q", buf.String())
 		}
 	})
+
+	t.Run("warnings are counted in every mode", func(t *testing.T) {
+		before := Warnings()
+		New(ModeJSON, false).Warning("Counted but not shown")
+
+		// other tests may warn meanwhile
+		if Warnings() <= before {
+			t.Errorf("Warnings() = %d after a warning, want more than %d", Warnings(), before)
+		}
+	})
 }
 
 func TestSuccess(t *testing.T) {
@@ -293,6 +307,140 @@ func TestClearProgress(t *testing.T) {
 	}
 }
 
+func TestTextSink(t *testing.T) {
+	t.Parallel()
+
+	t.Run("renders messages and clears progress", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := New(ModeNormal, false)
+		out.SetWriter(&buf)
+		sink := NewTextSink(out)
+
+		sink.Emit(events.Event{Kind: events.KindPhase, Phase: events.PhaseArchive, Message: "Creating archive\n"})
+		sink.Emit(events.Event{Kind: events.KindFileStarted, Path: ".zshrc", Current: 1, Total: 2})
+		sink.Emit(events.Event{Kind: events.KindInfo, Message: "interrupting\n"})
+
+		got := buf.String()
+		if !strings.Contains(got, "Creating archive\n") {
+			t.Errorf("expected phase message, got %q", got)
+		}
+		if !strings.Contains(got, "[1/2] .zshrc") {
+			t.Errorf("expected progress line, got %q", got)
+		}
+		if !strings.Contains(got, "\r\033[K"+"interrupting") {
+			t.Errorf("expected progress cleared before message, got %q", got)
+		}
+	})
+
+	t.Run("skips progress when total is unknown", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := New(ModeNormal, false)
+		out.SetWriter(&buf)
+		sink := NewTextSink(out)
+
+		sink.Emit(events.Event{Kind: events.KindFileStarted, Path: ".zshrc", Current: 1})
+		sink.Emit(events.Event{Kind: events.KindPhase, Phase: events.PhaseCleanup})
+
+		if buf.Len() != 0 {
+			t.Errorf("expected no output, got %q", buf.String())
+		}
+	})
+
+	t.Run("renders byte progress instead of file count", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := New(ModeNormal, false)
+		out.SetWriter(&buf)
+		sink := NewTextSink(out)
+
+		sink.Emit(events.Event{Kind: events.KindBytes, Total: 2, TotalBytes: 4096})
+		sink.Emit(events.Event{Kind: events.KindFileStarted, Path: ".zshrc", Current: 1, Total: 2})
+		sink.Emit(events.Event{
+			Kind: events.KindBytes, Path: ".zshrc", Current: 1, Total: 2,
+			Bytes: 2048, TotalBytes: 4096, Rate: 1024, ETA: 2 * time.Second,
+		})
+
+		got := buf.String()
+		if strings.Contains(got, "[1/2] .zshrc") {
+			t.Errorf("expected file count line to be replaced, got %q", got)
+		}
+		if !strings.Contains(got, "[1/2] 2.00 KB/4.00 KB 1.00 KB/s ETA 2s .zshrc") {
+			t.Errorf("expected byte progress line, got %q", got)
+		}
+	})
+
+	t.Run("hides progress when disabled", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := New(ModeNormal, false)
+		out.SetWriter(&buf)
+		sink := NewTextSink(out)
+		sink.SetProgress(false)
+
+		sink.Emit(events.Event{Kind: events.KindFileStarted, Path: ".zshrc", Current: 1, Total: 2})
+		sink.Emit(events.Event{Kind: events.KindBytes, Path: ".zshrc", Current: 1, Total: 2, Bytes: 1, TotalBytes: 2})
+
+		if buf.Len() != 0 {
+			t.Errorf("expected no output, got %q", buf.String())
+		}
+	})
+
+	t.Run("throttles progress redraws", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := New(ModeNormal, false)
+		out.SetWriter(&buf)
+		sink := NewTextSink(out)
+		clock := time.Unix(0, 0)
+		sink.now = func() time.Time { return clock }
+
+		sink.Emit(events.Event{Kind: events.KindFileStarted, Path: ".zshrc", Current: 1, Total: 3})
+		sink.Emit(events.Event{Kind: events.KindFileStarted, Path: ".bashrc", Current: 2, Total: 3})
+		clock = clock.Add(progressInterval)
+		sink.Emit(events.Event{Kind: events.KindFileStarted, Path: ".vimrc", Current: 3, Total: 3})
+
+		got := buf.String()
+		if !strings.Contains(got, "[1/3] .zshrc") || !strings.Contains(got, "[3/3] .vimrc") {
+			t.Errorf("expected first and later progress lines, got %q", got)
+		}
+		if strings.Contains(got, ".bashrc") {
+			t.Errorf("expected redraw within the interval to be skipped, got %q", got)
+		}
+	})
+
+	t.Run("summarizes hidden progress", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := New(ModeNormal, false)
+		out.SetWriter(&buf)
+		sink := NewTextSink(out)
+		sink.SetProgress(false)
+		clock := time.Unix(0, 0)
+		sink.now = func() time.Time { return clock }
+
+		for i := 1; i <= 4; i++ {
+			sink.Emit(events.Event{
+				Kind: events.KindBytes, Path: ".zshrc", Current: i, Total: 4,
+				Bytes: int64(i) * 1024, TotalBytes: 4096,
+			})
+			clock = clock.Add(summaryInterval / 2)
+		}
+
+		got := buf.String()
+		if want := "Progress: 3 of 4 files, 3.00 KB of 4.00 KB\n"; got != want {
+			t.Errorf("expected a single summary line %q, got %q", want, got)
+		}
+	})
+
+	t.Run("detail only in verbose mode", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := New(ModeNormal, false)
+		out.SetWriter(&buf)
+
+		NewTextSink(out).Emit(events.Event{Kind: events.KindDetail, Message: "hidden\n"})
+
+		if buf.Len() != 0 {
+			t.Errorf("expected no output, got %q", buf.String())
+		}
+	})
+}
+
 func TestJSON(t *testing.T) {
 	t.Parallel()
 
@@ -453,3 +601,40 @@ func TestTruncate(t *testing.T) {
 		})
 	}
 }
+
+// TestSetLogger is not parallel, since the logger receives the messages of
+// every Output.
+func TestSetLogger(t *testing.T) {
+	var log bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SetLogger(nil)
+
+	out := New(ModeQuiet, false)
+	out.Print("Backing up %d files\n", 3)
+	out.Verbose("Skipping .bashrc\n")
+	out.Warning("Cannot read .zshrc\n")
+	out.Error("Backup failed\n")
+	out.Progress(1, 3, ".zshrc")
+	out.Print("\n")
+
+	var got []string
+	for line := range strings.Lines(log.String()) {
+		_, record, _ := strings.Cut(strings.TrimSpace(line), " ")
+		got = append(got, record)
+	}
+	want := []string{
+		`level=INFO msg="Backing up 3 files"`,
+		`level=DEBUG msg="Skipping .bashrc"`,
+		`level=WARN msg="Cannot read .zshrc"`,
+		`level=ERROR msg="Backup failed"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("logged\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	SetLogger(nil)
+	out.Print("not logged\n")
+	if strings.Contains(log.String(), "not logged") {
+		t.Error("message logged after SetLogger(nil)")
+	}
+}
@@ -0,0 +1,134 @@
+package output
+
+import (
+	"time"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// progressInterval is the least time between redraws of the progress line,
+// so that archiving tens of thousands of small files is not held up by the
+// terminal.
+const progressInterval = 100 * time.Millisecond
+
+// summaryInterval is how often a hidden progress line is summarized on a
+// line of its own, so that long runs logged by cron show they are alive
+// without a line per file.
+const summaryInterval = 30 * time.Second
+
+// TextSink renders backup and restore events as human-readable CLI output.
+type TextSink struct {
+	out      *Output
+	progress bool
+	// hideProgress suppresses progress lines, e.g. when stdout is not a TTY
+	hideProgress bool
+	// bytes is set once the current phase reports byte progress, which
+	// then replaces the file count line
+	bytes bool
+	// drawn and summarized are when the progress line was last drawn and
+	// last summarized; both are reset by a new phase
+	drawn      time.Time
+	summarized time.Time
+	now        func() time.Time
+}
+
+// NewTextSink creates a TextSink that writes through out.
+func NewTextSink(out *Output) *TextSink {
+	return &TextSink{out: out, now: time.Now}
+}
+
+// SetProgress enables or disables the progress line. It is enabled by
+// default; when disabled, progress is summarized every 30 seconds instead.
+func (t *TextSink) SetProgress(enabled bool) {
+	t.hideProgress = !enabled
+}
+
+// Emit renders a single event.
+func (t *TextSink) Emit(e events.Event) {
+	switch e.Kind {
+	case events.KindFileStarted:
+		// the progress line needs a known total to be meaningful
+		switch {
+		case e.Total <= 0 || t.bytes:
+		case t.hideProgress:
+			t.summarize(e, "Progress: %d of %d files\n", e.Current, e.Total)
+		case t.due():
+			t.out.Progress(e.Current, e.Total, e.Path)
+			t.progress = true
+		}
+	case events.KindFileDone:
+		if e.Total > 0 && e.Current == e.Total {
+			t.clearProgress()
+		}
+	case events.KindBytes:
+		t.bytes = true
+		switch {
+		case e.Current == 0:
+		case t.hideProgress:
+			t.summarize(e, "Progress: %d of %d files, %s of %s\n", e.Current, e.Total,
+				osutils.FormatSize(e.Bytes), osutils.FormatSize(e.TotalBytes))
+		case e.Current == e.Total:
+			t.clearProgress()
+		case t.due():
+			t.out.ByteProgress(e.Current, e.Total, e.Bytes, e.TotalBytes, e.Rate, e.ETA, e.Path)
+			t.progress = true
+		}
+	case events.KindPhase:
+		t.bytes = false
+		t.drawn, t.summarized = time.Time{}, time.Time{}
+		t.clearProgress()
+		if e.Message != "" {
+			t.out.Print("%s", e.Message)
+		}
+	case events.KindInfo:
+		t.clearProgress()
+		if e.Message != "" {
+			t.out.Print("%s", e.Message)
+		}
+	case events.KindDetail:
+		t.clearProgress()
+		t.out.Verbose("%s", e.Message)
+	case events.KindWarning:
+		t.clearProgress()
+		t.out.Warning("%s", e.Message)
+	case events.KindError:
+		t.clearProgress()
+		t.out.Error("%s", e.Message)
+	case events.KindSuccess:
+		t.clearProgress()
+		t.out.Success("%s", e.Message)
+	}
+}
+
+// due reports whether the progress line may be redrawn, at most once per
+// progressInterval.
+func (t *TextSink) due() bool {
+	now := t.now()
+	if !t.drawn.IsZero() && now.Sub(t.drawn) < progressInterval {
+		return false
+	}
+	t.drawn = now
+	return true
+}
+
+// summarize prints a progress line of its own once summaryInterval has
+// passed since the first progress event of the phase or the last summary.
+// The last file of a phase is not summarized, since the phase reports it.
+func (t *TextSink) summarize(e events.Event, format string, args ...any) {
+	now := t.now()
+	switch {
+	case t.summarized.IsZero():
+		t.summarized = now
+	case e.Current < e.Total && now.Sub(t.summarized) >= summaryInterval:
+		t.summarized = now
+		t.out.Print(format, args...)
+	}
+}
+
+func (t *TextSink) clearProgress() {
+	if t.progress {
+		t.out.ClearProgress()
+		t.progress = false
+	}
+}
@@ -0,0 +1,85 @@
+package pkgmgr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// brew snapshots Homebrew formulae, casks, and taps as a Brewfile, restored
+// with brew bundle.
+type brew struct{}
+
+func (brew) Name() string { return "brew" }
+
+func (brew) File() string { return "Brewfile" }
+
+func (brew) Available() bool { return hasBinary("brew") }
+
+func (brew) Dump(path string) (int, error) {
+	if err := exec.Command("brew", "bundle", "dump", "--file="+path, "--force", "--describe").Run(); err != nil {
+		return 0, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// go "..." lines are left to the go package manager's snapshot
+	var kept []string
+	count := 0
+	for line := range strings.SplitSeq(string(content), "\n") {
+		if strings.HasPrefix(line, "go \"") {
+			continue
+		}
+		kept = append(kept, line)
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			count++
+		}
+	}
+	return count, os.WriteFile(path, []byte(strings.Join(kept, "\n")), 0600)
+}
+
+func (brew) Restore(path string, opts RestoreOptions, sink events.Sink) (*metadata.PackageRestoreResult, error) {
+	// brew bundle reads the Brewfile as Ruby; make sure it is the file the
+	// backup wrote and not a symlink to somewhere else
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("brewfile not found: %s", path)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, errors.New("brewfile cannot be a symlink")
+	}
+
+	result := &metadata.PackageRestoreResult{
+		Manager:   "brew",
+		DryRun:    opts.DryRun,
+		Installed: []string{},
+		Failed:    []metadata.PackageFailure{},
+	}
+	events.Info(sink, "Restoring Homebrew packages from %s...\n", path)
+	if opts.DryRun {
+		events.Info(sink, "\nDry run - would run: brew bundle install --file=%s\n", path)
+		result.Success = true
+		return result, nil
+	}
+
+	//nolint:gosec // g204: path is the Brewfile in the backup directory, checked above
+	cmd := exec.Command("brew", "bundle", "install", "--file="+path, "--no-lock")
+	cmd.Stdout, cmd.Stderr = io.Discard, io.Discard
+	if opts.Output != nil {
+		cmd.Stdout, cmd.Stderr = opts.Output, opts.Output
+	}
+	if err = cmd.Run(); err != nil {
+		result.Error = fmt.Sprintf("brew bundle failed: %v", err)
+		return result, nil
+	}
+	result.Success = true
+	return result, nil
+}
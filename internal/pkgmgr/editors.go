@@ -0,0 +1,206 @@
+package pkgmgr
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// Editor extensions are snapshotted like packages: VS Code lists its
+// extensions, and JetBrains IDEs keep their plugins in directories, one
+// per IDE version.
+var (
+	vscode = &listManager{
+		name:   "vscode",
+		binary: "code",
+		file:   "vscode-extensions.txt",
+		list:   []string{"code", "--list-extensions"},
+		parse:  parsePackageLines,
+		install: func(ext string) []string {
+			return []string{"code", "--install-extension", ext}
+		},
+	}
+	// jetbrains snapshots hold "<product> <plugin id>" lines, such as
+	// "GoLand com.github.copilot", and install plugins with the launcher of
+	// the product.
+	jetbrains = &listManager{
+		name: "jetbrains",
+		file: "jetbrains-plugins.txt",
+		available: func() bool {
+			dir, err := jetbrainsDir()
+			if err != nil {
+				return false
+			}
+			info, err := os.Stat(dir)
+			return err == nil && info.IsDir()
+		},
+		dump: func() ([]string, error) {
+			dir, err := jetbrainsDir()
+			if err != nil {
+				return nil, err
+			}
+			return jetbrainsPlugins(dir)
+		},
+		install: func(line string) []string {
+			product, id, _ := strings.Cut(line, " ")
+			return []string{jetbrainsLauncher(product), "installPlugins", strings.TrimSpace(id)}
+		},
+	}
+)
+
+// jetbrainsVersionDir matches the per-version directories of JetBrains
+// IDEs, such as GoLand2024.1.
+var jetbrainsVersionDir = regexp.MustCompile(`^([A-Za-z]+?)(\d{4})\.(\d+)$`)
+
+// jetbrainsLaunchers maps products to their launcher scripts where the two
+// names differ.
+var jetbrainsLaunchers = map[string]string{
+	"IntelliJIdea": "idea",
+	"IdeaIC":       "idea",
+	"PyCharmCE":    "pycharm",
+}
+
+// jetbrainsDir returns the directory holding the per-version directories
+// with installed plugins.
+func jetbrainsDir() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		home, err := osutils.HomeDir()
+		return filepath.Join(home, "Library", "Application Support", "JetBrains"), err
+	case "windows":
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "JetBrains"), nil
+		}
+		return "", errors.New("APPDATA is not set")
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "JetBrains"), nil
+	}
+	home, err := osutils.HomeDir()
+	return filepath.Join(home, ".local", "share", "JetBrains"), err
+}
+
+// jetbrainsPlugins returns the plugins installed in the newest version of
+// each JetBrains IDE under dir.
+func jetbrainsPlugins(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	newest := make(map[string]string)
+	for _, entry := range entries {
+		m := jetbrainsVersionDir.FindStringSubmatch(entry.Name())
+		if m == nil || !entry.IsDir() {
+			continue
+		}
+		if prev, ok := newest[m[1]]; !ok || newerVersionDir(entry.Name(), prev) {
+			newest[m[1]] = entry.Name()
+		}
+	}
+
+	var plugins []string
+	for product, version := range newest {
+		pluginsDir := filepath.Join(dir, version)
+		if runtime.GOOS != "linux" {
+			pluginsDir = filepath.Join(pluginsDir, "plugins")
+		}
+		pluginEntries, readErr := os.ReadDir(pluginsDir)
+		if readErr != nil {
+			continue
+		}
+		for _, entry := range pluginEntries {
+			if id := jetbrainsPluginID(filepath.Join(pluginsDir, entry.Name())); id != "" {
+				plugins = append(plugins, product+" "+id)
+			}
+		}
+	}
+	slices.Sort(plugins)
+	return slices.Compact(plugins), nil
+}
+
+// newerVersionDir reports whether the version directory a is newer than b;
+// both match jetbrainsVersionDir.
+func newerVersionDir(a, b string) bool {
+	version := func(name string) (year, release int) {
+		m := jetbrainsVersionDir.FindStringSubmatch(name)
+		_, _ = fmt.Sscan(m[2], &year)
+		_, _ = fmt.Sscan(m[3], &release)
+		return year, release
+	}
+	aYear, aRelease := version(a)
+	bYear, bRelease := version(b)
+	return aYear > bYear || aYear == bYear && aRelease > bRelease
+}
+
+// jetbrainsPluginID returns the id of the plugin at path, a directory with
+// jars in lib or a single jar, from the META-INF/plugin.xml of its jars. A
+// plugin without an id is known by its name; a directory without
+// plugin.xml, by the directory name. Other files are not plugins.
+func jetbrainsPluginID(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if !info.IsDir() {
+		if !strings.HasSuffix(path, ".jar") {
+			return ""
+		}
+		return pluginXMLID(path)
+	}
+	jars, _ := filepath.Glob(filepath.Join(path, "lib", "*.jar"))
+	for _, jar := range jars {
+		if id := pluginXMLID(jar); id != "" {
+			return id
+		}
+	}
+	return filepath.Base(path)
+}
+
+// pluginXMLID reads the plugin id from META-INF/plugin.xml in jar.
+func pluginXMLID(jar string) string {
+	zr, err := zip.OpenReader(jar)
+	if err != nil {
+		return ""
+	}
+	defer zr.Close()
+	f, err := zr.Open("META-INF/plugin.xml")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var plugin struct {
+		ID   string `xml:"id"`
+		Name string `xml:"name"`
+	}
+	if err = xml.NewDecoder(io.LimitReader(f, 1<<20)).Decode(&plugin); err != nil {
+		return ""
+	}
+	if id := strings.TrimSpace(plugin.ID); id != "" {
+		return id
+	}
+	return strings.TrimSpace(plugin.Name)
+}
+
+// jetbrainsLauncher returns the launcher script of a JetBrains product,
+// such as goland for GoLand.
+func jetbrainsLauncher(product string) string {
+	if launcher, ok := jetbrainsLaunchers[product]; ok {
+		return launcher
+	}
+	return strings.ToLower(product)
+}
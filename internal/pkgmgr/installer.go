@@ -0,0 +1,129 @@
+package pkgmgr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// installAttempts is the number of times a package install is attempted
+// before it is reported as failed.
+const installAttempts = 3
+
+// installRetryDelay is the base delay between install retries; it grows
+// linearly with each attempt.
+const installRetryDelay = 2 * time.Second
+
+// transientInstallMarkers are substrings of installer output that indicate
+// a network hiccup worth retrying rather than a permanent failure.
+var transientInstallMarkers = []string{
+	"i/o timeout",
+	"connection reset",
+	"connection refused",
+	"tls handshake timeout",
+	"temporary failure in name resolution",
+	"no such host",
+	"unexpected eof",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// packageInstaller installs packages with a bounded pool of workers,
+// retrying failures that look transient.
+type packageInstaller struct {
+	jobs       int
+	attempts   int
+	retryDelay time.Duration
+	install    func(pkg string) (string, error)
+}
+
+// run installs packages and records the outcome in result.
+func (p *packageInstaller) run(packages []string, result *metadata.PackageRestoreResult, sink events.Sink) {
+	jobs := min(max(p.jobs, 1), len(packages))
+
+	work := make(chan string)
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		done int
+	)
+
+	for range jobs {
+		wg.Go(func() {
+			for pkg := range work {
+				attempts, err := p.installWithRetry(pkg, sink)
+
+				mu.Lock()
+				done++
+				events.FileStarted(sink, pkg, done, len(packages))
+				events.FileDone(sink, pkg, done, len(packages), 0, err)
+				if err != nil {
+					result.Failed = append(result.Failed, metadata.PackageFailure{
+						Package:  pkg,
+						Attempts: attempts,
+						Error:    err.Error(),
+					})
+				} else {
+					result.Installed = append(result.Installed, pkg)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	for _, pkg := range packages {
+		work <- pkg
+	}
+	close(work)
+	wg.Wait()
+
+	// workers finish in arbitrary order; keep the summary stable
+	sort.Strings(result.Installed)
+	sort.Slice(result.Failed, func(i, j int) bool {
+		return result.Failed[i].Package < result.Failed[j].Package
+	})
+}
+
+// installWithRetry installs a single package and returns the number of
+// attempts made.
+func (p *packageInstaller) installWithRetry(pkg string, sink events.Sink) (int, error) {
+	attempts := max(p.attempts, 1)
+	for attempt := 1; ; attempt++ {
+		cmdOutput, err := p.install(pkg)
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt >= attempts || !isTransientInstallError(cmdOutput) {
+			if msg := lastLine(cmdOutput); msg != "" {
+				return attempt, fmt.Errorf("%w: %s", err, msg)
+			}
+			return attempt, err
+		}
+		events.Detail(sink, "Retrying %s after transient error (attempt %d/%d)\n", pkg, attempt, attempts)
+		time.Sleep(p.retryDelay * time.Duration(attempt))
+	}
+}
+
+// isTransientInstallError reports whether installer output indicates a
+// network failure that may succeed on retry.
+func isTransientInstallError(cmdOutput string) bool {
+	lower := strings.ToLower(cmdOutput)
+	for _, marker := range transientInstallMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,466 @@
+package pkgmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// listManager is a package manager whose snapshot lists one package per
+// line.
+type listManager struct {
+	name string
+	// binary is the executable used to detect the package manager.
+	binary string
+	// available replaces the binary check for package managers without
+	// one, such as editors whose plugins are directories.
+	available func() bool
+	file      string
+	// goos limits the package manager to one operating system.
+	goos string
+	// list is the command that prints the installed packages, turned into
+	// snapshot lines by parse.
+	list  []string
+	parse func(output string) []string
+	// dump replaces list and parse for package managers without a command
+	// that lists packages.
+	dump func() ([]string, error)
+
+	// install returns the command that installs the package of a snapshot
+	// line. It is nil for package managers that need root, whose restore
+	// only suggests command, a shell command with %s for the snapshot path.
+	install func(line string) []string
+	command string
+	// parallel allows installing several packages at a time.
+	parallel bool
+}
+
+func (m *listManager) Name() string { return m.name }
+
+func (m *listManager) File() string { return m.file }
+
+func (m *listManager) Available() bool {
+	if m.goos != "" && m.goos != runtime.GOOS {
+		return false
+	}
+	if m.available != nil {
+		return m.available()
+	}
+	return hasBinary(m.binary)
+}
+
+func (m *listManager) Dump(path string) (int, error) {
+	var packages []string
+	if m.dump != nil {
+		var err error
+		if packages, err = m.dump(); err != nil {
+			return 0, err
+		}
+	} else {
+		out, err := commandOutput(m.list[0], m.list[1:]...)
+		if err != nil {
+			return 0, err
+		}
+		packages = m.parse(out)
+	}
+	if len(packages) == 0 {
+		return 0, nil
+	}
+	return len(packages), os.WriteFile(path, []byte(strings.Join(packages, "\n")+"\n"), 0600)
+}
+
+func (m *listManager) Restore(path string, opts RestoreOptions,
+	sink events.Sink) (*metadata.PackageRestoreResult, error) {
+	if m.goos != "" && m.goos != runtime.GOOS {
+		return nil, fmt.Errorf("%s restore only available on %s", m.name, osName(m.goos))
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found in backup", m.file)
+	}
+
+	result := &metadata.PackageRestoreResult{
+		Manager:   m.name,
+		DryRun:    opts.DryRun,
+		Installed: []string{},
+		Failed:    []metadata.PackageFailure{},
+	}
+
+	if m.install == nil {
+		result.Success = true
+		if opts.DryRun {
+			events.Info(sink, "Dry run - would install packages from: %s\n", path)
+			return result, nil
+		}
+		result.Command = fmt.Sprintf(m.command, osutils.ShellQuote(path))
+		return result, nil
+	}
+
+	packages := parsePackageLines(string(content))
+	if len(packages) == 0 {
+		events.Info(sink, "No %s packages to restore\n", m.name)
+		result.Success = true
+		return result, nil
+	}
+
+	events.Info(sink, "Restoring %d %s packages...\n", len(packages), m.name)
+	if opts.DryRun {
+		events.Info(sink, "\nDry run - would run:\n")
+		for _, pkg := range packages {
+			events.Info(sink, "  %s\n", strings.Join(m.install(pkg), " "))
+		}
+		result.Success = true
+		return result, nil
+	}
+
+	jobs := 1
+	if m.parallel {
+		jobs = opts.Jobs
+	}
+	installer := &packageInstaller{
+		jobs:       jobs,
+		attempts:   installAttempts,
+		retryDelay: installRetryDelay,
+		install: func(pkg string) (string, error) {
+			args := m.install(pkg)
+			//nolint:gosec // g204: packages come from a snapshot written by this tool
+			out, cmdErr := exec.Command(args[0], args[1:]...).CombinedOutput()
+			return string(out), cmdErr
+		},
+	}
+	installer.run(packages, result, sink)
+
+	result.Success = len(result.Failed) == 0
+	return result, nil
+}
+
+func osName(goos string) string {
+	switch goos {
+	case "darwin":
+		return "macOS"
+	case "linux":
+		return "Linux"
+	}
+	return goos
+}
+
+// Linux distribution package managers need root to install, so their
+// restore suggests the command to run.
+var (
+	apt = &listManager{
+		name:    "apt",
+		binary:  "apt-mark",
+		file:    "apt-packages.txt",
+		goos:    "linux",
+		list:    []string{"apt-mark", "showmanual"},
+		parse:   parsePackageLines,
+		command: "xargs sudo apt install -y < %s",
+	}
+	dnf = &listManager{
+		name:    "dnf",
+		binary:  "dnf",
+		file:    "dnf-packages.txt",
+		goos:    "linux",
+		list:    []string{"dnf", "repoquery", "--userinstalled", "--queryformat", "%{name}\n"},
+		parse:   parsePackageLines,
+		command: "xargs sudo dnf install -y < %s",
+	}
+	pacman = &listManager{
+		name:    "pacman",
+		binary:  "pacman",
+		file:    "pacman-packages.txt",
+		goos:    "linux",
+		list:    []string{"pacman", "-Qqe"},
+		parse:   parsePackageLines,
+		command: "sudo pacman -S --needed - < %s",
+	}
+	zypper = &listManager{
+		name:    "zypper",
+		binary:  "zypper",
+		file:    "zypper-packages.txt",
+		goos:    "linux",
+		list:    []string{"zypper", "--quiet", "--non-interactive", "packages", "--userinstalled"},
+		parse:   parseZypperPackages,
+		command: "xargs sudo zypper --non-interactive install < %s",
+	}
+	snap = &listManager{
+		name:    "snap",
+		binary:  "snap",
+		file:    "snap-packages.txt",
+		goos:    "linux",
+		list:    []string{"snap", "list"},
+		parse:   parseSnapList,
+		command: "xargs -L1 sudo snap install < %s",
+	}
+)
+
+var (
+	// mas snapshots keep the `mas list` lines, "<id> <name> (<version>)".
+	mas = &listManager{
+		name:   "mas",
+		binary: "mas",
+		file:   "mas-apps.txt",
+		goos:   "darwin",
+		list:   []string{"mas", "list"},
+		parse:  parsePackageLines,
+		install: func(line string) []string {
+			return []string{"mas", "install", firstField(line)}
+		},
+	}
+	goPackages = &listManager{
+		name:   "go",
+		binary: "go",
+		file:   "go-packages.txt",
+		dump:   goInstalled,
+		install: func(pkg string) []string {
+			return []string{"go", "install", pkg + "@latest"}
+		},
+		parallel: true,
+	}
+	pip = &listManager{
+		name:   "pip",
+		binary: "pip3",
+		file:   "pip-packages.txt",
+		list:   []string{"pip3", "list", "--user", "--not-required", "--format=freeze"},
+		parse:  parsePipFreeze,
+		install: func(pkg string) []string {
+			return []string{"pip3", "install", "--user", pkg}
+		},
+	}
+	npm = &listManager{
+		name:   "npm",
+		binary: "npm",
+		file:   "npm-packages.txt",
+		list:   []string{"npm", "ls", "--global", "--depth=0", "--json"},
+		parse:  parseNpmList,
+		install: func(pkg string) []string {
+			return []string{"npm", "install", "--global", pkg}
+		},
+	}
+	cargo = &listManager{
+		name:   "cargo",
+		binary: "cargo",
+		file:   "cargo-packages.txt",
+		list:   []string{"cargo", "install", "--list"},
+		parse:  parseCargoList,
+		install: func(pkg string) []string {
+			return []string{"cargo", "install", pkg}
+		},
+	}
+	// flatpak snapshots hold "<application> <remote>" lines.
+	flatpak = &listManager{
+		name:   "flatpak",
+		binary: "flatpak",
+		file:   "flatpak-packages.txt",
+		goos:   "linux",
+		list:   []string{"flatpak", "list", "--app", "--columns=application,origin"},
+		parse:  parseFlatpakList,
+		install: func(line string) []string {
+			args := []string{"flatpak", "install", "--noninteractive"}
+			if fields := strings.Fields(line); len(fields) > 1 {
+				return append(args, fields[1], fields[0])
+			}
+			return append(args, line)
+		},
+	}
+)
+
+// goInstalled returns the module paths of the binaries in the Go bin
+// directory.
+func goInstalled() ([]string, error) {
+	goBinDir := os.Getenv("GOBIN")
+	if goBinDir == "" {
+		goPath := os.Getenv("GOPATH")
+		if goPath == "" {
+			home, err := osutils.HomeDir()
+			if err != nil {
+				return nil, err
+			}
+			goPath = filepath.Join(home, "go")
+		}
+		goBinDir = filepath.Join(goPath, "bin")
+	}
+
+	entries, err := os.ReadDir(goBinDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []string
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		// skip directories and non-executable files
+		if infoErr != nil || entry.IsDir() || info.Mode()&0111 == 0 {
+			continue
+		}
+		// go version -m prints "<binary>: <go version>\n\tpath\t<module path>\n..."
+		out, cmdErr := commandOutput("go", "version", "-m", filepath.Join(goBinDir, entry.Name()))
+		if cmdErr != nil {
+			continue
+		}
+		for line := range strings.SplitSeq(out, "\n") {
+			if modulePath, found := strings.CutPrefix(strings.TrimSpace(line), "path\t"); found {
+				if modulePath != "" {
+					packages = append(packages, modulePath)
+				}
+				break
+			}
+		}
+	}
+	slices.Sort(packages)
+	return slices.Compact(packages), nil
+}
+
+// parsePackageLines returns the sorted, de-duplicated non-empty lines of output.
+func parsePackageLines(output string) []string {
+	var packages []string
+	for line := range strings.SplitSeq(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			packages = append(packages, line)
+		}
+	}
+	slices.Sort(packages)
+	return slices.Compact(packages)
+}
+
+// parseZypperPackages extracts package names from the table printed by
+// `zypper packages`:
+//
+//	S  | Repository | Name | Version | Arch
+//	---+------------+------+---------+-------
+//	i+ | repo-oss   | vim  | 9.1     | x86_64
+func parseZypperPackages(output string) []string {
+	var packages []string
+	for line := range strings.SplitSeq(output, "\n") {
+		cols := strings.Split(line, "|")
+		if len(cols) < 3 {
+			continue
+		}
+		status := strings.TrimSpace(cols[0])
+		name := strings.TrimSpace(cols[2])
+		if !strings.HasPrefix(status, "i") || name == "" {
+			continue
+		}
+		packages = append(packages, name)
+	}
+	slices.Sort(packages)
+	return slices.Compact(packages)
+}
+
+// parseSnapList extracts the snaps from the table printed by `snap list`,
+// leaving out bases and snapd, which are installed along with the snaps
+// needing them. Classic snaps keep the --classic flag they install with:
+//
+//	Name    Version  Rev   Tracking       Publisher  Notes
+//	core22  20240111 1122  latest/stable  canonical  base
+//	code    1.89     159   latest/stable  vscode     classic
+func parseSnapList(output string) []string {
+	var packages []string
+	for i, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) == 0 {
+			continue
+		}
+		notes := ""
+		if len(fields) >= 6 {
+			notes = fields[5]
+		}
+		switch {
+		case strings.Contains(notes, "base"), strings.Contains(notes, "snapd"), notes == "core":
+			continue
+		case strings.Contains(notes, "classic"):
+			packages = append(packages, fields[0]+" --classic")
+		default:
+			packages = append(packages, fields[0])
+		}
+	}
+	slices.Sort(packages)
+	return slices.Compact(packages)
+}
+
+// parsePipFreeze extracts package names from "<name>==<version>" lines.
+func parsePipFreeze(output string) []string {
+	var packages []string
+	for line := range strings.SplitSeq(output, "\n") {
+		name, _, _ := strings.Cut(strings.TrimSpace(line), "==")
+		if name != "" && !strings.HasPrefix(name, "#") {
+			packages = append(packages, name)
+		}
+	}
+	slices.Sort(packages)
+	return slices.Compact(packages)
+}
+
+// npmBundled are packages that come with Node.js rather than being
+// installed by the user.
+var npmBundled = []string{"npm", "corepack"}
+
+// parseNpmList extracts the top-level packages from `npm ls --json`.
+func parseNpmList(output string) []string {
+	var list struct {
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return nil
+	}
+	var packages []string
+	for name := range list.Dependencies {
+		if !slices.Contains(npmBundled, name) {
+			packages = append(packages, name)
+		}
+	}
+	slices.Sort(packages)
+	return packages
+}
+
+// parseCargoList extracts crate names from `cargo install --list`, which
+// prints each crate followed by its indented binaries:
+//
+//	ripgrep v14.1.0:
+//	    rg
+func parseCargoList(output string) []string {
+	var packages []string
+	for line := range strings.SplitSeq(output, "\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		packages = append(packages, firstField(line))
+	}
+	slices.Sort(packages)
+	return slices.Compact(packages)
+}
+
+// parseFlatpakList turns the tab-separated application and origin columns
+// of `flatpak list` into "<application> <remote>" lines.
+func parseFlatpakList(output string) []string {
+	var packages []string
+	for line := range strings.SplitSeq(output, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			packages = append(packages, strings.Join(fields, " "))
+		}
+	}
+	slices.Sort(packages)
+	return slices.Compact(packages)
+}
+
+// firstField returns the first whitespace-separated field of s.
+func firstField(s string) string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
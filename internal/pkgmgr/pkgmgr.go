@@ -0,0 +1,129 @@
+// Package pkgmgr snapshots the packages installed with package managers
+// into the backup directory and reinstalls them on restore.
+package pkgmgr
+
+import (
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// PackageManager snapshots and reinstalls the packages of one package
+// manager.
+type PackageManager interface {
+	// Name identifies the package manager in the config and in
+	// `restore --packages`.
+	Name() string
+	// File is the snapshot file name inside the backup directory.
+	File() string
+	// Available reports whether the package manager is installed.
+	Available() bool
+	// Dump writes a snapshot of the installed packages to path and returns
+	// the number of packages. Nothing is written if there are none.
+	Dump(path string) (int, error)
+	// Restore reinstalls the packages in the snapshot at path. Packages that
+	// fail to install are recorded in the result; the error is for a
+	// snapshot that cannot be restored at all.
+	Restore(path string, opts RestoreOptions, sink events.Sink) (*metadata.PackageRestoreResult, error)
+}
+
+// RestoreOptions holds package restore options.
+type RestoreOptions struct {
+	DryRun bool
+	// Jobs is the number of packages installed at a time by package
+	// managers that allow it.
+	Jobs int
+	// Output receives the output of installers that report their own
+	// progress, such as brew bundle; nil discards it.
+	Output io.Writer
+}
+
+// managers lists the supported package managers in snapshot order.
+var managers = []PackageManager{
+	brew{},
+	mas,
+	apt,
+	dnf,
+	pacman,
+	zypper,
+	goPackages,
+	pip,
+	npm,
+	cargo,
+	flatpak,
+	snap,
+	vscode,
+	jetbrains,
+}
+
+// Names returns the names of the supported package managers.
+func Names() []string {
+	names := make([]string, 0, len(managers))
+	for _, pm := range managers {
+		names = append(names, pm.Name())
+	}
+	return names
+}
+
+// Find returns the package manager with the given name.
+func Find(name string) (PackageManager, bool) {
+	for _, pm := range managers {
+		if pm.Name() == name {
+			return pm, true
+		}
+	}
+	return nil, false
+}
+
+// Selected returns the package managers cfg selects for snapshots, whether
+// or not they are installed.
+func Selected(cfg config.PackagesConfig) []PackageManager {
+	var selected []PackageManager
+	for _, pm := range managers {
+		if len(cfg.Managers) > 0 && !slices.Contains(cfg.Managers, pm.Name()) {
+			continue
+		}
+		if slices.Contains(cfg.Skip, pm.Name()) {
+			continue
+		}
+		selected = append(selected, pm)
+	}
+	return selected
+}
+
+// Snapshot dumps the packages of each selected package manager that is
+// installed into dir. Failures are reported to sink and do not stop the
+// other package managers.
+func Snapshot(cfg config.PackagesConfig, dir string, sink events.Sink) {
+	for _, pm := range Selected(cfg) {
+		if !pm.Available() {
+			continue
+		}
+		n, err := pm.Dump(filepath.Join(dir, pm.File()))
+		switch {
+		case err != nil:
+			events.Detail(sink, "%s backup failed: %v\n", pm.Name(), err)
+		case n == 0:
+			events.Detail(sink, "No %s packages found to backup\n", pm.Name())
+		default:
+			events.Detail(sink, "%s packages saved to %s (%d packages)\n", pm.Name(), pm.File(), n)
+		}
+	}
+}
+
+// hasBinary reports whether name is found in PATH.
+func hasBinary(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// commandOutput runs a command and returns its standard output.
+func commandOutput(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	return string(out), err
+}
@@ -0,0 +1,368 @@
+package pkgmgr
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	// snapshot file names must not change, or older backups cannot be restored
+	files := map[string]string{
+		"brew":   "Brewfile",
+		"mas":    "mas-apps.txt",
+		"apt":    "apt-packages.txt",
+		"dnf":    "dnf-packages.txt",
+		"pacman": "pacman-packages.txt",
+		"zypper": "zypper-packages.txt",
+		"go":     "go-packages.txt",
+		// editor extensions
+		"vscode":    "vscode-extensions.txt",
+		"jetbrains": "jetbrains-plugins.txt",
+	}
+	for name, file := range files {
+		pm, ok := Find(name)
+		if !ok {
+			t.Errorf("expected %s to be supported", name)
+			continue
+		}
+		if pm.File() != file {
+			t.Errorf("unexpected file for %s: %s", name, pm.File())
+		}
+	}
+
+	for _, name := range []string{"pip", "npm", "cargo", "flatpak", "snap"} {
+		if _, ok := Find(name); !ok {
+			t.Errorf("expected %s to be supported", name)
+		}
+	}
+	if _, ok := Find("emerge"); ok {
+		t.Error("expected unknown package manager to be rejected")
+	}
+}
+
+func TestSelected(t *testing.T) {
+	t.Parallel()
+
+	names := func(pms []PackageManager) []string {
+		var out []string
+		for _, pm := range pms {
+			out = append(out, pm.Name())
+		}
+		return out
+	}
+
+	if got := names(Selected(config.PackagesConfig{})); !slices.Equal(got, Names()) {
+		t.Errorf("default selection = %v, want all", got)
+	}
+	got := names(Selected(config.PackagesConfig{Managers: []string{"npm", "go", "cargo"}, Skip: []string{"cargo"}}))
+	if want := []string{"go", "npm"}; !slices.Equal(got, want) {
+		t.Errorf("selection = %v, want %v", got, want)
+	}
+}
+
+func TestListManagerRestore(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "go-packages.txt")
+	if err := os.WriteFile(path, []byte("example.com/b\nexample.com/a\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := goPackages.Restore(path, RestoreOptions{DryRun: true}, events.Discard)
+	if err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if !result.Success || !result.DryRun || len(result.Installed) != 0 {
+		t.Errorf("dry run result = %+v", result)
+	}
+
+	if _, err = goPackages.Restore(filepath.Join(dir, "missing.txt"), RestoreOptions{}, events.Discard); err == nil {
+		t.Error("expected error for a missing snapshot")
+	}
+
+	if runtime.GOOS == "linux" {
+		aptFile := filepath.Join(dir, "apt packages.txt")
+		if err = os.WriteFile(aptFile, []byte("vim\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		result, err = apt.Restore(aptFile, RestoreOptions{}, events.Discard)
+		if err != nil {
+			t.Fatalf("Restore() error: %v", err)
+		}
+		if want := "xargs sudo apt install -y < '" + aptFile + "'"; result.Command != want {
+			t.Errorf("Command = %q, want %q", result.Command, want)
+		}
+	}
+}
+
+func TestParsers(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		parse  func(string) []string
+		output string
+		want   []string
+	}{
+		{"lines", parsePackageLines, "vim\n  git \n\nvim\ncurl\n", []string{"curl", "git", "vim"}},
+		{
+			"zypper", parseZypperPackages,
+			`S  | Repository | Name     | Version | Arch
+---+------------+----------+---------+-------
+i+ | repo-oss   | vim      | 9.1     | x86_64
+i+ | repo-oss   | git-core | 2.45    | x86_64
+v  | repo-oss   | emacs    | 29.4    | x86_64
+`,
+			[]string{"git-core", "vim"},
+		},
+		{
+			"snap", parseSnapList,
+			`Name    Version   Rev    Tracking       Publisher   Notes
+core22  20240111  1122   latest/stable  canonical✓  base
+snapd   2.61      20671  latest/stable  canonical✓  snapd
+code    1.89      159    latest/stable  vscode✓     classic
+spotify 1.2       75     latest/stable  spotify✓    -
+`,
+			[]string{"code --classic", "spotify"},
+		},
+		{"pip", parsePipFreeze, "black==24.1.0\nhttpie==3.2.2\n", []string{"black", "httpie"}},
+		{
+			"npm", parseNpmList,
+			`{"dependencies": {"typescript": {"version": "5.4.5"}, "npm": {"version": "10.5.0"}, "pnpm": {}}}`,
+			[]string{"pnpm", "typescript"},
+		},
+		{"npm without packages", parseNpmList, `{}`, nil},
+		{"cargo", parseCargoList, "ripgrep v14.1.0:\n    rg\nbat v0.24.0:\n    bat\n", []string{"bat", "ripgrep"}},
+		{
+			"flatpak", parseFlatpakList,
+			"org.mozilla.firefox\tflathub\ncom.spotify.Client\tflathub\n",
+			[]string{"com.spotify.Client flathub", "org.mozilla.firefox flathub"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.parse(tt.output); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstallCommands(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pm   *listManager
+		line string
+		want string
+	}{
+		{mas, "497799835  Xcode  (15.0)", "mas install 497799835"},
+		{goPackages, "golang.org/x/tools/gopls", "go install golang.org/x/tools/gopls@latest"},
+		{flatpak, "org.mozilla.firefox flathub", "flatpak install --noninteractive flathub org.mozilla.firefox"},
+		{cargo, "ripgrep", "cargo install ripgrep"},
+		{vscode, "golang.go", "code --install-extension golang.go"},
+		{jetbrains, "GoLand com.github.copilot", "goland installPlugins com.github.copilot"},
+		{jetbrains, "IdeaIC org.jetbrains.kotlin", "idea installPlugins org.jetbrains.kotlin"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(tt.pm.install(tt.line), " "); got != tt.want {
+			t.Errorf("%s install = %q, want %q", tt.pm.name, got, tt.want)
+		}
+	}
+}
+
+func TestJetbrainsPlugins(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	plugins := func(version string) string {
+		if runtime.GOOS == "linux" {
+			return filepath.Join(dir, version)
+		}
+		return filepath.Join(dir, version, "plugins")
+	}
+	writeJar := func(path, pluginXML string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		w, err := zw.Create("META-INF/plugin.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(pluginXML))
+		if err = zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	writeJar(filepath.Join(plugins("GoLand2024.2"), "copilot", "lib", "copilot.jar"),
+		"<idea-plugin><id>com.github.copilot</id><name>GitHub Copilot</name></idea-plugin>")
+	writeJar(filepath.Join(plugins("GoLand2024.2"), "ideavim.jar"), "<idea-plugin><name>IdeaVim</name></idea-plugin>")
+	if err := os.MkdirAll(filepath.Join(plugins("GoLand2024.10"), "rainbow"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	// older versions and other directories are left out
+	writeJar(filepath.Join(plugins("GoLand2023.3"), "old", "lib", "old.jar"), "<idea-plugin><id>old</id></idea-plugin>")
+	if err := os.MkdirAll(filepath.Join(dir, "consentOptions"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := jetbrainsPlugins(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"GoLand rainbow"}; !slices.Equal(got, want) {
+		t.Errorf("jetbrainsPlugins() = %v, want %v", got, want)
+	}
+
+	if err = os.RemoveAll(filepath.Join(dir, "GoLand2024.10")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = jetbrainsPlugins(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"GoLand IdeaVim", "GoLand com.github.copilot"}; !slices.Equal(got, want) {
+		t.Errorf("jetbrainsPlugins() = %v, want %v", got, want)
+	}
+}
+
+func TestCommandOutput(t *testing.T) {
+	t.Parallel()
+
+	if out, err := commandOutput("echo", "hello"); err != nil || out != "hello\n" {
+		t.Errorf("commandOutput() = %q, %v", out, err)
+	}
+	if _, err := commandOutput("false"); err == nil {
+		t.Error("expected error for failed command")
+	}
+	if _, err := commandOutput("nonexistent-command-12345"); err == nil {
+		t.Error("expected error for nonexistent command")
+	}
+}
+
+func TestIsTransientInstallError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected bool
+	}{
+		{"timeout", "dial tcp: i/o timeout", true},
+		{"dns", "dial tcp: lookup proxy.golang.org: Temporary failure in name resolution", true},
+		{"bad gateway", "reading https://proxy.golang.org/...: 502 Bad Gateway", true},
+		{"unknown module", "go: example.com/nope@latest: module not found", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientInstallError(tt.output); got != tt.expected {
+				t.Errorf("isTransientInstallError(%q) = %v, want %v", tt.output, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPackageInstaller(t *testing.T) {
+	t.Parallel()
+
+	sink := events.Discard
+
+	t.Run("installs all packages and sorts summary", func(t *testing.T) {
+		installer := &packageInstaller{
+			jobs:     3,
+			attempts: 1,
+			install: func(pkg string) (string, error) {
+				if pkg == "example.com/broken" {
+					return "module not found", errors.New("exit status 1")
+				}
+				return "", nil
+			},
+		}
+
+		result := &metadata.PackageRestoreResult{}
+		installer.run([]string{"example.com/c", "example.com/broken", "example.com/a", "example.com/b"}, result, sink)
+
+		want := []string{"example.com/a", "example.com/b", "example.com/c"}
+		if !slices.Equal(result.Installed, want) {
+			t.Errorf("installed = %v, want %v", result.Installed, want)
+		}
+		if len(result.Failed) != 1 || result.Failed[0].Package != "example.com/broken" {
+			t.Fatalf("unexpected failures: %+v", result.Failed)
+		}
+		if !strings.Contains(result.Failed[0].Error, "module not found") {
+			t.Errorf("expected installer output in error, got %q", result.Failed[0].Error)
+		}
+	})
+
+	t.Run("retries transient failures", func(t *testing.T) {
+		var calls atomic.Int32
+		installer := &packageInstaller{
+			jobs:     1,
+			attempts: 3,
+			install: func(_ string) (string, error) {
+				if calls.Add(1) < 3 {
+					return "dial tcp: i/o timeout", errors.New("exit status 1")
+				}
+				return "", nil
+			},
+		}
+
+		result := &metadata.PackageRestoreResult{}
+		installer.run([]string{"example.com/flaky"}, result, sink)
+
+		if len(result.Installed) != 1 {
+			t.Fatalf("expected package to install after retries, got %+v", result)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("expected 3 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("does not retry permanent failures", func(t *testing.T) {
+		var calls atomic.Int32
+		installer := &packageInstaller{
+			jobs:     1,
+			attempts: 3,
+			install: func(_ string) (string, error) {
+				calls.Add(1)
+				return "module not found", errors.New("exit status 1")
+			},
+		}
+
+		result := &metadata.PackageRestoreResult{}
+		installer.run([]string{"example.com/missing"}, result, sink)
+
+		if calls.Load() != 1 {
+			t.Errorf("expected 1 attempt, got %d", calls.Load())
+		}
+		if len(result.Failed) != 1 || result.Failed[0].Attempts != 1 {
+			t.Errorf("unexpected failures: %+v", result.Failed)
+		}
+	})
+}
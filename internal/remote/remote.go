@@ -0,0 +1,161 @@
+// Package remote uploads backup archives to, and fetches them from, remote
+// storage: S3 (and S3-compatible services), SFTP, and WebDAV.
+package remote
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// PasswordEnv is the environment variable holding the WebDAV password, so
+// that it does not have to be stored in the config file.
+const PasswordEnv = "DOTPAK_REMOTE_PASSWORD"
+
+// timeout bounds a single transfer.
+const timeout = 10 * time.Minute
+
+// Object is a file stored on the remote.
+type Object struct {
+	Name string
+	Size int64
+}
+
+// Remote is a storage backend holding backup files in a single directory.
+type Remote interface {
+	// Upload copies the local file to the remote under its base name.
+	Upload(localPath string) error
+	// Download copies the remote file name to localPath.
+	Download(name, localPath string) error
+	// List returns the files in the remote directory.
+	List() ([]Object, error)
+	// Delete removes the remote file name; a file that does not exist is
+	// not an error.
+	Delete(name string) error
+	// String returns the remote location for display.
+	String() string
+}
+
+// Options holds backend settings that do not fit in the URL.
+type Options struct {
+	// Region is the S3 region (default us-east-1).
+	Region string
+	// Endpoint is the base URL of an S3-compatible service (MinIO, R2, ...).
+	Endpoint string
+	// Username and Password authenticate WebDAV requests.
+	Username string
+	Password string
+}
+
+// New returns the backend for rawURL:
+//
+//	s3://bucket/prefix
+//	sftp://user@host[:port]/path
+//	https://host/path (WebDAV)
+//
+// S3 credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN; SFTP uses the ssh configuration and agent.
+func New(rawURL string, opts Options) (Remote, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "invalid remote URL %q: %w", rawURL, err)
+	}
+	if u.Host == "" {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "invalid remote URL %q: missing host", rawURL)
+	}
+	dir := strings.Trim(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return newS3(u.Host, dir, opts), nil
+	case "sftp":
+		return newSFTP(u, dir), nil
+	case "http", "https":
+		if opts.Password == "" {
+			opts.Password = os.Getenv(PasswordEnv)
+		}
+		return newWebDAV(u, opts), nil
+	default:
+		return nil, errs.Errorf(errs.ErrConfigInvalid,
+			"unsupported remote URL scheme %q (use s3, sftp, or https)", u.Scheme)
+	}
+}
+
+// Fetch downloads the archive name and its metadata file into dir and
+// returns the local archive path. For an incremental backup the parent
+// archives are fetched as well, so the whole chain can be restored. Files
+// already present in dir are not downloaded again, since archive names are
+// unique timestamps.
+func Fetch(r Remote, name, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	var archive string
+	seen := make(map[string]bool)
+	for next := name; next != "" && !seen[next]; {
+		if next != path.Base(next) || next == "." || next == ".." {
+			return "", fmt.Errorf("invalid remote archive name: %s", next)
+		}
+		seen[next] = true
+		local := filepath.Join(dir, next)
+		if archive == "" {
+			archive = local
+		}
+		if err := fetchOnce(r, next, local); err != nil {
+			return "", errs.Errorf(errs.ErrArchiveNotFound, "downloading %s from %s: %w", next, r, err)
+		}
+
+		// archives from older versions may have no metadata
+		metaPath := metadata.GetMetadataPath(local)
+		next = ""
+		if fetchOnce(r, filepath.Base(metaPath), metaPath) == nil {
+			if meta, err := metadata.Load(metaPath); err == nil {
+				next = meta.Parent
+			}
+		}
+	}
+	return archive, nil
+}
+
+// FetchMetadata downloads the metadata file of the named archive into dir,
+// unless it is there already, and loads it.
+func FetchMetadata(r Remote, name, dir string) (*metadata.Metadata, error) {
+	if name != path.Base(name) || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid remote archive name: %s", name)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	metaPath := metadata.GetMetadataPath(filepath.Join(dir, name))
+	if err := fetchOnce(r, filepath.Base(metaPath), metaPath); err != nil {
+		return nil, fmt.Errorf("downloading %s from %s: %w", filepath.Base(metaPath), r, err)
+	}
+	return metadata.Load(metaPath)
+}
+
+func fetchOnce(r Remote, name, local string) error {
+	if _, err := os.Stat(local); err == nil {
+		return nil
+	}
+	tmp := local + ".part"
+	if err := r.Download(name, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, local)
+}
+
+// sortObjects orders objects by name, which for backups is by timestamp.
+func sortObjects(objects []Object) []Object {
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects
+}
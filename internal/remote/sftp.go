@@ -0,0 +1,132 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// sftp stores files in a directory on an SSH server, using the system sftp
+// client in batch mode so that ~/.ssh/config, keys, and the agent apply.
+type sftp struct {
+	host string // [user@]host
+	port string
+	dir  string // relative to the login directory unless absolute
+}
+
+func newSFTP(u *url.URL, dir string) *sftp {
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	if strings.HasPrefix(u.Path, "//") {
+		// sftp://host//abs/path
+		dir = "/" + dir
+	}
+	return &sftp{host: host, port: u.Port(), dir: dir}
+}
+
+func (s *sftp) String() string {
+	target := s.host
+	if s.port != "" {
+		target += ":" + s.port
+	}
+	return "sftp://" + target + "/" + strings.TrimPrefix(s.dir, "/")
+}
+
+func (s *sftp) path(name string) string {
+	if s.dir == "" {
+		return name
+	}
+	return path.Join(s.dir, name)
+}
+
+// run executes batch commands. Commands prefixed with "-" may fail without
+// aborting the batch.
+func (s *sftp) run(commands ...string) (string, error) {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+	args = append(args, s.host)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	//nolint:gosec // g204: arguments come from the user's remote config
+	cmd := exec.CommandContext(ctx, "sftp", args...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("sftp: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("sftp: %w", err)
+	}
+	return stdout.String(), nil
+}
+
+// quote quotes a path for an sftp batch command.
+func quote(p string) string {
+	return `"` + strings.ReplaceAll(p, `"`, `\"`) + `"`
+}
+
+func (s *sftp) Upload(localPath string) error {
+	var commands []string
+	if s.dir != "" {
+		commands = append(commands, "-mkdir "+quote(s.dir))
+	}
+	commands = append(commands, "put "+quote(localPath)+" "+quote(s.path(path.Base(localPath))))
+	_, err := s.run(commands...)
+	return err
+}
+
+func (s *sftp) Download(name, localPath string) error {
+	_, err := s.run("get " + quote(s.path(name)) + " " + quote(localPath))
+	return err
+}
+
+func (s *sftp) Delete(name string) error {
+	_, err := s.run("-rm " + quote(s.path(name)))
+	return err
+}
+
+func (s *sftp) List() ([]Object, error) {
+	dir := s.dir
+	if dir == "" {
+		dir = "."
+	}
+	out, err := s.run("ls -l " + quote(dir))
+	if err != nil {
+		return nil, err
+	}
+	return parseLsOutput(out), nil
+}
+
+// parseLsOutput parses "ls -l" output from sftp, e.g.
+//
+//	-rw-------    1 user     staff        1234 Jan 15 14:30 backups/dotfiles-20240115_143022.tar.gz
+//
+// Directories, links, and the echoed commands are skipped.
+func parseLsOutput(out string) []Object {
+	var objects []Object
+	for line := range strings.SplitSeq(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		objects = append(objects, Object{Name: path.Base(strings.Join(fields[8:], " ")), Size: size})
+	}
+	return sortObjects(objects)
+}
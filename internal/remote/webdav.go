@@ -0,0 +1,175 @@
+package remote
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// webDAV stores files in a WebDAV collection.
+type webDAV struct {
+	base     *url.URL // collection URL, with a trailing slash
+	username string
+	password string
+	client   *http.Client
+}
+
+func newWebDAV(u *url.URL, opts Options) *webDAV {
+	base := *u
+	base.User = nil
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	username, password := opts.Username, opts.Password
+	if u.User != nil && username == "" {
+		username = u.User.Username()
+	}
+	return &webDAV{
+		base:     &base,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (w *webDAV) String() string {
+	return w.base.String()
+}
+
+func (w *webDAV) do(method, name string, body io.Reader, size int64, header map[string]string) (*http.Response, error) {
+	target := w.base.JoinPath(name)
+	req, err := http.NewRequestWithContext(context.Background(), method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("User-Agent", "dotpak")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	return w.client.Do(req)
+}
+
+func (w *webDAV) Upload(localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// create the collection; fails harmlessly if it already exists
+	if resp, mkErr := w.do("MKCOL", "", nil, 0, nil); mkErr == nil {
+		_ = resp.Body.Close()
+	}
+
+	resp, err := w.do(http.MethodPut, path.Base(localPath), file, info.Size(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", path.Base(localPath), resp.Status)
+	}
+	return nil
+}
+
+func (w *webDAV) Download(name, localPath string) error {
+	resp, err := w.do(http.MethodGet, name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+	return writeFile(localPath, resp.Body)
+}
+
+func (w *webDAV) Delete(name string) error {
+	resp, err := w.do(http.MethodDelete, name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE %s: %s", name, resp.Status)
+	}
+	return nil
+}
+
+// multistatus is the subset of a PROPFIND response dotpak reads.
+type multistatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+		Prop struct {
+			Size       int64     `xml:"getcontentlength"`
+			Collection *xml.Name `xml:"resourcetype>collection"`
+		} `xml:"propstat>prop"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><getcontentlength/><resourcetype/></prop></propfind>`
+
+func (w *webDAV) List() ([]Object, error) {
+	resp, err := w.do("PROPFIND", "", strings.NewReader(propfindBody), int64(len(propfindBody)),
+		map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND: %s", resp.Status)
+	}
+
+	var ms multistatus
+	if err = xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("parsing PROPFIND response: %w", err)
+	}
+
+	var objects []Object
+	for _, r := range ms.Responses {
+		if r.Prop.Collection != nil {
+			continue
+		}
+		href, unescapeErr := url.PathUnescape(r.Href)
+		if unescapeErr != nil {
+			href = r.Href
+		}
+		objects = append(objects, Object{Name: path.Base(href), Size: r.Prop.Size})
+	}
+	return sortObjects(objects), nil
+}
+
+// writeFile writes r to a new file at path with owner-only permissions.
+func writeFile(path string, r io.Reader) (err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(file, r)
+	return err
+}
@@ -0,0 +1,230 @@
+package restore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// zipMagic starts every zip file that has at least one entry.
+var zipMagic = []byte("PK\x03\x04")
+
+// maxZipLinkTarget bounds the link target read from a zip symlink entry.
+const maxZipLinkTarget = 4096
+
+// archiveReader iterates the entries of an unencrypted archive as tar
+// headers followed by their content, like *tar.Reader.
+type archiveReader interface {
+	Next() (*tar.Header, error)
+	io.Reader
+}
+
+// tarMagic is found at tarMagicOffset in the first header of a tar file
+// written in the ustar, PAX, or GNU format.
+var tarMagic = []byte("ustar")
+
+const tarMagicOffset = 257
+
+// maxManifestSize bounds the embedded manifest read from an archive.
+const maxManifestSize = 64 << 20
+
+// openArchive opens an unencrypted archive like openRawArchive, leaving out
+// the embedded manifest and shell snapshot, which describe the backup rather
+// than being its files.
+func openArchive(path string) (archiveReader, io.Closer, error) {
+	entries, closer, err := openRawArchive(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return skipManifest{entries}, closer, nil
+}
+
+// readManifest returns the manifest embedded in an unencrypted archive, or
+// nil if it has none. Backup writes it as the first entry.
+func readManifest(path string) (*metadata.Metadata, error) {
+	entries, closer, err := openRawArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	header, err := entries.Next()
+	if err == io.EOF || err == nil && header.Name != metadata.ManifestName {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(entries, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := metadata.Parse(data)
+	if err != nil {
+		return nil, errs.Errorf(errs.ErrArchiveCorrupt, "reading %s: %w", metadata.ManifestName, err)
+	}
+	return meta, nil
+}
+
+// skipManifest reads the entries of an archive except the embedded manifest
+// and shell snapshot.
+type skipManifest struct {
+	archiveReader
+}
+
+func (s skipManifest) Next() (*tar.Header, error) {
+	for {
+		header, err := s.archiveReader.Next()
+		if err != nil || header.Name != metadata.ManifestName && header.Name != metadata.ShellSnapshotName {
+			return header, err
+		}
+	}
+}
+
+// copyShellSnapshot writes the shell snapshot embedded in an unencrypted
+// archive to w, and reports whether the archive has one. Backup writes it
+// right after the manifest.
+func copyShellSnapshot(path string, w io.Writer) (bool, error) {
+	entries, closer, err := openRawArchive(path)
+	if err != nil {
+		return false, err
+	}
+	defer closer.Close()
+
+	for range 2 {
+		header, err := entries.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if header.Name == metadata.ShellSnapshotName {
+			_, err = io.Copy(w, io.LimitReader(entries, maxManifestSize))
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// openRawArchive opens an unencrypted archive: tar.gz, zip, or the plain tar
+// of earlier releases. The format is taken from the first bytes of the file
+// rather than its name, because decrypted archives are read from temporary
+// files.
+func openRawArchive(path string) (archiveReader, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	magic := make([]byte, tarMagicOffset+len(tarMagic))
+	n, _ := io.ReadFull(file, magic)
+	magic = magic[:n]
+	if bytes.HasPrefix(magic, zipMagic) {
+		info, statErr := file.Stat()
+		if statErr != nil {
+			_ = file.Close()
+			return nil, nil, statErr
+		}
+		zr, zipErr := zip.NewReader(file, info.Size())
+		if zipErr != nil {
+			_ = file.Close()
+			return nil, nil, zipErr
+		}
+		return &zipEntries{files: zr.File}, file, nil
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	if len(magic) > tarMagicOffset && bytes.HasPrefix(magic[tarMagicOffset:], tarMagic) {
+		return tar.NewReader(file), file, nil
+	}
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	return tar.NewReader(gzReader), closers{gzReader, file}, nil
+}
+
+// closers closes each of its elements in order.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var errList []error
+	for _, closer := range c {
+		errList = append(errList, closer.Close())
+	}
+	return errors.Join(errList...)
+}
+
+// zipEntries reads the entries of a zip file as tar entries. Symlinks
+// stored the way Info-ZIP does, with the link target as content, become
+// tar symlinks.
+type zipEntries struct {
+	files   []*zip.File
+	next    int
+	current io.ReadCloser
+}
+
+func (z *zipEntries) Next() (*tar.Header, error) {
+	if z.current != nil {
+		_ = z.current.Close()
+		z.current = nil
+	}
+	if z.next >= len(z.files) {
+		return nil, io.EOF
+	}
+	f := z.files[z.next]
+	z.next++
+
+	content, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	mode := f.Mode()
+	//nolint:gosec // g115: entry sizes are far below 8 EiB
+	size := int64(f.UncompressedSize64)
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     f.Name,
+		Size:     size,
+		Mode:     int64(mode.Perm()),
+		ModTime:  f.Modified,
+	}
+	switch {
+	case mode.IsDir() || strings.HasSuffix(f.Name, "/"):
+		header.Typeflag = tar.TypeDir
+		header.Size = 0
+	case mode&fs.ModeSymlink != 0:
+		target, readErr := io.ReadAll(io.LimitReader(content, maxZipLinkTarget))
+		_ = content.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(target)
+		header.Size = 0
+		return header, nil
+	}
+	z.current = content
+	return header, nil
+}
+
+func (z *zipEntries) Read(p []byte) (int, error) {
+	if z.current == nil {
+		return 0, io.EOF
+	}
+	return z.current.Read(p)
+}
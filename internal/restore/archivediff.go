@@ -0,0 +1,160 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/output"
+)
+
+// maxDiffContent limits the size of files whose content is kept for a
+// content diff.
+const maxDiffContent = 10 * 1024 * 1024
+
+// ShowArchiveDiff shows the files added, removed, and modified from the
+// backup oldPath to the backup newPath, limited to the given categories if
+// any. Both archives are decrypted and, for incremental backups, merged with
+// their parents, so that the states they restore are compared. With verbose,
+// the content changes of modified files are shown.
+func ShowArchiveDiff(cfg *config.Config, oldPath, newPath string, categories []string, verbose bool,
+	out *output.Output) error {
+	oldFiles, err := readArchiveFiles(cfg, oldPath, categories, verbose)
+	if err != nil {
+		return err
+	}
+	newFiles, err := readArchiveFiles(cfg, newPath, categories, verbose)
+	if err != nil {
+		return err
+	}
+
+	var added, removed, modified []string
+	unchanged := 0
+	for _, name := range slices.Sorted(maps.Keys(newFiles)) {
+		old, ok := oldFiles[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case old.differs(newFiles[name]):
+			modified = append(modified, name)
+		default:
+			unchanged++
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(oldFiles)) {
+		if _, ok := newFiles[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	diffOut := output.NewDiffOutput(out)
+	if len(added) > 0 {
+		out.Print("\nAdded files (%d):\n", len(added))
+		for _, name := range added {
+			diffOut.Added("  + " + name)
+		}
+	}
+	if len(removed) > 0 {
+		out.Print("\nRemoved files (%d):\n", len(removed))
+		for _, name := range removed {
+			diffOut.Removed("  - " + name)
+		}
+	}
+	if len(modified) > 0 {
+		out.Print("\nModified files (%d):\n", len(modified))
+		for _, name := range modified {
+			diffOut.Header("  ~ " + name)
+			old, cur := oldFiles[name], newFiles[name]
+			if verbose && old.content != nil && cur.content != nil {
+				showContentDiff(string(old.content), string(cur.content), out)
+			}
+		}
+	}
+
+	out.Print("\nSummary: %d added, %d removed, %d modified, %d unchanged\n",
+		len(added), len(removed), len(modified), unchanged)
+	return nil
+}
+
+// diffEntry is a file or symlink of an archive compared by ShowArchiveDiff.
+type diffEntry struct {
+	link string
+	size int64
+	sum  [sha256.Size]byte
+	// content is kept for a content diff, nil for symlinks and large files
+	content []byte
+}
+
+func (e diffEntry) differs(other diffEntry) bool {
+	return e.link != other.link || e.size != other.size || e.sum != other.sum
+}
+
+// archiveFiles collects the files and symlinks of an archive.
+type archiveFiles struct {
+	entries     map[string]diffEntry
+	keepContent bool
+}
+
+func (a *archiveFiles) add(header *tar.Header, content io.Reader) error {
+	switch header.Typeflag {
+	case tar.TypeReg:
+		hash := sha256.New()
+		var kept *bytes.Buffer
+		w := io.Writer(hash)
+		if a.keepContent && header.Size <= maxDiffContent {
+			kept = bytes.NewBuffer(make([]byte, 0, header.Size))
+			w = io.MultiWriter(hash, kept)
+		}
+		size, err := io.Copy(w, content)
+		if err != nil {
+			return err
+		}
+		entry := diffEntry{size: size}
+		copy(entry.sum[:], hash.Sum(nil))
+		if kept != nil {
+			entry.content = kept.Bytes()
+		}
+		a.entries[header.Name] = entry
+	case tar.TypeSymlink:
+		a.entries[header.Name] = diffEntry{link: header.Linkname}
+	default:
+		return errSkipEntry
+	}
+	return nil
+}
+
+func (a *archiveFiles) Close() error {
+	return nil
+}
+
+// readArchiveFiles returns the files and symlinks that the archive at path
+// restores, by name, keeping the content of small files if keepContent.
+func readArchiveFiles(cfg *config.Config, path string, categories []string,
+	keepContent bool) (map[string]diffEntry, error) {
+	r := &Restore{
+		cfg:  cfg,
+		opts: &Options{Categories: categories},
+		sink: events.Discard, // per-entry details would drown the diff
+	}
+	tarPaths, cleanup, err := r.openExport(path, &metadata.ExportResult{})
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+
+	files := &archiveFiles{entries: make(map[string]diffEntry), keepContent: keepContent}
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		if err = r.exportArchive(tarPath, files, &metadata.ExportResult{}); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return files.entries, nil
+}
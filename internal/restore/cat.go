@@ -0,0 +1,126 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// errCatDone stops reading an archive once Cat has found its file.
+var errCatDone = errors.New("file found")
+
+// Cat writes the content of the file name in an archive to w without
+// extracting anything: the archive is decrypted and, for an incremental
+// backup, merged with its parents, as for Export, so the content is the
+// one a restore would write. name is relative to the home directory, as
+// entries are archived, or metadata.ShellSnapshotName for the shell snapshot
+// of the backup.
+func Cat(cfg *config.Config, archivePath, name string, w io.Writer, opts ExportOptions,
+	sink events.Sink) (*metadata.ExportResult, error) {
+	result := &metadata.ExportResult{Archive: archivePath}
+	name = cleanPattern(filepath.ToSlash(filepath.Clean(name)))
+	if name == "" || name == "." {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid, "no file to print"))
+		return result, nil
+	}
+
+	r := &Restore{cfg: cfg, opts: &Options{SkipIntegrityCheck: opts.SkipIntegrityCheck}, sink: sink}
+	tarPaths, cleanup, err := r.openExport(archivePath, result)
+	defer cleanup()
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	if name == metadata.ShellSnapshotName {
+		catShellSnapshot(tarPaths[0], w, result)
+		return result, nil
+	}
+
+	c := &catExport{name: name, w: w}
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		err = r.exportArchive(tarPath, c, result)
+		if errors.Is(err, errCatDone) {
+			break
+		}
+		if err != nil {
+			result.SetError(fmt.Errorf("reading archive: %w", err))
+			return result, nil
+		}
+	}
+
+	switch {
+	case c.err != nil:
+		result.SetError(c.err)
+	case c.header == nil:
+		result.SetError(fmt.Errorf("%s is not in %s", name, filepath.Base(archivePath)))
+	default:
+		result.Files, result.TotalSize = 1, c.header.Size
+		result.Success = true
+	}
+	return result, nil
+}
+
+// catShellSnapshot writes the shell snapshot of the backup in tarPath to w.
+// Only the archive named is read: every backup takes its own snapshot.
+func catShellSnapshot(tarPath string, w io.Writer, result *metadata.ExportResult) {
+	found, err := copyShellSnapshot(tarPath, w)
+	switch {
+	case err != nil:
+		result.SetError(fmt.Errorf("reading shell snapshot: %w", err))
+	case !found:
+		result.SetError(fmt.Errorf("%s has no shell snapshot (back up with --shell-snapshot)",
+			filepath.Base(result.Archive)))
+	default:
+		result.Files = 1
+		result.Success = true
+	}
+}
+
+// catExport copies the content of one file to w. Archives are read newest
+// first, so the first entry with the name is the one a restore writes.
+type catExport struct {
+	name   string
+	w      io.Writer
+	header *tar.Header
+	err    error
+}
+
+func (c *catExport) add(header *tar.Header, content io.Reader) error {
+	entry := strings.TrimSuffix(strings.TrimPrefix(header.Name, "./"), "/")
+	if entry != c.name {
+		if strings.HasPrefix(entry, c.name+"/") && c.err == nil {
+			c.err = errs.Errorf(errs.ErrConfigInvalid,
+				"%s is a directory; list its files with dotpak contents", c.name)
+		}
+		return errSkipEntry
+	}
+	c.header = header
+	switch header.Typeflag {
+	case tar.TypeReg:
+		if _, err := io.Copy(c.w, content); err != nil {
+			c.err = fmt.Errorf("writing %s: %w", c.name, err)
+		}
+	case tar.TypeSymlink:
+		c.err = errs.Errorf(errs.ErrConfigInvalid, "%s is a symlink to %s", c.name, header.Linkname)
+	case tar.TypeDir:
+		c.err = errs.Errorf(errs.ErrConfigInvalid,
+			"%s is a directory; list its files with dotpak contents", c.name)
+	default:
+		c.err = errs.Errorf(errs.ErrConfigInvalid, "%s is not a regular file", c.name)
+	}
+	return errCatDone
+}
+
+func (c *catExport) Close() error {
+	return nil
+}
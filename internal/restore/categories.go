@@ -0,0 +1,68 @@
+package restore
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// CategoryPrefixes returns the built-in Categories merged with those defined
+// in the [categories] section of cfg. A configured category adds its prefixes
+// to the built-in one of the same name, or replaces them if it sets replace.
+func CategoryPrefixes(cfg *config.Config) map[string][]string {
+	merged := make(map[string][]string, len(Categories))
+	for name, prefixes := range Categories {
+		merged[name] = slices.Clone(prefixes)
+	}
+	if cfg == nil {
+		return merged
+	}
+
+	for name, cat := range cfg.Categories {
+		name = strings.ToLower(name)
+		if cat.Replace {
+			merged[name] = nil
+		}
+		for _, prefix := range cat.Prefixes {
+			prefix = strings.TrimPrefix(prefix, "~/")
+			if prefix = strings.Trim(strings.TrimPrefix(prefix, "./"), "/"); prefix != "" {
+				merged[name] = append(merged[name], prefix)
+			}
+		}
+	}
+	return merged
+}
+
+// ValidateCategories checks that every name is a built-in or configured
+// category.
+func ValidateCategories(cfg *config.Config, names []string) error {
+	known := CategoryPrefixes(cfg)
+	for _, name := range names {
+		if _, ok := known[strings.ToLower(name)]; !ok {
+			return errs.Errorf(errs.ErrConfigInvalid, "unknown category %q (available: %s)",
+				name, strings.Join(slices.Sorted(maps.Keys(known)), ", "))
+		}
+	}
+	return nil
+}
+
+// matchesCategory reports whether the archive entry path falls under one of
+// the categories.
+func matchesCategory(prefixes map[string][]string, categories []string, path string) bool {
+	path = strings.TrimPrefix(path, "./")
+	path = strings.TrimPrefix(path, "/")
+
+	for _, cat := range categories {
+		for _, prefix := range prefixes[strings.ToLower(cat)] {
+			prefix = strings.TrimPrefix(prefix, "./")
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
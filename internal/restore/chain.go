@@ -0,0 +1,176 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// loadChain returns the archives to extract for archivePath, newest first.
+// For an incremental backup it also records the manifest of files that make
+// up the restored state.
+func (r *Restore) loadChain(archivePath string) ([]string, error) {
+	chain, head, err := metadata.Chain(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		if chain, head, err = r.embeddedChain(archivePath); err != nil {
+			return nil, err
+		}
+	}
+	if len(chain) > 1 {
+		r.manifest = head.Files
+	}
+	if head != nil {
+		r.sourceHome = head.HomeDir
+	}
+	return chain, nil
+}
+
+// embeddedChain is metadata.Chain for an archive whose metadata file is
+// missing, read from the manifest embedded in the archive. The parents of an
+// incremental backup are described by their metadata files or, failing
+// that, their own manifests.
+func (r *Restore) embeddedChain(archivePath string) ([]string, *metadata.Metadata, error) {
+	chain := []string{archivePath}
+	head, err := r.embeddedManifest(archivePath)
+	if head == nil || err != nil {
+		return chain, nil, err
+	}
+	events.Detail(r.sink, "No metadata file for %s; using the manifest in the archive\n", filepath.Base(archivePath))
+
+	for meta := head; meta.Parent != ""; {
+		name := meta.Parent
+		parent := filepath.Join(filepath.Dir(archivePath), name)
+		if slices.Contains(chain, parent) {
+			return nil, nil, errs.Errorf(errs.ErrArchiveCorrupt, "incremental backup chain loops at %s", name)
+		}
+		if _, statErr := os.Stat(parent); statErr != nil {
+			return nil, nil, errs.Errorf(errs.ErrArchiveNotFound,
+				"incremental backup %s needs missing parent archive %s", filepath.Base(chain[len(chain)-1]), name)
+		}
+		chain = append(chain, parent)
+		if meta, err = metadata.Load(metadata.GetMetadataPath(parent)); err == nil {
+			continue
+		}
+		if meta, err = r.embeddedManifest(parent); meta == nil && err == nil {
+			err = errors.New("no metadata file or manifest")
+		}
+		if err != nil {
+			return nil, nil, errs.Errorf(errs.ErrArchiveCorrupt, "reading metadata of %s: %w", name, err)
+		}
+	}
+	return chain, head, nil
+}
+
+// embeddedManifest returns the manifest embedded in an archive, decrypting
+// it to a temporary file first if needed, or nil if it has none.
+func (r *Restore) embeddedManifest(archive string) (*metadata.Metadata, error) {
+	if !hasEncryptionSuffix(archive) {
+		return readManifest(archive)
+	}
+	tarPath, err := r.decryptArchive(archive)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", filepath.Base(archive), err)
+	}
+	defer os.Remove(tarPath)
+	return readManifest(tarPath)
+}
+
+// decryptChain returns the unencrypted paths for the archives in chain,
+// decrypting encrypted ones to temporary files. Archives verified by
+// verifyChain are read through their open handles, so unencrypted ones are
+// copied too. The returned cleanup removes the temporary files, closes the
+// handles, and must be called even on error.
+func (r *Restore) decryptChain(chain []string) ([]string, func(), error) {
+	var decrypted []string
+	cleanup := func() {
+		for _, path := range decrypted {
+			_ = os.Remove(path)
+		}
+		r.closeVerified()
+	}
+
+	tarPaths := make([]string, 0, len(chain))
+	start := time.Now()
+	var read, written int64
+	for _, archive := range chain {
+		encrypted := hasEncryptionSuffix(archive)
+		if !encrypted && r.verified[archive] == nil {
+			tarPaths = append(tarPaths, archive)
+			continue
+		}
+		if encrypted {
+			events.StartPhase(r.sink, events.PhaseDecrypt, "Decrypting %s...\n", filepath.Base(archive))
+		}
+		tarPath, err := r.decryptArchive(archive)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		decrypted = append(decrypted, tarPath)
+		tarPaths = append(tarPaths, tarPath)
+		read += fileSize(archive)
+		written += fileSize(tarPath)
+	}
+	if len(decrypted) > 0 {
+		r.recordIO(events.PhaseDecrypt, start, len(decrypted), read, written)
+	}
+	return tarPaths, cleanup, nil
+}
+
+// manifestPaths returns the set of paths to extract from an incremental
+// chain, or nil for a full backup.
+func (r *Restore) manifestPaths() map[string]bool {
+	if r.manifest == nil {
+		return nil
+	}
+	paths := make(map[string]bool, len(r.manifest))
+	for _, f := range r.manifest {
+		paths[filepath.ToSlash(f.Path)] = true
+	}
+	return paths
+}
+
+// takeFromChain reports whether the archive entry name should be extracted.
+// Archives in a chain are read newest first, so each manifest path is taken
+// from the first archive containing it; files deleted since an older
+// archive are not in the manifest and are never restored.
+func (r *Restore) takeFromChain(name string) bool {
+	if r.pending == nil {
+		return true
+	}
+	if !r.pending[name] {
+		return false
+	}
+	r.pending[name] = false
+	return true
+}
+
+// manifestFilesToBackup returns the manifest paths that exist in the home
+// directory and would be overwritten by the restore.
+func (r *Restore) manifestFilesToBackup() []string {
+	var files []string
+	for _, f := range r.manifest {
+		name := filepath.ToSlash(f.Path)
+		if !isSafePath(name) {
+			continue
+		}
+		if !r.selected(name) {
+			continue
+		}
+		if path, _, ok := r.entryPath(name); ok {
+			if _, err := os.Stat(path); err == nil {
+				files = append(files, name)
+			}
+		}
+	}
+	return files
+}
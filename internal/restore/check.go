@@ -0,0 +1,219 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// Kinds of difference reported by Check.
+const (
+	DiffMissing = "missing"
+	DiffType    = "type"
+	DiffContent = "content"
+	DiffMode    = "mode"
+	DiffSymlink = "symlink"
+)
+
+// Check compares an archive against the live home directory without writing
+// anything, and reports every entry a restore would change. Entries are
+// filtered by the configured categories and path safety rules, the same as Run.
+func (r *Restore) Check(archivePath string) (*metadata.CheckRestoreResult, error) {
+	result := &metadata.CheckRestoreResult{
+		Archive:     archivePath,
+		Differences: []metadata.RestoreDifference{},
+	}
+
+	if r == nil {
+		result.SetError(errors.New("restore not initialized (home directory error)"))
+		return result, errors.New(result.Error)
+	}
+
+	if _, err := os.Stat(archivePath); err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
+		return result, nil
+	}
+
+	chain, err := r.loadChain(archivePath)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	r.rewriter = r.newRewriter(r.sourceHome)
+	if _, err = r.verifyChain(chain); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	tarPaths, cleanup, err := r.decryptChain(chain)
+	defer cleanup()
+	if err != nil {
+		result.SetError(fmt.Errorf("decryption failed: %w", err))
+		return result, nil
+	}
+
+	events.StartPhase(r.sink, events.PhaseVerify, "Comparing archive with %s...\n", r.homeDir)
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		if err = r.compareArchive(tarPath, result); err != nil {
+			result.SetError(fmt.Errorf("reading archive: %w", err))
+			return result, nil
+		}
+	}
+
+	result.Success = true
+	result.NoOp = len(result.Differences) == 0
+	return result, nil
+}
+
+func (r *Restore) compareArchive(tarPath string, result *metadata.CheckRestoreResult) error {
+	entries, closer, err := r.openEntries(tarPath)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for {
+		header, nextErr := entries.Next()
+		if nextErr == io.EOF {
+			return nil
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+
+		if !isSafePath(header.Name) || !r.takeFromChain(header.Name) {
+			continue
+		}
+		if !r.selected(header.Name) {
+			continue
+		}
+
+		targetPath, base, ok := r.entryPath(header.Name)
+		if !ok || !isPathWithinBase(targetPath, base) {
+			continue
+		}
+
+		// compare with the content a restore would write
+		var content io.Reader = entries
+		if header.Typeflag == tar.TypeReg && r.rewriter.matches(header.Name, header.Size) {
+			data, readErr := io.ReadAll(io.LimitReader(entries, maxRewriteSize+1))
+			if readErr != nil {
+				return readErr
+			}
+			data, _ = r.rewriter.apply(data)
+			content = bytes.NewReader(data)
+		}
+		diff, cmpErr := compareEntry(header, content, targetPath)
+		if cmpErr != nil {
+			return cmpErr
+		}
+		result.Checked++
+		if diff.Kind != "" {
+			diff.Path = header.Name
+			result.Differences = append(result.Differences, diff)
+			events.Detail(r.sink, "%s: %s\n", diff.Kind, header.Name)
+		}
+	}
+}
+
+// compareEntry reports how the file at targetPath differs from a tar entry.
+// The returned difference has an empty Kind if restoring the entry would
+// leave the file unchanged.
+func compareEntry(header *tar.Header, content io.Reader, targetPath string) (metadata.RestoreDifference, error) {
+	info, err := os.Lstat(targetPath)
+	if os.IsNotExist(err) {
+		return metadata.RestoreDifference{Kind: DiffMissing}, nil
+	}
+	if err != nil {
+		return metadata.RestoreDifference{}, err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if !info.IsDir() {
+			return metadata.RestoreDifference{Kind: DiffType, Expected: "directory", Actual: fileType(info)}, nil
+		}
+
+	case tar.TypeSymlink:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return metadata.RestoreDifference{Kind: DiffType, Expected: "symlink", Actual: fileType(info)}, nil
+		}
+		target, linkErr := os.Readlink(targetPath)
+		if linkErr != nil {
+			return metadata.RestoreDifference{}, linkErr
+		}
+		if target != header.Linkname {
+			return metadata.RestoreDifference{Kind: DiffSymlink, Expected: header.Linkname, Actual: target}, nil
+		}
+
+	case tar.TypeReg:
+		if !info.Mode().IsRegular() {
+			return metadata.RestoreDifference{Kind: DiffType, Expected: "file", Actual: fileType(info)}, nil
+		}
+		expected, hashErr := hashReader(io.LimitReader(content, osutils.MaxExtractFileSize))
+		if hashErr != nil {
+			return metadata.RestoreDifference{}, hashErr
+		}
+		actual, hashErr := hashFile(targetPath)
+		if hashErr != nil {
+			return metadata.RestoreDifference{}, hashErr
+		}
+		if !bytes.Equal(expected, actual) {
+			return metadata.RestoreDifference{
+				Kind:     DiffContent,
+				Expected: "sha256:" + hex.EncodeToString(expected),
+				Actual:   "sha256:" + hex.EncodeToString(actual),
+			}, nil
+		}
+		//nolint:gosec // g115: mode is masked to valid 9-bit permission range before conversion
+		if wantMode := os.FileMode(header.Mode) & 0o777; info.Mode().Perm() != wantMode {
+			return metadata.RestoreDifference{
+				Kind:     DiffMode,
+				Expected: fmt.Sprintf("%#o", wantMode),
+				Actual:   fmt.Sprintf("%#o", info.Mode().Perm()),
+			}, nil
+		}
+	}
+
+	return metadata.RestoreDifference{}, nil
+}
+
+func hashReader(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func fileType(info os.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	case info.Mode().IsRegular():
+		return "file"
+	}
+	return info.Mode().Type().String()
+}
@@ -0,0 +1,118 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Conflict policies for files that differ from the local copy they would
+// replace.
+const (
+	ConflictOverwrite = "overwrite" // replace the local file
+	ConflictKeep      = "keep"      // keep the local file if it is newer than the archived one
+	ConflictRename    = "rename"    // write the archived file next to it, as <file>.dotpak-restored
+	ConflictPrompt    = "prompt"    // ask for each file with Options.Resolve
+)
+
+// ConflictPolicies lists the valid values of Options.OnConflict.
+var ConflictPolicies = []string{ConflictOverwrite, ConflictKeep, ConflictRename, ConflictPrompt}
+
+// RestoredSuffix is appended to the name of a file restored next to a local
+// file it conflicts with.
+const RestoredSuffix = ".dotpak-restored"
+
+// resolveConflict decides how to restore the entry of header over whatever
+// is at localPath with Options.OnConflict: ConflictOverwrite, ConflictKeep,
+// or ConflictRename. Only entries that differ from the local path conflict,
+// and with ConflictKeep only those changed locally since the backup. content
+// and size are those of a regular file, and nil and 0 for links. It returns
+// the content to write, read in to compare it with the local file, and
+// records each conflict.
+func (r *Restore) resolveConflict(header *tar.Header, localPath string, content io.Reader,
+	size int64) (io.Reader, string, error) {
+	policy := r.opts.OnConflict
+	info, err := r.fsys().Lstat(localPath)
+	if policy == "" || policy == ConflictOverwrite || err != nil {
+		return content, ConflictOverwrite, nil
+	}
+
+	content, same, err := r.sameAsLocal(header, localPath, info, content, size)
+	if err != nil {
+		return nil, "", err
+	}
+	if same {
+		return content, ConflictOverwrite, nil
+	}
+
+	if policy == ConflictKeep && !info.ModTime().After(header.ModTime) {
+		return content, ConflictOverwrite, nil
+	}
+
+	resolution := policy
+	if policy == ConflictPrompt {
+		resolution = ConflictKeep
+		if r.opts.Resolve != nil {
+			resolution = r.opts.Resolve(header.Name)
+		}
+	}
+	r.noteConflict(header.Name, resolution)
+	return content, resolution, nil
+}
+
+// sameAsLocal reports whether the entry of header would restore what is at
+// localPath already: a regular file with the same content, a symlink to
+// the same target, or, for a hard link, a file with the content of the file
+// restored for its target. Files too large to compare in memory are taken
+// to differ. It returns the content of a regular file to write instead of
+// content, which it reads.
+func (r *Restore) sameAsLocal(header *tar.Header, localPath string, info fs.FileInfo, content io.Reader,
+	size int64) (io.Reader, bool, error) {
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return content, false, nil
+		}
+		target, err := r.fsys().Readlink(localPath)
+		return content, err == nil && target == filepath.FromSlash(header.Linkname), nil
+	case tar.TypeLink:
+		target, ok := r.linkTargets[header.Linkname]
+		if !ok || !info.Mode().IsRegular() || info.Size() > maxDiffContent {
+			return content, false, nil
+		}
+		data, err := fsys.ReadFile(r.fsys(), target)
+		if err != nil {
+			return content, false, nil
+		}
+		local, err := fsys.ReadFile(r.fsys(), localPath)
+		return content, err == nil && bytes.Equal(local, data), nil
+	}
+
+	if !info.Mode().IsRegular() || info.Size() != size || size > maxDiffContent {
+		return content, false, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(content, size))
+	if err != nil {
+		return nil, false, err
+	}
+	local, err := fsys.ReadFile(r.fsys(), localPath)
+	return bytes.NewReader(data), err == nil && bytes.Equal(local, data), nil
+}
+
+// noteConflict records how the conflict over name was resolved and reports
+// files not overwritten.
+func (r *Restore) noteConflict(name, resolution string) {
+	r.conflicts = append(r.conflicts, metadata.RestoreConflict{Path: name, Resolution: resolution})
+	switch resolution {
+	case ConflictKeep:
+		events.Info(r.sink, "  Kept local %s\n", name)
+	case ConflictRename:
+		events.Info(r.sink, "  Restored %s as %s\n", name, name+RestoredSuffix)
+	}
+}
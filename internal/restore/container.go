@@ -0,0 +1,58 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// VerifyInContainer verifies the archive like Verify, then restores it into a
+// scratch home directory and runs the validators of validate.categories
+// there, with HOME pointing at it, to show that the backup gives a working
+// environment: shell rc files parse, and git, tmux, and ssh read their
+// configs. Nothing is written to the real home directory: hooks,
+// post_restore commands, and the safety backup are left out, and the
+// scratch directory is removed afterwards. Checks of programs that are not
+// installed are skipped; any other failing check fails the verification.
+func VerifyInContainer(cfg *config.Config, archivePath string, sink events.Sink) (*metadata.VerifyResult, error) {
+	result, err := Verify(cfg, archivePath, sink)
+	if err != nil || !result.Success {
+		return result, err
+	}
+
+	root, err := os.MkdirTemp("", "dotpak-verify-*")
+	if err != nil {
+		result.Success = false
+		result.SetError(fmt.Errorf("creating scratch home: %w", err))
+		return result, nil
+	}
+	defer os.RemoveAll(root)
+	result.Root = root
+
+	r := New(cfg, &Options{Target: root, NoBackup: true, NoPostRestore: true}, sink)
+	restored, err := r.run(archivePath)
+	if err != nil {
+		return result, err
+	}
+	if !restored.Success {
+		result.Success = false
+		result.ErrorCode = restored.ErrorCode
+		result.Error = "restoring into scratch home: " + restored.Error
+		return result, nil
+	}
+
+	var failed int
+	result.Checks, failed = validateConfigs(root, nil, cfg.Validate.Categories, sink)
+	if failed > 0 {
+		result.Success = false
+		result.SetError(fmt.Errorf("%d of %d config checks failed", failed, len(result.Checks)))
+		return result, nil
+	}
+	if len(result.Checks) == 0 {
+		events.Info(sink, "No shell, git, terminal, or ssh configs to check\n")
+	}
+	return result, nil
+}
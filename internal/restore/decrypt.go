@@ -1,12 +1,48 @@
 package restore
 
 import (
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/ospiem/dotpak/internal/config"
 	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/osutils"
 )
 
+// decryptFile decrypts inputPath to outputPath with the method its suffix
+// names, and returns outputPath.
+func decryptFile(cfg *config.Config, inputPath, outputPath string) (string, error) {
+	switch crypto.DetectMethod(inputPath) {
+	case crypto.MethodAge:
+		return decryptWithAge(inputPath, outputPath, resolveAgeIdentityFiles(cfg))
+	case crypto.MethodGPG:
+		return decryptWithGPG(inputPath, outputPath)
+	case crypto.MethodAES:
+		return decryptWithAES(inputPath, outputPath, cfg)
+	default:
+		return "", errs.Errorf(errs.ErrArchiveCorrupt, "unknown encryption format")
+	}
+}
+
+// streamDecryptor returns the decryptor of the method the suffix of path
+// names, with the identities or passphrase of cfg.
+func streamDecryptor(cfg *config.Config, path string) (crypto.StreamDecryptor, error) {
+	switch crypto.DetectMethod(path) {
+	case crypto.MethodAge:
+		return crypto.NewAgeEncryptor(crypto.Options{
+			AgeIdentityFiles: normalizeIdentityFiles(resolveAgeIdentityFiles(cfg)),
+		})
+	case crypto.MethodGPG:
+		return crypto.NewGPGEncryptor(crypto.Options{})
+	case crypto.MethodAES:
+		return crypto.NewAESEncryptor(crypto.Options{PassphraseFile: cfg.Backup.PassphraseFile})
+	default:
+		return nil, errs.Errorf(errs.ErrArchiveCorrupt, "unknown encryption format")
+	}
+}
+
 func decryptWithAge(inputPath, outputPath string, identityFiles []string) (string, error) {
 	identityFiles = normalizeIdentityFiles(identityFiles)
 	enc, err := crypto.NewAgeEncryptor(crypto.Options{
@@ -32,11 +68,53 @@ func decryptWithGPG(inputPath, outputPath string) (string, error) {
 	return outputPath, nil
 }
 
+func decryptWithAES(inputPath, outputPath string, cfg *config.Config) (string, error) {
+	var passphraseFile string
+	if cfg != nil {
+		passphraseFile = cfg.Backup.PassphraseFile
+	}
+	enc, err := crypto.NewAESEncryptor(crypto.Options{PassphraseFile: passphraseFile})
+	if err != nil {
+		return "", err
+	}
+	if err = enc.Decrypt(inputPath, outputPath); err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// resolveAgeIdentityFiles returns the configured identity files followed,
+// if age_identity_discovery is enabled, by the well-known locations.
 func resolveAgeIdentityFiles(cfg *config.Config) []string {
-	if cfg != nil && len(cfg.Backup.AgeIdentityFiles) > 0 {
-		return normalizeIdentityFiles(cfg.Backup.AgeIdentityFiles)
+	if cfg == nil {
+		return nil
+	}
+	identityFiles := normalizeIdentityFiles(cfg.Backup.AgeIdentityFiles)
+	if cfg.Backup.AgeIdentityDiscovery {
+		if home, err := osutils.HomeDir(); err == nil {
+			for _, path := range wellKnownAgeIdentities(home) {
+				if !slices.Contains(identityFiles, path) {
+					identityFiles = append(identityFiles, path)
+				}
+			}
+		}
+	}
+	if len(identityFiles) == 0 {
+		return nil
+	}
+	return identityFiles
+}
+
+// wellKnownAgeIdentities lists where age identities conventionally live, in
+// the order they are tried: dotpak's own key, age's default key file, then
+// SSH keys (age decrypts archives encrypted to ssh-ed25519 or ssh-rsa recipients).
+func wellKnownAgeIdentities(home string) []string {
+	return []string{
+		filepath.Join(home, ".config", "dotpak", "age", "keys.txt"),
+		filepath.Join(home, ".config", "age", "keys.txt"),
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_rsa"),
 	}
-	return nil
 }
 
 func normalizeIdentityFiles(identityFiles []string) []string {
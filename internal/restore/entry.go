@@ -0,0 +1,94 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// maxEntryName is the longest entry name restored, PATH_MAX on Linux.
+const maxEntryName = 4096
+
+// checkEntry validates the header of an archive entry before anything is
+// done with it, and normalizes it in place: the name is cleaned to the form
+// backup writes, slash-separated without "./" or a trailing slash, and the
+// mode is masked to the permission bits, dropping setuid, setgid, and
+// sticky; so is the target of a hard link. It returns why the entry must be
+// skipped: a type restore does not write, such as a device, a name that is
+// unsafe or too long, a size that is negative or above
+// osutils.MaxExtractFileSize, or a symlink or hard link without a usable
+// target.
+func checkEntry(header *tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+	default:
+		return fmt.Errorf("unsupported entry type %q", header.Typeflag)
+	}
+
+	name, err := normalizeName(header.Name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case header.Size < 0:
+		return fmt.Errorf("invalid size %d", header.Size)
+	case header.Size > osutils.MaxExtractFileSize:
+		return fmt.Errorf("size %s exceeds the limit of %s",
+			osutils.FormatSize(header.Size), osutils.FormatSize(osutils.MaxExtractFileSize))
+	case header.Typeflag == tar.TypeSymlink &&
+		(header.Linkname == "" || strings.ContainsRune(header.Linkname, '\x00')):
+		return errors.New("invalid symlink target")
+	case header.Typeflag == tar.TypeLink:
+		target, linkErr := normalizeName(header.Linkname)
+		if linkErr != nil {
+			return fmt.Errorf("invalid link target: %w", linkErr)
+		}
+		header.Linkname = target
+	}
+
+	header.Name = name
+	header.Mode &= 0o777
+	return nil
+}
+
+// normalizeName returns the entry name cleaned, or an error if it is empty,
+// too long, or not safe to join to the home directory.
+func normalizeName(name string) (string, error) {
+	if len(name) > maxEntryName {
+		return "", fmt.Errorf("name longer than %d bytes", maxEntryName)
+	}
+	if !isSafePath(name) {
+		return "", errors.New("unsafe path")
+	}
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", errors.New("empty name")
+	}
+	return clean, nil
+}
+
+// entryQuota counts the entries of an archive a restore writes and their
+// size, so that an archive of countless small entries or of a few huge ones
+// cannot fill the disk.
+type entryQuota struct {
+	entries int
+	size    int64
+}
+
+// charge accounts for the entry of header, or returns an error if it
+// exceeds osutils.MaxExtractEntries or osutils.MaxExtractTotalSize.
+func (q *entryQuota) charge(header *tar.Header) error {
+	if q.entries >= osutils.MaxExtractEntries {
+		return fmt.Errorf("archive has more than %d entries", osutils.MaxExtractEntries)
+	}
+	if q.size+header.Size > osutils.MaxExtractTotalSize {
+		return fmt.Errorf("total extracted size exceeds limit of %s", osutils.FormatSize(osutils.MaxExtractTotalSize))
+	}
+	q.entries++
+	q.size += header.Size
+	return nil
+}
@@ -0,0 +1,243 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Export formats.
+const (
+	ExportTar = "tar"
+	ExportZip = "zip"
+)
+
+// ExportOptions holds export options.
+type ExportOptions struct {
+	// Format is ExportTar or ExportZip; empty picks zip for a .zip
+	// destination and tar otherwise.
+	Format string
+	// Files limits the export to entries matching these globs, as for
+	// restore.
+	Files []string
+	// SkipIntegrityCheck exports archives without verifying their HMAC.
+	SkipIntegrityCheck bool
+	// IncludeSensitive also writes files under sensitive paths such as
+	// .ssh and .gnupg to a git export, which leaves them out by default.
+	IncludeSensitive bool
+}
+
+// errSkipEntry is returned by an exportWriter that leaves an entry out of
+// the export.
+var errSkipEntry = errors.New("entry skipped")
+
+// exportWriter writes the entries of an exported archive.
+type exportWriter interface {
+	add(header *tar.Header, content io.Reader) error
+	Close() error
+}
+
+// Export writes the files of an archive to dest as a plain, uncompressed
+// tar or zip file, for people who do not use dotpak. The archive is
+// decrypted and, for an incremental backup, merged with its parents into
+// the state it restores, but nothing is extracted. Owner names and ids and
+// extended attributes are dropped; names, modes, modification times, and
+// symlinks are kept.
+func Export(cfg *config.Config, archivePath, dest string, opts ExportOptions,
+	sink events.Sink) (*metadata.ExportResult, error) {
+	result := &metadata.ExportResult{Archive: archivePath, Output: dest, Format: opts.Format}
+	if result.Format == "" {
+		result.Format = ExportTar
+		if strings.EqualFold(filepath.Ext(dest), ".zip") {
+			result.Format = ExportZip
+		}
+	}
+	if result.Format != ExportTar && result.Format != ExportZip {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid, "unknown export format %q (want tar or zip)", result.Format))
+		return result, nil
+	}
+
+	if _, err := os.Lstat(dest); err == nil {
+		result.SetError(fmt.Errorf("%s already exists", dest))
+		return result, nil
+	}
+
+	r := &Restore{
+		cfg:  cfg,
+		opts: &Options{Files: opts.Files, SkipIntegrityCheck: opts.SkipIntegrityCheck},
+		sink: sink,
+	}
+	tarPaths, cleanup, err := r.openExport(archivePath, result)
+	defer cleanup()
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	if err = r.writeExport(tarPaths, dest, result); err != nil {
+		result.SetError(fmt.Errorf("export failed: %w", err))
+		return result, nil
+	}
+
+	result.Success = true
+	events.Success(sink, "Exported %d files (%s) to %s\n", result.Files, formatSize(result.TotalSize), dest)
+	return result, nil
+}
+
+// openExport verifies and decrypts the chain of archivePath, recording it
+// in result, and returns the tar files to export in chain order. cleanup
+// removes the decrypted files and is never nil.
+func (r *Restore) openExport(archivePath string, result *metadata.ExportResult) ([]string, func(), error) {
+	cleanup := func() {}
+	if _, err := os.Stat(archivePath); err != nil {
+		return nil, cleanup, errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath)
+	}
+
+	chain, err := r.loadChain(archivePath)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	if len(chain) > 1 {
+		result.Chain = chain
+	}
+	if result.Verified, err = r.verifyChain(chain); err != nil {
+		return nil, cleanup, err
+	}
+
+	tarPaths, cleanup, err := r.decryptChain(chain)
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("decryption failed: %w", err)
+	}
+	return tarPaths, cleanup, nil
+}
+
+// writeExport writes the entries of tarPaths to a temporary file next to
+// dest, renamed to dest once complete.
+func (r *Restore) writeExport(tarPaths []string, dest string, result *metadata.ExportResult) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".dotpak-export-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	var w exportWriter = &tarExport{tw: tar.NewWriter(tmp)}
+	if result.Format == ExportZip {
+		w = &zipExport{zw: backup.NewZipWriter(tmp)}
+	}
+
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		if err = r.exportArchive(tarPath, w, result); err != nil {
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// exportArchive copies the selected entries of one archive to w.
+func (r *Restore) exportArchive(tarPath string, w exportWriter, result *metadata.ExportResult) error {
+	entries, closer, err := openArchive(tarPath)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for {
+		header, nextErr := entries.Next()
+		if nextErr == io.EOF {
+			return nil
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+		if !isSafePath(header.Name) {
+			events.Warning(r.sink, "Skipping unsafe path: %s\n", header.Name)
+			continue
+		}
+		if !r.takeFromChain(header.Name) || !r.selected(header.Name) {
+			continue
+		}
+
+		err = w.add(header, entries)
+		if errors.Is(err, errSkipEntry) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		events.Detail(r.sink, "  %s\n", header.Name)
+		if header.Typeflag == tar.TypeReg {
+			result.Files++
+			result.TotalSize += header.Size
+		}
+	}
+}
+
+// tarExport writes a plain tar file.
+type tarExport struct {
+	tw *tar.Writer
+}
+
+func (t *tarExport) add(header *tar.Header, content io.Reader) error {
+	clean := &tar.Header{
+		Typeflag: header.Typeflag,
+		Name:     header.Name,
+		Linkname: header.Linkname,
+		Size:     header.Size,
+		Mode:     header.Mode,
+		ModTime:  header.ModTime,
+	}
+	if err := t.tw.WriteHeader(clean); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	_, err := io.Copy(t.tw, content)
+	return err
+}
+
+func (t *tarExport) Close() error {
+	return t.tw.Close()
+}
+
+// zipExport writes a zip file.
+type zipExport struct {
+	zw *backup.ZipWriter
+}
+
+func (z *zipExport) add(header *tar.Header, content io.Reader) error {
+	if err := z.zw.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	_, err := io.Copy(z.zw, content)
+	return err
+}
+
+func (z *zipExport) Close() error {
+	return z.zw.Close()
+}
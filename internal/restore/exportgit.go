@@ -0,0 +1,314 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// ExportGit is the format of an export to a git working tree.
+const ExportGit = "git"
+
+// GitExportList is the file in a git export listing the paths written by
+// the last export, so the next one can delete the files that are no longer
+// backed up without touching files added to the repository by hand.
+const GitExportList = ".dotpak-export"
+
+// ExportToGit writes the files of an archive into the git working tree dir
+// and commits them, so git keeps the history of the backed-up files. dir is
+// created and initialized if needed; an existing directory that is not a
+// git repository must be empty. Files of an earlier export that are no
+// longer in the archive are deleted, and nothing is committed if the tree
+// did not change. The commit is dated at the backup.
+//
+// Files under sensitive paths are left out unless opts.IncludeSensitive is
+// set: the repository is not encrypted.
+func ExportToGit(cfg *config.Config, archivePath, dir string, opts ExportOptions,
+	sink events.Sink) (*metadata.ExportResult, error) {
+	result := &metadata.ExportResult{Archive: archivePath, Output: dir, Format: ExportGit}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		result.SetError(errors.New("git is not installed"))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
+		return result, nil
+	}
+	if err := prepareGitTree(dir); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	r := &Restore{
+		cfg:  cfg,
+		opts: &Options{Files: opts.Files, SkipIntegrityCheck: opts.SkipIntegrityCheck},
+		sink: sink,
+	}
+	tarPaths, cleanup, err := r.openExport(archivePath, result)
+	defer cleanup()
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	w := &gitExport{dir: dir, sensitive: !opts.IncludeSensitive, sink: sink, written: make(map[string]bool)}
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		if err = r.exportArchive(tarPath, w, result); err != nil {
+			result.SetError(fmt.Errorf("export failed: %w", err))
+			return result, nil
+		}
+	}
+	if err = w.Close(); err != nil {
+		result.SetError(fmt.Errorf("export failed: %w", err))
+		return result, nil
+	}
+	result.Skipped = w.skipped
+
+	if result.Changes, result.Commit, err = commitExport(dir, archivePath); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	result.Success = true
+	if result.Commit == "" {
+		events.Success(sink, "Exported %d files to %s: no changes since the last export\n", result.Files, dir)
+	} else {
+		events.Success(sink, "Exported %d files to %s: committed %d changes as %s\n",
+			result.Files, dir, result.Changes, shortCommit(result.Commit))
+	}
+	if len(result.Skipped) > 0 {
+		events.Info(sink, "  Left out %d sensitive files (--include-sensitive exports them)\n", len(result.Skipped))
+	}
+	return result, nil
+}
+
+// prepareGitTree creates and initializes dir as a git repository, unless it
+// already is one.
+func prepareGitTree(dir string) error {
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil && info.IsDir() {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case len(entries) > 0:
+		return errs.Errorf(errs.ErrConfigInvalid, "%s is not empty and not a git repository", dir)
+	}
+	_, err = runGit(dir, nil, "init", "--quiet")
+	return err
+}
+
+// gitExport writes the entries of an export into a git working tree.
+type gitExport struct {
+	dir       string
+	sensitive bool // leave out sensitive files
+	sink      events.Sink
+	written   map[string]bool
+	skipped   []string
+}
+
+func (g *gitExport) add(header *tar.Header, content io.Reader) error {
+	name := strings.TrimSuffix(header.Name, "/")
+	if first, _, _ := strings.Cut(name, "/"); first == ".git" || name == GitExportList {
+		events.Warning(g.sink, "Skipping %s: reserved in a git export\n", name)
+		return errSkipEntry
+	}
+	if g.sensitive && isSensitivePath(name) {
+		if header.Typeflag != tar.TypeDir {
+			g.skipped = append(g.skipped, name)
+		}
+		return errSkipEntry
+	}
+	if err := g.checkParents(name); err != nil {
+		events.Warning(g.sink, "Skipping %s: %v\n", name, err)
+		return errSkipEntry
+	}
+
+	target := filepath.Join(g.dir, filepath.FromSlash(name))
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0700)
+	case tar.TypeReg, tar.TypeSymlink:
+	default:
+		return errSkipEntry
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	// replace rather than rewrite, so a symlink is not followed and the
+	// mode is the archived one
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	g.written[name] = true
+	if header.Typeflag == tar.TypeSymlink {
+		return os.Symlink(header.Linkname, target)
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(header.Mode).Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkParents returns an error if a parent directory of name in the tree
+// is a symlink, which writing name would follow out of the tree.
+func (g *gitExport) checkParents(name string) error {
+	parent := g.dir
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", part)
+		}
+	}
+	return nil
+}
+
+// Close deletes the files of the previous export that were not written
+// again, then records the files written in GitExportList.
+func (g *gitExport) Close() error {
+	listPath := filepath.Join(g.dir, GitExportList)
+	previous, err := os.ReadFile(listPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, name := range strings.Split(string(previous), "\n") {
+		if name == "" || g.written[name] || !isSafePath(name) {
+			continue
+		}
+		if first, _, _ := strings.Cut(name, "/"); first == ".git" || g.checkParents(name) != nil {
+			continue
+		}
+		if err = os.Remove(filepath.Join(g.dir, filepath.FromSlash(name))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removeEmptyParents(g.dir, name)
+	}
+
+	names := make([]string, 0, len(g.written))
+	for name := range g.written {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var list strings.Builder
+	for _, name := range names {
+		list.WriteString(name + "\n")
+	}
+	return os.WriteFile(listPath, []byte(list.String()), 0600)
+}
+
+// removeEmptyParents removes the directories above name that a deletion
+// left empty, up to dir.
+func removeEmptyParents(dir, name string) {
+	for parent := filepath.Dir(filepath.FromSlash(name)); parent != "."; parent = filepath.Dir(parent) {
+		if os.Remove(filepath.Join(dir, parent)) != nil {
+			return
+		}
+	}
+}
+
+// isSensitivePath reports whether name is under one of sensitivePatterns.
+func isSensitivePath(name string) bool {
+	for _, pattern := range sensitivePatterns {
+		if name == pattern || strings.HasPrefix(name, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// commitExport commits all changes in the working tree dir for the export
+// of archivePath and returns the number of changed paths and the commit
+// hash, or 0 and "" if nothing changed.
+func commitExport(dir, archivePath string) (int, string, error) {
+	if _, err := runGit(dir, nil, "add", "--all"); err != nil {
+		return 0, "", err
+	}
+	changed, err := runGit(dir, nil, "diff", "--cached", "--no-renames", "--name-only", "-z")
+	if err != nil {
+		return 0, "", err
+	}
+	changes := strings.Count(changed, "\x00")
+	if changes == 0 {
+		return 0, "", nil
+	}
+
+	message := "dotpak backup " + filepath.Base(archivePath)
+	var env []string
+	if meta, loadErr := metadata.Load(metadata.GetMetadataPath(archivePath)); loadErr == nil {
+		message = fmt.Sprintf("dotpak backup %s from %s\n\nArchive: %s", meta.Timestamp, meta.Hostname,
+			filepath.Base(archivePath))
+		env = append(env, "GIT_AUTHOR_DATE="+meta.Timestamp)
+	}
+
+	if _, identityErr := runGit(dir, nil, "config", "user.email"); identityErr != nil {
+		// commit anyway on machines where git was never set up
+		hostname, _ := osutils.Hostname()
+		env = append(env, "GIT_AUTHOR_NAME=dotpak", "GIT_AUTHOR_EMAIL=dotpak@"+hostname,
+			"GIT_COMMITTER_NAME=dotpak", "GIT_COMMITTER_EMAIL=dotpak@"+hostname)
+	}
+	if _, err = runGit(dir, env, "commit", "--quiet", "--no-verify", "-m", message); err != nil {
+		return 0, "", err
+	}
+	commit, err := runGit(dir, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return 0, "", err
+	}
+	return changes, commit, nil
+}
+
+// runGit runs git in dir with env added to the environment and returns its
+// trimmed output.
+func runGit(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,65 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// checkFeatures refuses archives whose manifests list features this release
+// does not know, since their entries would be restored as garbage, unless
+// Options.ForcePartial restores the entries that do not need them.
+func (r *Restore) checkFeatures(tarPaths []string) error {
+	var unknown []string
+	for _, tarPath := range tarPaths {
+		meta, err := readManifest(tarPath)
+		if errors.Is(err, errs.ErrNewerFormat) {
+			return err
+		}
+		if meta == nil {
+			continue
+		}
+		unknown = append(unknown, meta.Features...)
+	}
+	if unknown = metadata.UnknownFeatures(unknown); len(unknown) == 0 {
+		return nil
+	}
+	if !r.opts.ForcePartial {
+		return errs.Errorf(errs.ErrNewerFormat,
+			"the archive needs features this dotpak does not support (%s); upgrade dotpak, "+
+				"or pass --force-partial to restore only the files that do not need them", strings.Join(unknown, ", "))
+	}
+	events.Warning(r.sink, "The archive needs features this dotpak does not support (%s); "+
+		"restoring only the files that do not need them\n", strings.Join(unknown, ", "))
+	return nil
+}
+
+// unsupportedEntry reports whether header is an entry that needs features
+// this release does not know and is left out with Options.ForcePartial;
+// without it, such an entry fails the restore.
+func (r *Restore) unsupportedEntry(header *tar.Header) (bool, error) {
+	features := header.PAXRecords[metadata.FeaturePAXKey]
+	if features == "" {
+		return false, nil
+	}
+	unknown := metadata.UnknownFeatures(strings.Split(features, ","))
+	if len(unknown) == 0 {
+		return false, nil
+	}
+	if !r.opts.ForcePartial {
+		return false, errs.Errorf(errs.ErrNewerFormat, "%s needs features this dotpak does not support (%s); "+
+			"upgrade dotpak", header.Name, strings.Join(unknown, ", "))
+	}
+	if !r.unsupported[header.Name] {
+		if r.unsupported == nil {
+			r.unsupported = make(map[string]bool)
+		}
+		r.unsupported[header.Name] = true
+		events.Detail(r.sink, "Skipping %s: it needs %s\n", header.Name, strings.Join(unknown, ", "))
+	}
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package restore
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+// ValidateFilePatterns checks the syntax of Options.Files globs.
+func ValidateFilePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		for seg := range strings.SplitSeq(cleanPattern(pattern), "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return errs.Errorf(errs.ErrConfigInvalid, "invalid file pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PathPatterns turns paths into Options.Files patterns that match them
+// exactly and, for directories, the files under them: glob characters are
+// escaped, and absolute paths, as the shell expands ~/.zshrc, are made
+// relative to home.
+func PathPatterns(paths []string, home string) ([]string, error) {
+	patterns := make([]string, 0, len(paths))
+	for _, p := range paths {
+		rel, err := HomePath(p, home)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, escapeGlob(rel))
+	}
+	return patterns, nil
+}
+
+// HomePath returns the archive name of the path p: p itself, cleaned, or,
+// for an absolute path, p relative to home. Paths outside home fail with
+// errs.ErrConfigInvalid.
+func HomePath(p, home string) (string, error) {
+	rel := p
+	if filepath.IsAbs(p) {
+		var err error
+		if rel, err = filepath.Rel(home, p); err != nil {
+			rel = p
+		}
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if !filepath.IsLocal(filepath.FromSlash(cleanPattern(rel))) {
+		return "", errs.Errorf(errs.ErrConfigInvalid, "%s is not in the home directory %s", p, home)
+	}
+	return rel, nil
+}
+
+// escapeGlob escapes the characters path.Match treats specially.
+func escapeGlob(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// selected reports whether the archive entry name passes the category and
+// file filters.
+func (r *Restore) selected(name string) bool {
+	if len(r.opts.Categories) > 0 {
+		if r.categories == nil {
+			r.categories = CategoryPrefixes(r.cfg)
+		}
+		if !matchesCategory(r.categories, r.opts.Categories, name) {
+			return false
+		}
+	}
+	if len(r.opts.ExcludeFiles) > 0 && matchesFiles(r.opts.ExcludeFiles, name) {
+		return false
+	}
+	return len(r.opts.Files) == 0 || matchesFiles(r.opts.Files, name)
+}
+
+// matchesFiles reports whether name, or one of its parent directories,
+// matches any of the glob patterns, so that ".config/nvim" selects the whole
+// directory like ".config/nvim/**" does.
+func matchesFiles(patterns []string, name string) bool {
+	name = strings.Trim(strings.TrimPrefix(name, "./"), "/")
+	for _, pattern := range patterns {
+		pattern = cleanPattern(pattern)
+		for candidate := name; candidate != "."; candidate = path.Dir(candidate) {
+			if matchGlob(pattern, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cleanPattern strips the prefixes a pattern may be written with, since
+// archive paths are relative to the home directory.
+func cleanPattern(pattern string) string {
+	pattern = strings.TrimPrefix(pattern, "~/")
+	pattern = strings.TrimPrefix(pattern, "./")
+	return strings.Trim(pattern, "/")
+}
+
+// matchGlob matches a slash-separated name against pattern, where each
+// segment is a path.Match pattern and "**" matches any number of segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := range len(name) + 1 {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,146 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// verifyChain checks the integrity HMAC of every archive in chain, and of
+// its metadata, before anything is decrypted or extracted, and reports
+// whether all of them were verified. With sign_archives enabled an archive
+// without a valid HMAC is rejected, since an attacker who can modify archives
+// can also strip the HMAC from the metadata. Otherwise archives are verified
+// when both an HMAC and the local key are present. Verified archives stay
+// open in r.verified, to be read through the handle they were checked on.
+func (r *Restore) verifyChain(chain []string) (bool, error) {
+	if r.fromStore {
+		return true, nil
+	}
+	if r.opts.SkipIntegrityCheck {
+		events.Warning(r.sink, "Skipping archive integrity check\n")
+		return false, nil
+	}
+
+	required := r.cfg.Backup.SignArchives
+	keyPath := r.cfg.Backup.IntegrityKeyPath()
+	var key []byte
+	verified := true
+	for i, archive := range chain {
+		name := filepath.Base(archive)
+		meta, err := metadata.Load(metadata.GetMetadataPath(archive))
+		if err != nil || meta.HMAC == "" {
+			if required {
+				r.closeVerified()
+				return false, errs.Errorf(errs.ErrArchiveCorrupt,
+					"%s has no integrity HMAC (use --skip-integrity-check to restore it anyway)", name)
+			}
+			verified = false
+			continue
+		}
+
+		if key == nil {
+			key, err = crypto.LoadHMACKey(keyPath)
+			if errors.Is(err, os.ErrNotExist) && !required {
+				events.Warning(r.sink, "Cannot verify %s: integrity key %s not found\n", name, keyPath)
+				r.closeVerified()
+				return false, nil
+			}
+			if err != nil {
+				r.closeVerified()
+				return false, fmt.Errorf("loading integrity key %s: %w", keyPath, err)
+			}
+		}
+		if err = r.verifyArchive(key, archive, meta); err != nil {
+			r.closeVerified()
+			return false, err
+		}
+		if err = r.checkVerifiedChain(chain, i, meta); err != nil {
+			r.closeVerified()
+			return false, err
+		}
+		events.Detail(r.sink, "Verified integrity of %s\n", name)
+	}
+	return verified, nil
+}
+
+// verifyArchive checks archive and meta against meta.HMAC, keeping the
+// archive open in r.verified, rewound to the start, if they match.
+func (r *Restore) verifyArchive(key []byte, archive string, meta *metadata.Metadata) error {
+	signed, err := meta.Signed()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	if err = crypto.VerifyArchiveHMAC(key, file, signed, meta.HMAC); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if r.verified == nil {
+		r.verified = make(map[string]*os.File)
+	}
+	r.verified[archive] = file
+	return nil
+}
+
+// checkVerifiedChain checks that the verified metadata of chain[i] describes
+// the chain and manifest loadChain read before the metadata was verified, so
+// a metadata file swapped in between is not trusted.
+func (r *Restore) checkVerifiedChain(chain []string, i int, meta *metadata.Metadata) error {
+	name := filepath.Base(chain[i])
+	if i+1 < len(chain) && meta.Parent != filepath.Base(chain[i+1]) {
+		return errs.Errorf(errs.ErrArchiveCorrupt, "%s: signed parent %q does not match the backup chain",
+			name, meta.Parent)
+	}
+	if i == 0 && (r.manifest != nil && !slices.Equal(meta.Files, r.manifest) ||
+		r.sourceHome != "" && meta.HomeDir != r.sourceHome) {
+		return errs.Errorf(errs.ErrArchiveCorrupt, "%s: metadata changed while it was read", name)
+	}
+	return nil
+}
+
+// readVerified decrypts or copies the verified archive file to outputPath,
+// reading it from the start through the handle its HMAC was checked on.
+func (r *Restore) readVerified(file *os.File, outputPath string) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if hasEncryptionSuffix(file.Name()) {
+		var dec crypto.StreamDecryptor
+		if dec, err = streamDecryptor(r.cfg, file.Name()); err == nil {
+			err = dec.DecryptStream(file, out)
+		}
+	} else {
+		_, err = io.Copy(out, file)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closeVerified closes the archives kept open by verifyChain.
+func (r *Restore) closeVerified() {
+	for _, file := range r.verified {
+		_ = file.Close()
+	}
+	r.verified = nil
+}
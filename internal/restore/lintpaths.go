@@ -0,0 +1,231 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Kinds of path reported by LintPaths.
+const (
+	PathHome     = "home"
+	PathUser     = "user"
+	PathHomebrew = "homebrew"
+	PathVolume   = "volume"
+)
+
+type machinePath struct {
+	prefix, kind, hint string
+}
+
+// machinePaths are the absolute paths that differ between machines. The
+// first prefix a path is under decides its kind; user and volume paths must
+// name something below the prefix.
+var machinePaths = []machinePath{
+	{"/opt/homebrew", PathHomebrew, "Homebrew prefix on Apple silicon; Intel Macs use /usr/local, Linux /home/linuxbrew/.linuxbrew"},
+	{"/usr/local/Homebrew", PathHomebrew, "Homebrew prefix on Intel Macs; Apple silicon uses /opt/homebrew"},
+	{"/usr/local/Cellar", PathHomebrew, "Homebrew prefix on Intel Macs; Apple silicon uses /opt/homebrew"},
+	{"/usr/local/Caskroom", PathHomebrew, "Homebrew prefix on Intel Macs; Apple silicon uses /opt/homebrew"},
+	{"/usr/local/opt", PathHomebrew, "Homebrew prefix on Intel Macs; Apple silicon uses /opt/homebrew"},
+	{"/home/linuxbrew/.linuxbrew", PathHomebrew, "Homebrew prefix on Linux; macOS uses /opt/homebrew or /usr/local"},
+	{"/Users/Shared", "", ""},
+	{"/Users", PathUser, "home directory of another user; breaks under a different username"},
+	{"/home", PathUser, "home directory of another user; breaks under a different username"},
+	{"/Volumes", PathVolume, "mounted volume; may not exist on another machine"},
+	{"/mnt", PathVolume, "mounted volume; may not exist on another machine"},
+	{"/media", PathVolume, "mounted volume; may not exist on another machine"},
+}
+
+// LintPaths scans the text files of an archive for absolute paths that are
+// likely to break when it is restored on another machine or architecture:
+// the home directory of the backup in files restore does not rewrite, other
+// users' home directories, Homebrew prefixes, and mounted volumes. Entries
+// are selected by categories and files, the same as Run.
+func (r *Restore) LintPaths(archivePath string) (*metadata.LintPathsResult, error) {
+	result := &metadata.LintPathsResult{
+		Archive:  archivePath,
+		Findings: []metadata.PathLint{},
+	}
+
+	if r == nil {
+		result.SetError(errors.New("restore not initialized (home directory error)"))
+		return result, errors.New(result.Error)
+	}
+
+	if _, err := os.Stat(archivePath); err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
+		return result, nil
+	}
+
+	chain, err := r.loadChain(archivePath)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	result.SourceHome = r.sourceHome
+	if _, err = r.verifyChain(chain); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	tarPaths, cleanup, err := r.decryptChain(chain)
+	defer cleanup()
+	if err != nil {
+		result.SetError(fmt.Errorf("decryption failed: %w", err))
+		return result, nil
+	}
+
+	events.StartPhase(r.sink, events.PhaseVerify, "Scanning archive for machine-specific paths...\n")
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		if err = r.lintArchive(tarPath, result); err != nil {
+			result.SetError(fmt.Errorf("reading archive: %w", err))
+			return result, nil
+		}
+	}
+
+	result.Success = true
+	return result, nil
+}
+
+func (r *Restore) lintArchive(tarPath string, result *metadata.LintPathsResult) error {
+	entries, closer, err := openArchive(tarPath)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for {
+		header, nextErr := entries.Next()
+		if nextErr == io.EOF {
+			return nil
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+
+		if !isSafePath(header.Name) || !r.takeFromChain(header.Name) || !r.selected(header.Name) {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg || header.Size > maxRewriteSize {
+			continue
+		}
+		data, readErr := io.ReadAll(entries)
+		if readErr != nil {
+			return readErr
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			continue // binary
+		}
+		result.Checked++
+		rewritten := !r.opts.NoRewrite && matchesFiles(r.rewriteFiles(), header.Name)
+		r.lintContent(header.Name, data, rewritten, result)
+	}
+}
+
+// lintContent records the machine-specific paths in the content of the
+// file name, each once at its first line. If restore rewrites the file,
+// paths it replaces are only counted.
+func (r *Restore) lintContent(name string, data []byte, rewritten bool, result *metadata.LintPathsResult) {
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		for _, value := range findMachinePaths(line, r.sourceHome) {
+			if seen[value] {
+				continue
+			}
+			seen[value] = true
+
+			if rewritten && r.rewrittenPath(value) {
+				result.Rewritten++
+				continue
+			}
+			var kind, hint string
+			switch {
+			case r.sourceHome != "" && isUnder(value, r.sourceHome):
+				kind, hint = PathHome, "home directory of the backup, which restore does not rewrite in this file; "+
+					"add it to [rewrite] files"
+			default:
+				if kind, hint = classifyPath(value); kind == "" {
+					continue
+				}
+			}
+
+			result.Findings = append(result.Findings, metadata.PathLint{
+				Path: name, Line: i + 1, Value: value, Kind: kind, Hint: hint,
+			})
+			events.Detail(r.sink, "%s:%d: %s\n", name, i+1, value)
+		}
+	}
+}
+
+// rewrittenPath reports whether restore replaces value in the files it
+// rewrites: it is under the backup's home directory or a [rewrite] map key.
+func (r *Restore) rewrittenPath(value string) bool {
+	if r.sourceHome != "" && isUnder(value, r.sourceHome) {
+		return true
+	}
+	for from := range r.cfg.Rewrite.Map {
+		if from != "" && isUnder(value, from) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyPath returns the kind of a machine-specific absolute path and a
+// hint about where it breaks, or "" if the path is portable.
+func classifyPath(value string) (string, string) {
+	for _, p := range machinePaths {
+		if !isUnder(value, p.prefix) {
+			continue
+		}
+		if value == p.prefix && (p.kind == PathUser || p.kind == PathVolume) {
+			return "", ""
+		}
+		return p.kind, p.hint
+	}
+	return "", ""
+}
+
+// findMachinePaths returns the absolute paths in line that are under home
+// or one of machinePaths. A path starts at a slash that does not continue a
+// word, a relative path, or a variable such as $HOME/, and ends at
+// whitespace, quotes, or a separator such as the colons of $PATH.
+func findMachinePaths(line, home string) []string {
+	var paths []string
+	for i := 0; i < len(line); i++ {
+		if line[i] != '/' || (i > 0 && !isPathStart(line[i-1])) {
+			continue
+		}
+		end := i
+		for end < len(line) && !strings.ContainsRune(" \t\r\"'`;,:()[]{}<>|&", rune(line[end])) {
+			end++
+		}
+		value := strings.TrimRight(line[i:end], "./")
+		if home != "" && isUnder(value, home) || slices.ContainsFunc(machinePaths, func(p machinePath) bool {
+			return isUnder(value, p.prefix)
+		}) {
+			paths = append(paths, value)
+		}
+		i = end
+	}
+	return paths
+}
+
+func isPathStart(c byte) bool {
+	return !isNameByte(c) && !strings.ContainsRune("./~}$)", rune(c))
+}
+
+// isUnder reports whether path is dir or inside it.
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,253 @@
+package restore
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Manifest formats.
+const (
+	// ManifestMtree is the hierarchical spec of BSD mtree(8), which
+	// "mtree -f spec -p dir" checks a directory against.
+	ManifestMtree = "mtree"
+	// ManifestBSDTar is the mtree dialect of libarchive, one full path per
+	// line, as written by "bsdtar --format=mtree".
+	ManifestBSDTar = "bsdtar"
+	// ManifestJSON is a JSON document of ManifestEntry.
+	ManifestJSON = "json"
+)
+
+// ManifestFormats lists the supported manifest formats.
+var ManifestFormats = []string{ManifestMtree, ManifestBSDTar, ManifestJSON}
+
+// Types of ManifestEntry.
+const (
+	EntryFile = "file"
+	EntryDir  = "dir"
+	EntryLink = "link"
+)
+
+// ManifestEntry describes a file, directory, or symlink of an archive.
+type ManifestEntry struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	Mode    string `json:"mode"`
+	Size    int64  `json:"size,omitempty"`
+	ModTime int64  `json:"mtime"`
+	SHA256  string `json:"sha256,omitempty"`
+	Link    string `json:"link,omitempty"`
+}
+
+// jsonManifest is the document written in ManifestJSON.
+type jsonManifest struct {
+	Archive string          `json:"archive"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// Manifest writes a manifest of the files of an archive to w: path, type,
+// mode, size, modification time, SHA-256, and symlink target of each entry,
+// in opts.Format (ManifestMtree by default). The archive is decrypted and,
+// for an incremental backup, merged with its parents, as for Export.
+func Manifest(cfg *config.Config, archivePath string, w io.Writer, opts ExportOptions,
+	sink events.Sink) (*metadata.ExportResult, error) {
+	result := &metadata.ExportResult{Archive: archivePath, Format: opts.Format}
+	if result.Format == "" {
+		result.Format = ManifestMtree
+	}
+	if !slices.Contains(ManifestFormats, result.Format) {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid, "unknown manifest format %q (want %s)",
+			result.Format, strings.Join(ManifestFormats, ", ")))
+		return result, nil
+	}
+
+	r := &Restore{
+		cfg:  cfg,
+		opts: &Options{Files: opts.Files, SkipIntegrityCheck: opts.SkipIntegrityCheck},
+		sink: sink,
+	}
+	tarPaths, cleanup, err := r.openExport(archivePath, result)
+	defer cleanup()
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	m := &manifestExport{}
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		if err = r.exportArchive(tarPath, m, result); err != nil {
+			result.SetError(fmt.Errorf("reading archive: %w", err))
+			return result, nil
+		}
+	}
+
+	slices.SortFunc(m.entries, func(a, b ManifestEntry) int { return strings.Compare(a.Path, b.Path) })
+	bw := bufio.NewWriter(w)
+	switch result.Format {
+	case ManifestMtree:
+		writeMtree(bw, m.entries)
+	case ManifestBSDTar:
+		writeBSDTarMtree(bw, m.entries)
+	case ManifestJSON:
+		enc := json.NewEncoder(bw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(jsonManifest{Archive: filepath.Base(archivePath), Entries: m.entries})
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		result.SetError(fmt.Errorf("writing manifest: %w", err))
+		return result, nil
+	}
+
+	result.Success = true
+	return result, nil
+}
+
+// manifestExport collects the entries of a manifest.
+type manifestExport struct {
+	entries []ManifestEntry
+}
+
+func (m *manifestExport) add(header *tar.Header, content io.Reader) error {
+	entry := ManifestEntry{
+		Path:    strings.TrimSuffix(header.Name, "/"),
+		Mode:    fmt.Sprintf("%04o", header.Mode&0o7777),
+		ModTime: header.ModTime.Unix(),
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		hash := sha256.New()
+		size, err := io.Copy(hash, content)
+		if err != nil {
+			return err
+		}
+		entry.Type, entry.Size, entry.SHA256 = EntryFile, size, hex.EncodeToString(hash.Sum(nil))
+	case tar.TypeDir:
+		entry.Type = EntryDir
+	case tar.TypeSymlink:
+		entry.Type, entry.Link = EntryLink, header.Linkname
+	default:
+		return errSkipEntry
+	}
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *manifestExport) Close() error {
+	return nil
+}
+
+// mtreeKeywords returns the mtree keywords describing e.
+func mtreeKeywords(e ManifestEntry) string {
+	keywords := fmt.Sprintf("type=%s mode=%s", e.Type, e.Mode)
+	switch e.Type {
+	case EntryFile:
+		keywords += fmt.Sprintf(" size=%d", e.Size)
+	case EntryLink:
+		keywords += " link=" + mtreeEscape(e.Link)
+	}
+	keywords += fmt.Sprintf(" time=%d.000000000", e.ModTime)
+	if e.SHA256 != "" {
+		keywords += " sha256digest=" + e.SHA256
+	}
+	return keywords
+}
+
+// mtreeEscape escapes the characters of name that mtree does not allow
+// unquoted as octal \ooo sequences.
+func mtreeEscape(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || c == '\\' || c == '#' || c == '=' {
+			fmt.Fprintf(&b, "\\%03o", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// writeBSDTarMtree writes entries sorted by path as full-path mtree lines.
+func writeBSDTarMtree(w io.Writer, entries []ManifestEntry) {
+	fmt.Fprintln(w, "#mtree")
+	for _, e := range entries {
+		fmt.Fprintf(w, "./%s %s\n", mtreeEscape(e.Path), mtreeKeywords(e))
+	}
+}
+
+// mtreeNode is a directory level of an mtree(8) spec.
+type mtreeNode struct {
+	entry    *ManifestEntry
+	children map[string]*mtreeNode
+}
+
+// writeMtree writes entries as an mtree(8) spec: the entries of each
+// directory, files first, each directory followed by its own entries and
+// "..". Directories that are not in the archive only have their type.
+func writeMtree(w io.Writer, entries []ManifestEntry) {
+	root := &mtreeNode{children: make(map[string]*mtreeNode)}
+	for i := range entries {
+		node := root
+		for part := range strings.SplitSeq(entries[i].Path, "/") {
+			child, ok := node.children[part]
+			if !ok {
+				child = &mtreeNode{children: make(map[string]*mtreeNode)}
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.entry = &entries[i]
+	}
+
+	fmt.Fprintln(w, "#mtree")
+	fmt.Fprintln(w, ". type=dir")
+	writeMtreeDir(w, root, 1)
+	fmt.Fprintln(w, "..")
+}
+
+func writeMtreeDir(w io.Writer, dir *mtreeNode, depth int) {
+	indent := strings.Repeat("    ", depth)
+	names := make([]string, 0, len(dir.children))
+	for name := range dir.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	isDir := func(node *mtreeNode) bool {
+		return node.entry == nil || node.entry.Type == EntryDir
+	}
+	for _, name := range names {
+		if node := dir.children[name]; !isDir(node) {
+			fmt.Fprintf(w, "%s%s %s\n", indent, mtreeEscape(name), mtreeKeywords(*node.entry))
+		}
+	}
+	for _, name := range names {
+		node := dir.children[name]
+		if !isDir(node) {
+			continue
+		}
+		keywords := "type=dir"
+		if node.entry != nil {
+			keywords = mtreeKeywords(*node.entry)
+		}
+		fmt.Fprintf(w, "%s%s %s\n", indent, mtreeEscape(name), keywords)
+		writeMtreeDir(w, node, depth+1)
+		fmt.Fprintf(w, "%s..\n", indent)
+	}
+}
@@ -0,0 +1,93 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// Reasons of a metadata.ModeChange.
+const (
+	ModeUnreadable = "unreadable"
+	ModePrivate    = "private"
+)
+
+// modePolicy decides the modes of restored files and directories from
+// those recorded in the archive.
+type modePolicy struct {
+	umask   os.FileMode
+	file    os.FileMode
+	dir     os.FileMode
+	private []string
+}
+
+// newModePolicy reads the [restore] settings of cfg and Options.Umask. On
+// error, it returns a policy with the default modes.
+func newModePolicy(cfg *config.Config, opts *Options) (*modePolicy, error) {
+	p := &modePolicy{file: 0o644, dir: 0o755}
+	if cfg == nil {
+		return p, nil
+	}
+	if opts.Umask || cfg.Restore.Umask {
+		p.umask = osutils.Umask()
+	}
+	p.private = cfg.Restore.PrivateCategories
+	file, dir, err := cfg.Restore.DefaultModes()
+	if err != nil {
+		return p, err
+	}
+	p.file, p.dir = file, dir
+	return p, nil
+}
+
+// restoreMode returns the mode to give the file or directory name, recorded
+// with mode archived, and reports it if it differs for another reason than
+// the umask.
+func (r *Restore) restoreMode(name string, archived int64, isDir bool) os.FileMode {
+	if r.modes == nil {
+		r.modes, _ = newModePolicy(r.cfg, r.opts)
+	}
+	if r.categories == nil {
+		r.categories = CategoryPrefixes(r.cfg)
+	}
+	p := r.modes
+
+	//nolint:gosec // g115: mode is masked to valid 9-bit permission range before conversion
+	recorded := os.FileMode(archived) & 0o777
+	mode, reason := recorded, ""
+	switch {
+	case isDir && mode&0o700 != 0o700:
+		mode, reason = p.dir, ModeUnreadable
+	case !isDir && mode&0o400 == 0:
+		mode, reason = p.file, ModeUnreadable
+	}
+	mode &^= p.umask
+	if mode&0o077 != 0 && matchesCategory(r.categories, p.private, name) {
+		mode &= 0o700
+		if reason == "" {
+			reason = ModePrivate
+		}
+	}
+
+	if reason != "" {
+		change := metadata.ModeChange{
+			Path:   name,
+			From:   fmt.Sprintf("%04o", recorded),
+			To:     fmt.Sprintf("%04o", mode),
+			Reason: reason,
+		}
+		r.modeChanges = append(r.modeChanges, change)
+		if reason == ModeUnreadable {
+			events.Warning(r.sink, "Restoring %s with mode %s instead of %s, which locks its owner out\n",
+				name, change.To, change.From)
+		} else {
+			events.Detail(r.sink, "Restoring %s with mode %s instead of %s (private category)\n",
+				name, change.To, change.From)
+		}
+	}
+	return mode
+}
@@ -0,0 +1,279 @@
+package restore
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+)
+
+// categoryAfter lists the categories that built-in ones are restored after:
+// git signs with gpg and ssh keys, and the plugin managers of editors and
+// terminals and the toolchains run from a shell and clone with git. The
+// after lists of [categories] add to these.
+var categoryAfter = map[string][]string{
+	"git":      {"gpg", "ssh"},
+	"editor":   {"shell", "git"},
+	"terminal": {"shell", "git"},
+	"python":   {"shell"},
+	"node":     {"shell"},
+	"rust":     {"shell"},
+	"go":       {"shell"},
+}
+
+// CategoryDependencies returns, for each category, the categories it is
+// restored after: the built-in ones merged with the after lists of
+// [categories] in cfg.
+func CategoryDependencies(cfg *config.Config) map[string][]string {
+	deps := make(map[string][]string, len(categoryAfter))
+	for name, after := range categoryAfter {
+		deps[name] = slices.Clone(after)
+	}
+	if cfg == nil {
+		return deps
+	}
+	for name, cat := range cfg.Categories {
+		name = strings.ToLower(name)
+		for _, dep := range cat.After {
+			if dep = strings.ToLower(dep); !slices.Contains(deps[name], dep) {
+				deps[name] = append(deps[name], dep)
+			}
+		}
+	}
+	return deps
+}
+
+// restoreLevels assigns each category of cfg a level: 0 for categories that
+// depend on none, and otherwise one more than the highest level of those
+// they depend on. Entries are restored level by level.
+func restoreLevels(cfg *config.Config) (map[string]int, error) {
+	known := CategoryPrefixes(cfg)
+	deps := CategoryDependencies(cfg)
+	levels := make(map[string]int, len(known))
+	visiting := make(map[string]bool)
+
+	var visit func(name string, chain []string) (int, error)
+	visit = func(name string, chain []string) (int, error) {
+		if level, ok := levels[name]; ok {
+			return level, nil
+		}
+		if visiting[name] {
+			return 0, errs.Errorf(errs.ErrConfigInvalid, "categories restore after each other: %s",
+				strings.Join(append(chain, name), " -> "))
+		}
+		visiting[name] = true
+		level := 0
+		for _, dep := range deps[name] {
+			if _, ok := known[dep]; !ok {
+				return 0, errs.Errorf(errs.ErrConfigInvalid, "category %s is restored after unknown category %q",
+					name, dep)
+			}
+			depLevel, err := visit(dep, append(chain, name))
+			if err != nil {
+				return 0, err
+			}
+			level = max(level, depLevel+1)
+		}
+		visiting[name] = false
+		levels[name] = level
+		return level, nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(known)) {
+		if _, err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return levels, nil
+}
+
+// entryCategory returns the category of the archive entry name: the one
+// with the longest prefix of it, so that .config/nvim is in editor rather
+// than desktop, or "" if none has a prefix of it.
+func entryCategory(prefixes map[string][]string, name string) string {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "./"), "/")
+	best, bestLen := "", 0
+	for _, cat := range slices.Sorted(maps.Keys(prefixes)) {
+		for _, prefix := range prefixes[cat] {
+			prefix = strings.TrimPrefix(prefix, "./")
+			if len(prefix) > bestLen && strings.HasPrefix(name, prefix) {
+				best, bestLen = cat, len(prefix)
+			}
+		}
+	}
+	return best
+}
+
+// entryCategoryLevel returns the category of the archive entry name and
+// its restore level. Entries in no category depend on nothing and are
+// restored at level 0.
+func (r *Restore) entryCategoryLevel(name string) (string, int) {
+	if r.levels == nil {
+		return "", 0
+	}
+	if r.categories == nil {
+		r.categories = CategoryPrefixes(r.cfg)
+	}
+	category := entryCategory(r.categories, name)
+	return category, r.levels[category]
+}
+
+// extractLevels extracts the entries of tarPaths level by level, so that
+// categories are restored after those they depend on. The archives are read
+// once for level 0, which finds the levels of the other entries, then again
+// for each of those levels.
+func (r *Restore) extractLevels(tarPaths []string) (int, error) {
+	count := 0
+	r.level, r.laterLevels = 0, nil
+	for {
+		for _, tarPath := range tarPaths {
+			n, err := r.extractArchive(tarPath)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+		if len(r.laterLevels) == 0 {
+			return count, nil
+		}
+		r.level = slices.Min(slices.Collect(maps.Keys(r.laterLevels)))
+		categories := r.laterLevels[r.level]
+		delete(r.laterLevels, r.level)
+		slices.Sort(categories)
+		events.Detail(r.sink, "Restoring %s after the categories they depend on\n", strings.Join(categories, ", "))
+	}
+}
+
+// inLevel reports whether the archive entry name is extracted in the
+// current pass of extractLevels, noting the category of a later pass.
+func (r *Restore) inLevel(name string) bool {
+	category, level := r.entryCategoryLevel(name)
+	if level > r.level {
+		if r.laterLevels == nil {
+			r.laterLevels = make(map[int][]string)
+		}
+		if !slices.Contains(r.laterLevels[level], category) {
+			r.laterLevels[level] = append(r.laterLevels[level], category)
+		}
+	}
+	return level == r.level
+}
+
+// postRestoreOrder returns the indexes of the due [[item]] tables in the
+// order their post_restore commands run: an item runs after those named in
+// its after list, by path or category, and otherwise after the items of
+// categories restored before its own, in config order.
+func (r *Restore) postRestoreOrder() []int {
+	var due []int
+	for i := range r.cfg.ItemConfigs {
+		if r.postRestoreDue[i] {
+			due = append(due, i)
+		}
+	}
+	if r.categories == nil {
+		r.categories = CategoryPrefixes(r.cfg)
+	}
+	key := func(i int) itemKey {
+		rel := r.itemRelPath(r.cfg.ItemConfigs[i].Path)
+		category := entryCategory(r.categories, rel)
+		return itemKey{path: rel, category: category, level: r.levels[category]}
+	}
+	order, err := orderItems(r.cfg.ItemConfigs, due, key)
+	if err != nil {
+		events.Warning(r.sink, "Running post-restore commands in config order: %v\n", err)
+	}
+	return order
+}
+
+// itemKey identifies an [[item]] table for after lists.
+type itemKey struct {
+	path     string
+	category string
+	level    int
+}
+
+// orderItems sorts the indexes of items topologically by their after lists;
+// items free to run go by level, then config order. On a cycle, it returns
+// the indexes in config order with an error.
+func orderItems(items []config.ItemConfig, indexes []int, key func(int) itemKey) ([]int, error) {
+	keys := make(map[int]itemKey, len(indexes))
+	for _, i := range indexes {
+		keys[i] = key(i)
+	}
+	before := make(map[int][]int, len(indexes))
+	for _, i := range indexes {
+		for _, dep := range items[i].After {
+			dep = cleanItemRef(dep)
+			for _, j := range indexes {
+				if j != i && (keys[j].path == dep || keys[j].category == strings.ToLower(dep)) {
+					before[i] = append(before[i], j)
+				}
+			}
+		}
+	}
+
+	done := make(map[int]bool, len(indexes))
+	order := make([]int, 0, len(indexes))
+	for len(order) < len(indexes) {
+		next := -1
+		for _, i := range indexes {
+			if done[i] || slices.ContainsFunc(before[i], func(j int) bool { return !done[j] }) {
+				continue
+			}
+			if next < 0 || keys[i].level < keys[next].level {
+				next = i
+			}
+		}
+		if next < 0 {
+			return slices.Clone(indexes), fmt.Errorf("post_restore after lists form a cycle")
+		}
+		done[next] = true
+		order = append(order, next)
+	}
+	return order, nil
+}
+
+// cleanItemRef normalizes an entry of an after list like [[item]] paths.
+func cleanItemRef(ref string) string {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "~/")
+	return strings.Trim(strings.TrimPrefix(filepath.ToSlash(ref), "./"), "/")
+}
+
+// ValidateOrder checks the after lists of [categories] and [[item]] tables
+// in cfg: that they name known categories or items, and do not form a
+// cycle.
+func ValidateOrder(cfg *config.Config) error {
+	if _, err := restoreLevels(cfg); err != nil {
+		return err
+	}
+
+	known := CategoryPrefixes(cfg)
+	var indexes []int
+	for i, item := range cfg.ItemConfigs {
+		indexes = append(indexes, i)
+		for _, dep := range item.After {
+			ref := cleanItemRef(dep)
+			_, isCategory := known[strings.ToLower(ref)]
+			isItem := slices.ContainsFunc(cfg.ItemConfigs, func(other config.ItemConfig) bool {
+				return cleanItemRef(other.Path) == ref
+			})
+			if !isCategory && !isItem {
+				return errs.Errorf(errs.ErrConfigInvalid, "item %s: after names no item or category: %q",
+					item.Path, dep)
+			}
+		}
+	}
+	key := func(i int) itemKey {
+		path := cleanItemRef(cfg.ItemConfigs[i].Path)
+		return itemKey{path: path, category: entryCategory(known, path)}
+	}
+	if _, err := orderItems(cfg.ItemConfigs, indexes, key); err != nil {
+		return errs.Errorf(errs.ErrConfigInvalid, "item after lists: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package restore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/hooks"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// postRestoreTimeout bounds each post_restore command. Plugin managers that
+// sync over the network can take minutes.
+const postRestoreTimeout = 10 * time.Minute
+
+// noteRestored records that the archive entry name was restored, marking the
+// [[item]] tables it belongs to as due for their post_restore command.
+func (r *Restore) noteRestored(name string) {
+	if r.cfg == nil {
+		return
+	}
+	name = strings.Trim(strings.TrimPrefix(name, "./"), "/")
+	for i, item := range r.cfg.ItemConfigs {
+		if item.PostRestore == "" || r.postRestoreDue[i] {
+			continue
+		}
+		rel := r.itemRelPath(item.Path)
+		if rel == "" || (name != rel && !strings.HasPrefix(name, rel+"/")) {
+			continue
+		}
+		if r.postRestoreDue == nil {
+			r.postRestoreDue = make(map[int]bool)
+		}
+		r.postRestoreDue[i] = true
+	}
+}
+
+// itemRelPath returns the archive path of an [[item]] path, which may be
+// absolute (after ~ expansion) or relative to home.
+func (r *Restore) itemRelPath(path string) string {
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(r.homeDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return ""
+		}
+		path = rel
+	}
+	return strings.Trim(strings.TrimPrefix(filepath.ToSlash(path), "./"), "/")
+}
+
+// runPostRestore runs the post_restore commands of the items with restored
+// files, in the order of postRestoreOrder. A failing command is reported but does not fail
+// the restore: the files are already in place.
+func (r *Restore) runPostRestore() []metadata.PostRestoreResult {
+	if len(r.postRestoreDue) == 0 {
+		return nil
+	}
+
+	switch {
+	case r.opts.DryRun:
+		events.StartPhase(r.sink, events.PhasePostRestore, "\nWould run post-restore commands:\n")
+	case r.opts.NoPostRestore:
+		events.StartPhase(r.sink, events.PhasePostRestore, "\nSkipping post-restore commands:\n")
+	case r.opts.Target != "":
+		events.StartPhase(r.sink, events.PhasePostRestore,
+			"\nSkipping post-restore commands (restored into %s, not home):\n", r.homeDir)
+	default:
+		events.StartPhase(r.sink, events.PhasePostRestore, "\nRunning post-restore commands...\n")
+	}
+
+	var results []metadata.PostRestoreResult
+	for _, i := range r.postRestoreOrder() {
+		item := r.cfg.ItemConfigs[i]
+		result := metadata.PostRestoreResult{Path: item.Path, Command: item.PostRestore}
+		events.Info(r.sink, "  %s: %s\n", r.itemRelPath(item.Path), item.PostRestore)
+		if !r.opts.DryRun && !r.opts.NoPostRestore && r.opts.Target == "" {
+			result.Ran = true
+			if err := r.runCommand(item.PostRestore, item.Path); err != nil {
+				result.Error = err.Error()
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// RunPostRestore runs post_restore commands that a restore with
+// NoPostRestore listed without running, in order, and returns them with Ran
+// and Error set. A failing command does not stop the others.
+func RunPostRestore(commands []metadata.PostRestoreResult, sink events.Sink) ([]metadata.PostRestoreResult, error) {
+	home, err := osutils.HomeDir()
+	if err != nil {
+		return nil, err
+	}
+	r := &Restore{sink: sink, homeDir: home}
+	results := make([]metadata.PostRestoreResult, 0, len(commands))
+	for _, command := range commands {
+		events.Info(sink, "  %s: %s\n", r.itemRelPath(command.Path), command.Command)
+		command.Ran = true
+		command.Error = ""
+		if err = r.runCommand(command.Command, command.Path); err != nil {
+			command.Error = err.Error()
+		}
+		results = append(results, command)
+	}
+	return results, nil
+}
+
+// runCommand runs command with the shell in the home directory, with
+// DOTPAK_ITEM set to the item's full path.
+func (r *Restore) runCommand(command, itemPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postRestoreTimeout)
+	defer cancel()
+
+	cmd := hooks.Command(ctx, command)
+	if !filepath.IsAbs(itemPath) {
+		itemPath = filepath.Join(r.homeDir, itemPath)
+	}
+	cmd.Dir = r.homeDir
+	cmd.Env = append(os.Environ(), "DOTPAK_ITEM="+itemPath)
+
+	output, err := cmd.CombinedOutput()
+	if text := strings.TrimSpace(string(output)); text != "" {
+		events.Detail(r.sink, "%s\n", text)
+	}
+	if err != nil {
+		events.Warning(r.sink, "post_restore for %s failed: %v\n", r.itemRelPath(itemPath), err)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// preserved returns backup.preserve, parsed on first use. An invalid value
+// preserves nothing; config validate and backup report it.
+func (r *Restore) preserved() config.Preserve {
+	if r.preserve == nil {
+		r.preserve = &config.Preserve{}
+		if r.cfg != nil {
+			*r.preserve, _ = r.cfg.Backup.ParsePreserve()
+		}
+	}
+	return *r.preserve
+}
+
+// noteLinkTarget records that the file name was extracted to writePath.
+func (r *Restore) noteLinkTarget(name, writePath string) {
+	if r.linkTargets == nil {
+		r.linkTargets = make(map[string]string)
+	}
+	r.linkTargets[name] = writePath
+}
+
+// extractLink writes the hard link entry of header to writePath, which the
+// conflict policy has let it replace: linked to the file its target was
+// restored to, under its own name or as <file>.dotpak-restored, with
+// backup.preserve = ["hardlinks"], or else, or where the filesystem cannot
+// link it, as a copy.
+func (r *Restore) extractLink(header *tar.Header, writePath string) error {
+	target, ok := r.linkTargets[header.Linkname]
+	if !ok {
+		return fmt.Errorf("link target %s was not restored", header.Linkname)
+	}
+	if err := r.fsys().Remove(writePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if r.preserved().Hardlinks && r.fsys().Link(target, writePath) == nil {
+		return nil
+	}
+
+	file, err := r.fsys().Open(target)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	mode := r.restoreMode(header.Name, header.Mode, false)
+	return r.writer.extract(file, writePath, mode, info.Size(), osutils.MaxExtractFileSize)
+}
+
+// preserveMetadata applies what backup.preserve asks for of the entry of
+// header to the file restored to path: its owner, when run as root, its
+// extended attributes in the namespaces restorableXattr allows, and its
+// modification time. Symlinks get their owner only. Filesystems without
+// extended attributes are skipped silently; other failures are warned
+// about, as the content is restored.
+func (r *Restore) preserveMetadata(header *tar.Header, path string) {
+	p := r.preserved()
+	var failed []error
+	if p.Owner && os.Geteuid() == 0 {
+		failed = append(failed, r.fsys().Lchown(path, header.Uid, header.Gid))
+	}
+	if header.Typeflag != tar.TypeSymlink {
+		if p.Xattrs {
+			for key, value := range header.PAXRecords {
+				attr, ok := backup.XattrName(key)
+				switch {
+				case !ok:
+				case !restorableXattr(attr):
+					events.Warning(r.sink, "Skipping extended attribute %s of %s: only user.* attributes are restored\n",
+						attr, header.Name)
+				default:
+					failed = append(failed, r.fsys().Setxattr(path, attr, value))
+				}
+			}
+		}
+		if p.Times && !header.ModTime.IsZero() {
+			failed = append(failed, r.fsys().Chtimes(path, header.ModTime, header.ModTime))
+		}
+	}
+	for _, err := range failed {
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			events.Warning(r.sink, "Failed to preserve attributes of %s: %v\n", header.Name, err)
+		}
+	}
+}
+
+// restorableXattr reports whether the extended attribute attr of an archive
+// may be set on a restored file: only those of the user namespace, and the
+// com.apple ones on macOS. Others, such as security.capability or trusted.*,
+// would let a tampered archive grant privileges when restoring as root.
+func restorableXattr(attr string) bool {
+	return strings.HasPrefix(attr, "user.") ||
+		(runtime.GOOS == "darwin" && strings.HasPrefix(attr, "com.apple."))
+}
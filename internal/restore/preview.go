@@ -0,0 +1,129 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// Statuses of a metadata.PreviewFile.
+const (
+	PreviewNew       = "new"
+	PreviewIdentical = "identical"
+	PreviewModified  = "modified"
+)
+
+// previewEntry classifies the file or symlink of header, with content of
+// size bytes, against targetPath for the preview of a dry run, and lists
+// it with its status.
+func (r *Restore) previewEntry(header *tar.Header, targetPath string, content io.Reader, size int64) {
+	status, err := previewStatus(header, targetPath, content, size)
+	if err != nil {
+		events.Warning(r.sink, "Cannot compare %s: %v\n", header.Name, err)
+		status = PreviewModified
+	}
+	events.Info(r.sink, "  %-9s %s\n", status, header.Name)
+
+	if r.preview == nil {
+		r.preview = &metadata.RestorePreview{}
+	}
+	if r.categories == nil {
+		r.categories = CategoryPrefixes(r.cfg)
+	}
+	if header.Typeflag != tar.TypeReg {
+		size = 0
+	}
+	file := metadata.PreviewFile{
+		Path:     header.Name,
+		Status:   status,
+		Category: entryCategory(r.categories, header.Name),
+		Size:     size,
+	}
+	r.preview.Files = append(r.preview.Files, file)
+	r.preview.PreviewCounts.Add(file)
+	i := slices.IndexFunc(r.preview.Categories, func(c metadata.CategoryPreview) bool {
+		return c.Category == file.Category
+	})
+	if i < 0 {
+		r.preview.Categories = append(r.preview.Categories, metadata.CategoryPreview{Category: file.Category})
+		i = len(r.preview.Categories) - 1
+	}
+	r.preview.Categories[i].PreviewCounts.Add(file)
+}
+
+// previewStatus tells whether restoring the entry of header would create
+// targetPath, or overwrite it with the same or other content. Files are
+// compared by size, then by hash.
+func previewStatus(header *tar.Header, targetPath string, content io.Reader, size int64) (string, error) {
+	info, err := os.Lstat(targetPath)
+	if os.IsNotExist(err) {
+		return PreviewNew, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return PreviewModified, nil
+		}
+		target, linkErr := os.Readlink(targetPath)
+		if linkErr != nil {
+			return "", linkErr
+		}
+		if target != header.Linkname {
+			return PreviewModified, nil
+		}
+		return PreviewIdentical, nil
+	}
+
+	if !info.Mode().IsRegular() || info.Size() != size {
+		return PreviewModified, nil
+	}
+	expected, err := hashReader(io.LimitReader(content, min(size, osutils.MaxExtractFileSize)))
+	if err != nil {
+		return "", err
+	}
+	actual, err := hashFile(targetPath)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(expected, actual) {
+		return PreviewModified, nil
+	}
+	return PreviewIdentical, nil
+}
+
+// reportPreview sums up the preview of a dry run by category.
+func (r *Restore) reportPreview(result *metadata.RestoreResult) {
+	if r.preview == nil {
+		return
+	}
+	slices.SortFunc(r.preview.Categories, func(a, b metadata.CategoryPreview) int {
+		// files of no category last
+		if (a.Category == "") != (b.Category == "") {
+			return strings.Compare(b.Category, a.Category)
+		}
+		return strings.Compare(a.Category, b.Category)
+	})
+	result.Preview = r.preview
+
+	p := r.preview
+	events.Info(r.sink, "  %d new, %d modified, %d identical; would write %s (%s changed)\n",
+		p.New, p.Modified, p.Identical, formatSize(p.Bytes), formatSize(p.ChangedBytes))
+	for _, c := range p.Categories {
+		name := c.Category
+		if name == "" {
+			name = "other"
+		}
+		events.Info(r.sink, "    %-12s %4d new %4d modified %4d identical %10s\n",
+			name, c.New, c.Modified, c.Identical, formatSize(c.Bytes))
+	}
+}
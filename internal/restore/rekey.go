@@ -0,0 +1,237 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// RekeyOptions holds rekey options.
+type RekeyOptions struct {
+	// Method is "age" or "gpg"; empty keeps the method of the archive.
+	Method string
+	// RecipientsFile and GPGRecipient override backup.age_recipients and
+	// backup.gpg_recipient.
+	RecipientsFile string
+	GPGRecipient   string
+	// SkipIntegrityCheck re-encrypts archives without verifying their HMAC.
+	SkipIntegrityCheck bool
+}
+
+// Rekey re-encrypts an archive to other recipients without extracting it:
+// the archive is decrypted with the configured identities or passphrase and
+// streamed into the encryptor, so the plaintext never reaches the disk. The
+// new archive replaces the old one, renamed if the method changes, and its
+// metadata records the method and a new integrity HMAC.
+func Rekey(cfg *config.Config, archivePath string, opts RekeyOptions,
+	sink events.Sink) (*metadata.RekeyResult, error) {
+	result := &metadata.RekeyResult{Archive: archivePath}
+	if _, err := os.Stat(archivePath); err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath))
+		return result, nil
+	}
+
+	from := crypto.DetectMethod(archivePath)
+	if from == crypto.MethodNone {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid,
+			"%s is not encrypted; rekey re-encrypts age, gpg, and aes archives", filepath.Base(archivePath)))
+		return result, nil
+	}
+	to := crypto.Method(opts.Method)
+	if to == crypto.MethodNone {
+		to = from
+	}
+	result.From, result.To = string(from), string(to)
+
+	enc, err := rekeyEncryptor(cfg, to, opts)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	dec, err := streamDecryptor(cfg, archivePath)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	dest := strings.TrimSuffix(archivePath, "."+string(from)) + "." + string(to)
+	if dest != archivePath {
+		if err = checkRename(archivePath, dest); err != nil {
+			result.SetError(err)
+			return result, nil
+		}
+	}
+
+	r := &Restore{cfg: cfg, opts: &Options{SkipIntegrityCheck: opts.SkipIntegrityCheck}, sink: sink}
+	if result.Verified, err = r.verifyChain([]string{archivePath}); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	defer r.closeVerified()
+	in := r.verified[archivePath]
+	if in == nil {
+		if in, err = os.Open(archivePath); err != nil {
+			result.SetError(err)
+			return result, nil
+		}
+		defer in.Close()
+	}
+
+	events.Info(sink, "Re-encrypting %s with %s\n", filepath.Base(archivePath), to)
+	tmp := dest + ".rekey"
+	_ = os.Remove(tmp)
+	if err = reencrypt(dec, enc, in, tmp); err != nil {
+		_ = os.Remove(tmp)
+		result.SetError(err)
+		return result, nil
+	}
+	if err = os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		result.SetError(fmt.Errorf("replacing %s: %w", filepath.Base(archivePath), err))
+		return result, nil
+	}
+	if dest != archivePath {
+		if err = os.Remove(archivePath); err != nil {
+			events.Warning(sink, "Cannot remove %s: %v\n", archivePath, err)
+		}
+		result.Archive, result.Previous = dest, archivePath
+	}
+	if info, statErr := os.Stat(dest); statErr == nil {
+		result.Size = info.Size()
+	}
+
+	if result.Signed, err = updateRekeyedMetadata(cfg, dest, to); err != nil {
+		result.SetError(fmt.Errorf("updating metadata of %s: %w", filepath.Base(dest), err))
+		return result, nil
+	}
+
+	result.Success = true
+	events.Success(sink, "Re-encrypted %s\n", filepath.Base(dest))
+	return result, nil
+}
+
+// rekeyEncryptor returns the encryptor for method with the recipients of
+// opts or cfg.
+func rekeyEncryptor(cfg *config.Config, method crypto.Method, opts RekeyOptions) (crypto.Encryptor, error) {
+	switch method {
+	case crypto.MethodAge:
+		recipients := opts.RecipientsFile
+		if recipients == "" {
+			recipients = cfg.Backup.AgeRecipients
+		}
+		if recipients == "" {
+			return nil, errs.Errorf(errs.ErrEncryptionUnavailable,
+				"no age recipients file (set backup.age_recipients or pass --recipients)")
+		}
+		if err := crypto.CheckRecipients(recipients); err != nil {
+			return nil, err
+		}
+		return crypto.NewAgeEncryptor(crypto.Options{AgeRecipientsFile: recipients})
+	case crypto.MethodGPG:
+		recipient := opts.GPGRecipient
+		if recipient == "" {
+			recipient = cfg.Backup.GPGRecipient
+		}
+		if recipient == "" {
+			return nil, errs.Errorf(errs.ErrEncryptionUnavailable,
+				"no gpg recipient (set backup.gpg_recipient or pass --gpg-recipient)")
+		}
+		return crypto.NewGPGEncryptor(crypto.Options{GPGRecipient: recipient})
+	default:
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "rekey encrypts to age or gpg recipients (got %q)", method)
+	}
+}
+
+// checkRename checks that the archive can be renamed to dest: that dest does
+// not exist, and that no incremental backup names the archive as its parent,
+// since the archives of its chain are found by name.
+func checkRename(archivePath, dest string) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	name := filepath.Base(archivePath)
+	metaFiles, err := filepath.Glob(filepath.Join(filepath.Dir(archivePath), "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range metaFiles {
+		if meta, loadErr := metadata.Load(path); loadErr == nil && meta.Parent == name {
+			return errs.Errorf(errs.ErrConfigInvalid,
+				"%s is the parent of the incremental backup %s; rekey it with its own method (%s)",
+				name, strings.TrimSuffix(filepath.Base(path), ".json"), crypto.DetectMethod(archivePath))
+		}
+	}
+	return nil
+}
+
+// reencrypt decrypts in with dec and encrypts the plaintext with enc into
+// dest, through a pipe.
+func reencrypt(dec crypto.StreamDecryptor, enc crypto.Encryptor, in io.Reader, dest string) error {
+	pr, pw := io.Pipe()
+	decrypted := make(chan error, 1)
+	go func() {
+		decErr := dec.DecryptStream(in, pw)
+		// report before closing the pipe, so that the error is known by the
+		// time the encryptor sees it
+		decrypted <- decErr
+		pw.CloseWithError(decErr)
+	}()
+	encErr := enc.EncryptReader(pr, dest)
+
+	select {
+	case decErr := <-decrypted:
+		if decErr != nil {
+			return fmt.Errorf("decrypting: %w", decErr)
+		}
+	default:
+		// the encryptor gave up first; stop the decryptor
+		pr.CloseWithError(errors.New("encryption stopped"))
+		<-decrypted
+	}
+	if encErr != nil {
+		return fmt.Errorf("encrypting: %w", encErr)
+	}
+	return nil
+}
+
+// updateRekeyedMetadata records the method of the re-encrypted archive in
+// its metadata file, with a new integrity HMAC if the old archive had one or
+// sign_archives is set. It reports whether the archive was signed.
+func updateRekeyedMetadata(cfg *config.Config, archive string, method crypto.Method) (bool, error) {
+	metaPath := metadata.GetMetadataPath(archive)
+	meta, err := metadata.Load(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	meta.Encrypted = true
+	meta.EncryptionMethod = string(method)
+
+	signed := meta.HMAC != "" || cfg.Backup.SignArchives
+	if signed {
+		keyPath := cfg.Backup.IntegrityKeyPath()
+		key, keyErr := crypto.LoadOrCreateHMACKey(keyPath)
+		if keyErr != nil {
+			return false, fmt.Errorf("loading integrity key %s: %w", keyPath, keyErr)
+		}
+		fields, fieldsErr := meta.Signed()
+		if fieldsErr != nil {
+			return false, fieldsErr
+		}
+		if meta.HMAC, err = crypto.ArchiveHMAC(key, archive, fields); err != nil {
+			return false, err
+		}
+	}
+	return signed, meta.Save(metaPath)
+}
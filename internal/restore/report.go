@@ -0,0 +1,246 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// appConfigs maps configuration paths, relative to home, to the program
+// that reads them.
+var appConfigs = []struct {
+	prefix string
+	app    string
+}{
+	{".zshrc", "zsh"},
+	{".zprofile", "zsh"},
+	{".zshenv", "zsh"},
+	{".p10k.zsh", "zsh"},
+	{".oh-my-zsh", "zsh"},
+	{".config/fish", "fish"},
+	{".gitconfig", "git"},
+	{".config/git", "git"},
+	{".vimrc", "vim"},
+	{".config/nvim", "nvim"},
+	{".config/helix", "hx"},
+	{".emacs", "emacs"},
+	{".emacs.d", "emacs"},
+	{".tmux.conf", "tmux"},
+	{".config/wezterm", "wezterm"},
+	{".config/alacritty", "alacritty"},
+	{".config/kitty", "kitty"},
+	{".config/starship.toml", "starship"},
+	{".config/zellij", "zellij"},
+	{".ssh", "ssh"},
+	{".gnupg", "gpg"},
+	{".aws", "aws"},
+	{".config/gcloud", "gcloud"},
+	{".azure", "az"},
+	{".docker", "docker"},
+	{".config/podman", "podman"},
+	{".kube", "kubectl"},
+	{".config/gh", "gh"},
+	{".cargo", "cargo"},
+	{".npmrc", "npm"},
+	{".yarnrc", "yarn"},
+}
+
+// Report describes, without writing anything, what a restore of the archive
+// would change and what it needs first: directories it would create,
+// programs that are not installed although their configs are restored, and
+// paths it could not write. Entries are filtered as for Run.
+func (r *Restore) Report(archivePath string) (*metadata.RestoreReport, error) {
+	result := &metadata.RestoreReport{
+		Archive:          archivePath,
+		Changes:          []metadata.RestoreDifference{},
+		MissingDirs:      []string{},
+		MissingApps:      []metadata.MissingApp{},
+		PermissionIssues: []metadata.PermissionIssue{},
+	}
+
+	if r == nil {
+		result.SetError(errors.New("restore not initialized (home directory error)"))
+		return result, errors.New(result.Error)
+	}
+
+	if _, err := os.Stat(archivePath); err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
+		return result, nil
+	}
+
+	chain, err := r.loadChain(archivePath)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	if _, err = r.verifyChain(chain); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	tarPaths, cleanup, err := r.decryptChain(chain)
+	defer cleanup()
+	if err != nil {
+		result.SetError(fmt.Errorf("decryption failed: %w", err))
+		return result, nil
+	}
+
+	events.StartPhase(r.sink, events.PhaseVerify, "Checking what a restore needs in %s...\n", r.homeDir)
+	r.pending = r.manifestPaths()
+	report := &restoreReport{result: result, dirs: map[string]bool{}, issues: map[string]bool{}}
+	for _, tarPath := range tarPaths {
+		if err = r.reportArchive(tarPath, report); err != nil {
+			result.SetError(fmt.Errorf("reading archive: %w", err))
+			return result, nil
+		}
+	}
+
+	result.MissingApps = missingApps(report.paths, exec.LookPath)
+	slices.Sort(result.MissingDirs)
+	slices.SortFunc(result.PermissionIssues, func(a, b metadata.PermissionIssue) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	result.Success = true
+	return result, nil
+}
+
+// restoreReport collects a RestoreReport across the archives of a chain.
+type restoreReport struct {
+	result *metadata.RestoreReport
+	paths  []string
+	dirs   map[string]bool // missing directories already reported
+	issues map[string]bool // paths with a permission issue already reported
+}
+
+func (r *Restore) reportArchive(tarPath string, report *restoreReport) error {
+	entries, closer, err := r.openEntries(tarPath)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for {
+		header, nextErr := entries.Next()
+		if nextErr == io.EOF {
+			return nil
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+
+		if !isSafePath(header.Name) || !r.takeFromChain(header.Name) {
+			continue
+		}
+		if !r.selected(header.Name) {
+			continue
+		}
+
+		//nolint:gosec // g305: path validated by isSafePath() above and isPathWithinBase() below
+		targetPath := filepath.Join(r.homeDir, header.Name)
+		if !isPathWithinBase(targetPath, r.homeDir) {
+			continue
+		}
+
+		diff, cmpErr := compareEntry(header, entries, targetPath)
+		if cmpErr != nil {
+			return cmpErr
+		}
+		report.result.Checked++
+		report.paths = append(report.paths, header.Name)
+		if diff.Kind == "" {
+			continue
+		}
+		diff.Path = header.Name
+		report.result.Changes = append(report.result.Changes, diff)
+		events.Detail(r.sink, "%s: %s\n", diff.Kind, header.Name)
+		r.reportTarget(header, targetPath, diff.Kind, report)
+	}
+}
+
+// reportTarget records the directories restore would create for an entry it
+// changes, and whether it may write the entry.
+func (r *Restore) reportTarget(header *tar.Header, targetPath, kind string, report *restoreReport) {
+	// existing regular files are rewritten in place
+	if kind != DiffMissing && header.Typeflag == tar.TypeReg {
+		if info, err := os.Lstat(targetPath); err == nil && info.Mode().IsRegular() && !osutils.Writable(targetPath) {
+			report.addIssue(header.Name, "file is not writable")
+		}
+		return
+	}
+
+	// otherwise the entry is created in its directory, and so are any
+	// missing parent directories
+	dir := filepath.Dir(targetPath)
+	missing := ""
+	for {
+		if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+			break
+		}
+		missing = dir
+		dir = filepath.Dir(dir)
+	}
+	if missing != "" {
+		if rel, err := filepath.Rel(r.homeDir, missing); err == nil && !report.dirs[rel] {
+			report.dirs[rel] = true
+			report.result.MissingDirs = append(report.result.MissingDirs, filepath.ToSlash(rel))
+		}
+	}
+
+	rel, err := filepath.Rel(r.homeDir, dir)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	if info, statErr := os.Stat(dir); statErr != nil || !info.IsDir() {
+		report.addIssue(rel, "not a directory")
+	} else if !osutils.Writable(dir) {
+		report.addIssue(rel, "directory is not writable")
+	}
+}
+
+func (report *restoreReport) addIssue(path, problem string) {
+	if report.issues[path] {
+		return
+	}
+	report.issues[path] = true
+	report.result.PermissionIssues = append(report.result.PermissionIssues,
+		metadata.PermissionIssue{Path: path, Problem: problem})
+}
+
+// missingApps returns the programs, found with lookPath, that are not
+// installed although paths include their configuration.
+func missingApps(paths []string, lookPath func(string) (string, error)) []metadata.MissingApp {
+	configs := make(map[string][]string)
+	for _, path := range paths {
+		for _, ac := range appConfigs {
+			if path == ac.prefix || strings.HasPrefix(path, ac.prefix+"/") {
+				if !slices.Contains(configs[ac.app], ac.prefix) {
+					configs[ac.app] = append(configs[ac.app], ac.prefix)
+				}
+			}
+		}
+	}
+
+	missing := []metadata.MissingApp{}
+	for app, prefixes := range configs {
+		if _, err := lookPath(app); err != nil {
+			slices.Sort(prefixes)
+			missing = append(missing, metadata.MissingApp{App: app, Paths: prefixes})
+		}
+	}
+	slices.SortFunc(missing, func(a, b metadata.MissingApp) int {
+		return strings.Compare(a.App, b.App)
+	})
+	return missing
+}
@@ -4,10 +4,14 @@ package restore
 import (
 	"archive/tar"
 	"bufio"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -19,12 +23,18 @@ import (
 	"github.com/ospiem/dotpak/internal/backup"
 	"github.com/ospiem/dotpak/internal/config"
 	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/fsys"
+	"github.com/ospiem/dotpak/internal/hooks"
+	"github.com/ospiem/dotpak/internal/lock"
 	"github.com/ospiem/dotpak/internal/metadata"
 	"github.com/ospiem/dotpak/internal/osutils"
 	"github.com/ospiem/dotpak/internal/output"
 )
 
-// Categories maps category names to path prefixes.
+// Categories maps the built-in category names to path prefixes. Users can
+// add to them in config.toml; see CategoryPrefixes.
 var Categories = map[string][]string{
 	"shell": {
 		".zshrc",
@@ -36,9 +46,14 @@ var Categories = map[string][]string{
 		".config/fish",
 		".oh-my-zsh",
 		".p10k.zsh",
+		"Documents/PowerShell",
+		"Documents/WindowsPowerShell",
+	},
+	"git": {".gitconfig", ".gitignore_global", ".config/git"},
+	"editor": {
+		".vimrc", ".config/nvim", ".config/helix", ".config/zed", ".emacs", ".emacs.d", ".config/Code",
+		"AppData/Local/nvim", "AppData/Roaming/Code",
 	},
-	"git":    {".gitconfig", ".gitignore_global", ".config/git"},
-	"editor": {".vimrc", ".config/nvim", ".config/helix", ".config/zed", ".emacs", ".emacs.d", ".config/Code"},
 	"ssh":    {".ssh/"},
 	"gpg":    {".gnupg/"},
 	"python": {".config/pip", ".config/ruff", ".config/mypy", ".jupyter", ".condarc"},
@@ -54,9 +69,17 @@ var Categories = map[string][]string{
 		".config/kitty",
 		".config/starship.toml",
 		".config/zellij",
+		"AppData/Local/Packages/Microsoft.WindowsTerminal_8wekyb3d8bbwe",
+		"AppData/Local/Packages/Microsoft.WindowsTerminalPreview_8wekyb3d8bbwe",
+		"AppData/Local/Microsoft/Windows Terminal",
+	},
+	"desktop": {
+		"Library/Application Support", "Library/Preferences", ".local/share", ".config",
+		"AppData/Roaming", "AppData/Local", ".wslconfig",
 	},
-	"desktop": {"Library/Application Support", "Library/Preferences", ".local/share", ".config"},
-	"ai":      {".claude", ".claude.json", ".codex", ".ai"},
+	"ai": {".claude", ".claude.json", ".codex", ".ai"},
+	// the wsl.items of backups made in WSL, restored to the Windows side
+	"wsl": {backup.WindowsPrefix + "/"},
 }
 
 // Options holds restore options.
@@ -64,33 +87,179 @@ type Options struct {
 	DryRun     bool
 	Force      bool
 	Categories []string
-	NoBackup   bool
+	// Files limits the restore to entries matching these globs, relative to
+	// the home directory ("**" matches any number of directories).
+	Files []string
+	// ExcludeFiles leaves out entries matching these globs, as Files
+	// matches them.
+	ExcludeFiles []string
+	NoBackup     bool
+	// SkipIntegrityCheck restores archives without verifying their HMAC.
+	SkipIntegrityCheck bool
+	// NoPostRestore skips the post_restore commands of [[item]] tables.
+	NoPostRestore bool
+	// Fsync is one of FsyncPolicies; empty means FsyncNone.
+	Fsync string
+	// Transactional extracts into a staging directory and moves the files
+	// into place only if every entry was extracted, rolling back on failure.
+	Transactional bool
+	// NoRewrite restores config files unchanged instead of replacing the
+	// home directory of the backup and the [rewrite] map in them.
+	NoRewrite bool
+	// Target is the directory to restore into instead of the home
+	// directory, created if needed. Paths are checked against it, and the
+	// post_restore commands of [[item]] tables are not run, since the
+	// programs they start read the real home directory.
+	Target string
+	// RewriteTarget replaces the home directory of the backup with Target
+	// in restored config files. Without it, a restore into Target leaves
+	// the home directory as the backup has it, so that what is inspected
+	// there is what the backup holds; the [rewrite] map still applies.
+	RewriteTarget bool
+	// OnConflict is one of ConflictPolicies, for files that differ from the
+	// local files they replace; empty means ConflictOverwrite. With
+	// ConflictPrompt, Resolve is asked for each file and returns
+	// ConflictOverwrite, ConflictKeep, or ConflictRename; without Resolve,
+	// local files are kept.
+	OnConflict string
+	Resolve    func(name string) string
+	// Validate checks the syntax of the restored config files, such as
+	// zsh -n on .zshrc, as validate.after_restore does, and fails the
+	// restore if one does not parse.
+	Validate bool
+	// Umask clears the bits of the current umask from the modes recorded in
+	// the archive, as restore.umask does.
+	Umask bool
+	// Wait waits for another run that holds the lock on the backup
+	// directory instead of failing.
+	Wait bool
+	// ForcePartial restores an archive that needs features this release
+	// does not know, leaving out the entries that need them.
+	ForcePartial bool
+	// FS is the filesystem files are restored into, nil for the OS one.
+	// Archives and the backup directory are always read from the OS.
+	FS fsys.FS
 }
 
 // Restore performs the restore operation.
 type Restore struct {
 	cfg     *config.Config
 	opts    *Options
-	out     *output.Output
+	sink    events.Sink
 	homeDir string
+
+	// manifest lists the full state recorded by an incremental backup, and
+	// pending the manifest paths not yet extracted from its chain. Both are
+	// nil when restoring a full backup.
+	manifest []metadata.CatalogEntry
+	pending  map[string]bool
+
+	// writer writes extracted files, created on first use.
+	writer *fileWriter
+	// tx stages extracted files with Options.Transactional.
+	tx *transaction
+	// quota counts the entries extracted across the archives of a chain.
+	quota entryQuota
+	// preserve is backup.preserve, parsed on first use, and linkTargets
+	// maps the names of the files extracted to where they were written, for
+	// the hard link entries to them.
+	preserve    *config.Preserve
+	linkTargets map[string]string
+	// categories holds CategoryPrefixes(cfg), computed on first use.
+	categories map[string][]string
+	// postRestoreDue holds the indexes of cfg.ItemConfigs with a restored file.
+	postRestoreDue map[int]bool
+	// levels maps categories to the pass of extractLevels that restores
+	// them; level is the current pass, and laterLevels the categories of
+	// the entries found for each later one.
+	levels      map[string]int
+	level       int
+	laterLevels map[int][]string
+	// verified holds the archives whose integrity HMAC was checked, open
+	// on the handle they were read through; they are decrypted or copied
+	// from it, never reopened by name. closeVerified closes them.
+	verified map[string]*os.File
+	// fromStore is set while restoring an archive assembled from a snapshot,
+	// whose contents were checked against their hashes.
+	fromStore bool
+	// unsealUnavailable is set once decrypting a sensitive file encrypted
+	// on its own failed for lack of an age identity, so that the others are
+	// restored encrypted without trying again.
+	unsealUnavailable bool
+	// unsealedEntries holds the sealed entries decrypted so far, by archive
+	// and entry name, so that archives read more than once, level by level
+	// or to back up the files a restore replaces, are decrypted once.
+	unsealedEntries map[string]unsealedEntry
+	// unsupported holds the entries left out by Options.ForcePartial.
+	unsupported map[string]bool
+	// sourceHome is the home directory the backup was made in, if recorded.
+	sourceHome string
+	// rewriter rewrites restored config files, nil if there is nothing to
+	// replace; rewrites records the replacements made.
+	rewriter *rewriter
+	rewrites []metadata.Rewrite
+	// ctx stops a restore started with RunContext; nil for Run.
+	ctx context.Context
+	// restored lists the files and symlinks written so far, reported when
+	// the restore is canceled.
+	restored []string
+	// stats counts the entries extracted, and ioProfile times each phase.
+	stats     metadata.RestoreStats
+	ioProfile []metadata.IOPhase
+	// foldedNames maps the lowercased names of the files and symlinks
+	// restored so far to their names, to skip entries that would overwrite
+	// them on a case-insensitive filesystem; nil on a case-sensitive one.
+	// caseChecked is set once the filesystem was probed.
+	foldedNames    map[string]string
+	caseChecked    bool
+	caseCollisions []string
+	// conflicts records the files resolved with Options.OnConflict.
+	conflicts []metadata.RestoreConflict
+	// modes decides the modes of restored files, created on first use, and
+	// modeChanges records those that differ from the archive.
+	modes       *modePolicy
+	modeChanges []metadata.ModeChange
+	// preview classifies the files of a dry run.
+	preview *metadata.RestorePreview
+	// windowsHome is where the wsl.items of the archive are restored, ""
+	// if nowhere; windowsChecked is set once it was looked for.
+	windowsHome    string
+	windowsChecked bool
 }
 
-// New creates a new Restore instance.
+// New creates a new Restore instance that reports progress to sink.
 // Returns nil if home directory cannot be determined.
-func New(cfg *config.Config, opts *Options, out *output.Output) *Restore {
+func New(cfg *config.Config, opts *Options, sink events.Sink) *Restore {
+	if opts != nil && opts.Target != "" {
+		target, err := filepath.Abs(opts.Target)
+		if err != nil {
+			events.Error(sink, "Cannot resolve restore target: %v\n", err)
+			return nil
+		}
+		return &Restore{cfg: cfg, opts: opts, sink: sink, homeDir: target}
+	}
+
 	home, err := osutils.HomeDir()
 	if err != nil {
-		out.Error("Cannot determine home directory: %v\n", err)
+		events.Error(sink, "Cannot determine home directory: %v\n", err)
 		return nil
 	}
 	return &Restore{
 		cfg:     cfg,
 		opts:    opts,
-		out:     out,
+		sink:    sink,
 		homeDir: home,
 	}
 }
 
+// fsys returns the filesystem files are restored into.
+func (r *Restore) fsys() fsys.FS {
+	if r.opts == nil || r.opts.FS == nil {
+		return fsys.OS
+	}
+	return r.opts.FS
+}
+
 // sensitivePatterns are path prefixes that indicate sensitive files.
 var sensitivePatterns = []string{
 	".ssh", ".gnupg", ".aws", ".config/gcloud", ".azure",
@@ -119,18 +288,19 @@ func (r *Restore) canEncrypt() bool {
 	if r.cfg.Backup.GPGRecipient != "" {
 		return crypto.HasGPG()
 	}
-	return false
+	_, err := crypto.ResolvePassphrase(r.cfg.Backup.PassphraseFile)
+	return err == nil
 }
 
 // promptForSensitiveBackup prompts the user for how to handle sensitive files in the safety backup
 // when encryption is not available.
 func (r *Restore) promptForSensitiveBackup(files []string) ([]string, error) {
-	r.out.Warning("Safety backup contains sensitive files but no encryption is configured.\n")
-	r.out.Print("Options:\n")
-	r.out.Print("  1. Save without encryption\n")
-	r.out.Print("  2. Skip sensitive files\n")
-	r.out.Print("  3. Cancel restore\n")
-	r.out.Print("\nChoice [1/2/3]: ")
+	events.Warning(r.sink, "Safety backup contains sensitive files but no encryption is configured.\n")
+	events.Info(r.sink, "Options:\n")
+	events.Info(r.sink, "  1. Save without encryption\n")
+	events.Info(r.sink, "  2. Skip sensitive files\n")
+	events.Info(r.sink, "  3. Cancel restore\n")
+	events.Info(r.sink, "\nChoice [1/2/3]: ")
 
 	scanner := bufio.NewScanner(os.Stdin)
 	if !scanner.Scan() {
@@ -140,10 +310,10 @@ func (r *Restore) promptForSensitiveBackup(files []string) ([]string, error) {
 	choice := strings.TrimSpace(scanner.Text())
 	switch choice {
 	case "1":
-		r.out.Print("Proceeding with unencrypted safety backup...\n")
+		events.Info(r.sink, "Proceeding with unencrypted safety backup...\n")
 		return files, nil
 	case "2":
-		r.out.Print("Skipping sensitive files in safety backup...\n")
+		events.Info(r.sink, "Skipping sensitive files in safety backup...\n")
 		return r.filterSensitiveFiles(files), nil
 	case "3", "":
 		return nil, errors.New("restore cancelled by user")
@@ -170,8 +340,66 @@ func (r *Restore) filterSensitiveFiles(files []string) []string {
 	return filtered
 }
 
-// Run executes the restore from an archive.
+// Run executes the restore from an archive between its pre_restore and
+// post_restore hooks. Hooks do not run for dry runs.
 func (r *Restore) Run(archivePath string) (*metadata.RestoreResult, error) {
+	return r.RunContext(context.Background(), archivePath)
+}
+
+// RunContext is Run, stopped between archive entries when ctx is done. A
+// transactional restore then changes nothing; otherwise the files restored
+// so far stay in place. The result reports an ErrCanceled error.
+func (r *Restore) RunContext(ctx context.Context, archivePath string) (*metadata.RestoreResult, error) {
+	if r != nil {
+		r.ctx = ctx
+	}
+	return r.withHooks(archivePath, func() (*metadata.RestoreResult, error) {
+		return r.run(archivePath)
+	})
+}
+
+// lock locks the backup directory, which the safety backup is written to,
+// against overlapping runs.
+func (r *Restore) lock() (*lock.Lock, error) {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return lock.Acquire(ctx, r.cfg.Backup.BackupDir, "restore", r.opts.Wait, r.sink)
+}
+
+// withHooks runs do, which restores archive, between the pre_restore and
+// post_restore hooks.
+func (r *Restore) withHooks(archive string, do func() (*metadata.RestoreResult, error)) (*metadata.RestoreResult, error) {
+	if r == nil || r.cfg == nil || r.opts.DryRun {
+		return do()
+	}
+
+	ran, err := hooks.Run(r.cfg.Hooks, hooks.PreRestore, r.homeDir, []string{"DOTPAK_ARCHIVE=" + archive}, r.sink)
+	if err != nil {
+		result := &metadata.RestoreResult{Archive: archive, Hooks: ran}
+		result.SetError(err)
+		return result, nil
+	}
+
+	result, err := do()
+	if err != nil {
+		return result, err
+	}
+	result.Hooks = ran
+
+	env := hooks.ResultEnv(archive, result.Success, result.Error)
+	ran, err = hooks.Run(r.cfg.Hooks, hooks.PostRestore, r.homeDir, env, r.sink)
+	result.Hooks = append(result.Hooks, ran...)
+	if err != nil && result.Success {
+		result.Success = false
+		result.SetError(err)
+	}
+	return result, nil
+}
+
+func (r *Restore) run(archivePath string) (*metadata.RestoreResult, error) {
+	began := time.Now()
 	result := &metadata.RestoreResult{
 		Success: false,
 		Archive: archivePath,
@@ -179,60 +407,174 @@ func (r *Restore) Run(archivePath string) (*metadata.RestoreResult, error) {
 	}
 
 	if r == nil {
-		result.Error = "restore not initialized (home directory error)"
+		result.SetError(errors.New("restore not initialized (home directory error)"))
 		return result, fmt.Errorf("%s", result.Error)
 	}
 
 	result.Categories = r.opts.Categories
+	result.Files = r.opts.Files
+	if r.opts.Target != "" {
+		result.Target = r.homeDir
+	}
 
 	if _, err := os.Stat(archivePath); err != nil {
-		result.Error = fmt.Sprintf("archive not found: %s", archivePath)
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
 		return result, nil
 	}
 
-	tarPath := archivePath
-	needsDecrypt := strings.HasSuffix(archivePath, ".age") || strings.HasSuffix(archivePath, ".gpg")
-
-	if needsDecrypt {
-		r.out.Print("Decrypting archive...\n")
-		decrypted, err := r.decryptArchive(archivePath)
+	if !r.opts.DryRun {
+		l, err := r.lock()
 		if err != nil {
-			result.Error = fmt.Sprintf("decryption failed: %v", err)
+			result.SetError(err)
 			return result, nil
 		}
-		tarPath = decrypted
-		defer os.Remove(tarPath)
+		defer l.Release()
+	}
+
+	chain, err := r.loadChain(archivePath)
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	if len(chain) > 1 {
+		result.Chain = chain
+		events.Info(r.sink, "Incremental backup: restoring from %d archives\n", len(chain))
+	}
+	r.rewriter = r.newRewriter(r.sourceHome)
+	if r.levels, err = restoreLevels(r.cfg); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	if r.modes, err = newModePolicy(r.cfg, r.opts); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	if result.Verified, err = r.verifyChain(chain); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	tarPaths, cleanup, err := r.decryptChain(chain)
+	defer cleanup()
+	if err != nil {
+		result.SetError(fmt.Errorf("decryption failed: %w", err))
+		return result, nil
+	}
+	if err = r.checkFeatures(tarPaths); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+
+	if result.Target != "" {
+		events.Info(r.sink, "Restoring into %s\n", result.Target)
+		if !r.opts.DryRun {
+			if err = os.MkdirAll(result.Target, 0700); err != nil {
+				result.SetError(fmt.Errorf("creating restore target: %w", err))
+				return result, nil
+			}
+		}
 	}
 
 	if !r.opts.NoBackup && !r.opts.DryRun {
-		safetyPath, err := r.createSafetyBackup(tarPath, archivePath)
+		events.StartPhase(r.sink, events.PhaseSafetyBackup, "")
+		start := time.Now()
+		safetyPath, saved, err := r.createSafetyBackup(tarPaths[0], archivePath)
+		r.recordIO(events.PhaseSafetyBackup, start, saved, 0, fileSize(safetyPath))
 		if err != nil {
-			r.out.Warning("Failed to create safety backup: %v\n", err)
+			events.Warning(r.sink, "Failed to create safety backup: %v\n", err)
 		} else if safetyPath != "" {
 			result.SafetyBackup = safetyPath
-			r.out.Print("Created safety backup: %s\n", filepath.Base(safetyPath))
+			events.Info(r.sink, "Created safety backup: %s\n", filepath.Base(safetyPath))
 		}
 	}
 
 	if r.opts.DryRun {
-		r.out.Print("\nDry run - would restore:\n")
+		events.StartPhase(r.sink, events.PhaseExtract, "\nDry run - would restore:\n")
 	} else {
-		r.out.Print("\nRestoring files...\n")
+		events.StartPhase(r.sink, events.PhaseExtract, "\nRestoring files...\n")
 	}
 
-	count, err := r.extractArchive(tarPath)
-	if err != nil {
-		result.Error = fmt.Sprintf("extraction failed: %v", err)
+	if r.opts.Transactional && !r.opts.DryRun {
+		if r.tx, err = newTransaction(r.fsys(), r.homeDir); err != nil {
+			result.SetError(err)
+			return result, nil
+		}
+	}
+
+	r.pending = r.manifestPaths()
+	start := time.Now()
+	var tarSize int64
+	for _, tarPath := range tarPaths {
+		tarSize += fileSize(tarPath)
+	}
+	count, extractErr := r.extractLevels(tarPaths)
+	if extractErr != nil {
+		if r.tx != nil {
+			r.tx.discard()
+			r.restored = nil
+			extractErr = fmt.Errorf("%w (no files were changed)", extractErr)
+		}
+		if errors.Is(extractErr, errs.ErrCanceled) {
+			r.reportCanceled(result, tarPaths)
+		}
+		r.stats.FilesRestored = count
+		r.reportStats(result, began)
+		result.SetError(fmt.Errorf("extraction failed: %w", extractErr))
 		return result, nil
 	}
+	if r.writer != nil {
+		if err = r.writer.finish(); err != nil {
+			if r.tx != nil {
+				r.tx.discard()
+			}
+			result.SetError(fmt.Errorf("flushing restored files: %w", err))
+			return result, nil
+		}
+	}
+	if r.tx != nil {
+		events.Detail(r.sink, "Moving %d staged entries into place\n", len(r.tx.entries))
+		if err = r.tx.commit(); err != nil {
+			result.RolledBack = true
+			result.SetError(fmt.Errorf("applying restore failed, rolled back: %w", err))
+			return result, nil
+		}
+	}
+
+	r.recordIO(events.PhaseExtract, start, count, tarSize, r.stats.BytesWritten)
 
 	result.Success = true
+	result.Rewrites = r.rewrites
+	result.CaseCollisions = r.caseCollisions
+	result.Unsupported = slices.Sorted(maps.Keys(r.unsupported))
+	if len(result.Unsupported) > 0 {
+		events.Warning(r.sink, "Left out %d files that need a newer dotpak\n", len(result.Unsupported))
+	}
+	result.Conflicts = r.conflicts
+	result.ModeChanges = r.modeChanges
+	r.stats.FilesRestored = count
+	r.reportStats(result, began)
 
 	if r.opts.DryRun {
-		r.out.Print("\nWould restore %d files\n", count)
+		events.Info(r.sink, "\nWould restore %d files\n", count)
+		r.reportPreview(result)
 	} else {
-		r.out.Success("\nRestored %d files\n", count)
+		events.Success(r.sink, "\nRestored %d files\n", count)
+		if r.stats.FilesSkipped > 0 {
+			events.Info(r.sink, "  Skipped: %d\n", r.stats.FilesSkipped)
+		}
+		if r.stats.FilesFailed > 0 {
+			events.Info(r.sink, "  Failed: %d\n", r.stats.FilesFailed)
+		}
+		events.Info(r.sink, "  Written: %s in %s\n", formatSize(r.stats.BytesWritten),
+			time.Duration(result.Stats.DurationMS)*time.Millisecond)
+	}
+
+	if r.validateDue() {
+		r.validateRestored(result)
 	}
+	result.PostRestore = r.runPostRestore()
 
 	return result, nil
 }
@@ -245,42 +587,44 @@ func (r *Restore) decryptArchive(archivePath string) (string, error) {
 	_ = tmpFile.Close()
 	outputPath := tmpFile.Name()
 
-	if strings.HasSuffix(archivePath, ".age") {
-		return decryptWithAge(archivePath, outputPath, resolveAgeIdentityFiles(r.cfg))
-	}
-	if strings.HasSuffix(archivePath, ".gpg") {
-		return decryptWithGPG(archivePath, outputPath)
+	if file := r.verified[archivePath]; file != nil {
+		if err = r.readVerified(file, outputPath); err != nil {
+			_ = os.Remove(outputPath)
+			return "", err
+		}
+		return outputPath, nil
 	}
-
-	return "", errors.New("unknown encryption format")
+	return decryptFile(r.cfg, archivePath, outputPath)
 }
 
-func (r *Restore) createSafetyBackup(sourceArchive, originalArchive string) (string, error) {
+// createSafetyBackup saves the files the restore would overwrite and
+// returns the safety archive with the number of files in it.
+func (r *Restore) createSafetyBackup(sourceArchive, originalArchive string) (string, int, error) {
 	filesToBackup, err := r.findFilesToBackup(sourceArchive)
 	if err != nil {
-		return "", fmt.Errorf("scanning for files to backup: %w", err)
+		return "", 0, fmt.Errorf("scanning for files to backup: %w", err)
 	}
 
 	if len(filesToBackup) == 0 {
-		r.out.Verbose("No existing files to backup\n")
-		return "", nil
+		events.Detail(r.sink, "No existing files to backup\n")
+		return "", 0, nil
 	}
 
 	// check if safety backup contains sensitive files without encryption available
 	if r.containsSensitiveFiles(filesToBackup) && !r.canEncrypt() {
 		filesToBackup, err = r.promptForSensitiveBackup(filesToBackup)
 		if err != nil {
-			return "", err
+			return "", 0, err
 		}
 		if len(filesToBackup) == 0 {
-			r.out.Verbose("No files to backup after filtering\n")
-			return "", nil
+			events.Detail(r.sink, "No files to backup after filtering\n")
+			return "", 0, nil
 		}
 	}
 
 	preRestoreDir := filepath.Join(r.cfg.Backup.BackupDir, "pre-restore")
 	if err = os.MkdirAll(preRestoreDir, 0700); err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	timestamp := time.Now().Format("20060102_150405")
@@ -291,9 +635,10 @@ func (r *Restore) createSafetyBackup(sourceArchive, originalArchive string) (str
 		enc, encErr := crypto.NewEncryptor(method, crypto.Options{
 			AgeRecipientsFile: r.cfg.Backup.AgeRecipients,
 			GPGRecipient:      r.cfg.Backup.GPGRecipient,
+			PassphraseFile:    r.cfg.Backup.PassphraseFile,
 		})
 		if encErr != nil {
-			r.out.Warning("Failed to create encryptor for safety backup: %v\n", encErr)
+			events.Warning(r.sink, "Failed to create encryptor for safety backup: %v\n", encErr)
 			// fall through to unencrypted path below
 		} else {
 			encryptedPath := filepath.Join(preRestoreDir,
@@ -309,16 +654,16 @@ func (r *Restore) createSafetyBackup(sourceArchive, originalArchive string) (str
 			if encErr = enc.EncryptReader(pr, encryptedPath); encErr != nil {
 				_ = pr.Close() // unblock the writer goroutine
 				if writeErr := <-errCh; writeErr != nil {
-					r.out.Verbose("Safety archive write also failed: %v\n", writeErr)
+					events.Detail(r.sink, "Safety archive write also failed: %v\n", writeErr)
 				}
 				_ = os.Remove(encryptedPath)
-				r.out.Warning("Failed to encrypt safety backup: %v\n", encErr)
+				events.Warning(r.sink, "Failed to encrypt safety backup: %v\n", encErr)
 				// fall through to unencrypted path below
 			} else if writeErr := <-errCh; writeErr != nil {
 				_ = os.Remove(encryptedPath)
-				return "", writeErr
+				return "", 0, writeErr
 			} else {
-				return encryptedPath, nil
+				return encryptedPath, len(filesToBackup), nil
 			}
 		}
 	}
@@ -328,15 +673,15 @@ func (r *Restore) createSafetyBackup(sourceArchive, originalArchive string) (str
 
 	outFile, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	defer outFile.Close()
 
 	if err = r.writeSafetyArchive(outFile, filesToBackup); err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	return archivePath, nil
+	return archivePath, len(filesToBackup), nil
 }
 
 // writeSafetyArchive writes a tar.gz stream of the given files to w.
@@ -356,9 +701,9 @@ func (r *Restore) writeSafetyArchive(w io.Writer, filesToBackup []string) (err e
 	}()
 
 	for _, relPath := range filesToBackup {
-		fullPath := filepath.Join(r.homeDir, relPath)
-		if addErr := backup.AddFileToTar(tarWriter, fullPath, relPath); addErr != nil {
-			r.out.Verbose("Failed to backup %s: %v\n", relPath, addErr)
+		fullPath, _, _ := r.entryPath(relPath)
+		if addErr := backup.AddFileToTar(r.fsys(), tarWriter, fullPath, relPath); addErr != nil {
+			events.Detail(r.sink, "Failed to backup %s: %v\n", relPath, addErr)
 			continue
 		}
 	}
@@ -367,23 +712,23 @@ func (r *Restore) writeSafetyArchive(w io.Writer, filesToBackup []string) (err e
 }
 
 func (r *Restore) findFilesToBackup(sourceArchive string) ([]string, error) {
-	file, err := os.Open(sourceArchive)
-	if err != nil {
-		return nil, err
+	if r.manifest != nil {
+		// an incremental archive holds only changed files; the manifest
+		// lists everything the chain restores
+		return r.manifestFilesToBackup(), nil
 	}
-	defer file.Close()
 
-	gzReader, err := gzip.NewReader(file)
+	entries, closer, err := r.openEntries(sourceArchive)
 	if err != nil {
 		return nil, err
 	}
-	defer gzReader.Close()
+	defer closer.Close()
 
-	tarReader := tar.NewReader(gzReader)
 	var filesToBackup []string
+	var quota entryQuota
 
 	for {
-		header, nextErr := tarReader.Next()
+		header, nextErr := entries.Next()
 		if nextErr == io.EOF {
 			break
 		}
@@ -391,17 +736,24 @@ func (r *Restore) findFilesToBackup(sourceArchive string) ([]string, error) {
 			return nil, nextErr
 		}
 
-		if header.Typeflag == tar.TypeDir || !isSafePath(header.Name) {
+		if checkEntry(header) != nil || header.Typeflag == tar.TypeDir {
 			continue
 		}
 
-		if len(r.opts.Categories) > 0 && !r.matchesCategory(header.Name) {
+		if !r.selected(header.Name) {
 			continue
 		}
 
-		//nolint:gosec // g305: path validated by isSafePath() above
-		targetPath := filepath.Join(r.homeDir, header.Name)
-		if _, statErr := os.Stat(targetPath); statErr == nil {
+		// fail before the safety backup writes anything for an archive the
+		// extraction would give up on
+		if err = quota.charge(header); err != nil {
+			return nil, err
+		}
+		targetPath, _, ok := r.entryPath(header.Name)
+		if !ok {
+			continue
+		}
+		if _, statErr := r.fsys().Stat(targetPath); statErr == nil {
 			filesToBackup = append(filesToBackup, header.Name)
 		}
 	}
@@ -410,24 +762,22 @@ func (r *Restore) findFilesToBackup(sourceArchive string) ([]string, error) {
 }
 
 func (r *Restore) extractArchive(tarPath string) (int, error) {
-	file, err := os.Open(tarPath)
-	if err != nil {
-		return 0, err
-	}
-	defer file.Close()
-
-	gzReader, err := gzip.NewReader(file)
+	entries, closer, err := r.openEntries(tarPath)
 	if err != nil {
 		return 0, err
 	}
-	defer gzReader.Close()
+	defer closer.Close()
 
-	tarReader := tar.NewReader(gzReader)
 	count := 0
-	var totalExtracted int64
+	if r.writer == nil {
+		r.writer = newFileWriter(r.fsys(), r.opts.Fsync)
+	}
 
 	for {
-		header, nextErr := tarReader.Next()
+		if r.ctx != nil && r.ctx.Err() != nil {
+			return count, errs.Wrap(errs.ErrCanceled, r.ctx.Err())
+		}
+		header, nextErr := entries.Next()
 		if nextErr == io.EOF {
 			break
 		}
@@ -435,106 +785,339 @@ func (r *Restore) extractArchive(tarPath string) (int, error) {
 			return count, nextErr
 		}
 
-		if !isSafePath(header.Name) {
-			r.out.Warning("Skipping unsafe path: %s\n", header.Name)
+		entryErr := checkEntry(header)
+		if !r.inLevel(header.Name) {
+			continue
+		}
+		if entryErr != nil {
+			events.Warning(r.sink, "Skipping %s: %v\n", header.Name, entryErr)
+			r.stats.FilesSkipped++
 			continue
 		}
 
-		if len(r.opts.Categories) > 0 && !r.matchesCategory(header.Name) {
+		if !r.takeFromChain(header.Name) {
 			continue
 		}
 
-		//nolint:gosec // g305: path validated by isSafePath() above and isPathWithinBase() below
-		targetPath := filepath.Join(r.homeDir, header.Name)
+		if !r.selected(header.Name) {
+			continue
+		}
+
+		targetPath, base, ok := r.entryPath(header.Name)
+		if !ok {
+			events.Warning(r.sink, "Skipping %s: it belongs on the Windows side of WSL\n", header.Name)
+			r.stats.FilesSkipped++
+			continue
+		}
 
 		// defense-in-depth: verify resolved path is within home directory
-		if !isPathWithinBase(targetPath, r.homeDir) {
-			r.out.Warning("Skipping path that escapes home directory: %s\n", header.Name)
+		if !isPathWithinBase(targetPath, base) {
+			events.Warning(r.sink, "Skipping path that escapes home directory: %s\n", header.Name)
+			r.stats.FilesSkipped++
+			continue
+		}
+
+		if first, collides := r.caseCollision(header); collides {
+			events.Warning(r.sink, "Skipping %s: it would overwrite %s on this case-insensitive filesystem\n",
+				header.Name, first)
+			r.caseCollisions = append(r.caseCollisions, header.Name)
+			r.stats.FilesSkipped++
 			continue
 		}
 
 		if r.opts.DryRun {
-			r.out.Print("  %s\n", header.Name)
+			if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeDir {
+				r.restoreMode(header.Name, header.Mode, header.Typeflag == tar.TypeDir)
+			}
+			switch header.Typeflag {
+			case tar.TypeReg:
+				content, size, rwErr := r.content(header, entries)
+				if rwErr != nil {
+					events.Warning(r.sink, "Failed to read %s: %v\n", header.Name, rwErr)
+				} else {
+					r.previewEntry(header, targetPath, content, size)
+				}
+			case tar.TypeSymlink:
+				r.previewEntry(header, targetPath, nil, 0)
+			default:
+				events.Info(r.sink, "  %-9s %s\n", "", header.Name)
+			}
+			r.noteRestored(header.Name)
 			count++
 			continue
 		}
 
-		if totalExtracted+header.Size > osutils.MaxExtractTotalSize {
-			return count, fmt.Errorf(
-				"total extracted size exceeds limit of %s",
-				osutils.FormatSize(osutils.MaxExtractTotalSize),
-			)
+		if quotaErr := r.quota.charge(header); quotaErr != nil {
+			return count, quotaErr
 		}
 
-		if mkdirErr := os.MkdirAll(filepath.Dir(targetPath), 0755); mkdirErr != nil {
-			r.out.Warning("Failed to create directory for %s: %v\n", header.Name, mkdirErr)
+		// with a transaction, entries are written to the stage and any
+		// failure aborts the restore instead of skipping the entry. The
+		// stage is in the home directory, so wsl.items are written in place.
+		writePath := targetPath
+		staged := r.tx != nil && base == r.homeDir
+		if staged {
+			writePath = r.tx.path(header.Name)
+		}
+
+		if mkdirErr := r.fsys().MkdirAll(filepath.Dir(writePath), 0755); mkdirErr != nil {
+			if r.tx != nil {
+				return count, fmt.Errorf("creating directory for %s: %w", header.Name, mkdirErr)
+			}
+			events.Warning(r.sink, "Failed to create directory for %s: %v\n", header.Name, mkdirErr)
+			r.stats.FilesFailed++
 			continue
 		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			//nolint:gosec // g115: mode is masked to valid 9-bit permission range before conversion
-			if mkdirErr := os.MkdirAll(targetPath, os.FileMode(header.Mode)&0o777); mkdirErr != nil {
-				r.out.Warning("Failed to create directory %s: %v\n", header.Name, mkdirErr)
+			mode := r.restoreMode(header.Name, header.Mode, true)
+			if staged {
+				r.tx.addDir(header.Name, mode)
+				continue
+			}
+			if mkdirErr := r.fsys().MkdirAll(targetPath, mode); mkdirErr != nil {
+				events.Warning(r.sink, "Failed to create directory %s: %v\n", header.Name, mkdirErr)
 			}
 
 		case tar.TypeReg:
-			//nolint:gosec // g115: mode is masked to valid 9-bit permission range before conversion
-			if extractErr := extractFile(
-				tarReader,
-				targetPath,
-				os.FileMode(header.Mode)&0o777,
-				osutils.MaxExtractFileSize,
-			); extractErr != nil {
-				r.out.Warning("Failed to extract %s: %v\n", header.Name, extractErr)
+			events.FileStarted(r.sink, header.Name, count+1, 0)
+			name := header.Name
+			content, size, extractErr := r.content(header, entries)
+			resolution := ConflictOverwrite
+			if extractErr == nil {
+				content, resolution, extractErr = r.resolveConflict(header, targetPath, content, size)
+			}
+			if resolution == ConflictKeep {
+				events.FileDone(r.sink, header.Name, count+1, 0, header.Size, nil)
+				r.stats.FilesSkipped++
 				continue
 			}
-			totalExtracted += header.Size
+			if resolution == ConflictRename {
+				name += RestoredSuffix
+				writePath += RestoredSuffix
+			}
+			if extractErr == nil {
+				extractErr = r.writer.extract(
+					content,
+					writePath,
+					r.restoreMode(header.Name, header.Mode, false),
+					size,
+					osutils.MaxExtractFileSize,
+				)
+			}
+			events.FileDone(r.sink, header.Name, count+1, 0, header.Size, extractErr)
+			if extractErr != nil {
+				if r.tx != nil {
+					return count, fmt.Errorf("extracting %s: %w", header.Name, extractErr)
+				}
+				events.Warning(r.sink, "Failed to extract %s: %v\n", header.Name, extractErr)
+				r.stats.FilesFailed++
+				continue
+			}
+			if staged {
+				r.tx.add(name)
+			}
+			r.stats.BytesWritten += size
+			r.preserveMetadata(header, writePath)
+			// hard links to a file restored under another name link to it there
+			r.noteLinkTarget(header.Name, writePath)
+			if resolution != ConflictRename {
+				// the local file is unchanged, so post_restore has nothing to do
+				r.noteRestored(header.Name)
+			}
+			r.restored = append(r.restored, name)
+			count++
+
+		case tar.TypeLink:
+			name := header.Name
+			_, resolution, _ := r.resolveConflict(header, targetPath, nil, 0)
+			if resolution == ConflictKeep {
+				r.stats.FilesSkipped++
+				continue
+			}
+			if resolution == ConflictRename {
+				name += RestoredSuffix
+				writePath += RestoredSuffix
+			}
+			if linkErr := r.extractLink(header, writePath); linkErr != nil {
+				if r.tx != nil {
+					return count, fmt.Errorf("extracting %s: %w", header.Name, linkErr)
+				}
+				events.Warning(r.sink, "Failed to extract %s: %v\n", header.Name, linkErr)
+				r.stats.FilesFailed++
+				continue
+			}
+			if staged {
+				r.tx.add(name)
+			}
+			r.preserveMetadata(header, writePath)
+			if resolution != ConflictRename {
+				r.noteRestored(header.Name)
+			}
+			r.restored = append(r.restored, name)
 			count++
 
 		case tar.TypeSymlink:
 			if !isSafePath(header.Linkname) {
-				r.out.Warning("Skipping symlink with unsafe target: %s -> %s\n", header.Name, header.Linkname)
+				events.Warning(r.sink, "Skipping symlink with unsafe target: %s -> %s\n", header.Name, header.Linkname)
+				r.stats.FilesSkipped++
 				continue
 			}
 			// defense-in-depth: verify resolved symlink target is within home
 			//nolint:gosec // g305: path validated by isPathWithinBase() immediately below
 			resolvedTarget := filepath.Join(filepath.Dir(targetPath), header.Linkname)
-			if !isPathWithinBase(resolvedTarget, r.homeDir) {
-				r.out.Warning("Skipping symlink that escapes home: %s -> %s\n", header.Name, header.Linkname)
+			if !isPathWithinBase(resolvedTarget, base) {
+				events.Warning(r.sink, "Skipping symlink that escapes home: %s -> %s\n", header.Name, header.Linkname)
+				r.stats.FilesSkipped++
+				continue
+			}
+			name := header.Name
+			_, resolution, _ := r.resolveConflict(header, targetPath, nil, 0)
+			if resolution == ConflictKeep {
+				r.stats.FilesSkipped++
 				continue
 			}
-			if rmErr := os.Remove(targetPath); rmErr != nil && !os.IsNotExist(rmErr) {
-				r.out.Warning("Failed to remove existing file for symlink %s: %v\n", header.Name, rmErr)
+			if resolution == ConflictRename {
+				name += RestoredSuffix
+				writePath += RestoredSuffix
+			}
+			if rmErr := r.fsys().Remove(writePath); rmErr != nil && !os.IsNotExist(rmErr) {
+				events.Warning(r.sink, "Failed to remove existing file for symlink %s: %v\n", header.Name, rmErr)
+			}
+			if linkErr := r.fsys().Symlink(filepath.FromSlash(header.Linkname), writePath); linkErr != nil {
+				if osutils.SymlinkNotPermitted(linkErr) {
+					events.Warning(r.sink, "Skipping symlink %s -> %s: creating symlinks on Windows needs "+
+						"Developer Mode or an elevated shell\n", header.Name, header.Linkname)
+					r.stats.FilesSkipped++
+					continue
+				}
+				if r.tx != nil {
+					return count, fmt.Errorf("creating symlink %s: %w", header.Name, linkErr)
+				}
+				events.Warning(r.sink, "Failed to create symlink %s: %v\n", header.Name, linkErr)
+				r.stats.FilesFailed++
+				continue
 			}
-			if linkErr := os.Symlink(header.Linkname, targetPath); linkErr != nil {
-				r.out.Warning("Failed to create symlink %s: %v\n", header.Name, linkErr)
+			if staged {
+				r.tx.add(name)
 			}
+			r.preserveMetadata(header, writePath)
+			if resolution != ConflictRename {
+				r.noteRestored(header.Name)
+			}
+			r.restored = append(r.restored, name)
+			count++
 		}
 	}
 
 	return count, nil
 }
 
-func (r *Restore) matchesCategory(path string) bool {
-	path = strings.TrimPrefix(path, "./")
-	path = strings.TrimPrefix(path, "/")
+// caseCollision returns the name of the file, symlink, or hard link
+// restored earlier that the entry of header would overwrite because their
+// names differ only in case, if the home directory is on a case-insensitive
+// filesystem.
+// Archives made on Linux can hold both Foo and foo.
+func (r *Restore) caseCollision(header *tar.Header) (string, bool) {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+	default:
+		return "", false
+	}
+	if !r.caseChecked {
+		r.caseChecked = true
+		// only the OS filesystem is probed; Options.FS is taken to be
+		// case-sensitive
+		if r.fsys() == fsys.OS && osutils.CaseInsensitive(r.homeDir) {
+			r.foldedNames = make(map[string]string)
+		}
+	}
+	if r.foldedNames == nil {
+		return "", false
+	}
+	folded := strings.ToLower(header.Name)
+	if first, ok := r.foldedNames[folded]; ok && first != header.Name {
+		return first, true
+	}
+	r.foldedNames[folded] = header.Name
+	return "", false
+}
+
+// reportStats records the statistics and IO profile of the restore, which
+// began at began, in result.
+func (r *Restore) reportStats(result *metadata.RestoreResult, began time.Time) {
+	result.Stats = r.stats
+	result.Stats.DurationMS = time.Since(began).Milliseconds()
+	result.IOProfile = r.ioProfile
+}
+
+// recordIO adds the measurements of a phase that began at start to the IO
+// profile.
+func (r *Restore) recordIO(phase events.Phase, start time.Time, files int, bytesRead, bytesWritten int64) {
+	r.ioProfile = append(r.ioProfile, metadata.IOPhase{
+		Phase:        string(phase),
+		DurationMS:   time.Since(start).Milliseconds(),
+		Files:        files,
+		BytesRead:    bytesRead,
+		BytesWritten: bytesWritten,
+	})
+}
 
-	for _, cat := range r.opts.Categories {
-		prefixes, ok := Categories[strings.ToLower(cat)]
-		if !ok {
+// fileSize returns the size of the file at path, or 0 if it has none.
+func fileSize(path string) int64 {
+	if path == "" {
+		return 0
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// reportCanceled records in result which selected files and symlinks of
+// tarPaths a canceled restore wrote and which it did not get to, by reading
+// the archives again without extracting them.
+func (r *Restore) reportCanceled(result *metadata.RestoreResult, tarPaths []string) {
+	if r.opts.DryRun {
+		return
+	}
+	result.Restored = r.restored
+	done := make(map[string]bool, len(r.restored))
+	for _, name := range r.restored {
+		done[name] = true
+	}
+
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		entries, closer, err := r.openEntries(tarPath)
+		if err != nil {
 			continue
 		}
-
-		for _, prefix := range prefixes {
-			prefix = strings.TrimPrefix(prefix, "./")
-			if strings.HasPrefix(path, prefix) {
-				return true
+		for {
+			header, nextErr := entries.Next()
+			if nextErr != nil {
+				break
+			}
+			if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink {
+				continue
+			}
+			if !isSafePath(header.Name) || !r.takeFromChain(header.Name) || !r.selected(header.Name) {
+				continue
+			}
+			if !done[header.Name] {
+				result.NotRestored = append(result.NotRestored, header.Name)
 			}
 		}
+		_ = closer.Close()
 	}
 
-	return false
+	events.Warning(r.sink, "Restore interrupted: %d files restored, %d not restored\n",
+		len(result.Restored), len(result.NotRestored))
+	for _, name := range result.NotRestored {
+		events.Detail(r.sink, "  not restored: %s\n", name)
+	}
 }
 
 func isSafePath(path string) bool {
@@ -545,7 +1128,9 @@ func isSafePath(path string) bool {
 	if strings.ContainsRune(path, '\x00') {
 		return false
 	}
-	if filepath.IsAbs(path) {
+	// a volume name (C:, \\server\share) makes the path absolute or
+	// drive-relative on Windows
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
 		return false
 	}
 	if strings.HasPrefix(path, "/") || strings.HasPrefix(path, "~") {
@@ -579,35 +1164,14 @@ func isPathWithinBase(targetPath, baseDir string) bool {
 	return strings.HasPrefix(absTarget, absBase+string(filepath.Separator)) || absTarget == absBase
 }
 
-func extractFile(r io.Reader, path string, mode os.FileMode, maxSize int64) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	limitedReader := io.LimitReader(r, maxSize)
-	written, err := io.Copy(file, limitedReader)
-	if err != nil {
-		return err
-	}
-
-	if written == maxSize {
-		buf := make([]byte, 1)
-		if n, _ := r.Read(buf); n > 0 {
-			return fmt.Errorf("file exceeds maximum size limit of %d bytes", maxSize)
-		}
-	}
-
-	return nil
-}
-
-// ListArchiveContents lists the contents of an archive.
-func ListArchiveContents(cfg *config.Config, archivePath string, out *output.Output) error {
+// ListArchiveContents lists the contents of an archive, limited to the given
+// categories if any. If verifyHome is set, each entry is annotated with how
+// the file in that directory compares to it: same, differs, or missing.
+func ListArchiveContents(cfg *config.Config, archivePath string, categories []string, verifyHome string,
+	out *output.Output) error {
 	tarPath := archivePath
-	identityFiles := resolveAgeIdentityFiles(cfg)
 
-	if strings.HasSuffix(archivePath, ".age") || strings.HasSuffix(archivePath, ".gpg") {
+	if hasEncryptionSuffix(archivePath) {
 		tmpFile, err := osutils.CreateTempFile("dotpak-list-*.tar.gz")
 		if err != nil {
 			return err
@@ -615,15 +1179,7 @@ func ListArchiveContents(cfg *config.Config, archivePath string, out *output.Out
 		_ = tmpFile.Close()
 		defer os.Remove(tmpFile.Name())
 
-		var decrypted string
-		var decryptErr error
-
-		if strings.HasSuffix(archivePath, ".age") {
-			decrypted, decryptErr = decryptWithAge(archivePath, tmpFile.Name(), identityFiles)
-		} else {
-			decrypted, decryptErr = decryptWithGPG(archivePath, tmpFile.Name())
-		}
-
+		decrypted, decryptErr := decryptFile(cfg, archivePath, tmpFile.Name())
 		if decryptErr != nil {
 			return decryptErr
 		}
@@ -631,54 +1187,120 @@ func ListArchiveContents(cfg *config.Config, archivePath string, out *output.Out
 		defer os.Remove(tarPath)
 	}
 
-	file, err := os.Open(tarPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	gzReader, err := gzip.NewReader(file)
+	entries, closer, err := openArchive(tarPath)
 	if err != nil {
 		return err
 	}
-	defer gzReader.Close()
+	defer closer.Close()
 
-	tarReader := tar.NewReader(gzReader)
+	prefixes := CategoryPrefixes(cfg)
 
 	out.Print("Archive contents:\n\n")
 
+	counts := make(map[string]int)
 	for {
-		header, nextErr := tarReader.Next()
+		header, nextErr := entries.Next()
 		if nextErr == io.EOF {
 			break
 		}
 		if nextErr != nil {
 			return nextErr
 		}
+		if len(categories) > 0 && !matchesCategory(prefixes, categories, header.Name) {
+			continue
+		}
 
 		size := formatSize(header.Size)
-		out.Print("  %-50s %10s\n", header.Name, size)
+		if verifyHome == "" {
+			out.Print("  %-50s %10s\n", header.Name, size)
+			continue
+		}
+		status := localStatus(verifyHome, header, entries)
+		counts[status]++
+		out.Print("  %-50s %10s  %s\n", header.Name, size, status)
 	}
 
+	if verifyHome != "" {
+		out.Print("\nSummary: %d same, %d differ, %d missing\n",
+			counts[statusSame], counts[statusDiffers], counts[statusMissing])
+	}
 	return nil
 }
 
+// The local statuses of archive entries shown by contents --verify-against-home.
+const (
+	statusSame    = "same"
+	statusDiffers = "differs"
+	statusMissing = "missing"
+)
+
+// localStatus compares the file at header.Name in home to the archive entry,
+// whose content is read from content: same, differs, or missing. Regular
+// files are compared by size, then by SHA-256; symlinks by target; and
+// directories only by existing.
+func localStatus(home string, header *tar.Header, content io.Reader) string {
+	//nolint:gosec // g305: path used only for comparison, no extraction
+	localPath := filepath.Join(home, header.Name)
+	info, err := os.Lstat(localPath)
+	if os.IsNotExist(err) {
+		return statusMissing
+	}
+	if err != nil {
+		// permission denied, etc. - the file cannot be the same
+		return statusDiffers
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if info.IsDir() {
+			return statusSame
+		}
+	case tar.TypeSymlink:
+		if target, linkErr := os.Readlink(localPath); linkErr == nil && target == header.Linkname {
+			return statusSame
+		}
+	case tar.TypeReg:
+		if info.Mode().IsRegular() && info.Size() == header.Size && sameContent(localPath, content) {
+			return statusSame
+		}
+	}
+	return statusDiffers
+}
+
+// sameContent reports whether the file at path has the content read from r.
+func sameContent(path string, r io.Reader) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	local, archived := sha256.New(), sha256.New()
+	if _, err = io.Copy(local, f); err != nil {
+		return false
+	}
+	if _, err = io.Copy(archived, r); err != nil {
+		return false
+	}
+	return bytes.Equal(local.Sum(nil), archived.Sum(nil))
+}
+
 // fileContent holds file content for diff display.
 type fileContent struct {
 	name    string
 	archive string // content from archive
 }
 
-// ShowDiff shows differences between archive and current files.
-func ShowDiff(cfg *config.Config, archivePath string, verbose bool, out *output.Output) error {
+// ShowDiff shows differences between archive and current files, limited to
+// the given categories if any.
+func ShowDiff(cfg *config.Config, archivePath string, categories []string, verbose bool, out *output.Output) error {
 	home, err := osutils.HomeDir()
 	if err != nil {
 		return err
 	}
 	tarPath := archivePath
-	identityFiles := resolveAgeIdentityFiles(cfg)
 
-	if strings.HasSuffix(archivePath, ".age") || strings.HasSuffix(archivePath, ".gpg") {
+	if hasEncryptionSuffix(archivePath) {
 		tmpFile, tmpErr := osutils.CreateTempFile("dotpak-diff-*.tar.gz")
 		if tmpErr != nil {
 			return tmpErr
@@ -686,15 +1308,7 @@ func ShowDiff(cfg *config.Config, archivePath string, verbose bool, out *output.
 		_ = tmpFile.Close()
 		defer os.Remove(tmpFile.Name())
 
-		var decrypted string
-		var decryptErr error
-
-		if strings.HasSuffix(archivePath, ".age") {
-			decrypted, decryptErr = decryptWithAge(archivePath, tmpFile.Name(), identityFiles)
-		} else {
-			decrypted, decryptErr = decryptWithGPG(archivePath, tmpFile.Name())
-		}
-
+		decrypted, decryptErr := decryptFile(cfg, archivePath, tmpFile.Name())
 		if decryptErr != nil {
 			return decryptErr
 		}
@@ -702,25 +1316,19 @@ func ShowDiff(cfg *config.Config, archivePath string, verbose bool, out *output.
 		defer os.Remove(tarPath)
 	}
 
-	file, err := os.Open(tarPath)
+	entries, closer, err := openArchive(tarPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer closer.Close()
 
-	gzReader, err := gzip.NewReader(file)
-	if err != nil {
-		return err
-	}
-	defer gzReader.Close()
-
-	tarReader := tar.NewReader(gzReader)
+	prefixes := CategoryPrefixes(cfg)
 
 	var newFiles, unchangedFiles []string
 	var modifiedFiles []fileContent
 
 	for {
-		header, nextErr := tarReader.Next()
+		header, nextErr := entries.Next()
 		if errors.Is(nextErr, io.EOF) {
 			break
 		}
@@ -731,6 +1339,9 @@ func ShowDiff(cfg *config.Config, archivePath string, verbose bool, out *output.
 		if header.Typeflag != tar.TypeReg {
 			continue
 		}
+		if len(categories) > 0 && !matchesCategory(prefixes, categories, header.Name) {
+			continue
+		}
 
 		//nolint:gosec // g305: path used only for stat comparison, no extraction
 		currentPath := filepath.Join(home, header.Name)
@@ -749,7 +1360,7 @@ func ShowDiff(cfg *config.Config, archivePath string, verbose bool, out *output.
 		// read archive content to compare
 		var archiveContent []byte
 		if header.Size < 10*1024*1024 { // limit to 10MB
-			archiveContent, _ = io.ReadAll(io.LimitReader(tarReader, header.Size))
+			archiveContent, _ = io.ReadAll(io.LimitReader(entries, header.Size))
 		}
 
 		// compare by size first, then by content
@@ -811,9 +1422,15 @@ func showFileDiff(home string, fc fileContent, out *output.Output) {
 	if err != nil {
 		return
 	}
+	showContentDiff(string(currentContent), fc.archive, out)
+}
 
+// showContentDiff displays the lines removed from and added to from to
+// reach to.
+func showContentDiff(from, to string, out *output.Output) {
 	dmp := diffmatchpatch.New()
-	diffs := dmp.DiffMain(string(currentContent), fc.archive, false)
+	fromChars, toChars, lines := dmp.DiffLinesToChars(from, to)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(fromChars, toChars, false), lines)
 
 	// count changes and collect diff lines
 	var diffLines []struct {
@@ -0,0 +1,156 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"io"
+	"slices"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// DefaultRewriteFiles are the files rewritten on restore when [rewrite]
+// does not list any: configs that commonly hold absolute paths into the
+// home directory.
+var DefaultRewriteFiles = []string{
+	".gitconfig", ".config/git/**",
+	".profile", ".bashrc", ".bash_profile", ".zshrc", ".zshenv", ".zprofile", ".config/fish/**",
+	".ssh/config", ".npmrc", ".tmux.conf", ".vimrc", ".config/nvim/**", ".config/starship.toml",
+	"Documents/PowerShell/*.ps1", "Documents/WindowsPowerShell/*.ps1", ".wslconfig",
+}
+
+// maxRewriteSize is the largest file rewritten; config files are small, and
+// larger files are restored unchanged.
+const maxRewriteSize = 1 << 20
+
+// rewriter replaces strings in the content of restored config files: the
+// home directory a backup was made in with the one it is restored to, and
+// the [rewrite] map.
+type rewriter struct {
+	// from and to are the replacements, longest from first so that a
+	// longer prefix wins.
+	from, to []string
+	files    []string
+}
+
+// newRewriter returns the rewriter of a restore into homeDir of a backup
+// made in sourceHome, or nil if there is nothing to replace. A restore into
+// Options.Target replaces sourceHome only with Options.RewriteTarget.
+func (r *Restore) newRewriter(sourceHome string) *rewriter {
+	if r.opts.NoRewrite {
+		return nil
+	}
+	replace := make(map[string]string, len(r.cfg.Rewrite.Map)+1)
+	if sourceHome != "" && sourceHome != r.homeDir && (r.opts.Target == "" || r.opts.RewriteTarget) {
+		replace[sourceHome] = r.homeDir
+	}
+	for from, to := range r.cfg.Rewrite.Map {
+		if from != "" && from != to {
+			replace[from] = to
+		}
+	}
+	if len(replace) == 0 {
+		return nil
+	}
+
+	rw := &rewriter{files: r.rewriteFiles()}
+	for from := range replace {
+		rw.from = append(rw.from, from)
+	}
+	slices.SortFunc(rw.from, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return cmp.Compare(a, b)
+	})
+	for _, from := range rw.from {
+		rw.to = append(rw.to, replace[from])
+	}
+	return rw
+}
+
+// rewriteFiles returns the patterns of the files restore rewrites.
+func (r *Restore) rewriteFiles() []string {
+	if len(r.cfg.Rewrite.Files) > 0 {
+		return r.cfg.Rewrite.Files
+	}
+	return DefaultRewriteFiles
+}
+
+// matches reports whether the archive entry name is a file to rewrite.
+func (rw *rewriter) matches(name string, size int64) bool {
+	return rw != nil && size <= maxRewriteSize && matchesFiles(rw.files, name)
+}
+
+// apply returns content with the replacements made, and the number of
+// replacements of each from string. A replacement only matches where the
+// next byte does not continue a name, so /home/al leaves /home/alice alone.
+// Binary content is returned unchanged.
+func (rw *rewriter) apply(content []byte) ([]byte, []int) {
+	counts := make([]int, len(rw.from))
+	if bytes.IndexByte(content, 0) >= 0 {
+		return content, counts
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for i := 0; i < len(content); {
+		matched := -1
+		for j, from := range rw.from {
+			end := i + len(from)
+			if end <= len(content) && string(content[i:end]) == from &&
+				(end == len(content) || !isNameByte(content[end])) {
+				matched = j
+				break
+			}
+		}
+		if matched < 0 {
+			i++
+			continue
+		}
+		out.Write(content[last:i])
+		out.WriteString(rw.to[matched])
+		counts[matched]++
+		i += len(rw.from[matched])
+		last = i
+	}
+	if last == 0 {
+		return content, counts
+	}
+	out.Write(content[last:])
+	return out.Bytes(), counts
+}
+
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// content returns the content to restore for a regular file entry and its
+// size: the entry itself, or for a file to rewrite its rewritten content,
+// recording and reporting each replacement made.
+func (r *Restore) content(header *tar.Header, entry io.Reader) (io.Reader, int64, error) {
+	if !r.rewriter.matches(header.Name, header.Size) {
+		return entry, header.Size, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(entry, maxRewriteSize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	name := header.Name
+	data, counts := r.rewriter.apply(data)
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		change := metadata.Rewrite{Path: name, From: r.rewriter.from[i], To: r.rewriter.to[i], Count: count}
+		r.rewrites = append(r.rewrites, change)
+		verb := "Rewrote"
+		if r.opts.DryRun {
+			verb = "Would rewrite"
+		}
+		events.Info(r.sink, "  %s %s -> %s in %s (%d)\n", verb, change.From, change.To, name, count)
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
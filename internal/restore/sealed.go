@@ -0,0 +1,140 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/osutils"
+)
+
+// openEntries opens an unencrypted archive like openArchive, decrypting the
+// sensitive files that backup encrypted on their own with
+// backup.sensitive_encryption. Without age or an age identity file, they
+// are read as they are stored, under their .age names; one that the
+// identities do not decrypt fails to read. Each is decrypted once per
+// restore, however often the archive is read, and only if it is selected.
+func (r *Restore) openEntries(path string) (archiveReader, io.Closer, error) {
+	entries, closer, err := openArchive(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &unsealReader{archiveReader: entries, r: r, path: path}, closer, nil
+}
+
+// sealed reports whether header is a sensitive file encrypted on its own.
+func sealed(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeReg &&
+		header.PAXRecords[crypto.MemberPAXKey] == string(crypto.MethodAge) &&
+		strings.HasSuffix(header.Name, crypto.MemberSuffix)
+}
+
+// unsealReader decrypts the sealed entries of the archive at path.
+type unsealReader struct {
+	archiveReader
+	r    *Restore
+	path string
+	// content replaces the archive content of the current entry once read.
+	content io.Reader
+}
+
+// unsealedEntry is the content of a sealed entry, or why it could not be
+// decrypted.
+type unsealedEntry struct {
+	data []byte
+	err  error
+}
+
+func (u *unsealReader) Next() (*tar.Header, error) {
+	u.content = nil
+	header, err := u.archiveReader.Next()
+	for err == nil {
+		if skip, featureErr := u.r.unsupportedEntry(header); featureErr != nil || !skip {
+			err = featureErr
+			break
+		}
+		header, err = u.archiveReader.Next()
+	}
+	// an oversized entry is left for checkEntry to skip, unread
+	if err != nil || !sealed(header) || header.Size > osutils.MaxExtractFileSize {
+		return header, err
+	}
+
+	unsealed := *header
+	unsealed.Name = strings.TrimSuffix(header.Name, crypto.MemberSuffix)
+	// callers drop unselected entries by name before reading them, so
+	// those are not decrypted, which may need a hardware key touch
+	if !u.r.selected(unsealed.Name) {
+		unsealed.Size = 0
+		u.content = errReader{fmt.Errorf("%s is not selected", unsealed.Name)}
+		return &unsealed, nil
+	}
+
+	key := u.path + "\x00" + header.Name
+	entry, ok := u.r.unsealedEntries[key]
+	if !ok {
+		if u.r.unsealUnavailable {
+			return header, nil
+		}
+		enc, encErr := crypto.NewAgeEncryptor(crypto.Options{AgeIdentityFiles: resolveAgeIdentityFiles(u.r.cfg)})
+		if encErr == nil {
+			encErr = enc.CanDecrypt()
+		}
+		if encErr != nil {
+			u.r.unsealUnavailable = true
+			events.Warning(u.r.sink, "Sensitive files are encrypted with age (%v); restoring them encrypted as %s files\n",
+				encErr, crypto.MemberSuffix)
+			return header, nil
+		}
+
+		// the ciphertext is streamed into age, so an entry that cannot be
+		// decrypted fails instead of being restored encrypted
+		plain := &limitedBuffer{max: osutils.MaxExtractFileSize}
+		if decErr := enc.DecryptStream(io.LimitReader(u.archiveReader, header.Size), plain); decErr != nil {
+			entry.err = fmt.Errorf("cannot decrypt: %w", decErr)
+		} else {
+			entry.data = plain.Bytes()
+		}
+		if u.r.unsealedEntries == nil {
+			u.r.unsealedEntries = make(map[string]unsealedEntry)
+		}
+		u.r.unsealedEntries[key] = entry
+	}
+
+	if entry.err != nil {
+		u.content = errReader{entry.err}
+		return header, nil
+	}
+	unsealed.Size = int64(len(entry.data))
+	u.content = bytes.NewReader(entry.data)
+	return &unsealed, nil
+}
+
+// limitedBuffer is a bytes.Buffer that fails writes past max bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.max {
+		return 0, fmt.Errorf("decrypted content exceeds the limit of %s", osutils.FormatSize(b.max))
+	}
+	return b.Buffer.Write(p)
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func (u *unsealReader) Read(p []byte) (int, error) {
+	if u.content != nil {
+		return u.content.Read(p)
+	}
+	return u.archiveReader.Read(p)
+}
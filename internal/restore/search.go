@@ -0,0 +1,165 @@
+package restore
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// maxSearchText bounds the matching line reported for a content match.
+const maxSearchText = 200
+
+// SearchOptions holds search options.
+type SearchOptions struct {
+	// Content also searches the lines of the files for the pattern, as
+	// text. Without it, backups whose metadata has a catalog are searched
+	// without opening their archives.
+	Content bool
+}
+
+// Search finds the files matching pattern in each backup of the backup
+// directory, newest first: files whose path contains it, ignoring case, or,
+// for a pattern with glob characters, whose path or name matches it. With
+// opts.Content, files with a line containing the pattern match too. The
+// archives are decrypted and, for incremental backups, merged with their
+// parents, as for Export. A backup that cannot be searched is reported and
+// does not stop the others.
+func Search(cfg *config.Config, pattern string, opts SearchOptions, sink events.Sink) (*metadata.SearchResult, error) {
+	result := &metadata.SearchResult{Pattern: pattern, Content: opts.Content, Backups: []metadata.SearchBackup{}}
+	if pattern == "" {
+		result.SetError(errs.Errorf(errs.ErrConfigInvalid, "search pattern is empty"))
+		return result, nil
+	}
+	if err := ValidateFilePatterns([]string{pattern}); err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	backups, err := metadata.ListBackups(cfg.Backup.BackupDir)
+	if err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "listing backups in %s: %w", cfg.Backup.BackupDir, err))
+		return result, nil
+	}
+
+	s := &searcher{pattern: pattern, content: opts.Content}
+	for _, info := range backups {
+		result.Searched++
+		events.Detail(sink, "Searching %s\n", info.Archive)
+		found := metadata.SearchBackup{Archive: info.Archive, Timestamp: info.Timestamp}
+		if found.Matches, err = s.search(cfg, info.Archive); err != nil {
+			events.Warning(sink, "Cannot search %s: %v\n", info.Archive, err)
+			found.Error = err.Error()
+		}
+		if len(found.Matches) > 0 || found.Error != "" {
+			result.Backups = append(result.Backups, found)
+		}
+	}
+	result.Success = true
+	return result, nil
+}
+
+// searcher matches the files of archives against a search pattern.
+type searcher struct {
+	pattern string
+	content bool
+}
+
+// search returns the matching files of the archive, from the catalog of its
+// metadata if it has one and contents are not searched.
+func (s *searcher) search(cfg *config.Config, archive string) ([]metadata.SearchMatch, error) {
+	if !s.content {
+		if meta, err := metadata.Load(metadata.GetMetadataPath(archive)); err == nil && len(meta.Files) > 0 {
+			var matches []metadata.SearchMatch
+			for _, f := range meta.Files {
+				if s.matchName(f.Path) {
+					matches = append(matches, metadata.SearchMatch{Path: f.Path, Size: f.Size})
+				}
+			}
+			slices.SortFunc(matches, func(a, b metadata.SearchMatch) int { return strings.Compare(a.Path, b.Path) })
+			return matches, nil
+		}
+	}
+
+	r := &Restore{cfg: cfg, opts: &Options{}, sink: events.Discard}
+	tarPaths, cleanup, err := r.openExport(archive, &metadata.ExportResult{})
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	w := &searchExport{s: s}
+	r.pending = r.manifestPaths()
+	for _, tarPath := range tarPaths {
+		if err = r.exportArchive(tarPath, w, &metadata.ExportResult{}); err != nil {
+			return nil, err
+		}
+	}
+	slices.SortFunc(w.matches, func(a, b metadata.SearchMatch) int { return strings.Compare(a.Path, b.Path) })
+	return w.matches, nil
+}
+
+// matchName reports whether the archive path name matches the pattern.
+func (s *searcher) matchName(name string) bool {
+	if strings.ContainsAny(s.pattern, "*?[") {
+		ok, _ := path.Match(s.pattern, path.Base(name))
+		return ok || matchesFiles([]string{s.pattern}, name)
+	}
+	return strings.Contains(strings.ToLower(name), strings.ToLower(s.pattern))
+}
+
+// matchContent returns the first line of content containing the pattern,
+// with its number, and the number of such lines. Binary files and lines
+// too long to scan end the search.
+func (s *searcher) matchContent(content io.Reader) (line int, text string, lines int) {
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	needle := []byte(s.pattern)
+	for n := 1; scanner.Scan(); n++ {
+		b := scanner.Bytes()
+		if bytes.IndexByte(b, 0) >= 0 {
+			return 0, "", 0
+		}
+		if !bytes.Contains(b, needle) {
+			continue
+		}
+		if lines++; line == 0 {
+			line, text = n, strings.TrimSpace(string(b))
+			if len(text) > maxSearchText {
+				text = text[:maxSearchText] + "..."
+			}
+		}
+	}
+	return line, text, lines
+}
+
+// searchExport collects the matching files of an archive.
+type searchExport struct {
+	s       *searcher
+	matches []metadata.SearchMatch
+}
+
+func (e *searchExport) add(header *tar.Header, content io.Reader) error {
+	if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink {
+		return errSkipEntry
+	}
+	match := metadata.SearchMatch{Path: header.Name, Size: header.Size}
+	named := e.s.matchName(header.Name)
+	if e.s.content && header.Typeflag == tar.TypeReg {
+		match.Line, match.Text, match.Lines = e.s.matchContent(content)
+	}
+	if named || match.Lines > 0 {
+		e.matches = append(e.matches, match)
+	}
+	return nil
+}
+
+func (e *searchExport) Close() error {
+	return nil
+}
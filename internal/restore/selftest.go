@@ -0,0 +1,103 @@
+package restore
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// SelfTestStateFile records the last scheduled self-test in the backup
+// directory.
+const SelfTestStateFile = "self-test.json"
+
+// selfTestState is the content of SelfTestStateFile.
+type selfTestState struct {
+	LastRun time.Time `json:"last_run"`
+	Archive string    `json:"archive"`
+	Success bool      `json:"success"`
+}
+
+// SelfTestDue reports whether verify_schedule calls for a self-test at now:
+// after enough backups since the last one, or once its interval has passed.
+func SelfTestDue(cfg *config.Config, now time.Time) (bool, error) {
+	schedule, err := cfg.Backup.ParseVerifySchedule()
+	if err != nil || schedule == (config.VerifySchedule{}) {
+		return false, err
+	}
+	state := loadSelfTestState(cfg.Backup.BackupDir)
+
+	if schedule.Interval > 0 {
+		return now.Sub(state.LastRun) >= schedule.Interval, nil
+	}
+
+	backups, err := metadata.ListBackups(cfg.Backup.BackupDir)
+	if err != nil {
+		return false, err
+	}
+	since := 0
+	for _, info := range backups {
+		if created, parseErr := info.CreatedAt(); parseErr == nil && created.After(state.LastRun) {
+			since++
+		}
+	}
+	return since >= schedule.Every, nil
+}
+
+// SelfTest verifies a randomly chosen backup other than latest end to end,
+// so that a backup that cannot be restored is noticed while newer ones can
+// still replace it. It returns nil if there is no older backup to test. The
+// run is recorded for SelfTestDue whatever the outcome.
+func SelfTest(cfg *config.Config, latest string, sink events.Sink) (*metadata.VerifyResult, error) {
+	backups, err := metadata.ListBackups(cfg.Backup.BackupDir)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []string
+	for _, info := range backups {
+		if info.Archive != latest {
+			candidates = append(candidates, info.Archive)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	archive := candidates[rand.IntN(len(candidates))] //nolint:gosec // g404: sampling, not security
+	events.Info(sink, "Self-test: verifying %s\n", filepath.Base(archive))
+	result, err := Verify(cfg, archive, sink)
+	if err != nil {
+		return result, err
+	}
+
+	state := selfTestState{LastRun: time.Now(), Archive: filepath.Base(archive), Success: result.Success}
+	if saveErr := saveSelfTestState(cfg.Backup.BackupDir, state); saveErr != nil {
+		events.Detail(sink, "Failed to record self-test: %v\n", saveErr)
+	}
+	return result, nil
+}
+
+// loadSelfTestState returns the recorded self-test, or the zero state if
+// none ran yet.
+func loadSelfTestState(backupDir string) selfTestState {
+	var state selfTestState
+	data, err := os.ReadFile(filepath.Join(backupDir, SelfTestStateFile))
+	if err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+func saveSelfTestState(backupDir string, state selfTestState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding self-test state: %w", err)
+	}
+	return os.WriteFile(filepath.Join(backupDir, SelfTestStateFile), data, 0600)
+}
@@ -0,0 +1,65 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/osutils"
+	"github.com/ospiem/dotpak/internal/store"
+)
+
+// RunSnapshot restores the snapshot name from the content-addressed store in
+// the backup directory, between the restore hooks like Run. The selected
+// files are first assembled into a temporary archive, checking each against
+// its hash, so they are restored with the same safety backup, filters, and
+// post-restore commands as an archive.
+func (r *Restore) RunSnapshot(name string) (*metadata.RestoreResult, error) {
+	if r == nil {
+		return r.run(name)
+	}
+	st := store.Open(r.cfg.Backup.BackupDir)
+
+	return r.withHooks(st.ManifestPath(name), func() (*metadata.RestoreResult, error) {
+		result := &metadata.RestoreResult{
+			Archive:    name,
+			DryRun:     r.opts.DryRun,
+			Categories: r.opts.Categories,
+			Files:      r.opts.Files,
+		}
+
+		manifest, err := st.Load(name)
+		if err != nil {
+			result.SetError(err)
+			//nolint:nilerr // error captured in result.Error for structured JSON response
+			return result, nil
+		}
+
+		tmp, err := osutils.CreateTempFile("snapshot-*.tar.gz")
+		if err != nil {
+			result.SetError(fmt.Errorf("creating temporary archive: %w", err))
+			return result, nil
+		}
+		defer os.Remove(tmp.Name())
+
+		events.StartPhase(r.sink, events.PhaseVerify, "Reading snapshot %s...\n", name)
+		err = st.WriteArchive(manifest, tmp, r.selected)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			result.SetError(fmt.Errorf("reading snapshot: %w", err))
+			return result, nil
+		}
+
+		r.fromStore = true
+		r.sourceHome = manifest.HomeDir
+		defer func() { r.fromStore = false }()
+		result, err = r.run(tmp.Name())
+		if result != nil {
+			result.Archive = name
+		}
+		return result, err
+	})
+}
@@ -0,0 +1,200 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ospiem/dotpak/internal/fsys"
+)
+
+// transaction stages extracted files next to the home directory and moves
+// them into place with renames once extraction succeeded, so that a failed
+// restore of e.g. .ssh or .gnupg does not leave a mix of old and new files.
+type transaction struct {
+	fs   fsys.FS
+	home string
+	// stage holds the extracted entries under files/ and the entries they
+	// replace under old/. It lives in the home directory so renames between
+	// the two never cross a filesystem boundary.
+	stage string
+	// entries are the staged files and symlinks, and dirs the staged
+	// directories, relative to home, in extraction order.
+	entries []string
+	staged  map[string]bool
+	dirs    map[string]os.FileMode
+
+	// applied lists the entries moved into place, and created the
+	// directories made for them, both undone by rollback.
+	applied []appliedEntry
+	created []string
+}
+
+// appliedEntry is an entry moved into the home directory. displaced is set
+// if it replaced an existing file, which was moved to the stage.
+type appliedEntry struct {
+	rel       string
+	displaced bool
+}
+
+func newTransaction(fs fsys.FS, home string) (*transaction, error) {
+	stage, err := fsys.MkdirTemp(fs, home, ".dotpak-restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating staging directory: %w", err)
+	}
+	return &transaction{
+		fs:     fs,
+		home:   home,
+		stage:  stage,
+		staged: make(map[string]bool),
+		dirs:   make(map[string]os.FileMode),
+	}, nil
+}
+
+// path returns where the entry rel is extracted to.
+func (t *transaction) path(rel string) string {
+	return filepath.Join(t.stage, "files", rel)
+}
+
+// add records that rel was extracted to path(rel). An entry extracted again
+// overwrote the staged copy and is moved only once.
+func (t *transaction) add(rel string) {
+	if !t.staged[rel] {
+		t.staged[rel] = true
+		t.entries = append(t.entries, rel)
+	}
+}
+
+// addDir records a directory entry, created with mode at commit if missing.
+func (t *transaction) addDir(rel string, mode os.FileMode) {
+	t.dirs[rel] = mode
+}
+
+// commit moves the staged entries into the home directory. If any move
+// fails, the entries already moved are rolled back and the original files
+// restored. The stage is removed either way.
+func (t *transaction) commit() error {
+	defer t.discard()
+
+	dirs := make([]string, 0, len(t.dirs))
+	for rel := range t.dirs {
+		dirs = append(dirs, rel)
+	}
+	slices.Sort(dirs)
+	for _, rel := range dirs {
+		if err := t.mkdirs(filepath.Join(t.home, rel), t.dirs[rel]); err != nil {
+			return t.abort(err)
+		}
+	}
+
+	for _, rel := range t.entries {
+		if err := t.apply(rel); err != nil {
+			return t.abort(fmt.Errorf("%s: %w", rel, err))
+		}
+	}
+	t.applied = nil
+	return nil
+}
+
+// apply moves the staged entry rel into place, first moving aside the
+// file it replaces.
+func (t *transaction) apply(rel string) error {
+	target := filepath.Join(t.home, rel)
+	if err := t.mkdirs(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	displaced := false
+	info, err := t.fs.Lstat(target)
+	switch {
+	case err == nil && info.IsDir():
+		return errors.New("a directory is in the way")
+	case err == nil:
+		old := filepath.Join(t.stage, "old", rel)
+		if err = t.fs.MkdirAll(filepath.Dir(old), 0700); err != nil {
+			return err
+		}
+		if err = t.fs.Rename(target, old); err != nil {
+			return err
+		}
+		displaced = true
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if err = t.fs.Rename(t.path(rel), target); err != nil {
+		if displaced {
+			_ = t.fs.Rename(filepath.Join(t.stage, "old", rel), target)
+		}
+		return err
+	}
+	t.applied = append(t.applied, appliedEntry{rel: rel, displaced: displaced})
+	return nil
+}
+
+// mkdirs creates dir and its missing parents, recording each one created.
+func (t *transaction) mkdirs(dir string, mode os.FileMode) error {
+	if _, err := t.fs.Lstat(dir); err == nil {
+		return nil
+	}
+	if err := t.mkdirs(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err := t.fs.Mkdir(dir, mode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	t.created = append(t.created, dir)
+	return nil
+}
+
+// abort rolls back and returns err, joined with any rollback failure.
+func (t *transaction) abort(err error) error {
+	if rbErr := t.rollback(); rbErr != nil {
+		return fmt.Errorf("%w; rollback incomplete: %w", err, rbErr)
+	}
+	return err
+}
+
+// rollback undoes applied entries in reverse order, putting back the files
+// they displaced, and removes the directories created for them.
+func (t *transaction) rollback() error {
+	var errList []error
+	var failed []appliedEntry
+	for i := len(t.applied) - 1; i >= 0; i-- {
+		entry := t.applied[i]
+		target := filepath.Join(t.home, entry.rel)
+		if err := t.fs.Remove(target); err != nil && !os.IsNotExist(err) {
+			errList = append(errList, err)
+			failed = append(failed, entry)
+			continue
+		}
+		if entry.displaced {
+			if err := t.fs.Rename(filepath.Join(t.stage, "old", entry.rel), target); err != nil {
+				errList = append(errList, err)
+				failed = append(failed, entry)
+			}
+		}
+	}
+	t.applied = failed
+
+	for i := len(t.created) - 1; i >= 0; i-- {
+		_ = t.fs.Remove(t.created[i]) // only if still empty
+	}
+	t.created = nil
+	return errors.Join(errList...)
+}
+
+// discard removes the stage. If a rollback failed, the files it could not
+// put back stay under old/ for manual recovery.
+func (t *transaction) discard() {
+	if len(t.applied) > 0 {
+		_ = t.fs.RemoveAll(filepath.Join(t.stage, "files"))
+		return
+	}
+	_ = t.fs.RemoveAll(t.stage)
+}
@@ -0,0 +1,199 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Statuses of a metadata.SmokeCheck.
+const (
+	SmokePassed  = "passed"
+	SmokeFailed  = "failed"
+	SmokeSkipped = "skipped"
+)
+
+// smokeTimeout bounds each check, so that a config that waits for input
+// cannot hang a restore.
+const smokeTimeout = 30 * time.Second
+
+// validators check the syntax of restored config files. Each runs command
+// for its file, relative to home, with "{}" replaced by the restored file,
+// and is shown as name followed by the file. They only parse or print the
+// config, never start a session.
+var validators = []struct {
+	category string
+	file     string
+	name     string
+	command  []string
+}{
+	{"shell", ".zshenv", "zsh -n", []string{"zsh", "-n", "{}"}},
+	{"shell", ".zprofile", "zsh -n", []string{"zsh", "-n", "{}"}},
+	{"shell", ".zshrc", "zsh -n", []string{"zsh", "-n", "{}"}},
+	{"shell", ".profile", "sh -n", []string{"sh", "-n", "{}"}},
+	{"shell", ".bash_profile", "bash -n", []string{"bash", "-n", "{}"}},
+	{"shell", ".bashrc", "bash -n", []string{"bash", "-n", "{}"}},
+	{"shell", ".config/fish/config.fish", "fish --no-execute", []string{"fish", "--no-execute", "{}"}},
+	{"git", ".gitconfig", "git config --list --file", []string{"git", "config", "--list", "--file", "{}"}},
+	{"git", ".config/git/config", "git config --list --file", []string{"git", "config", "--list", "--file", "{}"}},
+	// a tmux server started without a config exits at once, as it has no
+	// sessions; source-file -n only parses
+	{"terminal", ".tmux.conf", "tmux source-file -n", []string{"tmux", "-L", "dotpak-validate", "-f", os.DevNull,
+		"start-server", ";", "source-file", "-n", "{}"}},
+	{"terminal", ".config/tmux/tmux.conf", "tmux source-file -n", []string{"tmux", "-L", "dotpak-validate",
+		"-f", os.DevNull, "start-server", ";", "source-file", "-n", "{}"}},
+	{"ssh", ".ssh/config", "ssh -G -F", []string{"ssh", "-G", "-F", "{}", "dotpak-validate"}},
+}
+
+// ValidatorCategories returns the categories that have validators, for
+// validate.categories.
+func ValidatorCategories() []string {
+	var categories []string
+	for _, v := range validators {
+		if !slices.Contains(categories, v.category) {
+			categories = append(categories, v.category)
+		}
+	}
+	return categories
+}
+
+// validateConfigs runs the validators of categories (all if empty) on the
+// config files restored under home. If restored is not nil, only the files
+// it lists, relative to home, are checked. It reports each check to sink
+// and returns them with the number that failed; checks of programs that
+// are not installed are skipped.
+func validateConfigs(home string, restored []string, categories []string, sink events.Sink) ([]metadata.SmokeCheck, int) {
+	var checks []metadata.SmokeCheck
+	failed := 0
+	for _, v := range validators {
+		if len(categories) > 0 && !slices.Contains(categories, v.category) {
+			continue
+		}
+		if restored != nil && !slices.Contains(restored, v.file) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(home, filepath.FromSlash(v.file))); err != nil {
+			continue
+		}
+		if len(checks) == 0 {
+			events.StartPhase(sink, events.PhaseVerify, "\nValidating restored configs:\n")
+		}
+
+		check := runValidator(home, v.file, v.command)
+		check.Category = v.category
+		check.Name = v.name + " " + v.file
+		switch check.Status {
+		case SmokePassed:
+			events.Success(sink, "  ok      %s\n", check.Name)
+		case SmokeSkipped:
+			events.Info(sink, "  skipped %s (%s)\n", check.Name, check.Output)
+		default:
+			failed++
+			events.Warning(sink, "  FAILED  %s\n", check.Name)
+			for line := range strings.Lines(check.Output) {
+				events.Info(sink, "          %s", strings.TrimSuffix(line, "\n")+"\n")
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks, failed
+}
+
+// validateDue reports whether the restored config files are to be checked.
+func (r *Restore) validateDue() bool {
+	return !r.opts.DryRun && (r.opts.Validate || (r.cfg != nil && r.cfg.Validate.AfterRestore))
+}
+
+// validateRestored checks the config files the restore wrote, and fails
+// result if one of them does not parse. The files stay restored; the safety
+// backup holds the ones they replaced.
+func (r *Restore) validateRestored(result *metadata.RestoreResult) {
+	restored := make([]string, 0, len(r.restored))
+	for _, name := range r.restored {
+		restored = append(restored, strings.Trim(strings.TrimPrefix(name, "./"), "/"))
+	}
+	checks, failed := validateConfigs(r.homeDir, restored, r.cfg.Validate.Categories, r.sink)
+	result.Checks = checks
+	if failed == 0 {
+		return
+	}
+
+	var names []string
+	for _, check := range checks {
+		if check.Status == SmokeFailed {
+			names = append(names, check.File)
+		}
+	}
+	result.Success = false
+	result.SetError(fmt.Errorf("%d restored config files failed validation: %s", failed, strings.Join(names, ", ")))
+	if result.SafetyBackup != "" {
+		events.Warning(r.sink, "The files they replaced are in the safety backup %s\n", filepath.Base(result.SafetyBackup))
+	}
+}
+
+// runValidator runs command for file, restored under home, with the
+// environment of a user whose home directory is home.
+func runValidator(home, file string, command []string) metadata.SmokeCheck {
+	path := filepath.Join(home, filepath.FromSlash(file))
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = strings.ReplaceAll(arg, "{}", path)
+	}
+	check := metadata.SmokeCheck{File: file, Command: strings.Join(args, " ")}
+
+	if _, err := exec.LookPath(args[0]); err != nil {
+		check.Status = SmokeSkipped
+		check.Output = args[0] + " not installed"
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), smokeTimeout)
+	defer cancel()
+	//nolint:gosec // g204: fixed programs, the only variable argument is the restored file
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = home
+	cmd.Env = homeEnv(home)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		check.Status = SmokeFailed
+		check.Output = strings.TrimSpace(string(out))
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			check.Output = fmt.Sprintf("timed out after %s", smokeTimeout)
+		} else if check.Output == "" {
+			check.Output = err.Error()
+		}
+		return check
+	}
+	check.Status = SmokePassed
+	return check
+}
+
+// homeEnv returns the environment with the home and config directories
+// moved to home.
+func homeEnv(home string) []string {
+	env := []string{
+		"HOME=" + home,
+		"USERPROFILE=" + home,
+		"ZDOTDIR=" + home,
+		"XDG_CONFIG_HOME=" + filepath.Join(home, ".config"),
+		"GIT_CONFIG_NOSYSTEM=1",
+	}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "HOME", "USERPROFILE", "ZDOTDIR", "XDG_CONFIG_HOME", "GIT_CONFIG_NOSYSTEM", "GIT_CONFIG_GLOBAL":
+		default:
+			env = append(env, kv)
+		}
+	}
+	return env
+}
@@ -0,0 +1,179 @@
+package restore
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/crypto"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Verify checks the archive's integrity HMAC, that it can be decrypted and
+// read to the end, that every entry would be restored inside the home
+// directory, and that the content matches the catalog in its metadata, or
+// in the manifest embedded in the archive if the metadata file is missing.
+// Nothing is written outside the temporary decryption file.
+func Verify(cfg *config.Config, archivePath string, sink events.Sink) (*metadata.VerifyResult, error) {
+	result := &metadata.VerifyResult{
+		Archive:   archivePath,
+		Encrypted: hasEncryptionSuffix(archivePath),
+	}
+
+	if _, err := os.Stat(archivePath); err != nil {
+		result.SetError(errs.Errorf(errs.ErrArchiveNotFound, "archive not found: %s", archivePath))
+		//nolint:nilerr // error captured in result.Error for structured JSON response
+		return result, nil
+	}
+
+	r := &Restore{cfg: cfg, opts: &Options{}, sink: sink}
+	verified, err := r.verifyChain([]string{archivePath})
+	defer r.closeVerified()
+	if err != nil {
+		result.SetError(err)
+		return result, nil
+	}
+	result.Verified = verified
+
+	tarPath := archivePath
+	if result.Encrypted || r.verified[archivePath] != nil {
+		if result.Encrypted {
+			events.StartPhase(sink, events.PhaseDecrypt, "Decrypting archive...\n")
+		}
+		decrypted, decryptErr := r.decryptArchive(archivePath)
+		if decryptErr != nil {
+			result.SetError(fmt.Errorf("decryption failed: %w", decryptErr))
+			return result, nil
+		}
+		tarPath = decrypted
+		defer os.Remove(tarPath)
+	}
+
+	events.StartPhase(sink, events.PhaseVerify, "")
+	hashes, err := verifyEntries(tarPath, result)
+	if err != nil {
+		result.SetError(fmt.Errorf("verification failed: %w", err))
+		return result, nil
+	}
+
+	meta, loadErr := metadata.Load(metadata.GetMetadataPath(archivePath))
+	if loadErr != nil {
+		if meta, loadErr = readManifest(tarPath); loadErr != nil {
+			result.SetError(fmt.Errorf("verification failed: %w", loadErr))
+			return result, nil
+		}
+	}
+	if meta != nil {
+		result.Mismatched = matchCatalog(hashes, meta)
+		if len(result.Mismatched) > 0 {
+			result.SetError(errs.Errorf(errs.ErrArchiveCorrupt, "%d files do not match the catalog: %s",
+				len(result.Mismatched), strings.Join(result.Mismatched, ", ")))
+			return result, nil
+		}
+	}
+
+	result.Success = true
+	events.Success(sink, "Archive OK: %d files, %s\n", result.Files, formatSize(result.TotalSize))
+	return result, nil
+}
+
+// verifyEntries reads every entry of an archive, which also validates the
+// gzip or zip checksums, and records file counts in result. It returns the hex
+// SHA-256 of each file and symlink target, hashed as backup catalogs them,
+// or an empty hash for sensitive files encrypted on their own.
+func verifyEntries(tarPath string, result *metadata.VerifyResult) (map[string]string, error) {
+	entries, closer, err := openArchive(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	hashes := make(map[string]string)
+	for {
+		header, nextErr := entries.Next()
+		if nextErr == io.EOF {
+			return hashes, nil
+		}
+		if nextErr != nil {
+			return nil, nextErr
+		}
+
+		if !isSafePath(header.Name) {
+			return nil, errs.Errorf(errs.ErrArchiveCorrupt, "unsafe path in archive: %s", header.Name)
+		}
+
+		if sealed(header) {
+			// encrypted on its own: its content is checked when decrypted
+			n, copyErr := io.Copy(io.Discard, entries)
+			if copyErr != nil {
+				return nil, copyErr
+			}
+			hashes[strings.TrimSuffix(header.Name, crypto.MemberSuffix)] = ""
+			result.Files++
+			result.TotalSize += n
+			continue
+		}
+
+		h := sha256.New()
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			h.Write([]byte(header.Linkname))
+			hashes[header.Name] = hex.EncodeToString(h.Sum(nil))
+			continue
+		case tar.TypeReg:
+		default:
+			continue
+		}
+
+		n, copyErr := io.Copy(h, entries)
+		if copyErr != nil {
+			return nil, copyErr
+		}
+		hashes[header.Name] = hex.EncodeToString(h.Sum(nil))
+		result.Files++
+		result.TotalSize += n
+	}
+}
+
+// matchCatalog compares the archive's hashes with the catalog in meta and
+// returns the paths that differ, sorted. An incremental archive holds only
+// changed files, so catalog entries missing from it are not reported.
+func matchCatalog(hashes map[string]string, meta *metadata.Metadata) []string {
+	if len(meta.Files) == 0 {
+		return nil
+	}
+
+	var mismatched []string
+	cataloged := make(map[string]bool, len(meta.Files))
+	for _, entry := range meta.Files {
+		name := filepath.ToSlash(entry.Path)
+		cataloged[name] = true
+		if entry.SHA256 == "" {
+			continue // could not be hashed during backup
+		}
+		hash, ok := hashes[name]
+		if (ok && hash != "" && hash != entry.SHA256) || (!ok && meta.Parent == "") {
+			mismatched = append(mismatched, name)
+		}
+	}
+	for name := range hashes {
+		if !cataloged[name] {
+			mismatched = append(mismatched, name)
+		}
+	}
+	sort.Strings(mismatched)
+	return mismatched
+}
+
+func hasEncryptionSuffix(path string) bool {
+	return crypto.DetectMethod(path) != crypto.MethodNone
+}
@@ -0,0 +1,48 @@
+package restore
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/events"
+)
+
+// entryPath returns the path the archive entry name is restored to and the
+// directory it must stay inside: the home directory or, for the wsl.items
+// of a backup made in WSL, the Windows user profile. It reports false for
+// wsl.items when there is no Windows side to restore them to.
+func (r *Restore) entryPath(name string) (path, base string, ok bool) {
+	rest, windows := strings.CutPrefix(filepath.ToSlash(name), backup.WindowsPrefix+"/")
+	if !windows || r.opts.Target != "" {
+		//nolint:gosec // g305: callers check the path with isPathWithinBase
+		return filepath.Join(r.homeDir, name), r.homeDir, true
+	}
+	root := r.windowsRoot()
+	if root == "" {
+		return "", "", false
+	}
+	//nolint:gosec // g305: callers check the path with isPathWithinBase
+	return filepath.Join(root, filepath.FromSlash(rest)), root, true
+}
+
+// windowsRoot returns the directory wsl.items are restored to, found on
+// first use: the Windows user profile in WSL, or wsl.windows_home, and the
+// home directory on Windows itself. Elsewhere it returns "".
+func (r *Restore) windowsRoot() string {
+	if r.windowsChecked {
+		return r.windowsHome
+	}
+	r.windowsChecked = true
+	if runtime.GOOS == "windows" {
+		r.windowsHome = r.homeDir
+		return r.windowsHome
+	}
+	home, err := backup.WindowsHome(r.cfg)
+	if err != nil {
+		events.Warning(r.sink, "Cannot restore wsl.items: %v\n", err)
+	}
+	r.windowsHome = home
+	return r.windowsHome
+}
@@ -0,0 +1,394 @@
+// Package store keeps backups as snapshots in a content-addressed store:
+// the content of each file is stored once, compressed, under
+// objects/<sha256>, and each snapshot is a manifest listing the files of a
+// backup with the hashes of their content. Backups of mostly unchanged
+// dotfiles then only add the objects of the files that changed.
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/metadata"
+)
+
+// Directories of a store inside the backup directory.
+const (
+	ObjectsDir   = "objects"
+	SnapshotsDir = "snapshots"
+)
+
+// ManifestVersion is the version of the manifest layout this release
+// writes.
+const ManifestVersion = 1
+
+// Manifest describes one snapshot.
+type Manifest struct {
+	Version    int            `json:"version"`
+	Timestamp  string         `json:"timestamp"`
+	Hostname   string         `json:"hostname"`
+	OSVersion  string         `json:"os_version,omitempty"`
+	HomeDir    string         `json:"home_dir,omitempty"`
+	Stats      metadata.Stats `json:"stats"`
+	DurationMS int64          `json:"duration_ms,omitempty"`
+	Entries    []Entry        `json:"entries"`
+}
+
+// Entry is a file or symlink of a snapshot, relative to home.
+type Entry struct {
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime int64       `json:"mtime"`
+	Size    int64       `json:"size"`
+	// SHA256 names the object holding the content of a regular file.
+	SHA256 string `json:"sha256,omitempty"`
+	// Link is the target of a symlink.
+	Link string `json:"link,omitempty"`
+}
+
+// Store is a content-addressed store in a backup directory.
+type Store struct {
+	dir string
+}
+
+// Open returns the store in backupDir. Nothing is created until the first
+// snapshot is saved.
+func Open(backupDir string) *Store {
+	return &Store{dir: backupDir}
+}
+
+// Exists reports whether the store holds any snapshot directory.
+func (s *Store) Exists() bool {
+	info, err := os.Stat(filepath.Join(s.dir, SnapshotsDir))
+	return err == nil && info.IsDir()
+}
+
+func (s *Store) objectPath(sum string) string {
+	return filepath.Join(s.dir, ObjectsDir, sum)
+}
+
+// ManifestPath returns the path of the manifest of the snapshot name.
+func (s *Store) ManifestPath(name string) string {
+	return filepath.Join(s.dir, SnapshotsDir, name+".json")
+}
+
+// Has reports whether the object sum is stored.
+func (s *Store) Has(sum string) bool {
+	_, err := os.Stat(s.objectPath(sum))
+	return err == nil
+}
+
+// Put stores the content of the file at path, which is expected to hash to
+// want, and returns the hash it was stored under and the compressed size of
+// a new object. An object already stored is not written again, so the size
+// is 0. If the file changed since want was computed, or want is empty, the
+// content read is stored under its own hash.
+func (s *Store) Put(path, want string) (string, int64, error) {
+	if want != "" && s.Has(want) {
+		return want, 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir, ObjectsDir), 0700); err != nil {
+		return "", 0, err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, ObjectsDir), ".tmp-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	gz := gzip.NewWriter(tmp)
+	if _, err = io.Copy(io.MultiWriter(h, gz), src); err != nil {
+		_ = tmp.Close()
+		return "", 0, err
+	}
+	if err = gz.Close(); err != nil {
+		_ = tmp.Close()
+		return "", 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if s.Has(actual) {
+		return actual, 0, nil
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return "", 0, err
+	}
+	if err = os.Rename(tmp.Name(), s.objectPath(actual)); err != nil {
+		return "", 0, err
+	}
+	return actual, info.Size(), nil
+}
+
+// open returns the content of the object sum. Reading it to the end fails
+// if the content does not hash to sum.
+func (s *Store) open(sum string) (io.ReadCloser, error) {
+	if !isSum(sum) {
+		return nil, errs.Errorf(errs.ErrArchiveCorrupt, "invalid object name %q", sum)
+	}
+	file, err := os.Open(s.objectPath(sum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errs.Errorf(errs.ErrArchiveCorrupt, "object %s is missing from the store", sum)
+	}
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, errs.Wrap(errs.ErrArchiveCorrupt, fmt.Errorf("object %s: %w", sum, err))
+	}
+	return &verifiedObject{sum: sum, gz: gz, file: file, h: sha256.New()}, nil
+}
+
+// isSum reports whether name is a hex SHA-256, so an object path built from
+// a manifest stays inside the objects directory.
+func isSum(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// verifiedObject reads an object and checks its hash at the end.
+type verifiedObject struct {
+	sum  string
+	gz   *gzip.Reader
+	file *os.File
+	h    hash.Hash
+}
+
+func (o *verifiedObject) Read(p []byte) (int, error) {
+	n, err := o.gz.Read(p)
+	o.h.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if sum := hex.EncodeToString(o.h.Sum(nil)); sum != o.sum {
+			return n, errs.Errorf(errs.ErrArchiveCorrupt, "object %s is corrupt (content hashes to %s)", o.sum, sum)
+		}
+	}
+	return n, err
+}
+
+func (o *verifiedObject) Close() error {
+	return errors.Join(o.gz.Close(), o.file.Close())
+}
+
+// Save writes m as the snapshot name, e.g. dotfiles-20260101_120000.
+func (s *Store) Save(name string, m *Manifest) error {
+	if err := os.MkdirAll(filepath.Join(s.dir, SnapshotsDir), 0700); err != nil {
+		return err
+	}
+	m.Version = ManifestVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.ManifestPath(name), data, 0600)
+}
+
+// Load reads the manifest of the snapshot name.
+func (s *Store) Load(name string) (*Manifest, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, errs.Errorf(errs.ErrArchiveNotFound, "snapshot not found: %s", name)
+	}
+	data, err := os.ReadFile(s.ManifestPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errs.Errorf(errs.ErrArchiveNotFound, "snapshot not found: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, errs.Wrap(errs.ErrArchiveCorrupt, fmt.Errorf("reading snapshot %s: %w", name, err))
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("snapshot %s was written by a newer dotpak (manifest version %d)", name, m.Version)
+	}
+	return &m, nil
+}
+
+// Names returns the names of the snapshots, oldest first.
+func (s *Store) Names() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, SnapshotsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Latest returns the name of the newest snapshot, or "" if there is none.
+func (s *Store) Latest() (string, error) {
+	names, err := s.Names()
+	if err != nil || len(names) == 0 {
+		return "", err
+	}
+	return names[len(names)-1], nil
+}
+
+// ObjectsSize returns the number of objects and their size on disk.
+func (s *Store) ObjectsSize() (int, int64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, ObjectsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if info, infoErr := entry.Info(); infoErr == nil {
+			count++
+			size += info.Size()
+		}
+	}
+	return count, size, nil
+}
+
+// Remove deletes the manifests of the snapshots names, then the objects no
+// remaining snapshot references, and returns the bytes freed. With dryRun
+// nothing is deleted and the bytes that would be freed are returned.
+func (s *Store) Remove(names []string, dryRun bool) (int64, error) {
+	removing := make(map[string]bool, len(names))
+	var freed int64
+	for _, name := range names {
+		removing[name] = true
+		if info, err := os.Stat(s.ManifestPath(name)); err == nil {
+			freed += info.Size()
+		}
+		if dryRun {
+			continue
+		}
+		if err := os.Remove(s.ManifestPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return freed, err
+		}
+	}
+
+	all, err := s.Names()
+	if err != nil {
+		return freed, err
+	}
+	referenced := make(map[string]bool)
+	for _, name := range all {
+		if removing[name] {
+			continue
+		}
+		m, loadErr := s.Load(name)
+		if loadErr != nil {
+			// unreadable manifests may still reference objects
+			return freed, loadErr
+		}
+		for _, e := range m.Entries {
+			if e.SHA256 != "" {
+				referenced[e.SHA256] = true
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, ObjectsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return freed, nil
+	}
+	if err != nil {
+		return freed, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if referenced[name] || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if info, infoErr := entry.Info(); infoErr == nil {
+			freed += info.Size()
+		}
+		if !dryRun {
+			if err = os.Remove(filepath.Join(s.dir, ObjectsDir, name)); err != nil {
+				return freed, err
+			}
+		}
+	}
+	return freed, nil
+}
+
+// WriteArchive writes the entries of m for which keep returns true as a
+// tar.gz archive to w, checking each file's content against its hash.
+func (s *Store) WriteArchive(m *Manifest, w io.Writer, keep func(path string) bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, e := range m.Entries {
+		if keep != nil && !keep(e.Path) {
+			continue
+		}
+		header := &tar.Header{
+			Name:    e.Path,
+			Mode:    int64(e.Mode.Perm()),
+			ModTime: time.Unix(e.ModTime, 0),
+		}
+		if e.Mode&fs.ModeSymlink != 0 {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = e.Link
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header.Typeflag = tar.TypeReg
+		header.Size = e.Size
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		content, err := s.open(e.SHA256)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Path, err)
+		}
+		_, err = io.Copy(tw, content)
+		_ = content.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sum(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPut(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	st := Open(t.TempDir())
+	a := writeFile(t, src, "a", "same content")
+	b := writeFile(t, src, "b", "same content")
+
+	got, size, err := st.Put(a, "")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got != sum("same content") || size == 0 {
+		t.Errorf("Put() = %s, %d; want %s and a new object", got, size, sum("same content"))
+	}
+
+	got, size, err = st.Put(b, sum("same content"))
+	if err != nil || got != sum("same content") || size != 0 {
+		t.Errorf("Put() of stored content = %s, %d, %v; want no new object", got, size, err)
+	}
+
+	// a file changed since it was hashed is stored under its new hash
+	got, _, err = st.Put(b, sum("stale"))
+	if err != nil || got != sum("same content") {
+		t.Errorf("Put() with stale hash = %s, %v; want %s", got, err, sum("same content"))
+	}
+
+	if count, _, _ := st.ObjectsSize(); count != 1 {
+		t.Errorf("ObjectsSize() count = %d, want 1", count)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	st := Open(t.TempDir())
+	for _, name := range []string{"dotfiles-20260102_000000", "dotfiles-20260101_000000"} {
+		if err := st.Save(name, &Manifest{Hostname: "host", Entries: []Entry{{Path: ".zshrc"}}}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	names, err := st.Names()
+	if err != nil || len(names) != 2 || names[0] != "dotfiles-20260101_000000" {
+		t.Errorf("Names() = %v, %v; want oldest first", names, err)
+	}
+	if latest, _ := st.Latest(); latest != "dotfiles-20260102_000000" {
+		t.Errorf("Latest() = %s", latest)
+	}
+
+	m, err := st.Load("dotfiles-20260101_000000")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if m.Version != ManifestVersion || m.Hostname != "host" || len(m.Entries) != 1 {
+		t.Errorf("Load() = %+v", m)
+	}
+
+	for _, name := range []string{"", "missing", "../snapshots/dotfiles-20260101_000000", ".hidden"} {
+		if _, err = st.Load(name); err == nil {
+			t.Errorf("Load(%q) succeeded, want error", name)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	st := Open(t.TempDir())
+	shared, _, _ := st.Put(writeFile(t, src, "shared", "shared"), "")
+	old, _, _ := st.Put(writeFile(t, src, "old", "old only"), "")
+
+	_ = st.Save("dotfiles-1", &Manifest{Entries: []Entry{{Path: "a", SHA256: shared}, {Path: "b", SHA256: old}}})
+	_ = st.Save("dotfiles-2", &Manifest{Entries: []Entry{{Path: "a", SHA256: shared}}})
+
+	freed, err := st.Remove([]string{"dotfiles-1"}, true)
+	if err != nil || freed == 0 {
+		t.Fatalf("Remove() dry run = %d, %v", freed, err)
+	}
+	if !st.Has(old) {
+		t.Fatal("Remove() dry run deleted an object")
+	}
+
+	if _, err = st.Remove([]string{"dotfiles-1"}, false); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if st.Has(old) {
+		t.Error("Remove() kept an unreferenced object")
+	}
+	if !st.Has(shared) {
+		t.Error("Remove() deleted an object still referenced")
+	}
+	if names, _ := st.Names(); len(names) != 1 || names[0] != "dotfiles-2" {
+		t.Errorf("Names() after Remove() = %v", names)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	st := Open(t.TempDir())
+	zshrc, _, _ := st.Put(writeFile(t, src, "zshrc", "export A=1\n"), "")
+	vimrc, _, _ := st.Put(writeFile(t, src, "vimrc", "set nu\n"), "")
+	m := &Manifest{Entries: []Entry{
+		{Path: ".zshrc", Mode: 0600, Size: 11, SHA256: zshrc},
+		{Path: ".vimrc", Mode: 0644, Size: 7, SHA256: vimrc},
+		{Path: ".bashrc", Mode: os.ModeSymlink | 0777, Link: ".zshrc"},
+	}}
+
+	t.Run("writes selected entries", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		if err := st.WriteArchive(m, &buf, func(path string) bool { return path != ".vimrc" }); err != nil {
+			t.Fatalf("WriteArchive() error = %v", err)
+		}
+
+		got := readArchive(t, &buf)
+		want := map[string]string{".zshrc": "export A=1\n", ".bashrc": "-> .zshrc"}
+		if len(got) != len(want) {
+			t.Fatalf("archive = %v, want %v", got, want)
+		}
+		for name, content := range want {
+			if got[name] != content {
+				t.Errorf("archive %s = %q, want %q", name, got[name], content)
+			}
+		}
+	})
+
+	t.Run("detects corrupt object", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		corrupt := Open(dir)
+		object, _, _ := corrupt.Put(writeFile(t, dir, "f", "original"), "")
+
+		// replace the object with other content under the same name
+		var obj bytes.Buffer
+		gz := gzip.NewWriter(&obj)
+		_, _ = gz.Write([]byte("tampered"))
+		_ = gz.Close()
+		if err := os.WriteFile(corrupt.objectPath(object), obj.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		entries := &Manifest{Entries: []Entry{{Path: "f", Mode: 0600, Size: 8, SHA256: object}}}
+		if err := corrupt.WriteArchive(entries, io.Discard, nil); err == nil {
+			t.Error("WriteArchive() succeeded with a corrupt object")
+		}
+	})
+
+	t.Run("rejects invalid object name", func(t *testing.T) {
+		t.Parallel()
+		entries := &Manifest{Entries: []Entry{{Path: "f", Mode: 0600, SHA256: "../../etc/passwd"}}}
+		if err := st.WriteArchive(entries, io.Discard, nil); err == nil {
+			t.Error("WriteArchive() succeeded with an invalid object name")
+		}
+	})
+}
+
+// readArchive returns the file contents and symlink targets of a tar.gz.
+func readArchive(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	entries := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			entries[header.Name] = "-> " + header.Linkname
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = string(content)
+	}
+}
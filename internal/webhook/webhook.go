@@ -0,0 +1,68 @@
+// Package webhook posts operation results to a user-configured HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// EventHeader carries the operation name ("backup" or "restore").
+	EventHeader = "X-Dotpak-Event"
+	// SignatureHeader carries the HMAC-SHA256 of the request body, formatted as "sha256=<hex>".
+	SignatureHeader = "X-Dotpak-Signature"
+)
+
+// timeout bounds the whole request so a slow endpoint can't stall a backup.
+const timeout = 10 * time.Second
+
+// Post sends payload as JSON to url. If secret is non-empty the body is
+// signed with HMAC-SHA256 and the signature is sent in SignatureHeader.
+func Post(url, secret, event string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dotpak")
+	req.Header.Set(EventHeader, event)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(body, secret))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body: "sha256=" followed by
+// the hex-encoded HMAC-SHA256 of body keyed with secret.
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
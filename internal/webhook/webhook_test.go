@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPost(t *testing.T) {
+	t.Parallel()
+
+	type payload struct {
+		Success bool   `json:"success"`
+		Archive string `json:"archive"`
+	}
+
+	t.Run("sends signed JSON payload", func(t *testing.T) {
+		var gotBody []byte
+		var gotHeaders http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotHeaders = r.Header.Clone()
+			gotBody, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		err := Post(server.URL, "s3cret", "backup", payload{Success: true, Archive: "dotfiles.tar.gz"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var parsed payload
+		if err = json.Unmarshal(gotBody, &parsed); err != nil {
+			t.Fatalf("body is not JSON: %v", err)
+		}
+		if !parsed.Success || parsed.Archive != "dotfiles.tar.gz" {
+			t.Errorf("unexpected payload: %+v", parsed)
+		}
+		if gotHeaders.Get(EventHeader) != "backup" {
+			t.Errorf("expected event header 'backup', got %q", gotHeaders.Get(EventHeader))
+		}
+		if gotHeaders.Get(SignatureHeader) != Sign(gotBody, "s3cret") {
+			t.Errorf("signature mismatch: %q", gotHeaders.Get(SignatureHeader))
+		}
+	})
+
+	t.Run("omits signature without secret", func(t *testing.T) {
+		var gotSignature string
+		server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			gotSignature = r.Header.Get(SignatureHeader)
+		}))
+		defer server.Close()
+
+		if err := Post(server.URL, "", "restore", payload{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if gotSignature != "" {
+			t.Errorf("expected no signature, got %q", gotSignature)
+		}
+	})
+
+	t.Run("reports non-2xx status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		if err := Post(server.URL, "", "backup", payload{}); err == nil {
+			t.Error("expected error for 500 response")
+		}
+	})
+}
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+
+	// echo -n '{"success":true}' | openssl dgst -sha256 -hmac key
+	want := "sha256=6619809b0b6104723d5d1dc0f43cdb355f1235ecd2fe16338f4c98549d9e29f3"
+	if got := Sign([]byte(`{"success":true}`), "key"); got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,203 @@
+// Package dotpak is the public Go API for dotpak. It lets other programs
+// (GUIs, provisioning systems) create, restore, list, and verify dotfile
+// backups without shelling out to the dotpak binary.
+//
+// Every operation takes a context and functional options:
+//
+//	result, err := dotpak.Backup(ctx,
+//		dotpak.WithProfile("work"),
+//		dotpak.WithEventHandler(func(e dotpak.Event) { log.Println(e.Message) }),
+//	)
+//
+// A failed operation is reported in the result's Error and ErrorCode fields,
+// the same as the CLI's JSON output. The returned error is reserved for
+// problems that prevent the operation from running at all, such as an
+// unreadable config file or a context canceled before it started. Backup
+// and Restore also stop when the context is canceled while they run, with
+// ErrorCode "canceled".
+package dotpak
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/ospiem/dotpak/internal/backup"
+	"github.com/ospiem/dotpak/internal/config"
+	"github.com/ospiem/dotpak/internal/errs"
+	"github.com/ospiem/dotpak/internal/events"
+	"github.com/ospiem/dotpak/internal/metadata"
+	"github.com/ospiem/dotpak/internal/restore"
+)
+
+// Types shared with the CLI's JSON output.
+type (
+	// Config is the dotpak configuration, as loaded from config.toml.
+	Config = config.Config
+	// BackupResult is the outcome of Backup.
+	BackupResult = metadata.BackupResult
+	// RestoreResult is the outcome of Restore.
+	RestoreResult = metadata.RestoreResult
+	// VerifyResult is the outcome of Verify.
+	VerifyResult = metadata.VerifyResult
+	// BackupInfo describes an archive returned by List.
+	BackupInfo = metadata.BackupInfo
+)
+
+// DefaultConfig returns the built-in configuration used when no config file exists.
+func DefaultConfig() *Config {
+	return config.DefaultConfig()
+}
+
+// LoadConfig reads a config file and applies host overrides and the named
+// profile. An empty path selects the default location.
+func LoadConfig(path, profile string) (*Config, error) {
+	if path == "" {
+		path = config.DefaultConfigPath()
+	}
+	return config.LoadWithProfile(path, profile)
+}
+
+// Backup creates a backup archive of the configured dotfiles.
+func Backup(ctx context.Context, opts ...Option) (*BackupResult, error) {
+	s, cfg, err := prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := backup.New(cfg, &backup.Options{
+		DryRun:                  s.dryRun,
+		EncryptionMethod:        s.encryption,
+		IncludeSecrets:          !s.noSecrets,
+		RecipientsFile:          s.recipientsFile,
+		GPGRecipient:            s.gpgRecipient,
+		MaterializePlaceholders: s.materialize,
+		SkipPlaceholders:        s.skipPlaceholders,
+		NixSymlinks:             s.nixSymlinks,
+		ShellSnapshot:           s.shellSnapshot,
+		Incremental:             s.incremental,
+		ProfileIO:               s.profileIO,
+		Jobs:                    s.jobs,
+		Profile:                 s.profile,
+		Wait:                    s.wait,
+	}, s.sink())
+	if b == nil {
+		return nil, errors.New("cannot determine home directory")
+	}
+	return b.RunContext(ctx)
+}
+
+// Restore extracts archivePath into the home directory, or the directory of
+// WithTarget. An empty archivePath restores the newest archive in the
+// configured backup directory.
+func Restore(ctx context.Context, archivePath string, opts ...Option) (*RestoreResult, error) {
+	s, cfg, err := prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = restore.ValidateCategories(cfg, s.categories); err != nil {
+		return nil, err
+	}
+	if err = restore.ValidateFilePatterns(s.files); err != nil {
+		return nil, err
+	}
+	if s.fsync != "" && !slices.Contains(restore.FsyncPolicies, s.fsync) {
+		return nil, errs.Errorf(errs.ErrConfigInvalid, "invalid fsync policy %q", s.fsync)
+	}
+	if archivePath == "" {
+		archivePath = metadata.LatestBackup(cfg.Backup.BackupDir)
+		if archivePath == "" {
+			return nil, errs.Errorf(errs.ErrArchiveNotFound, "no backups found in %s", cfg.Backup.BackupDir)
+		}
+	}
+
+	r := restore.New(cfg, &restore.Options{
+		DryRun:             s.dryRun,
+		Force:              s.force,
+		Categories:         s.categories,
+		Files:              s.files,
+		NoBackup:           s.noSafetyBackup,
+		SkipIntegrityCheck: s.skipIntegrity,
+		NoPostRestore:      s.noPostRestore,
+		Fsync:              s.fsync,
+		Transactional:      s.transactional,
+		NoRewrite:          s.noRewrite,
+		Target:             s.target,
+		Wait:               s.wait,
+		ForcePartial:       s.forcePartial,
+	}, s.sink())
+	if r == nil {
+		return nil, errors.New("cannot determine home directory")
+	}
+	return r.RunContext(ctx, archivePath)
+}
+
+// List returns the archives in the configured backup directory, newest first.
+func List(ctx context.Context, opts ...Option) ([]BackupInfo, error) {
+	_, cfg, err := prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.ListBackups(cfg.Backup.BackupDir)
+}
+
+// Verify checks that archivePath can be decrypted and fully read and that
+// every entry would be restored inside the home directory.
+func Verify(ctx context.Context, archivePath string, opts ...Option) (*VerifyResult, error) {
+	s, cfg, err := prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return restore.Verify(cfg, archivePath, s.sink())
+}
+
+// prepare applies opts, checks ctx, and resolves the configuration.
+func prepare(ctx context.Context, opts []Option) (*settings, *Config, error) {
+	s := &settings{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, errs.Wrap(errs.ErrCanceled, err)
+	}
+
+	if s.cfg != nil {
+		return s, s.cfg, nil
+	}
+	cfg, err := LoadConfig(s.configPath, s.profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, cfg, nil
+}
+
+// sink returns the event sink that forwards library events to the
+// configured event handler, or discards them when none is set.
+func (s *settings) sink() events.Sink {
+	handler := s.onEvent
+	if handler == nil {
+		return events.Discard
+	}
+	return events.SinkFunc(func(e events.Event) {
+		if e.Kind == events.KindDetail && !s.verbose {
+			return
+		}
+		handler(Event{
+			Kind:       EventKind(e.Kind.String()),
+			Phase:      string(e.Phase),
+			Path:       e.Path,
+			Current:    e.Current,
+			Total:      e.Total,
+			Size:       e.Size,
+			Err:        e.Err,
+			Bytes:      e.Bytes,
+			TotalBytes: e.TotalBytes,
+			Rate:       e.Rate,
+			ETA:        e.ETA,
+			Message:    strings.TrimSpace(e.Message),
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package dotpak
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ospiem/dotpak/internal/errs"
+)
+
+func TestBackupListVerifyRestore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := os.WriteFile(filepath.Join(home, ".zshrc"), []byte("export EDITOR=vim\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Backup.BackupDir = filepath.Join(home, "backups")
+	cfg.Items = []string{".zshrc"}
+	cfg.Sensitive = nil
+
+	var events []Event
+	ctx := context.Background()
+
+	result, err := Backup(ctx, WithConfig(cfg), WithEventHandler(func(e Event) {
+		events = append(events, e)
+	}))
+	if err != nil {
+		t.Fatalf("Backup() error: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("backup failed: %s", result.Error)
+	}
+	if result.Stats.FilesBackedUp != 1 {
+		t.Errorf("expected 1 file backed up, got %d", result.Stats.FilesBackedUp)
+	}
+	var sawCollect, sawFile bool
+	for _, e := range events {
+		if e.Kind == EventPhase && e.Phase == "collect" {
+			sawCollect = true
+		}
+		if e.Kind == EventFileDone && e.Path == ".zshrc" && e.Err == nil {
+			sawFile = true
+		}
+	}
+	if !sawCollect || !sawFile {
+		t.Errorf("expected collect phase and .zshrc file events, got %+v", events)
+	}
+
+	backups, err := List(ctx, WithConfig(cfg))
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(backups) != 1 || backups[0].Archive != result.Archive {
+		t.Fatalf("unexpected backups: %+v", backups)
+	}
+
+	verified, err := Verify(ctx, result.Archive, WithConfig(cfg))
+	if err != nil {
+		t.Fatalf("Verify() error: %v", err)
+	}
+	if !verified.Success || verified.Files != 1 {
+		t.Errorf("unexpected verify result: %+v", verified)
+	}
+
+	restored, err := Restore(ctx, "", WithConfig(cfg), WithDryRun())
+	if err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if !restored.Success || restored.Archive != result.Archive {
+		t.Errorf("unexpected restore result: %+v", restored)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := List(ctx, WithConfig(DefaultConfig()))
+	if !errors.Is(err, errs.ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+}
+
+func TestRestoreWithoutBackups(t *testing.T) {
+	t.Parallel()
+
+	cfg := DefaultConfig()
+	cfg.Backup.BackupDir = t.TempDir()
+
+	_, err := Restore(context.Background(), "", WithConfig(cfg))
+	if errs.Code(err) != errs.CodeArchiveNotFound {
+		t.Errorf("expected %s, got %v", errs.CodeArchiveNotFound, err)
+	}
+}
+
+func TestCancelWhileRunning(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := os.WriteFile(filepath.Join(home, ".zshrc"), []byte("export EDITOR=vim\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Backup.BackupDir = filepath.Join(home, "backups")
+	cfg.Backup.Encryption = "none"
+	cfg.Items = []string{".zshrc"}
+	cfg.Sensitive = nil
+
+	// cancelAt returns a context canceled when the phase starts
+	cancelAt := func(phase string) (context.Context, Option) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		return ctx, WithEventHandler(func(e Event) {
+			if e.Kind == EventPhase && e.Phase == phase {
+				cancel()
+			}
+		})
+	}
+
+	ctx, handler := cancelAt("archive")
+	result, err := Backup(ctx, WithConfig(cfg), handler)
+	if err != nil || result.Success || result.ErrorCode != errs.CodeCanceled {
+		t.Fatalf("canceled Backup() = %+v, %v; want error code %s", result, err, errs.CodeCanceled)
+	}
+	if backups, _ := List(context.Background(), WithConfig(cfg)); len(backups) != 0 {
+		t.Errorf("canceled backup left archives: %+v", backups)
+	}
+
+	result, err = Backup(context.Background(), WithConfig(cfg))
+	if err != nil || !result.Success {
+		t.Fatalf("Backup() = %+v, %v", result, err)
+	}
+
+	target := filepath.Join(t.TempDir(), "restored")
+	ctx, handler = cancelAt("extract")
+	restored, err := Restore(ctx, result.Archive, WithConfig(cfg), handler, WithTarget(target), WithoutSafetyBackup())
+	if err != nil || restored.Success || restored.ErrorCode != errs.CodeCanceled {
+		t.Fatalf("canceled Restore() = %+v, %v; want error code %s", restored, err, errs.CodeCanceled)
+	}
+	if _, err = os.Stat(filepath.Join(target, ".zshrc")); err == nil {
+		t.Error("canceled restore wrote .zshrc")
+	}
+}
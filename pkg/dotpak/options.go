@@ -0,0 +1,249 @@
+package dotpak
+
+import "time"
+
+// EventKind classifies an Event.
+type EventKind string
+
+const (
+	EventInfo        EventKind = "info"
+	EventDetail      EventKind = "detail"
+	EventWarning     EventKind = "warning"
+	EventError       EventKind = "error"
+	EventSuccess     EventKind = "success"
+	EventPhase       EventKind = "phase"
+	EventFileStarted EventKind = "file_started"
+	EventFileDone    EventKind = "file_done"
+	EventBytes       EventKind = "bytes"
+)
+
+// Event is a progress or status notification emitted while an operation runs.
+type Event struct {
+	Kind EventKind
+	// Phase names the stage that started, set for EventPhase
+	// (collect, archive, packages, cleanup, decrypt, safety_backup, extract, verify).
+	Phase string
+	// Path, Current, and Total are set for file events. Total is zero when
+	// the number of files is not known in advance.
+	Path    string
+	Current int
+	Total   int
+	// Size and Err are set for EventFileDone; Err is non-nil if the file failed.
+	Size int64
+	Err  error
+	// Bytes, TotalBytes, Rate (bytes per second), and ETA are set for
+	// EventBytes while an archive is written. ETA is zero until known.
+	Bytes      int64
+	TotalBytes int64
+	Rate       int64
+	ETA        time.Duration
+	// Message is human-readable text with surrounding whitespace trimmed.
+	Message string
+}
+
+// EventHandler receives events. Calls are never concurrent, but may come
+// from different goroutines while an operation works in parallel.
+type EventHandler func(Event)
+
+// Option configures an operation.
+type Option func(*settings)
+
+type settings struct {
+	configPath       string
+	profile          string
+	cfg              *Config
+	onEvent          EventHandler
+	verbose          bool
+	dryRun           bool
+	wait             bool
+	encryption       string
+	recipientsFile   string
+	gpgRecipient     string
+	noSecrets        bool
+	materialize      bool
+	skipPlaceholders bool
+	nixSymlinks      string
+	shellSnapshot    bool
+	incremental      bool
+	profileIO        bool
+	jobs             int
+	categories       []string
+	files            []string
+	force            bool
+	noSafetyBackup   bool
+	skipIntegrity    bool
+	noPostRestore    bool
+	fsync            string
+	transactional    bool
+	noRewrite        bool
+	target           string
+	forcePartial     bool
+}
+
+// WithConfigFile loads configuration from path instead of the default location.
+func WithConfigFile(path string) Option {
+	return func(s *settings) { s.configPath = path }
+}
+
+// WithProfile applies the named config profile.
+func WithProfile(name string) Option {
+	return func(s *settings) { s.profile = name }
+}
+
+// WithConfig uses cfg as is, skipping config file loading.
+func WithConfig(cfg *Config) Option {
+	return func(s *settings) { s.cfg = cfg }
+}
+
+// WithEventHandler delivers progress and status messages to h.
+// Without it, messages are discarded.
+func WithEventHandler(h EventHandler) Option {
+	return func(s *settings) { s.onEvent = h }
+}
+
+// WithVerbose also emits EventDetail messages.
+func WithVerbose() Option {
+	return func(s *settings) { s.verbose = true }
+}
+
+// WithDryRun reports what would happen without writing anything.
+func WithDryRun() Option {
+	return func(s *settings) { s.dryRun = true }
+}
+
+// WithWait makes Backup and Restore wait, until the context is done, for
+// another dotpak run that uses the backup directory instead of failing with
+// ErrorCode "locked".
+func WithWait() Option {
+	return func(s *settings) { s.wait = true }
+}
+
+// WithEncryption overrides the configured encryption method ("age", "gpg", "aes", or "none").
+func WithEncryption(method string) Option {
+	return func(s *settings) { s.encryption = method }
+}
+
+// WithAgeRecipients overrides the age recipients file used by Backup.
+func WithAgeRecipients(path string) Option {
+	return func(s *settings) { s.recipientsFile = path }
+}
+
+// WithGPGRecipient overrides the GPG recipient used by Backup.
+func WithGPGRecipient(recipient string) Option {
+	return func(s *settings) { s.gpgRecipient = recipient }
+}
+
+// WithoutSecrets excludes sensitive items from Backup.
+func WithoutSecrets() Option {
+	return func(s *settings) { s.noSecrets = true }
+}
+
+// WithMaterializePlaceholders makes Backup download cloud placeholder files
+// (iCloud/OneDrive files not stored locally) before archiving them.
+func WithMaterializePlaceholders() Option {
+	return func(s *settings) { s.materialize = true }
+}
+
+// WithoutPlaceholders makes Backup skip cloud placeholder files even if the
+// config materializes them.
+func WithoutPlaceholders() Option {
+	return func(s *settings) { s.skipPlaceholders = true }
+}
+
+// WithNixSymlinks sets what Backup does with symlinks into the Nix store:
+// "link", "skip", or "dereference", overriding backup.nix_symlinks.
+func WithNixSymlinks(mode string) Option {
+	return func(s *settings) { s.nixSymlinks = mode }
+}
+
+// WithShellSnapshot makes Backup save the interactive shell's aliases,
+// functions, and environment (secrets redacted) next to the archive.
+func WithShellSnapshot() Option {
+	return func(s *settings) { s.shellSnapshot = true }
+}
+
+// WithIncremental makes Backup archive only files whose content changed
+// since the previous backup. Restore reassembles the full state from the
+// chain of archives.
+func WithIncremental() Option {
+	return func(s *settings) { s.incremental = true }
+}
+
+// WithIOProfile makes Backup report the time, file count, and bytes read and
+// written of each phase in BackupResult.IOProfile.
+func WithIOProfile() Option {
+	return func(s *settings) { s.profileIO = true }
+}
+
+// WithJobs sets how many backup items Backup collects in parallel
+// (default 4).
+func WithJobs(n int) Option {
+	return func(s *settings) { s.jobs = n }
+}
+
+// WithCategories limits Restore to the given categories (shell, git, ssh, ...,
+// or one defined under [categories] in the configuration).
+func WithCategories(categories ...string) Option {
+	return func(s *settings) { s.categories = categories }
+}
+
+// WithFiles limits Restore to files matching the given globs, relative to the
+// home directory. "**" matches any number of directories, and a directory
+// pattern selects everything below it.
+func WithFiles(patterns ...string) Option {
+	return func(s *settings) { s.files = patterns }
+}
+
+// WithForce makes Restore overwrite files without asking.
+func WithForce() Option {
+	return func(s *settings) { s.force = true }
+}
+
+// WithoutSafetyBackup skips the pre-restore safety backup.
+func WithoutSafetyBackup() Option {
+	return func(s *settings) { s.noSafetyBackup = true }
+}
+
+// WithoutIntegrityCheck makes Restore extract archives whose integrity HMAC
+// is missing or cannot be verified.
+func WithoutIntegrityCheck() Option {
+	return func(s *settings) { s.skipIntegrity = true }
+}
+
+// WithoutPostRestore makes Restore skip the post_restore commands of
+// [[item]] entries.
+func WithoutPostRestore() Option {
+	return func(s *settings) { s.noPostRestore = true }
+}
+
+// WithFsync sets when Restore flushes restored files to disk: "none" (the
+// default), "per-file", or "end".
+func WithFsync(policy string) Option {
+	return func(s *settings) { s.fsync = policy }
+}
+
+// WithTransaction makes Restore extract into a staging directory and move
+// the files into place only if every entry was extracted. If moving fails
+// part way, the files already moved are rolled back.
+func WithTransaction() Option {
+	return func(s *settings) { s.transactional = true }
+}
+
+// WithoutRewrite makes Restore write config files unchanged instead of
+// replacing the home directory of the backup and the [rewrite] map in them.
+func WithoutRewrite() Option {
+	return func(s *settings) { s.noRewrite = true }
+}
+
+// WithTarget makes Restore extract into dir, created if needed, instead of
+// the home directory. Paths are checked against dir, and post_restore
+// commands are not run.
+func WithTarget(dir string) Option {
+	return func(s *settings) { s.target = dir }
+}
+
+// WithForcePartial makes Restore restore an archive that needs features
+// this release does not support, leaving out the files that need them.
+func WithForcePartial() Option {
+	return func(s *settings) { s.forcePartial = true }
+}
